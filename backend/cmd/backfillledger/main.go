@@ -0,0 +1,50 @@
+// Command backfillledger books the amounts already in every job's stage
+// data in the job ledger, and debits customer deposits for them. It is safe
+// to run again: entries kept from stage columns, and the debits that follow
+// them, are updated, not duplicated.
+//
+//	go run ./cmd/backfillledger [-config file]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/database"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+)
+
+func main() {
+	// Load configuration; the backfill only needs the database settings
+	cfg, _, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ledgerRepo, pipelineRepo := repository.NewLedgerRepository(db.DB), repository.NewPipelineRepository(db.DB)
+	depositService := services.NewDepositService(repository.NewDepositRepository(db.DB), ledgerRepo, pipelineRepo)
+	ledgerService := services.NewLedgerService(ledgerRepo, pipelineRepo, depositService)
+	n, err := ledgerService.Backfill(context.Background())
+	if err != nil {
+		log.Fatalf("Backfill failed after %d jobs: %v", n, err)
+	}
+	fmt.Printf("Synced the ledger of %d jobs\n", n)
+}
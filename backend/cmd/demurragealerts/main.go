@@ -0,0 +1,48 @@
+// Command demurragealerts emails the jobs whose containers' free time ends
+// within demurrage.alert_days, each free time once. Run it daily, e.g. from
+// cron.
+//
+//	go run ./cmd/demurragealerts [-config file]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/database"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+)
+
+func main() {
+	cfg, _, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	demurrageService := services.NewDemurrageService(repository.NewDemurrageRepository(db.DB),
+		services.NewEmailService(cfg.SMTP), cfg.Demurrage, cfg.Notifications.AdminEmail)
+	n, err := demurrageService.SendAlerts(context.Background(), time.Now())
+	if err != nil {
+		log.Fatalf("Sent %d alerts, then: %v", n, err)
+	}
+	fmt.Printf("Sent %d demurrage alerts\n", n)
+}
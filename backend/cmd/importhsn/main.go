@@ -0,0 +1,58 @@
+// Command importhsn loads an HSN tariff CSV into the hsn_codes table.
+//
+//	go run ./cmd/importhsn [-config file] tariff.csv
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/database"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+)
+
+func main() {
+	// Load configuration; the import only needs the database settings
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if len(opts.Args) != 1 {
+		log.Fatal("usage: importhsn [flags] tariff.csv")
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(opts.Args[0])
+	if err != nil {
+		log.Fatal("Error opening CSV: ", err)
+	}
+	defer f.Close()
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	hsnService := services.NewHSNService(repository.NewHSNRepository(db.DB))
+	result, err := hsnService.Import(context.Background(), f)
+	if err != nil {
+		log.Fatal("Import failed: ", err)
+	}
+
+	for _, e := range result.Errors {
+		fmt.Printf("line %d: %s\n", e.Line, e.Message)
+	}
+	fmt.Printf("Imported %d HSN codes, skipped %d rows\n", result.Imported, result.Skipped)
+}
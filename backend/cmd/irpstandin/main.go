@@ -0,0 +1,48 @@
+// Command irpstandin answers for the Invoice Registration Portal when it
+// is not available, e.g. in development. It reads an e-invoice JSON
+// downloaded from the CRM and writes a response in the IRP's format that
+// can be imported against the bill. The response is unsigned and is not a
+// registered e-invoice.
+//
+//	go run ./cmd/irpstandin [-o response.json] einvoice.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"maydiv-crm/internal/einvoice"
+)
+
+func main() {
+	out := flag.String("o", "", "write the response to this file instead of stdout")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: irpstandin [-o response.json] einvoice.json")
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal("Error reading e-invoice: ", err)
+	}
+	var inv einvoice.Invoice
+	if err := json.Unmarshal(data, &inv); err != nil {
+		log.Fatal("Error reading e-invoice: ", err)
+	}
+
+	resp, err := einvoice.StandIn(&inv, time.Now())
+	if err != nil {
+		log.Fatal("Invoice rejected: ", err)
+	}
+	if *out == "" {
+		fmt.Println(string(resp))
+		return
+	}
+	if err := os.WriteFile(*out, resp, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
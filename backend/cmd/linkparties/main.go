@@ -0,0 +1,81 @@
+// Command linkparties links the consignees and shippers of existing jobs to
+// the party directory, creating parties for names that match none, and
+// writes a CSV review report of every name it saw to the given file, or to
+// stdout. Names matched by GSTIN, IEC or a similar spelling are added to the
+// party's aliases and should be checked.
+//
+//	go run ./cmd/linkparties [-config file] [review.csv]
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/database"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+)
+
+func main() {
+	// Load configuration; linking only needs the database settings
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if len(opts.Args) > 1 {
+		log.Fatal("usage: linkparties [flags] [review.csv]")
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if len(opts.Args) == 1 {
+		f, err := os.Create(opts.Args[0])
+		if err != nil {
+			log.Fatal("Error creating review report: ", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	receivableService := services.NewReceivableService(repository.NewReceivableRepository(db.DB), repository.NewPipelineRepository(db.DB), cfg.Billing)
+	partyService := services.NewPartyService(repository.NewPartyRepository(db.DB), receivableService)
+	report, err := partyService.Migrate(context.Background())
+	if err != nil {
+		log.Fatal("Linking parties failed: ", err)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"field", "value", "jobs", "gstin", "iec", "party_id", "party", "match"})
+	for _, row := range report.Rows {
+		partyID := ""
+		if row.PartyID != 0 {
+			partyID = strconv.Itoa(row.PartyID)
+		}
+		w.Write([]string{row.Field, row.Value, strconv.Itoa(row.Jobs), row.GSTIN, row.IEC, partyID, row.Party, row.Match})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal("Error writing review report: ", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Linked %d jobs, created %d parties\n", report.LinkedJobs, report.Created)
+}
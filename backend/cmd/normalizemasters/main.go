@@ -0,0 +1,80 @@
+// Command normalizemasters links the free-text ports, countries, shipping
+// lines, forwarders and custodians of existing jobs to master data, and
+// writes a CSV review report of every value it saw to the given file, or to
+// stdout. Values matching no master keep their text and are listed with the
+// closest master, to be added as an alias before running it again.
+//
+//	go run ./cmd/normalizemasters [-config file] [review.csv]
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/database"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+)
+
+func main() {
+	// Load configuration; normalization only needs the database settings
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	if len(opts.Args) > 1 {
+		log.Fatal("usage: normalizemasters [flags] [review.csv]")
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if len(opts.Args) == 1 {
+		f, err := os.Create(opts.Args[0])
+		if err != nil {
+			log.Fatal("Error creating review report: ", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	masterService := services.NewMasterService(repository.NewMasterRepository(db.DB))
+	report, err := masterService.Normalize(context.Background())
+	if err != nil {
+		log.Fatal("Normalization failed: ", err)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"field", "value", "jobs", "master_id", "master", "suggestion"})
+	for _, row := range report.Rows {
+		masterID := ""
+		if row.MasterID != nil {
+			masterID = strconv.Itoa(*row.MasterID)
+		}
+		w.Write([]string{row.Field, row.Value, strconv.Itoa(row.Jobs), masterID, row.Master, row.Suggestion})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal("Error writing review report: ", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Linked %d values on %d jobs, %d jobs left to review\n", report.LinkedValues, report.LinkedJobs, report.UnmatchedJobs)
+}
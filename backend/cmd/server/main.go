@@ -1,84 +1,130 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+	"maydiv-crm/internal/config"
 	"maydiv-crm/internal/database"
 	"maydiv-crm/internal/handlers"
+	"maydiv-crm/internal/logging"
+	"maydiv-crm/internal/metrics"
+	"maydiv-crm/internal/middleware"
 	"maydiv-crm/internal/repository"
 	"maydiv-crm/internal/services"
 	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load environment variables
-	// Try multiple possible paths for .env file
-	envPaths := []string{
-		".env",                    // Current directory
-		"../.env",                 // Parent directory
-		"../../.env",              // Two levels up
-		"../../../.env",           // Three levels up
-		"../../../../.env",        // Four levels up
+	// Load configuration from file, environment and flags
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	
-	var envLoaded bool
-	for _, path := range envPaths {
-		if err := godotenv.Load(path); err == nil {
-			log.Printf("Loaded .env file from: %s", path)
-			envLoaded = true
-			break
-		}
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
 	}
-	
-	if !envLoaded {
-		log.Println("No .env file found, using system environment variables")
+
+	if opts.PrintConfig {
+		fmt.Print(cfg)
+		return
 	}
+
+	logger, err := logging.New(cfg.Log, os.Stdout)
+	if err != nil {
+		log.Fatal("Failed to configure logging: ", err)
+	}
+	slog.SetDefault(logger)
+
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.Info("Effective configuration", "config", cfg.Redacted())
 	
 	// Initialize database
-	db, err := database.NewConnection()
+	db, err := database.NewConnection(cfg.Database)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer db.Close()
 	
 	// Run migrations and seed data
 	if err := db.Migrate(); err != nil {
-		log.Fatal("Failed to run migrations:", err)
+		fatal("Failed to run migrations", err)
 	}
 	
 	if err := db.Seed(); err != nil {
-		log.Fatal("Failed to seed database:", err)
+		fatal("Failed to seed database", err)
 	}
 	
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db.DB)
 	taskRepo := repository.NewTaskRepository(db.DB)
 	pipelineRepo := repository.NewPipelineRepository(db.DB)
+	hsnRepo := repository.NewHSNRepository(db.DB)
+	dutyRepo := repository.NewDutyRepository(db.DB)
+	filingRepo := repository.NewFilingRepository(db.DB)
+	igmRepo := repository.NewIGMRepository(db.DB)
+	einvoiceRepo := repository.NewEInvoiceRepository(db.DB)
+	settingsRepo := repository.NewSettingsRepository(db.DB)
+	ledgerRepo := repository.NewLedgerRepository(db.DB)
+	receivableRepo := repository.NewReceivableRepository(db.DB)
+	depositRepo := repository.NewDepositRepository(db.DB)
+	billNoteRepo := repository.NewBillNoteRepository(db.DB)
+	demurrageRepo := repository.NewDemurrageRepository(db.DB)
+	containerRepo := repository.NewContainerRepository(db.DB)
+	masterRepo := repository.NewMasterRepository(db.DB)
+	partyRepo := repository.NewPartyRepository(db.DB)
+	orgRepo := repository.NewOrganizationRepository(db.DB)
+	metrics.RegisterDB(db.DB)
+	metrics.RegisterJobStats(pipelineRepo)
 	
 	// Initialize services
 	authService := services.NewAuthService(userRepo)
-	notificationService := services.NewNotificationService(db.DB)
+	emailService := services.NewEmailService(cfg.SMTP)
+	notificationService := services.NewNotificationService(db.DB, emailService, cfg.Notifications.AdminEmail)
+	hsnService := services.NewHSNService(hsnRepo)
+	dutyService := services.NewDutyService(hsnRepo, dutyRepo)
+	filingService := services.NewFilingService(filingRepo, masterRepo)
+	boeService := services.NewBillOfEntryService(pipelineRepo, dutyRepo, masterRepo, cfg.Company, cfg.Uploads.Dir)
+	igmService := services.NewIGMService(igmRepo, pipelineRepo)
+	einvoiceService := services.NewEInvoiceService(pipelineRepo, einvoiceRepo, cfg.Company)
+	billingService := services.NewBillingService(pipelineRepo, cfg.Company)
+	depositService := services.NewDepositService(depositRepo, ledgerRepo, pipelineRepo)
+	ledgerService := services.NewLedgerService(ledgerRepo, pipelineRepo, depositService)
+	receivableService := services.NewReceivableService(receivableRepo, pipelineRepo, cfg.Billing)
+	invoiceService := services.NewInvoiceService(pipelineRepo, einvoiceRepo, settingsRepo, einvoiceService, cfg.Company, cfg.Uploads.Dir)
+	demurrageService := services.NewDemurrageService(demurrageRepo, emailService, cfg.Demurrage, cfg.Notifications.AdminEmail)
+	billNoteService := services.NewBillNoteService(billNoteRepo, pipelineRepo, receivableRepo, invoiceService, cfg.Company, cfg.Billing, cfg.Uploads.Dir)
+	masterService := services.NewMasterService(masterRepo)
+	partyService := services.NewPartyService(partyRepo, receivableService)
+	orgService := services.NewOrganizationService(orgRepo, userRepo, notificationService, cfg.Server.AppURL)
 	
 	// Initialize session store
-	sessionKey := os.Getenv("SESSION_KEY")
+	sessionKey := cfg.Session.Key
 	if sessionKey == "" {
 		sessionKey = "default-session-key-change-in-production"
-		log.Println("Warning: Using default session key. Set SESSION_KEY in .env for production.")
+		slog.Warn("Using default session key. Set SESSION_KEY in .env for production.")
 	}
 	sessionStore := sessions.NewCookieStore([]byte(sessionKey))
 	
 	// Configure session store for better security and compatibility
 	sessionStore.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   86400 * 7, // 7 days
+		MaxAge:   cfg.Session.MaxAge,
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   cfg.Session.Secure, // Enable in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
 	}
 	
@@ -86,11 +132,89 @@ func main() {
 	authHandler := handlers.NewAuthHandler(authService, sessionStore)
 	userHandler := handlers.NewUserHandler(userRepo, sessionStore)
 	taskHandler := handlers.NewTaskHandler(taskRepo, sessionStore)
-	pipelineHandler := handlers.NewPipelineHandler(pipelineRepo, userRepo, sessionStore, notificationService)
+	pipelineHandler := handlers.NewPipelineHandler(pipelineRepo, userRepo, sessionStore, notificationService, filingService, billingService, ledgerService, depositService, partyService, cfg.Uploads)
+	healthHandler := handlers.NewHealthHandler(db.DB, cfg.Uploads.Dir, emailService, cfg.Health.CheckSMTP)
+	validateHandler := handlers.NewValidateHandler()
+	hsnHandler := handlers.NewHSNHandler(hsnRepo, hsnService, pipelineRepo, userRepo, sessionStore, cfg.Uploads)
+	dutyHandler := handlers.NewDutyHandler(dutyRepo, dutyService, pipelineRepo, userRepo, sessionStore)
+	filingHandler := handlers.NewFilingHandler(filingRepo, filingService, pipelineRepo, userRepo, sessionStore)
+	boeHandler := handlers.NewBillOfEntryHandler(boeService, pipelineRepo, userRepo, sessionStore)
+	igmHandler := handlers.NewIGMHandler(igmService, pipelineRepo, userRepo, sessionStore, cfg.Uploads)
+	einvoiceHandler := handlers.NewEInvoiceHandler(einvoiceService, pipelineRepo, userRepo, sessionStore, cfg.Uploads)
+	invoiceHandler := handlers.NewInvoiceHandler(invoiceService, pipelineRepo, userRepo, sessionStore)
+	demurrageHandler := handlers.NewDemurrageHandler(demurrageService, pipelineRepo, userRepo, sessionStore)
+	containerHandler := handlers.NewContainerHandler(containerRepo, pipelineRepo, userRepo, sessionStore)
+	masterHandler := handlers.NewMasterHandler(masterService, pipelineRepo, userRepo, sessionStore)
+	partyHandler := handlers.NewPartyHandler(partyService, pipelineRepo, userRepo, sessionStore)
+	orgHandler := handlers.NewOrganizationHandler(orgService, pipelineRepo, userRepo, sessionStore)
+	billNoteHandler := handlers.NewBillNoteHandler(billNoteService, ledgerService, pipelineRepo, userRepo, sessionStore)
+	ledgerHandler := handlers.NewLedgerHandler(ledgerService, pipelineRepo, userRepo, sessionStore)
+	receivableHandler := handlers.NewReceivableHandler(receivableService, pipelineRepo, userRepo, sessionStore)
+	depositHandler := handlers.NewDepositHandler(depositService, pipelineRepo, userRepo, sessionStore)
 	
 	// Setup routes
 	mux := http.NewServeMux()
 	
+	// Health probes
+	mux.HandleFunc("/healthz", healthHandler.Healthz)
+	mux.HandleFunc("/readyz", healthHandler.Readyz)
+	mux.Handle("/metrics", metrics.Handler())
+	
+	// Standalone field checks
+	mux.HandleFunc("/api/validate/container", validateHandler.HandleContainer)
+	
+	// HSN tariff master
+	mux.HandleFunc("/api/hsn/search", hsnHandler.HandleSearch)
+	mux.HandleFunc("/api/hsn/suggest", hsnHandler.HandleSuggest)
+	mux.HandleFunc("/api/hsn/import", hsnHandler.HandleImport)
+	mux.HandleFunc("/api/exchange-rates", dutyHandler.HandleExchangeRates)
+	mux.HandleFunc("/api/filing-rules", filingHandler.HandleRules)
+	mux.HandleFunc("/api/filing-rules/", filingHandler.HandleRule)
+
+	// IGM manifests
+	mux.HandleFunc("/api/igm/import", igmHandler.HandleImport)
+	mux.HandleFunc("/api/igm/drafts", igmHandler.HandleDrafts)
+
+	// Tax invoice template
+	mux.HandleFunc("/api/invoice-template", invoiceHandler.HandleTemplate)
+	mux.HandleFunc("/api/invoice-template/preview", invoiceHandler.HandleTemplate)
+
+	// Reports
+	mux.HandleFunc("/api/reports/profitability", ledgerHandler.HandleProfitability)
+
+	// Receivables
+	mux.HandleFunc("/api/receivables", receivableHandler.HandleReceivables)
+	mux.HandleFunc("/api/receivables/aging", receivableHandler.HandleAging)
+	mux.HandleFunc("/api/receivables/reminders", receivableHandler.HandleReminders)
+	mux.HandleFunc("/api/advances", receivableHandler.HandleAdvances)
+
+	// Customer deposit accounts
+	mux.HandleFunc("/api/deposits", depositHandler.HandleAccounts)
+	mux.HandleFunc("/api/deposits/", depositHandler.HandleAccount)
+
+	// Demurrage and detention
+	mux.HandleFunc("/api/demurrage", demurrageHandler.HandleDashboard)
+	mux.HandleFunc("/api/demurrage/tariffs", demurrageHandler.HandleTariffs)
+	mux.HandleFunc("/api/demurrage/tariffs/", demurrageHandler.HandleTariff)
+
+	// Master data
+	mux.HandleFunc("/api/masters/", masterHandler.HandleMasters)
+
+	// Party directory
+	mux.HandleFunc("/api/parties", partyHandler.HandleParties)
+	mux.HandleFunc("/api/parties/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/members") || strings.Contains(r.URL.Path, "/invitations") {
+			orgHandler.HandlePartyOrganization(w, r)
+		} else {
+			partyHandler.HandleParty(w, r)
+		}
+	})
+	
+	// Customer organizations
+	mux.HandleFunc("/api/organization", orgHandler.HandleOrganization)
+	mux.HandleFunc("/api/organization/", orgHandler.HandleOrganization)
+	mux.HandleFunc("/api/invitations/accept", orgHandler.HandleAccept)
+	
 	// Auth routes
 	mux.HandleFunc("/api/login", authHandler.Login)
 	mux.HandleFunc("/api/logout", authHandler.Logout)
@@ -101,20 +225,23 @@ func main() {
 		
 		session, err := sessionStore.Get(r, "session")
 		if err != nil {
-			http.Error(w, "Session error", http.StatusUnauthorized)
+			handlers.WriteError(w, r, services.ErrUnauthorized)
 			return
 		}
 		
 		userID, ok := session.Values["user_id"].(int)
 		if !ok {
-			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			handlers.WriteError(w, r, services.ErrUnauthorized)
 			return
 		}
 		
 		// Get user details
 		user, err := userRepo.GetByID(userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			err = services.ErrUserNotFound
+		}
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		
@@ -125,6 +252,11 @@ func main() {
 			"is_admin": user.IsAdmin,
 			"role": user.Role,
 		}
+		if user.PartyID != nil {
+			response["party_id"] = *user.PartyID
+			response["org_admin"] = user.OrgAdmin
+			response["stages"] = user.Stages
+		}
 		
 		json.NewEncoder(w).Encode(response)
 	})
@@ -140,6 +272,7 @@ func main() {
 	// New Pipeline routes
 	mux.HandleFunc("/api/pipeline/jobs", pipelineHandler.HandleJobs)
 	mux.HandleFunc("/api/pipeline/myjobs", pipelineHandler.HandleMyJobs)
+	mux.HandleFunc("/api/pipeline/party-identifiers", pipelineHandler.HandlePartyIdentifiers)
 	mux.HandleFunc("/api/debug", pipelineHandler.HandleDebug)
 	
 	// File upload routes
@@ -161,7 +294,7 @@ func main() {
 		// Get all stage1 data
 		rows, err := db.DB.Query("SELECT * FROM stage1_data")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -203,7 +336,7 @@ func main() {
 		// Get all stage2 data
 		rows, err := db.DB.Query("SELECT * FROM stage2_data")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -245,7 +378,7 @@ func main() {
 		// Get all stage3 data
 		rows, err := db.DB.Query("SELECT * FROM stage3_data")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -287,7 +420,7 @@ func main() {
 		// Get all stage4 data
 		rows, err := db.DB.Query("SELECT * FROM stage4_data")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -337,7 +470,7 @@ func main() {
 			ORDER BY pj.id
 		`)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			handlers.WriteError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -374,7 +507,7 @@ func main() {
 	// Test email endpoint
 	mux.HandleFunc("/api/test-email", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			handlers.WriteError(w, r, handlers.ErrMethodNotAllowed)
 			return
 		}
 		
@@ -390,10 +523,7 @@ func main() {
 		}
 		
 		// Send a test email
-		adminEmail := os.Getenv("ADMIN_EMAIL")
-		if adminEmail == "" {
-			adminEmail = "admin@maydiv.com"
-		}
+		adminEmail := cfg.Notifications.AdminEmail
 		
 		emailData := services.StageCompletionEmail{
 			JobNo:       "TEST-001",
@@ -406,7 +536,7 @@ func main() {
 			AdminEmail:  adminEmail,
 		}
 		
-		if err := notificationService.EmailService.SendStageCompletionEmail(emailData); err != nil {
+		if err := notificationService.EmailService.SendStageCompletionEmail(r.Context(), emailData); err != nil {
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"success": false,
 				"error":   err.Error(),
@@ -422,35 +552,121 @@ func main() {
 	
 	// Handle all pipeline job routes with ID
 	mux.HandleFunc("/api/pipeline/jobs/", func(w http.ResponseWriter, r *http.Request) {
-		// Route to specific job handlers based on path
+		// Route to specific job handlers based on path, labelling each for
+		// metrics and access logs
 		path := r.URL.Path
-		fmt.Printf("Pipeline job route accessed: %s\n", path)
 		
-		if strings.Contains(path, "/stage2") {
+		if strings.Contains(path, "/duty") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/duty")
+			dutyHandler.HandleJobDuty(w, r)
+		} else if strings.Contains(path, "/filings") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/filings")
+			filingHandler.HandleJobFilings(w, r)
+		} else if strings.Contains(path, "/bill-of-entry") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/bill-of-entry")
+			boeHandler.HandleBillOfEntry(w, r)
+		} else if strings.Contains(path, "/einvoice") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/einvoice")
+			einvoiceHandler.HandleEInvoice(w, r)
+		} else if strings.Contains(path, "/payments") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/payments")
+			receivableHandler.HandleJobPayments(w, r)
+		} else if strings.Contains(path, "/deposit") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/deposit")
+			depositHandler.HandleJobDeposit(w, r)
+		} else if strings.Contains(path, "/demurrage") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/demurrage")
+			demurrageHandler.HandleJobDemurrage(w, r)
+		} else if strings.Contains(path, "/containers") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/containers")
+			containerHandler.HandleContainers(w, r)
+		} else if strings.Contains(path, "/ledger") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/ledger")
+			ledgerHandler.HandleJobLedger(w, r)
+		} else if strings.Contains(path, "/bill-notes") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/bill-notes")
+			billNoteHandler.HandleBillNotes(w, r)
+		} else if strings.Contains(path, "/invoice") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/invoice")
+			invoiceHandler.HandleInvoice(w, r)
+		} else if strings.Contains(path, "/stage2") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/stage2")
 			pipelineHandler.HandleStage2Update(w, r)
 		} else if strings.Contains(path, "/stage3") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/stage3")
 			pipelineHandler.HandleStage3Update(w, r)
 		} else if strings.Contains(path, "/stage4") {
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}/stage4")
 			pipelineHandler.HandleStage4Update(w, r)
 		} else {
 			// Default to job details
+			metrics.SetRoute(r, "/api/pipeline/jobs/{id}")
 			pipelineHandler.HandleJobByID(w, r)
 		}
 	})
 	
-	// CORS middleware
-	handler := withCORS(mux)
+	server := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: withMiddleware(cfg.Server.CORSOrigin, mux),
+	}
+	
+	// Stop on Ctrl+C or a deploy's SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server started", "addr", cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+	
+	select {
+	case err := <-serverErr:
+		fatal("Server failed", err)
+	case <-ctx.Done():
+	}
+	stop()
 	
-	log.Println("Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	// Fail readiness first so load balancers stop sending new requests
+	slog.Info("Shutting down")
+	healthHandler.SetShuttingDown()
+	time.Sleep(cfg.Server.DrainDelay)
+	
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	
+	// Stop accepting connections and drain in-flight requests
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error draining HTTP requests", "error", err)
+	}
+	
+	// Requests may have queued notifications right before finishing
+	if err := notificationService.Wait(shutdownCtx); err != nil {
+		slog.Error("Error waiting for notifications", "error", err)
+	}
+	
+	slog.Info("Server stopped")
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// withMiddleware wraps the routes in the middleware every request goes
+// through, request IDs outermost so every log line carries one
+func withMiddleware(corsOrigin string, mux http.Handler) http.Handler {
+	return middleware.RequestID(middleware.AccessLog(metrics.Middleware(withCORS(corsOrigin, mux))))
 }
 
 // CORS middleware
-func withCORS(next http.Handler) http.Handler {
+func withCORS(origin string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		
 		if r.Method == "OPTIONS" {
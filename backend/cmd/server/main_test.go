@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maydiv-crm/internal/handlers"
+	"maydiv-crm/internal/middleware"
+)
+
+func TestMiddlewareRequestIDs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs/1", func(w http.ResponseWriter, r *http.Request) {
+		handlers.WriteError(w, r, handlers.NotFound("Job not found"))
+	})
+	handler := withMiddleware("http://localhost:3000", mux)
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "well formed", incoming: "lb-7f3a.42_x", reused: true},
+		{name: "none"},
+		{name: "spaces", incoming: "abc def"},
+		{name: "log injection", incoming: "abc\" level=ERROR msg=\"forged"},
+		{name: "too long", incoming: "a123456789b123456789c123456789d123456789e123456789f123456789g1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/jobs/1", nil)
+			if tt.incoming != "" {
+				r.Header.Set(middleware.RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			id := rec.Header().Get(middleware.RequestIDHeader)
+			if tt.reused && id != tt.incoming || !tt.reused && (id == "" || id == tt.incoming) {
+				t.Errorf("%s = %q for incoming %q, reused %v", middleware.RequestIDHeader, id, tt.incoming, tt.reused)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			var envelope struct {
+				Error struct {
+					Code      string `json:"code"`
+					Message   string `json:"message"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Error.Code != handlers.CodeNotFound || envelope.Error.Message != "Job not found" {
+				t.Errorf("error = %+v, want not_found", envelope.Error)
+			}
+			if envelope.Error.RequestID != id {
+				t.Errorf("envelope request_id = %q, header %q", envelope.Error.RequestID, id)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePreflight(t *testing.T) {
+	handler := withMiddleware("http://localhost:3000", http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/api/jobs", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("preflight status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Header().Get(middleware.RequestIDHeader) == "" {
+		t.Error("preflight has no request ID")
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != middleware.RequestIDHeader {
+		t.Errorf("exposed headers = %q, want %s", got, middleware.RequestIDHeader)
+	}
+}
@@ -2,32 +2,25 @@ package main
 
 import (
     "database/sql"
-    "fmt"
     "log"
     "os"
     "path/filepath"
-    "github.com/joho/godotenv"
+    "maydiv-crm/internal/config"
     _ "github.com/go-sql-driver/mysql"
 )
 
 func main() {
-    // Load environment variables from parent directory
-    err := godotenv.Load("../.env")
+    // Load configuration; setup only needs the database settings
+    cfg, _, err := config.Load(os.Args[1:])
     if err != nil {
-        log.Println("No .env file found, using system environment variables")
+        log.Fatal("Error loading configuration: ", err)
+    }
+    if err := cfg.Database.Validate(); err != nil {
+        log.Fatal(err)
     }
 
-    // Connect to database
-    dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-        os.Getenv("DB_USER"),
-        os.Getenv("DB_PASS"),
-        os.Getenv("DB_HOST"),
-        os.Getenv("DB_PORT"),
-        os.Getenv("DB_NAME"),
-    )
-    
     log.Println("Connecting to database...")
-    db, err := sql.Open("mysql", dsn)
+    db, err := sql.Open("mysql", cfg.Database.DSN())
     if err != nil {
         log.Fatal("Error opening database:", err)
     }
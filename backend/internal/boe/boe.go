@@ -0,0 +1,354 @@
+// Package boe writes the Bill of Entry flat file that customs filing
+// software submits to ICEGATE, following the ICES 1.5 message layout.
+//
+// The file is a header record, one table per section and a trailer:
+//
+//	HREC␝ZZ␝<sender>␝ZZ␝<custom house>␝ICES1_5␝P␝␝CACHI01␝<job no>␝<date>␝<time>
+//	<TABLE>BE       one record: importer, broker and shipment totals
+//	<TABLE>IGMS     one record: IGM, inward date and bills of lading
+//	<TABLE>INVOICE  one record: invoice, currency, freight and insurance
+//	<TABLE>ITEM     one record per invoice line: tariff item and value
+//	TREC␝<job no>
+//
+// Each table ends with <END-TABLE>. Fields are separated by the ASCII group
+// separator (shown as ␝), dates are YYYYMMDD and lines end in CRLF.
+package boe
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+const (
+	separator = "\x1d"
+	newline   = "\r\n"
+	dateFmt   = "20060102"
+)
+
+// Sender identifies the customs broker filing the Bill of Entry
+type Sender struct {
+	CHACode   string
+	ICEGATEID string
+}
+
+// Places are the masters a job's stage 1 ports and country of shipment are
+// linked to, nil where a field is not linked
+type Places struct {
+	PortOfDischarge *models.Master
+	PortOfLoading   *models.Master
+	Country         *models.Master
+}
+
+// Input is everything a Bill of Entry is built from. Duty is the saved duty
+// calculation, which supplies the invoice currency, values and items.
+type Input struct {
+	Job    *models.PipelineJobResponse
+	Duty   *models.DutyBreakdown
+	Places Places
+	Sender Sender
+	Now    time.Time
+}
+
+// FileName is the name of the flat file generated for a job
+func FileName(jobNo string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, jobNo) + ".be"
+}
+
+// Generate builds the flat file. When mandatory data is missing or badly
+// formed it returns validation.Errors naming every problem, with fields
+// such as "stage1.local_igm" or "duty.items[0].hsn_code".
+func Generate(in Input) ([]byte, error) {
+	w := &writer{}
+	job := in.Job
+	s1 := job.Stage1
+	if s1 == nil {
+		s1 = &models.Stage1Data{}
+	}
+	s2 := job.Stage2
+	if s2 == nil {
+		s2 = &models.Stage2Data{}
+	}
+
+	customHouse := w.code("stage1.port_of_discharge", customHouseCode(str(s1.PortOfDischarge), in.Places.PortOfDischarge),
+		isCustomHouse, "must be a custom house code such as INNSA1, or a port with one among its aliases")
+	// The filing software's own job number wins when one was recorded
+	jobNo := w.ident("job_no", job.JobNo, 20, true)
+	if edi := str(s1.EDIJobNo); edi != "" {
+		jobNo = w.ident("stage1.edi_job_no", edi, 20, true)
+	}
+	w.required("company.cha_code", in.Sender.CHACode)
+	w.required("company.icegate_id", in.Sender.ICEGATEID)
+
+	w.record("HREC", "ZZ", in.Sender.ICEGATEID, "ZZ", customHouse, "ICES1_5", "P", "", "CACHI01",
+		jobNo, in.Now.Format(dateFmt), in.Now.Format("1504"))
+
+	w.table("BE")
+	w.record(
+		"F",
+		customHouse,
+		jobNo,
+		w.date("stage1.job_date", s1.JobDate, true),
+		"H",
+		w.tradeID("stage1.consignee_iec", str(s1.ConsigneeIEC), tradeid.ValidateIEC),
+		"0",
+		w.text("stage1.consignee", str(s1.Consignee), 50, true),
+		w.tradeID("stage1.consignee_gstin", str(s1.ConsigneeGSTIN), tradeid.ValidateGSTIN),
+		in.Sender.CHACode,
+		w.text("stage1.country_of_shipment", masterCode(str(s1.CountryOfShipment), in.Places.Country), 30, true),
+		w.code("stage1.port_of_loading", masterCode(str(s1.PortOfLoading), in.Places.PortOfLoading), IsLocode,
+			"must be a UN/LOCODE such as CNSHA"),
+		"S",
+		w.count("stage1.packages", s1.Packages),
+		w.weight("stage1.weight", s1.Weight),
+		"KGS",
+		w.text("stage2.documents_type", str(s2.DocumentsType), 20, false),
+	)
+	w.endTable()
+
+	w.table("IGMS")
+	w.record(
+		w.ident("stage1.local_igm", str(s1.LocalIGM), 7, true),
+		w.date("stage1.local_igm_date", s1.LocalIGMDate, true),
+		w.date("stage1.date_of_arrival", s1.DateOfArrival, true),
+		w.ident("stage1.gateway_igm", str(s1.GatewayIGM), 7, false),
+		w.date("stage1.gateway_igm_date", s1.GatewayIGMDate, false),
+		w.ident("stage1.mbl_no", str(s1.MBLNo), 20, true),
+		w.date("stage1.mbl_date", s1.MBLDate, true),
+		w.ident("stage1.hbl_no", str(s1.HBLNo), 20, false),
+		w.date("stage1.hbl_date", s1.HBLDate, false),
+		w.count("stage1.packages", s1.Packages),
+		w.weight("stage1.weight", s1.Weight),
+	)
+	w.endTable()
+
+	duty := in.Duty
+	if duty == nil {
+		w.errs.Add("duty", "save a duty calculation first; it supplies the invoice value and items")
+		duty = &models.DutyBreakdown{}
+	}
+	w.table("INVOICE")
+	w.record(
+		"1",
+		w.ident("stage1.invoice_no", str(s1.InvoiceNo), 40, true),
+		w.date("stage1.invoice_date", s1.InvoiceDate, true),
+		w.text("stage1.shipper", str(s1.Shipper), 50, true),
+		duty.Currency,
+		money(duty.InvoiceValue),
+		money(duty.Freight),
+		"INR",
+		money(duty.Insurance),
+		"INR",
+	)
+	w.endTable()
+
+	if in.Duty != nil && len(duty.Items) == 0 {
+		w.errs.Add("duty.items", "the duty calculation has no items")
+	}
+	w.table("ITEM")
+	for i, item := range duty.Items {
+		field := fmt.Sprintf("duty.items[%d]", i)
+		cth := item.HSNCode
+		if len(cth) != 8 {
+			w.errs.Add(field+".hsn_code", "must be an 8 digit tariff item")
+		}
+		w.record(
+			"1",
+			strconv.Itoa(i+1),
+			cth,
+			w.text(field+".description", item.Description, 120, true),
+			money(item.InvoiceValue),
+			money(item.AssessableValue),
+		)
+	}
+	w.endTable()
+
+	w.record("TREC", jobNo)
+
+	if err := w.errs.Err(); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// writer accumulates records and the problems found while formatting them
+type writer struct {
+	buf  bytes.Buffer
+	errs validation.Errors
+	seen map[string]bool
+}
+
+func (w *writer) record(fields ...string) {
+	w.buf.WriteString(strings.Join(fields, separator))
+	w.buf.WriteString(newline)
+}
+
+func (w *writer) table(name string) {
+	w.buf.WriteString("<TABLE>" + name + newline)
+}
+
+func (w *writer) endTable() {
+	w.buf.WriteString("<END-TABLE>" + newline)
+}
+
+// fail records a problem once per field, as some fields appear in two records
+func (w *writer) fail(field, message string) {
+	if w.seen == nil {
+		w.seen = make(map[string]bool)
+	}
+	if !w.seen[field] {
+		w.seen[field] = true
+		w.errs.Add(field, message)
+	}
+}
+
+func (w *writer) required(field, value string) string {
+	if strings.TrimSpace(value) == "" {
+		w.fail(field, "is required")
+	}
+	return value
+}
+
+// text cleans free text for the file, cutting it to max characters
+func (w *writer) text(field, value string, max int, mandatory bool) string {
+	value = clean(value)
+	if value == "" && mandatory {
+		w.fail(field, "is required")
+	}
+	if r := []rune(value); len(r) > max {
+		value = string(r[:max])
+	}
+	return value
+}
+
+// ident is a reference number, which is never cut: a shortened number
+// would point customs at the wrong document
+func (w *writer) ident(field, value string, max int, mandatory bool) string {
+	value = clean(value)
+	if value == "" && mandatory {
+		w.fail(field, "is required")
+	}
+	if len([]rune(value)) > max {
+		w.fail(field, fmt.Sprintf("must be at most %d characters", max))
+	}
+	return value
+}
+
+func (w *writer) code(field, value string, valid func(string) bool, message string) string {
+	value = tradeid.Normalize(value)
+	if value == "" {
+		w.fail(field, "is required")
+	} else if !valid(value) {
+		w.fail(field, message)
+	}
+	return value
+}
+
+func (w *writer) tradeID(field, value string, validate func(string) error) string {
+	value = tradeid.Normalize(value)
+	if value == "" {
+		w.fail(field, "is required")
+	} else if err := validate(value); err != nil {
+		w.fail(field, err.Error())
+	}
+	return value
+}
+
+func (w *writer) date(field string, t *time.Time, mandatory bool) string {
+	if t == nil || t.IsZero() {
+		if mandatory {
+			w.fail(field, "is required")
+		}
+		return ""
+	}
+	return t.Format(dateFmt)
+}
+
+func (w *writer) count(field string, n *int) string {
+	if n == nil || *n <= 0 {
+		w.fail(field, "is required")
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func (w *writer) weight(field string, kg *float64) string {
+	if kg == nil || *kg <= 0 {
+		w.fail(field, "is required")
+		return ""
+	}
+	return strconv.FormatFloat(*kg, 'f', 3, 64)
+}
+
+func money(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// isCustomHouse reports whether s looks like an Indian custom house code:
+// IN, a three letter location and a digit, e.g. INNSA1
+// customHouseCode returns the custom house a port of discharge names: the
+// text itself when it is a custom house code, else the first alias of its
+// port master that is one, such as INNSA1 for Nhava Sheva
+func customHouseCode(text string, port *models.Master) string {
+	if port == nil || isCustomHouse(tradeid.Normalize(text)) {
+		return text
+	}
+	for _, alias := range port.Aliases {
+		if isCustomHouse(tradeid.Normalize(alias)) {
+			return alias
+		}
+	}
+	return text
+}
+
+// masterCode returns the code of the master a field is linked to, or the
+// field's text when it is not linked or the master has no code
+func masterCode(text string, m *models.Master) string {
+	if m == nil || m.Code == nil || *m.Code == "" {
+		return text
+	}
+	return *m.Code
+}
+
+func isCustomHouse(s string) bool {
+	return len(s) == 6 && strings.HasPrefix(s, "IN") && IsLocode(s[:5]) && s[5] >= '0' && s[5] <= '9'
+}
+
+// IsLocode reports whether s has the shape of a UN/LOCODE: a two letter
+// country and three letters or digits
+func IsLocode(s string) bool {
+	if len(s) != 5 {
+		return false
+	}
+	for i := 0; i < 5; i++ {
+		c := s[i]
+		letter := c >= 'A' && c <= 'Z'
+		if i < 2 && !letter || i >= 2 && !letter && !(c >= '2' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// clean collapses whitespace and drops separators that would split a field
+func clean(s string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, separator, " ")), " ")
+}
+
+func str(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
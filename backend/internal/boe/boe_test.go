@@ -0,0 +1,270 @@
+package boe
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/validation"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func strPtr(s string) *string { return &s }
+
+func datePtr(year int, month time.Month, day int) *time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+// testInput is a job with every field the Bill of Entry needs
+func testInput() Input {
+	packages, weight := 120, 18500.5
+	job := &models.PipelineJobResponse{
+		Stage1: &models.Stage1Data{
+			JobDate:           datePtr(2026, 4, 8),
+			Consignee:         strPtr("ABC  Import\x1dCo."),
+			ConsigneeIEC:      strPtr("0305012345"),
+			ConsigneeGSTIN:    strPtr("27aapfu0939f1zv"),
+			Shipper:           strPtr("Ningbo Bearing Works"),
+			PortOfDischarge:   strPtr("innsa1"),
+			PortOfLoading:     strPtr("CN SHA"),
+			CountryOfShipment: strPtr("China"),
+			LocalIGM:          strPtr("2345678"),
+			LocalIGMDate:      datePtr(2026, 4, 10),
+			DateOfArrival:     datePtr(2026, 4, 11),
+			MBLNo:             strPtr("MAEU123456789"),
+			MBLDate:           datePtr(2026, 4, 1),
+			HBLNo:             strPtr("SHA0099"),
+			HBLDate:           datePtr(2026, 4, 2),
+			Packages:          &packages,
+			Weight:            &weight,
+			InvoiceNo:         strPtr("NBW/2026/118"),
+			InvoiceDate:       datePtr(2026, 3, 28),
+		},
+		Stage2: &models.Stage2Data{DocumentsType: strPtr("Original")},
+	}
+	job.JobNo = "JOB/2026/001"
+	return Input{
+		Job: job,
+		Duty: &models.DutyBreakdown{
+			Currency: "USD", InvoiceValue: 12500, Freight: 650.5, Insurance: 125,
+			Items: []models.DutyItem{
+				{HSNCode: "84821010", Description: "Ball bearings", InvoiceValue: 10000, AssessableValue: 877500.25},
+				{HSNCode: "84829900", Description: "Bearing parts", InvoiceValue: 2500, AssessableValue: 219375.06},
+			},
+		},
+		Sender: Sender{CHACode: "AAPFU0939FCH001", ICEGATEID: "MAYDIV01"},
+		Now:    time.Date(2026, 4, 12, 9, 5, 0, 0, time.UTC),
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Input)
+	}{
+		{name: "complete", change: func(*Input) {}},
+		{name: "edi_job_no", change: func(in *Input) {
+			in.Job.Stage1.EDIJobNo = strPtr("NSA-4521")
+			in.Job.Stage1.HBLNo, in.Job.Stage1.HBLDate = nil, nil
+			in.Job.Stage2 = nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := testInput()
+			tt.change(&in)
+			got, err := Generate(in)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			// The golden files show the group separator as |
+			got = bytes.ReplaceAll(got, []byte(separator), []byte("|"))
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Bill of Entry differs from %s:\n%s", path, got)
+			}
+		})
+	}
+}
+
+func TestGenerateMandatoryFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Input)
+		fields []string
+	}{
+		{
+			name:   "sender",
+			change: func(in *Input) { in.Sender = Sender{} },
+			fields: []string{"company.cha_code", "company.icegate_id"},
+		},
+		{
+			name:   "job number",
+			change: func(in *Input) { in.Job.JobNo = " " },
+			fields: []string{"job_no"},
+		},
+		{
+			name:   "long filing job number",
+			change: func(in *Input) { in.Job.Stage1.EDIJobNo = strPtr("NSA-2026-000000045210") },
+			fields: []string{"stage1.edi_job_no"},
+		},
+		{
+			name: "ports",
+			change: func(in *Input) {
+				in.Job.Stage1.PortOfDischarge = strPtr("INNSA")
+				in.Job.Stage1.PortOfLoading = strPtr("Shanghai")
+			},
+			fields: []string{"stage1.port_of_discharge", "stage1.port_of_loading"},
+		},
+		{
+			name: "importer",
+			change: func(in *Input) {
+				in.Job.Stage1.ConsigneeIEC = strPtr("03050123")
+				in.Job.Stage1.ConsigneeGSTIN = nil
+				in.Job.Stage1.Consignee = strPtr("\x1d ")
+			},
+			fields: []string{"stage1.consignee_iec", "stage1.consignee", "stage1.consignee_gstin"},
+		},
+		{
+			name: "packages and weight, reported once",
+			change: func(in *Input) {
+				zero := 0
+				in.Job.Stage1.Packages = &zero
+				in.Job.Stage1.Weight = nil
+			},
+			fields: []string{"stage1.packages", "stage1.weight"},
+		},
+		{
+			name: "IGM",
+			change: func(in *Input) {
+				in.Job.Stage1.LocalIGM = strPtr("23456789")
+				in.Job.Stage1.LocalIGMDate = nil
+				in.Job.Stage1.DateOfArrival = &time.Time{}
+				in.Job.Stage1.MBLNo = nil
+				in.Job.Stage1.MBLDate = nil
+			},
+			fields: []string{"stage1.local_igm", "stage1.local_igm_date", "stage1.date_of_arrival", "stage1.mbl_no", "stage1.mbl_date"},
+		},
+		{
+			name:   "no stage 1 data",
+			change: func(in *Input) { in.Job.Stage1 = nil },
+			fields: []string{
+				"stage1.port_of_discharge", "stage1.job_date", "stage1.consignee_iec", "stage1.consignee",
+				"stage1.consignee_gstin", "stage1.country_of_shipment", "stage1.port_of_loading",
+				"stage1.packages", "stage1.weight", "stage1.local_igm", "stage1.local_igm_date",
+				"stage1.date_of_arrival", "stage1.mbl_no", "stage1.mbl_date",
+				"stage1.invoice_no", "stage1.invoice_date", "stage1.shipper",
+			},
+		},
+		{
+			name:   "no duty calculation",
+			change: func(in *Input) { in.Duty = nil },
+			fields: []string{"duty"},
+		},
+		{
+			name:   "duty calculation without items",
+			change: func(in *Input) { in.Duty.Items = nil },
+			fields: []string{"duty.items"},
+		},
+		{
+			name: "items",
+			change: func(in *Input) {
+				in.Duty.Items[0].HSNCode = "8482101"
+				in.Duty.Items[1].Description = " "
+			},
+			fields: []string{"duty.items[0].hsn_code", "duty.items[1].description"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := testInput()
+			tt.change(&in)
+			data, err := Generate(in)
+			var errs validation.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Generate = %d bytes, %v, want validation errors", len(data), err)
+			}
+			var fields []string
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Generate rejected %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestGenerateLinkedMasters(t *testing.T) {
+	nhavaSheva := &models.Master{Kind: models.MasterPort, Code: strPtr("INNSA"), Name: "Nhava Sheva",
+		Aliases: []string{"JNPT", "INNSA1"}}
+	shanghai := &models.Master{Kind: models.MasterPort, Code: strPtr("CNSHA"), Name: "Shanghai"}
+	china := &models.Master{Kind: models.MasterCountry, Code: strPtr("CN"), Name: "China", Aliases: []string{"PRC"}}
+
+	in := testInput()
+	in.Job.Stage1.PortOfDischarge = strPtr("Nhava Sheva")
+	in.Job.Stage1.PortOfLoading = strPtr("Shanghai")
+	in.Job.Stage1.CountryOfShipment = strPtr("PRC")
+	in.Places = Places{PortOfDischarge: nhavaSheva, PortOfLoading: shanghai, Country: china}
+	got, err := Generate(in)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{"ZZ" + separator + "INNSA1" + separator, separator + "CN" + separator + "CNSHA" + separator} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("Bill of Entry lacks %q:\n%s", bytes.ReplaceAll([]byte(want), []byte(separator), []byte("|")), got)
+		}
+	}
+
+	// A port without a custom house alias is still rejected
+	in.Places.PortOfDischarge = &models.Master{Kind: models.MasterPort, Code: strPtr("INNSA"), Name: "Nhava Sheva"}
+	var errs validation.Errors
+	if _, err := Generate(in); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "stage1.port_of_discharge" {
+		t.Errorf("Generate without a custom house alias = %v, want a stage1.port_of_discharge error", err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		jobNo, want string
+	}{
+		{"JOB001", "JOB001.be"},
+		{"JOB/2026/001", "JOB_2026_001.be"},
+		{"../etc passwd", "___etc_passwd.be"},
+	}
+	for _, tt := range tests {
+		if got := FileName(tt.jobNo); got != tt.want {
+			t.Errorf("FileName(%q) = %q, want %q", tt.jobNo, got, tt.want)
+		}
+	}
+}
+
+func TestIsCustomHouse(t *testing.T) {
+	for code, want := range map[string]bool{
+		"INNSA1": true, "INMAA1": true, "INDEL4": true,
+		"INNSA": false, "CNSHA1": false, "INNS01": false, "IN2SA1": true, "INNSAX": false,
+	} {
+		if got := isCustomHouse(code); got != want {
+			t.Errorf("isCustomHouse(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every runtime setting of the server. Values are resolved in
+// order: built-in defaults, the YAML config file, environment variables and
+// finally command line flags.
+type Config struct {
+	Server        ServerConfig       `yaml:"server"`
+	Database      DatabaseConfig     `yaml:"database"`
+	Session       SessionConfig      `yaml:"session"`
+	SMTP          SMTPConfig         `yaml:"smtp"`
+	Uploads       UploadConfig       `yaml:"uploads"`
+	Notifications NotificationConfig `yaml:"notifications"`
+	Health        HealthConfig       `yaml:"health"`
+	Log           LogConfig          `yaml:"log"`
+	Company       CompanyConfig      `yaml:"company"`
+	Billing       BillingConfig      `yaml:"billing"`
+	Demurrage     DemurrageConfig    `yaml:"demurrage"`
+}
+
+// ServerConfig holds HTTP listener settings
+type ServerConfig struct {
+	Addr       string `yaml:"addr"`
+	CORSOrigin string `yaml:"cors_origin"`
+	// AppURL is the address of the frontend, for links in emails
+	AppURL string `yaml:"app_url"`
+	// DrainDelay is how long readiness reports failure before the listener
+	// closes, giving load balancers time to stop routing new requests.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ShutdownTimeout bounds how long in-flight requests and pending
+	// notifications may take to finish after a shutdown signal.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// DatabaseConfig holds MySQL connection settings
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+}
+
+// SessionConfig holds cookie session settings
+type SessionConfig struct {
+	Key    string `yaml:"key"`
+	Secure bool   `yaml:"secure"`
+	MaxAge int    `yaml:"max_age"`
+}
+
+// SMTPConfig holds outgoing mail settings
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// UploadConfig holds file upload settings
+type UploadConfig struct {
+	Dir      string `yaml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes"`
+}
+
+// NotificationConfig holds notification recipients
+type NotificationConfig struct {
+	AdminEmail string `yaml:"admin_email"`
+}
+
+// HealthConfig holds readiness probe settings
+type HealthConfig struct {
+	CheckSMTP bool `yaml:"check_smtp"`
+}
+
+// LogConfig holds logger settings
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	// IncludeFinancial lets amounts such as duty and freight through to the
+	// logs. Keep it off outside local debugging.
+	IncludeFinancial bool `yaml:"include_financial"`
+}
+
+// CompanyConfig identifies the customs broker running the CRM on the
+// filings it generates
+type CompanyConfig struct {
+	Name string `yaml:"name"`
+	// CHACode is the customs broker licence code
+	CHACode string `yaml:"cha_code"`
+	// ICEGATEID is the sender ID on EDI files submitted to ICEGATE
+	ICEGATEID string `yaml:"icegate_id"`
+	// GSTIN and the address below identify the seller on GST e-invoices
+	GSTIN    string `yaml:"gstin"`
+	Address1 string `yaml:"address1"`
+	Address2 string `yaml:"address2"`
+	Location string `yaml:"location"`
+	Pincode  string `yaml:"pincode"`
+	Phone    string `yaml:"phone"`
+	Email    string `yaml:"email"`
+}
+
+// BillingConfig holds the terms bills are issued on
+type BillingConfig struct {
+	// CreditDays is how long after its date a bill falls due
+	CreditDays int `yaml:"credit_days"`
+	// CreditNotePrefix and DebitNotePrefix start the numbers of credit and
+	// debit notes, which run in their own series each financial year
+	CreditNotePrefix string `yaml:"credit_note_prefix"`
+	DebitNotePrefix  string `yaml:"debit_note_prefix"`
+}
+
+// DemurrageConfig holds when containers' free time is alerted
+type DemurrageConfig struct {
+	// AlertDays is how many days before a container's free time ends the
+	// job's notification email is alerted
+	AlertDays int `yaml:"alert_days"`
+}
+
+// Options are the command line flags that are not configuration values
+// themselves but steer how the configuration is loaded.
+type Options struct {
+	ConfigFile  string
+	EnvFile     string
+	PrintConfig bool
+	// Args are the arguments left after the flags, for commands that take them
+	Args []string
+}
+
+const redacted = "********"
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			CORSOrigin:      "http://localhost:3000",
+			AppURL:          "http://localhost:3000",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host: "localhost",
+			Port: 3306,
+		},
+		Session: SessionConfig{
+			MaxAge: 86400 * 7, // 7 days
+		},
+		SMTP: SMTPConfig{
+			Host: "smtp.gmail.com",
+			Port: 587,
+		},
+		Uploads: UploadConfig{
+			Dir:      "uploads",
+			MaxBytes: 50 << 20, // 50MB
+		},
+		Notifications: NotificationConfig{
+			AdminEmail: "admin@maydiv.com",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Billing: BillingConfig{
+			CreditDays:       30,
+			CreditNotePrefix: "CN",
+			DebitNotePrefix:  "DN",
+		},
+		Demurrage: DemurrageConfig{
+			AlertDays: 3,
+		},
+	}
+}
+
+// Load resolves the configuration from defaults, the config file, the
+// environment and the given command line arguments (without the program name).
+func Load(args []string) (*Config, *Options, error) {
+	cfg := Default()
+	opts := &Options{}
+
+	fs := flag.NewFlagSet("maydiv-crm", flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "path to YAML config file")
+	fs.StringVar(&opts.EnvFile, "env-file", ".env", "path to .env file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	addr := fs.String("addr", "", "HTTP listen address (e.g. :8080)")
+	corsOrigin := fs.String("cors-origin", "", "allowed CORS origin")
+	uploadDir := fs.String("upload-dir", "", "directory for uploaded files")
+	uploadMax := fs.Int64("upload-max-bytes", 0, "maximum upload size in bytes")
+	sessionSecure := fs.Bool("session-secure", false, "mark session cookies as Secure (HTTPS only)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for in-flight work on shutdown")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	dbHost := fs.String("db-host", "", "database host")
+	dbName := fs.String("db-name", "", "database name")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	opts.Args = fs.Args()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// A missing .env file is only an error when it was asked for explicitly
+	if err := godotenv.Load(opts.EnvFile); err != nil && set["env-file"] {
+		return nil, nil, fmt.Errorf("loading env file %s: %w", opts.EnvFile, err)
+	}
+
+	if opts.ConfigFile != "" {
+		if err := cfg.loadFile(opts.ConfigFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	if set["addr"] {
+		cfg.Server.Addr = *addr
+	}
+	if set["cors-origin"] {
+		cfg.Server.CORSOrigin = *corsOrigin
+	}
+	if set["shutdown-timeout"] {
+		cfg.Server.ShutdownTimeout = *shutdownTimeout
+	}
+	if set["upload-dir"] {
+		cfg.Uploads.Dir = *uploadDir
+	}
+	if set["upload-max-bytes"] {
+		cfg.Uploads.MaxBytes = *uploadMax
+	}
+	if set["session-secure"] {
+		cfg.Session.Secure = *sessionSecure
+	}
+	if set["log-level"] {
+		cfg.Log.Level = *logLevel
+	}
+	if set["db-host"] {
+		cfg.Database.Host = *dbHost
+	}
+	if set["db-name"] {
+		cfg.Database.Name = *dbName
+	}
+
+	if cfg.SMTP.From == "" {
+		cfg.SMTP.From = cfg.SMTP.User // Use SMTP user as from email if not specified
+	}
+
+	return cfg, opts, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	num := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	num64 := func(key string, dst *int64) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("SERVER_ADDR", &c.Server.Addr)
+	str("CORS_ORIGIN", &c.Server.CORSOrigin)
+	str("APP_URL", &c.Server.AppURL)
+	duration("DRAIN_DELAY", &c.Server.DrainDelay)
+	duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	str("DB_HOST", &c.Database.Host)
+	num("DB_PORT", &c.Database.Port)
+	str("DB_USER", &c.Database.User)
+	str("DB_PASS", &c.Database.Password)
+	str("DB_NAME", &c.Database.Name)
+
+	str("SESSION_KEY", &c.Session.Key)
+	boolean("SESSION_SECURE", &c.Session.Secure)
+	num("SESSION_MAX_AGE", &c.Session.MaxAge)
+
+	str("SMTP_HOST", &c.SMTP.Host)
+	num("SMTP_PORT", &c.SMTP.Port)
+	str("SMTP_USER", &c.SMTP.User)
+	str("SMTP_PASS", &c.SMTP.Password)
+	str("FROM_EMAIL", &c.SMTP.From)
+
+	str("UPLOAD_DIR", &c.Uploads.Dir)
+	num64("UPLOAD_MAX_BYTES", &c.Uploads.MaxBytes)
+
+	str("ADMIN_EMAIL", &c.Notifications.AdminEmail)
+
+	boolean("READINESS_CHECK_SMTP", &c.Health.CheckSMTP)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+	boolean("LOG_INCLUDE_FINANCIAL", &c.Log.IncludeFinancial)
+
+	str("COMPANY_NAME", &c.Company.Name)
+	str("COMPANY_CHA_CODE", &c.Company.CHACode)
+	str("COMPANY_ICEGATE_ID", &c.Company.ICEGATEID)
+	str("COMPANY_GSTIN", &c.Company.GSTIN)
+	str("COMPANY_ADDRESS1", &c.Company.Address1)
+	str("COMPANY_ADDRESS2", &c.Company.Address2)
+	str("COMPANY_LOCATION", &c.Company.Location)
+	str("COMPANY_PINCODE", &c.Company.Pincode)
+	str("COMPANY_PHONE", &c.Company.Phone)
+	str("COMPANY_EMAIL", &c.Company.Email)
+
+	num("BILLING_CREDIT_DAYS", &c.Billing.CreditDays)
+	str("BILLING_CREDIT_NOTE_PREFIX", &c.Billing.CreditNotePrefix)
+	str("BILLING_DEBIT_NOTE_PREFIX", &c.Billing.DebitNotePrefix)
+
+	num("DEMURRAGE_ALERT_DAYS", &c.Demurrage.AlertDays)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Validate checks that every setting the server needs is present
+func (c *Config) Validate() error {
+	problems := c.Database.problems()
+	problems = append(problems, c.SMTP.problems()...)
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr (SERVER_ADDR) is required")
+	}
+	if c.Server.DrainDelay < 0 {
+		problems = append(problems, "server.drain_delay (DRAIN_DELAY) must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+	}
+	if c.Uploads.Dir == "" {
+		problems = append(problems, "uploads.dir (UPLOAD_DIR) is required")
+	}
+	if c.Uploads.MaxBytes <= 0 {
+		problems = append(problems, "uploads.max_bytes (UPLOAD_MAX_BYTES) must be positive")
+	}
+	if c.Session.MaxAge <= 0 {
+		problems = append(problems, "session.max_age (SESSION_MAX_AGE) must be positive")
+	}
+	if c.Billing.CreditDays < 0 {
+		problems = append(problems, "billing.credit_days (BILLING_CREDIT_DAYS) must not be negative")
+	}
+	if n := len(c.Billing.CreditNotePrefix); n < 1 || n > 5 {
+		problems = append(problems, "billing.credit_note_prefix (BILLING_CREDIT_NOTE_PREFIX) must be 1 to 5 characters")
+	}
+	if n := len(c.Billing.DebitNotePrefix); n < 1 || n > 5 {
+		problems = append(problems, "billing.debit_note_prefix (BILLING_DEBIT_NOTE_PREFIX) must be 1 to 5 characters")
+	}
+	if c.Billing.CreditNotePrefix == c.Billing.DebitNotePrefix {
+		problems = append(problems, "billing.credit_note_prefix and billing.debit_note_prefix must differ")
+	}
+	if c.Demurrage.AlertDays < 0 {
+		problems = append(problems, "demurrage.alert_days (DEMURRAGE_ALERT_DAYS) must not be negative")
+	}
+
+	return problemError(problems)
+}
+
+// Validate checks that the database settings are complete
+func (d DatabaseConfig) Validate() error {
+	return problemError(d.problems())
+}
+
+func (d DatabaseConfig) problems() []string {
+	var problems []string
+	if d.Host == "" {
+		problems = append(problems, "database.host (DB_HOST) is required")
+	}
+	if d.Port <= 0 {
+		problems = append(problems, "database.port (DB_PORT) must be positive")
+	}
+	if d.User == "" {
+		problems = append(problems, "database.user (DB_USER) is required")
+	}
+	if d.Name == "" {
+		problems = append(problems, "database.name (DB_NAME) is required")
+	}
+	return problems
+}
+
+func (s SMTPConfig) problems() []string {
+	var problems []string
+	if s.Host == "" {
+		problems = append(problems, "smtp.host (SMTP_HOST) is required")
+	}
+	if s.Port <= 0 {
+		problems = append(problems, "smtp.port (SMTP_PORT) must be positive")
+	}
+	if s.User == "" {
+		problems = append(problems, "smtp.user (SMTP_USER) is required")
+	}
+	if s.Password == "" {
+		problems = append(problems, "smtp.password (SMTP_PASS) is required")
+	}
+	if s.From == "" {
+		problems = append(problems, "smtp.from (FROM_EMAIL) is required")
+	}
+	return problems
+}
+
+func problemError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// DSN returns the MySQL data source name. clientFoundRows makes
+// RowsAffected count matched rows, so an UPDATE that changes nothing is not
+// mistaken for a missing row.
+func (d DatabaseConfig) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&clientFoundRows=true",
+		d.User, d.Password, d.Host, d.Port, d.Name)
+}
+
+// Redacted returns a copy of the configuration with secrets masked
+func (c *Config) Redacted() *Config {
+	out := *c
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	mask(&out.Database.Password)
+	mask(&out.Session.Key)
+	mask(&out.SMTP.Password)
+	return &out
+}
+
+// String renders the configuration as YAML with secrets masked
+func (c *Config) String() string {
+	b, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("<config: %v>", err)
+	}
+	return string(b)
+}
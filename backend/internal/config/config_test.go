@@ -0,0 +1,268 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envKeys are the variables the tests set; they are cleared first so that
+// the environment the tests run in does not leak into them
+var envKeys = []string{
+	"CONFIG_FILE", "SERVER_ADDR", "CORS_ORIGIN", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASS", "DB_NAME",
+	"SESSION_KEY", "SESSION_MAX_AGE", "SMTP_HOST", "SMTP_PORT", "SMTP_USER", "SMTP_PASS", "FROM_EMAIL",
+	"UPLOAD_DIR", "UPLOAD_MAX_BYTES",
+}
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range envKeys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+// writeFile writes a file in the test's temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const yamlFile = `
+server:
+  addr: ":9000"
+database:
+  host: db.yaml
+  name: crm_yaml
+  port: 3307
+`
+	tests := []struct {
+		name     string
+		yaml     bool
+		dotenv   string
+		env      map[string]string
+		flags    []string
+		addr     string
+		dbHost   string
+		dbName   string
+		dbPort   int
+		dbUser   string
+		smtpFrom string
+	}{
+		{
+			name: "defaults", addr: ":8080", dbHost: "localhost", dbPort: 3306,
+		},
+		{
+			name: "YAML over defaults", yaml: true,
+			addr: ":9000", dbHost: "db.yaml", dbName: "crm_yaml", dbPort: 3307,
+		},
+		{
+			name: ".env over YAML", yaml: true, dotenv: "DB_HOST=db.dotenv\nDB_USER=crm\n",
+			addr: ":9000", dbHost: "db.dotenv", dbName: "crm_yaml", dbPort: 3307, dbUser: "crm",
+		},
+		{
+			name: "environment over .env", yaml: true, dotenv: "DB_HOST=db.dotenv\n",
+			env:  map[string]string{"DB_HOST": "db.env", "SERVER_ADDR": ":9100", "SMTP_USER": "mail@maydiv.in"},
+			addr: ":9100", dbHost: "db.env", dbName: "crm_yaml", dbPort: 3307, smtpFrom: "mail@maydiv.in",
+		},
+		{
+			name: "flags over everything", yaml: true, dotenv: "DB_NAME=crm_dotenv\n",
+			env:   map[string]string{"DB_HOST": "db.env", "SERVER_ADDR": ":9100", "DB_PORT": "3308"},
+			flags: []string{"-addr", ":9200", "-db-host", "db.flag", "-db-name", "crm_flag"},
+			addr:  ":9200", dbHost: "db.flag", dbName: "crm_flag", dbPort: 3308,
+		},
+		{
+			name: "flags set to empty", env: map[string]string{"DB_NAME": "crm_env"},
+			flags: []string{"-db-name="},
+			addr:  ":8080", dbHost: "localhost", dbPort: 3306,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := []string{"-env-file", writeFile(t, ".env", tt.dotenv)}
+			if tt.yaml {
+				args = append(args, "-config", writeFile(t, "config.yaml", yamlFile))
+			}
+			cfg, opts, err := Load(append(args, tt.flags...))
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if tt.yaml != (opts.ConfigFile != "") {
+				t.Errorf("config file %q", opts.ConfigFile)
+			}
+			got := []any{cfg.Server.Addr, cfg.Database.Host, cfg.Database.Name, cfg.Database.Port, cfg.Database.User, cfg.SMTP.From}
+			want := []any{tt.addr, tt.dbHost, tt.dbName, tt.dbPort, tt.dbUser, tt.smtpFrom}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("addr, db host, name, port, user, smtp from = %v, want %v", got, want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "server:\n  addr: \":9300\"\n"))
+	cfg, _, err := Load([]string{"-env-file", writeFile(t, ".env", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":9300" {
+		t.Errorf("addr %q, want the CONFIG_FILE's :9300", cfg.Server.Addr)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		env   map[string]string
+		args  []string
+		error string
+	}{
+		{name: "unknown YAML key", yaml: "server:\n  adr: \":9000\"\n", error: "field adr not found"},
+		{name: "unknown YAML section", yaml: "cache:\n  size: 10\n", error: "field cache not found"},
+		{name: "malformed YAML", yaml: "server: [", error: "parsing config file"},
+		{name: "missing config file", args: []string{"-config", "/nonexistent/config.yaml"}, error: "opening config file"},
+		{name: "missing env file asked for", args: []string{"-env-file", "/nonexistent/.env"}, error: "loading env file"},
+		{name: "bad number in the environment", env: map[string]string{"DB_PORT": "33o6"}, error: "DB_PORT"},
+		{name: "unknown flag", args: []string{"-port", "80"}, error: "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if args == nil {
+				args = []string{"-env-file", writeFile(t, ".env", "")}
+			}
+			if tt.yaml != "" {
+				args = append(args, "-config", writeFile(t, "config.yaml", tt.yaml))
+			}
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.error)
+			}
+		})
+	}
+}
+
+// validConfig is the defaults with the settings that have none filled in
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Database.User = "crm"
+	cfg.Database.Name = "maydiv_crm"
+	cfg.SMTP.User = "mail@maydiv.in"
+	cfg.SMTP.Password = "app-password"
+	cfg.SMTP.From = "mail@maydiv.in"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(*Config)
+		problems []string
+	}{
+		{name: "complete", change: func(*Config) {}},
+		{
+			name:     "no database",
+			change:   func(c *Config) { c.Database = DatabaseConfig{} },
+			problems: []string{"database.host (DB_HOST)", "database.port (DB_PORT)", "database.user (DB_USER)", "database.name (DB_NAME)"},
+		},
+		{
+			name:     "no SMTP credentials",
+			change:   func(c *Config) { c.SMTP.User, c.SMTP.Password, c.SMTP.From = "", "", "" },
+			problems: []string{"smtp.user (SMTP_USER)", "smtp.password (SMTP_PASS)", "smtp.from (FROM_EMAIL)"},
+		},
+		{
+			name:     "no SMTP server",
+			change:   func(c *Config) { c.SMTP.Host, c.SMTP.Port = "", 0 },
+			problems: []string{"smtp.host (SMTP_HOST)", "smtp.port (SMTP_PORT)"},
+		},
+		{
+			name:     "no uploads",
+			change:   func(c *Config) { c.Uploads = UploadConfig{} },
+			problems: []string{"uploads.dir (UPLOAD_DIR)", "uploads.max_bytes (UPLOAD_MAX_BYTES)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("Validate = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate passed")
+			}
+			lines := strings.Split(err.Error(), "\n  - ")
+			if len(lines)-1 != len(tt.problems) {
+				t.Errorf("Validate = %v, want %d problems", err, len(tt.problems))
+			}
+			for _, p := range tt.problems {
+				if !strings.Contains(err.Error(), "\n  - "+p) {
+					t.Errorf("Validate = %v, want a problem with %s", err, p)
+				}
+			}
+		})
+	}
+
+	if err := (DatabaseConfig{Host: "localhost", Port: 3306, Name: "crm"}).Validate(); err == nil || !strings.Contains(err.Error(), "DB_USER") {
+		t.Errorf("DatabaseConfig.Validate = %v, want DB_USER required", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.Database.Password = "db-secret"
+	cfg.Session.Key = "session-secret"
+	cfg.SMTP.Password = "smtp-secret"
+
+	out := cfg.Redacted()
+	for name, got := range map[string]string{
+		"database.password": out.Database.Password,
+		"session.key":       out.Session.Key,
+		"smtp.password":     out.SMTP.Password,
+	} {
+		if got != redacted {
+			t.Errorf("%s = %q, want it masked", name, got)
+		}
+	}
+	if cfg.Database.Password != "db-secret" || cfg.Session.Key != "session-secret" || cfg.SMTP.Password != "smtp-secret" {
+		t.Error("Redacted changed the configuration it copied")
+	}
+	if out.Database.User != "crm" || out.SMTP.User != "mail@maydiv.in" {
+		t.Error("Redacted masked settings that are not secret")
+	}
+
+	s := cfg.String()
+	for _, secret := range []string{"db-secret", "session-secret", "smtp-secret"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("String shows %s:\n%s", secret, s)
+		}
+	}
+
+	empty := Default().Redacted()
+	if empty.Database.Password != "" || empty.Session.Key != "" {
+		t.Error("Redacted masked secrets that are not set")
+	}
+}
@@ -3,8 +3,8 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"maydiv-crm/internal/config"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -14,16 +14,8 @@ type DB struct {
 }
 
 // NewConnection creates a new database connection
-func NewConnection() (*DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASS"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"),
-	)
-	
-	db, err := sql.Open("mysql", dsn)
+func NewConnection(cfg config.DatabaseConfig) (*DB, error) {
+	db, err := sql.Open("mysql", cfg.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
@@ -32,7 +24,7 @@ func NewConnection() (*DB, error) {
 		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
 	
-	log.Println("Successfully connected to database")
+	slog.Info("Successfully connected to database", "host", cfg.Host, "database", cfg.Name)
 	
 	return &DB{db}, nil
 }
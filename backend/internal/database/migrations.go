@@ -1,27 +1,47 @@
 package database
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"log/slog"
 	"strings"
 )
 
 func (db *DB) Migrate() error {
-	log.Println("Running database migrations...")
+	slog.Info("Running database migrations")
 
 	// SQL schema for 4-stage pipeline workflow
 	schema := `
 	-- Drop existing tables if they exist
+	DROP TABLE IF EXISTS bill_note_items;
+	DROP TABLE IF EXISTS bill_notes;
 	DROP TABLE IF EXISTS job_files;
+	DROP TABLE IF EXISTS deposit_movements;
+	DROP TABLE IF EXISTS deposit_accounts;
+	DROP TABLE IF EXISTS ledger_entries;
+	DROP TABLE IF EXISTS payments;
+	DROP TABLE IF EXISTS customer_advances;
+	DROP TABLE IF EXISTS e_invoices;
+	DROP TABLE IF EXISTS bill_items;
+	DROP TABLE IF EXISTS duty_calculations;
+	DROP TABLE IF EXISTS job_filings;
 	DROP TABLE IF EXISTS task_updates;
 	DROP TABLE IF EXISTS task_assignments;
 	DROP TABLE IF EXISTS tasks;
 	DROP TABLE IF EXISTS job_updates;
 	DROP TABLE IF EXISTS stage4_data;
+	DROP TABLE IF EXISTS container_events;
+	DROP TABLE IF EXISTS job_containers;
 	DROP TABLE IF EXISTS stage3_containers;
 	DROP TABLE IF EXISTS stage3_data;
 	DROP TABLE IF EXISTS stage2_data;
 	DROP TABLE IF EXISTS stage1_data;
 	DROP TABLE IF EXISTS pipeline_jobs;
+	DROP TABLE IF EXISTS org_invitations;
+	DROP TABLE IF EXISTS party_members;
+	DROP TABLE IF EXISTS party_aliases;
+	DROP TABLE IF EXISTS party_contacts;
+	DROP TABLE IF EXISTS parties;
 
 	-- Users table (updated)
 	DROP TABLE IF EXISTS users;
@@ -35,12 +55,107 @@ func (db *DB) Migrate() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 
+	-- Master data (Ports by UN/LOCODE, countries, shipping lines, forwarders and custodians),
+	-- curated by admins and kept across restarts
+	CREATE TABLE IF NOT EXISTS master_data (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		kind ENUM('port', 'country', 'shipping_line', 'forwarder', 'custodian') NOT NULL,
+		code VARCHAR(20),
+		name VARCHAR(100) NOT NULL,
+		country CHAR(2),
+		code_key VARCHAR(20),
+		name_key VARCHAR(100) NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_master_data_code (kind, code_key),
+		UNIQUE KEY uq_master_data_name (kind, name_key)
+	);
+
+	-- Master aliases (Other names a master is written as, matched ignoring case, spaces and punctuation)
+	CREATE TABLE IF NOT EXISTS master_aliases (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		master_id INT NOT NULL,
+		kind ENUM('port', 'country', 'shipping_line', 'forwarder', 'custodian') NOT NULL,
+		alias VARCHAR(100) NOT NULL,
+		alias_key VARCHAR(100) NOT NULL,
+		FOREIGN KEY (master_id) REFERENCES master_data(id) ON DELETE CASCADE,
+		UNIQUE KEY uq_master_aliases (kind, alias_key)
+	);
+
+	-- Parties (Consignees and shippers as customer accounts, matched by name ignoring legal forms such as Pvt Ltd)
+	CREATE TABLE parties (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		name_key VARCHAR(255) NOT NULL UNIQUE,
+		address TEXT,
+		gstin VARCHAR(15),
+		iec VARCHAR(10),
+		billing_email VARCHAR(255),
+		billing_address TEXT,
+		credit_days INT,
+		notes TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_parties_gstin (gstin)
+	);
+
+	-- Party contacts (People at a party)
+	CREATE TABLE party_contacts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		party_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		email VARCHAR(255),
+		phone VARCHAR(30),
+		role VARCHAR(50),
+		FOREIGN KEY (party_id) REFERENCES parties(id) ON DELETE CASCADE
+	);
+
+	-- Party aliases (Other names a party is written as on jobs)
+	CREATE TABLE party_aliases (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		party_id INT NOT NULL,
+		alias VARCHAR(255) NOT NULL,
+		alias_key VARCHAR(255) NOT NULL UNIQUE,
+		FOREIGN KEY (party_id) REFERENCES parties(id) ON DELETE CASCADE
+	);
+
+	-- Party members (Customer users of a party, its org admins and the stages each may see, all when stages is NULL)
+	CREATE TABLE party_members (
+		user_id INT PRIMARY KEY,
+		party_id INT NOT NULL,
+		org_admin BOOLEAN NOT NULL DEFAULT FALSE,
+		stages SET('stage1', 'stage2', 'stage3', 'stage4') NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (party_id) REFERENCES parties(id) ON DELETE CASCADE,
+		INDEX idx_party_members_party (party_id)
+	);
+
+	-- Organization invitations (Colleagues invited to join a party, by a token sent to them and kept hashed)
+	CREATE TABLE org_invitations (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		party_id INT NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		org_admin BOOLEAN NOT NULL DEFAULT FALSE,
+		stages SET('stage1', 'stage2', 'stage3', 'stage4') NULL,
+		invited_by INT NULL,
+		expires_at DATETIME NOT NULL,
+		accepted_by INT NULL,
+		accepted_at DATETIME NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (party_id) REFERENCES parties(id) ON DELETE CASCADE,
+		FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL,
+		FOREIGN KEY (accepted_by) REFERENCES users(id) ON DELETE SET NULL
+	);
+
 	-- Pipeline Jobs table (Main job tracking)
 	CREATE TABLE pipeline_jobs (
 		id INT AUTO_INCREMENT PRIMARY KEY,
 		job_no VARCHAR(50) NOT NULL UNIQUE,
-		current_stage ENUM('stage1', 'stage2', 'stage3', 'stage4', 'completed') DEFAULT 'stage1',
-		status ENUM('active', 'on_hold', 'completed', 'cancelled') DEFAULT 'active',
+		current_stage ENUM('stage1', 'stage2', 'stage3', 'stage4', 'completed', 'closed') DEFAULT 'stage1',
+		status ENUM('draft', 'active', 'on_hold', 'completed', 'cancelled') DEFAULT 'active',
 		created_by INT NOT NULL,
 		assigned_to_stage2 INT NULL,
 		assigned_to_stage3 INT NULL,
@@ -63,17 +178,30 @@ func (db *DB) Migrate() error {
 		edi_job_no VARCHAR(50),
 		edi_date DATE,
 		consignee TEXT,
+		consignee_key VARCHAR(255),
+		consignee_gstin VARCHAR(15),
+		consignee_iec VARCHAR(10),
+		consignee_party_id INT,
 		shipper TEXT,
+		shipper_gstin VARCHAR(15),
+		shipper_iec VARCHAR(10),
+		shipper_party_id INT,
 		port_of_discharge VARCHAR(100),
+		port_of_discharge_id INT,
 		final_place_of_delivery VARCHAR(100),
+		final_place_of_delivery_id INT,
 		port_of_loading VARCHAR(100),
+		port_of_loading_id INT,
 		country_of_shipment VARCHAR(100),
+		country_of_shipment_id INT,
 		hbl_no VARCHAR(50),
 		hbl_date DATE,
 		mbl_no VARCHAR(50),
 		mbl_date DATE,
 		shipping_line VARCHAR(100),
+		shipping_line_id INT,
 		forwarder VARCHAR(100),
+		forwarder_id INT,
 		weight DECIMAL(10,2),
 		packages INT,
 		invoice_no VARCHAR(50),
@@ -85,15 +213,22 @@ func (db *DB) Migrate() error {
 		commodity TEXT,
 		eta DATETIME,
 		current_status VARCHAR(100),
-		container_no VARCHAR(50),
-		container_size ENUM('20', '40', 'LCL'),
 		date_of_arrival DATE,
 		invoice_pl_doc VARCHAR(255),
 		bl_doc VARCHAR(255),
 		coo_doc VARCHAR(255),
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (consignee_party_id) REFERENCES parties(id) ON DELETE SET NULL,
+		INDEX idx_stage1_consignee_key (consignee_key),
+		FOREIGN KEY (shipper_party_id) REFERENCES parties(id) ON DELETE SET NULL,
+		FOREIGN KEY (port_of_discharge_id) REFERENCES master_data(id) ON DELETE SET NULL,
+		FOREIGN KEY (final_place_of_delivery_id) REFERENCES master_data(id) ON DELETE SET NULL,
+		FOREIGN KEY (port_of_loading_id) REFERENCES master_data(id) ON DELETE SET NULL,
+		FOREIGN KEY (country_of_shipment_id) REFERENCES master_data(id) ON DELETE SET NULL,
+		FOREIGN KEY (shipping_line_id) REFERENCES master_data(id) ON DELETE SET NULL,
+		FOREIGN KEY (forwarder_id) REFERENCES master_data(id) ON DELETE SET NULL
 	);
 
 	-- Stage 2: Customs & Documentation (Employee)
@@ -138,48 +273,326 @@ func (db *DB) Migrate() error {
 		clearance_exps DECIMAL(10,2),
 		stamp_duty DECIMAL(10,2),
 		custodian VARCHAR(100),
+		custodian_id INT,
 		offloading_charges DECIMAL(10,2),
 		transport_detention DECIMAL(10,2),
 		dispatch_info TEXT,
 		bill_of_entry_upload VARCHAR(255),
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (custodian_id) REFERENCES master_data(id) ON DELETE SET NULL
 	);
 
-	-- Stage 3: Container Details (Multiple containers per job)
-	CREATE TABLE stage3_containers (
+	-- Containers (Each container on a job, added at stage 1 and filled in as it moves)
+	CREATE TABLE job_containers (
 		id INT AUTO_INCREMENT PRIMARY KEY,
 		job_id INT NOT NULL,
 		container_no VARCHAR(50),
 		size ENUM('20', '40', 'LCL'),
+		type_code VARCHAR(4),
 		vehicle_no VARCHAR(50),
 		date_of_offloading DATE,
 		empty_return_date DATE,
+		status VARCHAR(20),
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		UNIQUE KEY uq_job_containers (job_id, container_no)
+	);
+
+	-- Container events (The timeline of a container from discharge to empty return)
+	CREATE TABLE container_events (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		container_id INT NOT NULL,
+		event ENUM('discharged', 'arrived_cfs', 'examined', 'out_of_charge', 'loaded_on_truck', 'delivered', 'empty_returned') NOT NULL,
+		event_at DATETIME NOT NULL,
+		location VARCHAR(100),
+		remarks TEXT,
+		created_by INT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (container_id) REFERENCES job_containers(id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+		INDEX idx_container_events (container_id, event_at)
 	);
 
 	-- Stage 4: Billing & Customer (Customer/Admin)
 	CREATE TABLE stage4_data (
 		id INT AUTO_INCREMENT PRIMARY KEY,
-		job_id INT NOT NULL,
+		job_id INT NOT NULL UNIQUE,
 		bill_no VARCHAR(50),
 		bill_date DATE,
 		amount_taxable DECIMAL(10,2),
 		gst_5_percent DECIMAL(10,2),
 		gst_18_percent DECIMAL(10,2),
+		place_of_supply CHAR(2),
+		cgst_amount DECIMAL(12,2),
+		sgst_amount DECIMAL(12,2),
+		igst_amount DECIMAL(12,2),
+		round_off DECIMAL(4,2),
+		total_amount DECIMAL(12,2),
+		totals_overridden BOOLEAN NOT NULL DEFAULT FALSE,
 		bill_mail VARCHAR(255),
 		bill_courier VARCHAR(100),
 		courier_date DATE,
 		acknowledge_date DATE,
 		acknowledge_name VARCHAR(100),
 		bill_copy_upload VARCHAR(255),
+		issued_at TIMESTAMP NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE
 	);
 
+	-- Bill Items (Services billed in stage 4 with their computed GST)
+	CREATE TABLE bill_items (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		line_no INT NOT NULL,
+		description VARCHAR(300) NOT NULL,
+		sac VARCHAR(8) NOT NULL,
+		gst_rate DECIMAL(5,2) NOT NULL,
+		taxable_amount DECIMAL(12,2) NOT NULL,
+		cgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		sgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		igst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		total_amount DECIMAL(12,2) NOT NULL,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		UNIQUE KEY uq_bill_items (job_id, line_no)
+	);
+
+	-- Job Ledger (Costs and revenue booked against jobs)
+	CREATE TABLE ledger_entries (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		kind ENUM('cost', 'revenue') NOT NULL,
+		category VARCHAR(30) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		amount DECIMAL(12,2) NOT NULL,
+		paid_by ENUM('us', 'customer', 'forwarder') NOT NULL DEFAULT 'us',
+		reimbursable BOOLEAN NOT NULL DEFAULT FALSE,
+		entry_date DATE,
+		source VARCHAR(50) NULL,
+		created_by INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users(id),
+		UNIQUE KEY uq_ledger_source (job_id, source)
+	);
+
+	-- Customer Advances (Money received before billing, adjusted against bills)
+	CREATE TABLE customer_advances (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		customer VARCHAR(255) NOT NULL,
+		customer_key VARCHAR(255) NOT NULL,
+		amount DECIMAL(12,2) NOT NULL,
+		received_date DATE NOT NULL,
+		reference_no VARCHAR(100),
+		remarks TEXT,
+		created_by INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (created_by) REFERENCES users(id),
+		INDEX idx_advances_customer (customer_key)
+	);
+
+	-- Payments (Received against stage 4 bills, with TDS and advance adjustments)
+	CREATE TABLE payments (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		payment_date DATE NOT NULL,
+		amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		tds_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		mode ENUM('bank_transfer', 'cheque', 'cash', 'upi', 'advance') NOT NULL,
+		advance_id INT NULL,
+		reference_no VARCHAR(100),
+		remarks TEXT,
+		created_by INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (advance_id) REFERENCES customer_advances(id),
+		FOREIGN KEY (created_by) REFERENCES users(id)
+	);
+
+	-- Deposit Accounts (Money customers deposit for us to pay their duty from)
+	CREATE TABLE deposit_accounts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		customer VARCHAR(255) NOT NULL,
+		customer_key VARCHAR(255) NOT NULL UNIQUE,
+		party_id INT NULL UNIQUE,
+		created_by INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (party_id) REFERENCES parties(id) ON DELETE SET NULL,
+		FOREIGN KEY (created_by) REFERENCES users(id)
+	);
+
+	-- Deposit Movements (Receipts, refunds, and debits for duty and debit notes paid by us)
+	CREATE TABLE deposit_movements (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		account_id INT NOT NULL,
+		kind ENUM('receipt', 'debit', 'refund') NOT NULL,
+		amount DECIMAL(12,2) NOT NULL,
+		movement_date DATE NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		job_id INT NULL,
+		ledger_entry_id INT NULL UNIQUE,
+		reference_no VARCHAR(100),
+		remarks TEXT,
+		created_by INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (account_id) REFERENCES deposit_accounts(id),
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (ledger_entry_id) REFERENCES ledger_entries(id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users(id),
+		INDEX idx_deposit_movements_account (account_id, movement_date)
+	);
+
+	-- Demurrage Tariffs (Free days per shipping line, container size and kind of charge),
+	-- kept across restarts with their slabs and the alerts sent
+	CREATE TABLE IF NOT EXISTS demurrage_tariffs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		shipping_line VARCHAR(100) NOT NULL DEFAULT '',
+		container_size ENUM('20', '40', 'LCL') NOT NULL,
+		kind ENUM('demurrage', 'detention') NOT NULL,
+		free_days INT NOT NULL,
+		currency CHAR(3) NOT NULL DEFAULT 'INR',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_demurrage_tariffs (shipping_line, container_size, kind)
+	);
+
+	-- Demurrage Slabs (Rate per day over a range of days after the free days)
+	CREATE TABLE IF NOT EXISTS demurrage_slabs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		tariff_id INT NOT NULL,
+		from_day INT NOT NULL,
+		to_day INT NULL,
+		rate DECIMAL(12,2) NOT NULL,
+		FOREIGN KEY (tariff_id) REFERENCES demurrage_tariffs(id) ON DELETE CASCADE,
+		UNIQUE KEY uq_demurrage_slabs (tariff_id, from_day)
+	);
+
+	-- Demurrage Alerts (Free time alerts sent, so each is sent once). job_id
+	-- has no foreign key as jobs are recreated
+	CREATE TABLE IF NOT EXISTS demurrage_alerts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		container_no VARCHAR(50) NOT NULL,
+		kind ENUM('demurrage', 'detention') NOT NULL,
+		free_until DATE NOT NULL,
+		sent_to VARCHAR(255) NOT NULL,
+		sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_demurrage_alerts (job_id, container_no, kind, free_until)
+	);
+
+	-- Document Series (Last number issued in each credit and debit note series),
+	-- kept across restarts so that no number is issued twice
+	CREATE TABLE IF NOT EXISTS document_series (
+		series VARCHAR(20) PRIMARY KEY,
+		last_no INT NOT NULL
+	);
+
+	-- Settings (Admin-edited documents such as the invoice template), kept
+	-- across restarts. updated_by has no foreign key as users are recreated
+	CREATE TABLE IF NOT EXISTS settings (
+		name VARCHAR(50) PRIMARY KEY,
+		value JSON NOT NULL,
+		updated_by INT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	);
+
+	-- HSN tariff master, loaded from the customs tariff CSV. It is kept
+	-- across restarts, as only an import fills it
+	CREATE TABLE IF NOT EXISTS hsn_codes (
+		code VARCHAR(8) PRIMARY KEY,
+		description TEXT NOT NULL,
+		unit VARCHAR(20) NOT NULL DEFAULT '',
+		bcd_rate DECIMAL(7,2) NOT NULL DEFAULT 0,
+		sws_rate DECIMAL(7,2) NOT NULL DEFAULT 10,
+		igst_rate DECIMAL(7,2) NOT NULL DEFAULT 0,
+		cess_rate DECIMAL(7,2) NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	);
+
+	-- Customs exchange rates, rupees per unit of foreign currency, kept
+	-- across restarts like the HSN master
+	CREATE TABLE IF NOT EXISTS exchange_rates (
+		currency CHAR(3) NOT NULL,
+		effective_date DATE NOT NULL,
+		rate DECIMAL(12,4) NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (currency, effective_date)
+	);
+
+	-- Duty calculations (Itemized breakdowns saved for audit)
+	CREATE TABLE duty_calculations (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		calculated_by INT NOT NULL,
+		total_duty DECIMAL(15,2) NOT NULL,
+		breakdown JSON NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (calculated_by) REFERENCES users(id),
+		INDEX idx_duty_calculations_job (job_id, created_at)
+	);
+
+	-- Filing rules (Regulatory filings required by HSN prefix and origin),
+	-- maintained by admins and kept across restarts
+	CREATE TABLE IF NOT EXISTS filing_rules (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		hsn_prefix VARCHAR(8) NOT NULL DEFAULT '',
+		country VARCHAR(100) NOT NULL DEFAULT '',
+		filing VARCHAR(50) NOT NULL,
+		description TEXT NOT NULL,
+		documents JSON NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_filing_rules (hsn_prefix, country, filing)
+	);
+
+	-- Job Filings (Filings required on a job and their progress)
+	CREATE TABLE job_filings (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		rule_id INT,
+		filing VARCHAR(50) NOT NULL,
+		description TEXT NOT NULL,
+		documents JSON NOT NULL,
+		status ENUM('pending', 'submitted', 'approved', 'not_required') NOT NULL DEFAULT 'pending',
+		reference_no VARCHAR(100),
+		remarks TEXT,
+		updated_by INT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (rule_id) REFERENCES filing_rules(id) ON DELETE SET NULL,
+		FOREIGN KEY (updated_by) REFERENCES users(id),
+		UNIQUE KEY uq_job_filings (job_id, filing)
+	);
+
+	-- E-invoices (GST e-invoice for a job's bill and its IRP registration)
+	CREATE TABLE e_invoices (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL UNIQUE,
+		bill_no VARCHAR(16) NOT NULL,
+		payload JSON NOT NULL,
+		status ENUM('generated', 'registered') NOT NULL DEFAULT 'generated',
+		irn CHAR(64) UNIQUE,
+		ack_no VARCHAR(20),
+		ack_date DATETIME,
+		signed_qr_code TEXT,
+		signed_invoice MEDIUMTEXT,
+		generated_by INT NOT NULL,
+		registered_by INT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (generated_by) REFERENCES users(id),
+		FOREIGN KEY (registered_by) REFERENCES users(id)
+	);
+
 	-- Job Files (File uploads for each stage)
 	CREATE TABLE job_files (
 		id INT AUTO_INCREMENT PRIMARY KEY,
@@ -197,6 +610,52 @@ func (db *DB) Migrate() error {
 		FOREIGN KEY (uploaded_by) REFERENCES users(id)
 	);
 
+	-- Bill Notes (Credit and debit notes correcting issued stage 4 bills)
+	CREATE TABLE bill_notes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id INT NOT NULL,
+		kind ENUM('credit', 'debit') NOT NULL,
+		status ENUM('pending', 'approved', 'rejected') NOT NULL DEFAULT 'pending',
+		note_no VARCHAR(16) UNIQUE,
+		note_date DATE,
+		reason VARCHAR(500) NOT NULL,
+		place_of_supply CHAR(2) NOT NULL,
+		amount_taxable DECIMAL(12,2) NOT NULL,
+		cgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		sgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		igst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		round_off DECIMAL(4,2) NOT NULL DEFAULT 0,
+		total_amount DECIMAL(12,2) NOT NULL,
+		requested_by INT NOT NULL,
+		reviewed_by INT NULL,
+		reviewed_at TIMESTAMP NULL,
+		review_remarks VARCHAR(500),
+		file_id INT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (job_id) REFERENCES pipeline_jobs(id) ON DELETE CASCADE,
+		FOREIGN KEY (requested_by) REFERENCES users(id),
+		FOREIGN KEY (reviewed_by) REFERENCES users(id),
+		FOREIGN KEY (file_id) REFERENCES job_files(id) ON DELETE SET NULL,
+		INDEX idx_bill_notes_job (job_id, status)
+	);
+
+	-- Bill Note Items (Services credited or debited with their computed GST)
+	CREATE TABLE bill_note_items (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		note_id INT NOT NULL,
+		line_no INT NOT NULL,
+		description VARCHAR(300) NOT NULL,
+		sac VARCHAR(8) NOT NULL,
+		gst_rate DECIMAL(5,2) NOT NULL,
+		taxable_amount DECIMAL(12,2) NOT NULL,
+		cgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		sgst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		igst_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+		total_amount DECIMAL(12,2) NOT NULL,
+		FOREIGN KEY (note_id) REFERENCES bill_notes(id) ON DELETE CASCADE,
+		UNIQUE KEY uq_bill_note_items (note_id, line_no)
+	);
+
 	-- Job Updates/Comments (Timeline)
 	CREATE TABLE job_updates (
 		id INT AUTO_INCREMENT PRIMARY KEY,
@@ -212,6 +671,20 @@ func (db *DB) Migrate() error {
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);`
 
+	// Tables kept across restarts may still reference the tables recreated
+	// here, so foreign keys are not checked while they are dropped. The
+	// setting belongs to the session, hence the single connection.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
 	// Split schema into individual statements and execute them
 	statements := strings.Split(schema, ";")
 	for _, stmt := range statements {
@@ -220,25 +693,25 @@ func (db *DB) Migrate() error {
 			continue
 		}
 		
-		log.Printf("Executing: %s", strings.Split(stmt, "\n")[0])
-		_, err := db.Exec(stmt)
+		slog.Debug("Executing migration statement", "statement", strings.Split(stmt, "\n")[0])
+		_, err := conn.ExecContext(ctx, stmt)
 		if err != nil {
-			log.Printf("Error executing statement: %v", err)
+			slog.Error("Error executing migration statement", "error", err)
 			return err
 		}
 	}
 
-	log.Println("Database migrations completed successfully")
+	slog.Info("Database migrations completed successfully")
 	return nil
 }
 
 func (db *DB) Seed() error {
-	log.Println("Seeding database with sample data...")
+	slog.Info("Seeding database with sample data")
 
 	// Clear existing users first
 	_, err := db.Exec("DELETE FROM users")
 	if err != nil {
-		log.Printf("Error clearing users: %v", err)
+		slog.Warn("Error clearing users", "error", err)
 	}
 
 	// Insert sample users for different roles
@@ -253,23 +726,117 @@ func (db *DB) Seed() error {
 	INSERT INTO pipeline_jobs (job_no, current_stage, created_by, assigned_to_stage2, assigned_to_stage3, customer_id) 
 	VALUES ('JOB001', 'stage1', 1, 2, 3, 4);
 
-	INSERT INTO stage1_data (job_id, job_no, job_date, consignee, shipper, commodity, current_status) 
-	VALUES (1, 'JOB001', CURDATE(), 'ABC Import Co.', 'XYZ Export Ltd.', 'Electronics', 'Documents Received');`
+	INSERT INTO parties (name, name_key) VALUES ('ABC Import Co.', 'abc import');
 
-	statements := strings.Split(seedSQL, ";")
-	for _, stmt := range statements {
+	INSERT INTO party_members (user_id, party_id, org_admin) VALUES (4, 1, TRUE);
+
+	INSERT INTO stage1_data (job_id, job_no, job_date, consignee, consignee_key, consignee_party_id, shipper, commodity, current_status) 
+	VALUES (1, 'JOB001', CURDATE(), 'ABC Import Co.', 'abc import', 1, 'XYZ Export Ltd.', 'Electronics', 'Documents Received');`
+
+	if err := execStatements(db.DB, seedSQL); err != nil {
+		slog.Error("Error seeding data", "error", err)
+		return err
+	}
+
+	// Default filing rules, which admins maintain from then on
+	if err := db.seedOnce("seeded_filing_rules", filingRulesSQL); err != nil {
+		slog.Error("Error seeding filing rules", "error", err)
+		return err
+	}
+
+	// Common masters and their aliases, which admins curate from then on
+	if err := db.seedOnce("seeded_masters", mastersSQL); err != nil {
+		slog.Error("Error seeding master data", "error", err)
+		return err
+	}
+
+	slog.Info("Database seeded successfully")
+	return nil
+}
+
+// filingRulesSQL seeds a few common filing rules
+const filingRulesSQL = `
+	INSERT IGNORE INTO filing_rules (hsn_prefix, country, filing, description, documents) VALUES
+	('04', '', 'FSSAI', 'Food import clearance from FSSAI', '["FSSAI import licence", "Product label", "Certificate of analysis"]'),
+	('09', '', 'FSSAI', 'Food import clearance from FSSAI', '["FSSAI import licence", "Product label", "Certificate of analysis"]'),
+	('21', '', 'FSSAI', 'Food import clearance from FSSAI', '["FSSAI import licence", "Product label", "Certificate of analysis"]'),
+	('07', '', 'Plant Quarantine', 'Plant quarantine clearance', '["Phytosanitary certificate", "Import permit"]'),
+	('08', '', 'Plant Quarantine', 'Plant quarantine clearance', '["Phytosanitary certificate", "Import permit"]'),
+	('10', '', 'Plant Quarantine', 'Plant quarantine clearance', '["Phytosanitary certificate", "Import permit"]'),
+	('8471', '', 'BIS', 'BIS registration under the Compulsory Registration Scheme', '["BIS registration certificate", "R-number on label"]'),
+	('8504', '', 'BIS', 'BIS registration under the Compulsory Registration Scheme', '["BIS registration certificate", "R-number on label"]'),
+	('8517', '', 'WPC', 'WPC equipment type approval for wireless equipment', '["ETA certificate", "Technical specifications"]'),
+	('30', '', 'Drug Controller', 'CDSCO import clearance for drugs', '["Import licence (Form 10)", "Registration certificate", "Certificate of analysis"]');`
+
+// mastersSQL seeds the common countries, ports and shipping lines
+const mastersSQL = `
+	INSERT IGNORE INTO master_data (kind, code, name, country, code_key, name_key) VALUES
+	('country', 'IN', 'India', NULL, 'IN', 'INDIA'),
+	('country', 'CN', 'China', NULL, 'CN', 'CHINA'),
+	('country', 'US', 'United States', NULL, 'US', 'UNITEDSTATES'),
+	('country', 'AE', 'United Arab Emirates', NULL, 'AE', 'UNITEDARABEMIRATES'),
+	('country', 'SG', 'Singapore', NULL, 'SG', 'SINGAPORE'),
+	('port', 'INNSA', 'Nhava Sheva', 'IN', 'INNSA', 'NHAVASHEVA'),
+	('port', 'INMUN', 'Mundra', 'IN', 'INMUN', 'MUNDRA'),
+	('port', 'INMAA', 'Chennai', 'IN', 'INMAA', 'CHENNAI'),
+	('port', 'CNSHA', 'Shanghai', 'CN', 'CNSHA', 'SHANGHAI'),
+	('port', 'SGSIN', 'Singapore', 'SG', 'SGSIN', 'SINGAPORE'),
+	('port', 'AEJEA', 'Jebel Ali', 'AE', 'AEJEA', 'JEBELALI'),
+	('shipping_line', 'MSCU', 'Mediterranean Shipping Company', NULL, 'MSCU', 'MEDITERRANEANSHIPPINGCOMPANY'),
+	('shipping_line', 'MAEU', 'Maersk', NULL, 'MAEU', 'MAERSK'),
+	('shipping_line', 'CMDU', 'CMA CGM', NULL, 'CMDU', 'CMACGM');
+
+	INSERT IGNORE INTO master_aliases (master_id, kind, alias, alias_key)
+	SELECT m.id, m.kind, a.alias, a.alias_key
+	FROM master_data m
+	JOIN (
+		SELECT 'country' AS kind, 'US' AS code, 'USA' AS alias, 'USA' AS alias_key
+		UNION ALL SELECT 'country', 'AE', 'UAE', 'UAE'
+		UNION ALL SELECT 'port', 'INNSA', 'JNPT', 'JNPT'
+		UNION ALL SELECT 'port', 'INNSA', 'Jawaharlal Nehru Port', 'JAWAHARLALNEHRUPORT'
+		UNION ALL SELECT 'port', 'INNSA', 'INNSA1', 'INNSA1'
+		UNION ALL SELECT 'port', 'INMUN', 'INMUN1', 'INMUN1'
+		UNION ALL SELECT 'port', 'INMAA', 'Madras', 'MADRAS'
+		UNION ALL SELECT 'port', 'INMAA', 'INMAA1', 'INMAA1'
+		UNION ALL SELECT 'shipping_line', 'MSCU', 'MSC', 'MSC'
+		UNION ALL SELECT 'shipping_line', 'MAEU', 'Maersk Line', 'MAERSKLINE'
+	) a ON a.kind = m.kind AND a.code = m.code;`
+
+// seedOnce runs seedSQL the first time it is called with name, recording
+// name in settings in the same transaction. Data admins maintain is seeded
+// this way, so rows they delete or change are not put back on restart.
+func (db *DB) seedOnce(name, seedSQL string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT IGNORE INTO settings (name, value, updated_by) VALUES (?, 'true', 0)`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := execStatements(tx, seedSQL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execStatements runs each ;-separated statement of script
+func execStatements(db interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, script string) error {
+	for _, stmt := range strings.Split(script, ";") {
 		stmt = strings.TrimSpace(stmt)
 		if stmt == "" {
 			continue
 		}
-		
-		_, err := db.Exec(stmt)
-		if err != nil {
-			log.Printf("Error seeding data: %v", err)
+		if _, err := db.Exec(stmt); err != nil {
 			return err
 		}
 	}
-
-	log.Println("Database seeded successfully")
 	return nil
 } 
\ No newline at end of file
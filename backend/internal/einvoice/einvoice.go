@@ -0,0 +1,424 @@
+// Package einvoice builds GST e-invoices in the INV-01 schema (version
+// 1.1) that the Invoice Registration Portal (IRP) accepts, checks them
+// against the schema's rules and reads the IRP's signed response.
+//
+// Only what a customs broker bills is covered: B2B tax invoices for
+// services, without batches, exports or e-way bills.
+package einvoice
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// SchemaVersion is the INV-01 schema version generated
+const SchemaVersion = "1.1"
+
+// DateLayout is how the schema writes dates
+const DateLayout = "02/01/2006"
+
+// gstRates are the GST rates the schema accepts
+var gstRates = []float64{0, 0.1, 0.25, 1, 1.5, 3, 5, 6, 7.5, 12, 18, 28}
+
+var (
+	docNoPattern = regexp.MustCompile(`^[A-Za-z1-9][A-Za-z0-9/-]{0,15}$`)
+	sacPattern   = regexp.MustCompile(`^[0-9]{4,8}$`)
+)
+
+// Invoice is an INV-01 e-invoice. Field names follow the schema.
+type Invoice struct {
+	Version    string     `json:"Version"`
+	TranDtls   TranDtls   `json:"TranDtls"`
+	DocDtls    DocDtls    `json:"DocDtls"`
+	SellerDtls SellerDtls `json:"SellerDtls"`
+	BuyerDtls  BuyerDtls  `json:"BuyerDtls"`
+	ItemList   []Item     `json:"ItemList"`
+	ValDtls    ValDtls    `json:"ValDtls"`
+}
+
+// TranDtls describes the kind of supply
+type TranDtls struct {
+	TaxSch      string `json:"TaxSch"`
+	SupTyp      string `json:"SupTyp"`
+	RegRev      string `json:"RegRev"`
+	IgstOnIntra string `json:"IgstOnIntra"`
+}
+
+// DocDtls identifies the invoice
+type DocDtls struct {
+	Typ string `json:"Typ"`
+	No  string `json:"No"`
+	Dt  string `json:"Dt"`
+}
+
+// SellerDtls is the supplier
+type SellerDtls struct {
+	Gstin string `json:"Gstin"`
+	LglNm string `json:"LglNm"`
+	TrdNm string `json:"TrdNm,omitempty"`
+	Addr1 string `json:"Addr1"`
+	Addr2 string `json:"Addr2,omitempty"`
+	Loc   string `json:"Loc"`
+	Pin   int    `json:"Pin"`
+	Stcd  string `json:"Stcd"`
+	Ph    string `json:"Ph,omitempty"`
+	Em    string `json:"Em,omitempty"`
+}
+
+// BuyerDtls is the recipient; Pos is the place of supply state code
+type BuyerDtls struct {
+	Gstin string `json:"Gstin"`
+	LglNm string `json:"LglNm"`
+	TrdNm string `json:"TrdNm,omitempty"`
+	Pos   string `json:"Pos"`
+	Addr1 string `json:"Addr1"`
+	Addr2 string `json:"Addr2,omitempty"`
+	Loc   string `json:"Loc"`
+	Pin   int    `json:"Pin"`
+	Stcd  string `json:"Stcd"`
+	Ph    string `json:"Ph,omitempty"`
+	Em    string `json:"Em,omitempty"`
+}
+
+// Item is one invoice line
+type Item struct {
+	SlNo       string  `json:"SlNo"`
+	PrdDesc    string  `json:"PrdDesc,omitempty"`
+	IsServc    string  `json:"IsServc"`
+	HsnCd      string  `json:"HsnCd"`
+	Qty        float64 `json:"Qty,omitempty"`
+	Unit       string  `json:"Unit,omitempty"`
+	UnitPrice  float64 `json:"UnitPrice"`
+	TotAmt     float64 `json:"TotAmt"`
+	Discount   float64 `json:"Discount"`
+	AssAmt     float64 `json:"AssAmt"`
+	GstRt      float64 `json:"GstRt"`
+	IgstAmt    float64 `json:"IgstAmt"`
+	CgstAmt    float64 `json:"CgstAmt"`
+	SgstAmt    float64 `json:"SgstAmt"`
+	CesRt      float64 `json:"CesRt"`
+	CesAmt     float64 `json:"CesAmt"`
+	TotItemVal float64 `json:"TotItemVal"`
+}
+
+// ValDtls are the invoice totals
+type ValDtls struct {
+	AssVal    float64 `json:"AssVal"`
+	CgstVal   float64 `json:"CgstVal"`
+	SgstVal   float64 `json:"SgstVal"`
+	IgstVal   float64 `json:"IgstVal"`
+	CesVal    float64 `json:"CesVal"`
+	Discount  float64 `json:"Discount"`
+	OthChrg   float64 `json:"OthChrg"`
+	RndOffAmt float64 `json:"RndOffAmt"`
+	TotInvVal float64 `json:"TotInvVal"`
+}
+
+// Line is one service billed, before tax
+type Line struct {
+	Description string
+	SAC         string
+	Rate        float64
+	Taxable     float64
+	// Tax is the GST on the line when it was recorded with the bill;
+	// zero means Taxable × Rate
+	Tax float64
+}
+
+// Input is everything an e-invoice is built from
+type Input struct {
+	DocNo   string
+	DocDate time.Time
+	Seller  models.BillingParty
+	Buyer   models.BillingParty
+	Lines   []Line
+	// PlaceOfSupply is a state code; empty means the buyer's state
+	PlaceOfSupply string
+	// RoundOff takes the invoice total to a whole rupee
+	RoundOff float64
+}
+
+// Build assembles the e-invoice and checks it with Validate. The place of
+// supply defaults to the buyer's state; the tax is CGST and SGST when it
+// matches the seller's state and IGST otherwise.
+func Build(in Input) (*Invoice, error) {
+	seller := normalizeParty(in.Seller)
+	buyer := normalizeParty(in.Buyer)
+	sellerState := stateCode(seller.GSTIN)
+	buyerState := stateCode(buyer.GSTIN)
+	pos := in.PlaceOfSupply
+	if pos == "" {
+		pos = buyerState
+	}
+
+	inv := &Invoice{
+		Version: SchemaVersion,
+		TranDtls: TranDtls{
+			TaxSch:      "GST",
+			SupTyp:      "B2B",
+			RegRev:      "N",
+			IgstOnIntra: "N",
+		},
+		DocDtls: DocDtls{
+			Typ: "INV",
+			No:  in.DocNo,
+			Dt:  in.DocDate.Format(DateLayout),
+		},
+		SellerDtls: SellerDtls{
+			Gstin: seller.GSTIN,
+			LglNm: seller.LegalName,
+			TrdNm: seller.TradeName,
+			Addr1: seller.Address1,
+			Addr2: seller.Address2,
+			Loc:   seller.Location,
+			Pin:   pincode(seller.Pincode),
+			Stcd:  sellerState,
+			Ph:    seller.Phone,
+			Em:    seller.Email,
+		},
+		BuyerDtls: BuyerDtls{
+			Gstin: buyer.GSTIN,
+			LglNm: buyer.LegalName,
+			TrdNm: buyer.TradeName,
+			Pos:   pos,
+			Addr1: buyer.Address1,
+			Addr2: buyer.Address2,
+			Loc:   buyer.Location,
+			Pin:   pincode(buyer.Pincode),
+			Stcd:  buyerState,
+			Ph:    buyer.Phone,
+			Em:    buyer.Email,
+		},
+	}
+	if in.DocDate.IsZero() {
+		inv.DocDtls.Dt = ""
+	}
+
+	intra := sellerState != "" && sellerState == pos
+	for i, line := range in.Lines {
+		taxable := Round(line.Taxable)
+		tax := Round(line.Tax)
+		if tax == 0 {
+			tax = Round(taxable * line.Rate / 100)
+		}
+		item := Item{
+			SlNo:      strconv.Itoa(i + 1),
+			PrdDesc:   line.Description,
+			IsServc:   "Y",
+			HsnCd:     line.SAC,
+			UnitPrice: taxable,
+			TotAmt:    taxable,
+			AssAmt:    taxable,
+			GstRt:     line.Rate,
+		}
+		if intra {
+			item.CgstAmt, item.SgstAmt = SplitTax(tax)
+		} else {
+			item.IgstAmt = tax
+		}
+		item.TotItemVal = Round(item.AssAmt + item.IgstAmt + item.CgstAmt + item.SgstAmt + item.CesAmt)
+		inv.ItemList = append(inv.ItemList, item)
+
+		inv.ValDtls.AssVal += item.AssAmt
+		inv.ValDtls.CgstVal += item.CgstAmt
+		inv.ValDtls.SgstVal += item.SgstAmt
+		inv.ValDtls.IgstVal += item.IgstAmt
+		inv.ValDtls.TotInvVal += item.TotItemVal
+	}
+	v := &inv.ValDtls
+	v.AssVal, v.CgstVal, v.SgstVal, v.IgstVal = Round(v.AssVal), Round(v.CgstVal), Round(v.SgstVal), Round(v.IgstVal)
+	v.RndOffAmt = Round(in.RoundOff)
+	v.TotInvVal = Round(v.TotInvVal + v.RndOffAmt)
+
+	if errs := Validate(inv); len(errs) > 0 {
+		return nil, errs
+	}
+	return inv, nil
+}
+
+// SplitTax divides GST into its central and state halves, giving the odd
+// paisa to the state share
+func SplitTax(tax float64) (cgst, sgst float64) {
+	cgst = math.Floor(math.Round(tax*100)/2) / 100
+	return cgst, Round(tax - cgst)
+}
+
+// Round rounds an amount to paise
+func Round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Validate checks an invoice against the INV-01 schema: required fields,
+// lengths and patterns, and that item and invoice totals add up. Field
+// names in the errors are schema paths such as "BuyerDtls.Pin".
+func Validate(inv *Invoice) validation.Errors {
+	var errs validation.Errors
+	str := func(field, value string, min, max int) {
+		switch n := len([]rune(value)); {
+		case n == 0 && min > 0:
+			errs.Add(field, "is required")
+		case n < min:
+			errs.Add(field, fmt.Sprintf("must be at least %d characters", min))
+		case n > max:
+			errs.Add(field, fmt.Sprintf("must be at most %d characters", max))
+		}
+	}
+	gstin := func(field, value string) {
+		if value == "" {
+			errs.Add(field, "is required")
+		} else if err := tradeid.ValidateGSTIN(value); err != nil {
+			errs.Add(field, err.Error())
+		}
+	}
+	state := func(field, value string) {
+		if value == "" {
+			errs.Add(field, "is required")
+		} else if _, ok := tradeid.States[value]; !ok {
+			errs.Add(field, "is not a GST state code")
+		}
+	}
+	pin := func(field string, value int) {
+		if value < 100000 || value > 999999 {
+			errs.Add(field, "must be a 6 digit PIN code")
+		}
+	}
+	amount := func(field string, got, want float64) {
+		if math.Abs(got-want) > 1 {
+			errs.Add(field, fmt.Sprintf("is %.2f but the amounts add up to %.2f", got, want))
+		}
+	}
+
+	if inv.Version != SchemaVersion {
+		errs.Add("Version", "must be "+SchemaVersion)
+	}
+
+	if !docNoPattern.MatchString(inv.DocDtls.No) {
+		errs.Add("DocDtls.No", "must be 1 to 16 letters, digits, / or -, not starting with 0, / or -")
+	}
+	if inv.DocDtls.Dt == "" {
+		errs.Add("DocDtls.Dt", "is required")
+	} else if dt, err := time.Parse(DateLayout, inv.DocDtls.Dt); err != nil {
+		errs.Add("DocDtls.Dt", "must be DD/MM/YYYY")
+	} else if dt.After(time.Now()) {
+		errs.Add("DocDtls.Dt", "cannot be in the future")
+	}
+
+	s := inv.SellerDtls
+	gstin("SellerDtls.Gstin", s.Gstin)
+	str("SellerDtls.LglNm", s.LglNm, 3, 100)
+	str("SellerDtls.Addr1", s.Addr1, 1, 100)
+	str("SellerDtls.Addr2", s.Addr2, 0, 100)
+	str("SellerDtls.Loc", s.Loc, 3, 50)
+	pin("SellerDtls.Pin", s.Pin)
+	state("SellerDtls.Stcd", s.Stcd)
+
+	b := inv.BuyerDtls
+	gstin("BuyerDtls.Gstin", b.Gstin)
+	if b.Gstin != "" && b.Gstin == s.Gstin {
+		errs.Add("BuyerDtls.Gstin", "cannot be the seller's GSTIN")
+	}
+	str("BuyerDtls.LglNm", b.LglNm, 3, 100)
+	state("BuyerDtls.Pos", b.Pos)
+	str("BuyerDtls.Addr1", b.Addr1, 1, 100)
+	str("BuyerDtls.Addr2", b.Addr2, 0, 100)
+	str("BuyerDtls.Loc", b.Loc, 3, 100)
+	pin("BuyerDtls.Pin", b.Pin)
+	state("BuyerDtls.Stcd", b.Stcd)
+
+	if len(inv.ItemList) == 0 {
+		errs.Add("ItemList", "must have at least one item")
+	}
+	if len(inv.ItemList) > 1000 {
+		errs.Add("ItemList", "must have at most 1000 items")
+	}
+	intra := b.Pos == s.Stcd
+	var total ValDtls
+	for i, item := range inv.ItemList {
+		field := fmt.Sprintf("ItemList[%d]", i)
+		str(field+".SlNo", item.SlNo, 1, 6)
+		str(field+".PrdDesc", item.PrdDesc, 0, 300)
+		if !sacPattern.MatchString(item.HsnCd) {
+			errs.Add(field+".HsnCd", "must be 4 to 8 digits")
+		} else if item.IsServc == "Y" && !strings.HasPrefix(item.HsnCd, "99") {
+			errs.Add(field+".HsnCd", "must be a SAC code (starting 99) for a service")
+		}
+		if !validRate(item.GstRt) {
+			errs.Add(field+".GstRt", fmt.Sprintf("%v is not a GST rate", item.GstRt))
+		}
+		if item.AssAmt < 0 {
+			errs.Add(field+".AssAmt", "cannot be negative")
+		}
+		amount(field+".AssAmt", item.AssAmt, item.TotAmt-item.Discount)
+		if intra && item.IgstAmt != 0 {
+			errs.Add(field+".IgstAmt", "must be 0 when the place of supply is the seller's state")
+		}
+		if !intra && (item.CgstAmt != 0 || item.SgstAmt != 0) {
+			errs.Add(field+".CgstAmt", "CGST and SGST must be 0 when the place of supply is another state")
+		}
+		amount(field+".TotItemVal", item.TotItemVal, item.AssAmt+item.IgstAmt+item.CgstAmt+item.SgstAmt+item.CesAmt)
+
+		total.AssVal += item.AssAmt
+		total.CgstVal += item.CgstAmt
+		total.SgstVal += item.SgstAmt
+		total.IgstVal += item.IgstAmt
+		total.CesVal += item.CesAmt
+		total.TotInvVal += item.TotItemVal
+	}
+
+	v := inv.ValDtls
+	amount("ValDtls.AssVal", v.AssVal, total.AssVal)
+	amount("ValDtls.CgstVal", v.CgstVal, total.CgstVal)
+	amount("ValDtls.SgstVal", v.SgstVal, total.SgstVal)
+	amount("ValDtls.IgstVal", v.IgstVal, total.IgstVal)
+	amount("ValDtls.CesVal", v.CesVal, total.CesVal)
+	amount("ValDtls.TotInvVal", v.TotInvVal, total.TotInvVal-v.Discount+v.OthChrg+v.RndOffAmt)
+	if v.TotInvVal <= 0 && len(inv.ItemList) > 0 {
+		errs.Add("ValDtls.TotInvVal", "must be more than zero")
+	}
+	return errs
+}
+
+func validRate(rate float64) bool {
+	for _, r := range gstRates {
+		if rate == r {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeParty(p models.BillingParty) models.BillingParty {
+	clean := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	p.GSTIN = tradeid.Normalize(p.GSTIN)
+	p.LegalName = clean(p.LegalName)
+	p.TradeName = clean(p.TradeName)
+	p.Address1 = clean(p.Address1)
+	p.Address2 = clean(p.Address2)
+	p.Location = clean(p.Location)
+	p.Pincode = strings.ReplaceAll(p.Pincode, " ", "")
+	p.Phone = clean(p.Phone)
+	p.Email = strings.TrimSpace(p.Email)
+	return p
+}
+
+// stateCode is the state encoded in a GSTIN, which for a registered buyer
+// is also the place of supply of services billed to them
+func stateCode(gstin string) string {
+	if len(gstin) < 2 {
+		return ""
+	}
+	return gstin[:2]
+}
+
+func pincode(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
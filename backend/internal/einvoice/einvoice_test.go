@@ -0,0 +1,255 @@
+package einvoice
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or rewrites it with -update
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+func testInput() Input {
+	return Input{
+		DocNo:   "MD/26-27/0042",
+		DocDate: time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC),
+		Seller: models.BillingParty{
+			GSTIN: "27aapfu0939f1zv", LegalName: "Maydiv  Logistics", Address1: "Plot 12, Sector 19",
+			Location: "Navi Mumbai", Pincode: "400 703", Email: "billing@maydiv.in",
+		},
+		Buyer: models.BillingParty{
+			GSTIN: "29AAGCB7383J1Z4", LegalName: "ABC Import Co.", Address1: "4th Cross, Peenya",
+			Location: "Bengaluru", Pincode: "560058",
+		},
+		Lines: []Line{
+			{Description: "Customs clearance", SAC: "996712", Rate: 18, Taxable: 4500},
+			{Description: "Transport", SAC: "996791", Rate: 5, Taxable: 1200.5},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name string
+		in   func() Input
+	}{
+		{name: "interstate", in: testInput},
+		{name: "intrastate", in: func() Input {
+			in := testInput()
+			in.Buyer.GSTIN = "27AAACR5055K1Z7"
+			in.Lines[1].Tax = 60.03
+			return in
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := Build(tt.in())
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			got, err := json.MarshalIndent(inv, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.name+".golden", append(got, '\n'))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Invoice)
+		fields []string
+	}{
+		{name: "as built", change: func(*Invoice) {}},
+		{name: "wrong version", change: func(inv *Invoice) { inv.Version = "1.03" }, fields: []string{"Version"}},
+		{
+			name:   "document number and date",
+			change: func(inv *Invoice) { inv.DocDtls.No = "0042"; inv.DocDtls.Dt = "2026-04-15" },
+			fields: []string{"DocDtls.No", "DocDtls.Dt"},
+		},
+		{name: "future date", change: func(inv *Invoice) { inv.DocDtls.Dt = "01/01/2999" }, fields: []string{"DocDtls.Dt"}},
+		{
+			name: "buyer details",
+			change: func(inv *Invoice) {
+				inv.BuyerDtls.Gstin = inv.SellerDtls.Gstin
+				inv.BuyerDtls.LglNm = "AB"
+				inv.BuyerDtls.Pin = 5600
+				inv.BuyerDtls.Stcd = "00"
+			},
+			fields: []string{"BuyerDtls.Gstin", "BuyerDtls.LglNm", "BuyerDtls.Pin", "BuyerDtls.Stcd"},
+		},
+		{
+			name:   "bad GSTIN",
+			change: func(inv *Invoice) { inv.SellerDtls.Gstin = "27AAPFU0939F1ZA" },
+			fields: []string{"SellerDtls.Gstin"},
+		},
+		{
+			name: "item codes and rates",
+			change: func(inv *Invoice) {
+				inv.ItemList[0].HsnCd = "8471"
+				inv.ItemList[1].HsnCd = "99"
+				inv.ItemList[1].GstRt = 15
+			},
+			fields: []string{"ItemList[0].HsnCd", "ItemList[1].HsnCd", "ItemList[1].GstRt"},
+		},
+		{
+			name:   "CGST on an interstate supply",
+			change: func(inv *Invoice) { inv.ItemList[0].CgstAmt, inv.ItemList[0].IgstAmt = 810, 0 },
+			fields: []string{"ItemList[0].CgstAmt", "ValDtls.CgstVal", "ValDtls.IgstVal"},
+		},
+		{
+			name:   "totals that do not add up",
+			change: func(inv *Invoice) { inv.ItemList[0].TotItemVal += 5; inv.ValDtls.TotInvVal -= 2 },
+			fields: []string{"ItemList[0].TotItemVal", "ValDtls.TotInvVal"},
+		},
+		{
+			name:   "rounding within a rupee",
+			change: func(inv *Invoice) { inv.ValDtls.AssVal += 0.99; inv.ValDtls.TotInvVal += 0.5 },
+		},
+		{name: "no items", change: func(inv *Invoice) { inv.ItemList = nil; inv.ValDtls = ValDtls{} }, fields: []string{"ItemList"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := Build(testInput())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(inv)
+			var got []string
+			for _, fe := range Validate(inv) {
+				got = append(got, fe.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("Validate rejected %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	inv, err := Build(testInput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := StandIn(inv, time.Date(2026, 4, 15, 11, 30, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enveloped, err := json.Marshal(map[string]string{"Status": "1", "Data": string(reply)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"reply": reply, "enveloped reply": enveloped} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := ParseResponse(data)
+			if err != nil {
+				t.Fatalf("ParseResponse failed: %v", err)
+			}
+			if resp.Irn != IRN(inv) || resp.Status != "ACT" || resp.AckDt.Format(AckDateLayout) != "2026-04-15 11:30:00" {
+				t.Errorf("response %s %s %v, want %s ACT 2026-04-15 11:30:00", resp.Irn, resp.Status, resp.AckDt, IRN(inv))
+			}
+			if err := resp.CheckMatches(inv); err != nil {
+				t.Errorf("CheckMatches: %v", err)
+			}
+			other := *inv
+			other.DocDtls.No = "MD/26-27/0043"
+			if err := resp.CheckMatches(&other); err == nil {
+				t.Error("CheckMatches accepted the response for another invoice")
+			}
+		})
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	for _, name := range []string{"rejected.json", "incomplete.json"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ParseResponse(data)
+			if err == nil {
+				t.Fatal("ParseResponse accepted the reply")
+			}
+			golden(t, name+".golden", []byte(err.Error()+"\n"))
+		})
+	}
+}
+
+func TestIRN(t *testing.T) {
+	inv := &Invoice{
+		SellerDtls: SellerDtls{Gstin: "27AAPFU0939F1ZV"},
+		DocDtls:    DocDtls{Typ: "INV", No: "md/26-27/0042", Dt: "15/04/2026"},
+	}
+	irn := IRN(inv)
+	if len(irn) != 64 {
+		t.Fatalf("IRN %q is not 64 characters", irn)
+	}
+	inv.DocDtls.No = "MD/26-27/0042"
+	if IRN(inv) != irn {
+		t.Error("the IRN depends on the case of the document number")
+	}
+	inv.DocDtls.Dt = "15/03/2026"
+	if IRN(inv) == irn {
+		t.Error("the IRN is the same in another financial year")
+	}
+}
+
+func TestFinancialYear(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC), "2025-26"},
+		{time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), "2026-27"},
+		{time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC), "2099-00"},
+	}
+	for _, tt := range tests {
+		if got := FinancialYear(tt.date); got != tt.want {
+			t.Errorf("FinancialYear(%s) = %s, want %s", tt.date.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
+
+func TestSplitTax(t *testing.T) {
+	tests := []struct {
+		tax, cgst, sgst float64
+	}{
+		{180, 90, 90},
+		{60.03, 30.01, 30.02},
+		{0.01, 0, 0.01},
+	}
+	for _, tt := range tests {
+		if cgst, sgst := SplitTax(tt.tax); cgst != tt.cgst || sgst != tt.sgst {
+			t.Errorf("SplitTax(%v) = %v, %v, want %v, %v", tt.tax, cgst, sgst, tt.cgst, tt.sgst)
+		}
+	}
+}
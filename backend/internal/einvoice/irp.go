@@ -0,0 +1,265 @@
+package einvoice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/validation"
+)
+
+// AckDateLayout is how the IRP writes acknowledgement dates
+const AckDateLayout = "2006-01-02 15:04:05"
+
+// Response is the IRP's reply for a registered invoice
+type Response struct {
+	AckNo         string
+	AckDt         time.Time
+	Irn           string
+	SignedInvoice string
+	SignedQRCode  string
+	Status        string
+}
+
+// rawResponse is the decrypted IRP reply; AckNo arrives as a number
+type rawResponse struct {
+	AckNo         json.RawMessage `json:"AckNo"`
+	AckDt         string          `json:"AckDt"`
+	Irn           string          `json:"Irn"`
+	SignedInvoice string          `json:"SignedInvoice"`
+	SignedQRCode  string          `json:"SignedQRCode"`
+	Status        string          `json:"Status"`
+}
+
+// envelope is the IRP API wrapper around a reply. Data holds the reply,
+// either as an object or as a JSON string.
+type envelope struct {
+	Data         json.RawMessage `json:"Data"`
+	ErrorDetails []struct {
+		ErrorCode    string `json:"ErrorCode"`
+		ErrorMessage string `json:"ErrorMessage"`
+	} `json:"ErrorDetails"`
+}
+
+// ParseResponse reads an IRP reply, with or without its API envelope.
+// A reply reporting errors, or missing the IRN, acknowledgement or signed
+// QR code, is rejected.
+func ParseResponse(data []byte) (*Response, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("not JSON: %v", err)
+	}
+	if len(env.ErrorDetails) > 0 {
+		messages := make([]string, len(env.ErrorDetails))
+		for i, e := range env.ErrorDetails {
+			messages[i] = e.ErrorCode + " " + e.ErrorMessage
+		}
+		return nil, fmt.Errorf("the IRP rejected the invoice: %s", strings.Join(messages, "; "))
+	}
+	if len(env.Data) > 0 && !bytes.Equal(env.Data, []byte("null")) {
+		data = env.Data
+		var inner string
+		if json.Unmarshal(data, &inner) == nil {
+			data = []byte(inner)
+		}
+	}
+
+	var raw rawResponse
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("reading reply: %v", err)
+	}
+	resp := &Response{
+		AckNo:         strings.Trim(string(raw.AckNo), `"`),
+		Irn:           strings.ToLower(strings.TrimSpace(raw.Irn)),
+		SignedInvoice: strings.TrimSpace(raw.SignedInvoice),
+		SignedQRCode:  strings.TrimSpace(raw.SignedQRCode),
+		Status:        raw.Status,
+	}
+
+	var errs validation.Errors
+	if b, err := hex.DecodeString(resp.Irn); err != nil || len(b) != sha256.Size {
+		errs.Add("Irn", "must be 64 hexadecimal characters")
+	}
+	if resp.AckNo == "" || resp.AckNo == "null" || strings.Trim(resp.AckNo, "0123456789") != "" {
+		errs.Add("AckNo", "must be a number")
+	}
+	ackDt, err := time.ParseInLocation(AckDateLayout, raw.AckDt, time.Local)
+	if err != nil {
+		errs.Add("AckDt", "must be YYYY-MM-DD HH:MM:SS")
+	}
+	resp.AckDt = ackDt
+	if resp.SignedQRCode == "" {
+		errs.Add("SignedQRCode", "is required")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return resp, nil
+}
+
+// signedDocument is what the signed invoice and QR code say about the
+// document they were issued for
+type signedDocument struct {
+	Irn         string
+	SellerGstin string
+	DocNo       string
+	DocDt       string
+}
+
+// CheckMatches reports whether the response was issued for inv, comparing
+// the document named in the signed QR code (and the signed invoice when
+// present) with the invoice. The signatures themselves are not verified.
+func (r *Response) CheckMatches(inv *Invoice) error {
+	var errs validation.Errors
+	check := func(field string, doc signedDocument) {
+		if doc.Irn != "" && !strings.EqualFold(doc.Irn, r.Irn) {
+			errs.Add(field, "was issued for a different IRN")
+		}
+		if doc.SellerGstin != inv.SellerDtls.Gstin {
+			errs.Add(field, fmt.Sprintf("is for seller %s, not %s", doc.SellerGstin, inv.SellerDtls.Gstin))
+		}
+		if !strings.EqualFold(doc.DocNo, inv.DocDtls.No) {
+			errs.Add(field, fmt.Sprintf("is for invoice %s, not %s", doc.DocNo, inv.DocDtls.No))
+		}
+		if doc.DocDt != inv.DocDtls.Dt {
+			errs.Add(field, fmt.Sprintf("is dated %s, not %s", doc.DocDt, inv.DocDtls.Dt))
+		}
+	}
+
+	qr, err := signedPayload(r.SignedQRCode)
+	if err != nil {
+		return validation.Errors{{Field: "SignedQRCode", Message: err.Error()}}
+	}
+	var qrDoc signedDocument
+	if err := json.Unmarshal(qr, &qrDoc); err != nil {
+		return validation.Errors{{Field: "SignedQRCode", Message: "does not hold QR code data"}}
+	}
+	check("SignedQRCode", qrDoc)
+
+	if r.SignedInvoice != "" {
+		signed, err := signedPayload(r.SignedInvoice)
+		if err != nil {
+			return validation.Errors{{Field: "SignedInvoice", Message: err.Error()}}
+		}
+		var signedInv Invoice
+		if err := json.Unmarshal(signed, &signedInv); err != nil {
+			return validation.Errors{{Field: "SignedInvoice", Message: "does not hold an invoice"}}
+		}
+		check("SignedInvoice", signedDocument{
+			SellerGstin: signedInv.SellerDtls.Gstin,
+			DocNo:       signedInv.DocDtls.No,
+			DocDt:       signedInv.DocDtls.Dt,
+		})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// signedPayload returns the data claim of a JWT issued by the IRP. The
+// claim holds JSON, usually as a string.
+func signedPayload(token string) (json.RawMessage, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("is not a signed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("has an unreadable payload")
+	}
+	var claims struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || len(claims.Data) == 0 {
+		return nil, errors.New("has no data claim")
+	}
+	var inner string
+	if json.Unmarshal(claims.Data, &inner) == nil {
+		return json.RawMessage(inner), nil
+	}
+	return claims.Data, nil
+}
+
+// IRN is the Invoice Reference Number of a document: the SHA-256 of the
+// seller's GSTIN, the financial year, the document type and number
+func IRN(inv *Invoice) string {
+	dt, _ := time.Parse(DateLayout, inv.DocDtls.Dt)
+	sum := sha256.Sum256([]byte(inv.SellerDtls.Gstin + FinancialYear(dt) + inv.DocDtls.Typ + strings.ToUpper(inv.DocDtls.No)))
+	return hex.EncodeToString(sum[:])
+}
+
+// FinancialYear names the April to March year t falls in, e.g. "2026-27"
+func FinancialYear(t time.Time) string {
+	year := t.Year()
+	if t.Month() < time.April {
+		year--
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// StandIn registers an invoice the way the IRP would, for use when the
+// portal is not available, e.g. in development. The reply has the IRP's
+// shape, but its tokens are unsigned and it is not a valid e-invoice.
+func StandIn(inv *Invoice, now time.Time) ([]byte, error) {
+	if errs := Validate(inv); len(errs) > 0 {
+		return nil, errs
+	}
+	irn := IRN(inv)
+	ackDt := now.Format(AckDateLayout)
+
+	signedInvoice, err := unsignedToken(inv)
+	if err != nil {
+		return nil, err
+	}
+	mainHSN := ""
+	if len(inv.ItemList) > 0 {
+		mainHSN = inv.ItemList[0].HsnCd
+	}
+	qr, err := unsignedToken(map[string]interface{}{
+		"SellerGstin": inv.SellerDtls.Gstin,
+		"BuyerGstin":  inv.BuyerDtls.Gstin,
+		"DocNo":       inv.DocDtls.No,
+		"DocTyp":      inv.DocDtls.Typ,
+		"DocDt":       inv.DocDtls.Dt,
+		"TotInvVal":   inv.ValDtls.TotInvVal,
+		"ItemCnt":     len(inv.ItemList),
+		"MainHsnCode": mainHSN,
+		"Irn":         irn,
+		"IrnDt":       ackDt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"AckNo":         100000000000000 + now.UnixMilli()%900000000000000,
+		"AckDt":         ackDt,
+		"Irn":           irn,
+		"SignedInvoice": signedInvoice,
+		"SignedQRCode":  qr,
+		"Status":        "ACT",
+		"Remarks":       "Issued by the local IRP stand-in; not a registered e-invoice",
+	}, "", "  ")
+}
+
+// unsignedToken wraps v as the data claim of a JWT with no signature
+func unsignedToken(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]string{"data": string(data), "iss": "IRP stand-in"})
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(claims) + ".", nil
+}
@@ -0,0 +1,161 @@
+// Package gst computes the GST on bills for services. Supplies within the
+// supplier's state pay CGST and SGST at half the rate each; supplies to
+// another state pay IGST. Tax is rounded to the paisa on each line and the
+// invoice total to the nearest rupee.
+package gst
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Rates are the GST rates services are billed at
+var Rates = []float64{0, 5, 12, 18, 28}
+
+// SAC codes for the services a customs broker bills most
+const (
+	SACTransport = "996791" // Goods transport agency services for road transport
+	SACClearance = "996712" // Customs house agency services
+)
+
+// Line is one service billed, before tax
+type Line struct {
+	Description string
+	SAC         string
+	Rate        float64
+	Taxable     float64
+}
+
+// ComputedLine is a line with its tax
+type ComputedLine struct {
+	Line
+	CGST  float64
+	SGST  float64
+	IGST  float64
+	Total float64
+}
+
+// Tax is the line's CGST, SGST and IGST together
+func (l ComputedLine) Tax() float64 {
+	return Round(l.CGST + l.SGST + l.IGST)
+}
+
+// Bill is a computed bill
+type Bill struct {
+	PlaceOfSupply string
+	Intrastate    bool
+	Lines         []ComputedLine
+	Taxable       float64
+	CGST          float64
+	SGST          float64
+	IGST          float64
+	// RoundOff takes the sum of the lines to Total, a whole rupee amount
+	RoundOff float64
+	Total    float64
+}
+
+// Tax is the bill's CGST, SGST and IGST together
+func (b *Bill) Tax() float64 {
+	return Round(b.CGST + b.SGST + b.IGST)
+}
+
+// TaxAt is the tax on the lines billed at rate
+func (b *Bill) TaxAt(rate float64) float64 {
+	var tax float64
+	for _, l := range b.Lines {
+		if l.Rate == rate {
+			tax += l.Tax()
+		}
+	}
+	return Round(tax)
+}
+
+// Compute works out the tax on each line and the bill's totals.
+// supplierState and placeOfSupply are GSTIN state codes such as "27".
+func Compute(supplierState, placeOfSupply string, lines []Line) *Bill {
+	b := &Bill{
+		PlaceOfSupply: placeOfSupply,
+		Intrastate:    supplierState == placeOfSupply,
+	}
+	var total float64
+	for _, line := range lines {
+		l := ComputedLine{Line: line}
+		l.Taxable = Round(line.Taxable)
+		if b.Intrastate {
+			l.CGST = Round(l.Taxable * line.Rate / 200)
+			l.SGST = l.CGST
+		} else {
+			l.IGST = Round(l.Taxable * line.Rate / 100)
+		}
+		l.Total = Round(l.Taxable + l.CGST + l.SGST + l.IGST)
+		b.Lines = append(b.Lines, l)
+
+		b.Taxable += l.Taxable
+		b.CGST += l.CGST
+		b.SGST += l.SGST
+		b.IGST += l.IGST
+		total += l.Total
+	}
+	b.Taxable, b.CGST, b.SGST, b.IGST = Round(b.Taxable), Round(b.CGST), Round(b.SGST), Round(b.IGST)
+	b.Total = math.Round(total)
+	b.RoundOff = Round(b.Total - total)
+	return b
+}
+
+// Totals builds a bill from totals alone, when they were entered by hand
+// rather than computed from lines. Within the state the tax is halved
+// between CGST and SGST, with any odd paisa going to SGST.
+func Totals(intrastate bool, taxable, tax float64) *Bill {
+	b := &Bill{Intrastate: intrastate, Taxable: Round(taxable)}
+	tax = Round(tax)
+	if intrastate {
+		b.CGST = math.Floor(math.Round(tax*100)/2) / 100
+		b.SGST = Round(tax - b.CGST)
+	} else {
+		b.IGST = tax
+	}
+	exact := Round(b.Taxable + tax)
+	b.Total = math.Round(exact)
+	b.RoundOff = Round(b.Total - exact)
+	return b
+}
+
+// LinesFromTotals turns a bill entered as a taxable amount and the tax
+// charged at 5% and 18% into lines: transport at 5% and customs clearance
+// at 18%. The taxable amounts implied by the tax must add up to taxable
+// within a rupee; any difference goes to the 18% line.
+func LinesFromTotals(taxable, tax5, tax18 float64) ([]Line, error) {
+	taxable5 := Round(tax5 / 0.05)
+	taxable18 := Round(tax18 / 0.18)
+	if math.Abs(taxable5+taxable18-taxable) > 1 {
+		return nil, fmt.Errorf("does not match the GST charged, which is due on %.2f at 5%% and %.2f at 18%%", taxable5, taxable18)
+	}
+	switch {
+	case tax18 <= 0:
+		taxable5 = taxable
+	case tax5 <= 0:
+		taxable18 = taxable
+	default:
+		taxable18 = Round(taxable - taxable5)
+	}
+
+	var lines []Line
+	if tax5 > 0 {
+		lines = append(lines, Line{Description: "Transportation charges", SAC: SACTransport, Rate: 5, Taxable: taxable5})
+	}
+	if tax18 > 0 {
+		lines = append(lines, Line{Description: "Customs clearance and agency charges", SAC: SACClearance, Rate: 18, Taxable: taxable18})
+	}
+	return lines, nil
+}
+
+// ValidRate reports whether services can be billed at rate
+func ValidRate(rate float64) bool {
+	return slices.Contains(Rates, rate)
+}
+
+// Round rounds an amount to paise
+func Round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
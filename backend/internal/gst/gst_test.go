@@ -0,0 +1,158 @@
+package gst
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name                  string
+		placeOfSupply         string
+		lines                 []Line
+		intrastate            bool
+		cgst, sgst, igst      float64
+		taxable, roundOff, tl float64
+	}{
+		{
+			name: "within the state", placeOfSupply: "27",
+			lines:      []Line{{Rate: 18, Taxable: 1000}},
+			intrastate: true, cgst: 90, sgst: 90,
+			taxable: 1000, tl: 1180,
+		},
+		{
+			name: "to another state", placeOfSupply: "29",
+			lines: []Line{{Rate: 18, Taxable: 1000}},
+			igst:  180, taxable: 1000, tl: 1180,
+		},
+		{
+			// 9% of 1000.33 is 90.0297: each half rounds to the paisa
+			name: "paisa rounding within the state", placeOfSupply: "27",
+			lines:      []Line{{Rate: 18, Taxable: 1000.33}},
+			intrastate: true, cgst: 90.03, sgst: 90.03,
+			taxable: 1000.33, roundOff: -0.39, tl: 1180,
+		},
+		{
+			name: "paisa rounding to another state", placeOfSupply: "29",
+			lines: []Line{{Rate: 18, Taxable: 1000.33}},
+			igst:  180.06, taxable: 1000.33, roundOff: -0.39, tl: 1180,
+		},
+		{
+			name: "mixed rates", placeOfSupply: "27",
+			lines:      []Line{{Rate: 5, Taxable: 2000.5}, {Rate: 18, Taxable: 999.99}},
+			intrastate: true, cgst: 140.01, sgst: 140.01,
+			taxable: 3000.49, roundOff: 0.49, tl: 3281,
+		},
+		{name: "no lines", placeOfSupply: "27", intrastate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Compute("27", tt.placeOfSupply, tt.lines)
+			if b.Intrastate != tt.intrastate {
+				t.Errorf("Intrastate = %v, want %v", b.Intrastate, tt.intrastate)
+			}
+			got := []float64{b.Taxable, b.CGST, b.SGST, b.IGST, b.RoundOff, b.Total}
+			want := []float64{tt.taxable, tt.cgst, tt.sgst, tt.igst, tt.roundOff, tt.tl}
+			if !slices.Equal(got, want) {
+				t.Errorf("taxable, CGST, SGST, IGST, round off, total = %v, want %v", got, want)
+			}
+			for _, l := range b.Lines {
+				if l.Total != Round(l.Taxable+l.Tax()) {
+					t.Errorf("line total %v is not its taxable %v plus tax %v", l.Total, l.Taxable, l.Tax())
+				}
+			}
+		})
+	}
+}
+
+func TestTaxAt(t *testing.T) {
+	b := Compute("27", "29", []Line{{Rate: 5, Taxable: 1000}, {Rate: 18, Taxable: 500}, {Rate: 5, Taxable: 100.1}})
+	if got := b.TaxAt(5); got != 55.01 {
+		t.Errorf("TaxAt(5) = %v, want 55.01", got)
+	}
+	if got := b.TaxAt(18); got != 90 {
+		t.Errorf("TaxAt(18) = %v, want 90", got)
+	}
+	if got := b.TaxAt(12); got != 0 {
+		t.Errorf("TaxAt(12) = %v, want 0", got)
+	}
+}
+
+func TestTotals(t *testing.T) {
+	tests := []struct {
+		name                    string
+		intrastate              bool
+		taxable, tax            float64
+		cgst, sgst, igst, total float64
+		roundOff                float64
+	}{
+		{name: "even tax within the state", intrastate: true, taxable: 1000, tax: 180, cgst: 90, sgst: 90, total: 1180},
+		{name: "odd paisa goes to SGST", intrastate: true, taxable: 1000, tax: 180.05, cgst: 90.02, sgst: 90.03, total: 1180, roundOff: -0.05},
+		{name: "to another state", taxable: 1000, tax: 180.05, igst: 180.05, total: 1180, roundOff: -0.05},
+		{name: "rounds up to the rupee", intrastate: true, taxable: 1000.4, tax: 180.11, cgst: 90.05, sgst: 90.06, total: 1181, roundOff: 0.49},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Totals(tt.intrastate, tt.taxable, tt.tax)
+			got := []float64{b.CGST, b.SGST, b.IGST, b.Total, b.RoundOff}
+			want := []float64{tt.cgst, tt.sgst, tt.igst, tt.total, tt.roundOff}
+			if !slices.Equal(got, want) {
+				t.Errorf("CGST, SGST, IGST, total, round off = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLinesFromTotals(t *testing.T) {
+	tests := []struct {
+		name                 string
+		taxable, tax5, tax18 float64
+		want                 []Line
+		err                  bool
+	}{
+		{
+			name: "both rates", taxable: 3000, tax5: 50, tax18: 360,
+			want: []Line{
+				{Description: "Transportation charges", SAC: SACTransport, Rate: 5, Taxable: 1000},
+				{Description: "Customs clearance and agency charges", SAC: SACClearance, Rate: 18, Taxable: 2000},
+			},
+		},
+		{
+			// 360.05 implies 2000.28 at 18%; the 18% line takes the taxable left over
+			name: "difference goes to the 18% line", taxable: 3000.5, tax5: 50, tax18: 360.05,
+			want: []Line{
+				{Description: "Transportation charges", SAC: SACTransport, Rate: 5, Taxable: 1000},
+				{Description: "Customs clearance and agency charges", SAC: SACClearance, Rate: 18, Taxable: 2000.5},
+			},
+		},
+		{
+			name: "transport only", taxable: 1000.4, tax5: 50,
+			want: []Line{{Description: "Transportation charges", SAC: SACTransport, Rate: 5, Taxable: 1000.4}},
+		},
+		{
+			name: "clearance only", taxable: 2000.6, tax18: 360,
+			want: []Line{{Description: "Customs clearance and agency charges", SAC: SACClearance, Rate: 18, Taxable: 2000.6}},
+		},
+		{name: "tax does not match taxable", taxable: 5000, tax5: 50, tax18: 360, err: true},
+		{name: "no tax", taxable: 1000, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := LinesFromTotals(tt.taxable, tt.tax5, tt.tax18)
+			if (err != nil) != tt.err {
+				t.Fatalf("LinesFromTotals error = %v, want error %v", err, tt.err)
+			}
+			if !slices.Equal(lines, tt.want) {
+				t.Errorf("LinesFromTotals = %+v, want %+v", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidRate(t *testing.T) {
+	for rate, want := range map[float64]bool{0: true, 5: true, 18: true, 28: true, 3: false, 9: false} {
+		if got := ValidRate(rate); got != want {
+			t.Errorf("ValidRate(%v) = %v, want %v", rate, got, want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+
+	"github.com/gorilla/sessions"
+)
+
+// accessControl identifies the session user and decides which jobs they
+// may see. Handlers that serve job data embed it.
+type accessControl struct {
+	pipelineRepo *repository.PipelineRepository
+	userRepo     *repository.UserRepository
+	sessionStore *sessions.CookieStore
+}
+
+// newAccessControl creates the access checks shared by job handlers
+func newAccessControl(pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) accessControl {
+	return accessControl{
+		pipelineRepo: pipelineRepo,
+		userRepo:     userRepo,
+		sessionStore: sessionStore,
+	}
+}
+
+// hasJobAccess reports whether the session user may see the job
+func (a *accessControl) hasJobAccess(r *http.Request, jobID int) bool {
+	userID := a.getUserID(r)
+	if userID == 0 {
+		return false
+	}
+
+	user, err := a.userRepo.GetByID(userID)
+	if err != nil {
+		return false
+	}
+
+	job, err := a.pipelineRepo.GetJobByID(r.Context(), jobID)
+	if err != nil {
+		return false
+	}
+	return jobAccess(user, job, requestStage(r))
+}
+
+// jobAccess reports whether a user may see the job, or the given stage of
+// it when stage is not ""
+func jobAccess(user *models.User, job *models.PipelineJobResponse, stage string) bool {
+	// Admin and subadmin have access to all jobs
+	if user.IsAdmin || user.Role == "subadmin" {
+		return true
+	}
+
+	// Check role-based access
+	switch user.Role {
+	case "stage2_employee":
+		return job.AssignedToStage2 != nil && *job.AssignedToStage2 == user.ID
+	case "stage3_employee":
+		return job.AssignedToStage3 != nil && *job.AssignedToStage3 == user.ID
+	case "customer":
+		return ownsJob(user, job) && (stage == "" || user.SeesStage(stage))
+	default:
+		return false
+	}
+}
+
+// hasStageAccess reports whether the session user may see a stage of the
+// job, its files for instance
+func (a *accessControl) hasStageAccess(r *http.Request, jobID int, stage string) bool {
+	if !a.hasJobAccess(r, jobID) {
+		return false
+	}
+	user, err := a.userRepo.GetByID(a.getUserID(r))
+	return err == nil && user.SeesStage(stage)
+}
+
+// ownsJob reports whether a customer has the job, because it names them as
+// its customer or their organization as its consignee
+func ownsJob(user *models.User, job *models.PipelineJobResponse) bool {
+	if job.CustomerID != nil && *job.CustomerID == user.ID {
+		return true
+	}
+	return user.PartyID != nil && job.Stage1 != nil && job.Stage1.ConsigneePartyID != nil &&
+		*job.Stage1.ConsigneePartyID == *user.PartyID
+}
+
+// jobStageRoutes maps the routes under a job to the stage whose data they
+// serve, in the order the server dispatches them
+var jobStageRoutes = []struct{ segment, stage string }{
+	{"/duty", "stage2"},
+	{"/filings", "stage2"},
+	{"/bill-of-entry", "stage2"},
+	{"/einvoice", "stage4"},
+	{"/payments", "stage4"},
+	{"/deposit", "stage4"},
+	{"/demurrage", "stage3"},
+	{"/containers", "stage3"},
+	{"/ledger", "stage4"},
+	{"/bill-notes", "stage4"},
+	{"/invoice", "stage4"},
+	{"/stage2", "stage2"},
+	{"/stage3", "stage3"},
+	{"/stage4", "stage4"},
+}
+
+// requestStage returns the stage a /api/pipeline/jobs/{id}/... request
+// serves, or "" for the job itself
+func requestStage(r *http.Request) string {
+	if !strings.HasPrefix(r.URL.Path, "/api/pipeline/jobs/") {
+		return ""
+	}
+	for _, route := range jobStageRoutes {
+		if strings.Contains(r.URL.Path, route.segment) {
+			return route.stage
+		}
+	}
+	return ""
+}
+
+// hideStages removes from a job the stages, and their updates, that a
+// customer restricted to some stages may not see
+func hideStages(user *models.User, job *models.PipelineJobResponse) {
+	if len(user.Stages) == 0 {
+		return
+	}
+	if !user.SeesStage("stage1") {
+		job.Stage1 = nil
+	}
+	if !user.SeesStage("stage2") {
+		job.Stage2 = nil
+	}
+	if !user.SeesStage("stage3") {
+		job.Stage3 = nil
+		job.Containers = nil
+	}
+	if !user.SeesStage("stage4") {
+		job.Stage4 = nil
+	}
+	updates := job.Updates[:0]
+	for _, u := range job.Updates {
+		if u.Stage == "" || user.SeesStage(u.Stage) {
+			updates = append(updates, u)
+		}
+	}
+	job.Updates = updates
+}
+
+// canUploadToStage reports whether the session user may attach files to a stage of the job
+func (a *accessControl) canUploadToStage(r *http.Request, jobID int, stage string) bool {
+	userID := a.getUserID(r)
+	if userID == 0 {
+		return false
+	}
+
+	user, err := a.userRepo.GetByID(userID)
+	if err != nil {
+		return false
+	}
+
+	// Admin and subadmin can upload to any stage
+	if user.IsAdmin || user.Role == "subadmin" {
+		return true
+	}
+
+	// Get job details to check role-based access
+	job, err := a.pipelineRepo.GetJobByID(r.Context(), jobID)
+	if err != nil {
+		return false
+	}
+
+	// Check role-based access for upload permission
+	switch user.Role {
+	case "stage2_employee":
+		// Stage 2 employee can only upload to stage2
+		return stage == "stage2" && job.AssignedToStage2 != nil && *job.AssignedToStage2 == userID
+	case "stage3_employee":
+		// Stage 3 employee can only upload to stage3
+		return stage == "stage3" && job.AssignedToStage3 != nil && *job.AssignedToStage3 == userID
+	case "customer":
+		// Customer can only upload to stage4
+		return stage == "stage4" && ownsJob(user, job) && user.SeesStage(stage)
+	case "stage1_employee":
+		// Stage 1 employee can only upload to stage1
+		return stage == "stage1" && job.CreatedBy == userID
+	default:
+		return false
+	}
+}
+
+// isAdmin reports whether the session user is an admin
+func (a *accessControl) isAdmin(r *http.Request) bool {
+	session, err := a.sessionStore.Get(r, "session")
+	if err != nil {
+		return false
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return false
+	}
+
+	user, err := a.userRepo.GetByID(userID)
+	if err != nil {
+		return false
+	}
+
+	return user.IsAdmin
+}
+
+// isSubadmin reports whether the session user is a subadmin
+func (a *accessControl) isSubadmin(r *http.Request) bool {
+	session, err := a.sessionStore.Get(r, "session")
+	if err != nil {
+		return false
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return false
+	}
+
+	user, err := a.userRepo.GetByID(userID)
+	if err != nil {
+		return false
+	}
+
+	return user.Role == "subadmin"
+}
+
+// isAdminOrSubadmin reports whether the session user is an admin or subadmin
+func (a *accessControl) isAdminOrSubadmin(r *http.Request) bool {
+	return a.isAdmin(r) || a.isSubadmin(r)
+}
+
+// getUserID returns the session user ID, or 0 when not logged in
+func (a *accessControl) getUserID(r *http.Request) int {
+	session, err := a.sessionStore.Get(r, "session")
+	if err != nil {
+		return 0
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return 0
+	}
+
+	return userID
+}
+
+// getUserIDFromSession returns the session user ID or an error when not logged in
+func (a *accessControl) getUserIDFromSession(r *http.Request) (int, error) {
+	session, err := a.sessionStore.Get(r, "session")
+	if err != nil {
+		return 0, err
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return 0, fmt.Errorf("user not authenticated")
+	}
+
+	return userID, nil
+}
+
+// extractJobID reads the job ID from a /api/pipeline/jobs/{id}/... path
+func (a *accessControl) extractJobID(r *http.Request) (int, error) {
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) < 5 {
+		return 0, fmt.Errorf("invalid path")
+	}
+
+	return strconv.Atoi(pathParts[4])
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"maydiv-crm/internal/models"
+)
+
+func intPtr(v int) *int { return &v }
+
+// testJob is job 1 of customer 10, with consignee organization 20,
+// assigned to stage 2 employee 30 and stage 3 employee 31
+func testJob() *models.PipelineJobResponse {
+	job := &models.PipelineJobResponse{
+		Stage1:     &models.Stage1Data{ConsigneePartyID: intPtr(20)},
+		Stage2:     &models.Stage2Data{},
+		Stage3:     &models.Stage3Data{},
+		Containers: []models.Container{{}},
+		Stage4:     &models.Stage4Data{},
+		Updates: []models.JobUpdate{
+			{ID: 1, Stage: "stage1"}, {ID: 2, Stage: "stage2"}, {ID: 3},
+			{ID: 4, Stage: "stage3"}, {ID: 5, Stage: "stage4"},
+		},
+	}
+	job.ID = 1
+	job.CustomerID = intPtr(10)
+	job.AssignedToStage2 = intPtr(30)
+	job.AssignedToStage3 = intPtr(31)
+	return job
+}
+
+func TestOwnsJob(t *testing.T) {
+	tests := []struct {
+		name string
+		user models.User
+		want bool
+	}{
+		{name: "the job's customer", user: models.User{ID: 10}, want: true},
+		{name: "a member of the consignee", user: models.User{ID: 11, PartyID: intPtr(20)}, want: true},
+		{name: "a member of another organization", user: models.User{ID: 11, PartyID: intPtr(21)}},
+		{name: "a customer without organization", user: models.User{ID: 11}},
+	}
+	for _, tt := range tests {
+		if got := ownsJob(&tt.user, testJob()); got != tt.want {
+			t.Errorf("%s: ownsJob = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	job := testJob()
+	job.Stage1 = nil
+	if ownsJob(&models.User{ID: 11, PartyID: intPtr(20)}, job) {
+		t.Error("ownsJob matched the consignee of a job without stage 1 data")
+	}
+}
+
+func TestJobAccess(t *testing.T) {
+	member := func(stages ...string) models.User {
+		return models.User{ID: 11, Role: "customer", PartyID: intPtr(20), Stages: stages}
+	}
+	tests := []struct {
+		name  string
+		user  models.User
+		stage string
+		want  bool
+	}{
+		{name: "admin", user: models.User{ID: 1, IsAdmin: true}, stage: "stage4", want: true},
+		{name: "subadmin", user: models.User{ID: 2, Role: "subadmin"}, want: true},
+		{name: "assigned stage 2 employee", user: models.User{ID: 30, Role: "stage2_employee"}, want: true},
+		{name: "other stage 2 employee", user: models.User{ID: 31, Role: "stage2_employee"}},
+		{name: "assigned stage 3 employee", user: models.User{ID: 31, Role: "stage3_employee"}, want: true},
+		{name: "stage 1 employee", user: models.User{ID: 40, Role: "stage1_employee"}},
+		{name: "the job's customer", user: models.User{ID: 10, Role: "customer"}, stage: "stage2", want: true},
+		{name: "member with every stage", user: member(), stage: "stage2", want: true},
+		{name: "member restricted, the job itself", user: member("stage4"), want: true},
+		{name: "member restricted, a stage allowed", user: member("stage3", "stage4"), stage: "stage4", want: true},
+		{name: "member restricted, a stage not allowed", user: member("stage4"), stage: "stage2"},
+		{name: "customer of another job", user: models.User{ID: 12, Role: "customer", PartyID: intPtr(21)}},
+	}
+	for _, tt := range tests {
+		if got := jobAccess(&tt.user, testJob(), tt.stage); got != tt.want {
+			t.Errorf("%s: jobAccess(%q) = %v, want %v", tt.name, tt.stage, got, tt.want)
+		}
+	}
+}
+
+func TestRequestStage(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/api/pipeline/jobs/7", ""},
+		{"/api/pipeline/jobs/7/duty", "stage2"},
+		{"/api/pipeline/jobs/7/filings/3", "stage2"},
+		{"/api/pipeline/jobs/7/bill-of-entry", "stage2"},
+		{"/api/pipeline/jobs/7/containers/2/events", "stage3"},
+		{"/api/pipeline/jobs/7/demurrage", "stage3"},
+		{"/api/pipeline/jobs/7/stage3", "stage3"},
+		{"/api/pipeline/jobs/7/einvoice", "stage4"},
+		{"/api/pipeline/jobs/7/bill-notes/1/approve", "stage4"},
+		{"/api/pipeline/jobs/7/invoice", "stage4"},
+		{"/api/pipeline/jobs/7/stage4", "stage4"},
+		{"/api/pipeline/jobs/7/files", ""},
+		{"/api/receivables/duty", ""},
+	}
+	for _, tt := range tests {
+		if got := requestStage(httptest.NewRequest("GET", tt.path, nil)); got != tt.want {
+			t.Errorf("requestStage(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHideStages(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  []string
+		visible []bool // stage 1 to 4, then containers
+		updates []int
+	}{
+		{name: "every stage", visible: []bool{true, true, true, true, true}, updates: []int{1, 2, 3, 4, 5}},
+		{name: "billing only", stages: []string{"stage4"}, visible: []bool{false, false, false, true, false}, updates: []int{3, 5}},
+		{name: "the port", stages: []string{"stage1", "stage3"}, visible: []bool{true, false, true, false, true}, updates: []int{1, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := testJob()
+			hideStages(&models.User{ID: 11, Role: "customer", Stages: tt.stages}, job)
+			visible := []bool{job.Stage1 != nil, job.Stage2 != nil, job.Stage3 != nil, job.Stage4 != nil, job.Containers != nil}
+			if !slices.Equal(visible, tt.visible) {
+				t.Errorf("stages 1-4 and containers visible %v, want %v", visible, tt.visible)
+			}
+			var updates []int
+			for _, u := range job.Updates {
+				updates = append(updates, u.ID)
+			}
+			if !slices.Equal(updates, tt.updates) {
+				t.Errorf("updates %v, want %v", updates, tt.updates)
+			}
+		})
+	}
+}
+
+func TestSeesStage(t *testing.T) {
+	tests := []struct {
+		stages []string
+		stage  string
+		want   bool
+	}{
+		{nil, "stage1", true},
+		{[]string{}, "stage4", true},
+		{[]string{"stage2", "stage4"}, "stage4", true},
+		{[]string{"stage2", "stage4"}, "stage3", false},
+	}
+	for _, tt := range tests {
+		u := models.User{Stages: tt.stages}
+		if got := u.SeesStage(tt.stage); got != tt.want {
+			t.Errorf("User{Stages: %v}.SeesStage(%q) = %v, want %v", tt.stages, tt.stage, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"maydiv-crm/internal/models"
 	"maydiv-crm/internal/services"
@@ -26,30 +28,30 @@ func NewAuthHandler(authService *services.AuthService, sessionStore *sessions.Co
 // Login handles user login
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 	
 	var credentials models.UserLogin
-	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeRequest(r, &credentials); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 	
-	log.Println("Login attempt for username:", credentials.Username)
-	
 	user, err := h.authService.Authenticate(&credentials)
 	if err != nil {
-		log.Println("Authentication failed for user", credentials.Username, ":", err)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			// Usernames stay out of the logs; failed attempts often contain typed passwords
+			slog.WarnContext(r.Context(), "Authentication failed", "remote_addr", r.RemoteAddr)
+		}
+		WriteError(w, r, err)
 		return
 	}
 	
 	// Create session
 	session, err := h.sessionStore.Get(r, "session")
 	if err != nil {
-		log.Println("Error getting session:", err)
-		http.Error(w, "Session error", http.StatusInternalServerError)
+		WriteError(w, r, fmt.Errorf("getting session: %w", err))
 		return
 	}
 	
@@ -58,14 +60,11 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	session.Values["username"] = user.Username
 	
 	if err := session.Save(r, w); err != nil {
-		log.Println("Error saving session:", err)
-		http.Error(w, "Session error", http.StatusInternalServerError)
+		WriteError(w, r, fmt.Errorf("saving session for user %d: %w", user.ID, err))
 		return
 	}
 	
-	log.Println("Session created successfully for user:", user.Username)
-	
-	log.Println("User", credentials.Username, "logged in successfully")
+	slog.InfoContext(r.Context(), "User logged in", "user_id", user.ID, "role", user.Role)
 	
 	response := map[string]interface{}{
 		"success": true,
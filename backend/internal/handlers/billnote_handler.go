@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// BillNoteHandler serves the credit and debit notes correcting a job's
+// issued bill, and the bill's net position
+type BillNoteHandler struct {
+	accessControl
+	billNoteService *services.BillNoteService
+	ledgerService   *services.LedgerService
+}
+
+// NewBillNoteHandler creates a new bill note handler
+func NewBillNoteHandler(billNoteService *services.BillNoteService, ledgerService *services.LedgerService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *BillNoteHandler {
+	return &BillNoteHandler{
+		accessControl:   newAccessControl(pipelineRepo, userRepo, sessionStore),
+		billNoteService: billNoteService,
+		ledgerService:   ledgerService,
+	}
+}
+
+// HandleBillNotes handles the bill note routes of a job:
+//
+//	GET  /api/pipeline/jobs/{id}/bill-notes                   - the bill net of its notes, and the notes
+//	POST /api/pipeline/jobs/{id}/bill-notes                   - request a credit or debit note (admin or subadmin)
+//	POST /api/pipeline/jobs/{id}/bill-notes/{note_id}/approve - number and approve a note (admin only)
+//	POST /api/pipeline/jobs/{id}/bill-notes/{note_id}/reject  - turn a note down (admin only)
+//	POST /api/pipeline/jobs/{id}/bill-notes/{note_id}/pdf     - print an approved note again (admin or subadmin)
+func (h *BillNoteHandler) HandleBillNotes(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	// /api/pipeline/jobs/{id}/bill-notes[/{note_id}/{action}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch len(parts) {
+	case 5:
+		h.notes(w, r, jobID, userID)
+	case 7:
+		noteID, err := strconv.Atoi(parts[5])
+		if err != nil {
+			WriteError(w, r, BadRequest("Invalid note ID"))
+			return
+		}
+		h.note(w, r, jobID, noteID, userID, parts[6])
+	default:
+		WriteError(w, r, NotFound("Not found"))
+	}
+}
+
+func (h *BillNoteHandler) notes(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		position, err := h.billNoteService.Position(r.Context(), jobID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Job not found"))
+			return
+		}
+		writeJSON(w, position)
+	case http.MethodPost:
+		if !h.isAdminOrSubadmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		var req models.BillNoteRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		note, err := h.billNoteService.Request(r.Context(), jobID, &req, userID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Job not found"))
+			return
+		}
+		writeJSON(w, note)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *BillNoteHandler) note(w http.ResponseWriter, r *http.Request, jobID, noteID, userID int, action string) {
+	if action != "approve" && action != "reject" && action != "pdf" {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+
+	if action == "pdf" {
+		if !h.isAdminOrSubadmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		file, err := h.billNoteService.GeneratePDF(r.Context(), jobID, noteID, userID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Note not found"))
+			return
+		}
+		writeJSON(w, models.FileUploadResponse{Success: true, Message: "Note generated", File: file})
+		return
+	}
+
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	var review models.BillNoteReview
+	if err := decodeRequest(r, &review); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if action == "reject" {
+		note, err := h.billNoteService.Reject(r.Context(), jobID, noteID, &review, userID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Pending note not found"))
+			return
+		}
+		writeJSON(w, note)
+		return
+	}
+
+	note, err := h.billNoteService.Approve(r.Context(), jobID, noteID, &review, userID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Pending note not found"))
+		return
+	}
+	if err := h.ledgerService.Sync(r.Context(), jobID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to sync job ledger", "job_id", jobID, "error", err)
+	}
+	resp := map[string]interface{}{"note": note}
+	file, err := h.billNoteService.GeneratePDF(r.Context(), jobID, noteID, userID)
+	if err != nil {
+		// The note stands approved; its PDF can be generated again later
+		slog.ErrorContext(r.Context(), "Failed to generate bill note PDF", "job_id", jobID, "note_id", noteID, "error", err)
+		resp["warnings"] = []FieldError{{Field: "pdf", Message: "the note was approved but its PDF could not be generated"}}
+	} else {
+		resp["file"] = file
+	}
+	writeJSON(w, resp)
+}
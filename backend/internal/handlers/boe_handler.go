@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// BillOfEntryHandler serves Bill of Entry flat files for customs EDI
+type BillOfEntryHandler struct {
+	accessControl
+	boeService *services.BillOfEntryService
+}
+
+// NewBillOfEntryHandler creates a new Bill of Entry handler
+func NewBillOfEntryHandler(boeService *services.BillOfEntryService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *BillOfEntryHandler {
+	return &BillOfEntryHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		boeService:    boeService,
+	}
+}
+
+// HandleBillOfEntry handles the Bill of Entry routes of a job:
+//
+//	GET  /api/pipeline/jobs/{id}/bill-of-entry/check - mandatory fields still missing
+//	POST /api/pipeline/jobs/{id}/bill-of-entry       - generate and store with the stage 2 files
+//	GET  /api/pipeline/jobs/{id}/bill-of-entry       - download the latest generated file
+func (h *BillOfEntryHandler) HandleBillOfEntry(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	check := strings.HasSuffix(r.URL.Path, "/bill-of-entry/check")
+	switch {
+	case r.Method == http.MethodGet && check:
+		h.check(w, r, jobID)
+	case r.Method == http.MethodPost && !check:
+		h.generate(w, r, jobID, userID)
+	case r.Method == http.MethodGet:
+		h.download(w, r, jobID)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *BillOfEntryHandler) check(w http.ResponseWriter, r *http.Request, jobID int) {
+	if !h.canUploadToStage(r, jobID, "stage2") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	_, _, err := h.boeService.Build(r.Context(), jobID)
+	var fieldErrs validation.Errors
+	switch {
+	case err == nil:
+		writeJSON(w, map[string]interface{}{"ready": true, "errors": []FieldError{}})
+	case errors.As(err, &fieldErrs):
+		writeJSON(w, map[string]interface{}{"ready": false, "errors": fieldErrs})
+	default:
+		WriteError(w, r, orNotFound(err, "Job not found"))
+	}
+}
+
+func (h *BillOfEntryHandler) generate(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	if !h.canUploadToStage(r, jobID, "stage2") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	file, err := h.boeService.Generate(r.Context(), jobID, userID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Job not found"))
+		return
+	}
+	writeJSON(w, models.FileUploadResponse{
+		Success: true,
+		Message: "Bill of Entry generated",
+		File:    file,
+	})
+}
+
+func (h *BillOfEntryHandler) download(w http.ResponseWriter, r *http.Request, jobID int) {
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	file, err := h.boeService.Latest(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "No Bill of Entry has been generated for this job"))
+		return
+	}
+	if _, err := os.Stat(file.FilePath); os.IsNotExist(err) {
+		WriteError(w, r, NotFound("File not found on disk"))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", file.OriginalName))
+	w.Header().Set("Content-Type", "text/plain")
+	http.ServeFile(w, r, file.FilePath)
+}
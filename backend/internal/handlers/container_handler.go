@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/iso6346"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// ContainerHandler serves a job's containers one at a time, and the events
+// on their timelines
+type ContainerHandler struct {
+	accessControl
+	containerRepo *repository.ContainerRepository
+}
+
+// NewContainerHandler creates a new container handler
+func NewContainerHandler(containerRepo *repository.ContainerRepository, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *ContainerHandler {
+	return &ContainerHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		containerRepo: containerRepo,
+	}
+}
+
+// HandleContainers handles the container routes of a job:
+//
+//	GET    /api/pipeline/jobs/{id}/containers                         - the containers with their events
+//	POST   /api/pipeline/jobs/{id}/containers                         - add a container
+//	GET    /api/pipeline/jobs/{id}/containers/{cid}                   - one container with its events
+//	PUT    /api/pipeline/jobs/{id}/containers/{cid}                   - replace a container's details
+//	DELETE /api/pipeline/jobs/{id}/containers/{cid}                   - remove a container
+//	POST   /api/pipeline/jobs/{id}/containers/{cid}/events            - record an event
+//	DELETE /api/pipeline/jobs/{id}/containers/{cid}/events/{event_id} - remove an event
+//
+// cid is the container ID. Stage 1 and stage 3 staff of the job change its
+// containers.
+func (h *ContainerHandler) HandleContainers(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	// /api/pipeline/jobs/{id}/containers[/{cid}[/events[/{event_id}]]]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 5 {
+		h.containers(w, r, jobID, userID)
+		return
+	}
+	containerID, err := strconv.Atoi(parts[5])
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid container ID"))
+		return
+	}
+	switch {
+	case len(parts) == 6:
+		h.container(w, r, jobID, containerID, userID)
+	case len(parts) == 7 && parts[6] == "events":
+		h.addEvent(w, r, jobID, containerID, userID)
+	case len(parts) == 8 && parts[6] == "events":
+		eventID, err := strconv.Atoi(parts[7])
+		if err != nil {
+			WriteError(w, r, BadRequest("Invalid event ID"))
+			return
+		}
+		h.deleteEvent(w, r, jobID, containerID, eventID, userID)
+	default:
+		WriteError(w, r, NotFound("Not found"))
+	}
+}
+
+func (h *ContainerHandler) containers(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		containers, err := h.containerRepo.Containers(r.Context(), jobID)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, containers)
+	case http.MethodPost:
+		if !h.canEditContainers(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		var req models.ContainerRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		id, err := h.containerRepo.CreateContainer(r.Context(), jobID, &req, userID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Job not found"))
+			return
+		}
+		h.writeContainer(w, r, jobID, id, &req)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *ContainerHandler) container(w http.ResponseWriter, r *http.Request, jobID, containerID, userID int) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		h.writeContainer(w, r, jobID, containerID, nil)
+	case http.MethodPut:
+		if !h.canEditContainers(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		var req models.ContainerRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if err := h.containerRepo.UpdateContainer(r.Context(), jobID, containerID, &req, userID); err != nil {
+			WriteError(w, r, orNotFound(err, "Container not found"))
+			return
+		}
+		h.writeContainer(w, r, jobID, containerID, &req)
+	case http.MethodDelete:
+		if !h.canEditContainers(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		if err := h.containerRepo.DeleteContainer(r.Context(), jobID, containerID, userID); err != nil {
+			WriteError(w, r, orNotFound(err, "Container not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Container removed"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *ContainerHandler) addEvent(w http.ResponseWriter, r *http.Request, jobID, containerID, userID int) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.canEditContainers(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	var req models.ContainerEventRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if _, err := h.containerRepo.AddEvent(r.Context(), jobID, containerID, &req, userID); err != nil {
+		WriteError(w, r, orNotFound(err, "Container not found"))
+		return
+	}
+	h.writeContainer(w, r, jobID, containerID, nil)
+}
+
+func (h *ContainerHandler) deleteEvent(w http.ResponseWriter, r *http.Request, jobID, containerID, eventID, userID int) {
+	if r.Method != http.MethodDelete {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.canEditContainers(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	if err := h.containerRepo.DeleteEvent(r.Context(), jobID, containerID, eventID, userID); err != nil {
+		WriteError(w, r, orNotFound(err, "Event not found"))
+		return
+	}
+	h.writeContainer(w, r, jobID, containerID, nil)
+}
+
+// writeContainer replies with the container as saved, flagging a size that
+// contradicts its type code when req was just saved
+func (h *ContainerHandler) writeContainer(w http.ResponseWriter, r *http.Request, jobID, containerID int, req *models.ContainerRequest) {
+	container, err := h.containerRepo.GetContainer(r.Context(), jobID, containerID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Container not found"))
+		return
+	}
+	if req == nil {
+		writeJSON(w, container)
+		return
+	}
+	resp := map[string]interface{}{"container": container}
+	if msg := iso6346.SizeMismatch(req.Size, req.TypeCode); msg != "" {
+		resp["warnings"] = []FieldError{{Field: "type_code", Message: msg}}
+	}
+	writeJSON(w, resp)
+}
+
+// canEditContainers reports whether the session user may change the job's
+// containers: its stage 1 or stage 3 staff, or an admin or subadmin
+func (h *ContainerHandler) canEditContainers(r *http.Request, jobID int) bool {
+	return h.canUploadToStage(r, jobID, "stage1") || h.canUploadToStage(r, jobID, "stage3")
+}
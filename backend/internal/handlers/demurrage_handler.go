@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// DemurrageHandler serves demurrage and detention tariffs, the dashboard of
+// containers nearing the end of their free time, and the charges on a job's
+// containers
+type DemurrageHandler struct {
+	accessControl
+	demurrageService *services.DemurrageService
+}
+
+// NewDemurrageHandler creates a new demurrage handler
+func NewDemurrageHandler(demurrageService *services.DemurrageService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *DemurrageHandler {
+	return &DemurrageHandler{
+		accessControl:    newAccessControl(pipelineRepo, userRepo, sessionStore),
+		demurrageService: demurrageService,
+	}
+}
+
+// HandleDashboard handles GET /api/demurrage?as_of=&within=&until= (admin
+// or subadmin): containers whose free time ends within the given days of
+// as_of, demurrage.alert_days by default, or has ended, with their charges
+// projected to until
+func (h *DemurrageHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	within := h.demurrageService.AlertDays()
+	if s := r.URL.Query().Get("within"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			WriteError(w, r, ValidationFailed(FieldError{Field: "within", Message: "must be a number of days"}))
+			return
+		}
+		within = n
+	}
+	asOf, until, ok := h.dates(w, r, within)
+	if !ok {
+		return
+	}
+
+	dashboard, err := h.demurrageService.Dashboard(r.Context(), asOf, until, within)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, dashboard)
+}
+
+// HandleTariffs handles GET /api/demurrage/tariffs (admin or subadmin) and
+// POST /api/demurrage/tariffs (admin only)
+func (h *DemurrageHandler) HandleTariffs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.isAdminOrSubadmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		tariffs, err := h.demurrageService.Tariffs(r.Context())
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, tariffs)
+	case http.MethodPost:
+		if !h.isAdmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		h.saveTariff(w, r, 0)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// HandleTariff handles PUT and DELETE /api/demurrage/tariffs/{id} (admin
+// only)
+func (h *DemurrageHandler) HandleTariff(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/demurrage/tariffs/"))
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid tariff ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.saveTariff(w, r, id)
+	case http.MethodDelete:
+		if err := h.demurrageService.DeleteTariff(r.Context(), id); err != nil {
+			WriteError(w, r, orNotFound(err, "Tariff not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Tariff deleted"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *DemurrageHandler) saveTariff(w http.ResponseWriter, r *http.Request, id int) {
+	var req models.DemurrageTariffRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	tariff, err := h.demurrageService.SaveTariff(r.Context(), id, &req)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Tariff not found"))
+		return
+	}
+	writeJSON(w, tariff)
+}
+
+// HandleJobDemurrage handles GET /api/pipeline/jobs/{id}/demurrage?as_of=&until=:
+// the demurrage and detention on each of the job's containers
+func (h *DemurrageHandler) HandleJobDemurrage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	asOf, until, ok := h.dates(w, r, h.demurrageService.AlertDays())
+	if !ok {
+		return
+	}
+
+	containers, err := h.demurrageService.JobContainers(r.Context(), jobID, asOf, until)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"as_of":        asOf.Format(validation.DateLayout),
+		"projected_on": until.Format(validation.DateLayout),
+		"containers":   containers,
+	})
+}
+
+// dates reads the as_of date, today by default, and the until date charges
+// are projected to, days after as_of by default
+func (h *DemurrageHandler) dates(w http.ResponseWriter, r *http.Request, days int) (asOf, until time.Time, ok bool) {
+	query := r.URL.Query()
+	now := time.Now()
+	asOf = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, field := range []string{"as_of", "until"} {
+		s := query.Get(field)
+		if s == "" {
+			continue
+		}
+		d, err := time.Parse(validation.DateLayout, s)
+		if err != nil {
+			WriteError(w, r, ValidationFailed(FieldError{Field: field, Message: "must be a date in YYYY-MM-DD format"}))
+			return asOf, until, false
+		}
+		if field == "as_of" {
+			asOf = d
+		} else {
+			until = d
+		}
+	}
+	if until.IsZero() {
+		until = asOf.AddDate(0, 0, days)
+	}
+	if until.Before(asOf) {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "until", Message: "must not be before as_of"}))
+		return asOf, until, false
+	}
+	return asOf, until, true
+}
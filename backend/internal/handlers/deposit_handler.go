@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// DepositHandler serves customer deposit accounts, their statements and
+// the deposit check of a job. All of it is for admins and subadmins only.
+type DepositHandler struct {
+	accessControl
+	depositService *services.DepositService
+}
+
+// NewDepositHandler creates a new deposit handler
+func NewDepositHandler(depositService *services.DepositService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *DepositHandler {
+	return &DepositHandler{
+		accessControl:  newAccessControl(pipelineRepo, userRepo, sessionStore),
+		depositService: depositService,
+	}
+}
+
+// HandleAccounts handles GET /api/deposits, the accounts and their
+// balances, and POST /api/deposits, which opens an account
+func (h *DepositHandler) HandleAccounts(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		accounts, err := h.depositService.Accounts(r.Context())
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, accounts)
+	case http.MethodPost:
+		var req models.DepositAccountRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		account, err := h.depositService.OpenAccount(r.Context(), &req, h.getUserID(r))
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, account)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// HandleAccount handles the routes of one deposit account:
+//
+//	GET  /api/deposits/{id}                       - the account and its balance
+//	POST /api/deposits/{id}/movements             - record a receipt or refund
+//	GET  /api/deposits/{id}/statement?from=&to=   - movements with the running balance
+func (h *DepositHandler) HandleAccount(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	// /api/deposits/{id}[/movements|/statement]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid deposit account ID"))
+		return
+	}
+	action := ""
+	if len(parts) == 4 {
+		action = parts[3]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		account, err := h.depositService.Account(r.Context(), id)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Deposit account not found"))
+			return
+		}
+		writeJSON(w, account)
+	case action == "movements" && r.Method == http.MethodPost:
+		var req models.DepositMovementRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		account, err := h.depositService.AddMovement(r.Context(), id, &req, h.getUserID(r))
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Deposit account not found"))
+			return
+		}
+		writeJSON(w, account)
+	case action == "statement" && r.Method == http.MethodGet:
+		query := r.URL.Query()
+		var dates [2]*time.Time
+		for i, field := range []string{"from", "to"} {
+			s := query.Get(field)
+			if s == "" {
+				continue
+			}
+			d, err := time.Parse(validation.DateLayout, s)
+			if err != nil {
+				WriteError(w, r, ValidationFailed(FieldError{Field: field, Message: "must be a date in YYYY-MM-DD format"}))
+				return
+			}
+			dates[i] = &d
+		}
+		statement, err := h.depositService.Statement(r.Context(), id, dates[0], dates[1])
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Deposit account not found"))
+			return
+		}
+		writeJSON(w, statement)
+	case action == "" || action == "movements" || action == "statement":
+		WriteError(w, r, ErrMethodNotAllowed)
+	default:
+		WriteError(w, r, NotFound("Not found"))
+	}
+}
+
+// HandleJobDeposit handles GET /api/pipeline/jobs/{id}/deposit: the duty
+// the job is expected to need against what its customer's deposit has
+// available. The check is null when the job's duty is not paid from a
+// deposit.
+func (h *DepositHandler) HandleJobDeposit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	check, err := h.depositService.Check(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Job not found"))
+		return
+	}
+	resp := map[string]interface{}{"check": check}
+	if msg := services.ShortfallWarning(check); msg != "" {
+		resp["warnings"] = []FieldError{{Field: "deposit", Message: msg}}
+	}
+	writeJSON(w, resp)
+}
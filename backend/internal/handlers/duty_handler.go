@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// DutyHandler serves customs duty calculations and exchange rates
+type DutyHandler struct {
+	accessControl
+	dutyRepo    *repository.DutyRepository
+	dutyService *services.DutyService
+}
+
+// NewDutyHandler creates a new duty handler
+func NewDutyHandler(dutyRepo *repository.DutyRepository, dutyService *services.DutyService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *DutyHandler {
+	return &DutyHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		dutyRepo:      dutyRepo,
+		dutyService:   dutyService,
+	}
+}
+
+// HandleJobDuty handles the duty routes of a job:
+//
+//	POST /api/pipeline/jobs/{id}/duty/calculate - compute without saving, to prefill duty_amount
+//	POST /api/pipeline/jobs/{id}/duty           - compute and save the breakdown
+//	GET  /api/pipeline/jobs/{id}/duty           - saved breakdowns, newest first
+func (h *DutyHandler) HandleJobDuty(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	preview := strings.HasSuffix(r.URL.Path, "/duty/calculate")
+	switch {
+	case r.Method == http.MethodGet && !preview:
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		calculations, err := h.dutyRepo.ListCalculations(r.Context(), jobID)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, calculations)
+	case r.Method == http.MethodPost:
+		h.calculate(w, r, jobID, userID, !preview)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *DutyHandler) calculate(w http.ResponseWriter, r *http.Request, jobID, userID int, save bool) {
+	// Whoever may work on stage 2 of the job may calculate its duty
+	if !h.canUploadToStage(r, jobID, "stage2") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	job, err := h.pipelineRepo.GetJobByID(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Job not found"))
+		return
+	}
+
+	var req models.DutyCalculationRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Goods are assessed at the rate in force on the Bill of Entry date
+	if req.RateDate == "" && job.Stage2 != nil && job.Stage2.BillOfEntryDate != nil {
+		req.RateDate = job.Stage2.BillOfEntryDate.Format(validation.DateLayout)
+	}
+
+	var breakdown *models.DutyBreakdown
+	if save {
+		breakdown, err = h.dutyService.Save(r.Context(), jobID, userID, &req)
+	} else {
+		breakdown, err = h.dutyService.Calculate(r.Context(), jobID, &req)
+	}
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, breakdown)
+}
+
+// HandleExchangeRates handles GET /api/exchange-rates?date= (rates in force
+// on a date, default today) and POST /api/exchange-rates (admin only)
+func (h *DutyHandler) HandleExchangeRates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if h.getUserID(r) == 0 {
+			WriteError(w, r, services.ErrUnauthorized)
+			return
+		}
+		on := time.Now()
+		if s := r.URL.Query().Get("date"); s != "" {
+			d, err := time.Parse(validation.DateLayout, s)
+			if err != nil {
+				WriteError(w, r, ValidationFailed(FieldError{Field: "date", Message: "must be a date in YYYY-MM-DD format"}))
+				return
+			}
+			on = d
+		}
+		rates, err := h.dutyRepo.ListExchangeRates(r.Context(), on)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, rates)
+	case http.MethodPost:
+		if !h.isAdmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		var req models.ExchangeRateRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		effective, _ := time.Parse(validation.DateLayout, req.EffectiveDate)
+		currency := strings.ToUpper(req.Currency)
+		if err := h.dutyRepo.SetExchangeRate(r.Context(), currency, req.Rate, effective); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Exchange rate saved"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// EInvoiceHandler generates GST e-invoices for job bills and records the
+// IRP's signed responses
+type EInvoiceHandler struct {
+	accessControl
+	einvoiceService *services.EInvoiceService
+	uploads         config.UploadConfig
+}
+
+// NewEInvoiceHandler creates a new e-invoice handler
+func NewEInvoiceHandler(einvoiceService *services.EInvoiceService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore, uploads config.UploadConfig) *EInvoiceHandler {
+	return &EInvoiceHandler{
+		accessControl:   newAccessControl(pipelineRepo, userRepo, sessionStore),
+		einvoiceService: einvoiceService,
+		uploads:         uploads,
+	}
+}
+
+// HandleEInvoice handles the e-invoice routes of a job:
+//
+//	GET  /api/pipeline/jobs/{id}/einvoice          - the e-invoice and its registration
+//	GET  /api/pipeline/jobs/{id}/einvoice/json     - download the JSON to upload to the IRP
+//	POST /api/pipeline/jobs/{id}/einvoice          - generate from the stage 4 bill
+//	POST /api/pipeline/jobs/{id}/einvoice/response - import the IRP's signed response
+func (h *EInvoiceHandler) HandleEInvoice(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/einvoice/response"):
+		h.importResponse(w, r, jobID, userID)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/einvoice"):
+		h.generate(w, r, jobID, userID)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/einvoice/json"):
+		h.download(w, r, jobID)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/einvoice"):
+		h.get(w, r, jobID)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *EInvoiceHandler) get(w http.ResponseWriter, r *http.Request, jobID int) {
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	e, err := h.einvoiceService.Get(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "No e-invoice has been generated for this job"))
+		return
+	}
+	writeJSON(w, e)
+}
+
+func (h *EInvoiceHandler) download(w http.ResponseWriter, r *http.Request, jobID int) {
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	e, err := h.einvoiceService.Get(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "No e-invoice has been generated for this job"))
+		return
+	}
+	name := strings.NewReplacer("/", "-", `"`, "").Replace(e.BillNo)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"einvoice_%s.json\"", name))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(e.Payload)
+}
+
+func (h *EInvoiceHandler) generate(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	if !h.canUploadToStage(r, jobID, "stage4") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	// The body is optional; it only overrides the buyer's details
+	var req models.EInvoiceRequest
+	if r.ContentLength != 0 {
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+
+	e, err := h.einvoiceService.Generate(r.Context(), jobID, userID, &req)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Job not found"))
+		return
+	}
+	writeJSON(w, e)
+}
+
+// importResponse accepts the IRP's response as the multipart field "file"
+// or as the JSON request body
+func (h *EInvoiceHandler) importResponse(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	if !h.canUploadToStage(r, jobID, "stage4") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.uploads.MaxBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(h.uploads.MaxBytes); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			WriteError(w, r, ValidationFailed(FieldError{Field: "file", Message: "is required"}))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if len(data) == 0 {
+		WriteError(w, r, BadRequest("Request body is empty"))
+		return
+	}
+
+	e, err := h.einvoiceService.ImportResponse(r.Context(), jobID, userID, data)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "No e-invoice has been generated for this job"))
+		return
+	}
+	writeJSON(w, e)
+}
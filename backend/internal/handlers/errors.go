@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"maydiv-crm/internal/logging"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+)
+
+// Error codes returned in the "code" field of the error envelope. They are
+// part of the API contract: the frontend switches on them, so never rename one.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidJSON        = "invalid_json"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeDuplicateJobNo     = "duplicate_job_no"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeInternal           = "internal_error"
+)
+
+// FieldError describes a problem with one request field
+type FieldError = validation.FieldError
+
+// APIError is an error that knows how it is presented to clients
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details []FieldError
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewError creates an API error with the given status, code and message
+func NewError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// BadRequest creates a 400 error for malformed parameters
+func BadRequest(message string) *APIError {
+	return NewError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// NotFound creates a 404 error naming the missing resource
+func NotFound(message string) *APIError {
+	return NewError(http.StatusNotFound, CodeNotFound, message)
+}
+
+// ValidationFailed creates a 422 error listing every invalid field
+func ValidationFailed(details ...FieldError) *APIError {
+	e := NewError(http.StatusUnprocessableEntity, CodeValidationFailed, "Request validation failed")
+	e.Details = details
+	return e
+}
+
+// orNotFound turns repository.ErrNotFound into a 404 naming the resource
+func orNotFound(err error, message string) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return NotFound(message)
+	}
+	return err
+}
+
+// requiredFields reports each empty value, keyed by field name
+func requiredFields(values map[string]string) []FieldError {
+	var details []FieldError
+	for field, value := range values {
+		if value == "" {
+			details = append(details, FieldError{Field: field, Message: "is required"})
+		}
+	}
+	sort.Slice(details, func(i, j int) bool { return details[i].Field < details[j].Field })
+	return details
+}
+
+// ErrMethodNotAllowed is returned by every handler that checks r.Method
+var ErrMethodNotAllowed = NewError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+
+type errorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+// toAPIError is the single place where repository, service and transport
+// errors are mapped to what the client sees. Anything unknown is a 500
+// whose details stay in the logs.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	var fieldErrs validation.Errors
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &fieldErrs):
+		return ValidationFailed(fieldErrs...)
+	case errors.As(err, &maxBytesErr):
+		return NewError(http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return NewError(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+	case errors.Is(err, services.ErrUnauthorized):
+		return NewError(http.StatusUnauthorized, CodeUnauthorized, "Not authenticated")
+	case errors.Is(err, services.ErrForbidden), errors.Is(err, repository.ErrNotOwner):
+		return NewError(http.StatusForbidden, CodeForbidden, "Access denied")
+	case errors.Is(err, services.ErrInvalidFile):
+		return BadRequest(err.Error())
+	case errors.Is(err, services.ErrEInvoiceRegistered):
+		return NewError(http.StatusConflict, CodeConflict, "The e-invoice is already registered with the IRP")
+	case errors.Is(err, services.ErrUserNotFound):
+		return NotFound("User not found")
+	case errors.Is(err, services.ErrTaskNotFound):
+		return NotFound("Task not found")
+	case errors.Is(err, repository.ErrNotFound):
+		return NotFound("Not found")
+	case errors.Is(err, repository.ErrDuplicate):
+		return NewError(http.StatusConflict, CodeConflict, "Already exists")
+	default:
+		return NewError(http.StatusInternalServerError, CodeInternal, "Internal server error")
+	}
+}
+
+// WriteError replies with the JSON error envelope for err
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "Request failed", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: errorBody{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: logging.RequestID(r.Context()),
+	}})
+}
+
+// decodeRequest decodes the JSON request body into v and checks its
+// validate tags. Malformed bodies and mistyped fields are invalid_json;
+// failed rules are validation_failed with every field listed.
+func decodeRequest(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return validation.Struct(v)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+
+	apiErr := NewError(http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON")
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		apiErr.Message = "Request body is empty"
+	case errors.As(err, &typeErr):
+		apiErr.Details = []FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be a %s", typeErr.Type),
+		}}
+	}
+	return apiErr
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maydiv-crm/internal/middleware"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+)
+
+func TestWriteError(t *testing.T) {
+	var fieldErrs validation.Errors
+	fieldErrs.Add("consignee", "is required")
+	fieldErrs.Add("items[0].hsn_code", "must be 4, 6 or 8 digits")
+
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+		details []FieldError
+	}{
+		{name: "not found", err: fmt.Errorf("loading job: %w", repository.ErrNotFound),
+			status: http.StatusNotFound, code: CodeNotFound, message: "Not found"},
+		{name: "named not found", err: orNotFound(repository.ErrNotFound, "Job not found"),
+			status: http.StatusNotFound, code: CodeNotFound, message: "Job not found"},
+		{name: "duplicate", err: fmt.Errorf("saving: %w", repository.ErrDuplicate),
+			status: http.StatusConflict, code: CodeConflict, message: "Already exists"},
+		{name: "validation", err: fieldErrs.Err(),
+			status: http.StatusUnprocessableEntity, code: CodeValidationFailed, message: "Request validation failed",
+			details: fieldErrs},
+		{name: "unauthorized", err: services.ErrUnauthorized,
+			status: http.StatusUnauthorized, code: CodeUnauthorized, message: "Not authenticated"},
+		{name: "not the owner", err: repository.ErrNotOwner,
+			status: http.StatusForbidden, code: CodeForbidden, message: "Access denied"},
+		{name: "too large", err: &http.MaxBytesError{Limit: 1024},
+			status: http.StatusRequestEntityTooLarge, code: CodePayloadTooLarge, message: "Request body exceeds 1024 bytes"},
+		{name: "API error", err: BadRequest("Invalid job ID"),
+			status: http.StatusBadRequest, code: CodeBadRequest, message: "Invalid job ID"},
+		{name: "unknown", err: errors.New("dial tcp 10.0.0.5:3306: connection refused"),
+			status: http.StatusInternalServerError, code: CodeInternal, message: "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteError(w, r, tt.err)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/pipeline/jobs/1", nil))
+			requestID := rec.Header().Get(middleware.RequestIDHeader)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var envelope map[string]map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("body %s is not an envelope: %v", rec.Body, err)
+			}
+			if len(envelope) != 1 || envelope["error"] == nil {
+				t.Fatalf("body %s has no single error member", rec.Body)
+			}
+
+			var got errorEnvelope
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Error.Code != tt.code || got.Error.Message != tt.message {
+				t.Errorf("error = %s %q, want %s %q", got.Error.Code, got.Error.Message, tt.code, tt.message)
+			}
+			if len(got.Error.Details) != len(tt.details) {
+				t.Errorf("details = %+v, want %+v", got.Error.Details, tt.details)
+			} else {
+				for i := range tt.details {
+					if got.Error.Details[i] != tt.details[i] {
+						t.Errorf("detail %d = %+v, want %+v", i, got.Error.Details[i], tt.details[i])
+					}
+				}
+			}
+			if _, ok := envelope["error"]["details"]; ok != (tt.details != nil) {
+				t.Errorf("details present = %v, want %v", ok, tt.details != nil)
+			}
+			if requestID == "" || got.Error.RequestID != requestID {
+				t.Errorf("request_id = %q, want the %s header %q", got.Error.RequestID, middleware.RequestIDHeader, requestID)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestErrors(t *testing.T) {
+	type request struct {
+		Name  string `json:"name" validate:"required"`
+		Count int    `json:"count"`
+	}
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		code    string
+		message string
+		field   string
+	}{
+		{name: "empty", body: "", status: http.StatusBadRequest, code: CodeInvalidJSON, message: "Request body is empty"},
+		{name: "malformed", body: `{"name":`, status: http.StatusBadRequest, code: CodeInvalidJSON, message: "Invalid JSON"},
+		{name: "mistyped", body: `{"name":"a","count":"two"}`, status: http.StatusBadRequest, code: CodeInvalidJSON,
+			message: "Invalid JSON", field: "count"},
+		{name: "missing field", body: `{"count":2}`, status: http.StatusUnprocessableEntity, code: CodeValidationFailed,
+			message: "Request validation failed", field: "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			var req request
+			rec := httptest.NewRecorder()
+			WriteError(rec, r, decodeRequest(r, &req))
+
+			var got errorEnvelope
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status || got.Error.Code != tt.code || got.Error.Message != tt.message {
+				t.Errorf("got %d %s %q, want %d %s %q", rec.Code, got.Error.Code, got.Error.Message, tt.status, tt.code, tt.message)
+			}
+			if tt.field != "" && (len(got.Error.Details) != 1 || got.Error.Details[0].Field != tt.field) {
+				t.Errorf("details = %+v, want one for %s", got.Error.Details, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// FilingHandler serves the filing rules and the filings required on jobs
+type FilingHandler struct {
+	accessControl
+	filingRepo    *repository.FilingRepository
+	filingService *services.FilingService
+}
+
+// NewFilingHandler creates a new filing handler
+func NewFilingHandler(filingRepo *repository.FilingRepository, filingService *services.FilingService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *FilingHandler {
+	return &FilingHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		filingRepo:    filingRepo,
+		filingService: filingService,
+	}
+}
+
+// HandleRules handles GET /api/filing-rules (any logged in user) and
+// POST /api/filing-rules (admin only)
+func (h *FilingHandler) HandleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if h.getUserID(r) == 0 {
+			WriteError(w, r, services.ErrUnauthorized)
+			return
+		}
+		rules, err := h.filingRepo.ListRules(r.Context(), false)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, rules)
+	case http.MethodPost:
+		if !h.isAdmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		rule, err := h.decodeRule(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		id, err := h.filingRepo.CreateRule(r.Context(), rule)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"message": "Filing rule created", "id": id})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// HandleRule handles PUT and DELETE /api/filing-rules/{id} (admin only).
+// Jobs pick up rule changes the next time their stage 2 data is saved.
+func (h *FilingHandler) HandleRule(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/filing-rules/"))
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid rule ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		rule, err := h.decodeRule(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		rule.ID = id
+		if err := h.filingRepo.UpdateRule(r.Context(), rule); err != nil {
+			WriteError(w, r, orNotFound(err, "Filing rule not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Filing rule updated"})
+	case http.MethodDelete:
+		if err := h.filingRepo.DeleteRule(r.Context(), id); err != nil {
+			WriteError(w, r, orNotFound(err, "Filing rule not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Filing rule deleted"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *FilingHandler) decodeRule(r *http.Request) (*models.FilingRule, error) {
+	var req models.FilingRuleRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	rule, err := services.RuleFromRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.filingService.KeyCountry(r.Context(), rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// HandleJobFilings handles the filings of a job:
+//
+//	GET /api/pipeline/jobs/{id}/filings                - required filings and their status
+//	PUT /api/pipeline/jobs/{id}/filings/{filing_id}    - record progress on one filing
+func (h *FilingHandler) HandleJobFilings(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	// /api/pipeline/jobs/{id}/filings[/{filing_id}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 5 {
+		if r.Method != http.MethodGet {
+			WriteError(w, r, ErrMethodNotAllowed)
+			return
+		}
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		filings, err := h.filingService.List(r.Context(), jobID)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"filings": filings,
+			"warning": services.OpenFilingsWarning(filings),
+		})
+		return
+	}
+
+	if r.Method != http.MethodPut {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	filingID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil || len(parts) != 6 {
+		WriteError(w, r, BadRequest("Invalid filing ID"))
+		return
+	}
+	if !h.canUploadToStage(r, jobID, "stage2") {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	var req models.JobFilingUpdateRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if req.Status == models.FilingNotRequired && strings.TrimSpace(req.Remarks) == "" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "remarks", Message: "is required to mark a filing not required"}))
+		return
+	}
+
+	if err := h.filingRepo.UpdateJobFiling(r.Context(), jobID, filingID, &req, userID); err != nil {
+		WriteError(w, r, orNotFound(err, "Filing not found"))
+		return
+	}
+	writeJSON(w, map[string]string{"message": "Filing updated"})
+}
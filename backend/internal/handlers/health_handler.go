@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"maydiv-crm/internal/services"
+)
+
+// readinessTimeout bounds each dependency check of /readyz
+const readinessTimeout = 3 * time.Second
+
+// HealthHandler serves liveness and readiness probes
+type HealthHandler struct {
+	db           *sql.DB
+	uploadDir    string
+	emailService *services.EmailService
+	checkSMTP    bool
+	shuttingDown atomic.Bool
+}
+
+// NewHealthHandler creates a new health handler. The SMTP server is only
+// probed when checkSMTP is set, since it is slow and rate limited.
+func NewHealthHandler(db *sql.DB, uploadDir string, emailService *services.EmailService, checkSMTP bool) *HealthHandler {
+	return &HealthHandler{
+		db:           db,
+		uploadDir:    uploadDir,
+		emailService: emailService,
+		checkSMTP:    checkSMTP,
+	}
+}
+
+// SetShuttingDown makes readiness fail so no new traffic is routed here
+func (h *HealthHandler) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Healthz handles GET /healthz - the process is up and serving HTTP
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// Readyz handles GET /readyz - the server can do useful work. The probe is
+// unauthenticated, so failed checks read "unavailable" and their errors
+// are only logged.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	check := func(name string, probe func(context.Context) error) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		if err := probe(ctx); err != nil {
+			slog.WarnContext(r.Context(), "Readiness check failed", "check", name, "error", err)
+			checks[name] = "unavailable"
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	if h.shuttingDown.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	check("database", h.db.PingContext)
+	check("uploads", func(context.Context) error { return h.checkUploadDir() })
+	if h.checkSMTP {
+		check("smtp", h.emailService.CheckConnection)
+	}
+
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkUploadDir verifies that files can be created in the upload directory
+func (h *HealthHandler) checkUploadDir() error {
+	if err := os.MkdirAll(h.uploadDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(h.uploadDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadyzHidesErrors(t *testing.T) {
+	userDriverOnce.Do(func() { sql.Register("handlers-test-users", userDriver{}) })
+	db, err := sql.Open("handlers-test-users", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A file where the upload directory should be cannot be written to
+	uploads := filepath.Join(t.TempDir(), "uploads")
+	if err := os.WriteFile(uploads, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHealthHandler(db, uploads, nil, false)
+
+	rec := httptest.NewRecorder()
+	h.Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"database": "ok", "uploads": "unavailable"}
+	if body.Status != "unavailable" || len(body.Checks) != len(want) ||
+		body.Checks["database"] != want["database"] || body.Checks["uploads"] != want["uploads"] {
+		t.Errorf("readiness = %+v, want unavailable with checks %v", body, want)
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/tradeid"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultHSNLimit = 20
+	maxHSNLimit     = 100
+)
+
+// HSNHandler serves the HSN tariff master: lookup, suggestions and import
+type HSNHandler struct {
+	accessControl
+	hsnRepo    *repository.HSNRepository
+	hsnService *services.HSNService
+	uploads    config.UploadConfig
+}
+
+// NewHSNHandler creates a new HSN handler
+func NewHSNHandler(hsnRepo *repository.HSNRepository, hsnService *services.HSNService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore, uploads config.UploadConfig) *HSNHandler {
+	return &HSNHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		hsnRepo:       hsnRepo,
+		hsnService:    hsnService,
+		uploads:       uploads,
+	}
+}
+
+// HandleSearch handles GET /api/hsn/search?q=&limit=
+// A numeric query matches codes by prefix; words must all appear in the description.
+func (h *HSNHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if h.getUserID(r) == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "q", Message: "is required"}))
+		return
+	}
+	limit, err := hsnLimit(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if code := tradeid.Normalize(q); isDigits(code) {
+		codes, err := h.hsnRepo.SearchByPrefix(r.Context(), code, limit)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, codes)
+		return
+	}
+
+	codes, err := h.hsnRepo.SearchByKeywords(r.Context(), strings.Fields(strings.ToLower(q)), true, limit)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, codes)
+}
+
+// HandleSuggest handles GET /api/hsn/suggest?job_id= or ?commodity=
+// With job_id the job's stage 1 commodity is used and the job's own HSN
+// code is left out of the history.
+func (h *HSNHandler) HandleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if h.getUserID(r) == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+
+	limit, err := hsnLimit(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	commodity := r.URL.Query().Get("commodity")
+	jobID := 0
+	if s := r.URL.Query().Get("job_id"); s != "" {
+		jobID, err = strconv.Atoi(s)
+		if err != nil {
+			WriteError(w, r, ValidationFailed(FieldError{Field: "job_id", Message: "must be a number"}))
+			return
+		}
+		if !h.hasJobAccess(r, jobID) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		job, err := h.pipelineRepo.GetJobByID(r.Context(), jobID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Job not found"))
+			return
+		}
+		if commodity == "" && job.Stage1 != nil && job.Stage1.Commodity != nil {
+			commodity = *job.Stage1.Commodity
+		}
+	}
+	if strings.TrimSpace(commodity) == "" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "commodity", Message: "is required, or give a job_id with a commodity"}))
+		return
+	}
+
+	suggestions, err := h.hsnService.Suggest(r.Context(), commodity, jobID, limit)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"commodity":   commodity,
+		"suggestions": suggestions,
+	})
+}
+
+// HandleImport handles POST /api/hsn/import (admin only) with a tariff CSV
+// in the multipart field "file"
+func (h *HSNHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.uploads.MaxBytes)
+	if err := r.ParseMultipartForm(h.uploads.MaxBytes); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "file", Message: "is required"}))
+		return
+	}
+	defer file.Close()
+
+	result, err := h.hsnService.Import(r.Context(), file)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// hsnLimit reads the optional limit parameter
+func hsnLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultHSNLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxHSNLimit {
+		return 0, ValidationFailed(FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxHSNLimit)})
+	}
+	return limit, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// IGMHandler imports IGM manifests and creates the draft jobs they propose
+type IGMHandler struct {
+	accessControl
+	igmService *services.IGMService
+	uploads    config.UploadConfig
+}
+
+// NewIGMHandler creates a new IGM handler
+func NewIGMHandler(igmService *services.IGMService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore, uploads config.UploadConfig) *IGMHandler {
+	return &IGMHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		igmService:    igmService,
+		uploads:       uploads,
+	}
+}
+
+// HandleImport handles POST /api/igm/import (admin or subadmin) with an IGM
+// flat file in the multipart field "file". With dry_run=true the
+// reconciliation is reported but no job is changed.
+func (h *IGMHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.uploads.MaxBytes)
+	if err := r.ParseMultipartForm(h.uploads.MaxBytes); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "file", Message: "is required"}))
+		return
+	}
+	defer file.Close()
+
+	dryRun := r.FormValue("dry_run") == "true"
+	result, err := h.igmService.Import(r.Context(), file, h.getUserID(r), dryRun)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// HandleDrafts handles POST /api/igm/drafts (admin or subadmin), creating
+// the draft jobs an import proposed, usually after they were reviewed
+func (h *IGMHandler) HandleDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	var req models.IGMDraftsRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	created, failed := h.igmService.CreateDrafts(r.Context(), req.Jobs, h.getUserID(r))
+	writeJSON(w, map[string]interface{}{
+		"created": created,
+		"errors":  failed,
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// InvoiceHandler serves tax invoice PDFs for stage 4 bills and the
+// template they are drawn with
+type InvoiceHandler struct {
+	accessControl
+	invoiceService *services.InvoiceService
+}
+
+// NewInvoiceHandler creates a new invoice handler
+func NewInvoiceHandler(invoiceService *services.InvoiceService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *InvoiceHandler {
+	return &InvoiceHandler{
+		accessControl:  newAccessControl(pipelineRepo, userRepo, sessionStore),
+		invoiceService: invoiceService,
+	}
+}
+
+// HandleInvoice handles the tax invoice routes of a job:
+//
+//	POST /api/pipeline/jobs/{id}/invoice - generate, store with the stage 4 files and link as the bill copy (admin or subadmin)
+//	GET  /api/pipeline/jobs/{id}/invoice - download the latest generated invoice
+func (h *InvoiceHandler) HandleInvoice(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.generate(w, r, jobID, userID)
+	case http.MethodGet:
+		h.download(w, r, jobID)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// generate issues the bill, so it is kept from customers who may upload
+// to stage 4
+func (h *InvoiceHandler) generate(w http.ResponseWriter, r *http.Request, jobID, userID int) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	file, err := h.invoiceService.Generate(r.Context(), jobID, userID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Job not found"))
+		return
+	}
+	writeJSON(w, models.FileUploadResponse{
+		Success: true,
+		Message: "Tax invoice generated",
+		File:    file,
+	})
+}
+
+// download is open to everyone with access to the job, customers included
+func (h *InvoiceHandler) download(w http.ResponseWriter, r *http.Request, jobID int) {
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	file, err := h.invoiceService.Latest(r.Context(), jobID)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "No tax invoice has been generated for this job"))
+		return
+	}
+	if _, err := os.Stat(file.FilePath); os.IsNotExist(err) {
+		WriteError(w, r, NotFound("File not found on disk"))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.OriginalName))
+	w.Header().Set("Content-Type", "application/pdf")
+	http.ServeFile(w, r, file.FilePath)
+}
+
+// HandleTemplate handles GET and PUT /api/invoice-template (admin only),
+// and POST /api/invoice-template/preview, which renders a template on
+// sample data without saving it
+func (h *InvoiceHandler) HandleTemplate(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	preview := strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/preview")
+	switch {
+	case r.Method == http.MethodPost && preview:
+		var t models.InvoiceTemplate
+		if err := decodeRequest(r, &t); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		pdf, err := h.invoiceService.Preview(&t)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Disposition", "inline; filename=\"invoice_preview.pdf\"")
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdf)
+	case r.Method == http.MethodGet && !preview:
+		t, err := h.invoiceService.Template(r.Context())
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, t)
+	case r.Method == http.MethodPut && !preview:
+		var t models.InvoiceTemplate
+		if err := decodeRequest(r, &t); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if err := h.invoiceService.SaveTemplate(r.Context(), &t, h.getUserID(r)); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"message": "Invoice template saved"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
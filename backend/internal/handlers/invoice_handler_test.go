@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+
+	"github.com/gorilla/sessions"
+)
+
+// userDriver is a database/sql driver whose every query returns the one
+// user row it was opened with, enough for the access checks to look up
+// the session user
+type userDriver struct{}
+
+var (
+	userDriverOnce  sync.Once
+	userDriverMu    sync.Mutex
+	userDriverUsers = map[string]models.User{}
+)
+
+func (userDriver) Open(name string) (driver.Conn, error) {
+	userDriverMu.Lock()
+	defer userDriverMu.Unlock()
+	return userConn{user: userDriverUsers[name]}, nil
+}
+
+type userConn struct{ user models.User }
+
+func (c userConn) Prepare(query string) (driver.Stmt, error) { return userStmt(c), nil }
+func (c userConn) Close() error                              { return nil }
+func (c userConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type userStmt struct{ user models.User }
+
+func (s userStmt) Close() error                               { return nil }
+func (s userStmt) NumInput() int                              { return -1 }
+func (s userStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s userStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &userRows{user: s.user}, nil
+}
+
+type userRows struct {
+	user models.User
+	done bool
+}
+
+func (r *userRows) Columns() []string {
+	return []string{"id", "username", "password_hash", "designation", "is_admin", "role", "party_id", "org_admin", "stages"}
+}
+func (r *userRows) Close() error { return nil }
+func (r *userRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	u := r.user
+	values := []driver.Value{int64(u.ID), u.Username, "", u.Designation, u.IsAdmin, u.Role, nil, false, nil}
+	copy(dest, values)
+	return nil
+}
+
+// sessionAs returns access checks that see user as the session user, and
+// a request carrying their session
+func sessionAs(t *testing.T, user models.User, method, path string) (accessControl, *http.Request) {
+	t.Helper()
+	userDriverOnce.Do(func() { sql.Register("handlers-test-users", userDriver{}) })
+	userDriverMu.Lock()
+	userDriverUsers[t.Name()] = user
+	userDriverMu.Unlock()
+	db, err := sql.Open("handlers-test-users", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := sessions.NewCookieStore([]byte("test-session-key"))
+	login := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(login, "session")
+	session.Values["user_id"] = user.ID
+	if err := session.Save(login, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return newAccessControl(nil, repository.NewUserRepository(db), store), r
+}
+
+func TestCustomersCannotIssueBills(t *testing.T) {
+	customer := models.User{ID: 10, Username: "acme", Role: "customer"}
+	tests := []struct {
+		name  string
+		path  string
+		serve func(ac accessControl, w http.ResponseWriter, r *http.Request)
+	}{
+		{
+			name: "generate the invoice", path: "/api/pipeline/jobs/1/invoice",
+			serve: func(ac accessControl, w http.ResponseWriter, r *http.Request) {
+				(&InvoiceHandler{accessControl: ac}).HandleInvoice(w, r)
+			},
+		},
+		{
+			name: "request a note", path: "/api/pipeline/jobs/1/bill-notes",
+			serve: func(ac accessControl, w http.ResponseWriter, r *http.Request) {
+				(&BillNoteHandler{accessControl: ac}).HandleBillNotes(w, r)
+			},
+		},
+		{
+			name: "print a note", path: "/api/pipeline/jobs/1/bill-notes/2/pdf",
+			serve: func(ac accessControl, w http.ResponseWriter, r *http.Request) {
+				(&BillNoteHandler{accessControl: ac}).HandleBillNotes(w, r)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, r := sessionAs(t, customer, http.MethodPost, tt.path)
+			rec := httptest.NewRecorder()
+			tt.serve(ac, rec, r)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("customer got %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSessionAsSubadmin(t *testing.T) {
+	ac, r := sessionAs(t, models.User{ID: 2, Username: "ops", Role: "subadmin"}, http.MethodPost, "/api/pipeline/jobs/1/invoice")
+	if !ac.isAdminOrSubadmin(r) {
+		t.Error("the subadmin's session was not recognised")
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// LedgerHandler serves job ledgers and the profitability report. Both are
+// for admins and subadmins only.
+type LedgerHandler struct {
+	accessControl
+	ledgerService *services.LedgerService
+}
+
+// NewLedgerHandler creates a new ledger handler
+func NewLedgerHandler(ledgerService *services.LedgerService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *LedgerHandler {
+	return &LedgerHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		ledgerService: ledgerService,
+	}
+}
+
+// HandleJobLedger handles the ledger routes of a job:
+//
+//	GET    /api/pipeline/jobs/{id}/ledger            - entries and what the job earned
+//	POST   /api/pipeline/jobs/{id}/ledger            - book an entry
+//	PUT    /api/pipeline/jobs/{id}/ledger/{entry_id} - replace an entry
+//	DELETE /api/pipeline/jobs/{id}/ledger/{entry_id} - remove an entry booked by hand
+func (h *LedgerHandler) HandleJobLedger(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	// /api/pipeline/jobs/{id}/ledger[/{entry_id}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 5 {
+		switch r.Method {
+		case http.MethodGet:
+			ledger, err := h.ledgerService.Ledger(r.Context(), jobID)
+			if err != nil {
+				WriteError(w, r, orNotFound(err, "Job not found"))
+				return
+			}
+			writeJSON(w, ledger)
+		case http.MethodPost:
+			var req models.LedgerEntryRequest
+			if err := decodeRequest(r, &req); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			entry, err := h.ledgerService.AddEntry(r.Context(), jobID, &req, userID)
+			if err != nil {
+				WriteError(w, r, orNotFound(err, "Job not found"))
+				return
+			}
+			writeJSON(w, entry)
+		default:
+			WriteError(w, r, ErrMethodNotAllowed)
+		}
+		return
+	}
+
+	entryID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil || len(parts) != 6 {
+		WriteError(w, r, BadRequest("Invalid ledger entry ID"))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var req models.LedgerEntryRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		entry, err := h.ledgerService.UpdateEntry(r.Context(), jobID, entryID, &req)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Ledger entry not found"))
+			return
+		}
+		writeJSON(w, entry)
+	case http.MethodDelete:
+		if err := h.ledgerService.DeleteEntry(r.Context(), jobID, entryID); err != nil {
+			WriteError(w, r, orNotFound(err, "Ledger entry not found"))
+			return
+		}
+		writeJSON(w, map[string]interface{}{"message": "Ledger entry deleted"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// HandleProfitability handles GET /api/reports/profitability?by=&from=&to=,
+// grouping jobs billed between from and to by job, customer or month
+func (h *LedgerHandler) HandleProfitability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	var dates [2]*time.Time
+	for i, field := range []string{"from", "to"} {
+		s := query.Get(field)
+		if s == "" {
+			continue
+		}
+		d, err := time.Parse(validation.DateLayout, s)
+		if err != nil {
+			WriteError(w, r, ValidationFailed(FieldError{Field: field, Message: "must be a date in YYYY-MM-DD format"}))
+			return
+		}
+		dates[i] = &d
+	}
+
+	report, err := h.ledgerService.Profitability(r.Context(), query.Get("by"), dates[0], dates[1])
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, report)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultMasterLimit = 10
+	maxMasterLimit     = 50
+)
+
+// masterKinds maps the kinds in master data URLs to the kinds stored
+var masterKinds = map[string]string{
+	"ports":          models.MasterPort,
+	"countries":      models.MasterCountry,
+	"shipping-lines": models.MasterShippingLine,
+	"forwarders":     models.MasterForwarder,
+	"custodians":     models.MasterCustodian,
+}
+
+// MasterHandler serves the master data registry: autocomplete for job
+// forms and the admin's upkeep of masters and their aliases
+type MasterHandler struct {
+	accessControl
+	masterService *services.MasterService
+}
+
+// NewMasterHandler creates a new master data handler
+func NewMasterHandler(masterService *services.MasterService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *MasterHandler {
+	return &MasterHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		masterService: masterService,
+	}
+}
+
+// HandleMasters handles the master data routes, where {kind} is ports,
+// countries, shipping-lines, forwarders or custodians:
+//
+//	GET    /api/masters/{kind}?q=&limit= - every master, or with q the best matches for autocomplete
+//	POST   /api/masters/{kind}           - add a master (admin only)
+//	GET    /api/masters/{kind}/{id}      - one master with its aliases
+//	PUT    /api/masters/{kind}/{id}      - replace a master and its aliases (admin only)
+//	DELETE /api/masters/{kind}/{id}      - remove a master (admin only)
+func (h *MasterHandler) HandleMasters(w http.ResponseWriter, r *http.Request) {
+	if h.getUserID(r) == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/masters/"), "/"), "/")
+	kind, ok := masterKinds[parts[0]]
+	if !ok || len(parts) > 2 {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+	if len(parts) == 1 {
+		h.masters(w, r, kind)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid master ID"))
+		return
+	}
+	h.master(w, r, kind, id)
+}
+
+func (h *MasterHandler) masters(w http.ResponseWriter, r *http.Request, kind string) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, err := masterLimit(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		masters, err := h.masterService.List(r.Context(), kind, r.URL.Query().Get("q"), limit)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, masters)
+	case http.MethodPost:
+		if !h.isAdmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		h.save(w, r, kind, 0)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *MasterHandler) master(w http.ResponseWriter, r *http.Request, kind string, id int) {
+	if r.Method == http.MethodGet {
+		master, err := h.masterService.Get(r.Context(), kind, id)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Master not found"))
+			return
+		}
+		writeJSON(w, master)
+		return
+	}
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.save(w, r, kind, id)
+	case http.MethodDelete:
+		if err := h.masterService.Delete(r.Context(), kind, id); err != nil {
+			WriteError(w, r, orNotFound(err, "Master not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Master deleted"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *MasterHandler) save(w http.ResponseWriter, r *http.Request, kind string, id int) {
+	var req models.MasterRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	master, err := h.masterService.Save(r.Context(), kind, id, &req)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Master not found"))
+		return
+	}
+	writeJSON(w, master)
+}
+
+// masterLimit reads the optional limit parameter of an autocomplete query
+func masterLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultMasterLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxMasterLimit {
+		return 0, ValidationFailed(FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxMasterLimit)})
+	}
+	return limit, nil
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+
+	"github.com/gorilla/sessions"
+)
+
+// OrganizationHandler serves customer organizations: the members and
+// invitations an org admin manages for their own organization, the same
+// for staff on any party, and the acceptance of invitations
+type OrganizationHandler struct {
+	accessControl
+	orgService *services.OrganizationService
+}
+
+// NewOrganizationHandler creates a new organization handler
+func NewOrganizationHandler(orgService *services.OrganizationService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *OrganizationHandler {
+	return &OrganizationHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		orgService:    orgService,
+	}
+}
+
+// HandleOrganization handles the routes of the session user's organization,
+// for its org admins:
+//
+//	GET    /api/organization                   - the organization with its members and pending invitations
+//	GET    /api/organization/members           - its members
+//	PUT    /api/organization/members/{user_id} - set a member's org_admin right and stages
+//	DELETE /api/organization/members/{user_id} - remove a member
+//	GET    /api/organization/invitations       - its pending invitations
+//	POST   /api/organization/invitations       - invite a colleague by email
+//	DELETE /api/organization/invitations/{id}  - revoke an invitation
+func (h *OrganizationHandler) HandleOrganization(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil || user.PartyID == nil || !user.OrgAdmin {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	// /api/organization[/...]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	h.route(w, r, user, *user.PartyID, parts[2:], false)
+}
+
+// HandlePartyOrganization handles the same routes as HandleOrganization
+// under /api/parties/{id}/members and /api/parties/{id}/invitations, for
+// admins and subadmins. PUT members/{user_id} also adds a customer user
+// to the party, moving them from any other.
+func (h *OrganizationHandler) HandlePartyOrganization(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	user, err := h.userRepo.GetByID(h.getUserID(r))
+	if err != nil {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	// /api/parties/{id}/...
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+	partyID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid party ID"))
+		return
+	}
+	h.route(w, r, user, partyID, parts[3:], true)
+}
+
+// HandleAccept handles POST /api/invitations/accept, which needs no login:
+// it creates the invited customer user, who can then log in
+func (h *OrganizationHandler) HandleAccept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	var req models.InvitationAcceptRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	user, err := h.orgService.AcceptInvitation(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, user)
+}
+
+// route serves the routes below an organization, rest being the path after
+// it. staff may add users to the organization.
+func (h *OrganizationHandler) route(w http.ResponseWriter, r *http.Request, user *models.User, partyID int, rest []string, staff bool) {
+	if len(rest) == 0 {
+		if r.Method != http.MethodGet {
+			WriteError(w, r, ErrMethodNotAllowed)
+			return
+		}
+		org, err := h.orgService.Get(r.Context(), partyID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Organization not found"))
+			return
+		}
+		writeJSON(w, org)
+		return
+	}
+	if len(rest) > 2 || (rest[0] != "members" && rest[0] != "invitations") {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+
+	id := 0
+	if len(rest) == 2 {
+		var err error
+		if id, err = strconv.Atoi(rest[1]); err != nil {
+			WriteError(w, r, BadRequest("Invalid ID"))
+			return
+		}
+	}
+	if rest[0] == "members" {
+		h.members(w, r, partyID, id, staff)
+	} else {
+		h.invitations(w, r, user, partyID, id)
+	}
+}
+
+func (h *OrganizationHandler) members(w http.ResponseWriter, r *http.Request, partyID, userID int, staff bool) {
+	switch {
+	case userID == 0 && r.Method == http.MethodGet:
+		org, err := h.orgService.Get(r.Context(), partyID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Organization not found"))
+			return
+		}
+		writeJSON(w, org.Members)
+	case userID != 0 && r.Method == http.MethodPut:
+		var req models.OrgMemberRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if err := h.orgService.SetMember(r.Context(), partyID, userID, &req, staff); err != nil {
+			WriteError(w, r, orNotFound(err, "Member not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Member updated"})
+	case userID != 0 && r.Method == http.MethodDelete:
+		if err := h.orgService.RemoveMember(r.Context(), partyID, userID); err != nil {
+			WriteError(w, r, orNotFound(err, "Member not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Member removed"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *OrganizationHandler) invitations(w http.ResponseWriter, r *http.Request, user *models.User, partyID, id int) {
+	switch {
+	case id == 0 && r.Method == http.MethodGet:
+		org, err := h.orgService.Get(r.Context(), partyID)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Organization not found"))
+			return
+		}
+		writeJSON(w, org.Invitations)
+	case id == 0 && r.Method == http.MethodPost:
+		var req models.OrgInvitationRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		inv, err := h.orgService.Invite(r.Context(), partyID, user, &req)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Organization not found"))
+			return
+		}
+		writeJSON(w, inv)
+	case id != 0 && r.Method == http.MethodDelete:
+		if err := h.orgService.RevokeInvitation(r.Context(), partyID, id); err != nil {
+			WriteError(w, r, orNotFound(err, "Invitation not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Invitation revoked"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultPartyLimit = 10
+	maxPartyLimit     = 50
+)
+
+// PartyHandler serves the party directory of consignees and shippers
+type PartyHandler struct {
+	accessControl
+	partyService *services.PartyService
+}
+
+// NewPartyHandler creates a new party handler
+func NewPartyHandler(partyService *services.PartyService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *PartyHandler {
+	return &PartyHandler{
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
+		partyService:  partyService,
+	}
+}
+
+// HandleParties handles GET /api/parties?q=&limit= (every party, or with q
+// the best matches for autocomplete) and POST /api/parties (add a party),
+// for admins and subadmins
+func (h *PartyHandler) HandleParties(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit, err := partyLimit(r)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		parties, err := h.partyService.List(r.Context(), r.URL.Query().Get("q"), limit)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, parties)
+	case http.MethodPost:
+		h.save(w, r, 0)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// HandleParty handles the routes of one party, for admins and subadmins:
+//
+//	GET    /api/parties/{id}?as_of= - the party with its jobs, open bills and documents
+//	PUT    /api/parties/{id}        - replace the party, its aliases and contacts
+//	DELETE /api/parties/{id}        - remove the party (admin only)
+func (h *PartyHandler) HandleParty(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	// /api/parties/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		WriteError(w, r, NotFound("Not found"))
+		return
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid party ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		asOf := time.Now()
+		if s := r.URL.Query().Get("as_of"); s != "" {
+			if asOf, err = time.Parse(validation.DateLayout, s); err != nil {
+				WriteError(w, r, ValidationFailed(FieldError{Field: "as_of", Message: "must be a date in YYYY-MM-DD format"}))
+				return
+			}
+		}
+		detail, err := h.partyService.Detail(r.Context(), id, asOf)
+		if err != nil {
+			WriteError(w, r, orNotFound(err, "Party not found"))
+			return
+		}
+		writeJSON(w, detail)
+	case http.MethodPut:
+		h.save(w, r, id)
+	case http.MethodDelete:
+		if !h.isAdmin(r) {
+			WriteError(w, r, services.ErrForbidden)
+			return
+		}
+		if err := h.partyService.Delete(r.Context(), id); err != nil {
+			WriteError(w, r, orNotFound(err, "Party not found"))
+			return
+		}
+		writeJSON(w, map[string]string{"message": "Party deleted"})
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+func (h *PartyHandler) save(w http.ResponseWriter, r *http.Request, id int) {
+	var req models.PartyRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	party, err := h.partyService.Save(r.Context(), id, &req)
+	if err != nil {
+		WriteError(w, r, orNotFound(err, "Party not found"))
+		return
+	}
+	writeJSON(w, party)
+}
+
+// partyLimit reads the optional limit parameter of an autocomplete query
+func partyLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultPartyLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxPartyLimit {
+		return 0, ValidationFailed(FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxPartyLimit)})
+	}
+	return limit, nil
+}
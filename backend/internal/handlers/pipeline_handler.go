@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,26 +14,37 @@ import (
 	"strings"
 	"time"
 
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/metrics"
 	"maydiv-crm/internal/models"
 	"maydiv-crm/internal/repository"
 	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
 
 	"github.com/gorilla/sessions"
 )
 
 type PipelineHandler struct {
-	pipelineRepo *repository.PipelineRepository
-	userRepo     *repository.UserRepository
-	sessionStore *sessions.CookieStore
+	accessControl
 	notificationService *services.NotificationService
+	filingService *services.FilingService
+	billingService *services.BillingService
+	ledgerService *services.LedgerService
+	depositService *services.DepositService
+	partyService *services.PartyService
+	uploads      config.UploadConfig
 }
 
-func NewPipelineHandler(pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore, notificationService *services.NotificationService) *PipelineHandler {
+func NewPipelineHandler(pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore, notificationService *services.NotificationService, filingService *services.FilingService, billingService *services.BillingService, ledgerService *services.LedgerService, depositService *services.DepositService, partyService *services.PartyService, uploads config.UploadConfig) *PipelineHandler {
 	return &PipelineHandler{
-		pipelineRepo: pipelineRepo,
-		userRepo:     userRepo,
-		sessionStore: sessionStore,
+		accessControl: newAccessControl(pipelineRepo, userRepo, sessionStore),
 		notificationService: notificationService,
+		filingService: filingService,
+		billingService: billingService,
+		ledgerService: ledgerService,
+		depositService: depositService,
+		partyService: partyService,
+		uploads:      uploads,
 	}
 }
 
@@ -42,39 +55,35 @@ func (h *PipelineHandler) HandleJobs(w http.ResponseWriter, r *http.Request) {
 	} else if r.Method == http.MethodPost {
 		h.createJob(w, r)
 	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 	}
 }
 
 // HandleMyJobs handles GET /api/pipeline/myjobs - gets jobs assigned to current user based on their role
 func (h *PipelineHandler) HandleMyJobs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	userID := h.getUserID(r)
-	fmt.Printf("HandleMyJobs - getUserID returned: %d\n", userID)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 
 	// Get user role
-	fmt.Printf("Looking up user with ID: %d\n", userID)
 	user, err := h.userRepo.GetByID(userID)
 	if err != nil {
-		fmt.Printf("User not found error: %v\n", err)
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, r, orNotFound(err, "User not found"))
 		return
 	}
-	fmt.Printf("Found user: %s (ID: %d, Role: %s)\n", user.Username, user.ID, user.Role)
 
 	// Admin gets all jobs
 	if user.IsAdmin {
-		jobs, err := h.pipelineRepo.GetAllJobs()
+		jobs, err := h.pipelineRepo.GetAllJobs(r.Context())
 		if err != nil {
-			http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+			WriteError(w, r, err)
 			return
 		}
 		writeJSON(w, jobs)
@@ -83,9 +92,9 @@ func (h *PipelineHandler) HandleMyJobs(w http.ResponseWriter, r *http.Request) {
 
 	// Subadmin gets all jobs (same as admin)
 	if user.Role == "subadmin" {
-		jobs, err := h.pipelineRepo.GetAllJobs()
+		jobs, err := h.pipelineRepo.GetAllJobs(r.Context())
 		if err != nil {
-			http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+			WriteError(w, r, err)
 			return
 		}
 		writeJSON(w, jobs)
@@ -93,36 +102,36 @@ func (h *PipelineHandler) HandleMyJobs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get jobs based on user role
-	fmt.Printf("Getting jobs for user ID %d with role %s\n", userID, user.Role)
-	jobs, err := h.pipelineRepo.GetJobsByUserRole(userID, user.Role)
+	jobs, err := h.pipelineRepo.GetJobsByUserRole(r.Context(), userID, user.Role)
 	if err != nil {
-		fmt.Printf("Error getting jobs: %v\n", err)
-		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
+	for i := range jobs {
+		hideStages(user, &jobs[i])
+	}
 
-	fmt.Printf("Found %d jobs for user\n", len(jobs))
 	writeJSON(w, jobs)
 }
 
 // Debug endpoint to check database state
 func (h *PipelineHandler) HandleDebug(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	// Get all users
 	users, err := h.userRepo.GetAll()
 	if err != nil {
-		http.Error(w, "Failed to get users", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
 	// Get all jobs
-	jobs, err := h.pipelineRepo.GetAllJobs()
+	jobs, err := h.pipelineRepo.GetAllJobs(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to get jobs", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
@@ -137,48 +146,44 @@ func (h *PipelineHandler) HandleDebug(w http.ResponseWriter, r *http.Request) {
 // HandleJobByID handles GET /api/pipeline/jobs/{id} - get specific job details
 func (h *PipelineHandler) HandleJobByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 
 	// Extract job ID from URL
 	pathParts := strings.Split(r.URL.Path, "/")
-	fmt.Printf("URL Path: %s\n", r.URL.Path)
-	fmt.Printf("Path parts: %v\n", pathParts)
-	fmt.Printf("Path parts length: %d\n", len(pathParts))
 	
 	if len(pathParts) < 5 {
-		fmt.Printf("Invalid path length: %d\n", len(pathParts))
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
 		return
 	}
 
-	fmt.Printf("Job ID from path: %s\n", pathParts[4])
 	jobID, err := strconv.Atoi(pathParts[4])
 	if err != nil {
-		fmt.Printf("Error converting job ID: %v\n", err)
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
 		return
 	}
-	fmt.Printf("Parsed job ID: %d\n", jobID)
 
-	job, err := h.pipelineRepo.GetJobByID(jobID)
+	job, err := h.pipelineRepo.GetJobByID(r.Context(), jobID)
 	if err != nil {
-		http.Error(w, "Job not found", http.StatusNotFound)
+		WriteError(w, r, orNotFound(err, "Job not found"))
 		return
 	}
 
 	// Check if user has access to this job
 	if !h.hasJobAccess(r, jobID) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
+	if user, err := h.userRepo.GetByID(userID); err == nil {
+		hideStages(user, job)
+	}
 
 	writeJSON(w, job)
 }
@@ -186,160 +191,223 @@ func (h *PipelineHandler) HandleJobByID(w http.ResponseWriter, r *http.Request)
 // HandleStage2Update handles PUT /api/pipeline/jobs/{id}/stage2
 func (h *PipelineHandler) HandleStage2Update(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 
 	// Check if user is stage2 employee or admin
 	user, err := h.userRepo.GetByID(userID)
 	if err != nil || (!user.IsAdmin && user.Role != "stage2_employee") {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 
 	jobID, err := h.extractJobID(r)
 	if err != nil {
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
 		return
 	}
 
 	var req models.Stage2UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
-	err = h.pipelineRepo.UpdateStage2Data(jobID, &req, userID)
+	err = h.pipelineRepo.UpdateStage2Data(r.Context(), jobID, &req, userID)
 	if err != nil {
-		http.Error(w, "Failed to update stage 2 data", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
+	h.syncLedger(r, jobID)
+
+	// The HSN code may have changed, so work out the filings again. The
+	// stage data is saved either way; the filings are worked out again on
+	// the next save.
+	var warnings []FieldError
+	filings, err := h.filingService.Evaluate(r.Context(), jobID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to evaluate job filings", "job_id", jobID, "error", err)
+		warnings = append(warnings, FieldError{Field: "filings", Message: "could not be worked out; save the stage again to retry"})
+	}
 
 	// Send notification to admin about stage completion
-	go func() {
-		if err := h.notificationService.NotifyStageCompletion(jobID, "stage2", userID); err != nil {
-			fmt.Printf("Failed to send stage 2 completion notification: %v\n", err)
-		}
-	}()
+	ctx := context.WithoutCancel(r.Context())
+	h.notificationService.Go(ctx, "stage 2 completion", func() error {
+		return h.notificationService.NotifyStageCompletion(ctx, jobID, "stage2", userID)
+	})
 
-	writeJSON(w, map[string]string{"message": "Stage 2 data updated successfully"})
+	resp := map[string]interface{}{
+		"message": "Stage 2 data updated successfully",
+		"filings": filings,
+	}
+	if msg := services.OpenFilingsWarning(filings); msg != "" {
+		warnings = append(warnings, FieldError{Field: "filings", Message: msg})
+	}
+	// Duty paid on the customer's behalf comes out of their deposit
+	check, err := h.depositService.Check(r.Context(), jobID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to check customer deposit", "job_id", jobID, "error", err)
+	} else if msg := services.ShortfallWarning(check); msg != "" {
+		warnings = append(warnings, FieldError{Field: "deposit", Message: msg})
+	}
+	if len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	writeJSON(w, resp)
 }
 
 // HandleStage3Update handles PUT /api/pipeline/jobs/{id}/stage3
 func (h *PipelineHandler) HandleStage3Update(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 
 	// Check if user is stage3 employee or admin
 	user, err := h.userRepo.GetByID(userID)
 	if err != nil || (!user.IsAdmin && user.Role != "stage3_employee") {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 
 	jobID, err := h.extractJobID(r)
 	if err != nil {
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
 		return
 	}
 
 	var req models.Stage3UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if details := unidentifiedContainers(req.Containers); len(details) > 0 {
+		WriteError(w, r, ValidationFailed(details...))
 		return
 	}
 
-	err = h.pipelineRepo.UpdateStage3Data(jobID, &req, userID)
+	err = h.pipelineRepo.UpdateStage3Data(r.Context(), jobID, &req, userID)
 	if err != nil {
-		http.Error(w, "Failed to update stage 3 data", http.StatusInternalServerError)
+		WriteError(w, r, orNotFound(err, "Container not found"))
 		return
 	}
+	h.syncLedger(r, jobID)
 
 	// Send notification to admin about stage completion
-	go func() {
-		if err := h.notificationService.NotifyStageCompletion(jobID, "stage3", userID); err != nil {
-			fmt.Printf("Failed to send stage 3 completion notification: %v\n", err)
-		}
-	}()
+	ctx := context.WithoutCancel(r.Context())
+	h.notificationService.Go(ctx, "stage 3 completion", func() error {
+		return h.notificationService.NotifyStageCompletion(ctx, jobID, "stage3", userID)
+	})
+
+	warnings := containerWarnings(req.Containers)
+	// Starting stage 3 completes stage 2; flag filings left behind
+	filings, err := h.filingService.List(r.Context(), jobID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to load job filings", "job_id", jobID, "error", err)
+	} else if msg := services.OpenFilingsWarning(filings); msg != "" {
+		warnings = append(warnings, FieldError{Field: "filings", Message: "Stage 2 completed but " + msg})
+	}
 
-	writeJSON(w, map[string]string{"message": "Stage 3 data updated successfully"})
+	resp := map[string]interface{}{"message": "Stage 3 data updated successfully"}
+	if len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	writeJSON(w, resp)
 }
 
 // HandleStage4Update handles PUT /api/pipeline/jobs/{id}/stage4
 func (h *PipelineHandler) HandleStage4Update(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 
 	// Check if user is customer or admin
 	user, err := h.userRepo.GetByID(userID)
 	if err != nil || (!user.IsAdmin && user.Role != "customer") {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 
 	jobID, err := h.extractJobID(r)
 	if err != nil {
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+	if !h.hasJobAccess(r, jobID) {
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 
 	var req models.Stage4UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
-	err = h.pipelineRepo.UpdateStage4Data(jobID, &req, userID)
+	err = h.billingService.SaveStage4(r.Context(), jobID, &req, userID, user.IsAdmin)
 	if err != nil {
-		http.Error(w, "Failed to update stage 4 data", http.StatusInternalServerError)
+		WriteError(w, r, orNotFound(err, "Job not found"))
 		return
 	}
+	h.syncLedger(r, jobID)
 
 	// Send notification to admin about stage completion
-	go func() {
-		if err := h.notificationService.NotifyStageCompletion(jobID, "stage4", userID); err != nil {
-			fmt.Printf("Failed to send stage 4 completion notification: %v\n", err)
-		}
-	}()
+	ctx := context.WithoutCancel(r.Context())
+	h.notificationService.Go(ctx, "stage 4 completion", func() error {
+		return h.notificationService.NotifyStageCompletion(ctx, jobID, "stage4", userID)
+	})
 
 	writeJSON(w, map[string]string{"message": "Stage 4 data updated successfully"})
 }
 
+// syncLedger books the amounts just saved in the job's ledger and debits
+// the customer's deposit for them. The stage
+// data is saved either way; a ledger left behind is caught up on the next
+// save or by cmd/backfillledger.
+func (h *PipelineHandler) syncLedger(r *http.Request, jobID int) {
+	if err := h.ledgerService.Sync(r.Context(), jobID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to sync job ledger", "job_id", jobID, "error", err)
+	}
+}
+
 // File upload handlers
 func (h *PipelineHandler) HandleFileUpload(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form (max 50MB)
-	err := r.ParseMultipartForm(50 << 20)
+	// Parse multipart form, rejecting bodies over the configured limit
+	r.Body = http.MaxBytesReader(w, r.Body, h.uploads.MaxBytes)
+	err := r.ParseMultipartForm(h.uploads.MaxBytes)
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		var maxBytesErr *http.MaxBytesError
+		if !errors.As(err, &maxBytesErr) {
+			err = BadRequest("Failed to parse form")
+		}
+		WriteError(w, r, err)
 		return
 	}
 	
 	// Get user from session
 	userID, err := h.getUserIDFromSession(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 	
@@ -348,42 +416,42 @@ func (h *PipelineHandler) HandleFileUpload(w http.ResponseWriter, r *http.Reques
 	stage := r.FormValue("stage")
 	description := r.FormValue("description")
 	
-	if jobIDStr == "" || stage == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	jobID, err := strconv.Atoi(jobIDStr)
+	if err != nil && jobIDStr != "" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "job_id", Message: "must be a number"}))
 		return
 	}
 	
-	jobID, err := strconv.Atoi(jobIDStr)
-	if err != nil {
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+	req := models.FileUploadRequest{JobID: jobID, Stage: stage, Description: description}
+	if err := validation.Struct(&req); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 	
 	// Check if user has access to this job
 	if !h.hasJobAccess(r, jobID) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 	
 	// Check file upload permissions based on user role and stage
 	if !h.canUploadToStage(r, jobID, stage) {
-		http.Error(w, "You don't have permission to upload files to this stage", http.StatusForbidden)
+		WriteError(w, r, NewError(http.StatusForbidden, CodeForbidden, "You don't have permission to upload files to this stage"))
 		return
 	}
 	
 	// Get uploaded file
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "No file uploaded", http.StatusBadRequest)
+		WriteError(w, r, ValidationFailed(FieldError{Field: "file", Message: "is required"}))
 		return
 	}
 	defer file.Close()
 	
 	// Create uploads directory if it doesn't exist
-	uploadDir := "uploads"
+	uploadDir := h.uploads.Dir
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Printf("Error creating upload directory: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, fmt.Errorf("creating upload directory %s: %w", uploadDir, err))
 		return
 	}
 	
@@ -395,28 +463,26 @@ func (h *PipelineHandler) HandleFileUpload(w http.ResponseWriter, r *http.Reques
 	// Create file on disk
 	dst, err := os.Create(filePath)
 	if err != nil {
-		log.Printf("Error creating file: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, fmt.Errorf("creating %s: %w", filePath, err))
 		return
 	}
 	defer dst.Close()
 	
 	// Copy uploaded file to destination
-	_, err = io.Copy(dst, file)
+	written, err := io.Copy(dst, file)
 	if err != nil {
-		log.Printf("Error copying file: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, fmt.Errorf("writing %s: %w", filePath, err))
 		return
 	}
+	metrics.UploadedBytes(stage, written)
 	
 	// Save file info to database
-	uploadedFile, err := h.pipelineRepo.UploadFile(
+	uploadedFile, err := h.pipelineRepo.UploadFile(r.Context(), 
 		jobID, stage, userID, fileName, header.Filename, filePath, 
 		header.Size, header.Header.Get("Content-Type"), description,
 	)
 	if err != nil {
-		log.Printf("Error saving file info: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -433,32 +499,32 @@ func (h *PipelineHandler) HandleFileDownload(w http.ResponseWriter, r *http.Requ
 	// Get file ID from URL
 	fileIDStr := r.URL.Query().Get("id")
 	if fileIDStr == "" {
-		http.Error(w, "File ID required", http.StatusBadRequest)
+		WriteError(w, r, ValidationFailed(FieldError{Field: "id", Message: "is required"}))
 		return
 	}
 	
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid file ID"))
 		return
 	}
 	
 	// Get file info from database
-	file, err := h.pipelineRepo.GetFileByID(fileID)
+	file, err := h.pipelineRepo.GetFileByID(r.Context(), fileID)
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+		WriteError(w, r, orNotFound(err, "File not found"))
 		return
 	}
 	
-	// Check if user has access to this job
-	if !h.hasJobAccess(r, file.JobID) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Check if user has access to this job and stage
+	if !h.hasStageAccess(r, file.JobID, file.Stage) {
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 	
 	// Check if file exists on disk
 	if _, err := os.Stat(file.FilePath); os.IsNotExist(err) {
-		http.Error(w, "File not found on disk", http.StatusNotFound)
+		WriteError(w, r, NotFound("File not found on disk"))
 		return
 	}
 	
@@ -475,27 +541,26 @@ func (h *PipelineHandler) HandleGetFiles(w http.ResponseWriter, r *http.Request)
 	stage := r.URL.Query().Get("stage")
 	
 	if jobIDStr == "" || stage == "" {
-		http.Error(w, "Job ID and stage required", http.StatusBadRequest)
+		WriteError(w, r, ValidationFailed(requiredFields(map[string]string{"job_id": jobIDStr, "stage": stage})...))
 		return
 	}
 	
 	jobID, err := strconv.Atoi(jobIDStr)
 	if err != nil {
-		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid job ID"))
 		return
 	}
 	
-	// Check if user has access to this job
-	if !h.hasJobAccess(r, jobID) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Check if user has access to this job and stage
+	if !h.hasStageAccess(r, jobID, stage) {
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 	
 	// Get files for this job and stage
-	files, err := h.pipelineRepo.GetFilesByJobAndStage(jobID, stage)
+	files, err := h.pipelineRepo.GetFilesByJobAndStage(r.Context(), jobID, stage)
 	if err != nil {
-		log.Printf("Error getting files: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -505,34 +570,33 @@ func (h *PipelineHandler) HandleGetFiles(w http.ResponseWriter, r *http.Request)
 
 func (h *PipelineHandler) HandleDeleteFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 	
 	fileIDStr := r.URL.Query().Get("id")
 	if fileIDStr == "" {
-		http.Error(w, "File ID required", http.StatusBadRequest)
+		WriteError(w, r, ValidationFailed(FieldError{Field: "id", Message: "is required"}))
 		return
 	}
 	
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid file ID"))
 		return
 	}
 	
 	// Get user from session
 	userID, err := h.getUserIDFromSession(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 	
 	// Delete file
-	err = h.pipelineRepo.DeleteFile(fileID, userID)
+	err = h.pipelineRepo.DeleteFile(r.Context(), fileID, userID)
 	if err != nil {
-		log.Printf("Error deleting file: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteError(w, r, orNotFound(err, "File not found"))
 		return
 	}
 	
@@ -543,219 +607,137 @@ func (h *PipelineHandler) HandleDeleteFile(w http.ResponseWriter, r *http.Reques
 	})
 }
 
-// Private helper methods
-
-func (h *PipelineHandler) getAllJobs(w http.ResponseWriter, r *http.Request) {
-	if !h.isAdminOrSubadmin(r) {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
-	jobs, err := h.pipelineRepo.GetAllJobs()
-	if err != nil {
-		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+// HandlePartyIdentifiers handles GET /api/pipeline/party-identifiers?party=consignee&name=
+// so the job form can prefill GSTIN and IEC as soon as a party is entered
+func (h *PipelineHandler) HandlePartyIdentifiers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
-
-	writeJSON(w, jobs)
-}
-
-func (h *PipelineHandler) createJob(w http.ResponseWriter, r *http.Request) {
 	if !h.isAdminOrSubadmin(r) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 
-	userID := h.getUserID(r)
-	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-		var req models.Stage1CreateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("JSON decode error: %v\n", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	party := r.URL.Query().Get("party")
+	name := r.URL.Query().Get("name")
+	if details := requiredFields(map[string]string{"party": party, "name": name}); len(details) > 0 {
+		WriteError(w, r, ValidationFailed(details...))
 		return
 	}
-	
-	// Validate required fields
-	if req.JobNo == "" {
-		http.Error(w, "Job number is required", http.StatusBadRequest)
+	if party != "consignee" && party != "shipper" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "party", Message: "must be one of: consignee, shipper"}))
 		return
 	}
 
-	job, err := h.pipelineRepo.CreateJob(&req, userID)
+	ids, err := h.pipelineRepo.LastPartyIdentifiers(r.Context(), party, name)
 	if err != nil {
-		if strings.Contains(err.Error(), "Duplicate entry") {
-			http.Error(w, "Job number already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	// Send notification to admin about new job creation
-	go func() {
-		if err := h.notificationService.NotifyJobCreation(job.ID, userID); err != nil {
-			fmt.Printf("Failed to send job creation notification: %v\n", err)
-		}
-	}()
-
-	writeJSON(w, job)
+	writeJSON(w, map[string]string{
+		"party": party,
+		"name":  name,
+		"gstin": ids.GSTIN,
+		"iec":   ids.IEC,
+	})
 }
 
-func (h *PipelineHandler) extractJobID(r *http.Request) (int, error) {
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 5 {
-		return 0, fmt.Errorf("invalid path")
-	}
-
-	return strconv.Atoi(pathParts[4])
-}
+// Private helper methods
 
-func (h *PipelineHandler) hasJobAccess(r *http.Request, jobID int) bool {
-	userID := h.getUserID(r)
-	if userID == 0 {
-		return false
+func (h *PipelineHandler) getAllJobs(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
 	}
 
-	user, err := h.userRepo.GetByID(userID)
+	jobs, err := h.pipelineRepo.GetAllJobs(r.Context())
 	if err != nil {
-		return false
-	}
-
-	// Admin and subadmin have access to all jobs
-	if user.IsAdmin || user.Role == "subadmin" {
-		return true
+		WriteError(w, r, err)
+		return
 	}
 
-	// Get job details to check role-based access
-	job, err := h.pipelineRepo.GetJobByID(jobID)
-	if err != nil {
-		return false
-	}
+	writeJSON(w, jobs)
+}
 
-	// Check role-based access
-	switch user.Role {
-	case "stage2_employee":
-		return job.AssignedToStage2 != nil && *job.AssignedToStage2 == userID
-	case "stage3_employee":
-		return job.AssignedToStage3 != nil && *job.AssignedToStage3 == userID
-	case "customer":
-		return job.CustomerID != nil && *job.CustomerID == userID
-	default:
-		return false
+func (h *PipelineHandler) createJob(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
 	}
-}
 
-func (h *PipelineHandler) canUploadToStage(r *http.Request, jobID int, stage string) bool {
 	userID := h.getUserID(r)
 	if userID == 0 {
-		return false
-	}
-
-	user, err := h.userRepo.GetByID(userID)
-	if err != nil {
-		return false
-	}
-
-	// Admin and subadmin can upload to any stage
-	if user.IsAdmin || user.Role == "subadmin" {
-		return true
-	}
-
-	// Get job details to check role-based access
-	job, err := h.pipelineRepo.GetJobByID(jobID)
-	if err != nil {
-		return false
-	}
-
-	// Check role-based access for upload permission
-	switch user.Role {
-	case "stage2_employee":
-		// Stage 2 employee can only upload to stage2
-		return stage == "stage2" && job.AssignedToStage2 != nil && *job.AssignedToStage2 == userID
-	case "stage3_employee":
-		// Stage 3 employee can only upload to stage3
-		return stage == "stage3" && job.AssignedToStage3 != nil && *job.AssignedToStage3 == userID
-	case "customer":
-		// Customer can only upload to stage4
-		return stage == "stage4" && job.CustomerID != nil && *job.CustomerID == userID
-	case "stage1_employee":
-		// Stage 1 employee can only upload to stage1
-		return stage == "stage1" && job.CreatedBy == userID
-	default:
-		return false
-	}
-}
-
-func (h *PipelineHandler) isAdmin(r *http.Request) bool {
-	session, err := h.sessionStore.Get(r, "session")
-	if err != nil {
-		return false
+		WriteError(w, r, services.ErrUnauthorized)
+		return
 	}
 
-	userID, ok := session.Values["user_id"].(int)
-	if !ok {
-		return false
+		var req models.Stage1CreateRequest
+	if err := decodeRequest(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
 	}
 
-	user, err := h.userRepo.GetByID(userID)
-	if err != nil {
-		return false
+	// Link the consignee and shipper to their parties, then fill in
+	// identifiers they used on earlier jobs
+	if err := h.partyService.FillJobParties(r.Context(), &req); err != nil {
+		WriteError(w, r, err)
+		return
 	}
-
-	return user.IsAdmin
-}
-
-func (h *PipelineHandler) isSubadmin(r *http.Request) bool {
-	session, err := h.sessionStore.Get(r, "session")
-	if err != nil {
-		return false
+	if err := h.prefillPartyIdentifiers(r.Context(), &req); err != nil {
+		WriteError(w, r, err)
+		return
 	}
 
-	userID, ok := session.Values["user_id"].(int)
-	if !ok {
-		return false
+	job, err := h.pipelineRepo.CreateJob(r.Context(), &req, userID)
+	if errors.Is(err, repository.ErrDuplicate) {
+		apiErr := NewError(http.StatusConflict, CodeDuplicateJobNo, "Job number already exists")
+		apiErr.Details = []FieldError{{Field: "job_no", Message: "already exists"}}
+		WriteError(w, r, apiErr)
+		return
 	}
-
-	user, err := h.userRepo.GetByID(userID)
 	if err != nil {
-		return false
+		WriteError(w, r, err)
+		return
 	}
 
-	return user.Role == "subadmin"
-}
+	// Send notification to admin about new job creation
+	ctx := context.WithoutCancel(r.Context())
+	h.notificationService.Go(ctx, "job creation", func() error {
+		return h.notificationService.NotifyJobCreation(ctx, job.ID, userID)
+	})
 
-func (h *PipelineHandler) isAdminOrSubadmin(r *http.Request) bool {
-	return h.isAdmin(r) || h.isSubadmin(r)
+	writeJSON(w, job)
 }
 
-func (h *PipelineHandler) getUserID(r *http.Request) int {
-	session, err := h.sessionStore.Get(r, "session")
-	if err != nil {
-		return 0
-	}
-
-	userID, ok := session.Values["user_id"].(int)
-	if !ok {
-		return 0
+// prefillPartyIdentifiers copies the last known GSTIN and IEC of the
+// consignee and shipper into fields the request left empty
+func (h *PipelineHandler) prefillPartyIdentifiers(ctx context.Context, req *models.Stage1CreateRequest) error {
+	parties := []struct {
+		party      string
+		name       string
+		gstin, iec *string
+	}{
+		{"consignee", req.Consignee, &req.ConsigneeGSTIN, &req.ConsigneeIEC},
+		{"shipper", req.Shipper, &req.ShipperGSTIN, &req.ShipperIEC},
+	}
+
+	for _, p := range parties {
+		if strings.TrimSpace(p.name) == "" || (*p.gstin != "" && *p.iec != "") {
+			continue
+		}
+		ids, err := h.pipelineRepo.LastPartyIdentifiers(ctx, p.party, p.name)
+		if err != nil {
+			return err
+		}
+		if *p.gstin == "" {
+			*p.gstin = ids.GSTIN
+		}
+		if *p.iec == "" {
+			*p.iec = ids.IEC
+		}
 	}
-
-	return userID
+	return nil
 }
 
-func (h *PipelineHandler) getUserIDFromSession(r *http.Request) (int, error) {
-	session, err := h.sessionStore.Get(r, "session")
-	if err != nil {
-		return 0, err
-	}
-
-	userID, ok := session.Values["user_id"].(int)
-	if !ok {
-		return 0, fmt.Errorf("user not authenticated")
-	}
-
-	return userID, nil
-} 
\ No newline at end of file
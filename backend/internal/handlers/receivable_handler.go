@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
+	"maydiv-crm/internal/validation"
+
+	"github.com/gorilla/sessions"
+)
+
+// ReceivableHandler serves payments against bills, customer advances and
+// the receivables reports
+type ReceivableHandler struct {
+	accessControl
+	receivableService *services.ReceivableService
+}
+
+// NewReceivableHandler creates a new receivable handler
+func NewReceivableHandler(receivableService *services.ReceivableService, pipelineRepo *repository.PipelineRepository, userRepo *repository.UserRepository, sessionStore *sessions.CookieStore) *ReceivableHandler {
+	return &ReceivableHandler{
+		accessControl:     newAccessControl(pipelineRepo, userRepo, sessionStore),
+		receivableService: receivableService,
+	}
+}
+
+// HandleJobPayments handles the payment routes of a job:
+//
+//	GET    /api/pipeline/jobs/{id}/payments              - the bill, its payments and balance
+//	POST   /api/pipeline/jobs/{id}/payments              - record a payment (admin or subadmin)
+//	DELETE /api/pipeline/jobs/{id}/payments/{payment_id} - remove a payment recorded in error (admin)
+func (h *ReceivableHandler) HandleJobPayments(w http.ResponseWriter, r *http.Request) {
+	userID := h.getUserID(r)
+	if userID == 0 {
+		WriteError(w, r, services.ErrUnauthorized)
+		return
+	}
+	jobID, err := h.extractJobID(r)
+	if err != nil {
+		WriteError(w, r, BadRequest("Invalid job ID"))
+		return
+	}
+
+	// /api/pipeline/jobs/{id}/payments[/{payment_id}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 5 {
+		switch r.Method {
+		case http.MethodGet:
+			if !h.hasJobAccess(r, jobID) {
+				WriteError(w, r, services.ErrForbidden)
+				return
+			}
+			bill, err := h.receivableService.Bill(r.Context(), jobID, time.Now())
+			if err != nil {
+				WriteError(w, r, orNotFound(err, "No bill has been issued for this job"))
+				return
+			}
+			writeJSON(w, bill)
+		case http.MethodPost:
+			if !h.isAdminOrSubadmin(r) {
+				WriteError(w, r, services.ErrForbidden)
+				return
+			}
+			var req models.PaymentRequest
+			if err := decodeRequest(r, &req); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			bill, err := h.receivableService.AddPayment(r.Context(), jobID, &req, userID)
+			if err != nil {
+				WriteError(w, r, orNotFound(err, "No bill has been issued for this job"))
+				return
+			}
+			writeJSON(w, bill)
+		default:
+			WriteError(w, r, ErrMethodNotAllowed)
+		}
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	paymentID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil || len(parts) != 6 {
+		WriteError(w, r, BadRequest("Invalid payment ID"))
+		return
+	}
+	if !h.isAdmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+	if err := h.receivableService.DeletePayment(r.Context(), jobID, paymentID, userID); err != nil {
+		WriteError(w, r, orNotFound(err, "Payment not found"))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"message": "Payment deleted"})
+}
+
+// HandleReceivables handles GET /api/receivables?customer=&as_of= (admin
+// or subadmin): the open bills and what each customer owes
+func (h *ReceivableHandler) HandleReceivables(w http.ResponseWriter, r *http.Request) {
+	asOf, ok := h.report(w, r)
+	if !ok {
+		return
+	}
+	bills, customers, err := h.receivableService.Outstanding(r.Context(), r.URL.Query().Get("customer"), asOf)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"as_of":     asOf.Format(validation.DateLayout),
+		"bills":     bills,
+		"customers": customers,
+	})
+}
+
+// HandleAging handles GET /api/receivables/aging?as_of= (admin or subadmin)
+func (h *ReceivableHandler) HandleAging(w http.ResponseWriter, r *http.Request) {
+	asOf, ok := h.report(w, r)
+	if !ok {
+		return
+	}
+	report, err := h.receivableService.Aging(r.Context(), asOf)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// HandleReminders handles GET /api/receivables/reminders?as_of= (admin or
+// subadmin): overdue bills to remind customers of
+func (h *ReceivableHandler) HandleReminders(w http.ResponseWriter, r *http.Request) {
+	asOf, ok := h.report(w, r)
+	if !ok {
+		return
+	}
+	bills, err := h.receivableService.Reminders(r.Context(), asOf)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"as_of": asOf.Format(validation.DateLayout),
+		"bills": bills,
+	})
+}
+
+// HandleAdvances handles GET /api/advances?customer= and POST /api/advances
+// (admin or subadmin)
+func (h *ReceivableHandler) HandleAdvances(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		advances, err := h.receivableService.Advances(r.Context(), r.URL.Query().Get("customer"))
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, advances)
+	case http.MethodPost:
+		var req models.AdvanceRequest
+		if err := decodeRequest(r, &req); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		advance, err := h.receivableService.AddAdvance(r.Context(), &req, h.getUserID(r))
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		writeJSON(w, advance)
+	default:
+		WriteError(w, r, ErrMethodNotAllowed)
+	}
+}
+
+// report checks access to a receivables report and reads its as_of date,
+// today by default
+func (h *ReceivableHandler) report(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return time.Time{}, false
+	}
+	if !h.isAdminOrSubadmin(r) {
+		WriteError(w, r, services.ErrForbidden)
+		return time.Time{}, false
+	}
+	asOf := time.Now()
+	if s := r.URL.Query().Get("as_of"); s != "" {
+		d, err := time.Parse(validation.DateLayout, s)
+		if err != nil {
+			WriteError(w, r, ValidationFailed(FieldError{Field: "as_of", Message: "must be a date in YYYY-MM-DD format"}))
+			return time.Time{}, false
+		}
+		asOf = d
+	}
+	return asOf, true
+}
@@ -1,14 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
 	"maydiv-crm/internal/models"
 	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
 	"github.com/gorilla/sessions"
-	"log"
 )
 
 // TaskHandler handles task-related requests
@@ -29,7 +28,7 @@ func NewTaskHandler(taskRepo *repository.TaskRepository, sessionStore *sessions.
 func (h *TaskHandler) HandleTasks(w http.ResponseWriter, r *http.Request) {
 	// Check if user is admin or subadmin for task management
 	if !h.isAdminOrSubadmin(r) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 	
@@ -39,26 +38,26 @@ func (h *TaskHandler) HandleTasks(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		h.createTask(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 	}
 }
 
 // HandleMyTasks handles getting tasks assigned to the current user
 func (h *TaskHandler) HandleMyTasks(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 	
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 	
 	tasks, err := h.taskRepo.GetByUserID(userID)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -68,7 +67,7 @@ func (h *TaskHandler) HandleMyTasks(w http.ResponseWriter, r *http.Request) {
 // HandleTaskStatus handles task status updates
 func (h *TaskHandler) HandleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 		return
 	}
 	
@@ -76,30 +75,30 @@ func (h *TaskHandler) HandleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
 	parts := strings.Split(path, "/")
 	if len(parts) < 2 || parts[1] != "status" {
-		http.Error(w, "Not found", http.StatusNotFound)
+		WriteError(w, r, NotFound("Not found"))
 		return
 	}
 	
 	taskID, err := strconv.Atoi(parts[0])
 	if err != nil {
-		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		WriteError(w, r, BadRequest("Invalid task ID"))
 		return
 	}
 	
 	userID := h.getUserID(r)
 	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, r, services.ErrUnauthorized)
 		return
 	}
 	
 	var update models.TaskUpdateCreate
-	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeRequest(r, &update); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 	
 	if err := h.taskRepo.UpdateStatus(taskID, userID, &update); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -110,8 +109,7 @@ func (h *TaskHandler) HandleTaskStatus(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) getTasks(w http.ResponseWriter, r *http.Request) {
 	tasks, err := h.taskRepo.GetAll()
 	if err != nil {
-		log.Printf("Error getting tasks: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -121,14 +119,14 @@ func (h *TaskHandler) getTasks(w http.ResponseWriter, r *http.Request) {
 // createTask creates a new task
 func (h *TaskHandler) createTask(w http.ResponseWriter, r *http.Request) {
 	var task models.TaskCreate
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeRequest(r, &task); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 	
 	taskID, err := h.taskRepo.Create(&task)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 	"maydiv-crm/internal/models"
 	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/services"
 	"github.com/gorilla/sessions"
 )
 
@@ -26,7 +26,7 @@ func NewUserHandler(userRepo *repository.UserRepository, sessionStore *sessions.
 func (h *UserHandler) HandleUsers(w http.ResponseWriter, r *http.Request) {
 	// Check if user is admin or subadmin
 	if !h.isAdminOrSubadmin(r) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+		WriteError(w, r, services.ErrForbidden)
 		return
 	}
 	
@@ -36,7 +36,7 @@ func (h *UserHandler) HandleUsers(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		h.createUser(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		WriteError(w, r, ErrMethodNotAllowed)
 	}
 }
 
@@ -44,7 +44,7 @@ func (h *UserHandler) HandleUsers(w http.ResponseWriter, r *http.Request) {
 func (h *UserHandler) getUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := h.userRepo.GetAll()
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -54,15 +54,15 @@ func (h *UserHandler) getUsers(w http.ResponseWriter, r *http.Request) {
 // createUser creates a new user
 func (h *UserHandler) createUser(w http.ResponseWriter, r *http.Request) {
 	var userCreate struct {
-		Username    string `json:"username"`
-		Password    string `json:"password"`
-		Designation string `json:"designation"`
+		Username    string `json:"username" validate:"required,max=50"`
+		Password    string `json:"password" validate:"required"`
+		Designation string `json:"designation" validate:"required"`
 		IsAdmin     bool   `json:"is_admin"`
-		Role        string `json:"role"`
+		Role        string `json:"role" validate:"omitempty,oneof=admin subadmin stage1_employee stage2_employee stage3_employee customer"`
 	}
 	
-	if err := json.NewDecoder(r.Body).Decode(&userCreate); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeRequest(r, &userCreate); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -81,7 +81,7 @@ func (h *UserHandler) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	if err := h.userRepo.Create(&user); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 	
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"maydiv-crm/internal/iso6346"
+	"maydiv-crm/internal/models"
+)
+
+// ValidateHandler serves standalone checks the frontend runs while a form
+// is being filled in
+type ValidateHandler struct{}
+
+// NewValidateHandler creates a new validate handler
+func NewValidateHandler() *ValidateHandler {
+	return &ValidateHandler{}
+}
+
+// containerCheck is the response of GET /api/validate/container
+type containerCheck struct {
+	iso6346.Result
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// HandleContainer handles GET /api/validate/container?no=&size=&type_code=
+// size and type_code are optional; when both are given they are compared.
+func (h *ValidateHandler) HandleContainer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, r, ErrMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	no := query.Get("no")
+	if no == "" {
+		WriteError(w, r, ValidationFailed(FieldError{Field: "no", Message: "is required"}))
+		return
+	}
+
+	resp := containerCheck{Result: iso6346.Check(no)}
+	if msg := iso6346.SizeMismatch(query.Get("size"), query.Get("type_code")); msg != "" {
+		resp.Warnings = append(resp.Warnings, msg)
+	}
+
+	writeJSON(w, resp)
+}
+
+// containerWarnings flags containers whose size/type code contradicts the
+// declared size. They are saved anyway since either field may be the typo.
+func containerWarnings(containers []models.Stage3ContainerRequest) []FieldError {
+	var warnings []FieldError
+	for i, c := range containers {
+		if msg := iso6346.SizeMismatch(c.Size, c.TypeCode); msg != "" {
+			warnings = append(warnings, FieldError{
+				Field:   fmt.Sprintf("containers[%d].type_code", i),
+				Message: msg,
+			})
+		}
+	}
+	return warnings
+}
+
+// unidentifiedContainers flags stage 3 containers with neither an ID nor a
+// number, since there is no telling which of the job's containers they fill
+// in
+func unidentifiedContainers(containers []models.Stage3ContainerRequest) []FieldError {
+	var details []FieldError
+	for i, c := range containers {
+		if c.ID == 0 && c.ContainerNo == "" {
+			details = append(details, FieldError{
+				Field:   fmt.Sprintf("containers[%d].container_no", i),
+				Message: "is required for a container without an id",
+			})
+		}
+	}
+	return details
+}
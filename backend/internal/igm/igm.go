@@ -0,0 +1,357 @@
+// Package igm reads the Import General Manifest flat files that shipping
+// lines and consol agents file with ICEGATE (the SAM/SACHI01 layout).
+//
+// A manifest is a header record, a <manifest> block holding <vesinfo>,
+// <cargo> and <contain> tables, and a trailer:
+//
+//	HREC␝ZZ␝<sender>␝ZZ␝<custom house>␝ICES1_5␝P␝␝SACHI01␝<msg id>␝<date>␝<time>
+//	<manifest>
+//	<vesinfo> ... <END-vesinfo>
+//	<cargo> ... <END-cargo>
+//	<contain> ... <END-contain>
+//	<END-manifest>
+//	TREC␝<msg id>
+//
+// Fields are separated by the ASCII group separator (shown as ␝). The
+// fields read from a cargo record are, counting from zero:
+//
+//	1 custom house    2 IGM no        3 IGM date      7 line no
+//	8 sub line no     9 MBL no       10 MBL date     11 port of loading
+//	12 destination   13 HBL no       14 HBL date     15 importer
+//	22 cargo movement 23 packages    24 package type 25 gross weight
+//	26 weight unit   27 goods description
+//
+// and from a container record:
+//
+//	7 line no   8 sub line no   9 container no   10 seal no
+//	12 status  13 packages     14 weight         15 ISO type code
+//
+// Dates may be DDMMYYYY, as ICEGATE writes them, or YYYYMMDD.
+package igm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/iso6346"
+	"maydiv-crm/internal/tradeid"
+)
+
+const separator = "\x1d"
+
+// Cargo movement codes. Local cargo is cleared at the port the manifest
+// was filed at; the others move on under a second, local IGM.
+const (
+	MovementLocal            = "LC"
+	MovementTransshipICD     = "TI"
+	MovementTransshipCoastal = "TC"
+)
+
+// Manifest is the cargo declared in one IGM file
+type Manifest struct {
+	CustomHouse string
+	Lines       []Line
+	// Errors are records that could not be read; they are left out of Lines
+	Errors []RecordError
+}
+
+// Line is one bill of lading declared in the manifest
+type Line struct {
+	FileLine          int
+	CustomHouse       string
+	IGMNo             string
+	IGMDate           time.Time
+	LineNo            int
+	SubLineNo         int
+	MBLNo             string
+	MBLDate           *time.Time
+	HBLNo             string
+	HBLDate           *time.Time
+	PortOfLoading     string
+	PortOfDestination string
+	Importer          string
+	CargoMovement     string
+	Packages          int
+	PackageType       string
+	// Weight is the gross weight in kilograms
+	Weight      float64
+	Description string
+	Containers  []Container
+}
+
+// Container is one container a manifest line was stuffed in
+type Container struct {
+	Number   string
+	SealNo   string
+	Status   string
+	Packages int
+	Weight   float64
+	ISOCode  string
+}
+
+// RecordError describes a record that could not be read
+type RecordError struct {
+	Line    int
+	Message string
+}
+
+// Ref is the line and sub line number, e.g. "12/1"
+func (l *Line) Ref() string {
+	return fmt.Sprintf("%d/%d", l.LineNo, l.SubLineNo)
+}
+
+// Local reports whether the manifest is the local IGM for this cargo, i.e.
+// the cargo is cleared where the manifest was filed. Otherwise it is the
+// gateway IGM of cargo moving on to an inland depot.
+func (l *Line) Local() bool {
+	return l.CargoMovement == "" || l.CargoMovement == MovementLocal
+}
+
+// ContainerNumbers lists the line's container numbers
+func (l *Line) ContainerNumbers() []string {
+	numbers := make([]string, len(l.Containers))
+	for i, c := range l.Containers {
+		numbers[i] = c.Number
+	}
+	return numbers
+}
+
+// Parse reads a manifest. It fails only when the file is not a manifest
+// at all; records that cannot be read are reported in Manifest.Errors.
+func Parse(r io.Reader) (*Manifest, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	m := &Manifest{}
+	index := make(map[string]int)
+	var table string
+	lineNo := 0
+	sawCargo := false
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		if tag, ok := tableTag(text); ok {
+			switch {
+			case strings.HasPrefix(tag, "END-"):
+				table = ""
+			default:
+				table = tag
+				if tag == "CARGO" {
+					sawCargo = true
+				}
+			}
+			continue
+		}
+
+		fields := strings.Split(text, separator)
+		switch {
+		case fields[0] == "HREC":
+			if len(fields) > 4 {
+				m.CustomHouse = tradeid.Normalize(fields[4])
+			}
+		case table == "CARGO":
+			line, err := parseCargo(fields)
+			if err != nil {
+				m.Errors = append(m.Errors, RecordError{Line: lineNo, Message: err.Error()})
+				continue
+			}
+			line.FileLine = lineNo
+			key := line.Ref()
+			if _, dup := index[key]; dup {
+				m.Errors = append(m.Errors, RecordError{Line: lineNo, Message: "line " + key + " is declared twice"})
+				continue
+			}
+			index[key] = len(m.Lines)
+			m.Lines = append(m.Lines, line)
+		case table == "CONTAIN":
+			key, c, err := parseContainer(fields)
+			if err != nil {
+				m.Errors = append(m.Errors, RecordError{Line: lineNo, Message: err.Error()})
+				continue
+			}
+			i, ok := index[key]
+			if !ok {
+				m.Errors = append(m.Errors, RecordError{Line: lineNo, Message: "container " + c.Number + " belongs to undeclared line " + key})
+				continue
+			}
+			m.Lines[i].Containers = append(m.Lines[i].Containers, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if !sawCargo {
+		return nil, errors.New("no <cargo> table found; is this an IGM flat file?")
+	}
+	if m.CustomHouse == "" && len(m.Lines) > 0 {
+		m.CustomHouse = m.Lines[0].CustomHouse
+	}
+	return m, nil
+}
+
+// tableTag returns the upper-cased name of a <table> or <END-table> line
+func tableTag(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "<") || !strings.HasSuffix(text, ">") {
+		return "", false
+	}
+	return strings.ToUpper(text[1 : len(text)-1]), true
+}
+
+func parseCargo(fields []string) (Line, error) {
+	if len(fields) < 26 {
+		return Line{}, fmt.Errorf("cargo record has %d fields, want at least 26", len(fields))
+	}
+	f := func(i int) string {
+		if i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	var line Line
+	var err error
+	line.CustomHouse = tradeid.Normalize(f(1))
+	line.IGMNo = tradeid.Normalize(f(2))
+	if line.IGMNo == "" {
+		return line, errors.New("IGM number is empty")
+	}
+	if line.IGMDate, err = parseDate(f(3)); err != nil {
+		return line, fmt.Errorf("IGM date: %w", err)
+	}
+	if line.LineNo, line.SubLineNo, err = lineNumbers(f(7), f(8)); err != nil {
+		return line, err
+	}
+	line.MBLNo = tradeid.Normalize(f(9))
+	if line.MBLDate, err = optionalDate(f(10)); err != nil {
+		return line, fmt.Errorf("MBL date: %w", err)
+	}
+	line.PortOfLoading = tradeid.Normalize(f(11))
+	line.PortOfDestination = tradeid.Normalize(f(12))
+	line.HBLNo = tradeid.Normalize(f(13))
+	if line.HBLDate, err = optionalDate(f(14)); err != nil {
+		return line, fmt.Errorf("HBL date: %w", err)
+	}
+	if line.MBLNo == "" && line.HBLNo == "" {
+		return line, errors.New("neither an MBL nor an HBL number is given")
+	}
+	line.Importer = strings.Join(strings.Fields(f(15)), " ")
+	line.CargoMovement = strings.ToUpper(f(22))
+	if line.Packages, err = number(f(23)); err != nil {
+		return line, fmt.Errorf("packages: %w", err)
+	}
+	line.PackageType = strings.ToUpper(f(24))
+	if line.Weight, err = weight(f(25), f(26)); err != nil {
+		return line, fmt.Errorf("gross weight: %w", err)
+	}
+	line.Description = strings.Join(strings.Fields(f(27)), " ")
+	return line, nil
+}
+
+func parseContainer(fields []string) (string, Container, error) {
+	if len(fields) < 10 {
+		return "", Container{}, fmt.Errorf("container record has %d fields, want at least 10", len(fields))
+	}
+	f := func(i int) string {
+		if i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	lineNo, subLineNo, err := lineNumbers(f(7), f(8))
+	if err != nil {
+		return "", Container{}, err
+	}
+	c := Container{
+		Number:  iso6346.Normalize(f(9)),
+		SealNo:  f(10),
+		Status:  strings.ToUpper(f(12)),
+		ISOCode: strings.ToUpper(f(15)),
+	}
+	if c.Number == "" {
+		return "", c, errors.New("container number is empty")
+	}
+	if c.Packages, err = number(f(13)); err != nil {
+		return "", c, fmt.Errorf("container packages: %w", err)
+	}
+	if c.Weight, err = weight(f(14), "KGS"); err != nil {
+		return "", c, fmt.Errorf("container weight: %w", err)
+	}
+	return fmt.Sprintf("%d/%d", lineNo, subLineNo), c, nil
+}
+
+func lineNumbers(line, subLine string) (int, int, error) {
+	n, err := strconv.Atoi(line)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("line number %q is not a number", line)
+	}
+	sub := 0
+	if subLine != "" {
+		if sub, err = strconv.Atoi(subLine); err != nil || sub < 0 {
+			return 0, 0, fmt.Errorf("sub line number %q is not a number", subLine)
+		}
+	}
+	return n, sub, nil
+}
+
+func number(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a count", s)
+	}
+	return n, nil
+}
+
+// weight converts a gross weight to kilograms
+func weight(s, unit string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%q is not a weight", s)
+	}
+	switch strings.ToUpper(unit) {
+	case "", "KGS", "KG", "KGM":
+		return v, nil
+	case "MTS", "TON", "TNE", "MT":
+		return v * 1000, nil
+	default:
+		return 0, fmt.Errorf("unknown weight unit %q", unit)
+	}
+}
+
+// parseDate reads DDMMYYYY or YYYYMMDD. The year check tells them apart:
+// 20260118 read as DDMMYYYY has month 26, 18012026 as YYYYMMDD year 1801.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"02012006", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil && t.Year() >= 1900 {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date", s)
+}
+
+func optionalDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := parseDate(s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,123 @@
+package igm
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestParse(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "manifest.igm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := Parse(f)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", "manifest.golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("parsed manifest differs from %s:\n%s", path, got)
+	}
+}
+
+func TestParseNotAManifest(t *testing.T) {
+	for name, text := range map[string]string{
+		"empty":       "",
+		"no cargo":    "HREC\x1dZZ\r\n<manifest>\r\n<END-manifest>\r\n",
+		"a bill file": "BE\x1dINNSA1\x1d12345\n",
+	} {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("%s: Parse succeeded", name)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	tests := []struct {
+		movement string
+		local    bool
+	}{
+		{"", true},
+		{MovementLocal, true},
+		{MovementTransshipICD, false},
+		{MovementTransshipCoastal, false},
+	}
+	for _, tt := range tests {
+		l := Line{LineNo: 12, SubLineNo: 1, CargoMovement: tt.movement}
+		if l.Local() != tt.local {
+			t.Errorf("movement %q: Local = %v, want %v", tt.movement, l.Local(), tt.local)
+		}
+		if l.Ref() != "12/1" {
+			t.Errorf("Ref = %q, want 12/1", l.Ref())
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+		bad  bool
+	}{
+		{text: "18012026", want: time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{text: "20260118", want: time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{text: "01022026", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{text: "31022026", bad: true},
+		{text: "18-01-2026", bad: true},
+		{text: "", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.text)
+		if (err != nil) != tt.bad || !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q) = %v, %v, want %v, failing %v", tt.text, got, err, tt.want, tt.bad)
+		}
+	}
+}
+
+func TestWeight(t *testing.T) {
+	tests := []struct {
+		value, unit string
+		want        float64
+		bad         bool
+	}{
+		{value: "", unit: "KGS"},
+		{value: "950", unit: "", want: 950},
+		{value: "950.5", unit: "kgm", want: 950.5},
+		{value: "18.5", unit: "MTS", want: 18500},
+		{value: "2", unit: "TNE", want: 2000},
+		{value: "10", unit: "LBS", bad: true},
+		{value: "-1", unit: "KGS", bad: true},
+		{value: "ten", unit: "KGS", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := weight(tt.value, tt.unit)
+		if (err != nil) != tt.bad || got != tt.want {
+			t.Errorf("weight(%q, %q) = %v, %v, want %v, failing %v", tt.value, tt.unit, got, err, tt.want, tt.bad)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+// Package invoice renders GST tax invoices as PDF: the supplier and
+// recipient, the job's shipment references, the billed services with their
+// tax, the amount in words and the bank details, laid out with the admin's
+// invoice template.
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/pdf"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// DateLayout is how dates are printed on invoices
+const DateLayout = "02-Jan-2006"
+
+// Data is everything printed on an invoice. Header, terms and footer
+// templates are executed with it.
+type Data struct {
+	Seller        models.BillingParty
+	Buyer         models.BillingParty
+	InvoiceNo     string
+	InvoiceDate   time.Time
+	PlaceOfSupply string
+	JobNo         string
+	BENo          string
+	BEDate        *time.Time
+	HBLNo         string
+	MBLNo         string
+	Containers    []string
+	Items         []models.BillItem
+	Taxable       float64
+	CGST          float64
+	SGST          float64
+	IGST          float64
+	RoundOff      float64
+	Total         float64
+	// IRN, AckNo and AckDate are set once the e-invoice is registered
+	IRN     string
+	AckNo   string
+	AckDate *time.Time
+	// Title, when set, replaces the template's title, and NumberLabel
+	// replaces "Invoice" in the number and date labels. Credit and debit
+	// notes set both, with the invoice they correct and why.
+	Title        string
+	NumberLabel  string
+	OriginalNo   string
+	OriginalDate *time.Time
+	Reason       string
+}
+
+// title is the document's heading
+func (d *Data) title(tmpl *models.InvoiceTemplate) string {
+	if d.Title != "" {
+		return d.Title
+	}
+	return tmpl.Title
+}
+
+// numberLabel names the document in its number and date labels
+func (d *Data) numberLabel() string {
+	if d.NumberLabel != "" {
+		return d.NumberLabel
+	}
+	return "Invoice"
+}
+
+// Intrastate reports whether the invoice charges CGST and SGST: the
+// place of supply is in the seller's state
+func (d *Data) Intrastate() bool {
+	return d.PlaceOfSupply != "" && d.PlaceOfSupply == stateCode(d.Seller.GSTIN)
+}
+
+// AmountInWords is the invoice total in words
+func (d *Data) AmountInWords() string {
+	return AmountInWords(d.Total)
+}
+
+// CheckTemplate reports the template fields that cannot be used, such as
+// a malformed colour or a Go template that does not parse or execute
+func CheckTemplate(t *models.InvoiceTemplate) validation.Errors {
+	var errs validation.Errors
+	if _, err := pdf.ParseColor(t.AccentColor); err != nil {
+		errs.Add("accent_color", "must be a colour such as #1F4E79")
+	}
+	sample := Sample()
+	for field, text := range map[string]string{"header": t.Header, "terms": t.Terms, "footer": t.Footer} {
+		if _, err := expand(field, text, sample); err != nil {
+			errs.Add(field, err.Error())
+		}
+	}
+	return errs
+}
+
+// Sample is an invoice with made-up data, for previewing templates
+func Sample() *Data {
+	date := time.Now()
+	return &Data{
+		Seller: models.BillingParty{
+			GSTIN: "27AAPFU0939F1ZV", LegalName: "Your Company", Address1: "Office address",
+			Location: "Mumbai", Pincode: "400001",
+		},
+		Buyer: models.BillingParty{
+			GSTIN: "29AAACR5055K1Z3", LegalName: "Sample Importer Pvt Ltd", Address1: "12 MG Road",
+			Location: "Bengaluru", Pincode: "560001",
+		},
+		InvoiceNo:     "INV/0001",
+		InvoiceDate:   date,
+		PlaceOfSupply: "29",
+		JobNo:         "JOB-0001",
+		BENo:          "1234567",
+		BEDate:        &date,
+		HBLNo:         "HBL0001",
+		MBLNo:         "MBL0001",
+		Containers:    []string{"MSCU1234566"},
+		Items: []models.BillItem{
+			{LineNo: 1, Description: "Customs clearance and agency charges", SAC: "996712", GSTRate: 18,
+				TaxableAmount: 10000, IGSTAmount: 1800, TotalAmount: 11800},
+			{LineNo: 2, Description: "Transportation charges", SAC: "996791", GSTRate: 5,
+				TaxableAmount: 2000, IGSTAmount: 100, TotalAmount: 2100},
+		},
+		Taxable: 12000,
+		IGST:    1900,
+		Total:   13900,
+	}
+}
+
+func expand(name, text string, data *Data) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Page layout, in points
+const (
+	margin     = 40.0
+	right      = pdf.PageWidth - margin
+	contentW   = right - margin
+	bottom     = pdf.PageHeight - margin
+	lineHeight = 12.0
+)
+
+// renderer lays out an invoice, starting new pages as needed
+type renderer struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	y      float64
+	accent pdf.Color
+	data   *Data
+	tmpl   *models.InvoiceTemplate
+}
+
+// Render lays out the invoice with the template
+func Render(data *Data, tmpl *models.InvoiceTemplate) ([]byte, error) {
+	if errs := CheckTemplate(tmpl); len(errs) > 0 {
+		return nil, errs
+	}
+	accent, _ := pdf.ParseColor(tmpl.AccentColor)
+	header, err := expand("header", tmpl.Header, data)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := expand("terms", tmpl.Terms, data)
+	if err != nil {
+		return nil, err
+	}
+	footer, err := expand("footer", tmpl.Footer, data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &renderer{
+		doc:    pdf.New(fmt.Sprintf("%s %s", data.title(tmpl), data.InvoiceNo)),
+		accent: accent,
+		data:   data,
+		tmpl:   tmpl,
+	}
+	r.newPage()
+	r.heading(header)
+	r.parties()
+	r.items()
+	r.totals()
+	r.payment(terms)
+	if footer != "" {
+		lines := pdf.Wrap(pdf.Regular, 7.5, contentW, footer)
+		y := bottom - float64(len(lines)-1)*9
+		for _, line := range lines {
+			r.page.TextCenter(pdf.PageWidth/2, y, pdf.Regular, 7.5, pdf.Gray, line)
+			y += 9
+		}
+	}
+	return r.doc.Bytes()
+}
+
+func (r *renderer) newPage() {
+	r.page = r.doc.AddPage()
+	r.page.Rect(0, 0, pdf.PageWidth, 8, r.accent)
+	r.y = margin + 10
+}
+
+// ensure starts a new page unless h points are left above the footer
+func (r *renderer) ensure(h float64) bool {
+	if r.y+h <= bottom-30 {
+		return false
+	}
+	r.newPage()
+	return true
+}
+
+// heading prints the seller on the left and the invoice details on the right
+func (r *renderer) heading(header string) {
+	s := r.data.Seller
+	top := r.y
+	r.page.Text(margin, r.y+8, pdf.Bold, 15, r.accent, s.LegalName)
+	r.y += 24
+	var lines []string
+	lines = append(lines, joinNonEmpty(", ", s.Address1, s.Address2))
+	lines = append(lines, joinNonEmpty(" - ", s.Location, s.Pincode))
+	lines = append(lines, "GSTIN: "+s.GSTIN+"   State: "+stateName(s.GSTIN))
+	lines = append(lines, joinNonEmpty("   ", prefixed("Phone: ", s.Phone), prefixed("Email: ", s.Email)))
+	if header != "" {
+		lines = append(lines, pdf.Wrap(pdf.Regular, 8.5, contentW*0.55, header)...)
+	}
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		r.page.Text(margin, r.y, pdf.Regular, 8.5, pdf.Black, line)
+		r.y += 11
+	}
+
+	x := right
+	ry := top + 8
+	r.page.TextRight(x, ry, pdf.Bold, 15, r.accent, r.data.title(r.tmpl))
+	ry += 18
+	label := r.data.numberLabel()
+	for _, kv := range [][2]string{
+		{label + " No", r.data.InvoiceNo},
+		{label + " Date", r.data.InvoiceDate.Format(DateLayout)},
+		{"Place of Supply", r.data.PlaceOfSupply + " - " + tradeid.States[r.data.PlaceOfSupply]},
+		{"Reverse Charge", "No"},
+	} {
+		r.page.TextRight(x, ry, pdf.Regular, 8.5, pdf.Black, kv[0]+": "+kv[1])
+		ry += 11
+	}
+	if ry > r.y {
+		r.y = ry
+	}
+	r.y += 6
+	r.page.Line(margin, r.y, right, r.y, 0.8, r.accent)
+	r.y += 16
+}
+
+// parties prints the recipient and the shipment references side by side
+func (r *renderer) parties() {
+	b := r.data.Buyer
+	colW := contentW/2 - 10
+	left := []string{
+		joinNonEmpty(", ", b.Address1, b.Address2),
+		joinNonEmpty(" - ", b.Location, b.Pincode),
+		"GSTIN: " + b.GSTIN,
+		"State: " + stateName(b.GSTIN) + " (" + stateCode(b.GSTIN) + ")",
+	}
+	var refs []string
+	for _, kv := range [][2]string{
+		{"Job No", r.data.JobNo},
+		{"B/E No", joinNonEmpty(" dt ", r.data.BENo, formatDate(r.data.BEDate))},
+		{"HBL No", r.data.HBLNo},
+		{"MBL No", r.data.MBLNo},
+		{"Containers", strings.Join(r.data.Containers, ", ")},
+	} {
+		if kv[1] != "" {
+			refs = append(refs, pdf.Wrap(pdf.Regular, 8.5, colW, kv[0]+": "+kv[1])...)
+		}
+	}
+
+	r.page.Text(margin, r.y, pdf.Bold, 8, pdf.Gray, "BILL TO")
+	r.page.Text(margin+contentW/2+10, r.y, pdf.Bold, 8, pdf.Gray, "SHIPMENT")
+	r.y += 13
+	top := r.y
+	r.page.Text(margin, r.y, pdf.Bold, 9.5, pdf.Black, b.LegalName)
+	ly := r.y + 12
+	for _, line := range left {
+		for _, l := range pdf.Wrap(pdf.Regular, 8.5, colW, line) {
+			if l != "" {
+				r.page.Text(margin, ly, pdf.Regular, 8.5, pdf.Black, l)
+				ly += 11
+			}
+		}
+	}
+	ry := top
+	for _, line := range refs {
+		r.page.Text(margin+contentW/2+10, ry, pdf.Regular, 8.5, pdf.Black, line)
+		ry += 11
+	}
+	r.y = math.Max(ly, ry) + 10
+
+	if r.data.OriginalNo != "" {
+		against := "Against Invoice: " + joinNonEmpty(" dated ", r.data.OriginalNo, formatDate(r.data.OriginalDate))
+		r.page.Text(margin, r.y, pdf.Bold, 8.5, pdf.Black, against)
+		r.y += 11
+	}
+	if r.data.Reason != "" {
+		for _, l := range pdf.Wrap(pdf.Regular, 8.5, contentW, "Reason: "+r.data.Reason) {
+			r.page.Text(margin, r.y, pdf.Regular, 8.5, pdf.Black, l)
+			r.y += 11
+		}
+	}
+	if r.data.OriginalNo != "" || r.data.Reason != "" {
+		r.y += 6
+	}
+}
+
+// column is one column of the items table
+type column struct {
+	title string
+	width float64
+	right bool
+}
+
+func (r *renderer) columns() []column {
+	cols := []column{
+		{"#", 20, false},
+		{"Description", 0, false},
+		{"SAC", 45, false},
+		{"Taxable", 70, true},
+		{"Rate", 35, true},
+	}
+	if r.data.Intrastate() {
+		cols = append(cols, column{"CGST", 60, true}, column{"SGST", 60, true})
+	} else {
+		cols = append(cols, column{"IGST", 70, true})
+	}
+	cols = append(cols, column{"Amount", 75, true})
+	fixed := 0.0
+	for _, c := range cols {
+		fixed += c.width
+	}
+	cols[1].width = contentW - fixed
+	return cols
+}
+
+func (r *renderer) tableHeader(cols []column) {
+	r.page.Rect(margin, r.y, contentW, 16, r.accent)
+	x := margin
+	for _, c := range cols {
+		if c.right {
+			r.page.TextRight(x+c.width-4, r.y+11, pdf.Bold, 8, pdf.White, c.title)
+		} else {
+			r.page.Text(x+4, r.y+11, pdf.Bold, 8, pdf.White, c.title)
+		}
+		x += c.width
+	}
+	r.y += 16
+}
+
+func (r *renderer) items() {
+	cols := r.columns()
+	r.tableHeader(cols)
+	for _, item := range r.data.Items {
+		desc := pdf.Wrap(pdf.Regular, 8.5, cols[1].width-8, item.Description)
+		h := float64(len(desc))*lineHeight + 6
+		if r.ensure(h) {
+			r.tableHeader(cols)
+		}
+		cells := []string{
+			fmt.Sprint(item.LineNo), "", item.SAC, Money(item.TaxableAmount),
+			fmt.Sprintf("%g%%", item.GSTRate),
+		}
+		if r.data.Intrastate() {
+			cells = append(cells, Money(item.CGSTAmount), Money(item.SGSTAmount))
+		} else {
+			cells = append(cells, Money(item.IGSTAmount))
+		}
+		cells = append(cells, Money(item.TotalAmount))
+
+		x := margin
+		base := r.y + 12
+		for i, c := range cols {
+			switch {
+			case i == 1:
+				for j, line := range desc {
+					r.page.Text(x+4, base+float64(j)*lineHeight, pdf.Regular, 8.5, pdf.Black, line)
+				}
+			case c.right:
+				r.page.TextRight(x+c.width-4, base, pdf.Regular, 8.5, pdf.Black, cells[i])
+			default:
+				r.page.Text(x+4, base, pdf.Regular, 8.5, pdf.Black, cells[i])
+			}
+			x += c.width
+		}
+		r.y += h
+		r.page.Line(margin, r.y, right, r.y, 0.3, pdf.Gray)
+	}
+	r.y += 10
+}
+
+// totals prints the tax split, the invoice total and the amount in words
+func (r *renderer) totals() {
+	rows := [][2]string{{"Taxable Value", Money(r.data.Taxable)}}
+	if r.data.Intrastate() {
+		rows = append(rows, [2]string{"CGST", Money(r.data.CGST)}, [2]string{"SGST", Money(r.data.SGST)})
+	} else {
+		rows = append(rows, [2]string{"IGST", Money(r.data.IGST)})
+	}
+	if r.data.RoundOff != 0 {
+		rows = append(rows, [2]string{"Round Off", Money(r.data.RoundOff)})
+	}
+	r.ensure(float64(len(rows)+3) * 14)
+
+	labelX := right - 190
+	for _, row := range rows {
+		r.page.Text(labelX, r.y, pdf.Regular, 9, pdf.Black, row[0])
+		r.page.TextRight(right-4, r.y, pdf.Regular, 9, pdf.Black, row[1])
+		r.y += 13
+	}
+	r.page.Rect(labelX-6, r.y-9, right-labelX+6, 18, r.accent)
+	r.page.Text(labelX, r.y+4, pdf.Bold, 10, pdf.White, "Total (INR)")
+	r.page.TextRight(right-4, r.y+4, pdf.Bold, 10, pdf.White, Money(r.data.Total))
+	r.y += 26
+
+	for _, line := range pdf.Wrap(pdf.Bold, 9, contentW, "Amount in words: "+r.data.AmountInWords()) {
+		r.page.Text(margin, r.y, pdf.Bold, 9, pdf.Black, line)
+		r.y += lineHeight
+	}
+	if r.data.IRN != "" {
+		r.y += 4
+		r.page.Text(margin, r.y, pdf.Regular, 7.5, pdf.Gray, "IRN: "+r.data.IRN)
+		r.y += 10
+		r.page.Text(margin, r.y, pdf.Regular, 7.5, pdf.Gray,
+			joinNonEmpty("   ", prefixed("Ack No: ", r.data.AckNo), prefixed("Ack Date: ", formatDate(r.data.AckDate))))
+		r.y += 10
+	}
+	r.y += 12
+}
+
+// payment prints the bank details and terms on the left and the signature
+// block on the right
+func (r *renderer) payment(terms string) {
+	var bank []string
+	t := r.tmpl
+	for _, kv := range [][2]string{
+		{"Account Name", t.BankAccountName},
+		{"Account No", t.BankAccountNo},
+		{"Bank", joinNonEmpty(", ", t.BankName, t.BankBranch)},
+		{"IFSC", t.BankIFSC},
+	} {
+		if kv[1] != "" {
+			bank = append(bank, kv[0]+": "+kv[1])
+		}
+	}
+	termLines := pdf.Wrap(pdf.Regular, 8, contentW*0.6, terms)
+	if terms == "" {
+		termLines = nil
+	}
+	r.ensure(float64(len(bank)+len(termLines))*11 + 80)
+
+	top := r.y
+	if len(bank) > 0 {
+		r.page.Text(margin, r.y, pdf.Bold, 8, pdf.Gray, "BANK DETAILS")
+		r.y += 12
+		for _, line := range bank {
+			r.page.Text(margin, r.y, pdf.Regular, 8.5, pdf.Black, line)
+			r.y += 11
+		}
+		r.y += 8
+	}
+	if len(termLines) > 0 {
+		r.page.Text(margin, r.y, pdf.Bold, 8, pdf.Gray, "TERMS")
+		r.y += 12
+		for _, line := range termLines {
+			r.page.Text(margin, r.y, pdf.Regular, 8, pdf.Black, line)
+			r.y += 10
+		}
+	}
+
+	r.page.TextRight(right, top, pdf.Bold, 9, pdf.Black, "For "+r.data.Seller.LegalName)
+	signatory := t.Signatory
+	if signatory == "" {
+		signatory = "Authorised Signatory"
+	}
+	r.page.Line(right-150, top+50, right, top+50, 0.5, pdf.Gray)
+	r.page.TextRight(right, top+62, pdf.Regular, 8.5, pdf.Black, signatory)
+	r.y = math.Max(r.y, top+70)
+}
+
+// Money formats an amount with Indian digit grouping, e.g. 12,34,567.80
+func Money(v float64) string {
+	paise := int64(math.Round(math.Abs(v) * 100))
+	neg := v < 0 && paise > 0
+	rupees := fmt.Sprint(paise / 100)
+	if len(rupees) > 3 {
+		head, tail := rupees[:len(rupees)-3], rupees[len(rupees)-3:]
+		var groups []string
+		for len(head) > 2 {
+			groups = append([]string{head[len(head)-2:]}, groups...)
+			head = head[:len(head)-2]
+		}
+		groups = append([]string{head}, groups...)
+		rupees = strings.Join(groups, ",") + "," + tail
+	}
+	s := fmt.Sprintf("%s.%02d", rupees, paise%100)
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+var (
+	ones = []string{"", "One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine",
+		"Ten", "Eleven", "Twelve", "Thirteen", "Fourteen", "Fifteen", "Sixteen", "Seventeen",
+		"Eighteen", "Nineteen"}
+	tens = []string{"", "", "Twenty", "Thirty", "Forty", "Fifty", "Sixty", "Seventy", "Eighty", "Ninety"}
+)
+
+// AmountInWords spells out a rupee amount in the Indian system, e.g.
+// "Rupees One Lakh Twenty Thousand and Fifty Paise Only"
+func AmountInWords(v float64) string {
+	paise := int64(math.Round(math.Abs(v) * 100))
+	rupees, p := paise/100, paise%100
+	words := "Rupees " + indianWords(rupees)
+	if rupees == 0 {
+		words = "Rupees Zero"
+	}
+	if p > 0 {
+		words += " and " + indianWords(p) + " Paise"
+	}
+	if v < 0 && paise > 0 {
+		words = "Minus " + words
+	}
+	return words + " Only"
+}
+
+func indianWords(n int64) string {
+	var parts []string
+	for _, unit := range []struct {
+		size int64
+		name string
+	}{{10000000, "Crore"}, {100000, "Lakh"}, {1000, "Thousand"}, {100, "Hundred"}} {
+		if n >= unit.size {
+			count := n / unit.size
+			if unit.size == 10000000 {
+				// Crores can run past 99, e.g. "One Hundred Twenty Crore"
+				parts = append(parts, indianWords(count), unit.name)
+			} else {
+				parts = append(parts, belowHundred(count), unit.name)
+			}
+			n %= unit.size
+		}
+	}
+	if n > 0 {
+		parts = append(parts, belowHundred(n))
+	}
+	return strings.Join(parts, " ")
+}
+
+func belowHundred(n int64) string {
+	if n < 20 {
+		return ones[n]
+	}
+	if n%10 == 0 {
+		return tens[n/10]
+	}
+	return tens[n/10] + " " + ones[n%10]
+}
+
+func stateCode(gstin string) string {
+	if len(gstin) < 2 {
+		return ""
+	}
+	return gstin[:2]
+}
+
+func stateName(gstin string) string {
+	return tradeid.States[stateCode(gstin)]
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(DateLayout)
+}
+
+func prefixed(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + s
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if strings.TrimSpace(p) != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, sep)
+}
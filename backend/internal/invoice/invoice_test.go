@@ -0,0 +1,55 @@
+package invoice
+
+import "testing"
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0.00"},
+		{-0.001, "0.00"},
+		{7.5, "7.50"},
+		{999, "999.00"},
+		{1000, "1,000.00"},
+		{99999, "99,999.00"},
+		{100000, "1,00,000.00"},
+		{1234567.8, "12,34,567.80"},
+		{10000000, "1,00,00,000.00"},
+		{1200000000.05, "1,20,00,00,000.05"},
+		{0.005, "0.01"},
+		{-2500.5, "-2,500.50"},
+	}
+	for _, tt := range tests {
+		if got := Money(tt.v); got != tt.want {
+			t.Errorf("Money(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestAmountInWords(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "Rupees Zero Only"},
+		{-0.001, "Rupees Zero Only"},
+		{0.5, "Rupees Zero and Fifty Paise Only"},
+		{1, "Rupees One Only"},
+		{19.07, "Rupees Nineteen and Seven Paise Only"},
+		{110, "Rupees One Hundred Ten Only"},
+		{99999, "Rupees Ninety Nine Thousand Nine Hundred Ninety Nine Only"},
+		{100000, "Rupees One Lakh Only"},
+		{120000.5, "Rupees One Lakh Twenty Thousand and Fifty Paise Only"},
+		{9999999, "Rupees Ninety Nine Lakh Ninety Nine Thousand Nine Hundred Ninety Nine Only"},
+		{10000000, "Rupees One Crore Only"},
+		{10000001, "Rupees One Crore One Only"},
+		{1200000000, "Rupees One Hundred Twenty Crore Only"},
+		{-45.25, "Minus Rupees Forty Five and Twenty Five Paise Only"},
+	}
+	for _, tt := range tests {
+		if got := AmountInWords(tt.v); got != tt.want {
+			t.Errorf("AmountInWords(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package iso6346 validates freight container numbers (BIC codes) such as
+// MSCU1234566: a three letter owner code, a category identifier, a six digit
+// serial number and a check digit.
+package iso6346
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxSuggestions bounds how many corrections Check proposes
+const maxSuggestions = 5
+
+// categories maps the equipment category identifier to its meaning
+var categories = map[byte]string{
+	'U': "freight container",
+	'J': "detachable freight container equipment",
+	'Z': "trailer or chassis",
+}
+
+// confusions lists characters commonly typed or read in place of each other,
+// tried first when suggesting corrections
+var confusions = map[byte]string{
+	'0': "OQD", 'O': "0QD", 'Q': "O0", 'D': "0O",
+	'1': "IL7", 'I': "1L", 'L': "1I", '7': "1",
+	'2': "Z", 'Z': "2",
+	'5': "S", 'S': "5",
+	'6': "G", 'G': "6",
+	'8': "B3", 'B': "8", '3': "8",
+}
+
+// Result describes a container number and what is wrong with it
+type Result struct {
+	Number             string   `json:"container_no"`
+	Valid              bool     `json:"valid"`
+	OwnerCode          string   `json:"owner_code,omitempty"`
+	Category           string   `json:"category,omitempty"`
+	CategoryName       string   `json:"category_name,omitempty"`
+	Serial             string   `json:"serial,omitempty"`
+	CheckDigit         string   `json:"check_digit,omitempty"`
+	ExpectedCheckDigit string   `json:"expected_check_digit,omitempty"`
+	Error              string   `json:"error,omitempty"`
+	Suggestions        []string `json:"suggestions,omitempty"`
+}
+
+// Normalize uppercases s and drops the spaces, dashes and dots people type
+// between the parts of a container number
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		switch r {
+		case ' ', '-', '.', '/', '\t':
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Check validates s after normalizing it. When the number is invalid it
+// suggests the valid numbers that differ from it in a single character.
+func Check(s string) Result {
+	no := Normalize(s)
+	res := Result{Number: no}
+	if err := checkFormat(no); err != "" {
+		res.Error = err
+		res.Suggestions = suggest(no)
+		return res
+	}
+
+	res.OwnerCode = no[:3]
+	res.Category = no[3:4]
+	res.CategoryName = categories[no[3]]
+	res.Serial = no[4:10]
+
+	digit, _ := CheckDigit(no[:10])
+	res.ExpectedCheckDigit = fmt.Sprint(digit)
+	if len(no) == 10 {
+		res.Error = "check digit is missing"
+		res.Suggestions = []string{no + res.ExpectedCheckDigit}
+		return res
+	}
+
+	res.CheckDigit = no[10:]
+	if res.CheckDigit != res.ExpectedCheckDigit {
+		res.Error = fmt.Sprintf("check digit %s does not match, expected %s", res.CheckDigit, res.ExpectedCheckDigit)
+		res.Suggestions = suggest(no)
+		return res
+	}
+
+	res.Valid = true
+	return res
+}
+
+// Validate returns nil for a valid container number, or an error whose
+// message names the problem and any suggested corrections
+func Validate(s string) error {
+	res := Check(s)
+	if res.Valid {
+		return nil
+	}
+	msg := res.Error
+	if len(res.Suggestions) > 0 {
+		msg += "; did you mean " + strings.Join(res.Suggestions, " or ") + "?"
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// CheckDigit computes the ISO 6346 check digit of the first ten characters
+// of a container number
+func CheckDigit(prefix string) (int, error) {
+	if len(prefix) != 10 {
+		return 0, fmt.Errorf("need 10 characters, got %d", len(prefix))
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		v, ok := charValue(prefix[i])
+		if !ok {
+			return 0, fmt.Errorf("invalid character %q", prefix[i])
+		}
+		sum += v << i
+	}
+	// A remainder of 10 is written as 0
+	return sum % 11 % 10, nil
+}
+
+// SizeMismatch reports whether an ISO 6346 size/type code such as 22G1 or
+// 45R1 contradicts the declared container size (20, 40 or LCL). It returns
+// a description of the mismatch, or "" when they agree or cannot be compared.
+func SizeMismatch(size, typeCode string) string {
+	typeCode = Normalize(typeCode)
+	if typeCode == "" {
+		return ""
+	}
+	var want byte
+	switch size {
+	case "20":
+		want = '2'
+	case "40":
+		want = '4'
+	default:
+		return ""
+	}
+	if typeCode[0] != want {
+		return fmt.Sprintf("type code %s is not a %s ft container", typeCode, size)
+	}
+	return ""
+}
+
+// checkFormat returns a description of the first structural problem in
+// no, or "" when it has the shape of a container number
+func checkFormat(no string) string {
+	if len(no) != 10 && len(no) != 11 {
+		return fmt.Sprintf("must be 11 characters, got %d", len(no))
+	}
+	for i := 0; i < 3; i++ {
+		if !isLetter(no[i]) {
+			return "owner code must be three letters"
+		}
+	}
+	if _, ok := categories[no[3]]; !ok {
+		return "category identifier must be U, J or Z"
+	}
+	for i := 4; i < len(no); i++ {
+		if !isDigit(no[i]) {
+			return "serial number and check digit must be digits"
+		}
+	}
+	return ""
+}
+
+// suggest lists valid numbers that differ from no in one character:
+// likely confusions first, then from the check digit backwards, since the
+// owner code is copied from a short list and mistyped least often
+func suggest(no string) []string {
+	if len(no) != 11 {
+		return nil
+	}
+
+	var likely, other []string
+	seen := map[string]bool{}
+	try := func(i int, c byte, into *[]string) {
+		if c == no[i] {
+			return
+		}
+		candidate := no[:i] + string(c) + no[i+1:]
+		if seen[candidate] || !valid(candidate) {
+			return
+		}
+		seen[candidate] = true
+		*into = append(*into, candidate)
+	}
+
+	for i := 0; i < len(no); i++ {
+		for _, c := range []byte(confusions[no[i]]) {
+			try(i, c, &likely)
+		}
+	}
+	for i := len(no) - 1; i >= 0; i-- {
+		for _, c := range []byte(alphabet(i)) {
+			try(i, c, &other)
+		}
+	}
+
+	out := append(likely, other...)
+	if len(out) > maxSuggestions {
+		out = out[:maxSuggestions]
+	}
+	return out
+}
+
+// alphabet is the set of characters allowed at position i
+func alphabet(i int) string {
+	switch {
+	case i < 3:
+		return "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	case i == 3:
+		return "UJZ"
+	default:
+		return "0123456789"
+	}
+}
+
+func valid(no string) bool {
+	if checkFormat(no) != "" || len(no) != 11 {
+		return false
+	}
+	digit, err := CheckDigit(no[:10])
+	return err == nil && int(no[10]-'0') == digit
+}
+
+// letterValues are the ISO 6346 values of A..Z: 10 upwards, skipping the
+// multiples of 11
+var letterValues = [26]int{
+	10, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 23, 24,
+	25, 26, 27, 28, 29, 30, 31, 32, 34, 35, 36, 37, 38,
+}
+
+func charValue(c byte) (int, bool) {
+	switch {
+	case isDigit(c):
+		return int(c - '0'), true
+	case isLetter(c):
+		return letterValues[c-'A'], true
+	}
+	return 0, false
+}
+
+func isLetter(c byte) bool { return c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
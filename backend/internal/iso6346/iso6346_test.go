@@ -0,0 +1,123 @@
+package iso6346
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{"CSQU305438", 3},
+		{"MSKU907032", 3},
+		{"TGHU997352", 1},
+		// The weighted sum leaves a remainder of 10, written as 0
+		{"CSQU000007", 0},
+	}
+	for _, tt := range tests {
+		got, err := CheckDigit(tt.prefix)
+		if err != nil || got != tt.want {
+			t.Errorf("CheckDigit(%q) = %d, %v, want %d", tt.prefix, got, err, tt.want)
+		}
+	}
+
+	for _, prefix := range []string{"CSQU30543", "CSQU30543!"} {
+		if _, err := CheckDigit(prefix); err == nil {
+			t.Errorf("CheckDigit(%q) succeeded, want an error", prefix)
+		}
+	}
+}
+
+func TestLetterValues(t *testing.T) {
+	for i, v := range letterValues {
+		if v%11 == 0 {
+			t.Errorf("letter %c has value %d, a multiple of 11", 'A'+i, v)
+		}
+		if i > 0 && v <= letterValues[i-1] {
+			t.Errorf("letter %c has value %d, not above %c's %d", 'A'+i, v, 'A'+i-1, letterValues[i-1])
+		}
+	}
+	if letterValues[0] != 10 || letterValues[25] != 38 {
+		t.Errorf("A..Z run from %d to %d, want 10 to 38", letterValues[0], letterValues[25])
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		valid       bool
+		err         string
+		suggestions []string
+	}{
+		{name: "valid", in: "CSQU3054383", valid: true},
+		{name: "check digit 10 written as 0", in: "CSQU0000070", valid: true},
+		{name: "separators and lower case", in: "msku 907032-3", valid: true},
+		{name: "trailer category", in: "CSQZ3054387", valid: true},
+		{
+			name: "wrong check digit", in: "CSQU3054384",
+			err:         "check digit 4 does not match, expected 3",
+			suggestions: []string{"CSQU3054383", "CSQU3054784", "CSQU3051384", "CSQU1054384", "CSJU3054384"},
+		},
+		{
+			name: "letter O for zero", in: "CSQU3O54383",
+			err:         "serial number and check digit must be digits",
+			suggestions: []string{"CSQU3054383"},
+		},
+		{
+			name: "unknown category", in: "CSQX3054383",
+			err:         "category identifier must be U, J or Z",
+			suggestions: []string{"CSQU3054383"},
+		},
+		{name: "digit in owner code", in: "C5QU3054383", err: "owner code must be three letters", suggestions: []string{"CSQU3054383", "CIQU3054383"}},
+		{name: "missing check digit", in: "CSQU305438", err: "check digit is missing", suggestions: []string{"CSQU3054383"}},
+		{name: "too short", in: "CSQU3054", err: "must be 11 characters, got 8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Check(tt.in)
+			if res.Valid != tt.valid || res.Error != tt.err {
+				t.Errorf("Check(%q) = valid %v, error %q, want valid %v, error %q", tt.in, res.Valid, res.Error, tt.valid, tt.err)
+			}
+			if !slices.Equal(res.Suggestions, tt.suggestions) {
+				t.Errorf("Check(%q) suggests %v, want %v", tt.in, res.Suggestions, tt.suggestions)
+			}
+		})
+	}
+}
+
+func TestSuggestFixesSingleTypos(t *testing.T) {
+	const no = "CSQU3054383"
+	for i := 0; i < len(no); i++ {
+		for _, c := range []byte(alphabet(i)) {
+			typo := no[:i] + string(c) + no[i+1:]
+			if c == no[i] || valid(typo) {
+				continue
+			}
+			if got := suggest(typo); !slices.Contains(got, no) && len(got) < maxSuggestions {
+				t.Errorf("suggest(%q) = %v, want it to include %s", typo, got, no)
+			}
+		}
+	}
+}
+
+func TestSizeMismatch(t *testing.T) {
+	tests := []struct {
+		size, typeCode string
+		mismatch       bool
+	}{
+		{"20", "22G1", false},
+		{"40", "45R1", false},
+		{"20", "45G1", true},
+		{"40", "22g1", true},
+		{"LCL", "22G1", false},
+		{"20", "", false},
+	}
+	for _, tt := range tests {
+		if got := SizeMismatch(tt.size, tt.typeCode); (got != "") != tt.mismatch {
+			t.Errorf("SizeMismatch(%q, %q) = %q, want mismatch %v", tt.size, tt.typeCode, got, tt.mismatch)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"maydiv-crm/internal/config"
+)
+
+type contextKey struct{}
+
+// financialKeys are attribute keys whose values are withheld from logs unless
+// log.include_financial is set. They mirror the money columns of the stage
+// tables so a stray attribute cannot leak a customer's figures.
+var financialKeys = map[string]bool{
+	"amount":              true,
+	"amount_taxable":      true,
+	"duty_amount":         true,
+	"ocean_freight":       true,
+	"destination_charges": true,
+	"clearance_exps":      true,
+	"stamp_duty":          true,
+	"offloading_charges":  true,
+	"transport_detention": true,
+	"gst_5_percent":       true,
+	"gst_18_percent":      true,
+}
+
+// New builds the application logger. Records logged with a context carry the
+// request ID stored in it by WithRequestID.
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if !cfg.IncludeFinancial {
+		opts.ReplaceAttr = redactFinancial
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q: want json or text", cfg.Format)
+	}
+
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+func redactFinancial(groups []string, a slog.Attr) slog.Attr {
+	if financialKeys[a.Key] {
+		return slog.String(a.Key, "[redacted]")
+	}
+	return a
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDHandler adds the request_id attribute from the record's context
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
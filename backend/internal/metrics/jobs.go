@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"maydiv-crm/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jobStatsTimeout bounds the query run on each scrape
+const jobStatsTimeout = 5 * time.Second
+
+// JobCounter reports how many jobs sit in each stage and status
+type JobCounter interface {
+	CountJobsByStageAndStatus(ctx context.Context) ([]models.JobStageCount, error)
+}
+
+// jobsCollector queries job counts at scrape time so the gauges are never stale
+type jobsCollector struct {
+	source JobCounter
+	jobs   *prometheus.Desc
+}
+
+// RegisterJobStats exports the number of jobs per current_stage and status
+func RegisterJobStats(source JobCounter) {
+	prometheus.MustRegister(&jobsCollector{
+		source: source,
+		jobs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "jobs"),
+			"Pipeline jobs by current stage and status.",
+			[]string{"current_stage", "status"}, nil,
+		),
+	})
+}
+
+func (c *jobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.jobs
+}
+
+func (c *jobsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobStatsTimeout)
+	defer cancel()
+
+	counts, err := c.source.CountJobsByStageAndStatus(ctx)
+	if err != nil {
+		slog.Error("Error collecting job metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.jobs, err)
+		return
+	}
+
+	for _, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.jobs, prometheus.GaugeValue,
+			float64(count.Count), count.CurrentStage, count.Status)
+	}
+}
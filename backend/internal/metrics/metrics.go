@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"maydiv-crm/internal/middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "maydiv"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	emailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_sent_total",
+		Help:      "Emails sent successfully by template.",
+	}, []string{"template"})
+
+	emailsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_failed_total",
+		Help:      "Emails that could not be sent by template.",
+	}, []string{"template"})
+
+	uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of uploaded job files by stage.",
+	}, []string{"stage"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request counts and latencies. The route label is the
+// ServeMux pattern that matched, or the one set with SetRoute, so IDs in
+// paths do not explode the series.
+// It must wrap the mux directly or through handlers that pass the same
+// *http.Request along, since the mux records the pattern on it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		rec := middleware.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(rec.Status())).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// SetRoute sets the route label of a request that a handler dispatches
+// further, since every route it serves shares the one mux pattern. route
+// must be drawn from a fixed set, never from the path itself.
+func SetRoute(r *http.Request, route string) {
+	r.Pattern = route
+}
+
+// RegisterDB exports connection pool statistics from sql.DB.Stats
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// EmailSent counts a successfully sent email
+func EmailSent(template string) {
+	emailsSent.WithLabelValues(template).Inc()
+}
+
+// EmailFailed counts an email that could not be sent
+func EmailFailed(template string) {
+	emailsFailed.WithLabelValues(template).Inc()
+}
+
+// UploadedBytes counts bytes stored for an uploaded job file
+func UploadedBytes(stage string, n int64) {
+	uploadBytes.WithLabelValues(stage).Add(float64(n))
+}
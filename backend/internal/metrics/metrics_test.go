@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareRouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/users", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, "/api/jobs/{id}/duty")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := Middleware(mux)
+
+	tests := []struct {
+		path, route, status string
+	}{
+		{"/api/users", "/api/users", "200"},
+		{"/api/jobs/7/duty", "/api/jobs/{id}/duty", "418"},
+		{"/nowhere", "unmatched", "404"},
+	}
+	for _, tt := range tests {
+		before := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, tt.route, tt.status))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+		after := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, tt.route, tt.status))
+		if after != before+1 {
+			t.Errorf("GET %s: requests{route=%q, status=%s} went from %v to %v, want one more", tt.path, tt.route, tt.status, before, after)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maydiv-crm/internal/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "well formed", incoming: "req-01.ABC_9", reused: true},
+		{name: "longest allowed", incoming: strings.Repeat("a", 64), reused: true},
+		{name: "none"},
+		{name: "too long", incoming: strings.Repeat("a", 65)},
+		{name: "newline", incoming: "abc\nmsg=forged"},
+		{name: "non-ASCII", incoming: "ïd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = logging.RequestID(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tt.reused && id != tt.incoming {
+				t.Errorf("ID = %q, want the incoming %q", id, tt.incoming)
+			}
+			if !tt.reused && (id == tt.incoming || !validRequestID.MatchString(id)) {
+				t.Errorf("ID = %q for incoming %q, want a new well formed ID", id, tt.incoming)
+			}
+			if seen != id {
+				t.Errorf("context carries %q, header %q", seen, id)
+			}
+		})
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(w http.ResponseWriter)
+		status int
+		bytes  int64
+	}{
+		{name: "nothing written", write: func(http.ResponseWriter) {}, status: http.StatusOK},
+		{name: "body only", write: func(w http.ResponseWriter) { w.Write([]byte("hello")) }, status: http.StatusOK, bytes: 5},
+		{name: "status then body", write: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{}}`))
+		}, status: http.StatusNotFound, bytes: 12},
+		{name: "first status wins", write: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusAccepted)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, status: http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		rec := NewResponseRecorder(httptest.NewRecorder())
+		tt.write(rec)
+		if rec.Status() != tt.status || rec.Bytes() != tt.bytes {
+			t.Errorf("%s: recorded %d and %d bytes, want %d and %d", tt.name, rec.Status(), rec.Bytes(), tt.status, tt.bytes)
+		}
+	}
+}
+
+func TestResponseRecorderUnwrap(t *testing.T) {
+	inner := httptest.NewRecorder()
+	rec := NewResponseRecorder(inner)
+	if rec.Unwrap() != inner {
+		t.Fatal("Unwrap does not return the wrapped writer")
+	}
+	// The recorder has no Flush of its own; the controller finds the
+	// wrapped writer's
+	if err := http.NewResponseController(rec).Flush(); err != nil {
+		t.Fatalf("Flush through the recorder failed: %v", err)
+	}
+	if !inner.Flushed {
+		t.Error("the wrapped writer was not flushed")
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+// BillItem is one service billed on a job, with the GST computed on it
+type BillItem struct {
+	ID            int     `json:"id" db:"id"`
+	JobID         int     `json:"job_id" db:"job_id"`
+	LineNo        int     `json:"line_no" db:"line_no"`
+	Description   string  `json:"description" db:"description"`
+	SAC           string  `json:"sac" db:"sac"`
+	GSTRate       float64 `json:"gst_rate" db:"gst_rate"`
+	TaxableAmount float64 `json:"taxable_amount" db:"taxable_amount"`
+	CGSTAmount    float64 `json:"cgst_amount" db:"cgst_amount"`
+	SGSTAmount    float64 `json:"sgst_amount" db:"sgst_amount"`
+	IGSTAmount    float64 `json:"igst_amount" db:"igst_amount"`
+	TotalAmount   float64 `json:"total_amount" db:"total_amount"`
+}
+
+// BillItemRequest is one service on a stage 4 bill; the tax is computed
+type BillItemRequest struct {
+	Description   string  `json:"description" validate:"required,max=300"`
+	SAC           string  `json:"sac" validate:"required,sac"`
+	GSTRate       float64 `json:"gst_rate" validate:"oneof=0 5 12 18 28"`
+	TaxableAmount float64 `json:"taxable_amount" validate:"min=0.01"`
+}
+
+// BillTotals is a stage 4 bill as computed for saving: its items and the
+// totals stored with stage 4. GST5Percent and GST18Percent are the tax
+// charged at those rates.
+type BillTotals struct {
+	PlaceOfSupply    string
+	Items            []BillItem
+	AmountTaxable    float64
+	GST5Percent      float64
+	GST18Percent     float64
+	CGSTAmount       float64
+	SGSTAmount       float64
+	IGSTAmount       float64
+	RoundOff         float64
+	TotalAmount      float64
+	TotalsOverridden bool
+}
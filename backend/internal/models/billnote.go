@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// Bill note kinds. A credit note takes an amount off an issued bill and a
+// debit note adds to it.
+const (
+	CreditNote = "credit"
+	DebitNote  = "debit"
+)
+
+// Bill note statuses. A note is requested as pending and numbered only
+// once an admin approves it.
+const (
+	NotePending  = "pending"
+	NoteApproved = "approved"
+	NoteRejected = "rejected"
+)
+
+// BillNote is a credit or debit note correcting a job's issued bill. Its
+// GST is computed from its items at the place of supply of the bill.
+type BillNote struct {
+	ID              int        `json:"id" db:"id"`
+	JobID           int        `json:"job_id" db:"job_id"`
+	Kind            string     `json:"kind" db:"kind"`
+	Status          string     `json:"status" db:"status"`
+	NoteNo          *string    `json:"note_no" db:"note_no"`
+	NoteDate        *time.Time `json:"note_date" db:"note_date"`
+	Reason          string     `json:"reason" db:"reason"`
+	PlaceOfSupply   string     `json:"place_of_supply" db:"place_of_supply"`
+	AmountTaxable   float64    `json:"amount_taxable" db:"amount_taxable"`
+	CGSTAmount      float64    `json:"cgst_amount" db:"cgst_amount"`
+	SGSTAmount      float64    `json:"sgst_amount" db:"sgst_amount"`
+	IGSTAmount      float64    `json:"igst_amount" db:"igst_amount"`
+	RoundOff        float64    `json:"round_off" db:"round_off"`
+	TotalAmount     float64    `json:"total_amount" db:"total_amount"`
+	Items           []BillItem `json:"items" db:"-"`
+	RequestedBy     int        `json:"requested_by" db:"requested_by"`
+	ReviewedBy      *int       `json:"reviewed_by" db:"reviewed_by"`
+	ReviewedAt      *time.Time `json:"reviewed_at" db:"reviewed_at"`
+	ReviewRemarks   *string    `json:"review_remarks" db:"review_remarks"`
+	FileID          *int       `json:"file_id" db:"file_id"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	RequestedByUser string     `json:"requested_by_user,omitempty" db:"-"`
+}
+
+// BillNoteRequest asks for a credit or debit note against a job's issued
+// bill. The tax and totals are computed from the items.
+type BillNoteRequest struct {
+	Kind   string            `json:"kind" validate:"required,oneof=credit debit"`
+	Reason string            `json:"reason" validate:"required,max=500"`
+	Items  []BillItemRequest `json:"items" validate:"min=1,max=100"`
+}
+
+// BillNoteReview records an admin's approval or rejection of a note
+type BillNoteReview struct {
+	Remarks string `json:"remarks" validate:"max=500"`
+}
+
+// BillPosition is a job's bill net of its approved credit and debit notes,
+// and what is left to pay on it. A negative Balance is owed back to the
+// customer.
+type BillPosition struct {
+	JobID       int        `json:"job_id"`
+	BillNo      string     `json:"bill_no"`
+	BillDate    *time.Time `json:"bill_date"`
+	IssuedAt    *time.Time `json:"issued_at"`
+	Original    float64    `json:"original_amount"`
+	CreditNotes float64    `json:"credit_notes"`
+	DebitNotes  float64    `json:"debit_notes"`
+	Net         float64    `json:"net_amount"`
+	Settled     float64    `json:"settled"`
+	Balance     float64    `json:"balance"`
+	Pending     int        `json:"pending_notes"`
+	Notes       []BillNote `json:"notes"`
+}
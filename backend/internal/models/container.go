@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Container events, in the order a container usually goes through them.
+// The latest event is the container's status.
+const (
+	ContainerDischarged    = "discharged"
+	ContainerArrivedCFS    = "arrived_cfs"
+	ContainerExamined      = "examined"
+	ContainerOutOfCharge   = "out_of_charge"
+	ContainerLoadedOnTruck = "loaded_on_truck"
+	ContainerDelivered     = "delivered"
+	ContainerEmptyReturned = "empty_returned"
+)
+
+// Container is one container on a job. It is added at stage 1, or later,
+// and filled in as it moves. DateOfOffloading and EmptyReturnDate are the
+// dates of its latest delivered and empty_returned events.
+type Container struct {
+	ID               int              `json:"id" db:"id"`
+	JobID            int              `json:"job_id" db:"job_id"`
+	ContainerNo      *string          `json:"container_no" db:"container_no"`
+	Size             *string          `json:"size" db:"size"`
+	TypeCode         *string          `json:"type_code" db:"type_code"`
+	VehicleNo        *string          `json:"vehicle_no" db:"vehicle_no"`
+	DateOfOffloading *time.Time       `json:"date_of_offloading" db:"date_of_offloading"`
+	EmptyReturnDate  *time.Time       `json:"empty_return_date" db:"empty_return_date"`
+	Status           *string          `json:"status" db:"status"`
+	Events           []ContainerEvent `json:"events" db:"-"`
+	CreatedAt        time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at" db:"updated_at"`
+}
+
+// ContainerEvent is one step on a container's timeline
+type ContainerEvent struct {
+	ID          int       `json:"id" db:"id"`
+	ContainerID int       `json:"container_id" db:"container_id"`
+	Event       string    `json:"event" db:"event"`
+	EventAt     time.Time `json:"event_at" db:"event_at"`
+	Location    *string   `json:"location" db:"location"`
+	Remarks     *string   `json:"remarks" db:"remarks"`
+	CreatedBy   *int      `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ContainerRequest adds a container to a job or replaces its details
+type ContainerRequest struct {
+	ContainerNo string `json:"container_no" validate:"omitempty,container"`
+	Size        string `json:"size" validate:"omitempty,oneof=20 40 LCL"`
+	TypeCode    string `json:"type_code" validate:"omitempty,max=4"`
+	VehicleNo   string `json:"vehicle_no" validate:"max=50"`
+}
+
+// ContainerEventRequest records an event on a container's timeline
+type ContainerEventRequest struct {
+	Event    string `json:"event" validate:"required,oneof=discharged arrived_cfs examined out_of_charge loaded_on_truck delivered empty_returned"`
+	EventAt  string `json:"event_at" validate:"required,datetime"`
+	Location string `json:"location" validate:"max=100"`
+	Remarks  string `json:"remarks" validate:"max=500"`
+}
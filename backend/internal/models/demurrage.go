@@ -0,0 +1,105 @@
+package models
+
+import "time"
+
+// Container charge kinds. Demurrage runs from the date of arrival until the
+// container is offloaded from the port, and detention from then until the
+// empty container is returned to the shipping line.
+const (
+	Demurrage = "demurrage"
+	Detention = "detention"
+)
+
+// DemurrageTariff is what a shipping line charges for a container of one
+// size held past its free days. An empty ShippingLine applies to lines
+// without a tariff of their own.
+type DemurrageTariff struct {
+	ID            int             `json:"id" db:"id"`
+	ShippingLine  string          `json:"shipping_line" db:"shipping_line"`
+	ContainerSize string          `json:"container_size" db:"container_size"`
+	Kind          string          `json:"kind" db:"kind"`
+	FreeDays      int             `json:"free_days" db:"free_days"`
+	Currency      string          `json:"currency" db:"currency"`
+	Slabs         []DemurrageSlab `json:"slabs" db:"-"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// DemurrageSlab is the rate per day charged from FromDay to ToDay after
+// the free days, or from FromDay on when ToDay is nil
+type DemurrageSlab struct {
+	FromDay int     `json:"from_day" db:"from_day"`
+	ToDay   *int    `json:"to_day" db:"to_day"`
+	Rate    float64 `json:"rate" db:"rate"`
+}
+
+// DemurrageTariffRequest creates or replaces a tariff. The slabs must run
+// on from day 1 without gaps, and only the last leaves to_day out.
+type DemurrageTariffRequest struct {
+	ShippingLine  string                 `json:"shipping_line" validate:"max=100"`
+	ContainerSize string                 `json:"container_size" validate:"required,oneof=20 40 LCL"`
+	Kind          string                 `json:"kind" validate:"required,oneof=demurrage detention"`
+	FreeDays      int                    `json:"free_days" validate:"min=0,max=365"`
+	Currency      string                 `json:"currency" validate:"omitempty,min=3,max=3"`
+	Slabs         []DemurrageSlabRequest `json:"slabs" validate:"min=1,max=20"`
+}
+
+// DemurrageSlabRequest is one slab of a tariff request
+type DemurrageSlabRequest struct {
+	FromDay int     `json:"from_day" validate:"min=1"`
+	ToDay   *int    `json:"to_day"`
+	Rate    float64 `json:"rate" validate:"min=0"`
+}
+
+// DemurrageClock is one charge running on a container: when it started,
+// when its free time ends and what it has cost so far. Until is when the
+// clock stopped, or nil while it runs. Projected is what it will have cost
+// by the projection date if it is still running then.
+type DemurrageClock struct {
+	Kind           string     `json:"kind"`
+	TariffID       int        `json:"tariff_id"`
+	Currency       string     `json:"currency"`
+	FreeDays       int        `json:"free_days"`
+	Start          *time.Time `json:"start"`
+	FreeUntil      *time.Time `json:"free_until"`
+	Until          *time.Time `json:"until"`
+	Running        bool       `json:"running"`
+	DaysLeft       *int       `json:"days_left"`
+	ChargeableDays int        `json:"chargeable_days"`
+	Accrued        float64    `json:"accrued"`
+	Projected      float64    `json:"projected"`
+}
+
+// ContainerDemurrage is a job's container with its demurrage and detention.
+// A clock is nil when no tariff covers the container.
+type ContainerDemurrage struct {
+	JobID        int             `json:"job_id"`
+	JobNo        string          `json:"job_no"`
+	Customer     string          `json:"customer"`
+	ShippingLine string          `json:"shipping_line"`
+	ContainerNo  string          `json:"container_no"`
+	Size         string          `json:"size"`
+	ArrivalDate  *time.Time      `json:"date_of_arrival"`
+	OffloadDate  *time.Time      `json:"date_of_offloading"`
+	ReturnDate   *time.Time      `json:"empty_return_date"`
+	Demurrage    *DemurrageClock `json:"demurrage"`
+	Detention    *DemurrageClock `json:"detention"`
+	Email        string          `json:"-"`
+}
+
+// DemurrageTotal adds up the accrued and projected charges in one currency
+type DemurrageTotal struct {
+	Currency  string  `json:"currency"`
+	Accrued   float64 `json:"accrued"`
+	Projected float64 `json:"projected"`
+}
+
+// DemurrageDashboard lists the containers whose free time ends within a
+// number of days of AsOf, or has already ended, soonest first
+type DemurrageDashboard struct {
+	AsOf        string               `json:"as_of"`
+	ProjectedOn string               `json:"projected_on"`
+	Within      int                  `json:"within_days"`
+	Containers  []ContainerDemurrage `json:"containers"`
+	Totals      []DemurrageTotal     `json:"totals"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+// Deposit movement kinds. Receipts add to a deposit account; debits and
+// refunds draw on it.
+const (
+	DepositReceipt = "receipt"
+	DepositDebit   = "debit"
+	DepositRefund  = "refund"
+)
+
+// DepositCategories are the ledger categories drawn from a customer's
+// deposit when we pay them on the customer's behalf: the duty and the
+// shipping line's debit note
+var DepositCategories = []string{"customs_duty", "ocean_freight", "destination_charges"}
+
+// DepositAccount holds money a customer deposits for us to pay their duty
+// and debit notes from. The customer is the consignee on their jobs.
+type DepositAccount struct {
+	ID        int       `json:"id" db:"id"`
+	Customer  string    `json:"customer" db:"customer"`
+	Received  float64   `json:"received" db:"-"`
+	Debited   float64   `json:"debited" db:"-"`
+	Refunded  float64   `json:"refunded" db:"-"`
+	Balance   float64   `json:"balance" db:"-"`
+	CreatedBy *int      `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// DepositAccountRequest opens a deposit account for a customer
+type DepositAccountRequest struct {
+	Customer string `json:"customer" validate:"required,max=255"`
+}
+
+// DepositMovement is money into or out of a deposit account. Debits with a
+// LedgerEntryID follow that ledger entry of the job; receipts and refunds
+// are entered by hand.
+type DepositMovement struct {
+	ID            int       `json:"id" db:"id"`
+	AccountID     int       `json:"account_id" db:"account_id"`
+	Kind          string    `json:"kind" db:"kind"`
+	Amount        float64   `json:"amount" db:"amount"`
+	MovementDate  time.Time `json:"movement_date" db:"movement_date"`
+	Description   string    `json:"description" db:"description"`
+	JobID         *int      `json:"job_id" db:"job_id"`
+	JobNo         *string   `json:"job_no" db:"-"`
+	LedgerEntryID *int      `json:"ledger_entry_id" db:"ledger_entry_id"`
+	ReferenceNo   *string   `json:"reference_no" db:"reference_no"`
+	Remarks       *string   `json:"remarks" db:"remarks"`
+	CreatedBy     *int      `json:"created_by" db:"created_by"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	Balance       float64   `json:"balance" db:"-"`
+}
+
+// DepositMovementRequest records money received into a deposit account or
+// refunded from it
+type DepositMovementRequest struct {
+	Kind         string  `json:"kind" validate:"required,oneof=receipt refund"`
+	Amount       float64 `json:"amount" validate:"min=0.01"`
+	MovementDate string  `json:"movement_date" validate:"required,date"`
+	Description  string  `json:"description" validate:"max=255"`
+	ReferenceNo  string  `json:"reference_no" validate:"max=100"`
+	Remarks      string  `json:"remarks" validate:"max=500"`
+}
+
+// DepositStatement is a deposit account's movements between two dates with
+// the running balance after each
+type DepositStatement struct {
+	Account        DepositAccount    `json:"account"`
+	From           *string           `json:"from,omitempty"`
+	To             *string           `json:"to,omitempty"`
+	OpeningBalance float64           `json:"opening_balance"`
+	Movements      []DepositMovement `json:"movements"`
+	ClosingBalance float64           `json:"closing_balance"`
+}
+
+// DepositCheck compares the duty a job is expected to need with what its
+// customer's deposit has available for it: the balance plus whatever has
+// already been debited for the job's duty
+type DepositCheck struct {
+	JobID        int     `json:"job_id"`
+	Customer     string  `json:"customer"`
+	AccountID    int     `json:"account_id"`
+	ExpectedDuty float64 `json:"expected_duty"`
+	Available    float64 `json:"available"`
+	Shortfall    float64 `json:"shortfall"`
+}
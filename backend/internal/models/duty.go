@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// ExchangeRate is the customs rate of one foreign currency in rupees,
+// effective from a date until the next notified rate
+type ExchangeRate struct {
+	Currency      string    `json:"currency" db:"currency"`
+	Rate          float64   `json:"rate" db:"rate"`
+	EffectiveDate time.Time `json:"effective_date" db:"effective_date"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ExchangeRateRequest records a notified exchange rate
+type ExchangeRateRequest struct {
+	Currency      string  `json:"currency" validate:"required,min=3,max=3"`
+	Rate          float64 `json:"rate" validate:"required,min=0"`
+	EffectiveDate string  `json:"effective_date" validate:"required,date"`
+}
+
+// DutyCalculationRequest describes the goods of a Bill of Entry. Freight
+// and insurance left out are taken at the statutory 20% and 1.125% of the
+// invoice value; send 0 when the invoice already includes them.
+type DutyCalculationRequest struct {
+	Currency          string           `json:"currency" validate:"required,min=3,max=3"`
+	ExchangeRate      float64          `json:"exchange_rate" validate:"min=0"`
+	RateDate          string           `json:"rate_date" validate:"omitempty,date"`
+	Freight           *float64         `json:"freight" validate:"omitempty,min=0"`
+	FreightCurrency   string           `json:"freight_currency" validate:"omitempty,min=3,max=3"`
+	Insurance         *float64         `json:"insurance" validate:"omitempty,min=0"`
+	InsuranceCurrency string           `json:"insurance_currency" validate:"omitempty,min=3,max=3"`
+	Items             []DutyItemRequest `json:"items" validate:"required,min=1"`
+}
+
+// DutyItemRequest is one invoice line to assess
+type DutyItemRequest struct {
+	HSNCode      string  `json:"hsn_code" validate:"required,hsn"`
+	Description  string  `json:"description"`
+	InvoiceValue float64 `json:"invoice_value" validate:"required,min=0"`
+}
+
+// DutyBreakdown is an itemized customs duty calculation for a job. Values
+// are in rupees unless named as foreign currency.
+type DutyBreakdown struct {
+	ID               int                `json:"id,omitempty"`
+	JobID            int                `json:"job_id"`
+	Currency         string             `json:"currency"`
+	ExchangeRates    map[string]float64 `json:"exchange_rates"`
+	RateDate         string             `json:"rate_date"`
+	InvoiceValue     float64            `json:"invoice_value_foreign"`
+	Freight          float64            `json:"freight"`
+	Insurance        float64            `json:"insurance"`
+	AssessableValue  float64            `json:"assessable_value"`
+	BCD              float64            `json:"bcd"`
+	SWS              float64            `json:"sws"`
+	IGST             float64            `json:"igst"`
+	Cess             float64            `json:"cess"`
+	TotalDuty        float64            `json:"total_duty"`
+	Items            []DutyItem         `json:"items"`
+	Notes            []string           `json:"notes,omitempty"`
+	CalculatedBy     int                `json:"calculated_by,omitempty"`
+	CalculatedByUser string             `json:"calculated_by_user,omitempty"`
+	CalculatedAt     *time.Time         `json:"calculated_at,omitempty"`
+}
+
+// DutyItem is the duty on one invoice line. Freight and insurance are its
+// share of the shipment's, in proportion to invoice value.
+type DutyItem struct {
+	HSNCode         string  `json:"hsn_code"`
+	Description     string  `json:"description"`
+	InvoiceValue    float64 `json:"invoice_value_foreign"`
+	Freight         float64 `json:"freight"`
+	Insurance       float64 `json:"insurance"`
+	AssessableValue float64 `json:"assessable_value"`
+	BCDRate         float64 `json:"bcd_rate"`
+	SWSRate         float64 `json:"sws_rate"`
+	IGSTRate        float64 `json:"igst_rate"`
+	CessRate        float64 `json:"cess_rate"`
+	BCD             float64 `json:"bcd"`
+	SWS             float64 `json:"sws"`
+	IGST            float64 `json:"igst"`
+	Cess            float64 `json:"cess"`
+	TotalDuty       float64 `json:"total_duty"`
+}
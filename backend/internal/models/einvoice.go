@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// E-invoice statuses
+const (
+	EInvoiceGenerated  = "generated"
+	EInvoiceRegistered = "registered"
+)
+
+// BillingParty is a party as named on tax invoices
+type BillingParty struct {
+	GSTIN     string `json:"gstin"`
+	LegalName string `json:"legal_name"`
+	TradeName string `json:"trade_name,omitempty"`
+	Address1  string `json:"address1"`
+	Address2  string `json:"address2,omitempty"`
+	Location  string `json:"location"`
+	Pincode   string `json:"pincode"`
+	Phone     string `json:"phone,omitempty"`
+	Email     string `json:"email,omitempty" validate:"omitempty,email"`
+}
+
+// EInvoice is the GST e-invoice prepared for a job's bill and, once the
+// IRP has registered it, its IRN, acknowledgement and signed QR code
+type EInvoice struct {
+	ID            int             `json:"id" db:"id"`
+	JobID         int             `json:"job_id" db:"job_id"`
+	BillNo        string          `json:"bill_no" db:"bill_no"`
+	Payload       json.RawMessage `json:"payload" db:"payload"`
+	Status        string          `json:"status" db:"status"`
+	IRN           *string         `json:"irn" db:"irn"`
+	AckNo         *string         `json:"ack_no" db:"ack_no"`
+	AckDate       *time.Time      `json:"ack_date" db:"ack_date"`
+	SignedQRCode  *string         `json:"signed_qr_code" db:"signed_qr_code"`
+	SignedInvoice *string         `json:"-" db:"signed_invoice"`
+	GeneratedBy   int             `json:"generated_by" db:"generated_by"`
+	RegisteredBy  *int            `json:"registered_by" db:"registered_by"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// EInvoiceRequest generates a job's e-invoice. Buyer fields that are set
+// override the consignee details and the address last billed to them.
+type EInvoiceRequest struct {
+	Buyer *BillingParty `json:"buyer"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// Job filing statuses. Pending and submitted filings are open.
+const (
+	FilingPending     = "pending"
+	FilingSubmitted   = "submitted"
+	FilingApproved    = "approved"
+	FilingNotRequired = "not_required"
+)
+
+// FilingRule says which regulatory filing goods need, by HSN code prefix
+// and country of shipment. The country is a country master code. An empty
+// prefix or country matches any.
+type FilingRule struct {
+	ID          int       `json:"id" db:"id"`
+	HSNPrefix   string    `json:"hsn_prefix" db:"hsn_prefix"`
+	Country     string    `json:"country" db:"country"`
+	Filing      string    `json:"filing" db:"filing"`
+	Description string    `json:"description" db:"description"`
+	Documents   []string  `json:"documents" db:"documents"`
+	Active      bool      `json:"active" db:"active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// FilingRuleRequest creates or replaces a filing rule. Country may be the
+// code, name or an alias of a country master.
+type FilingRuleRequest struct {
+	HSNPrefix   string   `json:"hsn_prefix" validate:"omitempty,max=10"`
+	Country     string   `json:"country" validate:"omitempty,max=100"`
+	Filing      string   `json:"filing" validate:"required,max=50"`
+	Description string   `json:"description"`
+	Documents   []string `json:"documents"`
+	Active      *bool    `json:"active"`
+}
+
+// JobFiling is a filing a job needs and how far it has got
+type JobFiling struct {
+	ID          int       `json:"id" db:"id"`
+	JobID       int       `json:"job_id" db:"job_id"`
+	RuleID      *int      `json:"rule_id" db:"rule_id"`
+	Filing      string    `json:"filing" db:"filing"`
+	Description string    `json:"description" db:"description"`
+	Documents   []string  `json:"documents" db:"documents"`
+	Status      string    `json:"status" db:"status"`
+	ReferenceNo *string   `json:"reference_no" db:"reference_no"`
+	Remarks     *string   `json:"remarks" db:"remarks"`
+	UpdatedBy   *int      `json:"updated_by" db:"updated_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Open reports whether the filing still needs work
+func (f JobFiling) Open() bool {
+	return f.Status == FilingPending || f.Status == FilingSubmitted
+}
+
+// JobFilingUpdateRequest records progress on a job filing
+type JobFilingUpdateRequest struct {
+	Status      string `json:"status" validate:"required,oneof=pending submitted approved not_required"`
+	ReferenceNo string `json:"reference_no" validate:"omitempty,max=100"`
+	Remarks     string `json:"remarks"`
+}
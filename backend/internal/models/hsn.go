@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// HSNCode is an entry of the customs tariff master
+type HSNCode struct {
+	Code        string    `json:"code" db:"code"`
+	Description string    `json:"description" db:"description"`
+	Unit        string    `json:"unit" db:"unit"`
+	BCDRate     float64   `json:"bcd_rate" db:"bcd_rate"`
+	SWSRate     float64   `json:"sws_rate" db:"sws_rate"`
+	IGSTRate    float64   `json:"igst_rate" db:"igst_rate"`
+	CessRate    float64   `json:"cess_rate" db:"cess_rate"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HSNSuggestion is a ranked HSN code proposed for a commodity
+type HSNSuggestion struct {
+	HSNCode
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// CommodityHSN pairs a past job's commodity text with the HSN code it was filed under
+type CommodityHSN struct {
+	JobID     int
+	Commodity string
+	HSNCode   string
+}
+
+// HSNImportResult summarises a tariff CSV import
+type HSNImportResult struct {
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	Errors   []HSNImportError `json:"errors,omitempty"`
+}
+
+// HSNImportError describes a CSV row that could not be imported
+type HSNImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Outcomes of reconciling one IGM manifest line against the jobs
+const (
+	IGMLineUpdated   = "updated"
+	IGMLineUnchanged = "unchanged"
+	IGMLineAmbiguous = "ambiguous"
+	IGMLineUnmatched = "unmatched"
+)
+
+// ManifestJob is the stage 1 data of a job an IGM line may belong to
+type ManifestJob struct {
+	JobID          int
+	JobNo          string
+	HBLNo          string
+	MBLNo          string
+	Containers     []string
+	GatewayIGM     string
+	GatewayIGMDate *time.Time
+	LocalIGM       string
+	LocalIGMDate   *time.Time
+	Packages       int
+	Weight         float64
+}
+
+// IGMFieldChange is one stage 1 field an IGM line changes
+type IGMFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// IGMLineResult is how one manifest line was reconciled
+type IGMLineResult struct {
+	Line       string   `json:"line"`
+	IGMNo      string   `json:"igm_no"`
+	IGMDate    string   `json:"igm_date"`
+	IGMType    string   `json:"igm_type"`
+	MBLNo      string   `json:"mbl_no,omitempty"`
+	HBLNo      string   `json:"hbl_no,omitempty"`
+	Containers []string `json:"containers,omitempty"`
+	Importer   string   `json:"importer,omitempty"`
+	Status     string   `json:"status"`
+	MatchedBy  string   `json:"matched_by,omitempty"`
+	JobID      int      `json:"job_id,omitempty"`
+	JobNo      string   `json:"job_no,omitempty"`
+	// Candidates are the job numbers an ambiguous line could belong to
+	Candidates []string         `json:"candidates,omitempty"`
+	Message    string           `json:"message,omitempty"`
+	Changes    []IGMFieldChange `json:"changes,omitempty"`
+	// OurConsignee marks unmatched cargo for an importer we have cleared
+	// for before; Draft is the job proposed for it
+	OurConsignee bool                 `json:"our_consignee,omitempty"`
+	Draft        *Stage1CreateRequest `json:"draft,omitempty"`
+}
+
+// IGMImportResult is the reconciliation summary of a manifest import
+type IGMImportResult struct {
+	DryRun      bool             `json:"dry_run"`
+	CustomHouse string           `json:"custom_house"`
+	Lines       int              `json:"lines"`
+	Updated     int              `json:"updated"`
+	Unchanged   int              `json:"unchanged"`
+	Ambiguous   int              `json:"ambiguous"`
+	Unmatched   int              `json:"unmatched"`
+	Drafts      int              `json:"drafts"`
+	Results     []IGMLineResult  `json:"results"`
+	Errors      []IGMImportError `json:"errors,omitempty"`
+}
+
+// IGMImportError describes a manifest record that could not be read
+type IGMImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// IGMDraftsRequest creates draft jobs proposed by a manifest import
+type IGMDraftsRequest struct {
+	Jobs []Stage1CreateRequest `json:"jobs" validate:"required,min=1,max=500"`
+}
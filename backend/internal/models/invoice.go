@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// InvoiceTemplate is the admin's customisation of tax invoice PDFs. Header,
+// Terms and Footer are Go templates over the invoice, e.g. "{{.JobNo}}".
+type InvoiceTemplate struct {
+	Title           string     `json:"title" validate:"required,max=40"`
+	AccentColor     string     `json:"accent_color" validate:"required,max=7"`
+	Header          string     `json:"header" validate:"max=500"`
+	BankName        string     `json:"bank_name" validate:"max=100"`
+	BankBranch      string     `json:"bank_branch" validate:"max=100"`
+	BankAccountName string     `json:"bank_account_name" validate:"max=100"`
+	BankAccountNo   string     `json:"bank_account_no" validate:"max=34"`
+	BankIFSC        string     `json:"bank_ifsc" validate:"max=11"`
+	Terms           string     `json:"terms" validate:"max=2000"`
+	Footer          string     `json:"footer" validate:"max=300"`
+	Signatory       string     `json:"signatory" validate:"max=100"`
+	UpdatedBy       *int       `json:"updated_by,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultInvoiceTemplate is used until an admin saves a template
+func DefaultInvoiceTemplate() InvoiceTemplate {
+	return InvoiceTemplate{
+		Title:       "TAX INVOICE",
+		AccentColor: "#1F4E79",
+		Terms: "Payment is due within 30 days of the invoice date.\n" +
+			"Please quote invoice {{.InvoiceNo}} with your payment.",
+		Footer:    "This is a computer generated invoice.",
+		Signatory: "Authorised Signatory",
+	}
+}
@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// Ledger entry kinds
+const (
+	LedgerCost    = "cost"
+	LedgerRevenue = "revenue"
+)
+
+// Who paid a ledger entry: us, the customer, or the forwarder or shipping
+// line
+const (
+	PaidByUs        = "us"
+	PaidByCustomer  = "customer"
+	PaidByForwarder = "forwarder"
+)
+
+// LedgerCategories are the categories ledger entries are booked under
+var LedgerCategories = []string{
+	"customs_duty", "ocean_freight", "destination_charges", "clearance", "stamp_duty",
+	"offloading", "transport", "transport_detention", "agency_fees", "other",
+}
+
+// LedgerEntry is a cost or revenue booked against a job. Entries with a
+// Source are kept in step with the stage column they come from, such as
+// "stage2.duty_amount"; the others are entered by hand.
+type LedgerEntry struct {
+	ID           int        `json:"id" db:"id"`
+	JobID        int        `json:"job_id" db:"job_id"`
+	Kind         string     `json:"kind" db:"kind"`
+	Category     string     `json:"category" db:"category"`
+	Description  string     `json:"description" db:"description"`
+	Amount       float64    `json:"amount" db:"amount"`
+	PaidBy       string     `json:"paid_by" db:"paid_by"`
+	Reimbursable bool       `json:"reimbursable" db:"reimbursable"`
+	EntryDate    *time.Time `json:"entry_date" db:"entry_date"`
+	Source       *string    `json:"source" db:"source"`
+	CreatedBy    *int       `json:"created_by" db:"created_by"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// LedgerEntryRequest creates or replaces a ledger entry entered by hand.
+// Entries kept from stage columns only take PaidBy and Reimbursable.
+type LedgerEntryRequest struct {
+	Kind         string  `json:"kind" validate:"required,oneof=cost revenue"`
+	Category     string  `json:"category" validate:"required,oneof=customs_duty ocean_freight destination_charges clearance stamp_duty offloading transport transport_detention agency_fees other"`
+	Description  string  `json:"description" validate:"max=255"`
+	Amount       float64 `json:"amount" validate:"min=0.01"`
+	PaidBy       string  `json:"paid_by" validate:"required,oneof=us customer forwarder"`
+	Reimbursable bool    `json:"reimbursable"`
+	EntryDate    string  `json:"entry_date" validate:"omitempty,date"`
+}
+
+// Profitability is what a job, or a group of jobs, earned. Costs are those
+// we paid and cannot recover; Recoverable are those we paid on the
+// customer's behalf and bill back; PassThrough were paid by the customer or
+// forwarder directly. Profit is Revenue less Costs.
+type Profitability struct {
+	Revenue     float64  `json:"revenue"`
+	Costs       float64  `json:"costs"`
+	Recoverable float64  `json:"recoverable"`
+	PassThrough float64  `json:"pass_through"`
+	Profit      float64  `json:"profit"`
+	Margin      *float64 `json:"margin"`
+}
+
+// JobLedger is a job's ledger entries with their totals
+type JobLedger struct {
+	JobID   int           `json:"job_id"`
+	Entries []LedgerEntry `json:"entries"`
+	Profitability
+}
+
+// ProfitabilityRow is one job, customer or month in the profitability
+// report. Key is the job ID, the customer name or the month as YYYY-MM.
+type ProfitabilityRow struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Jobs  int    `json:"jobs"`
+	Profitability
+}
+
+// ProfitabilityReport is the profitability of jobs billed between From and
+// To, grouped By job, customer or month
+type ProfitabilityReport struct {
+	By    string             `json:"by"`
+	From  *string            `json:"from"`
+	To    *string            `json:"to"`
+	Rows  []ProfitabilityRow `json:"rows"`
+	Total ProfitabilityRow   `json:"total"`
+}
+
+// JobProfitability is one job's line in the profitability report, with the
+// customer and month it is grouped under
+type JobProfitability struct {
+	JobID    int
+	JobNo    string
+	Customer string
+	Month    string
+	Profitability
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Master data kinds
+const (
+	MasterPort         = "port"
+	MasterCountry      = "country"
+	MasterShippingLine = "shipping_line"
+	MasterForwarder    = "forwarder"
+	MasterCustodian    = "custodian"
+)
+
+// Master is one entry of the master data that free-text job fields are
+// matched to: a port by its UN/LOCODE, a country by its ISO code, or a
+// shipping line, forwarder or custodian. A value matches a master when,
+// ignoring case, spaces and punctuation, it is the master's code, name or
+// one of its aliases.
+type Master struct {
+	ID        int       `json:"id" db:"id"`
+	Kind      string    `json:"kind" db:"kind"`
+	Code      *string   `json:"code" db:"code"`
+	Name      string    `json:"name" db:"name"`
+	Country   *string   `json:"country" db:"country"`
+	Aliases   []string  `json:"aliases" db:"-"`
+	Active    bool      `json:"active" db:"active"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MasterRequest creates or replaces a master. Ports need a UN/LOCODE and
+// countries an ISO 3166 code; a port's country defaults to the first two
+// letters of its code. Inactive masters are kept for the jobs that use
+// them but no longer offered or matched; masters are active unless active
+// is false.
+type MasterRequest struct {
+	Code    string   `json:"code" validate:"max=20"`
+	Name    string   `json:"name" validate:"required,max=100"`
+	Country string   `json:"country" validate:"omitempty,min=2,max=2"`
+	Aliases []string `json:"aliases" validate:"max=50"`
+	Active  *bool    `json:"active"`
+}
+
+// MasterReviewRow is one free-text value of a job field that is not yet
+// linked to a master, the number of jobs carrying it, and the master it
+// was linked to or, when none matched, the closest one to review
+type MasterReviewRow struct {
+	Field      string `json:"field"`
+	Value      string `json:"value"`
+	Jobs       int    `json:"jobs"`
+	MasterID   *int   `json:"master_id"`
+	Master     string `json:"master"`
+	Suggestion string `json:"suggestion"`
+}
+
+// MasterNormalization reports a run linking free-text job fields to
+// master data
+type MasterNormalization struct {
+	LinkedValues  int               `json:"linked_values"`
+	LinkedJobs    int               `json:"linked_jobs"`
+	UnmatchedJobs int               `json:"unmatched_jobs"`
+	Rows          []MasterReviewRow `json:"rows"`
+}
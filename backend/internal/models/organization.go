@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// JobStages are the stages of a job a customer organization member may be
+// restricted to
+var JobStages = []string{"stage1", "stage2", "stage3", "stage4"}
+
+// Organization is a customer company whose users share access to the jobs
+// it is the consignee on. It is a party of the party directory.
+type Organization struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Members     []OrgMember     `json:"members"`
+	Invitations []OrgInvitation `json:"invitations"`
+}
+
+// OrgMember is a customer user of an organization. An org admin manages
+// the members. Stages lists the only stages of the organization's jobs the
+// member may see, every stage when empty.
+type OrgMember struct {
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Designation string    `json:"designation"`
+	OrgAdmin    bool      `json:"org_admin"`
+	Stages      []string  `json:"stages"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OrgMemberRequest sets a member's rights. A staff user may add an
+// existing customer user to an organization with it.
+type OrgMemberRequest struct {
+	OrgAdmin bool     `json:"org_admin"`
+	Stages   []string `json:"stages" validate:"max=4"`
+}
+
+// OrgInvitation invites a colleague to join an organization, with the
+// rights they will have as a member. AcceptURL is only known when the
+// invitation is made.
+type OrgInvitation struct {
+	ID        int       `json:"id"`
+	PartyID   int       `json:"party_id"`
+	Email     string    `json:"email"`
+	OrgAdmin  bool      `json:"org_admin"`
+	Stages    []string  `json:"stages"`
+	InvitedBy *string   `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	AcceptURL string    `json:"accept_url,omitempty"`
+}
+
+// OrgInvitationRequest invites a colleague by email
+type OrgInvitationRequest struct {
+	Email    string   `json:"email" validate:"required,email"`
+	OrgAdmin bool     `json:"org_admin"`
+	Stages   []string `json:"stages" validate:"max=4"`
+}
+
+// InvitationAcceptRequest accepts an invitation, creating the customer
+// user who joins the organization
+type InvitationAcceptRequest struct {
+	Token       string `json:"token" validate:"required"`
+	Username    string `json:"username" validate:"required,max=50"`
+	Password    string `json:"password" validate:"required,min=8"`
+	Designation string `json:"designation" validate:"required,max=50"`
+}
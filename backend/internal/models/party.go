@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// Party is a company we deal with, as the consignee or shipper on jobs. A
+// name on a job matches a party when, ignoring case, punctuation and legal
+// forms such as "Pvt Ltd", it is the party's name or one of its aliases.
+type Party struct {
+	ID             int            `json:"id" db:"id"`
+	Name           string         `json:"name" db:"name"`
+	Address        *string        `json:"address" db:"address"`
+	GSTIN          *string        `json:"gstin" db:"gstin"`
+	IEC            *string        `json:"iec" db:"iec"`
+	BillingEmail   *string        `json:"billing_email" db:"billing_email"`
+	BillingAddress *string        `json:"billing_address" db:"billing_address"`
+	CreditDays     *int           `json:"credit_days" db:"credit_days"`
+	Notes          *string        `json:"notes" db:"notes"`
+	Aliases        []string       `json:"aliases" db:"-"`
+	Contacts       []PartyContact `json:"contacts" db:"-"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// PartyContact is a person at a party
+type PartyContact struct {
+	ID    int     `json:"id" db:"id"`
+	Name  string  `json:"name" db:"name"`
+	Email *string `json:"email" db:"email"`
+	Phone *string `json:"phone" db:"phone"`
+	Role  *string `json:"role" db:"role"`
+}
+
+// PartyRequest creates or replaces a party with its aliases and contacts.
+// billing_email, billing_address and credit_days, when set, are used for
+// the party's bills instead of the job's notification email and the
+// standard credit period.
+type PartyRequest struct {
+	Name           string                `json:"name" validate:"required,max=255"`
+	Address        string                `json:"address" validate:"max=1000"`
+	GSTIN          string                `json:"gstin" validate:"omitempty,gstin"`
+	IEC            string                `json:"iec" validate:"omitempty,iec"`
+	BillingEmail   string                `json:"billing_email" validate:"omitempty,email"`
+	BillingAddress string                `json:"billing_address" validate:"max=1000"`
+	CreditDays     *int                  `json:"credit_days" validate:"min=0,max=365"`
+	Notes          string                `json:"notes" validate:"max=2000"`
+	Aliases        []string              `json:"aliases" validate:"max=50"`
+	Contacts       []PartyContactRequest `json:"contacts" validate:"max=50"`
+}
+
+// PartyContactRequest is a contact of a party request
+type PartyContactRequest struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Email string `json:"email" validate:"omitempty,email"`
+	Phone string `json:"phone" validate:"max=30"`
+	Role  string `json:"role" validate:"max=50"`
+}
+
+// PartyJob is a job a party is the consignee or shipper on
+type PartyJob struct {
+	ID           int        `json:"id"`
+	JobNo        string     `json:"job_no"`
+	JobDate      *time.Time `json:"job_date"`
+	CurrentStage string     `json:"current_stage"`
+	Status       string     `json:"status"`
+	Role         string     `json:"role"`
+}
+
+// PartyDocument is a file uploaded to one of a party's jobs
+type PartyDocument struct {
+	JobNo string `json:"job_no"`
+	JobFile
+}
+
+// PartyDetail is a party with its jobs, the bills it has yet to settle and
+// the documents on its jobs
+type PartyDetail struct {
+	Party
+	Jobs        []PartyJob      `json:"jobs"`
+	Bills       []Receivable    `json:"bills"`
+	Outstanding float64         `json:"outstanding"`
+	Documents   []PartyDocument `json:"documents"`
+}
+
+// Ways a job's party name was matched to a party when linking jobs
+const (
+	PartyMatchExact   = "exact"
+	PartyMatchGSTIN   = "gstin"
+	PartyMatchIEC     = "iec"
+	PartyMatchSimilar = "similar"
+	PartyMatchCreated = "created"
+)
+
+// PartyReviewRow is one consignee or shipper name found on jobs that were
+// not linked to a party, the number of jobs carrying it, and the party it
+// was linked to with how it matched
+type PartyReviewRow struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Jobs    int    `json:"jobs"`
+	GSTIN   string `json:"gstin"`
+	IEC     string `json:"iec"`
+	PartyID int    `json:"party_id"`
+	Party   string `json:"party"`
+	Match   string `json:"match"`
+}
+
+// PartyMigration reports a run linking the consignees and shippers of
+// jobs to parties
+type PartyMigration struct {
+	Created    int              `json:"created"`
+	LinkedJobs int              `json:"linked_jobs"`
+	Rows       []PartyReviewRow `json:"rows"`
+}
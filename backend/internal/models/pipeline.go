@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // PipelineJob represents the main job tracking
 type PipelineJob struct {
@@ -17,6 +20,13 @@ type PipelineJob struct {
 	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Job statuses set by the application. Draft jobs were proposed by an IGM
+// import and become active once stage 2 work starts on them.
+const (
+	JobStatusActive = "active"
+	JobStatusDraft  = "draft"
+)
+
 // Stage1Data represents initial job creation data (Admin)
 type Stage1Data struct {
 	ID                    int        `json:"id" db:"id"`
@@ -26,17 +36,29 @@ type Stage1Data struct {
 	EDIJobNo              *string    `json:"edi_job_no" db:"edi_job_no"`
 	EDIDate               *time.Time `json:"edi_date" db:"edi_date"`
 	Consignee             *string    `json:"consignee" db:"consignee"`
+	ConsigneeGSTIN        *string    `json:"consignee_gstin" db:"consignee_gstin"`
+	ConsigneeIEC          *string    `json:"consignee_iec" db:"consignee_iec"`
+	ConsigneePartyID      *int       `json:"consignee_party_id" db:"consignee_party_id"`
 	Shipper               *string    `json:"shipper" db:"shipper"`
+	ShipperGSTIN          *string    `json:"shipper_gstin" db:"shipper_gstin"`
+	ShipperIEC            *string    `json:"shipper_iec" db:"shipper_iec"`
+	ShipperPartyID        *int       `json:"shipper_party_id" db:"shipper_party_id"`
 	PortOfDischarge       *string    `json:"port_of_discharge" db:"port_of_discharge"`
+	PortOfDischargeID     *int       `json:"port_of_discharge_id" db:"port_of_discharge_id"`
 	FinalPlaceOfDelivery  *string    `json:"final_place_of_delivery" db:"final_place_of_delivery"`
+	FinalPlaceOfDeliveryID *int      `json:"final_place_of_delivery_id" db:"final_place_of_delivery_id"`
 	PortOfLoading         *string    `json:"port_of_loading" db:"port_of_loading"`
+	PortOfLoadingID       *int       `json:"port_of_loading_id" db:"port_of_loading_id"`
 	CountryOfShipment     *string    `json:"country_of_shipment" db:"country_of_shipment"`
+	CountryOfShipmentID   *int       `json:"country_of_shipment_id" db:"country_of_shipment_id"`
 	HBLNo                 *string    `json:"hbl_no" db:"hbl_no"`
 	HBLDate               *time.Time `json:"hbl_date" db:"hbl_date"`
 	MBLNo                 *string    `json:"mbl_no" db:"mbl_no"`
 	MBLDate               *time.Time `json:"mbl_date" db:"mbl_date"`
 	ShippingLine          *string    `json:"shipping_line" db:"shipping_line"`
+	ShippingLineID        *int       `json:"shipping_line_id" db:"shipping_line_id"`
 	Forwarder             *string    `json:"forwarder" db:"forwarder"`
+	ForwarderID           *int       `json:"forwarder_id" db:"forwarder_id"`
 	Weight                *float64   `json:"weight" db:"weight"`
 	Packages              *int       `json:"packages" db:"packages"`
 	InvoiceNo             *string    `json:"invoice_no" db:"invoice_no"`
@@ -48,8 +70,9 @@ type Stage1Data struct {
 	Commodity             *string    `json:"commodity" db:"commodity"`
 	ETA                   *time.Time `json:"eta" db:"eta"`
 	CurrentStatus         *string    `json:"current_status" db:"current_status"`
-	ContainerNo           *string    `json:"container_no" db:"container_no"`
-	ContainerSize         *string    `json:"container_size" db:"container_size"`
+	// ContainerNo and ContainerSize are those of the job's first container
+	ContainerNo           *string    `json:"container_no" db:"-"`
+	ContainerSize         *string    `json:"container_size" db:"-"`
 	DateOfArrival         *time.Time `json:"date_of_arrival" db:"date_of_arrival"`
 	InvoicePLDoc          *string    `json:"invoice_pl_doc" db:"invoice_pl_doc"`
 	BLDoc                 *string    `json:"bl_doc" db:"bl_doc"`
@@ -63,6 +86,7 @@ type Stage2Data struct {
 	ID                   int        `json:"id" db:"id"`
 	JobID                int        `json:"job_id" db:"job_id"`
 	HSNCode              *string    `json:"hsn_code" db:"hsn_code"`
+	HSNDescription       *string    `json:"hsn_description,omitempty" db:"-"`
 	FilingRequirement    *string    `json:"filing_requirement" db:"filing_requirement"`
 	ChecklistSentDate    *time.Time `json:"checklist_sent_date" db:"checklist_sent_date"`
 	ApprovalDate         *time.Time `json:"approval_date" db:"approval_date"`
@@ -86,6 +110,8 @@ type Stage2Data struct {
 	Document6            *string    `json:"document_6" db:"document_6"`
 	QueryUpload          *string    `json:"query_upload" db:"query_upload"`
 	ReplyUpload          *string    `json:"reply_upload" db:"reply_upload"`
+	DutyBreakdown        *DutyBreakdown `json:"duty_breakdown,omitempty" db:"-"`
+	Filings              []JobFiling    `json:"filings,omitempty" db:"-"`
 	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
 }
@@ -99,6 +125,7 @@ type Stage3Data struct {
 	ClearanceExps       *float64   `json:"clearance_exps" db:"clearance_exps"`
 	StampDuty           *float64   `json:"stamp_duty" db:"stamp_duty"`
 	Custodian           *string    `json:"custodian" db:"custodian"`
+	CustodianID         *int       `json:"custodian_id" db:"custodian_id"`
 	OffloadingCharges   *float64   `json:"offloading_charges" db:"offloading_charges"`
 	TransportDetention  *float64   `json:"transport_detention" db:"transport_detention"`
 	DispatchInfo        *string    `json:"dispatch_info" db:"dispatch_info"`
@@ -107,18 +134,6 @@ type Stage3Data struct {
 	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
 }
 
-// Stage3Container represents container details for stage 3
-type Stage3Container struct {
-	ID                int        `json:"id" db:"id"`
-	JobID             int        `json:"job_id" db:"job_id"`
-	ContainerNo       *string    `json:"container_no" db:"container_no"`
-	Size              *string    `json:"size" db:"size"`
-	VehicleNo         *string    `json:"vehicle_no" db:"vehicle_no"`
-	DateOfOffloading  *time.Time `json:"date_of_offloading" db:"date_of_offloading"`
-	EmptyReturnDate   *time.Time `json:"empty_return_date" db:"empty_return_date"`
-	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
-}
-
 // Stage4Data represents billing & customer data
 type Stage4Data struct {
 	ID               int        `json:"id" db:"id"`
@@ -128,12 +143,25 @@ type Stage4Data struct {
 	AmountTaxable    *float64   `json:"amount_taxable" db:"amount_taxable"`
 	GST5Percent      *float64   `json:"gst_5_percent" db:"gst_5_percent"`
 	GST18Percent     *float64   `json:"gst_18_percent" db:"gst_18_percent"`
+	PlaceOfSupply    *string    `json:"place_of_supply" db:"place_of_supply"`
+	CGSTAmount       *float64   `json:"cgst_amount" db:"cgst_amount"`
+	SGSTAmount       *float64   `json:"sgst_amount" db:"sgst_amount"`
+	IGSTAmount       *float64   `json:"igst_amount" db:"igst_amount"`
+	RoundOff         *float64   `json:"round_off" db:"round_off"`
+	TotalAmount      *float64   `json:"total_amount" db:"total_amount"`
+	TotalsOverridden bool       `json:"totals_overridden" db:"totals_overridden"`
+	Items            []BillItem `json:"items" db:"-"`
 	BillMail         *string    `json:"bill_mail" db:"bill_mail"`
 	BillCourier      *string    `json:"bill_courier" db:"bill_courier"`
 	CourierDate      *time.Time `json:"courier_date" db:"courier_date"`
 	AcknowledgeDate  *time.Time `json:"acknowledge_date" db:"acknowledge_date"`
 	AcknowledgeName  *string    `json:"acknowledge_name" db:"acknowledge_name"`
 	BillCopyUpload   *string    `json:"bill_copy_upload" db:"bill_copy_upload"`
+	// IssuedAt is when the bill was sent; from then on it is corrected
+	// only by credit and debit notes
+	IssuedAt         *time.Time `json:"issued_at" db:"issued_at"`
+	Notes            []BillNote `json:"notes,omitempty" db:"-"`
+	EInvoice         *EInvoice  `json:"e_invoice,omitempty" db:"-"`
 	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
 }
@@ -157,7 +185,7 @@ type PipelineJobResponse struct {
 	Stage1      *Stage1Data        `json:"stage1,omitempty"`
 	Stage2      *Stage2Data        `json:"stage2,omitempty"`
 	Stage3      *Stage3Data        `json:"stage3,omitempty"`
-	Stage3Containers []Stage3Container `json:"stage3_containers,omitempty"`
+	Containers  []Container        `json:"stage3_containers,omitempty"`
 	Stage4      *Stage4Data        `json:"stage4,omitempty"`
 	Updates     []JobUpdate        `json:"updates,omitempty"`
 	CreatedByUser    string        `json:"created_by_user,omitempty"`
@@ -168,92 +196,123 @@ type PipelineJobResponse struct {
 
 // Create request structs
 type Stage1CreateRequest struct {
-	JobNo                 string `json:"job_no" binding:"required"`
-	JobDate               string `json:"job_date"`
+	JobNo                 string `json:"job_no" validate:"required"`
+	JobDate               string `json:"job_date" validate:"omitempty,date"`
 	EDIJobNo              string `json:"edi_job_no"`
-	EDIDate               string `json:"edi_date"`
+	EDIDate               string `json:"edi_date" validate:"omitempty,date"`
 	Consignee             string `json:"consignee"`
+	ConsigneeGSTIN        string `json:"consignee_gstin" validate:"omitempty,gstin"`
+	ConsigneeIEC          string `json:"consignee_iec" validate:"omitempty,iec"`
+	ConsigneePartyID      int    `json:"consignee_party_id" validate:"min=0"`
 	Shipper               string `json:"shipper"`
+	ShipperGSTIN          string `json:"shipper_gstin" validate:"omitempty,gstin"`
+	ShipperIEC            string `json:"shipper_iec" validate:"omitempty,iec"`
+	ShipperPartyID        int    `json:"shipper_party_id" validate:"min=0"`
 	PortOfDischarge       string `json:"port_of_discharge"`
 	FinalPlaceOfDelivery  string `json:"final_place_of_delivery"`
 	PortOfLoading         string `json:"port_of_loading"`
 	CountryOfShipment     string `json:"country_of_shipment"`
 	HBLNo                 string `json:"hbl_no"`
-	HBLDate               string `json:"hbl_date"`
+	HBLDate               string `json:"hbl_date" validate:"omitempty,date"`
 	MBLNo                 string `json:"mbl_no"`
-	MBLDate               string `json:"mbl_date"`
+	MBLDate               string `json:"mbl_date" validate:"omitempty,date"`
 	ShippingLine          string `json:"shipping_line"`
 	Forwarder             string `json:"forwarder"`
-	Weight                float64 `json:"weight"`
-	Packages              int    `json:"packages"`
+	Weight                float64 `json:"weight" validate:"min=0"`
+	Packages              int    `json:"packages" validate:"min=0"`
 	InvoiceNo             string `json:"invoice_no"`
-	InvoiceDate           string `json:"invoice_date"`
+	InvoiceDate           string `json:"invoice_date" validate:"omitempty,date"`
 	GatewayIGM            string `json:"gateway_igm"`
-	GatewayIGMDate        string `json:"gateway_igm_date"`
+	GatewayIGMDate        string `json:"gateway_igm_date" validate:"omitempty,date"`
 	LocalIGM              string `json:"local_igm"`
-	LocalIGMDate          string `json:"local_igm_date"`
+	LocalIGMDate          string `json:"local_igm_date" validate:"omitempty,date"`
 	Commodity             string `json:"commodity"`
-	ETA                   string `json:"eta"`
+	ETA                   string `json:"eta" validate:"omitempty,datetime"`
 	CurrentStatus         string `json:"current_status"`
-	ContainerNo           string `json:"container_no"`
-	ContainerSize         string `json:"container_size"`
-	DateOfArrival         string `json:"date_of_arrival"`
-	AssignedToStage2      int    `json:"assigned_to_stage2"`
-	AssignedToStage3      int    `json:"assigned_to_stage3"`
-	CustomerID            int    `json:"customer_id"`
-	NotificationEmail     string `json:"notification_email"`
+	ContainerNo           string `json:"container_no" validate:"omitempty,container"`
+	ContainerSize         string `json:"container_size" validate:"omitempty,oneof=20 40 LCL"`
+	Containers            []ContainerRequest `json:"containers" validate:"max=100"`
+	DateOfArrival         string `json:"date_of_arrival" validate:"omitempty,date"`
+	AssignedToStage2      int    `json:"assigned_to_stage2" validate:"min=0"`
+	AssignedToStage3      int    `json:"assigned_to_stage3" validate:"min=0"`
+	CustomerID            int    `json:"customer_id" validate:"min=0"`
+	NotificationEmail     string `json:"notification_email" validate:"omitempty,email"`
+	Status                string `json:"status" validate:"omitempty,oneof=active draft"`
 }
 
 type Stage2UpdateRequest struct {
-	HSNCode              string  `json:"hsn_code"`
+	HSNCode              string  `json:"hsn_code" validate:"omitempty,hsn"`
 	FilingRequirement    string  `json:"filing_requirement"`
-	ChecklistSentDate    string  `json:"checklist_sent_date"`
-	ApprovalDate         string  `json:"approval_date"`
+	ChecklistSentDate    string  `json:"checklist_sent_date" validate:"omitempty,date"`
+	ApprovalDate         string  `json:"approval_date" validate:"omitempty,date"`
 	BillOfEntryNo        string  `json:"bill_of_entry_no"`
-	BillOfEntryDate      string  `json:"bill_of_entry_date"`
+	BillOfEntryDate      string  `json:"bill_of_entry_date" validate:"omitempty,date"`
 	DebitNote            string  `json:"debit_note"`
 	DebitPaidBy          string  `json:"debit_paid_by"`
-	DutyAmount           float64 `json:"duty_amount"`
+	DutyAmount           float64 `json:"duty_amount" validate:"min=0"`
 	DutyPaidBy           string  `json:"duty_paid_by"`
-	OceanFreight         float64 `json:"ocean_freight"`
-	DestinationCharges   float64 `json:"destination_charges"`
-	OriginalDoctRecdDate string  `json:"original_doct_recd_date"`
+	OceanFreight         float64 `json:"ocean_freight" validate:"min=0"`
+	DestinationCharges   float64 `json:"destination_charges" validate:"min=0"`
+	OriginalDoctRecdDate string  `json:"original_doct_recd_date" validate:"omitempty,date"`
 	DRNNo                string  `json:"drn_no"`
 	IRNNo                string  `json:"irn_no"`
 	DocumentsType        string  `json:"documents_type"`
 }
 
 type Stage3UpdateRequest struct {
-	ExamDate           string  `json:"exam_date"`
-	OutOfCharge        string  `json:"out_of_charge"`
-	ClearanceExps      float64 `json:"clearance_exps"`
-	StampDuty          float64 `json:"stamp_duty"`
+	ExamDate           string  `json:"exam_date" validate:"omitempty,date"`
+	OutOfCharge        string  `json:"out_of_charge" validate:"omitempty,date"`
+	ClearanceExps      float64 `json:"clearance_exps" validate:"min=0"`
+	StampDuty          float64 `json:"stamp_duty" validate:"min=0"`
 	Custodian          string  `json:"custodian"`
-	OffloadingCharges  float64 `json:"offloading_charges"`
-	TransportDetention float64 `json:"transport_detention"`
+	OffloadingCharges  float64 `json:"offloading_charges" validate:"min=0"`
+	TransportDetention float64 `json:"transport_detention" validate:"min=0"`
 	DispatchInfo       string  `json:"dispatch_info"`
 	Containers         []Stage3ContainerRequest `json:"containers"`
 }
 
+// Stage3ContainerRequest fills in a job's container: the one with ID, else
+// the one with ContainerNo, which is added when the job has none by that
+// number. Containers left out of the list are kept, and so are the number,
+// size, type code and vehicle of a container when they are left empty.
 type Stage3ContainerRequest struct {
-	ContainerNo      string `json:"container_no"`
-	Size             string `json:"size"`
+	ID               int    `json:"id" validate:"min=0"`
+	ContainerNo      string `json:"container_no" validate:"omitempty,container"`
+	Size             string `json:"size" validate:"omitempty,oneof=20 40 LCL"`
+	TypeCode         string `json:"type_code" validate:"omitempty,max=4"`
 	VehicleNo        string `json:"vehicle_no"`
-	DateOfOffloading string `json:"date_of_offloading"`
-	EmptyReturnDate  string `json:"empty_return_date"`
+	DateOfOffloading string `json:"date_of_offloading" validate:"omitempty,date"`
+	EmptyReturnDate  string `json:"empty_return_date" validate:"omitempty,date"`
 }
 
 type Stage4UpdateRequest struct {
 	BillNo          string  `json:"bill_no"`
-	BillDate        string  `json:"bill_date"`
-	AmountTaxable   float64 `json:"amount_taxable"`
-	GST5Percent     float64 `json:"gst_5_percent"`
-	GST18Percent    float64 `json:"gst_18_percent"`
+	BillDate        string  `json:"bill_date" validate:"omitempty,date"`
+	AmountTaxable   float64 `json:"amount_taxable" validate:"min=0"`
+	GST5Percent     float64 `json:"gst_5_percent" validate:"min=0"`
+	GST18Percent    float64 `json:"gst_18_percent" validate:"min=0"`
 	BillMail        string  `json:"bill_mail"`
 	BillCourier     string  `json:"bill_courier"`
-	CourierDate     string  `json:"courier_date"`
-	AcknowledgeDate string  `json:"acknowledge_date"`
+	CourierDate     string  `json:"courier_date" validate:"omitempty,date"`
+	AcknowledgeDate string  `json:"acknowledge_date" validate:"omitempty,date"`
 	AcknowledgeName string  `json:"acknowledge_name"`
+	// Items are the services billed; the tax and totals are computed from
+	// them. Totals sent above must agree with the computed ones unless an
+	// admin sets OverrideTotals.
+	Items          []BillItemRequest `json:"items" validate:"max=100"`
+	PlaceOfSupply  string            `json:"place_of_supply" validate:"omitempty,min=2,max=2"`
+	OverrideTotals bool              `json:"override_totals"`
+}
+
+// BillSent reports whether the update records the bill as sent to the
+// customer: it has a bill no and was mailed, couriered or acknowledged.
+// Saving a sent bill issues it.
+func (r *Stage4UpdateRequest) BillSent() bool {
+	if strings.TrimSpace(r.BillNo) == "" {
+		return false
+	}
+	return strings.TrimSpace(r.BillMail) != "" || strings.TrimSpace(r.BillCourier) != "" ||
+		r.CourierDate != "" || r.AcknowledgeDate != ""
 }
 
 // JobFile represents uploaded files for pipeline jobs
@@ -274,8 +333,8 @@ type JobFile struct {
 
 // FileUploadRequest represents a file upload request
 type FileUploadRequest struct {
-	JobID       int    `json:"job_id" binding:"required"`
-	Stage       string `json:"stage" binding:"required"`
+	JobID       int    `json:"job_id" validate:"required"`
+	Stage       string `json:"stage" validate:"required,oneof=stage1 stage2 stage3 stage4"`
 	Description string `json:"description"`
 }
 
@@ -284,4 +343,17 @@ type FileUploadResponse struct {
 	Success bool     `json:"success"`
 	Message string   `json:"message"`
 	File    *JobFile `json:"file,omitempty"`
-} 
\ No newline at end of file
+}
+
+// JobStageCount is the number of jobs sharing a current stage and status
+type JobStageCount struct {
+	CurrentStage string
+	Status       string
+	Count        int
+}
+
+// PartyIdentifiers are the GSTIN and IEC last recorded for a consignee or shipper
+type PartyIdentifiers struct {
+	GSTIN string
+	IEC   string
+}
@@ -0,0 +1,121 @@
+package models
+
+import "time"
+
+// Bill payment statuses
+const (
+	BillUnpaid     = "unpaid"
+	BillPartlyPaid = "partly_paid"
+	BillSettled    = "settled"
+)
+
+// PaymentAdvance is the mode of a payment adjusted from a customer's
+// advance rather than received
+const PaymentAdvance = "advance"
+
+// Payment settles all or part of a job's bill. Amount is what was received,
+// or drawn from an advance; TDSAmount is the tax the customer deducted at
+// source, which settles the bill as well.
+type Payment struct {
+	ID          int       `json:"id" db:"id"`
+	JobID       int       `json:"job_id" db:"job_id"`
+	PaymentDate time.Time `json:"payment_date" db:"payment_date"`
+	Amount      float64   `json:"amount" db:"amount"`
+	TDSAmount   float64   `json:"tds_amount" db:"tds_amount"`
+	Mode        string    `json:"mode" db:"mode"`
+	AdvanceID   *int      `json:"advance_id" db:"advance_id"`
+	ReferenceNo *string   `json:"reference_no" db:"reference_no"`
+	Remarks     *string   `json:"remarks" db:"remarks"`
+	CreatedBy   *int      `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// PaymentRequest records a payment against a job's bill. Mode advance
+// needs the AdvanceID to draw from.
+type PaymentRequest struct {
+	PaymentDate string  `json:"payment_date" validate:"required,date"`
+	Amount      float64 `json:"amount" validate:"min=0"`
+	TDSAmount   float64 `json:"tds_amount" validate:"min=0"`
+	Mode        string  `json:"mode" validate:"required,oneof=bank_transfer cheque cash upi advance"`
+	AdvanceID   int     `json:"advance_id" validate:"min=0"`
+	ReferenceNo string  `json:"reference_no" validate:"max=100"`
+	Remarks     string  `json:"remarks" validate:"max=500"`
+}
+
+// Receivable is a job's bill and how much of it is still owed. The
+// customer is the consignee, and BillAmount is net of the bill's approved
+// credit and debit notes.
+type Receivable struct {
+	JobID           int       `json:"job_id"`
+	JobNo           string    `json:"job_no"`
+	CurrentStage    string    `json:"current_stage"`
+	Customer        string    `json:"customer"`
+	BillNo          string    `json:"bill_no"`
+	BillDate        time.Time `json:"bill_date"`
+	DueDate         time.Time `json:"due_date"`
+	BillAmount      float64   `json:"bill_amount"`
+	Received        float64   `json:"received"`
+	AdvanceAdjusted float64   `json:"advance_adjusted"`
+	TDS             float64   `json:"tds"`
+	Outstanding     float64   `json:"outstanding"`
+	Status          string    `json:"status"`
+	AgeDays         int       `json:"age_days"`
+	DaysOverdue     int       `json:"days_overdue"`
+	Email           *string   `json:"email,omitempty"`
+	CreditDays      *int      `json:"-"`
+	Payments        []Payment `json:"payments,omitempty"`
+}
+
+// CustomerBalance is what a customer owes across their bills
+type CustomerBalance struct {
+	Customer       string  `json:"customer"`
+	Bills          int     `json:"bills"`
+	BillAmount     float64 `json:"bill_amount"`
+	Settled        float64 `json:"settled"`
+	Outstanding    float64 `json:"outstanding"`
+	Overdue        float64 `json:"overdue"`
+	AdvanceBalance float64 `json:"advance_balance"`
+}
+
+// AgingRow splits a customer's outstanding balance by the age of the bills
+// in days since the bill date
+type AgingRow struct {
+	Customer   string  `json:"customer"`
+	Days0To30  float64 `json:"days_0_30"`
+	Days31To60 float64 `json:"days_31_60"`
+	Days61To90 float64 `json:"days_61_90"`
+	Over90     float64 `json:"days_90_plus"`
+	Total      float64 `json:"total"`
+}
+
+// AgingReport is the aging of outstanding bills as of a date
+type AgingReport struct {
+	AsOf  string     `json:"as_of"`
+	Rows  []AgingRow `json:"rows"`
+	Total AgingRow   `json:"total"`
+}
+
+// Advance is money a customer paid before being billed, adjusted against
+// their bills later
+type Advance struct {
+	ID           int       `json:"id" db:"id"`
+	Customer     string    `json:"customer" db:"customer"`
+	Amount       float64   `json:"amount" db:"amount"`
+	Adjusted     float64   `json:"adjusted" db:"-"`
+	Balance      float64   `json:"balance" db:"-"`
+	ReceivedDate time.Time `json:"received_date" db:"received_date"`
+	ReferenceNo  *string   `json:"reference_no" db:"reference_no"`
+	Remarks      *string   `json:"remarks" db:"remarks"`
+	CreatedBy    *int      `json:"created_by" db:"created_by"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// AdvanceRequest records an advance received from a customer, named as the
+// consignee on their jobs
+type AdvanceRequest struct {
+	Customer     string  `json:"customer" validate:"required,max=255"`
+	Amount       float64 `json:"amount" validate:"min=0.01"`
+	ReceivedDate string  `json:"received_date" validate:"required,date"`
+	ReferenceNo  string  `json:"reference_no" validate:"max=100"`
+	Remarks      string  `json:"remarks" validate:"max=500"`
+}
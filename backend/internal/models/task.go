@@ -17,7 +17,7 @@ type TaskCreate struct {
 	JobID       string `json:"job_id" validate:"required"`
 	Description string `json:"description" validate:"required"`
 	Priority    string `json:"priority" validate:"required,oneof=Low Medium High"`
-	Deadline    string `json:"deadline" validate:"required"`
+	Deadline    string `json:"deadline" validate:"required,date"`
 	AssignedTo  []int  `json:"assigned_to"`
 }
 
@@ -52,6 +52,6 @@ type TaskUpdate struct {
 
 // TaskUpdateCreate represents the data needed to create a task update
 type TaskUpdateCreate struct {
-	Status  string `json:"status" validate:"required,oneof=Assigned In Progress Completed"`
+	Status  string `json:"status" validate:"required,oneof=Assigned 'In Progress' Completed"`
 	Comment string `json:"comment"`
 } 
\ No newline at end of file
@@ -11,6 +11,26 @@ type User struct {
 	IsAdmin      bool      `json:"is_admin"`
 	Role         string    `json:"role"`
 	CreatedAt    time.Time `json:"created_at,omitempty"`
+	// PartyID is the organization a customer belongs to, OrgAdmin whether
+	// they manage its members, and Stages the only stages of its jobs they
+	// may see, every stage when empty
+	PartyID  *int     `json:"party_id,omitempty"`
+	OrgAdmin bool     `json:"org_admin,omitempty"`
+	Stages   []string `json:"stages,omitempty"`
+}
+
+// SeesStage reports whether the user may see a stage of the jobs they have
+// access to
+func (u *User) SeesStage(stage string) bool {
+	if len(u.Stages) == 0 {
+		return true
+	}
+	for _, s := range u.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
 }
 
 // UserCreate represents the data needed to create a new user
@@ -35,4 +55,5 @@ type UserResponse struct {
 	Designation string `json:"designation"`
 	IsAdmin     bool   `json:"is_admin"`
 	Role        string `json:"role"`
+	PartyID     *int   `json:"party_id,omitempty"`
 } 
\ No newline at end of file
@@ -0,0 +1,81 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentBytes(t *testing.T) {
+	doc := New("Tax Invoice (INV/001)")
+	doc.Created = time.Date(2026, 4, 12, 9, 5, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		p := doc.AddPage()
+		p.Text(40, 60, Bold, 14, Black, "Total ₹ 1,00,000.00")
+		p.Line(40, 70, 555, 70, 0.5, Gray)
+		p.Rect(40, 80, 100, 20, White)
+	}
+	data, err := doc.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Fatalf("document starts %q, want %%PDF-", data[:min(len(data), 8)])
+	}
+	if !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Errorf("document does not end with %%%%EOF")
+	}
+
+	// startxref gives the offset of the cross-reference table, whose
+	// entries give the offset of each object
+	tail := data[bytes.LastIndex(data, []byte("startxref\n"))+len("startxref\n"):]
+	xref, err := strconv.Atoi(string(tail[:bytes.IndexByte(tail, '\n')]))
+	if err != nil {
+		t.Fatalf("bad startxref: %v", err)
+	}
+	lines := strings.Split(string(data[xref:]), "\n")
+	if lines[0] != "xref" {
+		t.Fatalf("startxref points at %q, want xref", lines[0])
+	}
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection %q", lines[1])
+	}
+	// Catalog, pages, two fonts and info, then two objects per page
+	if want := 5 + 2*2 + 1; count != want {
+		t.Errorf("xref lists %d objects, want %d", count, want)
+	}
+	for n := 1; n < count; n++ {
+		var offset int
+		if _, err := fmt.Sscanf(lines[2+n], "%d 00000 n", &offset); err != nil {
+			t.Fatalf("bad xref entry %d %q", n, lines[2+n])
+		}
+		if want := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q, want %q", n, data[offset:offset+len(want)], want)
+		}
+	}
+	if !bytes.Contains(data, []byte("/Count 2")) {
+		t.Error("page tree does not count two pages")
+	}
+	if !bytes.Contains(data, []byte(`/Title (Tax Invoice \(INV/001\))`)) {
+		t.Error("title is not escaped into the document information")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"₹ 500", "Rs. 500"},
+		{"Café – “quoted”", "Caf\xe9 - \"quoted\""},
+		{"a\tb\x01c", "a bc"},
+		{"日本", "??"},
+	}
+	for _, tt := range tests {
+		if got := encode(tt.in); got != tt.want {
+			t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// netBillAmount is a stage 4 bill's total net of its approved credit and
+// debit notes, for queries over stage4_data aliased s4
+const netBillAmount = `(s4.total_amount + COALESCE((
+	SELECT SUM(IF(n.kind = 'debit', n.total_amount, -n.total_amount))
+	FROM bill_notes n WHERE n.job_id = s4.job_id AND n.status = 'approved'), 0))`
+
+// BillNoteRepository handles the credit and debit notes correcting issued
+// bills
+type BillNoteRepository struct {
+	db *sql.DB
+}
+
+// NewBillNoteRepository creates a new bill note repository
+func NewBillNoteRepository(db *sql.DB) *BillNoteRepository {
+	return &BillNoteRepository{db: db}
+}
+
+// Notes lists a job's notes with their items, oldest first
+func (r *BillNoteRepository) Notes(ctx context.Context, jobID int) ([]models.BillNote, error) {
+	return jobBillNotes(ctx, r.db, jobID, "")
+}
+
+// GetNote retrieves one of a job's notes with its items
+func (r *BillNoteRepository) GetNote(ctx context.Context, jobID, id int) (*models.BillNote, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+billNoteColumns+billNoteFrom+` WHERE n.id = ? AND n.job_id = ?`, id, jobID)
+	n, err := scanBillNote(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	if err := loadBillNoteItems(ctx, r.db, []*models.BillNote{n}); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// CreateNote records a note awaiting approval and returns its ID
+func (r *BillNoteRepository) CreateNote(ctx context.Context, n *models.BillNote) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO bill_notes (
+			job_id, kind, reason, place_of_supply, amount_taxable,
+			cgst_amount, sgst_amount, igst_amount, round_off, total_amount, requested_by
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, n.JobID, n.Kind, n.Reason, n.PlaceOfSupply, n.AmountTaxable,
+		n.CGSTAmount, n.SGSTAmount, n.IGSTAmount, n.RoundOff, n.TotalAmount, n.RequestedBy)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range n.Items {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO bill_note_items (
+				note_id, line_no, description, sac, gst_rate, taxable_amount,
+				cgst_amount, sgst_amount, igst_amount, total_amount
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, id, item.LineNo, item.Description, item.SAC, item.GSTRate, item.TaxableAmount,
+			item.CGSTAmount, item.SGSTAmount, item.IGSTAmount, item.TotalAmount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, n.JobID, n.RequestedBy, fmt.Sprintf("%s note of %.2f requested: %s", noteTitle(n.Kind), n.TotalAmount, n.Reason))
+	if err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// Approve numbers a pending note as the next in series, dated date, and
+// approves it. check is called with the note and the bill's net amount
+// while both are locked, and the note is left pending if it returns an
+// error. A note that settles the bill closes the job, and one that
+// unsettles it reopens the job.
+func (r *BillNoteRepository) Approve(ctx context.Context, jobID, id, userID int, remarks, series string, date time.Time, check func(n *models.BillNote, net float64) error) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	n, err := scanBillNote(tx.QueryRowContext(ctx, `SELECT `+billNoteColumns+billNoteFrom+`
+		WHERE n.id = ? AND n.job_id = ? AND n.status = 'pending' FOR UPDATE`, id, jobID))
+	if err != nil {
+		return "", translate(err)
+	}
+	var net float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT `+netBillAmount+` FROM stage4_data s4 WHERE s4.job_id = ? FOR UPDATE
+	`, jobID).Scan(&net)
+	if err != nil {
+		return "", translate(err)
+	}
+	if err := check(n, net); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO document_series (series, last_no) VALUES (?, 1)
+		ON DUPLICATE KEY UPDATE last_no = last_no + 1
+	`, series); err != nil {
+		return "", err
+	}
+	var last int
+	if err := tx.QueryRowContext(ctx, `SELECT last_no FROM document_series WHERE series = ?`, series).Scan(&last); err != nil {
+		return "", err
+	}
+	noteNo := fmt.Sprintf("%s/%04d", series, last)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE bill_notes
+		SET status = 'approved', note_no = ?, note_date = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP, review_remarks = ?
+		WHERE id = ?
+	`, noteNo, date, userID, nullString(remarks), id)
+	if err != nil {
+		return "", translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, jobID, userID, fmt.Sprintf("%s note %s of %.2f approved", noteTitle(n.Kind), noteNo, n.TotalAmount))
+	if err != nil {
+		return "", err
+	}
+	if err := settleJob(ctx, tx, jobID, userID); err != nil {
+		return "", err
+	}
+	return noteNo, tx.Commit()
+}
+
+// Reject turns down a pending note
+func (r *BillNoteRepository) Reject(ctx context.Context, jobID, id, userID int, remarks string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE bill_notes
+		SET status = 'rejected', reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP, review_remarks = ?
+		WHERE id = ? AND job_id = ? AND status = 'pending'
+	`, userID, nullString(remarks), id, jobID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, jobID, userID, fmt.Sprintf("Bill note %d rejected", id))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetFile links the PDF generated for a note
+func (r *BillNoteRepository) SetFile(ctx context.Context, id, fileID int) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE bill_notes SET file_id = ? WHERE id = ?`, fileID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+const billNoteColumns = `n.id, n.job_id, n.kind, n.status, n.note_no, n.note_date, n.reason, n.place_of_supply,
+	n.amount_taxable, n.cgst_amount, n.sgst_amount, n.igst_amount, n.round_off, n.total_amount,
+	n.requested_by, COALESCE(u.username, ''), n.reviewed_by, n.reviewed_at, n.review_remarks, n.file_id, n.created_at`
+
+const billNoteFrom = `
+	FROM bill_notes n
+	LEFT JOIN users u ON u.id = n.requested_by`
+
+// jobBillNotes lists a job's notes, all of them or those in one status,
+// with their items
+func jobBillNotes(ctx context.Context, db *sql.DB, jobID int, status string) ([]models.BillNote, error) {
+	query := `SELECT ` + billNoteColumns + billNoteFrom + ` WHERE n.job_id = ?`
+	args := []interface{}{jobID}
+	if status != "" {
+		query += ` AND n.status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY n.created_at, n.id`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []models.BillNote{}
+	for rows.Next() {
+		n, err := scanBillNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ptrs := make([]*models.BillNote, len(notes))
+	for i := range notes {
+		ptrs[i] = &notes[i]
+	}
+	return notes, loadBillNoteItems(ctx, db, ptrs)
+}
+
+func loadBillNoteItems(ctx context.Context, db *sql.DB, notes []*models.BillNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(notes))
+	index := make(map[int]*models.BillNote, len(notes))
+	for i, n := range notes {
+		ids[i] = n.ID
+		index[n.ID] = n
+		n.Items = []models.BillItem{}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, note_id, line_no, description, sac, gst_rate, taxable_amount,
+			   cgst_amount, sgst_amount, igst_amount, total_amount
+		FROM bill_note_items WHERE note_id IN `+placeholders(len(ids))+` ORDER BY note_id, line_no
+	`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.BillItem
+		var noteID int
+		err := rows.Scan(&item.ID, &noteID, &item.LineNo, &item.Description, &item.SAC, &item.GSTRate,
+			&item.TaxableAmount, &item.CGSTAmount, &item.SGSTAmount, &item.IGSTAmount, &item.TotalAmount)
+		if err != nil {
+			return err
+		}
+		n := index[noteID]
+		item.JobID = n.JobID
+		n.Items = append(n.Items, item)
+	}
+	return rows.Err()
+}
+
+func scanBillNote(row interface{ Scan(...interface{}) error }) (*models.BillNote, error) {
+	var n models.BillNote
+	err := row.Scan(&n.ID, &n.JobID, &n.Kind, &n.Status, &n.NoteNo, &n.NoteDate, &n.Reason, &n.PlaceOfSupply,
+		&n.AmountTaxable, &n.CGSTAmount, &n.SGSTAmount, &n.IGSTAmount, &n.RoundOff, &n.TotalAmount,
+		&n.RequestedBy, &n.RequestedByUser, &n.ReviewedBy, &n.ReviewedAt, &n.ReviewRemarks, &n.FileID, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// noteTitle names a note kind in job updates
+func noteTitle(kind string) string {
+	if kind == models.DebitNote {
+		return "Debit"
+	}
+	return "Credit"
+}
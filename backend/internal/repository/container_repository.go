@@ -0,0 +1,503 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"maydiv-crm/internal/iso6346"
+	"maydiv-crm/internal/models"
+)
+
+// ContainerRepository handles the containers on a job and the events on
+// their timelines
+type ContainerRepository struct {
+	db *sql.DB
+}
+
+// NewContainerRepository creates a new container repository
+func NewContainerRepository(db *sql.DB) *ContainerRepository {
+	return &ContainerRepository{db: db}
+}
+
+const containerColumns = `id, job_id, container_no, size, type_code, vehicle_no, date_of_offloading, empty_return_date, status, created_at, updated_at`
+
+// Containers lists a job's containers with their events, in the order they
+// were added
+func (r *ContainerRepository) Containers(ctx context.Context, jobID int) ([]models.Container, error) {
+	return queryContainers(ctx, r.db, jobID)
+}
+
+// GetContainer retrieves one of a job's containers with its events
+func (r *ContainerRepository) GetContainer(ctx context.Context, jobID, id int) (*models.Container, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+containerColumns+` FROM job_containers WHERE id = ? AND job_id = ?`, id, jobID)
+	c, err := scanContainer(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, container_id, event, event_at, location, remarks, created_by, created_at
+		FROM container_events WHERE container_id = ?
+		ORDER BY event_at, id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanContainerEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		c.Events = append(c.Events, *e)
+	}
+	return c, rows.Err()
+}
+
+// CreateContainer adds a container to a job and returns its ID
+func (r *ContainerRepository) CreateContainer(ctx context.Context, jobID int, req *models.ContainerRequest, userID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM pipeline_jobs WHERE id = ?`, jobID).Scan(&exists)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := insertContainer(ctx, tx, jobID, req)
+	if err != nil {
+		return 0, err
+	}
+	if err := addContainerUpdate(ctx, tx, jobID, userID, "stage1", fmt.Sprintf("Container %s added", containerLabel(req.ContainerNo, id))); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UpdateContainer replaces the details of one of a job's containers. Its
+// events and the dates they set are kept.
+func (r *ContainerRepository) UpdateContainer(ctx context.Context, jobID, id int, req *models.ContainerRequest, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockContainer(ctx, tx, jobID, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE job_containers SET container_no = ?, size = ?, type_code = ?, vehicle_no = ?
+		WHERE id = ?
+	`, nullString(iso6346.Normalize(req.ContainerNo)), nullString(req.Size), nullString(iso6346.Normalize(req.TypeCode)),
+		nullString(req.VehicleNo), id)
+	if err != nil {
+		return translate(err)
+	}
+	if err := addContainerUpdate(ctx, tx, jobID, userID, "stage1", fmt.Sprintf("Container %s updated", containerLabel(req.ContainerNo, id))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteContainer removes one of a job's containers with its events
+func (r *ContainerRepository) DeleteContainer(ctx context.Context, jobID, id, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	label, err := lockContainer(ctx, tx, jobID, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM job_containers WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := addContainerUpdate(ctx, tx, jobID, userID, "stage1", fmt.Sprintf("Container %s removed", label)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddEvent records an event on one of a job's containers and returns its
+// ID. The container's status and dates follow its timeline.
+func (r *ContainerRepository) AddEvent(ctx context.Context, jobID, containerID int, req *models.ContainerEventRequest, userID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	label, err := lockContainer(ctx, tx, jobID, containerID)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO container_events (container_id, event, event_at, location, remarks, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, containerID, req.Event, parseDateTime(req.EventAt), nullString(req.Location), nullString(req.Remarks), nullInt(userID))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := refreshContainer(ctx, tx, containerID); err != nil {
+		return 0, err
+	}
+	if err := addContainerUpdate(ctx, tx, jobID, userID, "stage3", fmt.Sprintf("Container %s: %s", label, req.Event)); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// DeleteEvent removes an event from the timeline of one of a job's
+// containers
+func (r *ContainerRepository) DeleteEvent(ctx context.Context, jobID, containerID, eventID, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	label, err := lockContainer(ctx, tx, jobID, containerID)
+	if err != nil {
+		return err
+	}
+	var event string
+	err = tx.QueryRowContext(ctx, `SELECT event FROM container_events WHERE id = ? AND container_id = ?`, eventID, containerID).Scan(&event)
+	if err != nil {
+		return translate(err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM container_events WHERE id = ?`, eventID); err != nil {
+		return err
+	}
+	if err := refreshContainer(ctx, tx, containerID); err != nil {
+		return err
+	}
+	if err := addContainerUpdate(ctx, tx, jobID, userID, "stage3", fmt.Sprintf("Container %s: %s event removed", label, event)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryContainers lists a job's containers with their events
+func queryContainers(ctx context.Context, db *sql.DB, jobID int) ([]models.Container, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+containerColumns+` FROM job_containers WHERE job_id = ? ORDER BY id`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	containers := []models.Container{}
+	index := map[int]int{}
+	for rows.Next() {
+		c, err := scanContainer(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[c.ID] = len(containers)
+		containers = append(containers, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return containers, nil
+	}
+
+	events, err := db.QueryContext(ctx, `
+		SELECT e.id, e.container_id, e.event, e.event_at, e.location, e.remarks, e.created_by, e.created_at
+		FROM container_events e
+		JOIN job_containers c ON c.id = e.container_id
+		WHERE c.job_id = ?
+		ORDER BY e.container_id, e.event_at, e.id
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer events.Close()
+	for events.Next() {
+		e, err := scanContainerEvent(events)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[e.ContainerID]; ok {
+			containers[i].Events = append(containers[i].Events, *e)
+		}
+	}
+	return containers, events.Err()
+}
+
+// insertContainer adds a container to a job and returns its ID
+func insertContainer(ctx context.Context, tx *sql.Tx, jobID int, req *models.ContainerRequest) (int, error) {
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO job_containers (job_id, container_no, size, type_code, vehicle_no)
+		VALUES (?, ?, ?, ?, ?)
+	`, jobID, nullString(iso6346.Normalize(req.ContainerNo)), nullString(req.Size), nullString(iso6346.Normalize(req.TypeCode)),
+		nullString(req.VehicleNo))
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// lockContainer locks one of a job's containers for the rest of tx and
+// returns how it is named on the job's timeline
+func lockContainer(ctx context.Context, tx *sql.Tx, jobID, id int) (string, error) {
+	var containerNo sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT container_no FROM job_containers WHERE id = ? AND job_id = ? FOR UPDATE`, id, jobID).Scan(&containerNo)
+	if err != nil {
+		return "", translate(err)
+	}
+	return containerLabel(containerNo.String, id), nil
+}
+
+// saveStage3Container fills in the job's container that c matches, adding
+// it when the job has none by its number. Its dates are recorded as
+// delivered and empty_returned events.
+func saveStage3Container(ctx context.Context, tx *sql.Tx, jobID int, c *models.Stage3ContainerRequest, userID int) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, container_no FROM job_containers WHERE job_id = ? FOR UPDATE`, jobID)
+	if err != nil {
+		return err
+	}
+	var containers []containerRef
+	for rows.Next() {
+		var ref containerRef
+		var containerNo sql.NullString
+		if err := rows.Scan(&ref.ID, &containerNo); err != nil {
+			rows.Close()
+			return err
+		}
+		ref.ContainerNo = containerNo.String
+		containers = append(containers, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	id, err := matchStage3Container(c, containers)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		details := &models.ContainerRequest{ContainerNo: c.ContainerNo, Size: c.Size, TypeCode: c.TypeCode, VehicleNo: c.VehicleNo}
+		if id, err = insertContainer(ctx, tx, jobID, details); err != nil {
+			return err
+		}
+	}
+
+	// Fields left out keep what the container has, such as a type code set
+	// through the container's own endpoints
+	_, err = tx.ExecContext(ctx, `
+		UPDATE job_containers
+		SET container_no = COALESCE(?, container_no), size = COALESCE(?, size),
+			type_code = COALESCE(?, type_code), vehicle_no = COALESCE(?, vehicle_no)
+		WHERE id = ?
+	`, nullString(iso6346.Normalize(c.ContainerNo)), nullString(c.Size), nullString(iso6346.Normalize(c.TypeCode)),
+		nullString(c.VehicleNo), id)
+	if err != nil {
+		return translate(err)
+	}
+	if err := setContainerDate(ctx, tx, id, models.ContainerDelivered, c.DateOfOffloading, userID); err != nil {
+		return err
+	}
+	if err := setContainerDate(ctx, tx, id, models.ContainerEmptyReturned, c.EmptyReturnDate, userID); err != nil {
+		return err
+	}
+	return refreshContainer(ctx, tx, id)
+}
+
+// containerRef is one of a job's containers as stage 3 containers are
+// matched against them
+type containerRef struct {
+	ID          int
+	ContainerNo string
+}
+
+// matchStage3Container returns the ID of the container c fills in: the
+// job's container with c.ID, else the one numbered c.ContainerNo. It
+// returns 0 when the job has no container by that number and one is to be
+// added, and ErrNotFound when c.ID is not one of the job's containers.
+func matchStage3Container(c *models.Stage3ContainerRequest, containers []containerRef) (int, error) {
+	if c.ID != 0 {
+		for _, ref := range containers {
+			if ref.ID == c.ID {
+				return ref.ID, nil
+			}
+		}
+		return 0, ErrNotFound
+	}
+	no := iso6346.Normalize(c.ContainerNo)
+	if no == "" {
+		return 0, fmt.Errorf("container without an ID or number")
+	}
+	for _, ref := range containers {
+		if iso6346.Normalize(ref.ContainerNo) == no {
+			return ref.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// dateChange is how a container date is recorded on its timeline
+type dateChange int
+
+const (
+	keepDate dateChange = iota
+	addDateEvent
+	moveDateEvent
+)
+
+// containerDateChange decides how to record that a container's latest event
+// of a kind happened on date, given when the latest such event happened, or
+// nil if there is none. An empty date, or one the event is already on,
+// leaves the timeline as it is.
+func containerDateChange(latest *time.Time, date string) dateChange {
+	switch {
+	case parseDate(date) == nil:
+		return keepDate
+	case latest == nil:
+		return addDateEvent
+	case latest.Format("2006-01-02") == date:
+		return keepDate
+	}
+	return moveDateEvent
+}
+
+// setContainerDate records that a container's latest event of kind event
+// happened on date, moving that event or adding one. refreshContainer must
+// follow.
+func setContainerDate(ctx context.Context, tx *sql.Tx, containerID int, event, date string, userID int) error {
+	var eventID int
+	var eventAt time.Time
+	var latest *time.Time
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, event_at FROM container_events
+		WHERE container_id = ? AND event = ?
+		ORDER BY event_at DESC, id DESC LIMIT 1
+	`, containerID, event).Scan(&eventID, &eventAt)
+	switch {
+	case err == nil:
+		latest = &eventAt
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	switch containerDateChange(latest, date) {
+	case addDateEvent:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO container_events (container_id, event, event_at, created_by) VALUES (?, ?, ?, ?)
+		`, containerID, event, parseDate(date), nullInt(userID))
+		return err
+	case moveDateEvent:
+		_, err = tx.ExecContext(ctx, `UPDATE container_events SET event_at = ? WHERE id = ?`, parseDate(date), eventID)
+		return err
+	}
+	return nil
+}
+
+// refreshContainer brings a container's status and dates in line with its
+// timeline
+func refreshContainer(ctx context.Context, tx *sql.Tx, id int) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, event, event_at FROM container_events WHERE container_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	var events []models.ContainerEvent
+	for rows.Next() {
+		var e models.ContainerEvent
+		if err := rows.Scan(&e.ID, &e.Event, &e.EventAt); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	status, offloaded, emptyReturned := containerState(events)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE job_containers SET status = ?, date_of_offloading = ?, empty_return_date = ? WHERE id = ?
+	`, status, offloaded, emptyReturned, id)
+	return err
+}
+
+// containerState reads a container's timeline: its status is its latest
+// event, and its offloading and empty return dates are the days of its
+// latest delivered and empty_returned events. Events at the same moment are
+// ordered by ID.
+func containerState(events []models.ContainerEvent) (status *string, offloaded, emptyReturned *time.Time) {
+	var latest *models.ContainerEvent
+	for i := range events {
+		e := &events[i]
+		if latest == nil || e.EventAt.After(latest.EventAt) || e.EventAt.Equal(latest.EventAt) && e.ID > latest.ID {
+			latest = e
+		}
+		var date **time.Time
+		switch e.Event {
+		case models.ContainerDelivered:
+			date = &offloaded
+		case models.ContainerEmptyReturned:
+			date = &emptyReturned
+		default:
+			continue
+		}
+		y, m, d := e.EventAt.Date()
+		if day := time.Date(y, m, d, 0, 0, 0, 0, e.EventAt.Location()); *date == nil || day.After(**date) {
+			*date = &day
+		}
+	}
+	if latest != nil {
+		status = &latest.Event
+	}
+	return status, offloaded, emptyReturned
+}
+
+func addContainerUpdate(ctx context.Context, tx *sql.Tx, jobID, userID int, stage, message string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, ?, 'data_update', ?)
+	`, jobID, userID, stage, message)
+	return err
+}
+
+// containerLabel names a container by its number, or by its ID until it
+// has one
+func containerLabel(containerNo string, id int) string {
+	if no := iso6346.Normalize(containerNo); no != "" {
+		return no
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+func scanContainer(row interface{ Scan(...interface{}) error }) (*models.Container, error) {
+	var c models.Container
+	err := row.Scan(&c.ID, &c.JobID, &c.ContainerNo, &c.Size, &c.TypeCode, &c.VehicleNo,
+		&c.DateOfOffloading, &c.EmptyReturnDate, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Events = []models.ContainerEvent{}
+	return &c, nil
+}
+
+func scanContainerEvent(row interface{ Scan(...interface{}) error }) (*models.ContainerEvent, error) {
+	var e models.ContainerEvent
+	err := row.Scan(&e.ID, &e.ContainerID, &e.Event, &e.EventAt, &e.Location, &e.Remarks, &e.CreatedBy, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestMatchStage3Container(t *testing.T) {
+	containers := []containerRef{
+		{ID: 4, ContainerNo: "MSCU1234565"},
+		{ID: 5},
+		{ID: 6, ContainerNo: "TGHU 987654-3"},
+	}
+	tests := []struct {
+		name    string
+		req     models.Stage3ContainerRequest
+		want    int
+		wantErr error
+	}{
+		{name: "by ID", req: models.Stage3ContainerRequest{ID: 5}, want: 5},
+		{name: "ID wins over number", req: models.Stage3ContainerRequest{ID: 4, ContainerNo: "TGHU9876543"}, want: 4},
+		{name: "ID of another job", req: models.Stage3ContainerRequest{ID: 9, ContainerNo: "MSCU1234565"}, wantErr: ErrNotFound},
+		{name: "by number", req: models.Stage3ContainerRequest{ContainerNo: "mscu 123456-5"}, want: 4},
+		{name: "by number written differently", req: models.Stage3ContainerRequest{ContainerNo: "TGHU9876543"}, want: 6},
+		{name: "new number", req: models.Stage3ContainerRequest{ContainerNo: "CSQU3054383"}, want: 0},
+	}
+	for _, tt := range tests {
+		got, err := matchStage3Container(&tt.req, containers)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("%s: matchStage3Container = %d, %v; want %d, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, err := matchStage3Container(&models.Stage3ContainerRequest{ContainerNo: " - "}, containers); err == nil {
+		t.Error("a container without an ID or number was matched")
+	}
+}
+
+func TestContainerDateChange(t *testing.T) {
+	latest := time.Date(2026, 4, 10, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		latest *time.Time
+		date   string
+		want   dateChange
+	}{
+		{name: "no date", latest: &latest, date: "", want: keepDate},
+		{name: "unreadable date", date: "10/04/2026", want: keepDate},
+		{name: "first event", date: "2026-04-10", want: addDateEvent},
+		{name: "same day", latest: &latest, date: "2026-04-10", want: keepDate},
+		{name: "another day", latest: &latest, date: "2026-04-12", want: moveDateEvent},
+	}
+	for _, tt := range tests {
+		if got := containerDateChange(tt.latest, tt.date); got != tt.want {
+			t.Errorf("%s: containerDateChange = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContainerState(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2026, 4, day, hour, 0, 0, 0, time.UTC) }
+	date := func(day int) *time.Time { d := time.Date(2026, 4, day, 0, 0, 0, 0, time.UTC); return &d }
+
+	tests := []struct {
+		name                     string
+		events                   []models.ContainerEvent
+		status                   string
+		offloaded, emptyReturned *time.Time
+	}{
+		{name: "no events"},
+		{
+			name: "latest event, whatever order it was recorded in",
+			events: []models.ContainerEvent{
+				{ID: 3, Event: models.ContainerDelivered, EventAt: at(12, 18)},
+				{ID: 1, Event: models.ContainerDischarged, EventAt: at(10, 9)},
+				{ID: 2, Event: models.ContainerOutOfCharge, EventAt: at(11, 14)},
+			},
+			status: models.ContainerDelivered, offloaded: date(12),
+		},
+		{
+			name: "same moment goes to the later event",
+			events: []models.ContainerEvent{
+				{ID: 8, Event: models.ContainerEmptyReturned, EventAt: at(14, 10)},
+				{ID: 7, Event: models.ContainerDelivered, EventAt: at(14, 10)},
+			},
+			status: models.ContainerEmptyReturned, offloaded: date(14), emptyReturned: date(14),
+		},
+		{
+			name: "latest of repeated deliveries",
+			events: []models.ContainerEvent{
+				{ID: 1, Event: models.ContainerDelivered, EventAt: at(12, 18)},
+				{ID: 2, Event: models.ContainerDelivered, EventAt: at(15, 8)},
+				{ID: 3, Event: models.ContainerExamined, EventAt: at(13, 11)},
+			},
+			status: models.ContainerDelivered, offloaded: date(15),
+		},
+	}
+	sameDay := func(a, b *time.Time) bool { return a == nil && b == nil || a != nil && b != nil && a.Equal(*b) }
+	for _, tt := range tests {
+		status, offloaded, emptyReturned := containerState(tt.events)
+		if (status == nil) != (tt.status == "") || status != nil && *status != tt.status {
+			t.Errorf("%s: status = %v, want %q", tt.name, status, tt.status)
+		}
+		if !sameDay(offloaded, tt.offloaded) {
+			t.Errorf("%s: offloaded = %v, want %v", tt.name, offloaded, tt.offloaded)
+		}
+		if !sameDay(emptyReturned, tt.emptyReturned) {
+			t.Errorf("%s: empty returned = %v, want %v", tt.name, emptyReturned, tt.emptyReturned)
+		}
+	}
+}
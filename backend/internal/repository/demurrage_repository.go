@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// DemurrageRepository handles demurrage and detention tariffs, the
+// containers they run on and the free time alerts sent
+type DemurrageRepository struct {
+	db *sql.DB
+}
+
+// NewDemurrageRepository creates a new demurrage repository
+func NewDemurrageRepository(db *sql.DB) *DemurrageRepository {
+	return &DemurrageRepository{db: db}
+}
+
+const demurrageTariffColumns = `id, shipping_line, container_size, kind, free_days, currency, created_at, updated_at`
+
+// Tariffs lists the tariffs with their slabs, by shipping line, the
+// tariffs for any line first
+func (r *DemurrageRepository) Tariffs(ctx context.Context) ([]models.DemurrageTariff, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+demurrageTariffColumns+` FROM demurrage_tariffs
+		ORDER BY shipping_line, container_size, kind
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tariffs := []models.DemurrageTariff{}
+	index := map[int]int{}
+	for rows.Next() {
+		t, err := scanDemurrageTariff(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[t.ID] = len(tariffs)
+		tariffs = append(tariffs, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slabs, err := r.db.QueryContext(ctx, `SELECT tariff_id, from_day, to_day, rate FROM demurrage_slabs ORDER BY tariff_id, from_day`)
+	if err != nil {
+		return nil, err
+	}
+	defer slabs.Close()
+	for slabs.Next() {
+		var tariffID int
+		var s models.DemurrageSlab
+		if err := slabs.Scan(&tariffID, &s.FromDay, &s.ToDay, &s.Rate); err != nil {
+			return nil, err
+		}
+		if i, ok := index[tariffID]; ok {
+			tariffs[i].Slabs = append(tariffs[i].Slabs, s)
+		}
+	}
+	return tariffs, slabs.Err()
+}
+
+// GetTariff retrieves a tariff with its slabs
+func (r *DemurrageRepository) GetTariff(ctx context.Context, id int) (*models.DemurrageTariff, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+demurrageTariffColumns+` FROM demurrage_tariffs WHERE id = ?`, id)
+	t, err := scanDemurrageTariff(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT from_day, to_day, rate FROM demurrage_slabs WHERE tariff_id = ? ORDER BY from_day`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.DemurrageSlab
+		if err := rows.Scan(&s.FromDay, &s.ToDay, &s.Rate); err != nil {
+			return nil, err
+		}
+		t.Slabs = append(t.Slabs, s)
+	}
+	return t, rows.Err()
+}
+
+// CreateTariff inserts a tariff with its slabs and returns its ID
+func (r *DemurrageRepository) CreateTariff(ctx context.Context, t *models.DemurrageTariff) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO demurrage_tariffs (shipping_line, container_size, kind, free_days, currency)
+		VALUES (?, ?, ?, ?, ?)
+	`, t.ShippingLine, t.ContainerSize, t.Kind, t.FreeDays, t.Currency)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertDemurrageSlabs(ctx, tx, int(id), t.Slabs); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// UpdateTariff replaces a tariff and its slabs
+func (r *DemurrageRepository) UpdateTariff(ctx context.Context, t *models.DemurrageTariff) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM demurrage_tariffs WHERE id = ? FOR UPDATE`, t.ID).Scan(&exists)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE demurrage_tariffs
+		SET shipping_line = ?, container_size = ?, kind = ?, free_days = ?, currency = ?
+		WHERE id = ?
+	`, t.ShippingLine, t.ContainerSize, t.Kind, t.FreeDays, t.Currency, t.ID)
+	if err != nil {
+		return translate(err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM demurrage_slabs WHERE tariff_id = ?`, t.ID); err != nil {
+		return err
+	}
+	if err := insertDemurrageSlabs(ctx, tx, t.ID, t.Slabs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTariff removes a tariff and its slabs
+func (r *DemurrageRepository) DeleteTariff(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM demurrage_tariffs WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Containers lists the containers of jobs that are not cancelled, or of
+// one job when jobID is not 0. With openOnly, containers whose empty has
+// been returned are left out.
+func (r *DemurrageRepository) Containers(ctx context.Context, jobID int, openOnly bool) ([]models.ContainerDemurrage, error) {
+	filter := ` AND pj.status <> 'cancelled'`
+	var args []interface{}
+	if jobID != 0 {
+		filter = ` AND pj.id = ?`
+		args = append(args, jobID)
+	}
+	if openOnly {
+		filter += ` AND c.empty_return_date IS NULL`
+	}
+	query := `
+		SELECT pj.id, pj.job_no, COALESCE(s1.consignee, ''), COALESCE(s1.shipping_line, ''), s1.date_of_arrival,
+			   COALESCE(c.container_no, ''), COALESCE(c.size, ''), c.date_of_offloading, c.empty_return_date,
+			   COALESCE(pj.notification_email, '')
+		FROM job_containers c
+		JOIN pipeline_jobs pj ON pj.id = c.job_id
+		LEFT JOIN stage1_data s1 ON s1.job_id = pj.id
+		WHERE TRUE` + filter + `
+		ORDER BY pj.job_no, c.container_no`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	containers := []models.ContainerDemurrage{}
+	for rows.Next() {
+		var c models.ContainerDemurrage
+		err := rows.Scan(&c.JobID, &c.JobNo, &c.Customer, &c.ShippingLine, &c.ArrivalDate,
+			&c.ContainerNo, &c.Size, &c.OffloadDate, &c.ReturnDate, &c.Email)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, c)
+	}
+	return containers, rows.Err()
+}
+
+// AlertSent reports whether the alert that a container's free time of kind
+// ends on freeUntil has been sent
+func (r *DemurrageRepository) AlertSent(ctx context.Context, jobID int, containerNo, kind string, freeUntil time.Time) (bool, error) {
+	var sent bool
+	err := r.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM demurrage_alerts WHERE job_id = ? AND container_no = ? AND kind = ? AND free_until = ?)
+	`, jobID, containerNo, kind, freeUntil.Format("2006-01-02")).Scan(&sent)
+	return sent, err
+}
+
+// RecordAlert records that an alert was sent to sentTo. An alert recorded
+// already is left as it is.
+func (r *DemurrageRepository) RecordAlert(ctx context.Context, jobID int, containerNo, kind string, freeUntil time.Time, sentTo string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT IGNORE INTO demurrage_alerts (job_id, container_no, kind, free_until, sent_to)
+		VALUES (?, ?, ?, ?, ?)
+	`, jobID, containerNo, kind, freeUntil.Format("2006-01-02"), sentTo)
+	return err
+}
+
+func insertDemurrageSlabs(ctx context.Context, tx *sql.Tx, tariffID int, slabs []models.DemurrageSlab) error {
+	for _, s := range slabs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO demurrage_slabs (tariff_id, from_day, to_day, rate) VALUES (?, ?, ?, ?)
+		`, tariffID, s.FromDay, s.ToDay, s.Rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanDemurrageTariff(row interface{ Scan(...interface{}) error }) (*models.DemurrageTariff, error) {
+	var t models.DemurrageTariff
+	err := row.Scan(&t.ID, &t.ShippingLine, &t.ContainerSize, &t.Kind, &t.FreeDays, &t.Currency, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Slabs = []models.DemurrageSlab{}
+	return &t, nil
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// DepositRepository handles customer deposit accounts and the money moving
+// through them
+type DepositRepository struct {
+	db *sql.DB
+}
+
+// NewDepositRepository creates a new deposit repository
+func NewDepositRepository(db *sql.DB) *DepositRepository {
+	return &DepositRepository{db: db}
+}
+
+const depositAccountColumns = `a.id, a.customer,
+	COALESCE(SUM(CASE WHEN m.kind = 'receipt' THEN m.amount END), 0),
+	COALESCE(SUM(CASE WHEN m.kind = 'debit' THEN m.amount END), 0),
+	COALESCE(SUM(CASE WHEN m.kind = 'refund' THEN m.amount END), 0),
+	a.created_by, a.created_at`
+
+const depositAccountFrom = `
+	FROM deposit_accounts a
+	LEFT JOIN deposit_movements m ON m.account_id = a.id`
+
+const depositAccountGroup = ` GROUP BY a.id, a.customer, a.created_by, a.created_at`
+
+// Accounts lists every deposit account with its balance, by customer
+func (r *DepositRepository) Accounts(ctx context.Context) ([]models.DepositAccount, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+depositAccountColumns+depositAccountFrom+depositAccountGroup+` ORDER BY a.customer`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.DepositAccount{}
+	for rows.Next() {
+		a, err := scanDepositAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *a)
+	}
+	return accounts, rows.Err()
+}
+
+// GetAccount retrieves a deposit account with its balance
+func (r *DepositRepository) GetAccount(ctx context.Context, id int) (*models.DepositAccount, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+depositAccountColumns+depositAccountFrom+` WHERE a.id = ?`+depositAccountGroup, id)
+	a, err := scanDepositAccount(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return a, nil
+}
+
+// AccountByCustomer retrieves the deposit account of a customer, matching
+// the name by its key, so that case, punctuation and legal forms such as
+// "Pvt Ltd" do not matter
+func (r *DepositRepository) AccountByCustomer(ctx context.Context, customer string) (*models.DepositAccount, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+depositAccountColumns+depositAccountFrom+` WHERE a.customer_key = ?`+depositAccountGroup, CustomerKey(customer))
+	a, err := scanDepositAccount(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return a, nil
+}
+
+// AccountByParty retrieves the deposit account of a party
+func (r *DepositRepository) AccountByParty(ctx context.Context, partyID int) (*models.DepositAccount, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+depositAccountColumns+depositAccountFrom+` WHERE a.party_id = ?`+depositAccountGroup, partyID)
+	a, err := scanDepositAccount(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return a, nil
+}
+
+// CreateAccount opens a deposit account, for the party the customer's name
+// matches if any, and returns its ID
+func (r *DepositRepository) CreateAccount(ctx context.Context, a *models.DepositAccount) (int, error) {
+	partyID, err := resolveParty(ctx, r.db, a.Customer)
+	if err != nil {
+		return 0, err
+	}
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO deposit_accounts (customer, customer_key, party_id, created_by) VALUES (?, ?, ?, ?)
+	`, a.Customer, CustomerKey(a.Customer), partyID, a.CreatedBy)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// CustomerKey is the key customer names on deposit accounts, advances and
+// jobs are matched by: the name as a party key, else lower case when it is
+// only legal forms
+func CustomerKey(customer string) string {
+	if key := PartyKey(customer); key != "" {
+		return key
+	}
+	return strings.ToLower(strings.TrimSpace(customer))
+}
+
+// AddMovement records a receipt or refund entered by hand and returns its
+// ID
+func (r *DepositRepository) AddMovement(ctx context.Context, m *models.DepositMovement) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO deposit_movements (account_id, kind, amount, movement_date, description, reference_no, remarks, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, m.AccountID, m.Kind, m.Amount, m.MovementDate, m.Description, m.ReferenceNo, m.Remarks, m.CreatedBy)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Movements lists an account's movements up to and including to, if given,
+// in the order they happened
+func (r *DepositRepository) Movements(ctx context.Context, accountID int, to *time.Time) ([]models.DepositMovement, error) {
+	query := `
+		SELECT m.id, m.account_id, m.kind, m.amount, m.movement_date, m.description, m.job_id, pj.job_no,
+			   m.ledger_entry_id, m.reference_no, m.remarks, m.created_by, m.created_at
+		FROM deposit_movements m
+		LEFT JOIN pipeline_jobs pj ON pj.id = m.job_id
+		WHERE m.account_id = ?`
+	args := []interface{}{accountID}
+	if to != nil {
+		query += ` AND m.movement_date <= ?`
+		args = append(args, *to)
+	}
+	query += ` ORDER BY m.movement_date, m.id`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []models.DepositMovement{}
+	for rows.Next() {
+		var m models.DepositMovement
+		err := rows.Scan(&m.ID, &m.AccountID, &m.Kind, &m.Amount, &m.MovementDate, &m.Description, &m.JobID, &m.JobNo,
+			&m.LedgerEntryID, &m.ReferenceNo, &m.Remarks, &m.CreatedBy, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	return movements, rows.Err()
+}
+
+// JobDebited totals what has been debited from deposits for a job's ledger
+// entries in the given categories
+func (r *DepositRepository) JobDebited(ctx context.Context, jobID int, categories ...string) (float64, error) {
+	args := []interface{}{jobID}
+	for _, c := range categories {
+		args = append(args, c)
+	}
+	var total float64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(m.amount), 0)
+		FROM deposit_movements m
+		JOIN ledger_entries le ON le.id = m.ledger_entry_id
+		WHERE m.job_id = ? AND m.kind = 'debit' AND le.category IN `+placeholders(len(categories)),
+		args...).Scan(&total)
+	return total, err
+}
+
+// SyncJobDebits makes the job's debits match debits, which are keyed by
+// their LedgerEntryID. Debits already made are moved to the account,
+// amount, date and description given; those no longer wanted are removed.
+func (r *DepositRepository) SyncJobDebits(ctx context.Context, jobID int, debits []models.DepositMovement) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keep := make([]interface{}, 0, len(debits)+1)
+	keep = append(keep, jobID)
+	for _, m := range debits {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO deposit_movements (account_id, kind, amount, movement_date, description, job_id, ledger_entry_id)
+			VALUES (?, 'debit', ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				account_id = VALUES(account_id),
+				amount = VALUES(amount),
+				movement_date = VALUES(movement_date),
+				description = VALUES(description)
+		`, m.AccountID, m.Amount, m.MovementDate, m.Description, jobID, m.LedgerEntryID)
+		if err != nil {
+			return err
+		}
+		keep = append(keep, *m.LedgerEntryID)
+	}
+
+	query := `DELETE FROM deposit_movements WHERE job_id = ? AND ledger_entry_id IS NOT NULL`
+	if len(debits) > 0 {
+		query += ` AND ledger_entry_id NOT IN ` + placeholders(len(debits))
+	}
+	if _, err := tx.ExecContext(ctx, query, keep...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanDepositAccount(row interface{ Scan(...interface{}) error }) (*models.DepositAccount, error) {
+	var a models.DepositAccount
+	err := row.Scan(&a.ID, &a.Customer, &a.Received, &a.Debited, &a.Refunded, &a.CreatedBy, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.Balance = math.Round((a.Received-a.Debited-a.Refunded)*100) / 100
+	return &a, nil
+}
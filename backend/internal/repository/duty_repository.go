@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// DutyRepository handles exchange rates and saved duty calculations
+type DutyRepository struct {
+	db *sql.DB
+}
+
+// NewDutyRepository creates a new duty repository
+func NewDutyRepository(db *sql.DB) *DutyRepository {
+	return &DutyRepository{db: db}
+}
+
+// SetExchangeRate records the rate of a currency from a date, replacing
+// any rate already recorded for that date
+func (r *DutyRepository) SetExchangeRate(ctx context.Context, currency string, rate float64, effective time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO exchange_rates (currency, effective_date, rate)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE rate = VALUES(rate), updated_at = CURRENT_TIMESTAMP
+	`, currency, effective, rate)
+	return err
+}
+
+// ListExchangeRates lists the rate in force for each currency on a date
+func (r *DutyRepository) ListExchangeRates(ctx context.Context, on time.Time) ([]models.ExchangeRate, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT e.currency, e.rate, e.effective_date, e.updated_at
+		FROM exchange_rates e
+		JOIN (
+			SELECT currency, MAX(effective_date) AS effective_date
+			FROM exchange_rates
+			WHERE effective_date <= ?
+			GROUP BY currency
+		) latest ON latest.currency = e.currency AND latest.effective_date = e.effective_date
+		ORDER BY e.currency
+	`, on)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.ExchangeRate{}
+	for rows.Next() {
+		var rate models.ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.EffectiveDate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+// ExchangeRate returns the rate of a currency in force on a date
+func (r *DutyRepository) ExchangeRate(ctx context.Context, currency string, on time.Time) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	err := r.db.QueryRowContext(ctx, `
+		SELECT currency, rate, effective_date, updated_at
+		FROM exchange_rates
+		WHERE currency = ? AND effective_date <= ?
+		ORDER BY effective_date DESC
+		LIMIT 1
+	`, currency, on).Scan(&rate.Currency, &rate.Rate, &rate.EffectiveDate, &rate.UpdatedAt)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &rate, nil
+}
+
+// SaveCalculation stores a duty breakdown and sets its ID and timestamp
+func (r *DutyRepository) SaveCalculation(ctx context.Context, b *models.DutyBreakdown) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO duty_calculations (job_id, calculated_by, total_duty, breakdown)
+		VALUES (?, ?, ?, ?)
+	`, b.JobID, b.CalculatedBy, b.TotalDuty, data)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	b.ID = int(id)
+	b.CalculatedAt = &now
+	return nil
+}
+
+// ListCalculations lists a job's saved duty calculations, newest first
+func (r *DutyRepository) ListCalculations(ctx context.Context, jobID int) ([]models.DutyBreakdown, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT d.id, d.calculated_by, u.username, d.breakdown, d.created_at
+		FROM duty_calculations d
+		LEFT JOIN users u ON d.calculated_by = u.id
+		WHERE d.job_id = ?
+		ORDER BY d.created_at DESC, d.id DESC
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calculations := []models.DutyBreakdown{}
+	for rows.Next() {
+		b, err := scanDutyCalculation(rows)
+		if err != nil {
+			return nil, err
+		}
+		calculations = append(calculations, *b)
+	}
+	return calculations, rows.Err()
+}
+
+// scanDutyCalculation reads the columns selected by ListCalculations and
+// getLatestDutyCalculation into a breakdown
+func scanDutyCalculation(row interface{ Scan(...interface{}) error }) (*models.DutyBreakdown, error) {
+	var (
+		b          models.DutyBreakdown
+		id, userID int
+		username   sql.NullString
+		data       []byte
+		createdAt  time.Time
+	)
+	if err := row.Scan(&id, &userID, &username, &data, &createdAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	b.ID = id
+	b.CalculatedBy = userID
+	b.CalculatedByUser = username.String
+	b.CalculatedAt = &createdAt
+	return &b, nil
+}
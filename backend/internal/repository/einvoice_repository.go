@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"maydiv-crm/internal/models"
+)
+
+// EInvoiceRepository stores the GST e-invoices generated for job bills
+type EInvoiceRepository struct {
+	db *sql.DB
+}
+
+// NewEInvoiceRepository creates a new e-invoice repository
+func NewEInvoiceRepository(db *sql.DB) *EInvoiceRepository {
+	return &EInvoiceRepository{db: db}
+}
+
+// Get returns a job's e-invoice
+func (r *EInvoiceRepository) Get(ctx context.Context, jobID int) (*models.EInvoice, error) {
+	e, err := queryEInvoice(ctx, r.db, jobID)
+	return e, translate(err)
+}
+
+// SaveGenerated stores a newly generated e-invoice for a job, replacing
+// one that has not been registered yet
+func (r *EInvoiceRepository) SaveGenerated(ctx context.Context, jobID int, billNo string, payload json.RawMessage, userID int) error {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO e_invoices (job_id, bill_no, payload, generated_by)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			bill_no = IF(status = 'registered', bill_no, VALUES(bill_no)),
+			payload = IF(status = 'registered', payload, VALUES(payload)),
+			generated_by = IF(status = 'registered', generated_by, VALUES(generated_by))
+	`, jobID, billNo, []byte(payload), userID)
+	if err != nil {
+		return translate(err)
+	}
+	return requireRow(result)
+}
+
+// Register records the IRP's acknowledgement of a job's generated
+// e-invoice and notes it on the job's timeline
+func (r *EInvoiceRepository) Register(ctx context.Context, jobID int, e *models.EInvoice, userID int, message string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE e_invoices
+		SET status = 'registered', irn = ?, ack_no = ?, ack_date = ?,
+			signed_qr_code = ?, signed_invoice = ?, registered_by = ?
+		WHERE job_id = ? AND status = 'generated'
+	`, e.IRN, e.AckNo, e.AckDate, e.SignedQRCode, e.SignedInvoice, userID, jobID)
+	if err != nil {
+		return translate(err)
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	// A registered bill has been issued and can no longer change
+	_, err = tx.ExecContext(ctx, `
+		UPDATE stage4_data SET issued_at = COALESCE(issued_at, CURRENT_TIMESTAMP) WHERE job_id = ?
+	`, jobID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, jobID, userID, message)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LastBuyerPayload returns the most recent e-invoice payload, on a job
+// other than excludeJobID, billed to the buyer with the given GSTIN
+func (r *EInvoiceRepository) LastBuyerPayload(ctx context.Context, gstin string, excludeJobID int) (json.RawMessage, error) {
+	var payload []byte
+	err := r.db.QueryRowContext(ctx, `
+		SELECT payload FROM e_invoices
+		WHERE JSON_UNQUOTE(JSON_EXTRACT(payload, '$.BuyerDtls.Gstin')) = ? AND job_id <> ?
+		ORDER BY updated_at DESC, id DESC
+		LIMIT 1
+	`, gstin, excludeJobID).Scan(&payload)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return payload, nil
+}
+
+func queryEInvoice(ctx context.Context, db *sql.DB, jobID int) (*models.EInvoice, error) {
+	var e models.EInvoice
+	var payload []byte
+	err := db.QueryRowContext(ctx, `
+		SELECT id, job_id, bill_no, payload, status, irn, ack_no, ack_date,
+			   signed_qr_code, signed_invoice, generated_by, registered_by, created_at, updated_at
+		FROM e_invoices WHERE job_id = ?
+	`, jobID).Scan(&e.ID, &e.JobID, &e.BillNo, &payload, &e.Status, &e.IRN, &e.AckNo, &e.AckDate,
+		&e.SignedQRCode, &e.SignedInvoice, &e.GeneratedBy, &e.RegisteredBy, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.Payload = payload
+	return &e, nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDuplicateEntry is the MySQL error number for unique key violations
+const mysqlDuplicateEntry = 1062
+
+var (
+	// ErrNotFound is returned when the requested row does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrDuplicate is returned when an insert or update violates a unique key
+	ErrDuplicate = errors.New("duplicate entry")
+
+	// ErrNotOwner is returned when a user changes a row that belongs to someone else
+	ErrNotOwner = errors.New("not owner")
+
+	// ErrBillIssued is returned when a bill is saved as a draft but was issued
+	// since it was read
+	ErrBillIssued = errors.New("bill issued")
+
+	// ErrLastAdmin is returned when a change would leave an organization
+	// without an org admin
+	ErrLastAdmin = errors.New("last org admin")
+)
+
+// translate maps driver errors onto the repository errors above, keeping
+// the original error in the chain for logging
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return fmt.Errorf("%w: %w", ErrDuplicate, err)
+	}
+	return err
+}
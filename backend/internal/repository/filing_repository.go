@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"maydiv-crm/internal/models"
+)
+
+// FilingRepository handles filing rules and the filings required on jobs
+type FilingRepository struct {
+	db *sql.DB
+}
+
+// NewFilingRepository creates a new filing repository
+func NewFilingRepository(db *sql.DB) *FilingRepository {
+	return &FilingRepository{db: db}
+}
+
+const filingRuleColumns = `id, hsn_prefix, country, filing, description, documents, active, created_at, updated_at`
+
+// ListRules lists filing rules, most specific HSN prefix first
+func (r *FilingRepository) ListRules(ctx context.Context, activeOnly bool) ([]models.FilingRule, error) {
+	query := `SELECT ` + filingRuleColumns + ` FROM filing_rules`
+	if activeOnly {
+		query += ` WHERE active = TRUE`
+	}
+	query += ` ORDER BY LENGTH(hsn_prefix) DESC, hsn_prefix, country, filing`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.FilingRule{}
+	for rows.Next() {
+		rule, err := scanFilingRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetRule retrieves a filing rule by ID
+func (r *FilingRepository) GetRule(ctx context.Context, id int) (*models.FilingRule, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+filingRuleColumns+` FROM filing_rules WHERE id = ?`, id)
+	rule, err := scanFilingRule(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return rule, nil
+}
+
+// CreateRule inserts a filing rule and returns its ID
+func (r *FilingRepository) CreateRule(ctx context.Context, rule *models.FilingRule) (int, error) {
+	documents, err := json.Marshal(rule.Documents)
+	if err != nil {
+		return 0, err
+	}
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO filing_rules (hsn_prefix, country, filing, description, documents, active)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, rule.HSNPrefix, rule.Country, rule.Filing, rule.Description, documents, rule.Active)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// UpdateRule replaces a filing rule
+func (r *FilingRepository) UpdateRule(ctx context.Context, rule *models.FilingRule) error {
+	documents, err := json.Marshal(rule.Documents)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE filing_rules
+		SET hsn_prefix = ?, country = ?, filing = ?, description = ?, documents = ?, active = ?
+		WHERE id = ?
+	`, rule.HSNPrefix, rule.Country, rule.Filing, rule.Description, documents, rule.Active, rule.ID)
+	if err != nil {
+		return translate(err)
+	}
+	return requireRow(result)
+}
+
+// DeleteRule removes a filing rule. Job filings it produced are kept.
+func (r *FilingRepository) DeleteRule(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM filing_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// JobGoods returns the HSN code and country of shipment that filing rules
+// are evaluated against. The country is the code of the country master the
+// job is linked to, or its free text when it is not linked.
+func (r *FilingRepository) JobGoods(ctx context.Context, jobID int) (hsnCode, country string, err error) {
+	var hsn, origin sql.NullString
+	err = r.db.QueryRowContext(ctx, `
+		SELECT s2.hsn_code, COALESCE(c.code, s1.country_of_shipment)
+		FROM pipeline_jobs pj
+		LEFT JOIN stage1_data s1 ON s1.job_id = pj.id
+		LEFT JOIN stage2_data s2 ON s2.job_id = pj.id
+		LEFT JOIN master_data c ON c.id = s1.country_of_shipment_id
+		WHERE pj.id = ?
+	`, jobID).Scan(&hsn, &origin)
+	if err != nil {
+		return "", "", translate(err)
+	}
+	return hsn.String, origin.String, nil
+}
+
+// SyncJobFilings makes the job's filings match required. New filings start
+// pending and existing ones keep their progress; filings no longer required
+// are removed unless work on them has started.
+func (r *FilingRepository) SyncJobFilings(ctx context.Context, jobID int, required []models.JobFiling) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names := make([]interface{}, 0, len(required)+2)
+	names = append(names, jobID, models.FilingPending)
+	for _, f := range required {
+		documents, err := json.Marshal(f.Documents)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO job_filings (job_id, rule_id, filing, description, documents, status)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				rule_id = VALUES(rule_id),
+				description = VALUES(description),
+				documents = VALUES(documents)
+		`, jobID, f.RuleID, f.Filing, f.Description, documents, models.FilingPending)
+		if err != nil {
+			return err
+		}
+		names = append(names, f.Filing)
+	}
+
+	query := `DELETE FROM job_filings WHERE job_id = ? AND status = ?`
+	if len(required) > 0 {
+		query += ` AND filing NOT IN (?` + strings.Repeat(", ?", len(required)-1) + `)`
+	}
+	if _, err := tx.ExecContext(ctx, query, names...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// JobFilings lists the filings required on a job
+func (r *FilingRepository) JobFilings(ctx context.Context, jobID int) ([]models.JobFiling, error) {
+	return queryJobFilings(ctx, r.db, jobID)
+}
+
+// UpdateJobFiling records progress on one of a job's filings
+func (r *FilingRepository) UpdateJobFiling(ctx context.Context, jobID, filingID int, req *models.JobFilingUpdateRequest, userID int) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE job_filings
+		SET status = ?, reference_no = ?, remarks = ?, updated_by = ?
+		WHERE id = ? AND job_id = ?
+	`, req.Status, nullString(req.ReferenceNo), nullString(req.Remarks), userID, filingID, jobID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func queryJobFilings(ctx context.Context, db *sql.DB, jobID int) ([]models.JobFiling, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, job_id, rule_id, filing, description, documents, status,
+			   reference_no, remarks, updated_by, created_at, updated_at
+		FROM job_filings
+		WHERE job_id = ?
+		ORDER BY filing
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filings := []models.JobFiling{}
+	for rows.Next() {
+		var f models.JobFiling
+		var documents []byte
+		err := rows.Scan(&f.ID, &f.JobID, &f.RuleID, &f.Filing, &f.Description, &documents, &f.Status,
+			&f.ReferenceNo, &f.Remarks, &f.UpdatedBy, &f.CreatedAt, &f.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(documents, &f.Documents); err != nil {
+			return nil, err
+		}
+		filings = append(filings, f)
+	}
+	return filings, rows.Err()
+}
+
+func scanFilingRule(row interface{ Scan(...interface{}) error }) (*models.FilingRule, error) {
+	var rule models.FilingRule
+	var documents []byte
+	err := row.Scan(&rule.ID, &rule.HSNPrefix, &rule.Country, &rule.Filing, &rule.Description,
+		&documents, &rule.Active, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(documents, &rule.Documents); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// requireRow returns ErrNotFound when an UPDATE or DELETE matched no row
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
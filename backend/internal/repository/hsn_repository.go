@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"maydiv-crm/internal/models"
+)
+
+// HSNRepository handles the HSN tariff master
+type HSNRepository struct {
+	db *sql.DB
+}
+
+// NewHSNRepository creates a new HSN repository
+func NewHSNRepository(db *sql.DB) *HSNRepository {
+	return &HSNRepository{db: db}
+}
+
+const hsnColumns = `code, description, unit, bcd_rate, sws_rate, igst_rate, cess_rate, updated_at`
+
+// Upsert inserts or replaces tariff entries in a single transaction
+func (r *HSNRepository) Upsert(ctx context.Context, codes []models.HSNCode) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO hsn_codes (code, description, unit, bcd_rate, sws_rate, igst_rate, cess_rate)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			description = VALUES(description),
+			unit = VALUES(unit),
+			bcd_rate = VALUES(bcd_rate),
+			sws_rate = VALUES(sws_rate),
+			igst_rate = VALUES(igst_rate),
+			cess_rate = VALUES(cess_rate),
+			updated_at = CURRENT_TIMESTAMP
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range codes {
+		if _, err := stmt.ExecContext(ctx, c.Code, c.Description, c.Unit, c.BCDRate, c.SWSRate, c.IGSTRate, c.CessRate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetByCode retrieves a single tariff entry
+func (r *HSNRepository) GetByCode(ctx context.Context, code string) (*models.HSNCode, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+hsnColumns+` FROM hsn_codes WHERE code = ?`, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := scanHSNCodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, ErrNotFound
+	}
+	return &codes[0], nil
+}
+
+// GetByCodes retrieves the tariff entries that exist among codes, keyed by code
+func (r *HSNRepository) GetByCodes(ctx context.Context, codes []string) (map[string]models.HSNCode, error) {
+	found := make(map[string]models.HSNCode)
+	if len(codes) == 0 {
+		return found, nil
+	}
+
+	args := make([]interface{}, len(codes))
+	for i, c := range codes {
+		args[i] = c
+	}
+	query := `SELECT ` + hsnColumns + ` FROM hsn_codes WHERE code IN (?` + strings.Repeat(", ?", len(codes)-1) + `)`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	list, err := scanHSNCodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		found[c.Code] = c
+	}
+	return found, nil
+}
+
+// SearchByPrefix lists codes starting with prefix, headings first
+func (r *HSNRepository) SearchByPrefix(ctx context.Context, prefix string, limit int) ([]models.HSNCode, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+hsnColumns+` FROM hsn_codes
+		WHERE code LIKE ?
+		ORDER BY LENGTH(code), code
+		LIMIT ?
+	`, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanHSNCodes(rows)
+}
+
+// SearchByKeywords lists codes whose description contains every keyword
+// when matchAll is set, or any of them otherwise
+func (r *HSNRepository) SearchByKeywords(ctx context.Context, keywords []string, matchAll bool, limit int) ([]models.HSNCode, error) {
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+
+	conds := make([]string, len(keywords))
+	args := make([]interface{}, 0, len(keywords)+1)
+	for i, k := range keywords {
+		conds[i] = "description LIKE ?"
+		args = append(args, "%"+escapeLike(k)+"%")
+	}
+	join := " OR "
+	if matchAll {
+		join = " AND "
+	}
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+hsnColumns+` FROM hsn_codes
+		WHERE `+strings.Join(conds, join)+`
+		ORDER BY LENGTH(code) DESC, code
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanHSNCodes(rows)
+}
+
+// CommodityHistory lists the commodity and HSN code of past jobs that have
+// both, leaving out excludeJobID
+func (r *HSNRepository) CommodityHistory(ctx context.Context, excludeJobID int) ([]models.CommodityHSN, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT s1.job_id, s1.commodity, s2.hsn_code
+		FROM stage1_data s1
+		JOIN stage2_data s2 ON s2.job_id = s1.job_id
+		WHERE s1.commodity IS NOT NULL AND s1.commodity <> ''
+		  AND s2.hsn_code IS NOT NULL AND s2.hsn_code <> ''
+		  AND s1.job_id <> ?
+	`, excludeJobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.CommodityHSN
+	for rows.Next() {
+		var h models.CommodityHSN
+		if err := rows.Scan(&h.JobID, &h.Commodity, &h.HSNCode); err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+func scanHSNCodes(rows *sql.Rows) ([]models.HSNCode, error) {
+	defer rows.Close()
+
+	var codes []models.HSNCode
+	for rows.Next() {
+		var c models.HSNCode
+		if err := rows.Scan(&c.Code, &c.Description, &c.Unit, &c.BCDRate, &c.SWSRate, &c.IGSTRate, &c.CessRate, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
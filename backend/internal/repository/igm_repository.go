@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/tradeid"
+)
+
+// IGMRepository finds and updates the jobs named in IGM manifests
+type IGMRepository struct {
+	db *sql.DB
+}
+
+// NewIGMRepository creates a new IGM repository
+func NewIGMRepository(db *sql.DB) *IGMRepository {
+	return &IGMRepository{db: db}
+}
+
+// blColumn compares a bill of lading column the way tradeid.Normalize
+// writes numbers: upper case without spaces, dashes or dots
+func blColumn(column string) string {
+	return `UPPER(REPLACE(REPLACE(REPLACE(` + column + `, ' ', ''), '-', ''), '.', ''))`
+}
+
+// ManifestCandidates returns the jobs, other than cancelled ones, whose
+// HBL or MBL is one of bls or that carry one of containers. bls must be
+// normalized with tradeid.Normalize and containers with iso6346.Normalize.
+func (r *IGMRepository) ManifestCandidates(ctx context.Context, bls, containers []string) ([]models.ManifestJob, error) {
+	if len(bls) == 0 && len(containers) == 0 {
+		return nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	if len(bls) > 0 {
+		in := placeholders(len(bls))
+		conditions = append(conditions, blColumn("s.hbl_no")+` IN `+in, blColumn("s.mbl_no")+` IN `+in)
+		for i := 0; i < 2; i++ {
+			for _, bl := range bls {
+				args = append(args, bl)
+			}
+		}
+	}
+	if len(containers) > 0 {
+		in := placeholders(len(containers))
+		conditions = append(conditions, `s.job_id IN (SELECT job_id FROM job_containers WHERE container_no IN `+in+`)`)
+		for _, c := range containers {
+			args = append(args, c)
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT pj.id, pj.job_no, COALESCE(s.hbl_no, ''), COALESCE(s.mbl_no, ''),
+			   COALESCE(s.gateway_igm, ''), s.gateway_igm_date, COALESCE(s.local_igm, ''), s.local_igm_date,
+			   COALESCE(s.packages, 0), COALESCE(s.weight, 0)
+		FROM stage1_data s
+		JOIN pipeline_jobs pj ON pj.id = s.job_id
+		WHERE pj.status <> 'cancelled' AND (`+strings.Join(conditions, " OR ")+`)
+		ORDER BY pj.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.ManifestJob
+	index := make(map[int]int)
+	for rows.Next() {
+		var job models.ManifestJob
+		if err := rows.Scan(&job.JobID, &job.JobNo, &job.HBLNo, &job.MBLNo,
+			&job.GatewayIGM, &job.GatewayIGMDate, &job.LocalIGM, &job.LocalIGMDate,
+			&job.Packages, &job.Weight); err != nil {
+			return nil, err
+		}
+		job.HBLNo = tradeid.Normalize(job.HBLNo)
+		job.MBLNo = tradeid.Normalize(job.MBLNo)
+		index[job.JobID] = len(jobs)
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return jobs, nil
+	}
+
+	// Every job's containers, so container matches can be checked against
+	// the whole list
+	ids := make([]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.JobID)
+	}
+	crows, err := r.db.QueryContext(ctx, `
+		SELECT job_id, container_no FROM job_containers
+		WHERE container_no IS NOT NULL AND container_no <> '' AND job_id IN `+placeholders(len(ids))+`
+		ORDER BY id`,
+		ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var jobID int
+		var container string
+		if err := crows.Scan(&jobID, &container); err != nil {
+			return nil, err
+		}
+		job := &jobs[index[jobID]]
+		if !slices.Contains(job.Containers, container) {
+			job.Containers = append(job.Containers, container)
+		}
+	}
+	return jobs, crows.Err()
+}
+
+// ConsigneeNames lists every consignee name recorded on a job
+func (r *IGMRepository) ConsigneeNames(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT DISTINCT TRIM(consignee) FROM stage1_data
+		WHERE consignee IS NOT NULL AND TRIM(consignee) <> ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// UpdateManifestFields saves the IGM numbers, dates, packages and weight
+// of job and records message on the job's timeline
+func (r *IGMRepository) UpdateManifestFields(ctx context.Context, job *models.ManifestJob, userID int, message string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE stage1_data
+		SET gateway_igm = ?, gateway_igm_date = ?, local_igm = ?, local_igm_date = ?, packages = ?, weight = ?
+		WHERE job_id = ?
+	`, nullString(job.GatewayIGM), job.GatewayIGMDate, nullString(job.LocalIGM), job.LocalIGMDate,
+		job.Packages, job.Weight, job.JobID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage1', 'data_update', ?)
+	`, job.JobID, userID, message)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func placeholders(n int) string {
+	return "(?" + strings.Repeat(", ?", n-1) + ")"
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// LedgerRepository handles the costs and revenue booked against jobs
+type LedgerRepository struct {
+	db *sql.DB
+}
+
+// NewLedgerRepository creates a new ledger repository
+func NewLedgerRepository(db *sql.DB) *LedgerRepository {
+	return &LedgerRepository{db: db}
+}
+
+const ledgerColumns = `id, job_id, kind, category, description, amount, paid_by, reimbursable,
+	entry_date, source, created_by, created_at, updated_at`
+
+// JobEntries lists a job's ledger entries, revenue first
+func (r *LedgerRepository) JobEntries(ctx context.Context, jobID int) ([]models.LedgerEntry, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+ledgerColumns+`
+		FROM ledger_entries
+		WHERE job_id = ?
+		ORDER BY kind DESC, source IS NULL, entry_date, id
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.LedgerEntry{}
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// GetEntry retrieves one of a job's ledger entries
+func (r *LedgerRepository) GetEntry(ctx context.Context, jobID, id int) (*models.LedgerEntry, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+ledgerColumns+` FROM ledger_entries WHERE id = ? AND job_id = ?`, id, jobID)
+	e, err := scanLedgerEntry(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return e, nil
+}
+
+// CreateEntry inserts a ledger entry and returns its ID
+func (r *LedgerRepository) CreateEntry(ctx context.Context, e *models.LedgerEntry) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO ledger_entries (job_id, kind, category, description, amount, paid_by, reimbursable, entry_date, source, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.JobID, e.Kind, e.Category, e.Description, e.Amount, e.PaidBy, e.Reimbursable, e.EntryDate, e.Source, e.CreatedBy)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// UpdateEntry replaces a ledger entry
+func (r *LedgerRepository) UpdateEntry(ctx context.Context, e *models.LedgerEntry) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE ledger_entries
+		SET kind = ?, category = ?, description = ?, amount = ?, paid_by = ?, reimbursable = ?, entry_date = ?
+		WHERE id = ? AND job_id = ?
+	`, e.Kind, e.Category, e.Description, e.Amount, e.PaidBy, e.Reimbursable, e.EntryDate, e.ID, e.JobID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// DeleteEntry removes a ledger entry entered by hand
+func (r *LedgerRepository) DeleteEntry(ctx context.Context, jobID, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM ledger_entries WHERE id = ? AND job_id = ? AND source IS NULL`, id, jobID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// SyncJobEntries makes the job's entries kept from stage columns match
+// derived, which are keyed by Source. Amounts, descriptions and dates are
+// updated; the payer only when the derived entry names one, and whether an
+// entry is reimbursable is left as it was set. Entries whose column is now
+// empty are removed.
+func (r *LedgerRepository) SyncJobEntries(ctx context.Context, jobID int, derived []models.LedgerEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sources := make([]interface{}, 0, len(derived)+1)
+	sources = append(sources, jobID)
+	for _, e := range derived {
+		paidBy := nullString(e.PaidBy)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO ledger_entries (job_id, kind, category, description, amount, paid_by, reimbursable, entry_date, source)
+			VALUES (?, ?, ?, ?, ?, COALESCE(?, 'us'), ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				kind = VALUES(kind),
+				category = VALUES(category),
+				description = VALUES(description),
+				amount = VALUES(amount),
+				paid_by = COALESCE(?, paid_by),
+				entry_date = VALUES(entry_date)
+		`, jobID, e.Kind, e.Category, e.Description, e.Amount, paidBy, e.Reimbursable, e.EntryDate, e.Source, paidBy)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, *e.Source)
+	}
+
+	query := `DELETE FROM ledger_entries WHERE job_id = ? AND source IS NOT NULL`
+	if len(derived) > 0 {
+		query += ` AND source NOT IN ` + placeholders(len(derived))
+	}
+	if _, err := tx.ExecContext(ctx, query, sources...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// JobIDs lists every job, for backfilling the ledger
+func (r *LedgerRepository) JobIDs(ctx context.Context) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id FROM pipeline_jobs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Profitability totals the ledger of each job that is not cancelled and has
+// entries. A job falls in the month of its bill date, else its job date,
+// and between from and to when they are given. Its customer is the
+// consignee.
+func (r *LedgerRepository) Profitability(ctx context.Context, from, to *time.Time) ([]models.JobProfitability, error) {
+	var where []string
+	var args []interface{}
+	if from != nil {
+		where = append(where, `basis >= ?`)
+		args = append(args, *from)
+	}
+	if to != nil {
+		where = append(where, `basis <= ?`)
+		args = append(args, *to)
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = `WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT job_id, job_no, customer, DATE_FORMAT(basis, '%Y-%m'),
+			   revenue, costs, recoverable, pass_through
+		FROM (
+			SELECT pj.id AS job_id, pj.job_no,
+				   COALESCE(s1.consignee, '') AS customer,
+				   COALESCE(s4.bill_date, s1.job_date, DATE(pj.created_at)) AS basis,
+				   SUM(CASE WHEN le.kind = 'revenue' THEN le.amount ELSE 0 END) AS revenue,
+				   SUM(CASE WHEN le.kind = 'cost' AND le.paid_by = 'us' AND NOT le.reimbursable THEN le.amount ELSE 0 END) AS costs,
+				   SUM(CASE WHEN le.kind = 'cost' AND le.paid_by = 'us' AND le.reimbursable THEN le.amount ELSE 0 END) AS recoverable,
+				   SUM(CASE WHEN le.kind = 'cost' AND le.paid_by <> 'us' THEN le.amount ELSE 0 END) AS pass_through
+			FROM pipeline_jobs pj
+			JOIN ledger_entries le ON le.job_id = pj.id
+			LEFT JOIN stage1_data s1 ON s1.job_id = pj.id
+			LEFT JOIN stage4_data s4 ON s4.job_id = pj.id
+			WHERE pj.status <> 'cancelled'
+			GROUP BY pj.id, pj.job_no, s1.consignee, s4.bill_date, s1.job_date, pj.created_at
+		) jobs
+		`+filter+`
+		ORDER BY basis, job_no
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.JobProfitability
+	for rows.Next() {
+		var j models.JobProfitability
+		err := rows.Scan(&j.JobID, &j.JobNo, &j.Customer, &j.Month,
+			&j.Revenue, &j.Costs, &j.Recoverable, &j.PassThrough)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func scanLedgerEntry(row interface{ Scan(...interface{}) error }) (*models.LedgerEntry, error) {
+	var e models.LedgerEntry
+	err := row.Scan(&e.ID, &e.JobID, &e.Kind, &e.Category, &e.Description, &e.Amount, &e.PaidBy, &e.Reimbursable,
+		&e.EntryDate, &e.Source, &e.CreatedBy, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
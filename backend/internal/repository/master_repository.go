@@ -0,0 +1,384 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+
+	"maydiv-crm/internal/models"
+)
+
+// MasterRepository handles the master data that free-text job fields are
+// linked to, and their aliases
+type MasterRepository struct {
+	db *sql.DB
+}
+
+// NewMasterRepository creates a new master data repository
+func NewMasterRepository(db *sql.DB) *MasterRepository {
+	return &MasterRepository{db: db}
+}
+
+// MasterField is a free-text job column kept with a foreign key, named by
+// Column with "_id" added, to the master of Kind it matches
+type MasterField struct {
+	Stage  string
+	Column string
+	Kind   string
+}
+
+// Name names the field as validation errors and reports do
+func (f MasterField) Name() string {
+	return f.Stage + "." + f.Column
+}
+
+func (f MasterField) table() string {
+	return f.Stage + "_data"
+}
+
+// MasterFields are the job fields linked to master data
+var MasterFields = []MasterField{
+	{"stage1", "port_of_discharge", models.MasterPort},
+	{"stage1", "final_place_of_delivery", models.MasterPort},
+	{"stage1", "port_of_loading", models.MasterPort},
+	{"stage1", "country_of_shipment", models.MasterCountry},
+	{"stage1", "shipping_line", models.MasterShippingLine},
+	{"stage1", "forwarder", models.MasterForwarder},
+	{"stage3", "custodian", models.MasterCustodian},
+}
+
+// MasterKey reduces a name or code to what masters are matched on: its
+// letters and digits, upper case
+func MasterKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+const masterColumns = `id, kind, code, name, country, active, created_at, updated_at`
+
+// List lists the masters of a kind by name with their aliases. With a
+// query it lists at most limit active masters whose code, name or an alias
+// starts with it, or whose name contains it, exact matches first.
+func (r *MasterRepository) List(ctx context.Context, kind, query string, limit int) ([]models.Master, error) {
+	sqlQuery := `SELECT ` + masterColumns + ` FROM master_data m WHERE m.kind = ?`
+	args := []interface{}{kind}
+	if key := MasterKey(query); key != "" {
+		prefix := key + "%"
+		sqlQuery += ` AND m.active AND (m.code_key LIKE ? OR m.name_key LIKE ? OR m.name LIKE ?
+				OR EXISTS (SELECT 1 FROM master_aliases a WHERE a.master_id = m.id AND a.alias_key LIKE ?))
+			ORDER BY (m.code_key = ? OR m.name_key = ?
+				OR EXISTS (SELECT 1 FROM master_aliases a WHERE a.master_id = m.id AND a.alias_key = ?)) DESC, m.name
+			LIMIT ?`
+		args = append(args, prefix, prefix, "%"+strings.TrimSpace(query)+"%", prefix, key, key, key, limit)
+	} else {
+		sqlQuery += ` ORDER BY m.name`
+	}
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	masters := []models.Master{}
+	index := map[int]int{}
+	for rows.Next() {
+		m, err := scanMaster(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[m.ID] = len(masters)
+		masters = append(masters, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(masters) == 0 {
+		return masters, nil
+	}
+
+	ids := make([]interface{}, 0, len(masters))
+	for _, m := range masters {
+		ids = append(ids, m.ID)
+	}
+	aliases, err := r.db.QueryContext(ctx, `SELECT master_id, alias FROM master_aliases WHERE master_id IN `+placeholders(len(ids))+` ORDER BY alias`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer aliases.Close()
+	for aliases.Next() {
+		var masterID int
+		var alias string
+		if err := aliases.Scan(&masterID, &alias); err != nil {
+			return nil, err
+		}
+		if i, ok := index[masterID]; ok {
+			masters[i].Aliases = append(masters[i].Aliases, alias)
+		}
+	}
+	return masters, aliases.Err()
+}
+
+// Get retrieves a master of a kind with its aliases
+func (r *MasterRepository) Get(ctx context.Context, kind string, id int) (*models.Master, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+masterColumns+` FROM master_data m WHERE id = ? AND kind = ?`, id, kind)
+	m, err := scanMaster(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT alias FROM master_aliases WHERE master_id = ? ORDER BY alias`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		m.Aliases = append(m.Aliases, alias)
+	}
+	return m, rows.Err()
+}
+
+// KeyOwners maps each of keys that is the code, name or an alias of a
+// master of kind to that master's ID
+func (r *MasterRepository) KeyOwners(ctx context.Context, kind string, keys []string) (map[string]int, error) {
+	owners := make(map[string]int)
+	if len(keys) == 0 {
+		return owners, nil
+	}
+	in := placeholders(len(keys))
+	args := []interface{}{kind}
+	for i := 0; i < 3; i++ {
+		for _, k := range keys {
+			args = append(args, k)
+		}
+		if i < 2 {
+			args = append(args, kind)
+		}
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT code_key, id FROM master_data WHERE kind = ? AND code_key IN `+in+`
+		UNION ALL
+		SELECT name_key, id FROM master_data WHERE kind = ? AND name_key IN `+in+`
+		UNION ALL
+		SELECT alias_key, master_id FROM master_aliases WHERE kind = ? AND alias_key IN `+in,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var id int
+		if err := rows.Scan(&key, &id); err != nil {
+			return nil, err
+		}
+		owners[key] = id
+	}
+	return owners, rows.Err()
+}
+
+// Create inserts a master with its aliases and returns its ID
+func (r *MasterRepository) Create(ctx context.Context, m *models.Master) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO master_data (kind, code, name, country, code_key, name_key, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.Kind, m.Code, m.Name, m.Country, masterCodeKey(m.Code), MasterKey(m.Name), m.Active)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertMasterAliases(ctx, tx, int(id), m.Kind, m.Aliases); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// Update replaces a master and its aliases
+func (r *MasterRepository) Update(ctx context.Context, m *models.Master) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM master_data WHERE id = ? AND kind = ? FOR UPDATE`, m.ID, m.Kind).Scan(&exists)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE master_data SET code = ?, name = ?, country = ?, code_key = ?, name_key = ?, active = ?
+		WHERE id = ?
+	`, m.Code, m.Name, m.Country, masterCodeKey(m.Code), MasterKey(m.Name), m.Active, m.ID)
+	if err != nil {
+		return translate(err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM master_aliases WHERE master_id = ?`, m.ID); err != nil {
+		return err
+	}
+	if err := insertMasterAliases(ctx, tx, m.ID, m.Kind, m.Aliases); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a master and its aliases. Jobs linked to it keep their
+// free text and lose the link.
+func (r *MasterRepository) Delete(ctx context.Context, kind string, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM master_data WHERE id = ? AND kind = ?`, id, kind)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// UnlinkedValues lists the distinct free-text values of field on jobs not
+// linked to a master, with the number of jobs carrying each
+func (r *MasterRepository) UnlinkedValues(ctx context.Context, field MasterField) ([]models.MasterReviewRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT TRIM(`+field.Column+`), COUNT(*) FROM `+field.table()+`
+		WHERE `+field.Column+`_id IS NULL AND TRIM(COALESCE(`+field.Column+`, '')) <> ''
+		GROUP BY TRIM(`+field.Column+`)
+		ORDER BY COUNT(*) DESC, TRIM(`+field.Column+`)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []models.MasterReviewRow
+	for rows.Next() {
+		v := models.MasterReviewRow{Field: field.Name()}
+		if err := rows.Scan(&v.Value, &v.Jobs); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Resolve returns the ID of the active master of kind that value matches,
+// or 0 if none does
+func (r *MasterRepository) Resolve(ctx context.Context, kind, value string) (int, error) {
+	id, err := resolveMaster(ctx, r.db, kind, value)
+	if id == nil {
+		return 0, err
+	}
+	return id.(int), err
+}
+
+// Code returns the code of the active master of kind that value matches,
+// or "" if none does
+func (r *MasterRepository) Code(ctx context.Context, kind, value string) (string, error) {
+	id, err := resolveMaster(ctx, r.db, kind, value)
+	if id == nil {
+		return "", err
+	}
+	var code sql.NullString
+	err = r.db.QueryRowContext(ctx, `SELECT code FROM master_data WHERE id = ?`, id).Scan(&code)
+	return code.String, err
+}
+
+// LinkValue links the jobs whose field holds value, and are not linked
+// yet, to a master. It returns the number of jobs linked.
+func (r *MasterRepository) LinkValue(ctx context.Context, field MasterField, value string, masterID int) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE `+field.table()+` SET `+field.Column+`_id = ?
+		WHERE `+field.Column+`_id IS NULL AND TRIM(`+field.Column+`) = ?
+	`, masterID, value)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// resolveMaster returns the ID of the active master of kind that value
+// matches, or nil if none does, ready to be stored in a foreign key
+func resolveMaster(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, kind, value string) (interface{}, error) {
+	key := MasterKey(value)
+	if key == "" {
+		return nil, nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM master_data WHERE kind = ? AND active AND (code_key = ? OR name_key = ?)
+		UNION ALL
+		SELECT m.id FROM master_aliases a JOIN master_data m ON m.id = a.master_id
+		WHERE a.kind = ? AND a.alias_key = ? AND m.active
+		LIMIT 1
+	`, kind, key, key, kind, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// resolveMasters resolves the values of a stage's master data fields, keyed
+// by column, to the IDs to store in their foreign keys
+func resolveMasters(ctx context.Context, tx *sql.Tx, stage string, values map[string]string) (map[string]interface{}, error) {
+	ids := make(map[string]interface{})
+	for _, f := range MasterFields {
+		if f.Stage != stage {
+			continue
+		}
+		id, err := resolveMaster(ctx, tx, f.Kind, values[f.Column])
+		if err != nil {
+			return nil, err
+		}
+		ids[f.Column] = id
+	}
+	return ids, nil
+}
+
+func insertMasterAliases(ctx context.Context, tx *sql.Tx, masterID int, kind string, aliases []string) error {
+	for _, alias := range aliases {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO master_aliases (master_id, kind, alias, alias_key) VALUES (?, ?, ?, ?)
+		`, masterID, kind, alias, MasterKey(alias))
+		if err != nil {
+			return translate(err)
+		}
+	}
+	return nil
+}
+
+func masterCodeKey(code *string) interface{} {
+	if code == nil {
+		return nil
+	}
+	return nullString(MasterKey(*code))
+}
+
+func scanMaster(row interface{ Scan(...interface{}) error }) (*models.Master, error) {
+	var m models.Master
+	err := row.Scan(&m.ID, &m.Kind, &m.Code, &m.Name, &m.Country, &m.Active, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.Aliases = []string{}
+	return &m, nil
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+// OrganizationRepository handles the customer users of a party and the
+// invitations to join it
+type OrganizationRepository struct {
+	db *sql.DB
+}
+
+// NewOrganizationRepository creates a new organization repository
+func NewOrganizationRepository(db *sql.DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+// Get returns an organization with its members and pending invitations
+func (r *OrganizationRepository) Get(ctx context.Context, partyID int) (*models.Organization, error) {
+	org := &models.Organization{ID: partyID}
+	err := r.db.QueryRowContext(ctx, `SELECT name FROM parties WHERE id = ?`, partyID).Scan(&org.Name)
+	if err != nil {
+		return nil, translate(err)
+	}
+	if org.Members, err = r.Members(ctx, partyID); err != nil {
+		return nil, err
+	}
+	if org.Invitations, err = r.Invitations(ctx, partyID); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// Members lists the users of an organization, org admins first
+func (r *OrganizationRepository) Members(ctx context.Context, partyID int) ([]models.OrgMember, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.designation, pm.org_admin, pm.stages, pm.created_at
+		FROM party_members pm
+		JOIN users u ON u.id = pm.user_id
+		WHERE pm.party_id = ?
+		ORDER BY pm.org_admin DESC, u.username
+	`, partyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.OrgMember{}
+	for rows.Next() {
+		var m models.OrgMember
+		var stages sql.NullString
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Designation, &m.OrgAdmin, &stages, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Stages = splitStages(stages)
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetMember makes a user a member of an organization with the given
+// rights, moving them from any other organization. It returns ErrLastAdmin
+// when the user is the last org admin of the organization they would stop
+// administering.
+func (r *OrganizationRepository) SetMember(ctx context.Context, partyID, userID int, orgAdmin bool, stages []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int
+	var admin bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT party_id, org_admin FROM party_members WHERE user_id = ? FOR UPDATE
+	`, userID).Scan(&current, &admin)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if admin && (current != partyID || !orgAdmin) {
+		if err := keepAdmin(ctx, tx, current); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO party_members (user_id, party_id, org_admin, stages) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE party_id = VALUES(party_id), org_admin = VALUES(org_admin), stages = VALUES(stages)
+	`, userID, partyID, orgAdmin, nullString(strings.Join(stages, ",")))
+	if err != nil {
+		return translate(err)
+	}
+	return tx.Commit()
+}
+
+// RemoveMember removes a user from an organization. The user keeps their
+// account but no longer sees its jobs. It returns ErrLastAdmin when the
+// user is the organization's last org admin.
+func (r *OrganizationRepository) RemoveMember(ctx context.Context, partyID, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var admin bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT org_admin FROM party_members WHERE party_id = ? AND user_id = ? FOR UPDATE
+	`, partyID, userID).Scan(&admin)
+	if err != nil {
+		return translate(err)
+	}
+	if admin {
+		if err := keepAdmin(ctx, tx, partyID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM party_members WHERE party_id = ? AND user_id = ?`, partyID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// keepAdmin locks the org admins of an organization and returns
+// ErrLastAdmin unless there are others besides the one about to lose the
+// right. Concurrent changes to the same organization wait on the lock, so
+// they cannot each leave the other as the last admin and demote both.
+func keepAdmin(ctx context.Context, tx *sql.Tx, partyID int) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT user_id FROM party_members WHERE party_id = ? AND org_admin FOR UPDATE
+	`, partyID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Invitations lists the invitations to an organization that were neither
+// accepted nor have expired, newest first
+func (r *OrganizationRepository) Invitations(ctx context.Context, partyID int) ([]models.OrgInvitation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT i.id, i.party_id, i.email, i.org_admin, i.stages, u.username, i.expires_at, i.created_at
+		FROM org_invitations i
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.party_id = ? AND i.accepted_at IS NULL AND i.expires_at > ?
+		ORDER BY i.created_at DESC, i.id DESC
+	`, partyID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.OrgInvitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+// CreateInvitation records an invitation known by the hash of its token
+func (r *OrganizationRepository) CreateInvitation(ctx context.Context, inv *models.OrgInvitation, tokenHash string, invitedBy int) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO org_invitations (party_id, email, token_hash, org_admin, stages, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, inv.PartyID, inv.Email, tokenHash, inv.OrgAdmin, nullString(strings.Join(inv.Stages, ",")), invitedBy, inv.ExpiresAt)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// DeleteInvitation revokes an organization's pending invitation
+func (r *OrganizationRepository) DeleteInvitation(ctx context.Context, partyID, id int) error {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM org_invitations WHERE id = ? AND party_id = ? AND accepted_at IS NULL
+	`, id, partyID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// AcceptInvitation creates the customer user accepting the pending
+// invitation with the given token hash and adds them to the organization,
+// all or nothing. check is called with the invitation while it is locked,
+// and nothing is saved if it returns an error. It returns ErrNotFound when
+// the invitation was accepted or revoked, and ErrDuplicate when the
+// username is taken.
+func (r *OrganizationRepository) AcceptInvitation(ctx context.Context, tokenHash string, user *models.UserCreate, check func(inv *models.OrgInvitation) error) (*models.OrgInvitation, int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvitation(tx.QueryRowContext(ctx, `
+		SELECT i.id, i.party_id, i.email, i.org_admin, i.stages, u.username, i.expires_at, i.created_at
+		FROM org_invitations i
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.token_hash = ? AND i.accepted_at IS NULL
+		FOR UPDATE
+	`, tokenHash))
+	if err != nil {
+		return nil, 0, translate(err)
+	}
+	if err := check(inv); err != nil {
+		return nil, 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO users (username, password_hash, designation, is_admin, role) VALUES (?, ?, ?, FALSE, 'customer')
+	`, user.Username, user.PasswordHash, user.Designation)
+	if err != nil {
+		return nil, 0, translate(err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO party_members (user_id, party_id, org_admin, stages) VALUES (?, ?, ?, ?)
+	`, userID, inv.PartyID, inv.OrgAdmin, nullString(strings.Join(inv.Stages, ",")))
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE org_invitations SET accepted_by = ?, accepted_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, userID, inv.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return inv, int(userID), tx.Commit()
+}
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (*models.OrgInvitation, error) {
+	var inv models.OrgInvitation
+	var stages sql.NullString
+	err := row.Scan(&inv.ID, &inv.PartyID, &inv.Email, &inv.OrgAdmin, &stages, &inv.InvitedBy, &inv.ExpiresAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.Stages = splitStages(stages)
+	return &inv, nil
+}
+
+// splitStages reads a member's stages from their SET column
+func splitStages(s sql.NullString) []string {
+	if s.String == "" {
+		return []string{}
+	}
+	return strings.Split(s.String, ",")
+}
@@ -0,0 +1,440 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"maydiv-crm/internal/models"
+)
+
+// legalFormWords are left out when comparing company names, so that
+// "ACME PVT. LTD." and "Acme Private Limited" are the same importer
+var legalFormWords = map[string]bool{
+	"pvt": true, "private": true, "ltd": true, "limited": true, "llp": true,
+	"co": true, "company": true, "corp": true, "corporation": true,
+	"inc": true, "the": true, "and": true,
+}
+
+// PartyFields are the stage 1 columns naming a job's parties, each kept
+// with a foreign key, named by the column with "_party_id" added, to the
+// party it matches
+var PartyFields = []string{"consignee", "shipper"}
+
+// PartyKey reduces a company name to its distinctive words, lower case
+func PartyKey(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, "m/s")
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	kept := words[:0]
+	for _, w := range words {
+		if !legalFormWords[w] {
+			kept = append(kept, w)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// PartyRepository handles the party directory: consignees and shippers
+// with their contacts, and the jobs linked to them
+type PartyRepository struct {
+	db *sql.DB
+}
+
+// NewPartyRepository creates a new party repository
+func NewPartyRepository(db *sql.DB) *PartyRepository {
+	return &PartyRepository{db: db}
+}
+
+const partyColumns = `p.id, p.name, p.address, p.gstin, p.iec, p.billing_email, p.billing_address,
+	p.credit_days, p.notes, p.created_at, p.updated_at`
+
+// List lists the parties by name with their aliases and contacts. With a
+// query it lists at most limit parties whose name or an alias starts with
+// it, ignoring legal forms, or whose name contains it, exact matches first.
+func (r *PartyRepository) List(ctx context.Context, query string, limit int) ([]models.Party, error) {
+	sqlQuery := `SELECT ` + partyColumns + ` FROM parties p`
+	var args []interface{}
+	if key := PartyKey(query); key != "" {
+		prefix := key + "%"
+		sqlQuery += ` WHERE p.name_key LIKE ? OR p.name LIKE ?
+				OR EXISTS (SELECT 1 FROM party_aliases a WHERE a.party_id = p.id AND a.alias_key LIKE ?)
+			ORDER BY (p.name_key = ? OR EXISTS (SELECT 1 FROM party_aliases a WHERE a.party_id = p.id AND a.alias_key = ?)) DESC, p.name
+			LIMIT ?`
+		args = append(args, prefix, "%"+strings.TrimSpace(query)+"%", prefix, key, key, limit)
+	} else {
+		sqlQuery += ` ORDER BY p.name`
+	}
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parties := []models.Party{}
+	for rows.Next() {
+		p, err := scanParty(rows)
+		if err != nil {
+			return nil, err
+		}
+		parties = append(parties, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.loadAliasesAndContacts(ctx, parties); err != nil {
+		return nil, err
+	}
+	return parties, nil
+}
+
+// Get retrieves a party with its aliases and contacts
+func (r *PartyRepository) Get(ctx context.Context, id int) (*models.Party, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+partyColumns+` FROM parties p WHERE p.id = ?`, id)
+	p, err := scanParty(row)
+	if err != nil {
+		return nil, translate(err)
+	}
+	parties := []models.Party{*p}
+	if err := r.loadAliasesAndContacts(ctx, parties); err != nil {
+		return nil, err
+	}
+	return &parties[0], nil
+}
+
+// loadAliasesAndContacts fills in the aliases and contacts of parties
+func (r *PartyRepository) loadAliasesAndContacts(ctx context.Context, parties []models.Party) error {
+	if len(parties) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(parties))
+	ids := make([]interface{}, 0, len(parties))
+	for i, p := range parties {
+		index[p.ID] = i
+		ids = append(ids, p.ID)
+	}
+	in := placeholders(len(ids))
+
+	aliases, err := r.db.QueryContext(ctx, `SELECT party_id, alias FROM party_aliases WHERE party_id IN `+in+` ORDER BY alias`, ids...)
+	if err != nil {
+		return err
+	}
+	defer aliases.Close()
+	for aliases.Next() {
+		var partyID int
+		var alias string
+		if err := aliases.Scan(&partyID, &alias); err != nil {
+			return err
+		}
+		p := &parties[index[partyID]]
+		p.Aliases = append(p.Aliases, alias)
+	}
+	if err := aliases.Err(); err != nil {
+		return err
+	}
+
+	contacts, err := r.db.QueryContext(ctx, `
+		SELECT party_id, id, name, email, phone, role FROM party_contacts
+		WHERE party_id IN `+in+` ORDER BY id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer contacts.Close()
+	for contacts.Next() {
+		var partyID int
+		var c models.PartyContact
+		if err := contacts.Scan(&partyID, &c.ID, &c.Name, &c.Email, &c.Phone, &c.Role); err != nil {
+			return err
+		}
+		p := &parties[index[partyID]]
+		p.Contacts = append(p.Contacts, c)
+	}
+	return contacts.Err()
+}
+
+// KeyOwners maps each of keys that is the name or an alias of a party to
+// that party's ID
+func (r *PartyRepository) KeyOwners(ctx context.Context, keys []string) (map[string]int, error) {
+	owners := make(map[string]int)
+	if len(keys) == 0 {
+		return owners, nil
+	}
+	in := placeholders(len(keys))
+	args := make([]interface{}, 0, 2*len(keys))
+	for i := 0; i < 2; i++ {
+		for _, k := range keys {
+			args = append(args, k)
+		}
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT name_key, id FROM parties WHERE name_key IN `+in+`
+		UNION ALL
+		SELECT alias_key, party_id FROM party_aliases WHERE alias_key IN `+in,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var id int
+		if err := rows.Scan(&key, &id); err != nil {
+			return nil, err
+		}
+		owners[key] = id
+	}
+	return owners, rows.Err()
+}
+
+// Create inserts a party with its aliases and contacts and returns its ID
+func (r *PartyRepository) Create(ctx context.Context, p *models.Party) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO parties (name, name_key, address, gstin, iec, billing_email, billing_address, credit_days, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.Name, PartyKey(p.Name), p.Address, p.GSTIN, p.IEC, p.BillingEmail, p.BillingAddress, p.CreditDays, p.Notes)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertPartyDetails(ctx, tx, int(id), p); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// Update replaces a party, its aliases and its contacts. Jobs linked to it
+// keep the name they were given.
+func (r *PartyRepository) Update(ctx context.Context, p *models.Party) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM parties WHERE id = ? FOR UPDATE`, p.ID).Scan(&exists)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE parties SET name = ?, name_key = ?, address = ?, gstin = ?, iec = ?,
+			billing_email = ?, billing_address = ?, credit_days = ?, notes = ?
+		WHERE id = ?
+	`, p.Name, PartyKey(p.Name), p.Address, p.GSTIN, p.IEC, p.BillingEmail, p.BillingAddress, p.CreditDays, p.Notes, p.ID)
+	if err != nil {
+		return translate(err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM party_aliases WHERE party_id = ?`, p.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM party_contacts WHERE party_id = ?`, p.ID); err != nil {
+		return err
+	}
+	if err := insertPartyDetails(ctx, tx, p.ID, p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a party with its aliases and contacts. Jobs linked to it
+// keep their consignee and shipper names and lose the link.
+func (r *PartyRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM parties WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// AddAlias records another name a party is written as
+func (r *PartyRepository) AddAlias(ctx context.Context, partyID int, alias string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO party_aliases (party_id, alias, alias_key) VALUES (?, ?, ?)
+	`, partyID, alias, PartyKey(alias))
+	return translate(err)
+}
+
+// Resolve returns the ID of the party name matches, or 0 if none does
+func (r *PartyRepository) Resolve(ctx context.Context, name string) (int, error) {
+	id, err := resolveParty(ctx, r.db, name)
+	if id == nil {
+		return 0, err
+	}
+	return id.(int), err
+}
+
+// Jobs lists the jobs a party is the consignee or shipper on, latest first
+func (r *PartyRepository) Jobs(ctx context.Context, partyID int) ([]models.PartyJob, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT pj.id, pj.job_no, s1.job_date, pj.current_stage, pj.status,
+			   IF(s1.consignee_party_id = ?, 'consignee', 'shipper')
+		FROM stage1_data s1
+		JOIN pipeline_jobs pj ON pj.id = s1.job_id
+		WHERE s1.consignee_party_id = ? OR s1.shipper_party_id = ?
+		ORDER BY COALESCE(s1.job_date, DATE(pj.created_at)) DESC, pj.id DESC
+	`, partyID, partyID, partyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.PartyJob{}
+	for rows.Next() {
+		var j models.PartyJob
+		if err := rows.Scan(&j.ID, &j.JobNo, &j.JobDate, &j.CurrentStage, &j.Status, &j.Role); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Documents lists the files uploaded to a party's jobs, latest first
+func (r *PartyRepository) Documents(ctx context.Context, partyID int) ([]models.PartyDocument, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT pj.job_no, jf.id, jf.job_id, jf.stage, jf.uploaded_by, jf.file_name, jf.original_name,
+			   jf.file_path, jf.file_size, jf.file_type, jf.description, jf.created_at,
+			   COALESCE(u.username, '')
+		FROM job_files jf
+		JOIN stage1_data s1 ON s1.job_id = jf.job_id
+		JOIN pipeline_jobs pj ON pj.id = jf.job_id
+		LEFT JOIN users u ON u.id = jf.uploaded_by
+		WHERE s1.consignee_party_id = ? OR s1.shipper_party_id = ?
+		ORDER BY jf.created_at DESC, jf.id DESC
+	`, partyID, partyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []models.PartyDocument{}
+	for rows.Next() {
+		var d models.PartyDocument
+		err := rows.Scan(&d.JobNo, &d.ID, &d.JobID, &d.Stage, &d.UploadedBy, &d.FileName, &d.OriginalName,
+			&d.FilePath, &d.FileSize, &d.FileType, &d.Description, &d.CreatedAt, &d.UploadedByUser)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+// UnlinkedNames lists the distinct names in field, "consignee" or
+// "shipper", on jobs not linked to a party, with the number of jobs
+// carrying each and a GSTIN and IEC recorded with it, most used first
+func (r *PartyRepository) UnlinkedNames(ctx context.Context, field string) ([]models.PartyReviewRow, error) {
+	if field != "consignee" && field != "shipper" {
+		return nil, fmt.Errorf("invalid party: %s", field)
+	}
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT TRIM(%[1]s), COUNT(*), COALESCE(MAX(%[1]s_gstin), ''), COALESCE(MAX(%[1]s_iec), '')
+		FROM stage1_data
+		WHERE %[1]s_party_id IS NULL AND TRIM(COALESCE(%[1]s, '')) <> ''
+		GROUP BY TRIM(%[1]s)
+		ORDER BY COUNT(*) DESC, TRIM(%[1]s)
+	`, field))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []models.PartyReviewRow
+	for rows.Next() {
+		n := models.PartyReviewRow{Field: field}
+		if err := rows.Scan(&n.Value, &n.Jobs, &n.GSTIN, &n.IEC); err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	return names, rows.Err()
+}
+
+// LinkName links the jobs whose field, "consignee" or "shipper", holds
+// name, and are not linked yet, to a party. It returns the number of jobs
+// linked.
+func (r *PartyRepository) LinkName(ctx context.Context, field, name string, partyID int) (int, error) {
+	if field != "consignee" && field != "shipper" {
+		return 0, fmt.Errorf("invalid party: %s", field)
+	}
+	result, err := r.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE stage1_data SET %[1]s_party_id = ?
+		WHERE %[1]s_party_id IS NULL AND TRIM(%[1]s) = ?
+	`, field), partyID, name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// resolveParty returns the ID of the party name matches, or nil if none
+// does, ready to be stored in a foreign key
+func resolveParty(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, name string) (interface{}, error) {
+	key := PartyKey(name)
+	if key == "" {
+		return nil, nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM parties WHERE name_key = ?
+		UNION ALL
+		SELECT party_id FROM party_aliases WHERE alias_key = ?
+		LIMIT 1
+	`, key, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func insertPartyDetails(ctx context.Context, tx *sql.Tx, partyID int, p *models.Party) error {
+	for _, alias := range p.Aliases {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO party_aliases (party_id, alias, alias_key) VALUES (?, ?, ?)
+		`, partyID, alias, PartyKey(alias))
+		if err != nil {
+			return translate(err)
+		}
+	}
+	for _, c := range p.Contacts {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO party_contacts (party_id, name, email, phone, role) VALUES (?, ?, ?, ?, ?)
+		`, partyID, c.Name, c.Email, c.Phone, c.Role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanParty(row interface{ Scan(...interface{}) error }) (*models.Party, error) {
+	var p models.Party
+	err := row.Scan(&p.ID, &p.Name, &p.Address, &p.GSTIN, &p.IEC, &p.BillingEmail, &p.BillingAddress,
+		&p.CreditDays, &p.Notes, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Aliases = []string{}
+	p.Contacts = []models.PartyContact{}
+	return &p, nil
+}
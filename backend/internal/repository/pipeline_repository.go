@@ -1,12 +1,15 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"maydiv-crm/internal/iso6346"
 	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/tradeid"
 )
 
 type PipelineRepository struct {
@@ -18,7 +21,7 @@ func NewPipelineRepository(db *sql.DB) *PipelineRepository {
 }
 
 // GetAllJobs retrieves all pipeline jobs with their current stage data
-func (r *PipelineRepository) GetAllJobs() ([]models.PipelineJobResponse, error) {
+func (r *PipelineRepository) GetAllJobs(ctx context.Context) ([]models.PipelineJobResponse, error) {
 	query := `
 		SELECT 
 			pj.id, pj.job_no, pj.current_stage, pj.status, pj.created_by, 
@@ -36,7 +39,7 @@ func (r *PipelineRepository) GetAllJobs() ([]models.PipelineJobResponse, error)
 		ORDER BY pj.created_at DESC
 	`
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +71,8 @@ func (r *PipelineRepository) GetAllJobs() ([]models.PipelineJobResponse, error)
 		}
 
 		// Load stage data based on current stage
-		if err := r.loadJobStageData(&job); err != nil {
-			log.Printf("Error loading stage data for job %d: %v", job.ID, err)
+		if err := r.loadJobStageData(ctx, &job); err != nil {
+			slog.ErrorContext(ctx, "Error loading stage data", "job_id", job.ID, "error", err)
 		}
 
 		jobs = append(jobs, job)
@@ -78,10 +81,31 @@ func (r *PipelineRepository) GetAllJobs() ([]models.PipelineJobResponse, error)
 	return jobs, nil
 }
 
+// CountJobsByStageAndStatus returns the number of jobs per current stage and status
+func (r *PipelineRepository) CountJobsByStageAndStatus(ctx context.Context) ([]models.JobStageCount, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT current_stage, status, COUNT(*)
+		FROM pipeline_jobs
+		GROUP BY current_stage, status
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.JobStageCount
+	for rows.Next() {
+		var c models.JobStageCount
+		if err := rows.Scan(&c.CurrentStage, &c.Status, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
 // GetJobByID retrieves a specific job with all its stage data
-func (r *PipelineRepository) GetJobByID(jobID int) (*models.PipelineJobResponse, error) {
-	log.Printf("GetJobByID called with jobID: %d", jobID)
-	
+func (r *PipelineRepository) GetJobByID(ctx context.Context, jobID int) (*models.PipelineJobResponse, error) {
 	query := `
 		SELECT 
 			pj.id, pj.job_no, pj.current_stage, pj.status, pj.created_by, 
@@ -102,18 +126,16 @@ func (r *PipelineRepository) GetJobByID(jobID int) (*models.PipelineJobResponse,
 	var job models.PipelineJobResponse
 	var stage2UserName, stage3UserName, customerName sql.NullString
 
-	log.Printf("Executing query for job ID: %d", jobID)
-	err := r.db.QueryRow(query, jobID).Scan(
+	err := r.db.QueryRowContext(ctx, query, jobID).Scan(
 		&job.ID, &job.JobNo, &job.CurrentStage, &job.Status, &job.CreatedBy,
 		&job.AssignedToStage2, &job.AssignedToStage3, &job.CustomerID,
 		&job.NotificationEmail, &job.CreatedAt, &job.UpdatedAt,
 		&job.CreatedByUser, &stage2UserName, &stage3UserName, &customerName,
 	)
 	if err != nil {
-		log.Printf("Error scanning job: %v", err)
-		return nil, err
+		return nil, translate(err)
 	}
-	log.Printf("Job found: ID=%d, JobNo=%s, Stage=%s", job.ID, job.JobNo, job.CurrentStage)
+	slog.DebugContext(ctx, "Job loaded", "job_id", job.ID, "job_no", job.JobNo, "stage", job.CurrentStage)
 
 	if stage2UserName.Valid {
 		job.Stage2UserName = stage2UserName.String
@@ -126,12 +148,12 @@ func (r *PipelineRepository) GetJobByID(jobID int) (*models.PipelineJobResponse,
 	}
 
 	// Load all stage data
-	if err := r.loadJobStageData(&job); err != nil {
+	if err := r.loadJobStageData(ctx, &job); err != nil {
 		return nil, err
 	}
 
 	// Load job updates
-	updates, err := r.getJobUpdates(jobID)
+	updates, err := r.getJobUpdates(ctx, jobID)
 	if err == nil {
 		job.Updates = updates
 	}
@@ -140,9 +162,8 @@ func (r *PipelineRepository) GetJobByID(jobID int) (*models.PipelineJobResponse,
 }
 
 // GetJobsByUserRole retrieves jobs assigned to a specific user based on their role
-func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]models.PipelineJobResponse, error) {
-	log.Printf("GetJobsByUserRole called with userID=%d, role=%s", userID, role)
-	
+func (r *PipelineRepository) GetJobsByUserRole(ctx context.Context, userID int, role string) ([]models.PipelineJobResponse, error) {
+	slog.DebugContext(ctx, "Fetching jobs for role", "user_id", userID, "role", role)
 	var query string
 	switch role {
 	case "subadmin":
@@ -163,7 +184,6 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 			LEFT JOIN users u4 ON pj.customer_id = u4.id
 			ORDER BY pj.created_at DESC
 		`
-		log.Printf("Subadmin query: %s", query)
 	case "stage1_employee":
 		query = `
 			SELECT 
@@ -176,8 +196,6 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 			WHERE pj.created_by = ? AND pj.current_stage IN ('stage1', 'stage2', 'stage3', 'stage4')
 			ORDER BY pj.created_at DESC
 		`
-		log.Printf("Stage1 query: %s", query)
-		log.Printf("Querying with userID: %d", userID)
 	case "stage2_employee":
 		query = `
 			SELECT 
@@ -190,8 +208,6 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 			WHERE pj.assigned_to_stage2 = ? AND pj.current_stage IN ('stage1', 'stage2', 'stage3', 'stage4')
 			ORDER BY pj.created_at DESC
 		`
-		log.Printf("Stage2 query: %s", query)
-		log.Printf("Querying with userID: %d", userID)
 	case "stage3_employee":
 		query = `
 			SELECT 
@@ -213,7 +229,9 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 				u1.username as created_by_user
 			FROM pipeline_jobs pj
 			LEFT JOIN users u1 ON pj.created_by = u1.id
-			WHERE pj.customer_id = ? AND pj.current_stage IN ('stage3', 'stage4')
+			LEFT JOIN stage1_data s1 ON s1.job_id = pj.id
+			WHERE (pj.customer_id = ? OR s1.consignee_party_id = (SELECT party_id FROM party_members WHERE user_id = ?))
+				AND pj.current_stage IN ('stage3', 'stage4', 'completed', 'closed')
 			ORDER BY pj.created_at DESC
 		`
 	default:
@@ -224,46 +242,20 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 	var rows *sql.Rows
 	var err error
 	if role == "subadmin" {
-		rows, err = r.db.Query(query)
+		rows, err = r.db.QueryContext(ctx, query)
+	} else if role == "customer" {
+		// Customers see their own jobs and their organization's
+		rows, err = r.db.QueryContext(ctx, query, userID, userID)
 	} else {
-		rows, err = r.db.Query(query, userID)
+		rows, err = r.db.QueryContext(ctx, query, userID)
 	}
 	
 	if err != nil {
-		log.Printf("Query error: %v", err)
+		slog.ErrorContext(ctx, "Error querying jobs for role", "role", role, "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	log.Printf("Query executed successfully, checking rows...")
-	
-	// First, let's check if there are any jobs at all
-	var count int
-	err = r.db.QueryRow("SELECT COUNT(*) FROM pipeline_jobs").Scan(&count)
-	if err != nil {
-		log.Printf("Error counting jobs: %v", err)
-	} else {
-		log.Printf("Total jobs in database: %d", count)
-	}
-	
-	// Check if there are any jobs assigned to this user
-	var assignedCount int
-	err = r.db.QueryRow("SELECT COUNT(*) FROM pipeline_jobs WHERE assigned_to_stage2 = ?", userID).Scan(&assignedCount)
-	if err != nil {
-		log.Printf("Error counting assigned jobs: %v", err)
-	} else {
-		log.Printf("Jobs assigned to user %d: %d", userID, assignedCount)
-	}
-	
-	// Check if there are any jobs created by this user (for stage1 employees)
-	var createdCount int
-	err = r.db.QueryRow("SELECT COUNT(*) FROM pipeline_jobs WHERE created_by = ?", userID).Scan(&createdCount)
-	if err != nil {
-		log.Printf("Error counting created jobs: %v", err)
-	} else {
-		log.Printf("Jobs created by user %d: %d", userID, createdCount)
-	}
-	
 	var jobs []models.PipelineJobResponse
 	for rows.Next() {
 		var job models.PipelineJobResponse
@@ -303,8 +295,8 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 		}
 
 		// Load stage data based on current stage
-		if err := r.loadJobStageData(&job); err != nil {
-			log.Printf("Error loading stage data for job %d: %v", job.ID, err)
+		if err := r.loadJobStageData(ctx, &job); err != nil {
+			slog.ErrorContext(ctx, "Error loading stage data", "job_id", job.ID, "error", err)
 		}
 
 		jobs = append(jobs, job)
@@ -314,20 +306,25 @@ func (r *PipelineRepository) GetJobsByUserRole(userID int, role string) ([]model
 }
 
 // CreateJob creates a new pipeline job with stage 1 data
-func (r *PipelineRepository) CreateJob(req *models.Stage1CreateRequest, createdBy int) (*models.PipelineJobResponse, error) {
-	tx, err := r.db.Begin()
+func (r *PipelineRepository) CreateJob(ctx context.Context, req *models.Stage1CreateRequest, createdBy int) (*models.PipelineJobResponse, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
+	status := req.Status
+	if status == "" {
+		status = models.JobStatusActive
+	}
+
 	// Create pipeline job
-	jobResult, err := tx.Exec(`
+	jobResult, err := tx.ExecContext(ctx, `
 		INSERT INTO pipeline_jobs (job_no, current_stage, status, created_by, assigned_to_stage2, assigned_to_stage3, customer_id, notification_email)
-		VALUES (?, 'stage1', 'active', ?, ?, ?, ?, ?)
-	`, req.JobNo, createdBy, nullInt(req.AssignedToStage2), nullInt(req.AssignedToStage3), nullInt(req.CustomerID), req.NotificationEmail)
+		VALUES (?, 'stage1', ?, ?, ?, ?, ?, ?)
+	`, req.JobNo, status, createdBy, nullInt(req.AssignedToStage2), nullInt(req.AssignedToStage3), nullInt(req.CustomerID), req.NotificationEmail)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	jobID, err := jobResult.LastInsertId()
@@ -335,35 +332,84 @@ func (r *PipelineRepository) CreateJob(req *models.Stage1CreateRequest, createdB
 		return nil, err
 	}
 
+	// Link the ports, country, shipping line and forwarder to their masters
+	masters, err := resolveMasters(ctx, tx, "stage1", map[string]string{
+		"port_of_discharge":       req.PortOfDischarge,
+		"final_place_of_delivery": req.FinalPlaceOfDelivery,
+		"port_of_loading":         req.PortOfLoading,
+		"country_of_shipment":     req.CountryOfShipment,
+		"shipping_line":           req.ShippingLine,
+		"forwarder":               req.Forwarder,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Link the consignee and shipper to the parties named, unless given
+	consigneeParty, shipperParty := nullInt(req.ConsigneePartyID), nullInt(req.ShipperPartyID)
+	if consigneeParty == nil {
+		if consigneeParty, err = resolveParty(ctx, tx, req.Consignee); err != nil {
+			return nil, err
+		}
+	}
+	if shipperParty == nil {
+		if shipperParty, err = resolveParty(ctx, tx, req.Shipper); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create stage1 data
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO stage1_data (
-			job_id, job_no, job_date, edi_job_no, edi_date, consignee, shipper,
-			port_of_discharge, final_place_of_delivery, port_of_loading, country_of_shipment,
-			hbl_no, hbl_date, mbl_no, mbl_date, shipping_line, forwarder,
+			job_id, job_no, job_date, edi_job_no, edi_date,
+			consignee, consignee_key, consignee_gstin, consignee_iec, consignee_party_id,
+			shipper, shipper_gstin, shipper_iec, shipper_party_id,
+			port_of_discharge, port_of_discharge_id, final_place_of_delivery, final_place_of_delivery_id,
+			port_of_loading, port_of_loading_id, country_of_shipment, country_of_shipment_id,
+			hbl_no, hbl_date, mbl_no, mbl_date, shipping_line, shipping_line_id, forwarder, forwarder_id,
 			weight, packages, invoice_no, invoice_date, gateway_igm, gateway_igm_date,
 			local_igm, local_igm_date, commodity, eta, current_status,
-			container_no, container_size, date_of_arrival
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			date_of_arrival
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		jobID, req.JobNo, parseDate(req.JobDate), req.EDIJobNo, parseDate(req.EDIDate),
-		req.Consignee, req.Shipper, req.PortOfDischarge, req.FinalPlaceOfDelivery,
-		req.PortOfLoading, req.CountryOfShipment, req.HBLNo, parseDate(req.HBLDate),
-		req.MBLNo, parseDate(req.MBLDate), req.ShippingLine, req.Forwarder,
+		req.Consignee, CustomerKey(req.Consignee), nullString(tradeid.Normalize(req.ConsigneeGSTIN)), nullString(tradeid.Normalize(req.ConsigneeIEC)), consigneeParty,
+		req.Shipper, nullString(tradeid.Normalize(req.ShipperGSTIN)), nullString(tradeid.Normalize(req.ShipperIEC)), shipperParty,
+		req.PortOfDischarge, masters["port_of_discharge"], req.FinalPlaceOfDelivery, masters["final_place_of_delivery"],
+		req.PortOfLoading, masters["port_of_loading"], req.CountryOfShipment, masters["country_of_shipment"],
+		req.HBLNo, parseDate(req.HBLDate), req.MBLNo, parseDate(req.MBLDate),
+		req.ShippingLine, masters["shipping_line"], req.Forwarder, masters["forwarder"],
 		req.Weight, req.Packages, req.InvoiceNo, parseDate(req.InvoiceDate),
 		req.GatewayIGM, parseDate(req.GatewayIGMDate), req.LocalIGM, parseDate(req.LocalIGMDate),
 		req.Commodity, parseDateTime(req.ETA), req.CurrentStatus,
-		req.ContainerNo, req.ContainerSize, parseDate(req.DateOfArrival),
+		parseDate(req.DateOfArrival),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Create the containers, the one given on its own first
+	containers := req.Containers
+	if req.ContainerNo != "" || req.ContainerSize != "" {
+		containers = append([]models.ContainerRequest{{ContainerNo: req.ContainerNo, Size: req.ContainerSize}}, containers...)
+	}
+	added := make(map[string]bool)
+	for i := range containers {
+		no := iso6346.Normalize(containers[i].ContainerNo)
+		if no != "" && added[no] {
+			continue
+		}
+		added[no] = true
+		if _, err := insertContainer(ctx, tx, int(jobID), &containers[i]); err != nil {
+			return nil, err
+		}
+	}
+
 	// Add job update
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
-		VALUES (?, ?, 'stage1', 'status_change', 'Job created')
-	`, jobID, createdBy)
+		VALUES (?, ?, 'stage1', 'status_change', ?)
+	`, jobID, createdBy, jobCreatedMessage(status))
 	if err != nil {
 		return nil, err
 	}
@@ -373,28 +419,54 @@ func (r *PipelineRepository) CreateJob(req *models.Stage1CreateRequest, createdB
 	}
 
 	// Return the created job
-	return r.GetJobByID(int(jobID))
+	return r.GetJobByID(ctx, int(jobID))
+}
+
+func jobCreatedMessage(status string) string {
+	if status == models.JobStatusDraft {
+		return "Draft job created"
+	}
+	return "Job created"
+}
+
+// LastPartyIdentifiers returns the GSTIN and IEC most recently recorded for
+// a consignee or shipper with the given name. party is "consignee" or
+// "shipper"; names are compared ignoring case and surrounding spaces.
+func (r *PipelineRepository) LastPartyIdentifiers(ctx context.Context, party, name string) (*models.PartyIdentifiers, error) {
+	if party != "consignee" && party != "shipper" {
+		return nil, fmt.Errorf("invalid party: %s", party)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COALESCE(%[1]s_gstin, ''), COALESCE(%[1]s_iec, '')
+		FROM stage1_data
+		WHERE LOWER(TRIM(%[1]s)) = LOWER(TRIM(?))
+		  AND (%[1]s_gstin IS NOT NULL OR %[1]s_iec IS NOT NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, party)
+
+	var ids models.PartyIdentifiers
+	err := r.db.QueryRowContext(ctx, query, name).Scan(&ids.GSTIN, &ids.IEC)
+	if err == sql.ErrNoRows {
+		return &ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ids, nil
 }
 
 // UpdateStage2Data updates stage 2 data and advances job to stage 2
-func (r *PipelineRepository) UpdateStage2Data(jobID int, req *models.Stage2UpdateRequest, userID int) error {
-	log.Printf("UpdateStage2Data called with jobID: %d, userID: %d", jobID, userID)
-	log.Printf("Stage2 data: %+v", req)
-	
-	tx, err := r.db.Begin()
+func (r *PipelineRepository) UpdateStage2Data(ctx context.Context, jobID int, req *models.Stage2UpdateRequest, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
 	// Insert or update stage2 data
-	log.Printf("Executing stage2 data insert/update for job %d", jobID)
-	log.Printf("HSNCode: %s", req.HSNCode)
-	log.Printf("FilingRequirement: %s", req.FilingRequirement)
-	log.Printf("DutyAmount: %f", req.DutyAmount)
-	log.Printf("OceanFreight: %f", req.OceanFreight)
-	
-	stage2Result, err := tx.Exec(`
+	stage2Result, err := tx.ExecContext(ctx, `
 		INSERT INTO stage2_data (
 			job_id, hsn_code, filing_requirement, checklist_sent_date, approval_date,
 			bill_of_entry_no, bill_of_entry_date, debit_note, debit_paid_by,
@@ -420,7 +492,7 @@ func (r *PipelineRepository) UpdateStage2Data(jobID int, req *models.Stage2Updat
 			documents_type = VALUES(documents_type),
 			updated_at = CURRENT_TIMESTAMP
 	`,
-		jobID, req.HSNCode, req.FilingRequirement, parseDate(req.ChecklistSentDate),
+		jobID, tradeid.Normalize(req.HSNCode), req.FilingRequirement, parseDate(req.ChecklistSentDate),
 		parseDate(req.ApprovalDate), req.BillOfEntryNo, parseDate(req.BillOfEntryDate),
 		req.DebitNote, req.DebitPaidBy, req.DutyAmount, req.DutyPaidBy,
 		req.OceanFreight, req.DestinationCharges, parseDate(req.OriginalDoctRecdDate),
@@ -428,33 +500,33 @@ func (r *PipelineRepository) UpdateStage2Data(jobID int, req *models.Stage2Updat
 	)
 	
 	if err != nil {
-		log.Printf("Error executing stage2 data insert/update: %v", err)
+		slog.ErrorContext(ctx, "Error saving stage 2 data", "job_id", jobID, "error", err)
 		return err
 	}
 	
 	stage2RowsAffected, _ := stage2Result.RowsAffected()
-	log.Printf("Stage2 data insert/update affected %d rows", stage2RowsAffected)
+	slog.DebugContext(ctx, "Stage 2 data saved", "job_id", jobID, "rows_affected", stage2RowsAffected)
 	if err != nil {
 		return err
 	}
 
-	// Update job stage if not already in stage2 or beyond
-	log.Printf("Updating job stage to stage2 for job %d", jobID)
-	jobResult, err := tx.Exec(`
+	// Update job stage if not already in stage2 or beyond; starting work
+	// on a draft job confirms it
+	jobResult, err := tx.ExecContext(ctx, `
 		UPDATE pipeline_jobs 
-		SET current_stage = 'stage2', updated_at = CURRENT_TIMESTAMP 
+		SET current_stage = 'stage2', status = IF(status = ?, ?, status), updated_at = CURRENT_TIMESTAMP 
 		WHERE id = ? AND current_stage = 'stage1'
-	`, jobID)
+	`, models.JobStatusDraft, models.JobStatusActive, jobID)
 	if err != nil {
-		log.Printf("Error updating job stage: %v", err)
+		slog.ErrorContext(ctx, "Error updating job stage", "job_id", jobID, "error", err)
 		return err
 	}
 	
 	jobRowsAffected, _ := jobResult.RowsAffected()
-	log.Printf("Job stage update affected %d rows", jobRowsAffected)
+	slog.DebugContext(ctx, "Job stage updated", "job_id", jobID, "stage", "stage2", "rows_affected", jobRowsAffected)
 
 	// Add job update
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
 		VALUES (?, ?, 'stage2', 'data_update', 'Stage 2 data updated')
 	`, jobID, userID)
@@ -464,158 +536,133 @@ func (r *PipelineRepository) UpdateStage2Data(jobID int, req *models.Stage2Updat
 
 	err = tx.Commit()
 	if err != nil {
-		log.Printf("Error committing transaction: %v", err)
+		slog.ErrorContext(ctx, "Error committing transaction", "job_id", jobID, "error", err)
 		return err
 	}
 
-	log.Printf("Stage2 data updated successfully for job %d", jobID)
+	slog.InfoContext(ctx, "Stage 2 data updated", "job_id", jobID, "user_id", userID)
 	return nil
 }
 
 // UpdateStage3Data updates stage 3 data and advances job to stage 3
-func (r *PipelineRepository) UpdateStage3Data(jobID int, req *models.Stage3UpdateRequest, userID int) error {
-	log.Printf("UpdateStage3Data called with jobID: %d, userID: %d", jobID, userID)
-	log.Printf("Stage3 data: %+v", req)
-	
-	tx, err := r.db.Begin()
+func (r *PipelineRepository) UpdateStage3Data(ctx context.Context, jobID int, req *models.Stage3UpdateRequest, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	masters, err := resolveMasters(ctx, tx, "stage3", map[string]string{"custodian": req.Custodian})
+	if err != nil {
+		return err
+	}
+
 	// Insert or update stage3 data
-	log.Printf("Executing stage3 data insert/update for job %d", jobID)
-	log.Printf("Custodian: %s", req.Custodian)
-	log.Printf("DispatchInfo: %s", req.DispatchInfo)
-	log.Printf("ClearanceExps: %f", req.ClearanceExps)
-	log.Printf("StampDuty: %f", req.StampDuty)
-	
-	stage3Result, err := tx.Exec(`
+	stage3Result, err := tx.ExecContext(ctx, `
 		INSERT INTO stage3_data (
 			job_id, exam_date, out_of_charge, clearance_exps, stamp_duty,
-			custodian, offloading_charges, transport_detention, dispatch_info
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			custodian, custodian_id, offloading_charges, transport_detention, dispatch_info
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			exam_date = VALUES(exam_date),
 			out_of_charge = VALUES(out_of_charge),
 			clearance_exps = VALUES(clearance_exps),
 			stamp_duty = VALUES(stamp_duty),
 			custodian = VALUES(custodian),
+			custodian_id = VALUES(custodian_id),
 			offloading_charges = VALUES(offloading_charges),
 			transport_detention = VALUES(transport_detention),
 			dispatch_info = VALUES(dispatch_info),
 			updated_at = CURRENT_TIMESTAMP
 	`,
 		jobID, parseDate(req.ExamDate), parseDate(req.OutOfCharge),
-		req.ClearanceExps, req.StampDuty, req.Custodian,
+		req.ClearanceExps, req.StampDuty, req.Custodian, masters["custodian"],
 		req.OffloadingCharges, req.TransportDetention, req.DispatchInfo,
 	)
 	if err != nil {
-		log.Printf("Error executing stage3 data insert/update: %v", err)
+		slog.ErrorContext(ctx, "Error saving stage 3 data", "job_id", jobID, "error", err)
 		return err
 	}
 	stage3RowsAffected, _ := stage3Result.RowsAffected()
-	log.Printf("Stage3 data insert/update affected %d rows", stage3RowsAffected)
+	slog.DebugContext(ctx, "Stage 3 data saved", "job_id", jobID, "rows_affected", stage3RowsAffected)
 
-	// Delete existing containers and add new ones
-	_, err = tx.Exec("DELETE FROM stage3_containers WHERE job_id = ?", jobID)
-	if err != nil {
-		return err
-	}
-
-	for _, container := range req.Containers {
-		_, err = tx.Exec(`
-			INSERT INTO stage3_containers (job_id, container_no, size, vehicle_no, date_of_offloading, empty_return_date)
-			VALUES (?, ?, ?, ?, ?, ?)
-		`,
-			jobID, container.ContainerNo, container.Size, container.VehicleNo,
-			parseDate(container.DateOfOffloading), parseDate(container.EmptyReturnDate),
-		)
-		if err != nil {
+	// Fill in the listed containers, adding those the job does not have
+	for i := range req.Containers {
+		if err := saveStage3Container(ctx, tx, jobID, &req.Containers[i], userID); err != nil {
 			return err
 		}
 	}
 
 	// Update job stage if not already in stage3 or beyond
-	log.Printf("Updating job stage to stage3 for job %d", jobID)
-	jobResult, err := tx.Exec(`
+	jobResult, err := tx.ExecContext(ctx, `
 		UPDATE pipeline_jobs 
 		SET current_stage = 'stage3', updated_at = CURRENT_TIMESTAMP 
 		WHERE id = ? AND current_stage IN ('stage1', 'stage2')
 	`, jobID)
 	if err != nil {
-		log.Printf("Error updating job stage: %v", err)
+		slog.ErrorContext(ctx, "Error updating job stage", "job_id", jobID, "error", err)
 		return err
 	}
 	jobRowsAffected, _ := jobResult.RowsAffected()
-	log.Printf("Job stage update affected %d rows", jobRowsAffected)
+	slog.DebugContext(ctx, "Job stage updated", "job_id", jobID, "stage", "stage3", "rows_affected", jobRowsAffected)
 
 	// Add job update
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
 		VALUES (?, ?, 'stage3', 'data_update', 'Stage 3 data updated')
 	`, jobID, userID)
 	if err != nil {
-		log.Printf("Error adding job update: %v", err)
+		slog.ErrorContext(ctx, "Error adding job update", "job_id", jobID, "error", err)
 		return err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Printf("Error committing transaction: %v", err)
+		slog.ErrorContext(ctx, "Error committing transaction", "job_id", jobID, "error", err)
 		return err
 	}
-	log.Printf("Stage3 data updated successfully for job %d", jobID)
+	slog.InfoContext(ctx, "Stage 3 data updated", "job_id", jobID, "user_id", userID)
 	return nil
 }
 
-// UpdateStage4Data updates stage 4 data and completes the job
-func (r *PipelineRepository) UpdateStage4Data(jobID int, req *models.Stage4UpdateRequest, userID int) error {
-	log.Printf("UpdateStage4Data called with jobID: %d, userID: %d", jobID, userID)
-	log.Printf("Stage4 data: %+v", req)
-	
-	tx, err := r.db.Begin()
+// UpdateStage4Data updates stage 4 data with the bill's computed items and
+// totals, and completes the job. Once the bill is issued only its delivery
+// is saved, when bill is nil; a bill saved as sent is issued, see
+// Stage4UpdateRequest.BillSent. It
+// returns ErrBillIssued when given a bill to save for a bill issued since.
+func (r *PipelineRepository) UpdateStage4Data(ctx context.Context, jobID int, req *models.Stage4UpdateRequest, bill *models.BillTotals, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Insert or update stage4 data
-	log.Printf("Executing stage4 data insert/update for job %d", jobID)
-	log.Printf("BillNo: %s", req.BillNo)
-	log.Printf("BillMail: %s", req.BillMail)
-	log.Printf("AmountTaxable: %f", req.AmountTaxable)
-	log.Printf("GST5Percent: %f", req.GST5Percent)
-	log.Printf("GST18Percent: %f", req.GST18Percent)
-	
-	stage4Result, err := tx.Exec(`
-		INSERT INTO stage4_data (
-			job_id, bill_no, bill_date, amount_taxable, gst_5_percent, gst_18_percent,
-			bill_mail, bill_courier, courier_date, acknowledge_date, acknowledge_name
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-			bill_no = VALUES(bill_no),
-			bill_date = VALUES(bill_date),
-			amount_taxable = VALUES(amount_taxable),
-			gst_5_percent = VALUES(gst_5_percent),
-			gst_18_percent = VALUES(gst_18_percent),
-			bill_mail = VALUES(bill_mail),
-			bill_courier = VALUES(bill_courier),
-			courier_date = VALUES(courier_date),
-			acknowledge_date = VALUES(acknowledge_date),
-			acknowledge_name = VALUES(acknowledge_name),
-			updated_at = CURRENT_TIMESTAMP
-	`,
-		jobID, req.BillNo, parseDate(req.BillDate), req.AmountTaxable,
-		req.GST5Percent, req.GST18Percent, req.BillMail, req.BillCourier,
-		parseDate(req.CourierDate), parseDate(req.AcknowledgeDate), req.AcknowledgeName,
-	)
-	if err != nil {
-		log.Printf("Error executing stage4 data insert/update: %v", err)
+	var issued bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT issued_at IS NOT NULL FROM stage4_data WHERE job_id = ? FOR UPDATE
+	`, jobID).Scan(&issued)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if issued && bill != nil {
+		// Issued since the caller checked, so its edits must be checked again
+		return ErrBillIssued
+	}
+	if issued {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE stage4_data
+			SET bill_mail = ?, bill_courier = ?, courier_date = ?, acknowledge_date = ?, acknowledge_name = ?,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE job_id = ?
+		`, req.BillMail, req.BillCourier, parseDate(req.CourierDate), parseDate(req.AcknowledgeDate), req.AcknowledgeName, jobID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving stage 4 delivery", "job_id", jobID, "error", err)
+			return err
+		}
+	} else if err := r.saveStage4Bill(ctx, tx, jobID, req, bill); err != nil {
 		return err
 	}
-	stage4RowsAffected, _ := stage4Result.RowsAffected()
-	log.Printf("Stage4 data insert/update affected %d rows", stage4RowsAffected)
 
 	// Update job stage to stage4 and potentially completed
 	var newStage string
@@ -625,18 +672,18 @@ func (r *PipelineRepository) UpdateStage4Data(jobID int, req *models.Stage4Updat
 		newStage = "stage4"
 	}
 
-	log.Printf("Updating job stage to %s for job %d", newStage, jobID)
-	jobResult, err := tx.Exec(`
+	// A closed job stays closed unless the new bill is no longer settled
+	jobResult, err := tx.ExecContext(ctx, `
 		UPDATE pipeline_jobs 
-		SET current_stage = ?, updated_at = CURRENT_TIMESTAMP 
+		SET current_stage = IF(current_stage = 'closed', current_stage, ?), updated_at = CURRENT_TIMESTAMP 
 		WHERE id = ?
 	`, newStage, jobID)
 	if err != nil {
-		log.Printf("Error updating job stage: %v", err)
+		slog.ErrorContext(ctx, "Error updating job stage", "job_id", jobID, "error", err)
 		return err
 	}
 	jobRowsAffected, _ := jobResult.RowsAffected()
-	log.Printf("Job stage update affected %d rows", jobRowsAffected)
+	slog.DebugContext(ctx, "Job stage updated", "job_id", jobID, "stage", newStage, "rows_affected", jobRowsAffected)
 
 	// Add job update
 	message := "Stage 4 data updated"
@@ -644,99 +691,208 @@ func (r *PipelineRepository) UpdateStage4Data(jobID int, req *models.Stage4Updat
 		message = "Job completed"
 	}
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
 		VALUES (?, ?, 'stage4', 'data_update', ?)
 	`, jobID, userID, message)
 	if err != nil {
-		log.Printf("Error adding job update: %v", err)
+		slog.ErrorContext(ctx, "Error adding job update", "job_id", jobID, "error", err)
+		return err
+	}
+
+	if err := settleJob(ctx, tx, jobID, userID); err != nil {
 		return err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Printf("Error committing transaction: %v", err)
+		slog.ErrorContext(ctx, "Error committing transaction", "job_id", jobID, "error", err)
 		return err
 	}
-	log.Printf("Stage4 data updated successfully for job %d", jobID)
+	slog.InfoContext(ctx, "Stage 4 data updated", "job_id", jobID, "user_id", userID, "stage", newStage)
 	return nil
 }
 
+// saveStage4Bill saves a bill that has not been issued with its items
+func (r *PipelineRepository) saveStage4Bill(ctx context.Context, tx *sql.Tx, jobID int, req *models.Stage4UpdateRequest, bill *models.BillTotals) error {
+	var issuedAt interface{}
+	if req.BillSent() {
+		issuedAt = time.Now()
+	}
+
+	// Insert or update stage4 data
+	stage4Result, err := tx.ExecContext(ctx, `
+		INSERT INTO stage4_data (
+			job_id, bill_no, bill_date, amount_taxable, gst_5_percent, gst_18_percent,
+			place_of_supply, cgst_amount, sgst_amount, igst_amount, round_off, total_amount, totals_overridden,
+			bill_mail, bill_courier, courier_date, acknowledge_date, acknowledge_name, issued_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			bill_no = VALUES(bill_no),
+			bill_date = VALUES(bill_date),
+			amount_taxable = VALUES(amount_taxable),
+			gst_5_percent = VALUES(gst_5_percent),
+			gst_18_percent = VALUES(gst_18_percent),
+			place_of_supply = VALUES(place_of_supply),
+			cgst_amount = VALUES(cgst_amount),
+			sgst_amount = VALUES(sgst_amount),
+			igst_amount = VALUES(igst_amount),
+			round_off = VALUES(round_off),
+			total_amount = VALUES(total_amount),
+			totals_overridden = VALUES(totals_overridden),
+			bill_mail = VALUES(bill_mail),
+			bill_courier = VALUES(bill_courier),
+			courier_date = VALUES(courier_date),
+			acknowledge_date = VALUES(acknowledge_date),
+			acknowledge_name = VALUES(acknowledge_name),
+			issued_at = VALUES(issued_at),
+			updated_at = CURRENT_TIMESTAMP
+	`,
+		jobID, req.BillNo, parseDate(req.BillDate), bill.AmountTaxable,
+		bill.GST5Percent, bill.GST18Percent, nullString(bill.PlaceOfSupply),
+		bill.CGSTAmount, bill.SGSTAmount, bill.IGSTAmount, bill.RoundOff, bill.TotalAmount,
+		bill.TotalsOverridden, req.BillMail, req.BillCourier,
+		parseDate(req.CourierDate), parseDate(req.AcknowledgeDate), req.AcknowledgeName, issuedAt,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving stage 4 data", "job_id", jobID, "error", err)
+		return err
+	}
+	stage4RowsAffected, _ := stage4Result.RowsAffected()
+	slog.DebugContext(ctx, "Stage 4 data saved", "job_id", jobID, "rows_affected", stage4RowsAffected)
+
+	// Replace the bill's items
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bill_items WHERE job_id = ?`, jobID); err != nil {
+		return err
+	}
+	for _, item := range bill.Items {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO bill_items (
+				job_id, line_no, description, sac, gst_rate, taxable_amount,
+				cgst_amount, sgst_amount, igst_amount, total_amount
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, jobID, item.LineNo, item.Description, item.SAC, item.GSTRate, item.TaxableAmount,
+			item.CGSTAmount, item.SGSTAmount, item.IGSTAmount, item.TotalAmount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBillCopy links a job's bill copy, e.g. to a generated invoice PDF
+func (r *PipelineRepository) SetBillCopy(ctx context.Context, jobID int, link string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE stage4_data SET bill_copy_upload = ? WHERE job_id = ?
+	`, link, jobID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// MarkBillIssued records that a job's bill has been sent, unless it already
+// was. From then on the bill is corrected only by credit and debit notes.
+func (r *PipelineRepository) MarkBillIssued(ctx context.Context, jobID int) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE stage4_data SET issued_at = COALESCE(issued_at, CURRENT_TIMESTAMP) WHERE job_id = ?
+	`, jobID)
+	return err
+}
+
 // Helper functions
-func (r *PipelineRepository) loadJobStageData(job *models.PipelineJobResponse) error {
-	log.Printf("loadJobStageData called for job %d (current stage: %s)", job.ID, job.CurrentStage)
-	
+func (r *PipelineRepository) loadJobStageData(ctx context.Context, job *models.PipelineJobResponse) error {
 	// Load Stage 1 data
-	stage1, err := r.getStage1Data(job.ID)
+	stage1, err := r.getStage1Data(ctx, job.ID)
 	if err == nil {
 		job.Stage1 = stage1
-		log.Printf("Stage1 data loaded successfully for job %d", job.ID)
 	} else {
-		log.Printf("Failed to load Stage1 data for job %d: %v", job.ID, err)
+		slog.WarnContext(ctx, "Failed to load stage 1 data", "job_id", job.ID, "error", err)
+	}
+
+	containers, err := queryContainers(ctx, r.db, job.ID)
+	if err == nil {
+		job.Containers = containers
+	} else {
+		slog.WarnContext(ctx, "Failed to load containers", "job_id", job.ID, "error", err)
 	}
 
 	// Load Stage 2 data if applicable
-	if job.CurrentStage == "stage2" || job.CurrentStage == "stage3" || job.CurrentStage == "stage4" || job.CurrentStage == "completed" {
-		stage2, err := r.getStage2Data(job.ID)
+	if job.CurrentStage == "stage2" || job.CurrentStage == "stage3" || job.CurrentStage == "stage4" || job.CurrentStage == "completed" || job.CurrentStage == "closed" {
+		stage2, err := r.getStage2Data(ctx, job.ID)
 		if err == nil {
 			job.Stage2 = stage2
+			duty, err := r.getLatestDutyCalculation(ctx, job.ID)
+			if err == nil {
+				stage2.DutyBreakdown = duty
+			} else if err != sql.ErrNoRows {
+				slog.WarnContext(ctx, "Failed to load duty calculation", "job_id", job.ID, "error", err)
+			}
+			filings, err := queryJobFilings(ctx, r.db, job.ID)
+			if err == nil {
+				stage2.Filings = filings
+			} else {
+				slog.WarnContext(ctx, "Failed to load job filings", "job_id", job.ID, "error", err)
+			}
 		}
 	}
 
 	// Load Stage 3 data if applicable
-	if job.CurrentStage == "stage3" || job.CurrentStage == "stage4" || job.CurrentStage == "completed" {
-		stage3, err := r.getStage3Data(job.ID)
+	if job.CurrentStage == "stage3" || job.CurrentStage == "stage4" || job.CurrentStage == "completed" || job.CurrentStage == "closed" {
+		stage3, err := r.getStage3Data(ctx, job.ID)
 		if err == nil {
 			job.Stage3 = stage3
-			log.Printf("Stage3 data loaded successfully for job %d", job.ID)
-		} else {
-			log.Printf("Failed to load Stage3 data for job %d: %v", job.ID, err)
-		}
-
-		containers, err := r.getStage3Containers(job.ID)
-		if err == nil {
-			job.Stage3Containers = containers
-			log.Printf("Stage3 containers loaded successfully for job %d", job.ID)
 		} else {
-			log.Printf("Failed to load Stage3 containers for job %d: %v", job.ID, err)
+			slog.WarnContext(ctx, "Failed to load stage 3 data", "job_id", job.ID, "error", err)
 		}
 	}
 
 	// Load Stage 4 data if applicable
-	if job.CurrentStage == "stage4" || job.CurrentStage == "completed" {
-		stage4, err := r.getStage4Data(job.ID)
+	if job.CurrentStage == "stage4" || job.CurrentStage == "completed" || job.CurrentStage == "closed" {
+		stage4, err := r.getStage4Data(ctx, job.ID)
 		if err == nil {
 			job.Stage4 = stage4
-			log.Printf("Stage4 data loaded successfully for job %d", job.ID)
+			eInvoice, err := queryEInvoice(ctx, r.db, job.ID)
+			if err == nil {
+				stage4.EInvoice = eInvoice
+			} else if err != sql.ErrNoRows {
+				slog.WarnContext(ctx, "Failed to load e-invoice", "job_id", job.ID, "error", err)
+			}
 		} else {
-			log.Printf("Failed to load Stage4 data for job %d: %v", job.ID, err)
+			slog.WarnContext(ctx, "Failed to load stage 4 data", "job_id", job.ID, "error", err)
 		}
 	}
 
 	return nil
 }
 
-func (r *PipelineRepository) getStage1Data(jobID int) (*models.Stage1Data, error) {
-	log.Printf("getStage1Data called for jobID: %d", jobID)
-	
+func (r *PipelineRepository) getStage1Data(ctx context.Context, jobID int) (*models.Stage1Data, error) {
 	var stage1 models.Stage1Data
 	query := `
-		SELECT id, job_id, job_no, job_date, edi_job_no, edi_date, consignee, shipper,
-			   port_of_discharge, final_place_of_delivery, port_of_loading, country_of_shipment,
-			   hbl_no, hbl_date, mbl_no, mbl_date, shipping_line, forwarder,
+		SELECT id, job_id, job_no, job_date, edi_job_no, edi_date,
+			   consignee, consignee_gstin, consignee_iec, consignee_party_id,
+			   shipper, shipper_gstin, shipper_iec, shipper_party_id,
+			   port_of_discharge, port_of_discharge_id, final_place_of_delivery, final_place_of_delivery_id,
+			   port_of_loading, port_of_loading_id, country_of_shipment, country_of_shipment_id,
+			   hbl_no, hbl_date, mbl_no, mbl_date, shipping_line, shipping_line_id, forwarder, forwarder_id,
 			   weight, packages, invoice_no, invoice_date, gateway_igm, gateway_igm_date,
 			   local_igm, local_igm_date, commodity, eta, current_status,
-			   container_no, container_size, date_of_arrival, invoice_pl_doc, bl_doc, coo_doc,
+			   (SELECT c.container_no FROM job_containers c WHERE c.job_id = stage1_data.job_id ORDER BY c.id LIMIT 1),
+			   (SELECT c.size FROM job_containers c WHERE c.job_id = stage1_data.job_id ORDER BY c.id LIMIT 1),
+			   date_of_arrival, invoice_pl_doc, bl_doc, coo_doc,
 			   created_at, updated_at
 		FROM stage1_data WHERE job_id = ?
 	`
 
-	err := r.db.QueryRow(query, jobID).Scan(
+	err := r.db.QueryRowContext(ctx, query, jobID).Scan(
 		&stage1.ID, &stage1.JobID, &stage1.JobNo, &stage1.JobDate, &stage1.EDIJobNo,
-		&stage1.EDIDate, &stage1.Consignee, &stage1.Shipper, &stage1.PortOfDischarge,
-		&stage1.FinalPlaceOfDelivery, &stage1.PortOfLoading, &stage1.CountryOfShipment,
+		&stage1.EDIDate, &stage1.Consignee, &stage1.ConsigneeGSTIN, &stage1.ConsigneeIEC, &stage1.ConsigneePartyID,
+		&stage1.Shipper, &stage1.ShipperGSTIN, &stage1.ShipperIEC, &stage1.ShipperPartyID,
+		&stage1.PortOfDischarge, &stage1.PortOfDischargeID, &stage1.FinalPlaceOfDelivery, &stage1.FinalPlaceOfDeliveryID,
+		&stage1.PortOfLoading, &stage1.PortOfLoadingID, &stage1.CountryOfShipment, &stage1.CountryOfShipmentID,
 		&stage1.HBLNo, &stage1.HBLDate, &stage1.MBLNo, &stage1.MBLDate,
-		&stage1.ShippingLine, &stage1.Forwarder, &stage1.Weight, &stage1.Packages,
+		&stage1.ShippingLine, &stage1.ShippingLineID, &stage1.Forwarder, &stage1.ForwarderID,
+		&stage1.Weight, &stage1.Packages,
 		&stage1.InvoiceNo, &stage1.InvoiceDate, &stage1.GatewayIGM, &stage1.GatewayIGMDate,
 		&stage1.LocalIGM, &stage1.LocalIGMDate, &stage1.Commodity, &stage1.ETA,
 		&stage1.CurrentStatus, &stage1.ContainerNo, &stage1.ContainerSize,
@@ -745,30 +901,28 @@ func (r *PipelineRepository) getStage1Data(jobID int) (*models.Stage1Data, error
 	)
 
 	if err != nil {
-		log.Printf("Error getting stage1 data for job %d: %v", jobID, err)
 		return nil, err
 	}
 
-	log.Printf("Stage1 data found for job %d: Consignee=%s, Commodity=%s", jobID, stage1.Consignee, stage1.Commodity)
 	return &stage1, nil
 }
 
-func (r *PipelineRepository) getStage2Data(jobID int) (*models.Stage2Data, error) {
-	log.Printf("getStage2Data called for jobID: %d", jobID)
-	
+func (r *PipelineRepository) getStage2Data(ctx context.Context, jobID int) (*models.Stage2Data, error) {
 	var stage2 models.Stage2Data
 	query := `
-		SELECT id, job_id, hsn_code, filing_requirement, checklist_sent_date, approval_date,
-			   bill_of_entry_no, bill_of_entry_date, debit_note, debit_paid_by,
-			   duty_amount, duty_paid_by, ocean_freight, destination_charges,
-			   original_doct_recd_date, drn_no, irn_no, documents_type,
-			   document_1, document_2, document_3, document_4, document_5, document_6,
-			   query_upload, reply_upload, created_at, updated_at
-		FROM stage2_data WHERE job_id = ?
+		SELECT s.id, s.job_id, s.hsn_code, h.description, s.filing_requirement, s.checklist_sent_date, s.approval_date,
+			   s.bill_of_entry_no, s.bill_of_entry_date, s.debit_note, s.debit_paid_by,
+			   s.duty_amount, s.duty_paid_by, s.ocean_freight, s.destination_charges,
+			   s.original_doct_recd_date, s.drn_no, s.irn_no, s.documents_type,
+			   s.document_1, s.document_2, s.document_3, s.document_4, s.document_5, s.document_6,
+			   s.query_upload, s.reply_upload, s.created_at, s.updated_at
+		FROM stage2_data s
+		LEFT JOIN hsn_codes h ON h.code = s.hsn_code
+		WHERE s.job_id = ?
 	`
 
-	err := r.db.QueryRow(query, jobID).Scan(
-		&stage2.ID, &stage2.JobID, &stage2.HSNCode, &stage2.FilingRequirement,
+	err := r.db.QueryRowContext(ctx, query, jobID).Scan(
+		&stage2.ID, &stage2.JobID, &stage2.HSNCode, &stage2.HSNDescription, &stage2.FilingRequirement,
 		&stage2.ChecklistSentDate, &stage2.ApprovalDate, &stage2.BillOfEntryNo,
 		&stage2.BillOfEntryDate, &stage2.DebitNote, &stage2.DebitPaidBy,
 		&stage2.DutyAmount, &stage2.DutyPaidBy, &stage2.OceanFreight,
@@ -779,105 +933,115 @@ func (r *PipelineRepository) getStage2Data(jobID int) (*models.Stage2Data, error
 	)
 
 	if err != nil {
-		log.Printf("Error getting stage2 data for job %d: %v", jobID, err)
 		return nil, err
 	}
 
-	log.Printf("Stage2 data found for job %d: HSNCode=%s, FilingRequirement=%s", jobID, stage2.HSNCode, stage2.FilingRequirement)
 	return &stage2, nil
 }
 
-func (r *PipelineRepository) getStage3Data(jobID int) (*models.Stage3Data, error) {
-	log.Printf("getStage3Data called for jobID: %d", jobID)
-	
+// getLatestDutyCalculation returns the duty breakdown last saved for a job
+func (r *PipelineRepository) getLatestDutyCalculation(ctx context.Context, jobID int) (*models.DutyBreakdown, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT d.id, d.calculated_by, u.username, d.breakdown, d.created_at
+		FROM duty_calculations d
+		LEFT JOIN users u ON d.calculated_by = u.id
+		WHERE d.job_id = ?
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT 1
+	`, jobID)
+	return scanDutyCalculation(row)
+}
+
+func (r *PipelineRepository) getStage3Data(ctx context.Context, jobID int) (*models.Stage3Data, error) {
 	var stage3 models.Stage3Data
 	query := `
 		SELECT id, job_id, exam_date, out_of_charge, clearance_exps, stamp_duty,
-			   custodian, offloading_charges, transport_detention, dispatch_info,
+			   custodian, custodian_id, offloading_charges, transport_detention, dispatch_info,
 			   bill_of_entry_upload, created_at, updated_at
 		FROM stage3_data WHERE job_id = ?
 	`
 
-	err := r.db.QueryRow(query, jobID).Scan(
+	err := r.db.QueryRowContext(ctx, query, jobID).Scan(
 		&stage3.ID, &stage3.JobID, &stage3.ExamDate, &stage3.OutOfCharge,
-		&stage3.ClearanceExps, &stage3.StampDuty, &stage3.Custodian,
+		&stage3.ClearanceExps, &stage3.StampDuty, &stage3.Custodian, &stage3.CustodianID,
 		&stage3.OffloadingCharges, &stage3.TransportDetention, &stage3.DispatchInfo,
 		&stage3.BillOfEntryUpload, &stage3.CreatedAt, &stage3.UpdatedAt,
 	)
 
 	if err != nil {
-		log.Printf("Error getting stage3 data for job %d: %v", jobID, err)
 		return nil, err
 	}
 
-	log.Printf("Stage3 data found for job %d: Custodian=%s, DispatchInfo=%s", jobID, stage3.Custodian, stage3.DispatchInfo)
 	return &stage3, err
 }
 
-func (r *PipelineRepository) getStage3Containers(jobID int) ([]models.Stage3Container, error) {
-	query := `
-		SELECT id, job_id, container_no, size, vehicle_no, date_of_offloading, empty_return_date, created_at
-		FROM stage3_containers WHERE job_id = ?
-	`
-
-	rows, err := r.db.Query(query, jobID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var containers []models.Stage3Container
-	for rows.Next() {
-		var container models.Stage3Container
-		err := rows.Scan(
-			&container.ID, &container.JobID, &container.ContainerNo, &container.Size,
-			&container.VehicleNo, &container.DateOfOffloading, &container.EmptyReturnDate,
-			&container.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		containers = append(containers, container)
-	}
-
-	return containers, nil
-}
-
-func (r *PipelineRepository) getStage4Data(jobID int) (*models.Stage4Data, error) {
-	log.Printf("getStage4Data called for jobID: %d", jobID)
-	
+func (r *PipelineRepository) getStage4Data(ctx context.Context, jobID int) (*models.Stage4Data, error) {
 	var stage4 models.Stage4Data
 	query := `
 		SELECT id, job_id, bill_no, bill_date, amount_taxable, gst_5_percent, gst_18_percent,
-			   bill_mail, bill_courier, courier_date, acknowledge_date, acknowledge_name,
-			   bill_copy_upload, created_at, updated_at
+			   place_of_supply, cgst_amount, sgst_amount, igst_amount, round_off, total_amount,
+			   totals_overridden, bill_mail, bill_courier, courier_date, acknowledge_date,
+			   acknowledge_name, bill_copy_upload, issued_at, created_at, updated_at
 		FROM stage4_data WHERE job_id = ?
 	`
 
-	err := r.db.QueryRow(query, jobID).Scan(
+	err := r.db.QueryRowContext(ctx, query, jobID).Scan(
 		&stage4.ID, &stage4.JobID, &stage4.BillNo, &stage4.BillDate,
 		&stage4.AmountTaxable, &stage4.GST5Percent, &stage4.GST18Percent,
+		&stage4.PlaceOfSupply, &stage4.CGSTAmount, &stage4.SGSTAmount, &stage4.IGSTAmount,
+		&stage4.RoundOff, &stage4.TotalAmount, &stage4.TotalsOverridden,
 		&stage4.BillMail, &stage4.BillCourier, &stage4.CourierDate,
 		&stage4.AcknowledgeDate, &stage4.AcknowledgeName, &stage4.BillCopyUpload,
-		&stage4.CreatedAt, &stage4.UpdatedAt,
+		&stage4.IssuedAt, &stage4.CreatedAt, &stage4.UpdatedAt,
 	)
 
 	if err != nil {
-		log.Printf("Error getting stage4 data for job %d: %v", jobID, err)
 		return nil, err
 	}
 
-	log.Printf("Stage4 data found for job %d: BillNo=%s, BillMail=%s", jobID, stage4.BillNo, stage4.BillMail)
-	return &stage4, err
+	stage4.Items, err = r.getBillItems(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	stage4.Notes, err = jobBillNotes(ctx, r.db, jobID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &stage4, nil
+}
+
+func (r *PipelineRepository) getBillItems(ctx context.Context, jobID int) ([]models.BillItem, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, job_id, line_no, description, sac, gst_rate, taxable_amount,
+			   cgst_amount, sgst_amount, igst_amount, total_amount
+		FROM bill_items WHERE job_id = ? ORDER BY line_no
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.BillItem{}
+	for rows.Next() {
+		var item models.BillItem
+		err := rows.Scan(&item.ID, &item.JobID, &item.LineNo, &item.Description, &item.SAC,
+			&item.GSTRate, &item.TaxableAmount, &item.CGSTAmount, &item.SGSTAmount,
+			&item.IGSTAmount, &item.TotalAmount)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
 }
 
-func (r *PipelineRepository) getJobUpdates(jobID int) ([]models.JobUpdate, error) {
+func (r *PipelineRepository) getJobUpdates(ctx context.Context, jobID int) ([]models.JobUpdate, error) {
 	query := `
 		SELECT id, job_id, user_id, stage, update_type, message, old_value, new_value, created_at
 		FROM job_updates WHERE job_id = ? ORDER BY created_at DESC
 	`
 
-	rows, err := r.db.Query(query, jobID)
+	rows, err := r.db.QueryContext(ctx, query, jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -926,6 +1090,13 @@ func parseDateTime(dateStr string) interface{} {
 	return date
 }
 
+func nullString(val string) interface{} {
+	if val == "" {
+		return nil
+	}
+	return val
+}
+
 func nullInt(val int) interface{} {
 	if val == 0 {
 		return nil
@@ -934,13 +1105,13 @@ func nullInt(val int) interface{} {
 }
 
 // File upload methods
-func (r *PipelineRepository) UploadFile(jobID int, stage string, uploadedBy int, fileName, originalName, filePath string, fileSize int64, fileType, description string) (*models.JobFile, error) {
+func (r *PipelineRepository) UploadFile(ctx context.Context, jobID int, stage string, uploadedBy int, fileName, originalName, filePath string, fileSize int64, fileType, description string) (*models.JobFile, error) {
 	query := `
 		INSERT INTO job_files (job_id, stage, uploaded_by, file_name, original_name, file_path, file_size, file_type, description)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
-	result, err := r.db.Exec(query, jobID, stage, uploadedBy, fileName, originalName, filePath, fileSize, fileType, description)
+	result, err := r.db.ExecContext(ctx, query, jobID, stage, uploadedBy, fileName, originalName, filePath, fileSize, fileType, description)
 	if err != nil {
 		return nil, err
 	}
@@ -951,18 +1122,18 @@ func (r *PipelineRepository) UploadFile(jobID int, stage string, uploadedBy int,
 	}
 	
 	// Add job update for file upload
-	_, err = r.db.Exec(`
+	_, err = r.db.ExecContext(ctx, `
 		INSERT INTO job_updates (job_id, user_id, stage, update_type, message, new_value)
 		VALUES (?, ?, ?, 'file_upload', ?, ?)
 	`, jobID, uploadedBy, stage, fmt.Sprintf("File uploaded: %s", originalName), fileName)
 	if err != nil {
-		log.Printf("Error adding file upload update: %v", err)
+		slog.ErrorContext(ctx, "Error adding file upload update", "job_id", jobID, "error", err)
 	}
 	
-	return r.GetFileByID(int(fileID))
+	return r.GetFileByID(ctx, int(fileID))
 }
 
-func (r *PipelineRepository) GetFilesByJobAndStage(jobID int, stage string) ([]models.JobFile, error) {
+func (r *PipelineRepository) GetFilesByJobAndStage(ctx context.Context, jobID int, stage string) ([]models.JobFile, error) {
 	query := `
 		SELECT jf.id, jf.job_id, jf.stage, jf.uploaded_by, jf.file_name, jf.original_name, 
 		       jf.file_path, jf.file_size, jf.file_type, jf.description, jf.created_at,
@@ -973,7 +1144,7 @@ func (r *PipelineRepository) GetFilesByJobAndStage(jobID int, stage string) ([]m
 		ORDER BY jf.created_at DESC
 	`
 	
-	rows, err := r.db.Query(query, jobID, stage)
+	rows, err := r.db.QueryContext(ctx, query, jobID, stage)
 	if err != nil {
 		return nil, err
 	}
@@ -1003,7 +1174,7 @@ func (r *PipelineRepository) GetFilesByJobAndStage(jobID int, stage string) ([]m
 	return files, nil
 }
 
-func (r *PipelineRepository) GetFileByID(fileID int) (*models.JobFile, error) {
+func (r *PipelineRepository) GetFileByID(ctx context.Context, fileID int) (*models.JobFile, error) {
 	query := `
 		SELECT jf.id, jf.job_id, jf.stage, jf.uploaded_by, jf.file_name, jf.original_name, 
 		       jf.file_path, jf.file_size, jf.file_type, jf.description, jf.created_at,
@@ -1016,13 +1187,13 @@ func (r *PipelineRepository) GetFileByID(fileID int) (*models.JobFile, error) {
 	var file models.JobFile
 	var uploadedByUser sql.NullString
 	
-	err := r.db.QueryRow(query, fileID).Scan(
+	err := r.db.QueryRowContext(ctx, query, fileID).Scan(
 		&file.ID, &file.JobID, &file.Stage, &file.UploadedBy, &file.FileName, &file.OriginalName,
 		&file.FilePath, &file.FileSize, &file.FileType, &file.Description, &file.CreatedAt,
 		&uploadedByUser,
 	)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	
 	if uploadedByUser.Valid {
@@ -1032,9 +1203,9 @@ func (r *PipelineRepository) GetFileByID(fileID int) (*models.JobFile, error) {
 	return &file, nil
 }
 
-func (r *PipelineRepository) DeleteFile(fileID int, userID int) error {
+func (r *PipelineRepository) DeleteFile(ctx context.Context, fileID int, userID int) error {
 	// First check if user has permission to delete this file
-	file, err := r.GetFileByID(fileID)
+	file, err := r.GetFileByID(ctx, fileID)
 	if err != nil {
 		return err
 	}
@@ -1042,10 +1213,10 @@ func (r *PipelineRepository) DeleteFile(fileID int, userID int) error {
 	// Only allow deletion if user uploaded the file or is admin
 	// For now, we'll allow the uploader to delete their own files
 	if file.UploadedBy != userID {
-		return fmt.Errorf("unauthorized to delete this file")
+		return ErrNotOwner
 	}
 	
 	query := `DELETE FROM job_files WHERE id = ?`
-	_, err = r.db.Exec(query, fileID)
+	_, err = r.db.ExecContext(ctx, query, fileID)
 	return err
 } 
\ No newline at end of file
@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+
+	"maydiv-crm/internal/models"
+)
+
+// settledTolerance is how little may be left on a bill for it to count as
+// settled: half a paisa of rounding
+const settledTolerance = 0.005
+
+// ReceivableRepository handles payments against bills and customer advances
+type ReceivableRepository struct {
+	db *sql.DB
+}
+
+// NewReceivableRepository creates a new receivable repository
+func NewReceivableRepository(db *sql.DB) *ReceivableRepository {
+	return &ReceivableRepository{db: db}
+}
+
+// BillFilter narrows the bills listed. Zero values match every bill. A
+// Customer matches the consignee by CustomerKey, or by the party the name
+// resolves to.
+type BillFilter struct {
+	JobID    int
+	Customer string
+	PartyID  int
+	OpenOnly bool
+}
+
+// Bills lists the bills of jobs that are not cancelled with what has been
+// paid against them, oldest first. A job has a bill once its stage 4 has a
+// bill number, date and total. The amount billed is net of the bill's
+// approved credit and debit notes. Bills of a consignee linked to a party
+// carry the party's billing email and credit days, when it has them.
+func (r *ReceivableRepository) Bills(ctx context.Context, filter BillFilter) ([]models.Receivable, error) {
+	query := `
+		SELECT pj.id, pj.job_no, pj.current_stage, COALESCE(s1.consignee, ''), s4.bill_no, s4.bill_date,
+			   ` + netBillAmount + `, COALESCE(p.received, 0), COALESCE(p.adjusted, 0), COALESCE(p.tds, 0),
+			   COALESCE(pt.billing_email, pj.notification_email, s4.bill_mail), pt.credit_days
+		FROM stage4_data s4
+		JOIN pipeline_jobs pj ON pj.id = s4.job_id
+		LEFT JOIN stage1_data s1 ON s1.job_id = pj.id
+		LEFT JOIN parties pt ON pt.id = s1.consignee_party_id
+		LEFT JOIN (
+			SELECT job_id,
+				   SUM(CASE WHEN mode <> 'advance' THEN amount ELSE 0 END) AS received,
+				   SUM(CASE WHEN mode = 'advance' THEN amount ELSE 0 END) AS adjusted,
+				   SUM(tds_amount) AS tds
+			FROM payments
+			GROUP BY job_id
+		) p ON p.job_id = pj.id
+		WHERE pj.status <> 'cancelled' AND s4.bill_no IS NOT NULL AND s4.bill_no <> ''
+			AND s4.bill_date IS NOT NULL AND s4.total_amount > 0`
+	var args []interface{}
+	if filter.JobID != 0 {
+		query += ` AND pj.id = ?`
+		args = append(args, filter.JobID)
+	}
+	if filter.Customer != "" {
+		partyID, err := resolveParty(ctx, r.db, filter.Customer)
+		if err != nil {
+			return nil, err
+		}
+		query += ` AND (s1.consignee_key = ? OR s1.consignee_party_id = ?)`
+		args = append(args, CustomerKey(filter.Customer), partyID)
+	}
+	if filter.PartyID != 0 {
+		query += ` AND s1.consignee_party_id = ?`
+		args = append(args, filter.PartyID)
+	}
+	if filter.OpenOnly {
+		query += ` AND ` + netBillAmount + ` - COALESCE(p.received + p.adjusted + p.tds, 0) > ?`
+		args = append(args, settledTolerance)
+	}
+	query += ` ORDER BY s4.bill_date, pj.job_no`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bills := []models.Receivable{}
+	for rows.Next() {
+		var b models.Receivable
+		err := rows.Scan(&b.JobID, &b.JobNo, &b.CurrentStage, &b.Customer, &b.BillNo, &b.BillDate,
+			&b.BillAmount, &b.Received, &b.AdvanceAdjusted, &b.TDS, &b.Email, &b.CreditDays)
+		if err != nil {
+			return nil, err
+		}
+		bills = append(bills, b)
+	}
+	return bills, rows.Err()
+}
+
+// Payments lists the payments against a job's bill in the order they were
+// made
+func (r *ReceivableRepository) Payments(ctx context.Context, jobID int) ([]models.Payment, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, job_id, payment_date, amount, tds_amount, mode, advance_id,
+			   reference_no, remarks, created_by, created_at
+		FROM payments
+		WHERE job_id = ?
+		ORDER BY payment_date, id
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.JobID, &p.PaymentDate, &p.Amount, &p.TDSAmount, &p.Mode, &p.AdvanceID,
+			&p.ReferenceNo, &p.Remarks, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// AddPayment records a payment against a job's bill. check is called with
+// the bill's outstanding balance and the advance drawn from, if any, while
+// both are locked, and the payment is not recorded if it returns an error.
+// A payment that settles the bill closes the job.
+func (r *ReceivableRepository) AddPayment(ctx context.Context, p *models.Payment, check func(outstanding float64, advance *models.Advance) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var outstanding float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT `+netBillAmount+` - COALESCE((SELECT SUM(amount + tds_amount) FROM payments WHERE job_id = s4.job_id), 0)
+		FROM stage4_data s4
+		WHERE s4.job_id = ? AND s4.total_amount IS NOT NULL
+		FOR UPDATE
+	`, p.JobID).Scan(&outstanding)
+	if err != nil {
+		return translate(err)
+	}
+
+	var advance *models.Advance
+	if p.AdvanceID != nil {
+		advance, err = queryAdvance(ctx, tx, *p.AdvanceID, true)
+		if err != nil {
+			return err
+		}
+	}
+	if err := check(outstanding, advance); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO payments (job_id, payment_date, amount, tds_amount, mode, advance_id, reference_no, remarks, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.JobID, p.PaymentDate, p.Amount, p.TDSAmount, p.Mode, p.AdvanceID, p.ReferenceNo, p.Remarks, p.CreatedBy)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, p.JobID, p.CreatedBy, fmt.Sprintf("Payment of %.2f recorded (TDS %.2f)", p.Amount, p.TDSAmount))
+	if err != nil {
+		return err
+	}
+	if err := settleJob(ctx, tx, p.JobID, *p.CreatedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePayment removes a payment recorded in error. A closed job whose
+// bill is no longer settled is reopened.
+func (r *ReceivableRepository) DeletePayment(ctx context.Context, jobID, paymentID, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM payments WHERE id = ? AND job_id = ?`, paymentID, jobID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message)
+		VALUES (?, ?, 'stage4', 'data_update', ?)
+	`, jobID, userID, fmt.Sprintf("Payment %d deleted", paymentID))
+	if err != nil {
+		return err
+	}
+	if err := settleJob(ctx, tx, jobID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// settleJob closes a job in stage 4 or completed once its bill, net of
+// credit and debit notes, is settled, and reopens a closed job whose bill
+// no longer is
+func settleJob(ctx context.Context, tx *sql.Tx, jobID, userID int) error {
+	var stage string
+	var total sql.NullFloat64
+	var acknowledged bool
+	var paid float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT pj.current_stage, `+netBillAmount+`, s4.acknowledge_date IS NOT NULL,
+			   COALESCE((SELECT SUM(amount + tds_amount) FROM payments WHERE job_id = pj.id), 0)
+		FROM pipeline_jobs pj
+		LEFT JOIN stage4_data s4 ON s4.job_id = pj.id
+		WHERE pj.id = ?
+		FOR UPDATE
+	`, jobID).Scan(&stage, &total, &acknowledged, &paid)
+	if err != nil {
+		return translate(err)
+	}
+
+	settled := total.Valid && total.Float64 > 0 && total.Float64-paid <= settledTolerance
+	newStage := stage
+	switch {
+	case settled && (stage == "stage4" || stage == "completed"):
+		newStage = "closed"
+	case !settled && stage == "closed" && acknowledged:
+		newStage = "completed"
+	case !settled && stage == "closed":
+		newStage = "stage4"
+	}
+	if newStage == stage {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE pipeline_jobs SET current_stage = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, newStage, jobID); err != nil {
+		return err
+	}
+	message := "Bill settled; job closed"
+	if stage == "closed" {
+		message = "Bill no longer settled; job reopened"
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_updates (job_id, user_id, stage, update_type, message, old_value, new_value)
+		VALUES (?, ?, 'stage4', 'status_change', ?, ?, ?)
+	`, jobID, userID, message, stage, newStage)
+	return err
+}
+
+// Advances lists customer advances with what is left of them, all of them
+// or one customer's, matched by CustomerKey
+func (r *ReceivableRepository) Advances(ctx context.Context, customer string) ([]models.Advance, error) {
+	query := `SELECT ` + advanceColumns + ` FROM customer_advances a`
+	var args []interface{}
+	if customer != "" {
+		query += ` WHERE a.customer_key = ?`
+		args = append(args, CustomerKey(customer))
+	}
+	query += ` ORDER BY a.customer, a.received_date, a.id`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	advances := []models.Advance{}
+	for rows.Next() {
+		a, err := scanAdvance(rows)
+		if err != nil {
+			return nil, err
+		}
+		advances = append(advances, *a)
+	}
+	return advances, rows.Err()
+}
+
+// GetAdvance retrieves a customer advance
+func (r *ReceivableRepository) GetAdvance(ctx context.Context, id int) (*models.Advance, error) {
+	return queryAdvance(ctx, r.db, id, false)
+}
+
+// CreateAdvance records an advance received and returns its ID
+func (r *ReceivableRepository) CreateAdvance(ctx context.Context, a *models.Advance) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO customer_advances (customer, customer_key, amount, received_date, reference_no, remarks, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, a.Customer, CustomerKey(a.Customer), a.Amount, a.ReceivedDate, a.ReferenceNo, a.Remarks, a.CreatedBy)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+const advanceColumns = `a.id, a.customer, a.amount,
+	COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.advance_id = a.id), 0),
+	a.received_date, a.reference_no, a.remarks, a.created_by, a.created_at`
+
+func queryAdvance(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, id int, lock bool) (*models.Advance, error) {
+	query := `SELECT ` + advanceColumns + ` FROM customer_advances a WHERE a.id = ?`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	a, err := scanAdvance(db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return a, nil
+}
+
+func scanAdvance(row interface{ Scan(...interface{}) error }) (*models.Advance, error) {
+	var a models.Advance
+	err := row.Scan(&a.ID, &a.Customer, &a.Amount, &a.Adjusted, &a.ReceivedDate,
+		&a.ReferenceNo, &a.Remarks, &a.CreatedBy, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.Balance = math.Round((a.Amount-a.Adjusted)*100) / 100
+	return &a, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SettingsRepository stores named settings edited from the admin screens,
+// each as a JSON document
+type SettingsRepository struct {
+	db *sql.DB
+}
+
+// NewSettingsRepository creates a new settings repository
+func NewSettingsRepository(db *sql.DB) *SettingsRepository {
+	return &SettingsRepository{db: db}
+}
+
+// Get decodes the named setting into v and returns who last saved it and
+// when; ErrNotFound means it was never saved
+func (r *SettingsRepository) Get(ctx context.Context, name string, v interface{}) (updatedBy int, updatedAt time.Time, err error) {
+	var value []byte
+	err = r.db.QueryRowContext(ctx, `
+		SELECT value, updated_by, updated_at FROM settings WHERE name = ?
+	`, name).Scan(&value, &updatedBy, &updatedAt)
+	if err != nil {
+		return 0, time.Time{}, translate(err)
+	}
+	return updatedBy, updatedAt, json.Unmarshal(value, v)
+}
+
+// Put saves the named setting
+func (r *SettingsRepository) Put(ctx context.Context, name string, v interface{}, userID int) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO settings (name, value, updated_by) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE value = VALUES(value), updated_by = VALUES(updated_by)
+	`, name, value, userID)
+	return err
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+
 	"maydiv-crm/internal/models"
 )
 
@@ -15,39 +16,42 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// userColumns selects a user with their organization membership, if any
+const userColumns = `SELECT u.id, u.username, u.password_hash, u.designation, u.is_admin, u.role,
+	pm.party_id, COALESCE(pm.org_admin, FALSE), pm.stages
+	FROM users u LEFT JOIN party_members pm ON pm.user_id = u.id`
+
 // GetByUsername retrieves a user by username
 func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
-	user := &models.User{}
-	err := r.db.QueryRow(
-		"SELECT id, username, password_hash, designation, is_admin, role FROM users WHERE username = ?",
-		username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Designation, &user.IsAdmin, &user.Role)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	return user, nil
+	return scanUser(r.db.QueryRow(userColumns+" WHERE u.username = ?", username))
 }
 
 // GetByID retrieves a user by ID
 func (r *UserRepository) GetByID(id int) (*models.User, error) {
+	return scanUser(r.db.QueryRow(userColumns+" WHERE u.id = ?", id))
+}
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
 	user := &models.User{}
-	err := r.db.QueryRow(
-		"SELECT id, username, password_hash, designation, is_admin, role FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Designation, &user.IsAdmin, &user.Role)
-	
+	var partyID sql.NullInt64
+	var stages sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Designation, &user.IsAdmin, &user.Role,
+		&partyID, &user.OrgAdmin, &stages)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
-	
+	if partyID.Valid {
+		id := int(partyID.Int64)
+		user.PartyID = &id
+	}
+	user.Stages = splitStages(stages)
 	return user, nil
 }
 
 // GetAll retrieves all users
 func (r *UserRepository) GetAll() ([]models.UserResponse, error) {
-	rows, err := r.db.Query("SELECT id, username, designation, is_admin, role FROM users")
+	rows, err := r.db.Query(`SELECT u.id, u.username, u.designation, u.is_admin, u.role, pm.party_id
+		FROM users u LEFT JOIN party_members pm ON pm.user_id = u.id`)
 	if err != nil {
 		return nil, err
 	}
@@ -56,9 +60,14 @@ func (r *UserRepository) GetAll() ([]models.UserResponse, error) {
 	var users []models.UserResponse
 	for rows.Next() {
 		var user models.UserResponse
-		if err := rows.Scan(&user.ID, &user.Username, &user.Designation, &user.IsAdmin, &user.Role); err != nil {
+		var partyID sql.NullInt64
+		if err := rows.Scan(&user.ID, &user.Username, &user.Designation, &user.IsAdmin, &user.Role, &partyID); err != nil {
 			continue
 		}
+		if partyID.Valid {
+			id := int(partyID.Int64)
+			user.PartyID = &id
+		}
 		users = append(users, user)
 	}
 	
@@ -71,7 +80,7 @@ func (r *UserRepository) Create(user *models.UserCreate) error {
 		"INSERT INTO users (username, password_hash, designation, is_admin, role) VALUES (?, ?, ?, ?, ?)",
 		user.Username, user.PasswordHash, user.Designation, user.IsAdmin, user.Role,
 	)
-	return err
+	return translate(err)
 }
 
 // Update updates an existing user
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"errors"
+
 	"maydiv-crm/internal/models"
 	"maydiv-crm/internal/repository"
 )
@@ -18,6 +20,9 @@ func NewAuthService(userRepo *repository.UserRepository) *AuthService {
 // Authenticate validates user credentials
 func (s *AuthService) Authenticate(credentials *models.UserLogin) (*models.User, error) {
 	user, err := s.userRepo.GetByUsername(credentials.Username)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// totalsTolerance is how far, per bill line, a total entered by hand may
+// be from the computed one: a paisa of rounding
+const totalsTolerance = 0.01
+
+// BillingService computes the GST on stage 4 bills and saves them
+type BillingService struct {
+	pipelineRepo *repository.PipelineRepository
+	company      config.CompanyConfig
+}
+
+// NewBillingService creates a new billing service
+func NewBillingService(pipelineRepo *repository.PipelineRepository, company config.CompanyConfig) *BillingService {
+	return &BillingService{
+		pipelineRepo: pipelineRepo,
+		company:      company,
+	}
+}
+
+// SaveStage4 computes the bill in a stage 4 update and saves it. The
+// place of supply is the one requested, else the state of the consignee's
+// GSTIN, else our own state. Totals in the request that disagree with the
+// computed ones are rejected unless an admin overrides them. Once the bill
+// is issued, even while the request is handled, only its delivery is
+// saved, and a request changing the bill is rejected.
+func (s *BillingService) SaveStage4(ctx context.Context, jobID int, req *models.Stage4UpdateRequest, userID int, isAdmin bool) error {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Stage4 != nil && job.Stage4.IssuedAt != nil {
+		if errs := issuedBillChanges(job.Stage4, req); len(errs) > 0 {
+			return errs
+		}
+		return s.pipelineRepo.UpdateStage4Data(ctx, jobID, req, nil, userID)
+	}
+	bill, err := s.Compute(job, req, isAdmin)
+	if err != nil {
+		return err
+	}
+	err = s.pipelineRepo.UpdateStage4Data(ctx, jobID, req, bill, userID)
+	if errors.Is(err, repository.ErrBillIssued) {
+		// Issued meanwhile: check the request against the issued bill
+		return s.SaveStage4(ctx, jobID, req, userID, isAdmin)
+	}
+	return err
+}
+
+// Compute works out the items and totals a stage 4 update saves for job.
+// A request without items is billed from its totals: the tax at 5% as
+// transport and the tax at 18% as customs clearance.
+func (s *BillingService) Compute(job *models.PipelineJobResponse, req *models.Stage4UpdateRequest, isAdmin bool) (*models.BillTotals, error) {
+	var errs validation.Errors
+	if req.OverrideTotals && !isAdmin {
+		errs.Add("override_totals", "only an admin can override computed totals")
+		return nil, errs
+	}
+
+	pos := tradeid.Normalize(req.PlaceOfSupply)
+	if pos == "" && job.Stage1 != nil && job.Stage1.ConsigneeGSTIN != nil {
+		if state := tradeid.Normalize(*job.Stage1.ConsigneeGSTIN); len(state) >= 2 {
+			pos = state[:2]
+		}
+	}
+	ownState := ""
+	if gstin := tradeid.Normalize(s.company.GSTIN); len(gstin) >= 2 {
+		ownState = gstin[:2]
+	}
+	if pos == "" {
+		pos = ownState
+	}
+	if _, ok := tradeid.States[pos]; pos != "" && !ok {
+		errs.Add("place_of_supply", fmt.Sprintf("%s is not a GST state code", pos))
+		return nil, errs
+	}
+
+	lines := make([]gst.Line, len(req.Items))
+	for i, item := range req.Items {
+		lines[i] = gst.Line{
+			Description: item.Description,
+			SAC:         item.SAC,
+			Rate:        item.GSTRate,
+			Taxable:     item.TaxableAmount,
+		}
+	}
+	if len(lines) == 0 && (req.AmountTaxable > 0 || req.GST5Percent > 0 || req.GST18Percent > 0) {
+		derived, err := gst.LinesFromTotals(req.AmountTaxable, req.GST5Percent, req.GST18Percent)
+		if err != nil {
+			if req.OverrideTotals {
+				return overridden(gst.Compute(ownState, pos, nil), req), nil
+			}
+			errs.Add("amount_taxable", err.Error())
+			return nil, errs
+		}
+		lines = derived
+	}
+	return s.check(ownState, pos, lines, req)
+}
+
+// check computes the bill from lines and compares it with the totals in
+// the request, which may be left at zero
+func (s *BillingService) check(ownState, pos string, lines []gst.Line, req *models.Stage4UpdateRequest) (*models.BillTotals, error) {
+	if len(lines) > 0 && ownState == "" {
+		return nil, errors.New("computing GST: the company GSTIN is not configured")
+	}
+	bill := gst.Compute(ownState, pos, lines)
+
+	var errs validation.Errors
+	tolerance := totalsTolerance * float64(len(lines))
+	compare := func(field string, entered, computed float64) {
+		if entered != 0 && math.Abs(entered-computed) > tolerance+1e-9 {
+			errs.Add(field, fmt.Sprintf("is %.2f but the items come to %.2f", entered, computed))
+		}
+	}
+	compare("amount_taxable", req.AmountTaxable, bill.Taxable)
+	compare("gst_5_percent", req.GST5Percent, bill.TaxAt(5))
+	compare("gst_18_percent", req.GST18Percent, bill.TaxAt(18))
+	if len(errs) > 0 {
+		if req.OverrideTotals {
+			return overridden(bill, req), nil
+		}
+		return nil, errs
+	}
+
+	totals := &models.BillTotals{
+		PlaceOfSupply: pos,
+		Items:         billItems(bill),
+		AmountTaxable: bill.Taxable,
+		GST5Percent:   bill.TaxAt(5),
+		GST18Percent:  bill.TaxAt(18),
+		CGSTAmount:    bill.CGST,
+		SGSTAmount:    bill.SGST,
+		IGSTAmount:    bill.IGST,
+		RoundOff:      bill.RoundOff,
+		TotalAmount:   bill.Total,
+	}
+	return totals, nil
+}
+
+// overridden keeps the totals an admin entered by hand in place of the
+// computed ones, splitting their tax by the bill's place of supply
+func overridden(computed *gst.Bill, req *models.Stage4UpdateRequest) *models.BillTotals {
+	taxable, tax5, tax18 := computed.Taxable, computed.TaxAt(5), computed.TaxAt(18)
+	otherTax := computed.Tax() - tax5 - tax18
+	if req.AmountTaxable != 0 {
+		taxable = req.AmountTaxable
+	}
+	if req.GST5Percent != 0 {
+		tax5 = req.GST5Percent
+	}
+	if req.GST18Percent != 0 {
+		tax18 = req.GST18Percent
+	}
+
+	bill := gst.Totals(computed.Intrastate, taxable, otherTax+tax5+tax18)
+	return &models.BillTotals{
+		PlaceOfSupply:    computed.PlaceOfSupply,
+		Items:            billItems(computed),
+		AmountTaxable:    bill.Taxable,
+		GST5Percent:      gst.Round(tax5),
+		GST18Percent:     gst.Round(tax18),
+		CGSTAmount:       bill.CGST,
+		SGSTAmount:       bill.SGST,
+		IGSTAmount:       bill.IGST,
+		RoundOff:         bill.RoundOff,
+		TotalAmount:      bill.Total,
+		TotalsOverridden: true,
+	}
+}
+
+// issuedBillChanges lists the fields of a stage 4 update that would change
+// an issued bill. Fields left empty keep the bill as it is.
+func issuedBillChanges(bill *models.Stage4Data, req *models.Stage4UpdateRequest) validation.Errors {
+	var errs validation.Errors
+	msg := fmt.Sprintf("cannot change once bill %s is issued; raise a credit or debit note instead", deref(bill.BillNo))
+	if req.BillNo != "" && req.BillNo != deref(bill.BillNo) {
+		errs.Add("bill_no", msg)
+	}
+	if req.BillDate != "" && (bill.BillDate == nil || req.BillDate != bill.BillDate.Format(validation.DateLayout)) {
+		errs.Add("bill_date", msg)
+	}
+	if req.PlaceOfSupply != "" && tradeid.Normalize(req.PlaceOfSupply) != deref(bill.PlaceOfSupply) {
+		errs.Add("place_of_supply", msg)
+	}
+	if req.OverrideTotals {
+		errs.Add("override_totals", msg)
+	}
+	for _, f := range []struct {
+		field           string
+		entered, stored float64
+	}{
+		{"amount_taxable", req.AmountTaxable, deref(bill.AmountTaxable)},
+		{"gst_5_percent", req.GST5Percent, deref(bill.GST5Percent)},
+		{"gst_18_percent", req.GST18Percent, deref(bill.GST18Percent)},
+	} {
+		if f.entered != 0 && math.Abs(f.entered-f.stored) > totalsTolerance {
+			errs.Add(f.field, msg)
+		}
+	}
+	if len(req.Items) > 0 && !sameItems(req.Items, bill.Items) {
+		errs.Add("items", msg)
+	}
+	return errs
+}
+
+// sameItems reports whether the items requested are those billed
+func sameItems(req []models.BillItemRequest, items []models.BillItem) bool {
+	if len(req) != len(items) {
+		return false
+	}
+	for i, r := range req {
+		item := items[i]
+		if r.Description != item.Description || tradeid.Normalize(r.SAC) != item.SAC || r.GSTRate != item.GSTRate ||
+			math.Abs(r.TaxableAmount-item.TaxableAmount) > totalsTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func billItems(bill *gst.Bill) []models.BillItem {
+	items := make([]models.BillItem, len(bill.Lines))
+	for i, l := range bill.Lines {
+		items[i] = models.BillItem{
+			LineNo:        i + 1,
+			Description:   l.Description,
+			SAC:           l.SAC,
+			GSTRate:       l.Rate,
+			TaxableAmount: l.Taxable,
+			CGSTAmount:    l.CGST,
+			SGSTAmount:    l.SGST,
+			IGSTAmount:    l.IGST,
+			TotalAmount:   l.Total,
+		}
+	}
+	return items
+}
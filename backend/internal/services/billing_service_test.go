@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/validation"
+)
+
+func TestBillingCompute(t *testing.T) {
+	clearance := []models.BillItemRequest{{Description: "Clearance", SAC: "996712", GSTRate: 18, TaxableAmount: 1000}}
+	karnataka := "29AAGCB7383J1Z4"
+
+	tests := []struct {
+		name      string
+		consignee *string
+		req       models.Stage4UpdateRequest
+		isAdmin   bool
+		// fields is the fields rejected; when empty want the totals saved
+		fields     []string
+		pos        string
+		taxable    float64
+		cgst, igst float64
+		total      float64
+		overridden bool
+	}{
+		{
+			name: "within our state", req: models.Stage4UpdateRequest{Items: clearance},
+			pos: "27", taxable: 1000, cgst: 90, total: 1180,
+		},
+		{
+			name: "consignee's state", consignee: &karnataka, req: models.Stage4UpdateRequest{Items: clearance},
+			pos: "29", taxable: 1000, igst: 180, total: 1180,
+		},
+		{
+			name: "requested place of supply", consignee: &karnataka,
+			req: models.Stage4UpdateRequest{Items: clearance, PlaceOfSupply: "07"},
+			pos: "07", taxable: 1000, igst: 180, total: 1180,
+		},
+		{
+			name: "unknown place of supply", req: models.Stage4UpdateRequest{Items: clearance, PlaceOfSupply: "00"},
+			fields: []string{"place_of_supply"},
+		},
+		{
+			name: "matching totals", req: models.Stage4UpdateRequest{Items: clearance, AmountTaxable: 1000, GST18Percent: 180},
+			pos: "27", taxable: 1000, cgst: 90, total: 1180,
+		},
+		{
+			name: "totals within a paisa a line", req: models.Stage4UpdateRequest{Items: clearance, GST18Percent: 180.01},
+			pos: "27", taxable: 1000, cgst: 90, total: 1180,
+		},
+		{
+			name:   "totals that do not match",
+			req:    models.Stage4UpdateRequest{Items: clearance, AmountTaxable: 1100, GST5Percent: 10, GST18Percent: 198},
+			fields: []string{"amount_taxable", "gst_5_percent", "gst_18_percent"},
+		},
+		{
+			name:   "override by a non-admin",
+			req:    models.Stage4UpdateRequest{Items: clearance, AmountTaxable: 1100, OverrideTotals: true},
+			fields: []string{"override_totals"},
+		},
+		{
+			name:    "override by an admin",
+			req:     models.Stage4UpdateRequest{Items: clearance, AmountTaxable: 1100, GST18Percent: 198, OverrideTotals: true},
+			isAdmin: true,
+			pos:     "27", taxable: 1100, cgst: 99, total: 1298, overridden: true,
+		},
+		{
+			name:    "override not needed",
+			req:     models.Stage4UpdateRequest{Items: clearance, AmountTaxable: 1000, OverrideTotals: true},
+			isAdmin: true,
+			pos:     "27", taxable: 1000, cgst: 90, total: 1180,
+		},
+		{
+			name: "billed from totals", req: models.Stage4UpdateRequest{AmountTaxable: 3000, GST5Percent: 50, GST18Percent: 360},
+			pos: "27", taxable: 3000, cgst: 205, total: 3410,
+		},
+		{
+			name:   "totals that do not add up",
+			req:    models.Stage4UpdateRequest{AmountTaxable: 5000, GST5Percent: 50, GST18Percent: 360},
+			fields: []string{"amount_taxable"},
+		},
+		{
+			name:    "totals that do not add up, overridden",
+			req:     models.Stage4UpdateRequest{AmountTaxable: 5000, GST5Percent: 50, GST18Percent: 360, OverrideTotals: true},
+			isAdmin: true,
+			pos:     "27", taxable: 5000, cgst: 205, total: 5410, overridden: true,
+		},
+		{name: "nothing billed yet", req: models.Stage4UpdateRequest{BillMail: "sent"}, pos: "27"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewBillingService(nil, config.CompanyConfig{GSTIN: "27AAPFU0939F1ZV"})
+			job := &models.PipelineJobResponse{Stage1: &models.Stage1Data{ConsigneeGSTIN: tt.consignee}}
+			bill, err := s.Compute(job, &tt.req, tt.isAdmin)
+
+			if len(tt.fields) > 0 {
+				if got := failedFields(t, err); !slices.Equal(got, tt.fields) {
+					t.Errorf("Compute rejected %v, want %v", got, tt.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Compute failed: %v", err)
+			}
+			got := []float64{bill.AmountTaxable, bill.CGSTAmount, bill.SGSTAmount, bill.IGSTAmount, bill.TotalAmount}
+			want := []float64{tt.taxable, tt.cgst, tt.cgst, tt.igst, tt.total}
+			if bill.PlaceOfSupply != tt.pos || !slices.Equal(got, want) || bill.TotalsOverridden != tt.overridden {
+				t.Errorf("Compute = %s %v overridden %v, want %s %v overridden %v",
+					bill.PlaceOfSupply, got, bill.TotalsOverridden, tt.pos, want, tt.overridden)
+			}
+		})
+	}
+}
+
+func TestBillingComputeWithoutCompanyGSTIN(t *testing.T) {
+	s := NewBillingService(nil, config.CompanyConfig{})
+	req := &models.Stage4UpdateRequest{
+		Items:         []models.BillItemRequest{{Description: "Clearance", SAC: "996712", GSTRate: 18, TaxableAmount: 1000}},
+		PlaceOfSupply: "27",
+	}
+	if _, err := s.Compute(&models.PipelineJobResponse{}, req, false); err == nil {
+		t.Error("Compute succeeded without the company GSTIN")
+	}
+}
+
+func TestIssuedBillChanges(t *testing.T) {
+	billNo, pos := "B-101", "27"
+	billDate := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	taxable, tax18 := 1000.0, 180.0
+	bill := &models.Stage4Data{
+		BillNo:        &billNo,
+		BillDate:      &billDate,
+		PlaceOfSupply: &pos,
+		AmountTaxable: &taxable,
+		GST18Percent:  &tax18,
+		Items:         []models.BillItem{{LineNo: 1, Description: "Clearance", SAC: "996712", GSTRate: 18, TaxableAmount: 1000}},
+	}
+	item := models.BillItemRequest{Description: "Clearance", SAC: "9967 12", GSTRate: 18, TaxableAmount: 1000}
+
+	tests := []struct {
+		name   string
+		req    models.Stage4UpdateRequest
+		fields []string
+	}{
+		{name: "delivery only", req: models.Stage4UpdateRequest{BillCourier: "DTDC", AcknowledgeName: "R. Shah"}},
+		{
+			name: "the bill as issued",
+			req: models.Stage4UpdateRequest{
+				BillNo: "B-101", BillDate: "2026-04-01", PlaceOfSupply: "27",
+				AmountTaxable: 1000, GST18Percent: 180.01, Items: []models.BillItemRequest{item},
+			},
+		},
+		{
+			name: "every field changed",
+			req: models.Stage4UpdateRequest{
+				BillNo: "B-102", BillDate: "2026-04-02", PlaceOfSupply: "29", OverrideTotals: true,
+				AmountTaxable: 1100, GST5Percent: 5, GST18Percent: 198,
+				Items: []models.BillItemRequest{item, item},
+			},
+			fields: []string{"bill_no", "bill_date", "place_of_supply", "override_totals", "amount_taxable", "gst_5_percent", "gst_18_percent", "items"},
+		},
+		{
+			name:   "an item's amount changed",
+			req:    models.Stage4UpdateRequest{Items: []models.BillItemRequest{{Description: "Clearance", SAC: "996712", GSTRate: 18, TaxableAmount: 1000.5}}},
+			fields: []string{"items"},
+		},
+		{
+			name:   "an item's rate changed",
+			req:    models.Stage4UpdateRequest{Items: []models.BillItemRequest{{Description: "Clearance", SAC: "996712", GSTRate: 12, TaxableAmount: 1000}}},
+			fields: []string{"items"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, fe := range issuedBillChanges(bill, &tt.req) {
+				got = append(got, fe.Field)
+			}
+			if !slices.Equal(got, tt.fields) {
+				t.Errorf("issuedBillChanges = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+// failedFields returns the fields of the validation.Errors err is
+func failedFields(t *testing.T, err error) []string {
+	t.Helper()
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not a validation error", err)
+	}
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	return fields
+}
+
+func TestBillSent(t *testing.T) {
+	tests := []struct {
+		name string
+		req  models.Stage4UpdateRequest
+		want bool
+	}{
+		{name: "draft", req: models.Stage4UpdateRequest{BillNo: "INV-1"}},
+		{name: "mailed", req: models.Stage4UpdateRequest{BillNo: "INV-1", BillMail: "accounts@acme.in"}, want: true},
+		{name: "couriered", req: models.Stage4UpdateRequest{BillNo: "INV-1", BillCourier: "Blue Dart"}, want: true},
+		{name: "courier date", req: models.Stage4UpdateRequest{BillNo: "INV-1", CourierDate: "2026-04-02"}, want: true},
+		{name: "acknowledged", req: models.Stage4UpdateRequest{BillNo: "INV-1", AcknowledgeDate: "2026-04-05"}, want: true},
+		{name: "mailed without a bill no", req: models.Stage4UpdateRequest{BillMail: "accounts@acme.in"}},
+		{name: "blank mail", req: models.Stage4UpdateRequest{BillNo: "INV-1", BillMail: "  "}},
+	}
+	for _, tt := range tests {
+		if got := tt.req.BillSent(); got != tt.want {
+			t.Errorf("%s: BillSent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/invoice"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// BillNoteService corrects issued bills with credit and debit notes. A note
+// is requested against the bill, numbered once an admin approves it and
+// printed like the tax invoice it corrects.
+type BillNoteService struct {
+	billNoteRepo   *repository.BillNoteRepository
+	pipelineRepo   *repository.PipelineRepository
+	receivableRepo *repository.ReceivableRepository
+	invoiceService *InvoiceService
+	company        config.CompanyConfig
+	billing        config.BillingConfig
+	uploadDir      string
+}
+
+// NewBillNoteService creates a new bill note service
+func NewBillNoteService(billNoteRepo *repository.BillNoteRepository, pipelineRepo *repository.PipelineRepository, receivableRepo *repository.ReceivableRepository, invoiceService *InvoiceService, company config.CompanyConfig, billing config.BillingConfig, uploadDir string) *BillNoteService {
+	return &BillNoteService{
+		billNoteRepo:   billNoteRepo,
+		pipelineRepo:   pipelineRepo,
+		receivableRepo: receivableRepo,
+		invoiceService: invoiceService,
+		company:        company,
+		billing:        billing,
+		uploadDir:      uploadDir,
+	}
+}
+
+// Position returns a job's bill net of its approved notes, what has been
+// paid against it and all of its notes
+func (s *BillNoteService) Position(ctx context.Context, jobID int) (*models.BillPosition, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return s.position(ctx, job)
+}
+
+func (s *BillNoteService) position(ctx context.Context, job *models.PipelineJobResponse) (*models.BillPosition, error) {
+	jobID := job.ID
+	p := &models.BillPosition{JobID: jobID, Notes: []models.BillNote{}}
+	if s4 := job.Stage4; s4 != nil {
+		p.BillNo = deref(s4.BillNo)
+		p.BillDate = s4.BillDate
+		p.IssuedAt = s4.IssuedAt
+		p.Original = deref(s4.TotalAmount)
+		if s4.Notes != nil {
+			p.Notes = s4.Notes
+		}
+	}
+	for _, n := range p.Notes {
+		switch {
+		case n.Status == models.NotePending:
+			p.Pending++
+		case n.Status != models.NoteApproved:
+		case n.Kind == models.DebitNote:
+			p.DebitNotes += n.TotalAmount
+		default:
+			p.CreditNotes += n.TotalAmount
+		}
+	}
+	payments, err := s.receivableRepo.Payments(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pay := range payments {
+		p.Settled += pay.Amount + pay.TDSAmount
+	}
+	p.CreditNotes = gst.Round(p.CreditNotes)
+	p.DebitNotes = gst.Round(p.DebitNotes)
+	p.Net = gst.Round(p.Original - p.CreditNotes + p.DebitNotes)
+	p.Settled = gst.Round(p.Settled)
+	p.Balance = gst.Round(p.Net - p.Settled)
+	return p, nil
+}
+
+// Request records a note against a job's issued bill, awaiting an admin's
+// approval. Its GST is computed from the items at the bill's place of
+// supply, and a credit note may not take more off than the bill's net
+// amount.
+func (s *BillNoteService) Request(ctx context.Context, jobID int, req *models.BillNoteRequest, userID int) (*models.BillNote, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.position(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var errs validation.Errors
+	if p.IssuedAt == nil {
+		errs.Add("kind", "the bill has not been issued yet; correct it in stage 4 instead")
+		return nil, errs
+	}
+
+	ownState := ""
+	if gstin := tradeid.Normalize(s.company.GSTIN); len(gstin) >= 2 {
+		ownState = gstin[:2]
+	}
+	if ownState == "" {
+		return nil, errors.New("computing GST: the company GSTIN is not configured")
+	}
+	pos := deref(job.Stage4.PlaceOfSupply)
+	if pos == "" {
+		pos = ownState
+	}
+	lines := make([]gst.Line, len(req.Items))
+	for i, item := range req.Items {
+		lines[i] = gst.Line{
+			Description: item.Description,
+			SAC:         item.SAC,
+			Rate:        item.GSTRate,
+			Taxable:     item.TaxableAmount,
+		}
+	}
+	bill := gst.Compute(ownState, pos, lines)
+	if req.Kind == models.CreditNote {
+		if err := checkCredit(bill.Total, p.Net); err != nil {
+			return nil, err
+		}
+	}
+
+	n := &models.BillNote{
+		JobID:         jobID,
+		Kind:          req.Kind,
+		Reason:        strings.TrimSpace(req.Reason),
+		PlaceOfSupply: pos,
+		AmountTaxable: bill.Taxable,
+		CGSTAmount:    bill.CGST,
+		SGSTAmount:    bill.SGST,
+		IGSTAmount:    bill.IGST,
+		RoundOff:      bill.RoundOff,
+		TotalAmount:   bill.Total,
+		Items:         billItems(bill),
+		RequestedBy:   userID,
+	}
+	id, err := s.billNoteRepo.CreateNote(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return s.billNoteRepo.GetNote(ctx, jobID, id)
+}
+
+// Approve numbers a pending note in its series for the financial year, dates
+// it today and approves it. A credit note is checked again against the
+// bill's net amount, which other notes may have lowered since it was
+// requested.
+func (s *BillNoteService) Approve(ctx context.Context, jobID, id int, review *models.BillNoteReview, userID int) (*models.BillNote, error) {
+	today := time.Now()
+	check := func(n *models.BillNote, net float64) error {
+		if n.Kind == models.CreditNote {
+			return checkCredit(n.TotalAmount, net)
+		}
+		return nil
+	}
+	prefix := s.billing.CreditNotePrefix
+	if n, err := s.billNoteRepo.GetNote(ctx, jobID, id); err != nil {
+		return nil, err
+	} else if n.Kind == models.DebitNote {
+		prefix = s.billing.DebitNotePrefix
+	}
+	if _, err := s.billNoteRepo.Approve(ctx, jobID, id, userID, review.Remarks, prefix+"/"+financialYear(today), today, check); err != nil {
+		return nil, err
+	}
+	return s.billNoteRepo.GetNote(ctx, jobID, id)
+}
+
+// Reject turns down a pending note
+func (s *BillNoteService) Reject(ctx context.Context, jobID, id int, review *models.BillNoteReview, userID int) (*models.BillNote, error) {
+	if err := s.billNoteRepo.Reject(ctx, jobID, id, userID, review.Remarks); err != nil {
+		return nil, err
+	}
+	return s.billNoteRepo.GetNote(ctx, jobID, id)
+}
+
+// GeneratePDF renders an approved note with the invoice template, stores it
+// with the job's stage 4 files and links it to the note
+func (s *BillNoteService) GeneratePDF(ctx context.Context, jobID, id, userID int) (*models.JobFile, error) {
+	n, err := s.billNoteRepo.GetNote(ctx, jobID, id)
+	if err != nil {
+		return nil, err
+	}
+	if n.Status != models.NoteApproved || n.NoteNo == nil || n.NoteDate == nil {
+		var errs validation.Errors
+		errs.Add("status", "only an approved note can be printed")
+		return nil, errs
+	}
+	data, err := s.invoiceService.Build(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := s.invoiceService.Template(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	title := noteTitle(n.Kind)
+	data.Title = strings.ToUpper(title)
+	data.NumberLabel = title
+	billDate := data.InvoiceDate
+	data.OriginalNo, data.OriginalDate = data.InvoiceNo, &billDate
+	data.InvoiceNo, data.InvoiceDate = *n.NoteNo, *n.NoteDate
+	data.Reason = n.Reason
+	data.PlaceOfSupply = n.PlaceOfSupply
+	data.Items = n.Items
+	data.Taxable, data.CGST, data.SGST, data.IGST = n.AmountTaxable, n.CGSTAmount, n.SGSTAmount, n.IGSTAmount
+	data.RoundOff, data.Total = n.RoundOff, n.TotalAmount
+	data.IRN, data.AckNo, data.AckDate = "", "", nil
+
+	pdf, err := invoice.Render(data, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.uploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating upload directory %s: %w", s.uploadDir, err)
+	}
+	fileName := fmt.Sprintf("%d_stage4_%s_note_%d_%d.pdf", jobID, n.Kind, n.ID, time.Now().Unix())
+	filePath := filepath.Join(s.uploadDir, fileName)
+	if err := os.WriteFile(filePath, pdf, 0644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", filePath, err)
+	}
+
+	name := strings.ReplaceAll(title, " ", "_") + "_" + strings.NewReplacer("/", "-", `"`, "").Replace(*n.NoteNo) + ".pdf"
+	file, err := s.pipelineRepo.UploadFile(ctx, jobID, "stage4", userID, fileName, name, filePath,
+		int64(len(pdf)), "application/pdf", title+" "+*n.NoteNo)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.billNoteRepo.SetFile(ctx, n.ID, file.ID); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// checkCredit rejects a credit note of total against a bill whose net
+// amount is net
+func checkCredit(total, net float64) error {
+	if total > net+paymentTolerance {
+		var errs validation.Errors
+		errs.Add("items", fmt.Sprintf("credit of %.2f is more than the bill's net amount of %.2f", total, net))
+		return errs
+	}
+	return nil
+}
+
+// financialYear names the Indian financial year, April to March, that t
+// falls in, such as 25-26
+func financialYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%02d-%02d", start%100, (start+1)%100)
+}
+
+// noteTitle names a note kind on its PDF
+func noteTitle(kind string) string {
+	if kind == models.DebitNote {
+		return "Debit Note"
+	}
+	return "Credit Note"
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckCredit(t *testing.T) {
+	tests := []struct {
+		total, net float64
+		ok         bool
+	}{
+		{total: 500, net: 1180, ok: true},
+		{total: 1180, net: 1180, ok: true},
+		{total: 1180.004, net: 1180, ok: true},
+		{total: 1180.01, net: 1180},
+		{total: 1, net: 0},
+	}
+	for _, tt := range tests {
+		err := checkCredit(tt.total, tt.net)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkCredit(%v, %v) = %v, want ok %v", tt.total, tt.net, err, tt.ok)
+		}
+		if err != nil {
+			if fields := failedFields(t, err); len(fields) != 1 || fields[0] != "items" {
+				t.Errorf("checkCredit rejected %v, want items", fields)
+			}
+		}
+	}
+}
+
+func TestFinancialYear(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 31, 23, 59, 59, 0, ist), "25-26"},
+		{time.Date(2026, 4, 1, 0, 0, 0, 0, ist), "26-27"},
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "25-26"},
+		{time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), "26-27"},
+		{time.Date(2099, 4, 1, 0, 0, 0, 0, time.UTC), "99-00"},
+		{time.Date(2100, 2, 1, 0, 0, 0, 0, time.UTC), "99-00"},
+	}
+	for _, tt := range tests {
+		if got := financialYear(tt.date); got != tt.want {
+			t.Errorf("financialYear(%s) = %s, want %s", tt.date.Format(time.DateTime), got, tt.want)
+		}
+	}
+}
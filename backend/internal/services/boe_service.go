@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"maydiv-crm/internal/boe"
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+)
+
+// BillOfEntryDescription marks the stage 2 job files that hold generated
+// Bill of Entry flat files
+const BillOfEntryDescription = "Bill of Entry flat file"
+
+// BillOfEntryService generates Bill of Entry flat files for jobs
+type BillOfEntryService struct {
+	pipelineRepo *repository.PipelineRepository
+	dutyRepo     *repository.DutyRepository
+	masterRepo   *repository.MasterRepository
+	company      config.CompanyConfig
+	uploadDir    string
+}
+
+// NewBillOfEntryService creates a new Bill of Entry service
+func NewBillOfEntryService(pipelineRepo *repository.PipelineRepository, dutyRepo *repository.DutyRepository, masterRepo *repository.MasterRepository, company config.CompanyConfig, uploadDir string) *BillOfEntryService {
+	return &BillOfEntryService{
+		pipelineRepo: pipelineRepo,
+		dutyRepo:     dutyRepo,
+		masterRepo:   masterRepo,
+		company:      company,
+		uploadDir:    uploadDir,
+	}
+}
+
+// Build returns the flat file for a job and its file name, or
+// validation.Errors listing every missing mandatory field
+func (s *BillOfEntryService) Build(ctx context.Context, jobID int) ([]byte, string, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, "", err
+	}
+	calculations, err := s.dutyRepo.ListCalculations(ctx, jobID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	in := boe.Input{
+		Job: job,
+		Sender: boe.Sender{
+			CHACode:   s.company.CHACode,
+			ICEGATEID: s.company.ICEGATEID,
+		},
+		Now: time.Now(),
+	}
+	if len(calculations) > 0 {
+		in.Duty = &calculations[0]
+	}
+	if s1 := job.Stage1; s1 != nil {
+		if in.Places.PortOfDischarge, err = s.linkedMaster(ctx, models.MasterPort, s1.PortOfDischargeID); err != nil {
+			return nil, "", err
+		}
+		if in.Places.PortOfLoading, err = s.linkedMaster(ctx, models.MasterPort, s1.PortOfLoadingID); err != nil {
+			return nil, "", err
+		}
+		if in.Places.Country, err = s.linkedMaster(ctx, models.MasterCountry, s1.CountryOfShipmentID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	data, err := boe.Generate(in)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, boe.FileName(job.JobNo), nil
+}
+
+// linkedMaster returns the master a job field is linked to, or nil
+func (s *BillOfEntryService) linkedMaster(ctx context.Context, kind string, id *int) (*models.Master, error) {
+	if id == nil {
+		return nil, nil
+	}
+	m, err := s.masterRepo.Get(ctx, kind, *id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// Generate builds the flat file for a job, stores it with the job's
+// stage 2 files and returns the new file record
+func (s *BillOfEntryService) Generate(ctx context.Context, jobID, userID int) (*models.JobFile, error) {
+	data, name, err := s.Build(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.uploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating upload directory %s: %w", s.uploadDir, err)
+	}
+	fileName := fmt.Sprintf("%d_stage2_boe_%d.be", jobID, time.Now().Unix())
+	filePath := filepath.Join(s.uploadDir, fileName)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", filePath, err)
+	}
+
+	return s.pipelineRepo.UploadFile(ctx, jobID, "stage2", userID, fileName, name, filePath,
+		int64(len(data)), "text/plain", BillOfEntryDescription)
+}
+
+// Latest returns the Bill of Entry last generated for a job
+func (s *BillOfEntryService) Latest(ctx context.Context, jobID int) (*models.JobFile, error) {
+	files, err := s.pipelineRepo.GetFilesByJobAndStage(ctx, jobID, "stage2")
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if files[i].Description != nil && *files[i].Description == BillOfEntryDescription {
+			return &files[i], nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
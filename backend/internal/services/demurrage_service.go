@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// DemurrageService works out the demurrage and detention running on
+// containers from their dates and the shipping lines' tariffs, and alerts
+// jobs whose containers' free time is about to end
+type DemurrageService struct {
+	demurrageRepo *repository.DemurrageRepository
+	emailService  *EmailService
+	demurrage     config.DemurrageConfig
+	adminEmail    string
+}
+
+// NewDemurrageService creates a new demurrage service
+func NewDemurrageService(demurrageRepo *repository.DemurrageRepository, emailService *EmailService, demurrage config.DemurrageConfig, adminEmail string) *DemurrageService {
+	return &DemurrageService{
+		demurrageRepo: demurrageRepo,
+		emailService:  emailService,
+		demurrage:     demurrage,
+		adminEmail:    adminEmail,
+	}
+}
+
+// AlertDays is how many days before free time ends jobs are alerted
+func (s *DemurrageService) AlertDays() int {
+	return s.demurrage.AlertDays
+}
+
+// JobContainers returns a job's containers with their charges on asOf,
+// projected to projectTo
+func (s *DemurrageService) JobContainers(ctx context.Context, jobID int, asOf, projectTo time.Time) ([]models.ContainerDemurrage, error) {
+	containers, err := s.demurrageRepo.Containers(ctx, jobID, false)
+	if err != nil {
+		return nil, err
+	}
+	tariffs, err := s.demurrageRepo.Tariffs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		ChargeContainer(&containers[i], tariffs, asOf, projectTo)
+	}
+	return containers, nil
+}
+
+// Dashboard lists the containers with a clock running whose free time ends
+// within the given days of asOf or has already ended, soonest first, with
+// their charges projected to projectTo
+func (s *DemurrageService) Dashboard(ctx context.Context, asOf, projectTo time.Time, within int) (*models.DemurrageDashboard, error) {
+	containers, err := s.demurrageRepo.Containers(ctx, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	tariffs, err := s.demurrageRepo.Tariffs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &models.DemurrageDashboard{
+		AsOf:        asOf.Format(validation.DateLayout),
+		ProjectedOn: projectTo.Format(validation.DateLayout),
+		Within:      within,
+		Containers:  []models.ContainerDemurrage{},
+		Totals:      []models.DemurrageTotal{},
+	}
+	for _, c := range containers {
+		ChargeContainer(&c, tariffs, asOf, projectTo)
+		if left, ok := daysLeft(&c); ok && left <= within {
+			d.Containers = append(d.Containers, c)
+		}
+	}
+	slices.SortStableFunc(d.Containers, func(a, b models.ContainerDemurrage) int {
+		x, _ := daysLeft(&a)
+		y, _ := daysLeft(&b)
+		return x - y
+	})
+
+	totals := map[string]*models.DemurrageTotal{}
+	for _, c := range d.Containers {
+		for _, clock := range []*models.DemurrageClock{c.Demurrage, c.Detention} {
+			if clock == nil {
+				continue
+			}
+			t := totals[clock.Currency]
+			if t == nil {
+				t = &models.DemurrageTotal{Currency: clock.Currency}
+				totals[clock.Currency] = t
+			}
+			t.Accrued = gst.Round(t.Accrued + clock.Accrued)
+			t.Projected = gst.Round(t.Projected + clock.Projected)
+		}
+	}
+	for _, t := range totals {
+		d.Totals = append(d.Totals, *t)
+	}
+	slices.SortFunc(d.Totals, func(a, b models.DemurrageTotal) int { return strings.Compare(a.Currency, b.Currency) })
+	return d, nil
+}
+
+// SendAlerts emails each job with a container whose free time ends within
+// demurrage.alert_days of asOf, once for each free time, and returns how
+// many emails were sent. Jobs without a notification email alert the
+// admin.
+func (s *DemurrageService) SendAlerts(ctx context.Context, asOf time.Time) (int, error) {
+	d, err := s.Dashboard(ctx, asOf, asOf.AddDate(0, 0, s.demurrage.AlertDays), s.demurrage.AlertDays)
+	if err != nil {
+		return 0, err
+	}
+
+	type jobAlerts struct {
+		c      models.ContainerDemurrage
+		alerts []DemurrageAlert
+	}
+	var jobs []*jobAlerts
+	byJob := map[int]*jobAlerts{}
+	for _, c := range d.Containers {
+		if c.ContainerNo == "" {
+			continue
+		}
+		for _, clock := range []*models.DemurrageClock{c.Demurrage, c.Detention} {
+			if clock == nil || !clock.Running || clock.DaysLeft == nil || *clock.DaysLeft < 0 {
+				continue
+			}
+			sent, err := s.demurrageRepo.AlertSent(ctx, c.JobID, c.ContainerNo, clock.Kind, *clock.FreeUntil)
+			if err != nil {
+				return 0, err
+			}
+			if sent {
+				continue
+			}
+			j := byJob[c.JobID]
+			if j == nil {
+				j = &jobAlerts{c: c}
+				byJob[c.JobID] = j
+				jobs = append(jobs, j)
+			}
+			j.alerts = append(j.alerts, DemurrageAlert{
+				ContainerNo: c.ContainerNo,
+				Kind:        clock.Kind,
+				FreeUntil:   *clock.FreeUntil,
+				DaysLeft:    *clock.DaysLeft,
+				Currency:    clock.Currency,
+				Projected:   clock.Projected,
+			})
+		}
+	}
+
+	sent := 0
+	var errs []error
+	for _, j := range jobs {
+		to := j.c.Email
+		if !validation.IsEmail(to) {
+			to = s.adminEmail
+		}
+		if err := s.emailService.SendDemurrageAlert(ctx, to, j.c.JobNo, j.c.Customer, j.alerts); err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", j.c.JobNo, err))
+			continue
+		}
+		sent++
+		for _, a := range j.alerts {
+			if err := s.demurrageRepo.RecordAlert(ctx, j.c.JobID, a.ContainerNo, a.Kind, a.FreeUntil, to); err != nil {
+				errs = append(errs, fmt.Errorf("recording alert for job %s: %w", j.c.JobNo, err))
+			}
+		}
+		slog.InfoContext(ctx, "Demurrage alert sent", "job_id", j.c.JobID, "job_no", j.c.JobNo, "containers", len(j.alerts))
+	}
+	return sent, errors.Join(errs...)
+}
+
+// Tariffs lists the demurrage and detention tariffs
+func (s *DemurrageService) Tariffs(ctx context.Context) ([]models.DemurrageTariff, error) {
+	return s.demurrageRepo.Tariffs(ctx)
+}
+
+// SaveTariff creates a tariff, or replaces the tariff with the given ID
+// when it is not 0
+func (s *DemurrageService) SaveTariff(ctx context.Context, id int, req *models.DemurrageTariffRequest) (*models.DemurrageTariff, error) {
+	t, err := TariffFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		id, err = s.demurrageRepo.CreateTariff(ctx, t)
+	} else {
+		t.ID = id
+		err = s.demurrageRepo.UpdateTariff(ctx, t)
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		var errs validation.Errors
+		errs.Add("shipping_line", fmt.Sprintf("already has a %s tariff for %s containers", t.Kind, t.ContainerSize))
+		return nil, errs
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.demurrageRepo.GetTariff(ctx, id)
+}
+
+// DeleteTariff removes a tariff
+func (s *DemurrageService) DeleteTariff(ctx context.Context, id int) error {
+	return s.demurrageRepo.DeleteTariff(ctx, id)
+}
+
+// TariffFromRequest validates a tariff request and builds the tariff it
+// describes
+func TariffFromRequest(req *models.DemurrageTariffRequest) (*models.DemurrageTariff, error) {
+	t := &models.DemurrageTariff{
+		ShippingLine:  strings.TrimSpace(req.ShippingLine),
+		ContainerSize: req.ContainerSize,
+		Kind:          req.Kind,
+		FreeDays:      req.FreeDays,
+		Currency:      strings.ToUpper(strings.TrimSpace(req.Currency)),
+		Slabs:         []models.DemurrageSlab{},
+	}
+	if t.Currency == "" {
+		t.Currency = "INR"
+	}
+
+	var errs validation.Errors
+	next := 1
+	for i, s := range req.Slabs {
+		field := fmt.Sprintf("slabs[%d]", i)
+		if s.FromDay != next {
+			errs.Add(field+".from_day", fmt.Sprintf("must be %d, following on from the slab before", next))
+		}
+		last := i == len(req.Slabs)-1
+		switch {
+		case s.ToDay == nil && !last:
+			errs.Add(field+".to_day", "is required on all but the last slab")
+		case s.ToDay != nil && last:
+			errs.Add(field+".to_day", "must be left out on the last slab, which runs on")
+		case s.ToDay != nil && *s.ToDay < s.FromDay:
+			errs.Add(field+".to_day", "must not be before from_day")
+		}
+		if s.ToDay != nil {
+			next = *s.ToDay + 1
+		}
+		t.Slabs = append(t.Slabs, models.DemurrageSlab{FromDay: s.FromDay, ToDay: s.ToDay, Rate: gst.Round(s.Rate)})
+	}
+	return t, errs.Err()
+}
+
+// MatchTariff returns the tariff of kind for a shipping line's containers
+// of a size, preferring the line's own tariff to one for any line, or nil
+// if there is none
+func MatchTariff(tariffs []models.DemurrageTariff, line, size, kind string) *models.DemurrageTariff {
+	var fallback *models.DemurrageTariff
+	for i := range tariffs {
+		t := &tariffs[i]
+		if t.ContainerSize != size || t.Kind != kind {
+			continue
+		}
+		if line != "" && strings.EqualFold(t.ShippingLine, strings.TrimSpace(line)) {
+			return t
+		}
+		if t.ShippingLine == "" {
+			fallback = t
+		}
+	}
+	return fallback
+}
+
+// ChargeContainer works out a container's demurrage, from its date of
+// arrival until it is offloaded, and its detention, from then until its
+// empty is returned
+func ChargeContainer(c *models.ContainerDemurrage, tariffs []models.DemurrageTariff, asOf, projectTo time.Time) {
+	if t := MatchTariff(tariffs, c.ShippingLine, c.Size, models.Demurrage); t != nil {
+		c.Demurrage = Clock(t, c.ArrivalDate, c.OffloadDate, asOf, projectTo)
+	}
+	if t := MatchTariff(tariffs, c.ShippingLine, c.Size, models.Detention); t != nil {
+		c.Detention = Clock(t, c.OffloadDate, c.ReturnDate, asOf, projectTo)
+	}
+}
+
+// Clock works out a charge that started on start and stopped on until, or
+// is still running when until is nil. The day it starts is the first free
+// day. A charge that has not started yet costs nothing.
+func Clock(t *models.DemurrageTariff, start, until *time.Time, asOf, projectTo time.Time) *models.DemurrageClock {
+	c := &models.DemurrageClock{
+		Kind:     t.Kind,
+		TariffID: t.ID,
+		Currency: t.Currency,
+		FreeDays: t.FreeDays,
+		Start:    start,
+		Until:    until,
+	}
+	if start == nil {
+		return c
+	}
+	freeUntil := start.AddDate(0, 0, t.FreeDays-1)
+	c.FreeUntil = &freeUntil
+
+	end := asOf
+	if until != nil {
+		end = *until
+	} else {
+		c.Running = true
+		left := daysBetween(asOf, freeUntil)
+		c.DaysLeft = &left
+	}
+	used := max(daysBetween(*start, end)+1, 0)
+	c.ChargeableDays = max(used-t.FreeDays, 0)
+	c.Accrued = SlabCharge(t.Slabs, c.ChargeableDays)
+	c.Projected = c.Accrued
+	if c.Running {
+		used := max(daysBetween(*start, projectTo)+1, 0)
+		c.Projected = SlabCharge(t.Slabs, max(used-t.FreeDays, 0))
+	}
+	return c
+}
+
+// SlabCharge is the charge for days past the free days, each day at the
+// rate of the slab it falls in
+func SlabCharge(slabs []models.DemurrageSlab, days int) float64 {
+	total := 0.0
+	for _, s := range slabs {
+		to := days
+		if s.ToDay != nil {
+			to = min(*s.ToDay, days)
+		}
+		if n := to - s.FromDay + 1; n > 0 {
+			total += float64(n) * s.Rate
+		}
+	}
+	return gst.Round(total)
+}
+
+// daysLeft is the fewest days left of the free time of a container's
+// running clocks, and false if none is running
+func daysLeft(c *models.ContainerDemurrage) (int, bool) {
+	left, ok := math.MaxInt, false
+	for _, clock := range []*models.DemurrageClock{c.Demurrage, c.Detention} {
+		if clock != nil && clock.Running && clock.DaysLeft != nil {
+			left, ok = min(left, *clock.DaysLeft), true
+		}
+	}
+	return left, ok
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+func intPtr(v int) *int { return &v }
+
+func day(month time.Month, d int) *time.Time {
+	t := time.Date(2026, month, d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+// tieredSlabs charge 100 a day for days 1-5, 200 for days 6-10 and 400
+// after that
+var tieredSlabs = []models.DemurrageSlab{
+	{FromDay: 1, ToDay: intPtr(5), Rate: 100},
+	{FromDay: 6, ToDay: intPtr(10), Rate: 200},
+	{FromDay: 11, Rate: 400},
+}
+
+func TestSlabCharge(t *testing.T) {
+	tests := []struct {
+		name  string
+		slabs []models.DemurrageSlab
+		days  int
+		want  float64
+	}{
+		{name: "within free time", slabs: tieredSlabs, days: 0},
+		{name: "first slab", slabs: tieredSlabs, days: 3, want: 300},
+		{name: "end of the first slab", slabs: tieredSlabs, days: 5, want: 500},
+		{name: "into the second slab", slabs: tieredSlabs, days: 7, want: 900},
+		{name: "into the open slab", slabs: tieredSlabs, days: 12, want: 2300},
+		{name: "flat rate", slabs: []models.DemurrageSlab{{FromDay: 1, Rate: 37.335}}, days: 3, want: 112.01},
+		{name: "no slabs", days: 10},
+	}
+	for _, tt := range tests {
+		if got := SlabCharge(tt.slabs, tt.days); got != tt.want {
+			t.Errorf("%s: SlabCharge(%d days) = %v, want %v", tt.name, tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	tariff := &models.DemurrageTariff{ID: 3, Kind: models.Demurrage, FreeDays: 4, Currency: "USD", Slabs: tieredSlabs}
+	asOf := *day(time.April, 10)
+	projectTo := *day(time.April, 13)
+
+	tests := []struct {
+		name       string
+		start      *time.Time
+		until      *time.Time
+		freeUntil  *time.Time
+		running    bool
+		daysLeft   *int
+		chargeable int
+		accrued    float64
+		projected  float64
+	}{
+		{name: "not started"},
+		{
+			name: "running in free time", start: day(time.April, 9), freeUntil: day(time.April, 12),
+			running: true, daysLeft: intPtr(2), projected: 100,
+		},
+		{
+			name: "free time ends today", start: day(time.April, 7), freeUntil: day(time.April, 10),
+			running: true, daysLeft: intPtr(0), projected: 300,
+		},
+		{
+			name: "running and charged", start: day(time.April, 1), freeUntil: day(time.April, 4),
+			running: true, daysLeft: intPtr(-6), chargeable: 6, accrued: 700, projected: 1300,
+		},
+		{
+			name: "stopped", start: day(time.April, 1), until: day(time.April, 6), freeUntil: day(time.April, 4),
+			chargeable: 2, accrued: 200, projected: 200,
+		},
+		{
+			name: "stopped within free time", start: day(time.April, 1), until: day(time.April, 3), freeUntil: day(time.April, 4),
+		},
+		{
+			name: "starts after today", start: day(time.April, 12), freeUntil: day(time.April, 15),
+			running: true, daysLeft: intPtr(5),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Clock(tariff, tt.start, tt.until, asOf, projectTo)
+			if c.Kind != models.Demurrage || c.TariffID != 3 || c.Currency != "USD" || c.FreeDays != 4 {
+				t.Errorf("clock %+v does not describe its tariff", c)
+			}
+			if !equalDate(c.FreeUntil, tt.freeUntil) || c.Running != tt.running || !equalInt(c.DaysLeft, tt.daysLeft) {
+				t.Errorf("free until %v, running %v, days left %v, want %v, %v, %v",
+					c.FreeUntil, c.Running, c.DaysLeft, tt.freeUntil, tt.running, tt.daysLeft)
+			}
+			if c.ChargeableDays != tt.chargeable || c.Accrued != tt.accrued || c.Projected != tt.projected {
+				t.Errorf("%d days accrued %v projected %v, want %d days accrued %v projected %v",
+					c.ChargeableDays, c.Accrued, c.Projected, tt.chargeable, tt.accrued, tt.projected)
+			}
+		})
+	}
+}
+
+func TestChargeContainer(t *testing.T) {
+	tariffs := []models.DemurrageTariff{
+		{ID: 1, Kind: models.Demurrage, ContainerSize: "20", FreeDays: 4, Currency: "INR", Slabs: tieredSlabs},
+		{ID: 2, ShippingLine: "Maersk", Kind: models.Demurrage, ContainerSize: "20", FreeDays: 7, Currency: "USD", Slabs: tieredSlabs},
+		{ID: 3, Kind: models.Detention, ContainerSize: "20", FreeDays: 10, Currency: "INR", Slabs: tieredSlabs},
+		{ID: 4, Kind: models.Demurrage, ContainerSize: "40", FreeDays: 4, Currency: "INR", Slabs: tieredSlabs},
+	}
+	asOf := *day(time.April, 10)
+
+	c := models.ContainerDemurrage{ShippingLine: " maersk ", Size: "20", ArrivalDate: day(time.April, 1), OffloadDate: day(time.April, 9)}
+	ChargeContainer(&c, tariffs, asOf, asOf)
+	if c.Demurrage == nil || c.Demurrage.TariffID != 2 || c.Demurrage.Running || c.Demurrage.ChargeableDays != 2 {
+		t.Errorf("demurrage %+v, want 2 days on the line's own tariff 2", c.Demurrage)
+	}
+	if c.Detention == nil || c.Detention.TariffID != 3 || !c.Detention.Running || c.Detention.ChargeableDays != 0 {
+		t.Errorf("detention %+v, want running free on tariff 3", c.Detention)
+	}
+
+	c = models.ContainerDemurrage{ShippingLine: "MSC", Size: "20", ArrivalDate: day(time.April, 1)}
+	ChargeContainer(&c, tariffs, asOf, asOf)
+	if c.Demurrage == nil || c.Demurrage.TariffID != 1 || c.Demurrage.Accrued != 700 {
+		t.Errorf("demurrage %+v, want 700 on the tariff for any line", c.Demurrage)
+	}
+	if c.Detention == nil || c.Detention.Start != nil || c.Detention.Running {
+		t.Errorf("detention %+v, want not started", c.Detention)
+	}
+
+	c = models.ContainerDemurrage{Size: "LCL", ArrivalDate: day(time.April, 1)}
+	ChargeContainer(&c, tariffs, asOf, asOf)
+	if c.Demurrage != nil || c.Detention != nil {
+		t.Errorf("an LCL container was charged: %+v %+v", c.Demurrage, c.Detention)
+	}
+}
+
+func TestTariffFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		slabs  []models.DemurrageSlabRequest
+		fields []string
+	}{
+		{name: "one open slab", slabs: []models.DemurrageSlabRequest{{FromDay: 1, Rate: 50}}},
+		{
+			name:  "tiers",
+			slabs: []models.DemurrageSlabRequest{{FromDay: 1, ToDay: intPtr(5), Rate: 50}, {FromDay: 6, Rate: 100}},
+		},
+		{
+			name:   "gap and overlap",
+			slabs:  []models.DemurrageSlabRequest{{FromDay: 2, ToDay: intPtr(5)}, {FromDay: 5, ToDay: intPtr(9)}, {FromDay: 10}},
+			fields: []string{"slabs[0].from_day", "slabs[1].from_day"},
+		},
+		{
+			name:   "open slab before the last",
+			slabs:  []models.DemurrageSlabRequest{{FromDay: 1}, {FromDay: 1, ToDay: intPtr(4)}},
+			fields: []string{"slabs[0].to_day", "slabs[1].to_day"},
+		},
+		{
+			name:   "ends before it starts",
+			slabs:  []models.DemurrageSlabRequest{{FromDay: 1, ToDay: intPtr(0)}, {FromDay: 1}},
+			fields: []string{"slabs[0].to_day"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &models.DemurrageTariffRequest{ShippingLine: " Maersk ", ContainerSize: "20", Kind: models.Demurrage, FreeDays: 5, Slabs: tt.slabs}
+			tariff, err := TariffFromRequest(req)
+			if len(tt.fields) > 0 {
+				if got := failedFields(t, err); !slices.Equal(got, tt.fields) {
+					t.Errorf("TariffFromRequest rejected %v, want %v", got, tt.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TariffFromRequest failed: %v", err)
+			}
+			if tariff.ShippingLine != "Maersk" || tariff.Currency != "INR" || len(tariff.Slabs) != len(tt.slabs) {
+				t.Errorf("tariff %+v, want Maersk in INR with %d slabs", tariff, len(tt.slabs))
+			}
+		})
+	}
+}
+
+func equalDate(a, b *time.Time) bool {
+	return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+}
+
+func equalInt(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// DepositService keeps the deposit accounts customers pay their duty from:
+// receipts entered by hand and debits for the duty and debit notes we pay
+// on their behalf
+type DepositService struct {
+	depositRepo  *repository.DepositRepository
+	ledgerRepo   *repository.LedgerRepository
+	pipelineRepo *repository.PipelineRepository
+}
+
+// NewDepositService creates a new deposit service
+func NewDepositService(depositRepo *repository.DepositRepository, ledgerRepo *repository.LedgerRepository, pipelineRepo *repository.PipelineRepository) *DepositService {
+	return &DepositService{
+		depositRepo:  depositRepo,
+		ledgerRepo:   ledgerRepo,
+		pipelineRepo: pipelineRepo,
+	}
+}
+
+// Sync debits the deposit of the job's consignee for each of the job's
+// ledger entries in DepositCategories that we paid and recover from the
+// customer. The debits follow the entries: they change with them and go
+// when they stop qualifying, or when the consignee has no deposit account.
+func (s *DepositService) Sync(ctx context.Context, jobID int) error {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	var debits []models.DepositMovement
+	account, err := s.account(ctx, job)
+	if err != nil {
+		return err
+	}
+	if account != nil {
+		entries, err := s.ledgerRepo.JobEntries(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		debits = DepositDebits(job, account.ID, entries)
+	}
+	if err := s.depositRepo.SyncJobDebits(ctx, jobID, debits); err != nil {
+		return fmt.Errorf("saving deposit debits of job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// DepositDebits are the debits a job's ledger entries make on its
+// customer's deposit account. An entry without a date is debited on the
+// day it was recorded, so the debit keeps its date however often the job
+// is synced.
+func DepositDebits(job *models.PipelineJobResponse, accountID int, entries []models.LedgerEntry) []models.DepositMovement {
+	var debits []models.DepositMovement
+	for _, e := range entries {
+		if e.Kind != models.LedgerCost || e.PaidBy != models.PaidByUs || !e.Reimbursable ||
+			!slices.Contains(models.DepositCategories, e.Category) || e.Amount <= 0 {
+			continue
+		}
+		date := e.CreatedAt
+		if e.EntryDate != nil {
+			date = *e.EntryDate
+		}
+		description := e.Description
+		if description == "" {
+			description = e.Category
+		}
+		entryID, jobID := e.ID, job.ID
+		debits = append(debits, models.DepositMovement{
+			AccountID:     accountID,
+			Kind:          models.DepositDebit,
+			Amount:        e.Amount,
+			MovementDate:  date,
+			Description:   description + ", job " + job.JobNo,
+			JobID:         &jobID,
+			LedgerEntryID: &entryID,
+		})
+	}
+	return debits
+}
+
+// Check compares the duty a job is expected to need with what its
+// customer's deposit has available for it. It returns nil when the job has
+// no duty to pay from a deposit: its consignee has no account, the duty is
+// paid by someone else, or there is no duty yet. The expected duty is the
+// stage 2 duty amount, or the latest duty calculation until that is
+// entered.
+func (s *DepositService) Check(ctx context.Context, jobID int) (*models.DepositCheck, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	s2 := job.Stage2
+	if s2 == nil {
+		return nil, nil
+	}
+	if paidBy := ParsePaidBy(deref(s2.DutyPaidBy)); paidBy != "" && paidBy != models.PaidByUs {
+		return nil, nil
+	}
+	expected := s2.DutyAmount
+	if expected <= 0 && s2.DutyBreakdown != nil {
+		expected = s2.DutyBreakdown.TotalDuty
+	}
+	if expected <= 0 {
+		return nil, nil
+	}
+	account, err := s.account(ctx, job)
+	if err != nil || account == nil {
+		return nil, err
+	}
+
+	debited, err := s.depositRepo.JobDebited(ctx, jobID, "customs_duty")
+	if err != nil {
+		return nil, err
+	}
+	check := &models.DepositCheck{
+		JobID:        jobID,
+		Customer:     account.Customer,
+		AccountID:    account.ID,
+		ExpectedDuty: gst.Round(expected),
+		Available:    gst.Round(account.Balance + debited),
+	}
+	check.Shortfall = max(gst.Round(check.ExpectedDuty-check.Available), 0)
+	return check, nil
+}
+
+// ShortfallWarning describes a deposit too small for the job's duty, or
+// returns "" if it covers it
+func ShortfallWarning(check *models.DepositCheck) string {
+	if check == nil || check.Shortfall <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("expected duty of %.2f exceeds the %.2f available in %s's deposit by %.2f",
+		check.ExpectedDuty, check.Available, check.Customer, check.Shortfall)
+}
+
+// Accounts lists the deposit accounts with their balances
+func (s *DepositService) Accounts(ctx context.Context) ([]models.DepositAccount, error) {
+	return s.depositRepo.Accounts(ctx)
+}
+
+// Account returns a deposit account with its balance
+func (s *DepositService) Account(ctx context.Context, id int) (*models.DepositAccount, error) {
+	return s.depositRepo.GetAccount(ctx, id)
+}
+
+// OpenAccount opens a deposit account for a customer. Jobs already saved
+// are debited the next time they are saved.
+func (s *DepositService) OpenAccount(ctx context.Context, req *models.DepositAccountRequest, userID int) (*models.DepositAccount, error) {
+	a := &models.DepositAccount{Customer: strings.TrimSpace(req.Customer), CreatedBy: &userID}
+	id, err := s.depositRepo.CreateAccount(ctx, a)
+	if errors.Is(err, repository.ErrDuplicate) {
+		var errs validation.Errors
+		errs.Add("customer", "already has a deposit account")
+		return nil, errs
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.depositRepo.GetAccount(ctx, id)
+}
+
+// AddMovement records money received into a deposit account or refunded
+// from it. A refund may not take out more than the balance.
+func (s *DepositService) AddMovement(ctx context.Context, accountID int, req *models.DepositMovementRequest, userID int) (*models.DepositAccount, error) {
+	account, err := s.depositRepo.GetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	amount := gst.Round(req.Amount)
+	if req.Kind == models.DepositRefund && amount > account.Balance+paymentTolerance {
+		var errs validation.Errors
+		errs.Add("amount", fmt.Sprintf("is more than the balance of %.2f", account.Balance))
+		return nil, errs
+	}
+
+	date, _ := time.Parse(validation.DateLayout, req.MovementDate)
+	m := &models.DepositMovement{
+		AccountID:    accountID,
+		Kind:         req.Kind,
+		Amount:       amount,
+		MovementDate: date,
+		Description:  req.Description,
+		CreatedBy:    &userID,
+	}
+	if m.Description == "" {
+		m.Description = "Deposit received"
+		if m.Kind == models.DepositRefund {
+			m.Description = "Deposit refunded"
+		}
+	}
+	if req.ReferenceNo != "" {
+		m.ReferenceNo = &req.ReferenceNo
+	}
+	if req.Remarks != "" {
+		m.Remarks = &req.Remarks
+	}
+	if _, err := s.depositRepo.AddMovement(ctx, m); err != nil {
+		return nil, err
+	}
+	return s.depositRepo.GetAccount(ctx, accountID)
+}
+
+// Statement lists a deposit account's movements between from and to, both
+// optional, with the balance after each
+func (s *DepositService) Statement(ctx context.Context, accountID int, from, to *time.Time) (*models.DepositStatement, error) {
+	account, err := s.depositRepo.GetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	movements, err := s.depositRepo.Movements(ctx, accountID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &models.DepositStatement{Account: *account, Movements: []models.DepositMovement{}}
+	if from != nil {
+		f := from.Format(validation.DateLayout)
+		st.From = &f
+	}
+	if to != nil {
+		t := to.Format(validation.DateLayout)
+		st.To = &t
+	}
+	balance := 0.0
+	for _, m := range movements {
+		if m.Kind == models.DepositReceipt {
+			balance += m.Amount
+		} else {
+			balance -= m.Amount
+		}
+		balance = gst.Round(balance)
+		if from != nil && m.MovementDate.Before(*from) {
+			st.OpeningBalance = balance
+			continue
+		}
+		m.Balance = balance
+		st.Movements = append(st.Movements, m)
+	}
+	st.ClosingBalance = balance
+	return st, nil
+}
+
+// account returns the deposit account of the job's consignee party, else
+// the one matching the consignee's name, or nil if they have none
+func (s *DepositService) account(ctx context.Context, job *models.PipelineJobResponse) (*models.DepositAccount, error) {
+	if job.Stage1 == nil {
+		return nil, nil
+	}
+	if id := job.Stage1.ConsigneePartyID; id != nil {
+		account, err := s.depositRepo.AccountByParty(ctx, *id)
+		if !errors.Is(err, repository.ErrNotFound) {
+			return account, err
+		}
+	}
+	consignee := strings.TrimSpace(deref(job.Stage1.Consignee))
+	if consignee == "" {
+		return nil, nil
+	}
+	account, err := s.depositRepo.AccountByCustomer(ctx, consignee)
+	if errors.Is(err, repository.ErrNotFound) {
+		slog.InfoContext(ctx, "No deposit account for the job's consignee", "job_id", job.ID, "consignee", consignee)
+		return nil, nil
+	}
+	return account, err
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestDepositDebitsKeepTheirDate(t *testing.T) {
+	job := &models.PipelineJobResponse{PipelineJob: models.PipelineJob{ID: 7, JobNo: "JOB-007"}}
+	recorded := time.Date(2026, 4, 10, 11, 30, 0, 0, time.UTC)
+	paid := time.Date(2026, 4, 12, 0, 0, 0, 0, time.UTC)
+	entries := []models.LedgerEntry{
+		{ID: 1, Kind: models.LedgerCost, Category: "customs_duty", Amount: 50000, PaidBy: models.PaidByUs,
+			Reimbursable: true, CreatedAt: recorded},
+		{ID: 2, Kind: models.LedgerCost, Category: "customs_duty", Amount: 1200, PaidBy: models.PaidByUs,
+			Reimbursable: true, EntryDate: &paid, CreatedAt: recorded},
+		{ID: 3, Kind: models.LedgerCost, Category: "customs_duty", Amount: 800, PaidBy: models.PaidByCustomer,
+			Reimbursable: true, CreatedAt: recorded},
+	}
+
+	first := DepositDebits(job, 3, entries)
+	second := DepositDebits(job, 3, entries)
+
+	if len(first) != 2 || len(second) != 2 {
+		t.Fatalf("got %d and %d debits, want 2", len(first), len(second))
+	}
+	want := []time.Time{recorded, paid}
+	for i := range first {
+		if !first[i].MovementDate.Equal(want[i]) {
+			t.Errorf("debit %d first sync dated %v, want %v", i, first[i].MovementDate, want[i])
+		}
+		if !second[i].MovementDate.Equal(first[i].MovementDate) {
+			t.Errorf("debit %d moved from %v to %v between syncs", i, first[i].MovementDate, second[i].MovementDate)
+		}
+	}
+}
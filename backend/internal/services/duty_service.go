@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// Loadings, as a percentage of the invoice value, used when freight or
+// insurance cannot be ascertained (Customs Valuation Rules, 2007, rule 10)
+const (
+	defaultFreightRate   = 20.0
+	defaultInsuranceRate = 1.125
+)
+
+// DutyService computes customs duty from the HSN master and exchange rates
+type DutyService struct {
+	hsnRepo  *repository.HSNRepository
+	dutyRepo *repository.DutyRepository
+}
+
+// NewDutyService creates a new duty service
+func NewDutyService(hsnRepo *repository.HSNRepository, dutyRepo *repository.DutyRepository) *DutyService {
+	return &DutyService{hsnRepo: hsnRepo, dutyRepo: dutyRepo}
+}
+
+// Calculate looks up the tariff and exchange rates for a request and
+// returns the itemized duty. Codes missing from the HSN master and
+// currencies without a rate are reported as validation errors.
+func (s *DutyService) Calculate(ctx context.Context, jobID int, req *models.DutyCalculationRequest) (*models.DutyBreakdown, error) {
+	rateDate := time.Now()
+	if req.RateDate != "" {
+		d, err := time.Parse(validation.DateLayout, req.RateDate)
+		if err != nil {
+			return nil, err
+		}
+		rateDate = d
+	}
+
+	var errs validation.Errors
+	currency := strings.ToUpper(req.Currency)
+	rates := map[string]float64{"INR": 1}
+	var notes []string
+	if req.ExchangeRate > 0 {
+		rates[currency] = req.ExchangeRate
+		if currency != "INR" {
+			notes = append(notes, fmt.Sprintf("%s at %g as entered", currency, req.ExchangeRate))
+		}
+	}
+	lookup := func(field, code string) error {
+		code = strings.ToUpper(code)
+		if _, ok := rates[code]; ok || code == "" {
+			return nil
+		}
+		rate, err := s.dutyRepo.ExchangeRate(ctx, code, rateDate)
+		if errors.Is(err, repository.ErrNotFound) {
+			errs.Add(field, fmt.Sprintf("no exchange rate for %s on or before %s", code, rateDate.Format(validation.DateLayout)))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rates[code] = rate.Rate
+		notes = append(notes, fmt.Sprintf("%s at %g effective %s", code, rate.Rate, rate.EffectiveDate.Format(validation.DateLayout)))
+		return nil
+	}
+	for _, c := range []struct{ field, code string }{
+		{"currency", currency},
+		{"freight_currency", req.FreightCurrency},
+		{"insurance_currency", req.InsuranceCurrency},
+	} {
+		if err := lookup(c.field, c.code); err != nil {
+			return nil, err
+		}
+	}
+
+	codes := make([]string, len(req.Items))
+	for i, item := range req.Items {
+		codes[i] = tradeid.Normalize(item.HSNCode)
+	}
+	tariff, err := s.hsnRepo.GetByCodes(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	for i, code := range codes {
+		if _, ok := tariff[code]; !ok {
+			errs.Add(fmt.Sprintf("items[%d].hsn_code", i), "is not in the HSN master")
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	b := ComputeDuty(req, tariff, rates)
+	b.JobID = jobID
+	b.RateDate = rateDate.Format(validation.DateLayout)
+	b.Notes = append(notes, b.Notes...)
+	return b, nil
+}
+
+// Save calculates the duty for a request and stores the breakdown against
+// the job so the figure filed in stage 2 can be traced later
+func (s *DutyService) Save(ctx context.Context, jobID, userID int, req *models.DutyCalculationRequest) (*models.DutyBreakdown, error) {
+	b, err := s.Calculate(ctx, jobID, req)
+	if err != nil {
+		return nil, err
+	}
+	b.CalculatedBy = userID
+	if err := s.dutyRepo.SaveCalculation(ctx, b); err != nil {
+		return nil, fmt.Errorf("saving duty calculation: %w", err)
+	}
+	return b, nil
+}
+
+// ComputeDuty applies the customs formula to each item. rates holds rupees
+// per unit of each currency used and tariff the HSN entry of each item's
+// code. Freight and insurance are shared across items by invoice value, in
+// whole paise that add up to the header amounts.
+//
+//	assessable value = (invoice + freight + insurance) in rupees
+//	BCD  = assessable value × BCD rate
+//	SWS  = BCD × SWS rate
+//	IGST = (assessable value + BCD + SWS) × IGST rate
+//	cess = (assessable value + BCD + SWS) × cess rate
+//
+// Each duty is rounded to the nearest rupee as required by section 154A.
+func ComputeDuty(req *models.DutyCalculationRequest, tariff map[string]models.HSNCode, rates map[string]float64) *models.DutyBreakdown {
+	currency := strings.ToUpper(req.Currency)
+	b := &models.DutyBreakdown{
+		Currency:      currency,
+		ExchangeRates: rates,
+		Items:         make([]models.DutyItem, 0, len(req.Items)),
+	}
+
+	for _, item := range req.Items {
+		b.InvoiceValue += item.InvoiceValue
+	}
+	invoiceINR := b.InvoiceValue * rates[currency]
+
+	b.Freight = chargeINR(req.Freight, req.FreightCurrency, currency, rates, invoiceINR, defaultFreightRate)
+	if req.Freight == nil {
+		b.Notes = append(b.Notes, fmt.Sprintf("Freight not given; taken at %g%% of invoice value", defaultFreightRate))
+	}
+	b.Insurance = chargeINR(req.Insurance, req.InsuranceCurrency, currency, rates, invoiceINR, defaultInsuranceRate)
+	if req.Insurance == nil {
+		b.Notes = append(b.Notes, fmt.Sprintf("Insurance not given; taken at %g%% of invoice value", defaultInsuranceRate))
+	}
+
+	b.Freight = roundPaise(b.Freight)
+	b.Insurance = roundPaise(b.Insurance)
+	values := make([]float64, len(req.Items))
+	for i, line := range req.Items {
+		values[i] = line.InvoiceValue
+	}
+	freight := apportion(b.Freight, values)
+	insurance := apportion(b.Insurance, values)
+
+	for i, line := range req.Items {
+		hsn := tariff[tradeid.Normalize(line.HSNCode)]
+		item := models.DutyItem{
+			HSNCode:      hsn.Code,
+			Description:  line.Description,
+			InvoiceValue: line.InvoiceValue,
+			Freight:      freight[i],
+			Insurance:    insurance[i],
+			BCDRate:      hsn.BCDRate,
+			SWSRate:      hsn.SWSRate,
+			IGSTRate:     hsn.IGSTRate,
+			CessRate:     hsn.CessRate,
+		}
+		if item.Description == "" {
+			item.Description = hsn.Description
+		}
+		item.AssessableValue = roundPaise(line.InvoiceValue*rates[currency] + item.Freight + item.Insurance)
+		item.BCD = math.Round(item.AssessableValue * item.BCDRate / 100)
+		item.SWS = math.Round(item.BCD * item.SWSRate / 100)
+		base := item.AssessableValue + item.BCD + item.SWS
+		item.IGST = math.Round(base * item.IGSTRate / 100)
+		item.Cess = math.Round(base * item.CessRate / 100)
+		item.TotalDuty = item.BCD + item.SWS + item.IGST + item.Cess
+
+		b.AssessableValue += item.AssessableValue
+		b.BCD += item.BCD
+		b.SWS += item.SWS
+		b.IGST += item.IGST
+		b.Cess += item.Cess
+		b.TotalDuty += item.TotalDuty
+		b.Items = append(b.Items, item)
+	}
+
+	b.InvoiceValue = roundPaise(b.InvoiceValue)
+	b.AssessableValue = roundPaise(b.AssessableValue)
+	return b
+}
+
+// chargeINR converts a freight or insurance amount to rupees, or loads the
+// invoice value by percent when the amount is unknown
+func chargeINR(amount *float64, currency, invoiceCurrency string, rates map[string]float64, invoiceINR, percent float64) float64 {
+	if amount == nil {
+		return invoiceINR * percent / 100
+	}
+	if currency == "" {
+		currency = invoiceCurrency
+	}
+	return *amount * rates[strings.ToUpper(currency)]
+}
+
+// apportion splits total, in rupees and paise, in proportion to weights.
+// Each share is rounded down to the paisa and the paise left over go to the
+// shares that lost the most, so the shares always add up to total. Without
+// any weight the total is split evenly.
+func apportion(total float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		weights = make([]float64, len(weights))
+		for i := range weights {
+			weights[i] = 1
+		}
+		sum = float64(len(weights))
+	}
+
+	paise := math.Round(total * 100)
+	remainders := make([]float64, len(weights))
+	left := paise
+	for i, w := range weights {
+		exact := paise * w / sum
+		shares[i] = math.Floor(exact)
+		remainders[i] = exact - shares[i]
+		left -= shares[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order {
+		if left <= 0 {
+			break
+		}
+		shares[i]++
+		left--
+	}
+	for i := range shares {
+		shares[i] /= 100
+	}
+	return shares
+}
+
+func roundPaise(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"maydiv-crm/internal/models"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+
+var dutyTariff = map[string]models.HSNCode{
+	"84821010": {Code: "84821010", Description: "Ball bearings", BCDRate: 7.5, SWSRate: 10, IGSTRate: 18},
+	"84829900": {Code: "84829900", Description: "Bearing parts", BCDRate: 10, SWSRate: 10, IGSTRate: 28, CessRate: 1},
+}
+
+func TestComputeDuty(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   models.DutyCalculationRequest
+		rates map[string]float64
+		// want holds the header totals; items the per-item freight shares
+		want  models.DutyBreakdown
+		items []float64
+		notes int
+	}{
+		{
+			// 1,000 USD at 83.5 = 83,500; freight 8,350 and insurance 835
+			// give 92,685; BCD 6,951.375 rounds to 6,951, SWS 695, IGST
+			// on 1,00,331 is 18,059.58
+			name: "foreign currency invoice",
+			req: models.DutyCalculationRequest{
+				Currency: "usd", Freight: floatPtr(100), Insurance: floatPtr(10),
+				Items: []models.DutyItemRequest{{HSNCode: "8482.10.10", InvoiceValue: 1000}},
+			},
+			rates: map[string]float64{"INR": 1, "USD": 83.5},
+			want: models.DutyBreakdown{
+				Currency: "USD", InvoiceValue: 1000, Freight: 8350, Insurance: 835, AssessableValue: 92685,
+				BCD: 6951, SWS: 695, IGST: 18060, TotalDuty: 25706,
+			},
+			items: []float64{8350},
+		},
+		{
+			// 2,000 EUR at 90 = 1,80,000; freight 500 USD at 83.5 = 41,750;
+			// insurance 1,500 rupees; assessable 2,23,250
+			name: "freight in another currency",
+			req: models.DutyCalculationRequest{
+				Currency: "EUR", Freight: floatPtr(500), FreightCurrency: "usd",
+				Insurance: floatPtr(1500), InsuranceCurrency: "INR",
+				Items: []models.DutyItemRequest{{HSNCode: "84821010", InvoiceValue: 2000}},
+			},
+			rates: map[string]float64{"INR": 1, "USD": 83.5, "EUR": 90},
+			want: models.DutyBreakdown{
+				Currency: "EUR", InvoiceValue: 2000, Freight: 41750, Insurance: 1500, AssessableValue: 223250,
+				BCD: 16744, SWS: 1674, IGST: 43500, TotalDuty: 61918,
+			},
+			items: []float64{41750},
+		},
+		{
+			// Freight is loaded at 20% (2,000) and insurance at 1.125%
+			// (112.50) of the 10,000 rupee invoice
+			name: "missing freight and insurance",
+			req: models.DutyCalculationRequest{
+				Currency: "INR",
+				Items:    []models.DutyItemRequest{{HSNCode: "84821010", InvoiceValue: 10000}},
+			},
+			rates: map[string]float64{"INR": 1},
+			want: models.DutyBreakdown{
+				Currency: "INR", InvoiceValue: 10000, Freight: 2000, Insurance: 112.5, AssessableValue: 12112.5,
+				BCD: 908, SWS: 91, IGST: 2360, TotalDuty: 3359,
+			},
+			items: []float64{2000},
+			notes: 2,
+		},
+		{
+			// 100 rupees of freight over three equal lines is 33.33⅓ each;
+			// the odd paisa goes to the first line so the lines add up
+			name: "apportioned across items",
+			req: models.DutyCalculationRequest{
+				Currency: "INR", Freight: floatPtr(100), Insurance: floatPtr(0),
+				Items: []models.DutyItemRequest{
+					{HSNCode: "84821010", InvoiceValue: 100},
+					{HSNCode: "84821010", InvoiceValue: 100},
+					{HSNCode: "84829900", InvoiceValue: 100},
+				},
+			},
+			rates: map[string]float64{"INR": 1},
+			want: models.DutyBreakdown{
+				Currency: "INR", InvoiceValue: 300, Freight: 100, AssessableValue: 400,
+				BCD: 33, SWS: 3, IGST: 93, Cess: 1, TotalDuty: 130,
+			},
+			items: []float64{33.34, 33.33, 33.33},
+		},
+		{
+			name: "uneven lines",
+			req: models.DutyCalculationRequest{
+				Currency: "INR", Freight: floatPtr(10), Insurance: floatPtr(0.05),
+				Items: []models.DutyItemRequest{
+					{HSNCode: "84821010", InvoiceValue: 1},
+					{HSNCode: "84821010", InvoiceValue: 2},
+					{HSNCode: "84821010", InvoiceValue: 4},
+				},
+			},
+			rates: map[string]float64{"INR": 1},
+			want: models.DutyBreakdown{
+				Currency: "INR", InvoiceValue: 7, Freight: 10, Insurance: 0.05, AssessableValue: 17.05,
+				BCD: 1, IGST: 3, TotalDuty: 4,
+			},
+			items: []float64{1.43, 2.86, 5.71},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeDuty(&tt.req, dutyTariff, tt.rates)
+
+			for _, f := range []struct {
+				field     string
+				got, want float64
+			}{
+				{"invoice value", got.InvoiceValue, tt.want.InvoiceValue},
+				{"freight", got.Freight, tt.want.Freight},
+				{"insurance", got.Insurance, tt.want.Insurance},
+				{"assessable value", got.AssessableValue, tt.want.AssessableValue},
+				{"BCD", got.BCD, tt.want.BCD},
+				{"SWS", got.SWS, tt.want.SWS},
+				{"IGST", got.IGST, tt.want.IGST},
+				{"cess", got.Cess, tt.want.Cess},
+				{"total duty", got.TotalDuty, tt.want.TotalDuty},
+			} {
+				if f.got != f.want {
+					t.Errorf("%s = %v, want %v", f.field, f.got, f.want)
+				}
+			}
+			if got.Currency != tt.want.Currency {
+				t.Errorf("currency = %q, want %q", got.Currency, tt.want.Currency)
+			}
+			if len(got.Notes) != tt.notes {
+				t.Errorf("notes = %q, want %d", got.Notes, tt.notes)
+			}
+			if len(got.Items) != len(tt.items) {
+				t.Fatalf("got %d items, want %d", len(got.Items), len(tt.items))
+			}
+
+			var freight, insurance, assessable float64
+			for i, item := range got.Items {
+				if item.Freight != tt.items[i] {
+					t.Errorf("item %d freight = %v, want %v", i, item.Freight, tt.items[i])
+				}
+				freight += item.Freight
+				insurance += item.Insurance
+				assessable += item.AssessableValue
+			}
+			for _, sum := range []struct {
+				field       string
+				items, head float64
+			}{
+				{"freight", freight, got.Freight},
+				{"insurance", insurance, got.Insurance},
+				{"assessable value", assessable, got.AssessableValue},
+			} {
+				if math.Round(sum.items*100) != math.Round(sum.head*100) {
+					t.Errorf("item %s adds up to %v, header says %v", sum.field, sum.items, sum.head)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/einvoice"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// ErrEInvoiceRegistered is returned when a job's e-invoice would change
+// after the IRP has registered it
+var ErrEInvoiceRegistered = errors.New("e-invoice already registered")
+
+// EInvoiceService generates GST e-invoices for stage 4 bills and records
+// their registration with the IRP
+type EInvoiceService struct {
+	pipelineRepo *repository.PipelineRepository
+	einvoiceRepo *repository.EInvoiceRepository
+	company      config.CompanyConfig
+}
+
+// NewEInvoiceService creates a new e-invoice service
+func NewEInvoiceService(pipelineRepo *repository.PipelineRepository, einvoiceRepo *repository.EInvoiceRepository, company config.CompanyConfig) *EInvoiceService {
+	return &EInvoiceService{
+		pipelineRepo: pipelineRepo,
+		einvoiceRepo: einvoiceRepo,
+		company:      company,
+	}
+}
+
+// Build assembles the e-invoice for a job's bill, or returns
+// validation.Errors listing what is missing or does not fit the schema.
+// The buyer is the job's consignee; fields set in buyer override the
+// address last billed to them.
+func (s *EInvoiceService) Build(ctx context.Context, jobID int, buyer *models.BillingParty) (*einvoice.Invoice, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs validation.Errors
+	if job.Stage1 == nil || job.Stage1.ConsigneeGSTIN == nil || *job.Stage1.ConsigneeGSTIN == "" {
+		errs.Add("stage1.consignee_gstin", "is required")
+	}
+	stage4 := job.Stage4
+	if stage4 == nil {
+		stage4 = &models.Stage4Data{}
+	}
+	if stage4.BillNo == nil || *stage4.BillNo == "" {
+		errs.Add("stage4.bill_no", "is required")
+	}
+	if stage4.BillDate == nil {
+		errs.Add("stage4.bill_date", "is required")
+	}
+	if len(stage4.Items) == 0 {
+		errs.Add("stage4.items", "is required")
+	}
+	if stage4.TotalsOverridden {
+		errs.Add("stage4.totals_overridden", "the e-invoice needs totals computed from the items")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	lines := make([]einvoice.Line, len(stage4.Items))
+	for i, item := range stage4.Items {
+		lines[i] = einvoice.Line{
+			Description: item.Description,
+			SAC:         item.SAC,
+			Rate:        item.GSTRate,
+			Taxable:     item.TaxableAmount,
+			Tax:         item.CGSTAmount + item.SGSTAmount + item.IGSTAmount,
+		}
+	}
+
+	in := einvoice.Input{
+		DocNo:   *stage4.BillNo,
+		DocDate: *stage4.BillDate,
+		Seller: models.BillingParty{
+			GSTIN:     s.company.GSTIN,
+			LegalName: s.company.Name,
+			Address1:  s.company.Address1,
+			Address2:  s.company.Address2,
+			Location:  s.company.Location,
+			Pincode:   s.company.Pincode,
+			Phone:     s.company.Phone,
+			Email:     s.company.Email,
+		},
+		Buyer: s.buyer(ctx, job, buyer),
+		Lines: lines,
+	}
+	if stage4.PlaceOfSupply != nil {
+		in.PlaceOfSupply = *stage4.PlaceOfSupply
+	}
+	if stage4.RoundOff != nil {
+		in.RoundOff = *stage4.RoundOff
+	}
+	return einvoice.Build(in)
+}
+
+// buyer is the job's consignee as last billed, with the fields set in
+// override taking precedence
+func (s *EInvoiceService) buyer(ctx context.Context, job *models.PipelineJobResponse, override *models.BillingParty) models.BillingParty {
+	party := models.BillingParty{GSTIN: *job.Stage1.ConsigneeGSTIN}
+	if job.Stage1.Consignee != nil {
+		party.LegalName = *job.Stage1.Consignee
+	}
+	if payload, err := s.einvoiceRepo.LastBuyerPayload(ctx, party.GSTIN, job.ID); err == nil {
+		var last einvoice.Invoice
+		if json.Unmarshal(payload, &last) == nil {
+			b := last.BuyerDtls
+			party.TradeName = b.TrdNm
+			party.Address1 = b.Addr1
+			party.Address2 = b.Addr2
+			party.Location = b.Loc
+			if b.Pin > 0 {
+				party.Pincode = strconv.Itoa(b.Pin)
+			}
+			party.Phone = b.Ph
+			party.Email = b.Em
+		}
+	}
+	if override == nil {
+		return party
+	}
+
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&party.LegalName, override.LegalName)
+	set(&party.TradeName, override.TradeName)
+	set(&party.Address1, override.Address1)
+	set(&party.Address2, override.Address2)
+	set(&party.Location, override.Location)
+	set(&party.Pincode, override.Pincode)
+	set(&party.Phone, override.Phone)
+	set(&party.Email, override.Email)
+	return party
+}
+
+// Generate builds a job's e-invoice and stores it for upload to the IRP,
+// replacing an earlier one that has not been registered
+func (s *EInvoiceService) Generate(ctx context.Context, jobID, userID int, req *models.EInvoiceRequest) (*models.EInvoice, error) {
+	existing, err := s.einvoiceRepo.Get(ctx, jobID)
+	if err == nil && existing.Status == models.EInvoiceRegistered {
+		return nil, ErrEInvoiceRegistered
+	} else if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	inv, err := s.Build(ctx, jobID, req.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.einvoiceRepo.SaveGenerated(ctx, jobID, inv.DocDtls.No, payload, userID); err != nil {
+		return nil, err
+	}
+	return s.einvoiceRepo.Get(ctx, jobID)
+}
+
+// Get returns a job's e-invoice
+func (s *EInvoiceService) Get(ctx context.Context, jobID int) (*models.EInvoice, error) {
+	return s.einvoiceRepo.Get(ctx, jobID)
+}
+
+// ImportResponse reads the IRP's reply to a job's generated e-invoice and
+// stores the IRN, acknowledgement and signed QR code against the bill. A
+// reply issued for a different invoice is rejected.
+func (s *EInvoiceService) ImportResponse(ctx context.Context, jobID, userID int, data []byte) (*models.EInvoice, error) {
+	existing, err := s.einvoiceRepo.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Status == models.EInvoiceRegistered {
+		return nil, ErrEInvoiceRegistered
+	}
+
+	resp, err := einvoice.ParseResponse(data)
+	if err != nil {
+		var fieldErrs validation.Errors
+		if errors.As(err, &fieldErrs) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	var inv einvoice.Invoice
+	if err := json.Unmarshal(existing.Payload, &inv); err != nil {
+		return nil, fmt.Errorf("reading stored e-invoice for job %d: %w", jobID, err)
+	}
+	if err := resp.CheckMatches(&inv); err != nil {
+		return nil, err
+	}
+
+	registered := &models.EInvoice{
+		IRN:          &resp.Irn,
+		AckNo:        &resp.AckNo,
+		AckDate:      &resp.AckDt,
+		SignedQRCode: &resp.SignedQRCode,
+	}
+	if resp.SignedInvoice != "" {
+		registered.SignedInvoice = &resp.SignedInvoice
+	}
+	message := fmt.Sprintf("E-invoice %s registered, IRN %s, ack %s", existing.BillNo, resp.Irn, resp.AckNo)
+	if err := s.einvoiceRepo.Register(ctx, jobID, registered, userID, message); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrEInvoiceRegistered
+		}
+		return nil, err
+	}
+	return s.einvoiceRepo.Get(ctx, jobID)
+}
@@ -1,10 +1,19 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"html"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/smtp"
 	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/metrics"
 
 	"gopkg.in/gomail.v2"
 )
@@ -25,38 +34,16 @@ type StageCompletionEmail struct {
 	AdminEmail   string
 }
 
-func NewEmailService() *EmailService {
-	// Get SMTP configuration from environment variables
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPortStr := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	fromEmail := os.Getenv("FROM_EMAIL")
-
-	if smtpHost == "" {
-		smtpHost = "smtp.gmail.com" // Default to Gmail
-	}
-
-	smtpPort := 587 // Default port
-	if smtpPortStr != "" {
-		if port, err := strconv.Atoi(smtpPortStr); err == nil {
-			smtpPort = port
-		}
-	}
-
-	if fromEmail == "" {
-		fromEmail = smtpUser // Use SMTP user as from email if not specified
-	}
-
-	dialer := gomail.NewDialer(smtpHost, smtpPort, smtpUser, smtpPass)
+func NewEmailService(cfg config.SMTPConfig) *EmailService {
+	dialer := gomail.NewDialer(cfg.Host, cfg.Port, cfg.User, cfg.Password)
 
 	return &EmailService{
 		dialer: dialer,
-		from:   fromEmail,
+		from:   cfg.From,
 	}
 }
 
-func (es *EmailService) SendStageCompletionEmail(emailData StageCompletionEmail) error {
+func (es *EmailService) SendStageCompletionEmail(ctx context.Context, emailData StageCompletionEmail) error {
 	subject := fmt.Sprintf("Stage Completion Notification - Job %s", emailData.JobNo)
 	
 	body := fmt.Sprintf(`
@@ -147,15 +134,16 @@ func (es *EmailService) SendStageCompletionEmail(emailData StageCompletionEmail)
 
 	// Try to send email
 	if err := es.dialer.DialAndSend(m); err != nil {
-		log.Printf("Failed to send email notification: %v", err)
-		return err
+		metrics.EmailFailed("stage_completion")
+		return fmt.Errorf("sending stage completion email: %w", err)
 	}
+	metrics.EmailSent("stage_completion")
 
-	log.Printf("Stage completion email sent successfully to %s for job %s", emailData.AdminEmail, emailData.JobNo)
+	slog.DebugContext(ctx, "Stage completion email sent", "job_no", emailData.JobNo, "stage", emailData.Stage)
 	return nil
 }
 
-func (es *EmailService) SendJobCreationEmail(jobNo, createdBy, adminEmail string) error {
+func (es *EmailService) SendJobCreationEmail(ctx context.Context, jobNo, createdBy, adminEmail string) error {
 	subject := fmt.Sprintf("New Pipeline Job Created - %s", jobNo)
 	
 	body := fmt.Sprintf(`
@@ -221,15 +209,184 @@ func (es *EmailService) SendJobCreationEmail(jobNo, createdBy, adminEmail string
 	m.SetBody("text/html", body)
 
 	if err := es.dialer.DialAndSend(m); err != nil {
-		log.Printf("Failed to send job creation email: %v", err)
-		return err
+		metrics.EmailFailed("job_creation")
+		return fmt.Errorf("sending job creation email: %w", err)
+	}
+	metrics.EmailSent("job_creation")
+
+	slog.DebugContext(ctx, "Job creation email sent", "job_no", jobNo)
+	return nil
+}
+
+// DemurrageAlert is one container whose free time is about to end
+type DemurrageAlert struct {
+	ContainerNo string
+	Kind        string
+	FreeUntil   time.Time
+	DaysLeft    int
+	Currency    string
+	Projected   float64
+}
+
+// SendDemurrageAlert tells to that the free time of a job's containers is
+// about to end
+func (es *EmailService) SendDemurrageAlert(ctx context.Context, to, jobNo, customer string, alerts []DemurrageAlert) error {
+	subject := fmt.Sprintf("Free time ending - Job %s", jobNo)
+
+	var rows strings.Builder
+	for _, a := range alerts {
+		fmt.Fprintf(&rows, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%s %.2f</td></tr>\n",
+			html.EscapeString(a.ContainerNo), a.Kind, a.FreeUntil.Format("02-Jan-2006"), a.DaysLeft, a.Currency, a.Projected)
+	}
+	body := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Free Time Ending</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background-color: #d97706; color: white; padding: 20px; text-align: center; border-radius: 8px 8px 0 0; }
+        .content { background-color: #f8fafc; padding: 20px; border-radius: 0 0 8px 8px; }
+        table { border-collapse: collapse; width: 100%%; }
+        th, td { border: 1px solid #e5e7eb; padding: 6px; text-align: left; }
+        .footer { margin-top: 20px; padding-top: 20px; border-top: 1px solid #e5e7eb; font-size: 12px; color: #6b7280; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>Free Time Ending</h1>
+        </div>
+        <div class="content">
+            <p>The free time of these containers on job <strong>%s</strong> (%s) ends soon:</p>
+
+            <table>
+                <tr><th>Container</th><th>Charge</th><th>Free until</th><th>Days left</th><th>Projected</th></tr>
+                %s
+            </table>
+
+            <p style="margin-top: 20px;">
+                <strong>Action Required:</strong> Offload or return the containers before their free time ends to avoid charges.
+            </p>
+
+            <div class="footer">
+                <p>This is an automated notification from the MayDiv CRM System.</p>
+            </div>
+        </div>
+    </div>
+</body>
+</html>
+`, html.EscapeString(jobNo), html.EscapeString(customer), rows.String())
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", es.from)
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", body)
+
+	if err := es.dialer.DialAndSend(m); err != nil {
+		metrics.EmailFailed("demurrage_alert")
+		return fmt.Errorf("sending demurrage alert email: %w", err)
 	}
+	metrics.EmailSent("demurrage_alert")
 
-	log.Printf("Job creation email sent successfully to %s for job %s", adminEmail, jobNo)
+	slog.DebugContext(ctx, "Demurrage alert email sent", "job_no", jobNo, "containers", len(alerts))
+	return nil
+}
+
+// SendInvitation invites to to join an organization's account, through
+// the link to accept it by
+func (es *EmailService) SendInvitation(ctx context.Context, to, organization, invitedBy, acceptURL string, expiresAt time.Time) error {
+	subject := fmt.Sprintf("You are invited to %s on MayDiv CRM", organization)
+
+	body := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Invitation</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background-color: #2563eb; color: white; padding: 20px; text-align: center; border-radius: 8px 8px 0 0; }
+        .content { background-color: #f8fafc; padding: 20px; border-radius: 0 0 8px 8px; }
+        .footer { margin-top: 20px; padding-top: 20px; border-top: 1px solid #e5e7eb; font-size: 12px; color: #6b7280; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>You Are Invited</h1>
+        </div>
+        <div class="content">
+            <p><strong>%s</strong> has invited you to follow the jobs of <strong>%s</strong> on the MayDiv CRM System.</p>
+
+            <p><a href="%s">Accept the invitation</a> to choose a username and password.</p>
+
+            <p>The invitation expires on %s.</p>
+
+            <div class="footer">
+                <p>This is an automated notification from the MayDiv CRM System.</p>
+            </div>
+        </div>
+    </div>
+</body>
+</html>
+`, html.EscapeString(invitedBy), html.EscapeString(organization), html.EscapeString(acceptURL), expiresAt.Format("02-Jan-2006 15:04"))
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", es.from)
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", body)
+
+	if err := es.dialer.DialAndSend(m); err != nil {
+		metrics.EmailFailed("invitation")
+		return fmt.Errorf("sending invitation email: %w", err)
+	}
+	metrics.EmailSent("invitation")
+
+	slog.DebugContext(ctx, "Invitation email sent", "organization", organization)
 	return nil
 }
 
 // Test email configuration
+// CheckConnection connects to the SMTP server and exchanges greetings,
+// giving up as soon as ctx is done. It does not log in, so it is cheap
+// enough for readiness probes.
+func (es *EmailService) CheckConnection(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(es.dialer.Host, strconv.Itoa(es.dialer.Port)))
+	if err != nil {
+		return fmt.Errorf("connecting to SMTP server: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if es.dialer.SSL {
+		tlsConfig := &tls.Config{ServerName: es.dialer.Host}
+		if es.dialer.TLSConfig != nil {
+			tlsConfig = es.dialer.TLSConfig.Clone()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("SMTP TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+	client, err := smtp.NewClient(conn, es.dialer.Host)
+	if err != nil {
+		return fmt.Errorf("reading SMTP greeting: %w", err)
+	}
+	if err := client.Hello("localhost"); err != nil {
+		return fmt.Errorf("SMTP HELO: %w", err)
+	}
+	return client.Quit()
+}
+
 func (es *EmailService) TestEmailConnection() error {
 	// Try to connect to SMTP server
 	s, err := es.dialer.Dial()
@@ -238,6 +395,6 @@ func (es *EmailService) TestEmailConnection() error {
 	}
 	defer s.Close()
 	
-	log.Println("Email service connection test successful")
+	slog.Debug("Email service connection test successful")
 	return nil
 } 
\ No newline at end of file
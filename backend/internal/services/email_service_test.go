@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/config"
+)
+
+// fakeSMTP listens on a local port and hands each connection to serve
+func fakeSMTP(t *testing.T, serve func(net.Conn)) *EmailService {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return NewEmailService(config.SMTPConfig{Host: "127.0.0.1", Port: addr.Port})
+}
+
+func TestCheckConnection(t *testing.T) {
+	es := fakeSMTP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.test ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				conn.Write([]byte("250 mail.test\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("502 not implemented\r\n"))
+			}
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := es.CheckConnection(ctx); err != nil {
+		t.Errorf("CheckConnection failed: %v", err)
+	}
+}
+
+func TestCheckConnectionGivesUp(t *testing.T) {
+	// The server accepts but never greets
+	es := fakeSMTP(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := es.CheckConnection(ctx); err == nil {
+		t.Error("CheckConnection succeeded without a greeting")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CheckConnection took %v, want it to stop at the context deadline", elapsed)
+	}
+}
@@ -17,4 +17,7 @@ var (
 	
 	// ErrForbidden is returned when user doesn't have permission
 	ErrForbidden = errors.New("forbidden")
+	
+	// ErrInvalidFile is returned when an uploaded file cannot be parsed
+	ErrInvalidFile = errors.New("invalid file")
 ) 
\ No newline at end of file
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// FilingService decides which regulatory filings a job needs
+type FilingService struct {
+	filingRepo *repository.FilingRepository
+	masterRepo *repository.MasterRepository
+}
+
+// NewFilingService creates a new filing service
+func NewFilingService(filingRepo *repository.FilingRepository, masterRepo *repository.MasterRepository) *FilingService {
+	return &FilingService{filingRepo: filingRepo, masterRepo: masterRepo}
+}
+
+// Evaluate matches the active rules against the job's HSN code and country
+// of shipment, brings the job's filings in line and returns them. Countries
+// are compared by country master code, so "PRC", "China" and "CN" agree.
+func (s *FilingService) Evaluate(ctx context.Context, jobID int) ([]models.JobFiling, error) {
+	hsnCode, country, err := s.filingRepo.JobGoods(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.filingRepo.ListRules(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rules saved before they were keyed on the master code may still
+	// name a country
+	codes := make(map[string]string)
+	countryCode := func(value string) (string, error) {
+		code, seen := codes[value]
+		if !seen {
+			if code, err = s.masterRepo.Code(ctx, models.MasterCountry, value); err != nil {
+				return "", err
+			}
+			if code == "" {
+				code = value
+			}
+			codes[value] = code
+		}
+		return code, nil
+	}
+	if country, err = countryCode(country); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if rules[i].Country == "" {
+			continue
+		}
+		if rules[i].Country, err = countryCode(rules[i].Country); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.filingRepo.SyncJobFilings(ctx, jobID, MatchFilingRules(rules, hsnCode, country)); err != nil {
+		return nil, fmt.Errorf("saving job filings: %w", err)
+	}
+	return s.filingRepo.JobFilings(ctx, jobID)
+}
+
+// KeyCountry replaces the country a rule names, by code, name or alias,
+// with the code of its country master, which jobs are matched on
+func (s *FilingService) KeyCountry(ctx context.Context, rule *models.FilingRule) error {
+	if rule.Country == "" {
+		return nil
+	}
+	code, err := s.masterRepo.Code(ctx, models.MasterCountry, rule.Country)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		var errs validation.Errors
+		errs.Add("country", "must be a country in the master data")
+		return errs.Err()
+	}
+	rule.Country = code
+	return nil
+}
+
+// List returns the filings recorded on a job
+func (s *FilingService) List(ctx context.Context, jobID int) ([]models.JobFiling, error) {
+	return s.filingRepo.JobFilings(ctx, jobID)
+}
+
+// MatchFilingRules returns the filings required for goods under hsnCode
+// shipped from country. Rules naming the same filing are merged: the most
+// specific rule supplies the description and the documents are combined.
+func MatchFilingRules(rules []models.FilingRule, hsnCode, country string) []models.JobFiling {
+	hsnCode = tradeid.Normalize(hsnCode)
+	country = strings.TrimSpace(country)
+
+	var matched []models.FilingRule
+	for _, rule := range rules {
+		if rule.Active && ruleMatches(rule, hsnCode, country) {
+			matched = append(matched, rule)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return specificity(matched[i]) > specificity(matched[j])
+	})
+
+	var filings []models.JobFiling
+	index := make(map[string]int)
+	for _, rule := range matched {
+		key := strings.ToLower(rule.Filing)
+		i, seen := index[key]
+		if !seen {
+			id := rule.ID
+			i = len(filings)
+			index[key] = i
+			filings = append(filings, models.JobFiling{
+				RuleID:      &id,
+				Filing:      rule.Filing,
+				Description: rule.Description,
+				Documents:   []string{},
+				Status:      models.FilingPending,
+			})
+		}
+		filings[i].Documents = appendMissing(filings[i].Documents, rule.Documents)
+	}
+	return filings
+}
+
+// ruleMatches reports whether a rule applies. A rule with neither prefix
+// nor country never matches, and an HSN prefix needs a code to match.
+func ruleMatches(rule models.FilingRule, hsnCode, country string) bool {
+	if rule.HSNPrefix == "" && rule.Country == "" {
+		return false
+	}
+	if rule.HSNPrefix != "" && (hsnCode == "" || !strings.HasPrefix(hsnCode, rule.HSNPrefix)) {
+		return false
+	}
+	if rule.Country != "" && !strings.EqualFold(rule.Country, country) {
+		return false
+	}
+	return true
+}
+
+// specificity ranks rules: a longer HSN prefix wins, then naming a country
+func specificity(rule models.FilingRule) int {
+	n := len(rule.HSNPrefix) * 2
+	if rule.Country != "" {
+		n++
+	}
+	return n
+}
+
+func appendMissing(list, items []string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// OpenFilingsWarning describes the job filings still open, or returns ""
+func OpenFilingsWarning(filings []models.JobFiling) string {
+	var open []string
+	for _, f := range filings {
+		if f.Open() {
+			open = append(open, fmt.Sprintf("%s (%s)", f.Filing, f.Status))
+		}
+	}
+	if len(open) == 0 {
+		return ""
+	}
+	noun := "filings are"
+	if len(open) == 1 {
+		noun = "filing is"
+	}
+	return fmt.Sprintf("%d required %s still open: %s", len(open), noun, strings.Join(open, ", "))
+}
+
+// RuleFromRequest validates a rule request and builds the rule it describes
+func RuleFromRequest(req *models.FilingRuleRequest) (*models.FilingRule, error) {
+	rule := &models.FilingRule{
+		HSNPrefix:   tradeid.Normalize(req.HSNPrefix),
+		Country:     strings.TrimSpace(req.Country),
+		Filing:      strings.TrimSpace(req.Filing),
+		Description: strings.TrimSpace(req.Description),
+		Documents:   []string{},
+		Active:      req.Active == nil || *req.Active,
+	}
+	for _, d := range req.Documents {
+		if d = strings.TrimSpace(d); d != "" {
+			rule.Documents = appendMissing(rule.Documents, []string{d})
+		}
+	}
+
+	var errs validation.Errors
+	if rule.HSNPrefix == "" && rule.Country == "" {
+		errs.Add("hsn_prefix", "hsn_prefix or country is required")
+	}
+	if p := rule.HSNPrefix; p != "" && (len(p) < 2 || len(p) > 8 || strings.Trim(p, "0123456789") != "") {
+		errs.Add("hsn_prefix", "must be 2 to 8 digits")
+	}
+	if rule.Filing == "" {
+		errs.Add("filing", "is required")
+	}
+	return rule, errs.Err()
+}
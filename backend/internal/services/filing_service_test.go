@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/validation"
+)
+
+var filingRules = []models.FilingRule{
+	{ID: 1, HSNPrefix: "85", Filing: "BIS", Description: "BIS for electronics", Documents: []string{"BIS certificate"}, Active: true},
+	{ID: 2, HSNPrefix: "8504", Filing: "bis", Description: "BIS for power supplies", Documents: []string{"R-number on label", "BIS Certificate"}, Active: true},
+	{ID: 3, HSNPrefix: "8517", Filing: "WPC", Description: "WPC approval", Documents: []string{"ETA certificate"}, Active: true},
+	{ID: 4, Country: "CN", Filing: "Anti-dumping", Description: "Anti-dumping declaration", Documents: []string{"Certificate of origin"}, Active: true},
+	{ID: 5, HSNPrefix: "8504", Country: "CN", Filing: "BIS", Description: "BIS for power supplies from China", Documents: []string{"Factory registration"}, Active: true},
+	{ID: 6, HSNPrefix: "09", Filing: "FSSAI", Description: "FSSAI clearance", Documents: []string{"FSSAI licence"}},
+	{ID: 7, Filing: "Anything", Description: "Matches nothing", Active: true},
+}
+
+func TestMatchFilingRules(t *testing.T) {
+	type filing struct {
+		ruleID      int
+		filing      string
+		description string
+		documents   []string
+	}
+	tests := []struct {
+		name    string
+		hsnCode string
+		country string
+		want    []filing
+	}{
+		{
+			name: "longest prefix supplies the description", hsnCode: "8504.40.90", country: "US",
+			want: []filing{{2, "bis", "BIS for power supplies", []string{"R-number on label", "BIS Certificate"}}},
+		},
+		{
+			name: "country breaks a prefix tie and documents merge", hsnCode: "85044090", country: "cn",
+			want: []filing{
+				{5, "BIS", "BIS for power supplies from China", []string{"Factory registration", "R-number on label", "BIS Certificate"}},
+				{4, "Anti-dumping", "Anti-dumping declaration", []string{"Certificate of origin"}},
+			},
+		},
+		{
+			name: "short prefix alone", hsnCode: "85011000",
+			want: []filing{{1, "BIS", "BIS for electronics", []string{"BIS certificate"}}},
+		},
+		{
+			name: "country-only rule without an HSN code", country: "CN",
+			want: []filing{{4, "Anti-dumping", "Anti-dumping declaration", []string{"Certificate of origin"}}},
+		},
+		{name: "inactive rule", hsnCode: "09041100"},
+		{name: "no HSN code or country"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []filing
+			for _, f := range MatchFilingRules(filingRules, tt.hsnCode, tt.country) {
+				if f.RuleID == nil || f.Status != models.FilingPending {
+					t.Errorf("%s: rule %v, status %q, want a rule and pending", f.Filing, f.RuleID, f.Status)
+					continue
+				}
+				got = append(got, filing{*f.RuleID, f.Filing, f.Description, f.Documents})
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b filing) bool {
+				return a.ruleID == b.ruleID && a.filing == b.filing && a.description == b.description &&
+					slices.Equal(a.documents, b.documents)
+			}) {
+				t.Errorf("MatchFilingRules(%q, %q) = %+v, want %+v", tt.hsnCode, tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.FilingRule
+		hsnCode string
+		country string
+		want    bool
+	}{
+		{name: "empty rule", rule: models.FilingRule{}, hsnCode: "85044090", country: "CN"},
+		{name: "prefix", rule: models.FilingRule{HSNPrefix: "8504"}, hsnCode: "85044090", want: true},
+		{name: "prefix without a code", rule: models.FilingRule{HSNPrefix: "8504"}, country: "CN"},
+		{name: "other prefix", rule: models.FilingRule{HSNPrefix: "8517"}, hsnCode: "85044090"},
+		{name: "country any case", rule: models.FilingRule{Country: "CN"}, country: "cn", want: true},
+		{name: "other country", rule: models.FilingRule{Country: "CN"}, country: "US"},
+		{name: "prefix and country", rule: models.FilingRule{HSNPrefix: "85", Country: "CN"}, hsnCode: "85044090", country: "CN", want: true},
+		{name: "prefix but not country", rule: models.FilingRule{HSNPrefix: "85", Country: "CN"}, hsnCode: "85044090", country: "US"},
+	}
+	for _, tt := range tests {
+		if got := ruleMatches(tt.rule, tt.hsnCode, tt.country); got != tt.want {
+			t.Errorf("%s: ruleMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	ranked := []models.FilingRule{
+		{HSNPrefix: "85044090"},
+		{HSNPrefix: "8504", Country: "CN"},
+		{HSNPrefix: "8504"},
+		{HSNPrefix: "85", Country: "CN"},
+		{HSNPrefix: "85"},
+		{Country: "CN"},
+	}
+	for i := 1; i < len(ranked); i++ {
+		if specificity(ranked[i-1]) <= specificity(ranked[i]) {
+			t.Errorf("%+v does not outrank %+v", ranked[i-1], ranked[i])
+		}
+	}
+}
+
+func TestRuleFromRequest(t *testing.T) {
+	inactive := false
+	tests := []struct {
+		name   string
+		req    models.FilingRuleRequest
+		want   models.FilingRule
+		fields []string
+	}{
+		{
+			name: "normalized",
+			req: models.FilingRuleRequest{HSNPrefix: " 85.04 ", Country: " CN ", Filing: " BIS ",
+				Description: " BIS registration ", Documents: []string{"BIS certificate", " ", "bis certificate", "Label"}},
+			want: models.FilingRule{HSNPrefix: "8504", Country: "CN", Filing: "BIS", Description: "BIS registration",
+				Documents: []string{"BIS certificate", "Label"}, Active: true},
+		},
+		{
+			name: "country only, inactive",
+			req:  models.FilingRuleRequest{Country: "CN", Filing: "Anti-dumping", Active: &inactive},
+			want: models.FilingRule{Country: "CN", Filing: "Anti-dumping", Documents: []string{}},
+		},
+		{
+			name:   "empty rule",
+			req:    models.FilingRuleRequest{Filing: "BIS"},
+			fields: []string{"hsn_prefix"},
+		},
+		{
+			name:   "bad prefix and no filing",
+			req:    models.FilingRuleRequest{HSNPrefix: "8"},
+			fields: []string{"hsn_prefix", "filing"},
+		},
+		{
+			name:   "prefix too long",
+			req:    models.FilingRuleRequest{HSNPrefix: "850440901", Filing: "BIS"},
+			fields: []string{"hsn_prefix"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := RuleFromRequest(&tt.req)
+			if tt.fields != nil {
+				var errs validation.Errors
+				if !errors.As(err, &errs) {
+					t.Fatalf("RuleFromRequest = %v, want validation errors", err)
+				}
+				var fields []string
+				for _, fe := range errs {
+					fields = append(fields, fe.Field)
+				}
+				if !slices.Equal(fields, tt.fields) {
+					t.Errorf("RuleFromRequest rejected %v, want %v", fields, tt.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RuleFromRequest failed: %v", err)
+			}
+			if rule.HSNPrefix != tt.want.HSNPrefix || rule.Country != tt.want.Country || rule.Filing != tt.want.Filing ||
+				rule.Description != tt.want.Description || rule.Active != tt.want.Active ||
+				!slices.Equal(rule.Documents, tt.want.Documents) {
+				t.Errorf("RuleFromRequest = %+v, want %+v", *rule, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+)
+
+const (
+	// hsnKeywordCandidates bounds how many tariff entries are scored per suggestion
+	hsnKeywordCandidates = 200
+
+	// hsnHistoryThreshold is the least commodity similarity for a past job to count
+	hsnHistoryThreshold = 0.5
+
+	// hsnHistoryWeight makes one similar past job outweigh a keyword match
+	hsnHistoryWeight = 2.0
+)
+
+// hsnStopwords are commodity words too common to say anything about the goods
+var hsnStopwords = map[string]bool{
+	"and": true, "the": true, "for": true, "with": true, "other": true,
+	"others": true, "parts": true, "part": true, "nos": true, "pcs": true,
+	"set": true, "sets": true, "new": true, "used": true, "assorted": true,
+	"goods": true, "items": true, "item": true, "type": true, "made": true,
+}
+
+// defaultSWSRate is the Social Welfare Surcharge, as a percentage of BCD,
+// that applies unless a tariff item is exempted
+const defaultSWSRate = 10
+
+// hsnColumns are the CSV headers the importer understands. Only code and
+// description are required; a missing sws_rate column means the standard 10%.
+var hsnColumns = map[string][]string{
+	"code":        {"code", "hsn", "hsn_code", "cth", "tariff_item"},
+	"description": {"description", "desc"},
+	"unit":        {"unit", "uqc"},
+	"bcd_rate":    {"bcd_rate", "bcd", "basic_duty"},
+	"sws_rate":    {"sws_rate", "sws", "social_welfare_surcharge"},
+	"igst_rate":   {"igst_rate", "igst"},
+	"cess_rate":   {"cess_rate", "cess"},
+}
+
+// HSNService loads the tariff master and suggests codes for commodities
+type HSNService struct {
+	hsnRepo *repository.HSNRepository
+}
+
+// NewHSNService creates a new HSN service
+func NewHSNService(hsnRepo *repository.HSNRepository) *HSNService {
+	return &HSNService{hsnRepo: hsnRepo}
+}
+
+// Import reads a tariff CSV and upserts every valid row. Invalid rows are
+// skipped and reported with their line numbers.
+func (s *HSNService) Import(ctx context.Context, r io.Reader) (*models.HSNImportResult, error) {
+	codes, result, err := ParseHSNCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hsnRepo.Upsert(ctx, codes); err != nil {
+		return nil, fmt.Errorf("saving HSN codes: %w", err)
+	}
+	result.Imported = len(codes)
+	return result, nil
+}
+
+// ParseHSNCSV parses a tariff CSV with a header row. Codes may be written
+// with dots (8471.30.10); rates may carry a % sign or read "Free".
+func ParseHSNCSV(r io.Reader) ([]models.HSNCode, *models.HSNImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: reading CSV header: %v", ErrInvalidFile, err)
+	}
+	index := make(map[string]int)
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for column, aliases := range hsnColumns {
+			for _, alias := range aliases {
+				if name == alias {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, required := range []string{"code", "description"} {
+		if _, ok := index[required]; !ok {
+			return nil, nil, fmt.Errorf("%w: CSV header has no %s column", ErrInvalidFile, required)
+		}
+	}
+
+	result := &models.HSNImportResult{}
+	seen := make(map[string]int)
+	var codes []models.HSNCode
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: reading CSV line %d: %v", ErrInvalidFile, line, err)
+		}
+
+		code, err := parseHSNRow(record, index)
+		if err != nil {
+			result.Skipped++
+			result.Errors = append(result.Errors, models.HSNImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		// Later rows win, as they would in the database
+		if i, dup := seen[code.Code]; dup {
+			codes[i] = code
+			continue
+		}
+		seen[code.Code] = len(codes)
+		codes = append(codes, code)
+	}
+	return codes, result, nil
+}
+
+func parseHSNRow(record []string, index map[string]int) (models.HSNCode, error) {
+	field := func(column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	code := models.HSNCode{
+		Code:        tradeid.Normalize(field("code")),
+		Description: field("description"),
+		Unit:        strings.ToUpper(field("unit")),
+	}
+	if err := tradeid.ValidateHSN(code.Code); err != nil {
+		return code, fmt.Errorf("code %q %w", field("code"), err)
+	}
+	if code.Description == "" {
+		return code, errors.New("description is empty")
+	}
+
+	rates := []struct {
+		column string
+		dest   *float64
+	}{
+		{"bcd_rate", &code.BCDRate},
+		{"sws_rate", &code.SWSRate},
+		{"igst_rate", &code.IGSTRate},
+		{"cess_rate", &code.CessRate},
+	}
+	code.SWSRate = defaultSWSRate
+	for _, rate := range rates {
+		if _, ok := index[rate.column]; !ok {
+			continue
+		}
+		v, err := parseRate(field(rate.column))
+		if err != nil {
+			return code, fmt.Errorf("%s: %w", rate.column, err)
+		}
+		*rate.dest = v
+	}
+	return code, nil
+}
+
+// parseRate reads a percentage such as "7.5", "7.5%" or "Free"
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	switch strings.ToLower(s) {
+	case "", "free", "nil", "-":
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a rate", s)
+	}
+	if v < 0 || v > 1000 {
+		return 0, fmt.Errorf("%v is out of range", v)
+	}
+	return v, nil
+}
+
+// Suggest ranks HSN codes for a commodity description. Codes filed on past
+// jobs with a similar commodity score highest; tariff descriptions sharing
+// its keywords follow. excludeJobID keeps a job from suggesting its own code.
+func (s *HSNService) Suggest(ctx context.Context, commodity string, excludeJobID, limit int) ([]models.HSNSuggestion, error) {
+	words := commodityKeywords(commodity)
+	if len(words) == 0 {
+		return []models.HSNSuggestion{}, nil
+	}
+
+	history, err := s.hsnRepo.CommodityHistory(ctx, excludeJobID)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := s.hsnRepo.SearchByKeywords(ctx, words, false, hsnKeywordCandidates)
+	if err != nil {
+		return nil, err
+	}
+	return rankHSN(words, history, candidates, func(codes []string) (map[string]models.HSNCode, error) {
+		return s.hsnRepo.GetByCodes(ctx, codes)
+	}, limit)
+}
+
+// rankHSN scores the codes of past jobs whose commodity resembles words and
+// the tariff candidates whose description shares them, and returns the best
+// limit. lookup supplies the tariff details of codes only known from history.
+func rankHSN(words []string, history []models.CommodityHSN, candidates []models.HSNCode,
+	lookup func(codes []string) (map[string]models.HSNCode, error), limit int) ([]models.HSNSuggestion, error) {
+	scores := make(map[string]*models.HSNSuggestion)
+	entry := func(code string) *models.HSNSuggestion {
+		if scores[code] == nil {
+			scores[code] = &models.HSNSuggestion{HSNCode: models.HSNCode{Code: code}}
+		}
+		return scores[code]
+	}
+
+	jobs := make(map[string]int)
+	for _, h := range history {
+		sim := similarity(words, commodityKeywords(h.Commodity))
+		if sim < hsnHistoryThreshold {
+			continue
+		}
+		code := tradeid.Normalize(h.HSNCode)
+		entry(code).Score += hsnHistoryWeight * sim
+		jobs[code]++
+	}
+
+	for _, c := range candidates {
+		desc := strings.ToLower(c.Description)
+		var matched []string
+		for _, w := range words {
+			if strings.Contains(desc, w) {
+				matched = append(matched, w)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		e := entry(c.Code)
+		e.HSNCode = c
+		e.Score += float64(len(matched)) / float64(len(words))
+		e.Reasons = append(e.Reasons, "description matches "+strings.Join(matched, ", "))
+	}
+
+	// Fill in tariff details for codes only known from history
+	var missing []string
+	for code, e := range scores {
+		if e.Description == "" {
+			missing = append(missing, code)
+		}
+	}
+	known, err := lookup(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]models.HSNSuggestion, 0, len(scores))
+	for code, e := range scores {
+		if c, ok := known[code]; ok {
+			e.HSNCode = c
+		}
+		if n := jobs[code]; n > 0 {
+			reason := fmt.Sprintf("used on %d similar jobs", n)
+			if n == 1 {
+				reason = "used on 1 similar job"
+			}
+			e.Reasons = append([]string{reason}, e.Reasons...)
+		}
+		e.Score = float64(int(e.Score*1000+0.5)) / 1000
+		suggestions = append(suggestions, *e)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Code < suggestions[j].Code
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// commodityKeywords lowercases text and keeps the distinct words that
+// describe goods, with a plural "s" trimmed so "laptops" finds "laptop"
+func commodityKeywords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var words []string
+	for _, f := range fields {
+		if len(f) < 3 || hsnStopwords[f] || isNumber(f) {
+			continue
+		}
+		if len(f) > 4 && strings.HasSuffix(f, "s") && !strings.HasSuffix(f, "ss") {
+			f = strings.TrimSuffix(f, "s")
+		}
+		if !seen[f] {
+			seen[f] = true
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// similarity is the Jaccard index of two keyword sets
+func similarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(a))
+	for _, w := range a {
+		set[w] = true
+	}
+	common := 0
+	for _, w := range b {
+		if set[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
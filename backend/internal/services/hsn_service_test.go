@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestParseHSNCSV(t *testing.T) {
+	csv := "\ufeffHSN, Desc,UQC,BCD,IGST,Cess\n" +
+		"8471.30.10,Laptops,nos,Free,18%,\n" +
+		"8517 13 00,Smartphones,NOS,20,18,nil\n" +
+		"84713,Too short,NOS,10,18,0\n" +
+		"85171300,Smartphones (revised),NOS,15%,18,\n" +
+		"09041100,,KGS,70,5,0\n" +
+		"09041200,Pepper crushed,KGS,seventy,5,0\n"
+	codes, result, err := ParseHSNCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("ParseHSNCSV failed: %v", err)
+	}
+
+	want := []models.HSNCode{
+		{Code: "84713010", Description: "Laptops", Unit: "NOS", BCDRate: 0, SWSRate: 10, IGSTRate: 18},
+		{Code: "85171300", Description: "Smartphones (revised)", Unit: "NOS", BCDRate: 15, SWSRate: 10, IGSTRate: 18},
+	}
+	if !slices.Equal(codes, want) {
+		t.Errorf("codes = %+v, want %+v", codes, want)
+	}
+
+	if result.Skipped != 3 {
+		t.Errorf("skipped %d rows, want 3", result.Skipped)
+	}
+	wantErrors := []struct {
+		line int
+		text string
+	}{
+		{4, `code "84713" must be 4, 6 or 8 digits`},
+		{6, "description is empty"},
+		{7, `bcd_rate: "seventy" is not a rate`},
+	}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("errors = %+v, want %d", result.Errors, len(wantErrors))
+	}
+	for i, e := range result.Errors {
+		if e.Line != wantErrors[i].line || !strings.Contains(e.Message, wantErrors[i].text) {
+			t.Errorf("error %d = line %d %q, want line %d containing %q",
+				i, e.Line, e.Message, wantErrors[i].line, wantErrors[i].text)
+		}
+	}
+}
+
+func TestParseHSNCSVHeader(t *testing.T) {
+	for name, csv := range map[string]string{
+		"no code column":        "description,bcd\nLaptops,10\n",
+		"no description column": "hsn_code,bcd\n84713010,10\n",
+		"empty file":            "",
+	} {
+		if _, _, err := ParseHSNCSV(strings.NewReader(csv)); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%s: ParseHSNCSV = %v, want ErrInvalidFile", name, err)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"7.5", 7.5, true},
+		{" 7.5 % ", 7.5, true},
+		{"Free", 0, true},
+		{"NIL", 0, true},
+		{"-", 0, true},
+		{"", 0, true},
+		{"ten", 0, false},
+		{"-5", 0, false},
+		{"1001", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRate(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCommodityKeywords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Laptops, 20 Nos", []string{"laptop"}},
+		{"Glass bottles and other parts", []string{"glass", "bottle"}},
+		{"LED-TV sets; TV sets", []string{"led"}},
+		{"Steel pipes & steel tubes", []string{"steel", "pipe", "tube"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := commodityKeywords(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("commodityKeywords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want float64
+	}{
+		{[]string{"laptop", "computer"}, []string{"computer", "laptop"}, 1},
+		{[]string{"laptop", "computer"}, []string{"laptop", "computer", "bag"}, 2.0 / 3},
+		{[]string{"laptop"}, []string{"pepper"}, 0},
+		{nil, []string{"pepper"}, 0},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRankHSN(t *testing.T) {
+	words := commodityKeywords("Laptops, computer")
+	history := []models.CommodityHSN{
+		{JobID: 1, Commodity: "Laptop computers", HSNCode: "8471.30.10"},
+		{JobID: 2, Commodity: "Laptop computer bags", HSNCode: "42021290"},
+		{JobID: 3, Commodity: "Mobile phones", HSNCode: "85171300"},
+	}
+	candidates := []models.HSNCode{
+		{Code: "84713010", Description: "Portable laptop computer"},
+		{Code: "84714900", Description: "Other computer systems"},
+		{Code: "84733020", Description: "Motherboards"},
+	}
+	var looked []string
+	lookup := func(codes []string) (map[string]models.HSNCode, error) {
+		looked = codes
+		return map[string]models.HSNCode{"42021290": {Code: "42021290", Description: "Travel bags"}}, nil
+	}
+
+	got, err := rankHSN(words, history, candidates, lookup, 10)
+	if err != nil {
+		t.Fatalf("rankHSN failed: %v", err)
+	}
+	want := []models.HSNSuggestion{
+		{HSNCode: models.HSNCode{Code: "84713010", Description: "Portable laptop computer"}, Score: 3,
+			Reasons: []string{"used on 1 similar job", "description matches laptop, computer"}},
+		{HSNCode: models.HSNCode{Code: "42021290", Description: "Travel bags"}, Score: 1.333,
+			Reasons: []string{"used on 1 similar job"}},
+		{HSNCode: models.HSNCode{Code: "84714900", Description: "Other computer systems"}, Score: 0.5,
+			Reasons: []string{"description matches computer"}},
+	}
+	if !slices.EqualFunc(got, want, func(a, b models.HSNSuggestion) bool {
+		return a.HSNCode == b.HSNCode && a.Score == b.Score && slices.Equal(a.Reasons, b.Reasons)
+	}) {
+		t.Errorf("rankHSN = %+v, want %+v", got, want)
+	}
+	if !slices.Equal(looked, []string{"42021290"}) {
+		t.Errorf("looked up %q, want only the code known from history", looked)
+	}
+
+	if got, _ := rankHSN(words, history, candidates, lookup, 1); len(got) != 1 || got[0].Code != "84713010" {
+		t.Errorf("rankHSN limited to 1 = %+v, want 84713010", got)
+	}
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/igm"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+)
+
+// IGMService reconciles IGM manifests with jobs
+type IGMService struct {
+	igmRepo      *repository.IGMRepository
+	pipelineRepo *repository.PipelineRepository
+}
+
+// NewIGMService creates a new IGM service
+func NewIGMService(igmRepo *repository.IGMRepository, pipelineRepo *repository.PipelineRepository) *IGMService {
+	return &IGMService{
+		igmRepo:      igmRepo,
+		pipelineRepo: pipelineRepo,
+	}
+}
+
+// Import reads a manifest and reconciles every line with the jobs. Lines
+// matching one job update its IGM number and date, packages and weight,
+// unless dryRun is set. Unmatched lines for known consignees come back
+// with a proposed draft job.
+func (s *IGMService) Import(ctx context.Context, r io.Reader, userID int, dryRun bool) (*models.IGMImportResult, error) {
+	manifest, err := igm.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	result := &models.IGMImportResult{
+		DryRun:      dryRun,
+		CustomHouse: manifest.CustomHouse,
+		Lines:       len(manifest.Lines),
+		Results:     []models.IGMLineResult{},
+	}
+	for _, e := range manifest.Errors {
+		result.Errors = append(result.Errors, models.IGMImportError{Line: e.Line, Message: e.Message})
+	}
+
+	var bls, containers []string
+	for _, line := range manifest.Lines {
+		for _, bl := range []string{line.HBLNo, line.MBLNo} {
+			if bl != "" {
+				bls = append(bls, bl)
+			}
+		}
+		containers = append(containers, line.ContainerNumbers()...)
+	}
+	jobs, err := s.igmRepo.ManifestCandidates(ctx, bls, containers)
+	if err != nil {
+		return nil, fmt.Errorf("finding jobs: %w", err)
+	}
+	consignees, err := s.knownConsignees(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading consignees: %w", err)
+	}
+
+	// A job takes the first line that matches it; a later line matching the
+	// same job is reported rather than overwriting it
+	claimed := make(map[int]string)
+	for i := range manifest.Lines {
+		line := &manifest.Lines[i]
+		res := newIGMLineResult(line)
+
+		matches, by := matchManifestLine(line, jobs)
+		switch {
+		case len(matches) == 0:
+			res.Status = models.IGMLineUnmatched
+			if name, ok := consignees[repository.PartyKey(line.Importer)]; ok {
+				res.OurConsignee = true
+				if res.Draft, err = s.draftJob(ctx, manifest, line, name); err != nil {
+					return nil, err
+				}
+				result.Drafts++
+			}
+		case len(matches) > 1:
+			res.Status = models.IGMLineAmbiguous
+			res.MatchedBy = by
+			for _, j := range matches {
+				res.Candidates = append(res.Candidates, jobs[j].JobNo)
+			}
+			res.Message = fmt.Sprintf("matches %d jobs by %s", len(matches), by)
+		default:
+			job := &jobs[matches[0]]
+			res.MatchedBy = by
+			if ref, ok := claimed[job.JobID]; ok {
+				res.Status = models.IGMLineAmbiguous
+				res.Candidates = []string{job.JobNo}
+				res.Message = "job " + job.JobNo + " already matched line " + ref
+				break
+			}
+			claimed[job.JobID] = line.Ref()
+			res.JobID = job.JobID
+			res.JobNo = job.JobNo
+
+			updated := *job
+			res.Changes = applyManifestLine(&updated, line)
+			if len(res.Changes) == 0 {
+				res.Status = models.IGMLineUnchanged
+				break
+			}
+			res.Status = models.IGMLineUpdated
+			if !dryRun {
+				if err := s.igmRepo.UpdateManifestFields(ctx, &updated, userID, manifestUpdateMessage(line, res.Changes)); err != nil {
+					return nil, fmt.Errorf("updating job %s: %w", job.JobNo, err)
+				}
+				*job = updated
+			}
+		}
+
+		switch res.Status {
+		case models.IGMLineUpdated:
+			result.Updated++
+		case models.IGMLineUnchanged:
+			result.Unchanged++
+		case models.IGMLineAmbiguous:
+			result.Ambiguous++
+		case models.IGMLineUnmatched:
+			result.Unmatched++
+		}
+		result.Results = append(result.Results, res)
+	}
+	return result, nil
+}
+
+// CreateDrafts creates draft jobs, typically the ones an import proposed.
+// Each job is created on its own; the errors are keyed by job number.
+func (s *IGMService) CreateDrafts(ctx context.Context, reqs []models.Stage1CreateRequest, userID int) ([]*models.PipelineJobResponse, map[string]string) {
+	created := []*models.PipelineJobResponse{}
+	failed := make(map[string]string)
+	for i := range reqs {
+		req := reqs[i]
+		req.Status = models.JobStatusDraft
+		job, err := s.pipelineRepo.CreateJob(ctx, &req, userID)
+		switch {
+		case errors.Is(err, repository.ErrDuplicate):
+			failed[req.JobNo] = "job number already exists"
+		case err != nil:
+			failed[req.JobNo] = err.Error()
+		default:
+			created = append(created, job)
+		}
+	}
+	return created, failed
+}
+
+// knownConsignees maps the party key of every consignee on record to the
+// name as it was recorded
+func (s *IGMService) knownConsignees(ctx context.Context) (map[string]string, error) {
+	names, err := s.igmRepo.ConsigneeNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]string, len(names))
+	for _, name := range names {
+		if key := repository.PartyKey(name); key != "" {
+			known[key] = name
+		}
+	}
+	return known, nil
+}
+
+// draftJob proposes a job for unmatched cargo of a known consignee
+func (s *IGMService) draftJob(ctx context.Context, manifest *igm.Manifest, line *igm.Line, consignee string) (*models.Stage1CreateRequest, error) {
+	jobNo := fmt.Sprintf("IGM%s-%d", line.IGMNo, line.LineNo)
+	if line.SubLineNo > 0 {
+		jobNo += "-" + strconv.Itoa(line.SubLineNo)
+	}
+
+	draft := &models.Stage1CreateRequest{
+		JobNo:                jobNo,
+		Consignee:            consignee,
+		PortOfDischarge:      manifest.CustomHouse,
+		FinalPlaceOfDelivery: line.PortOfDestination,
+		PortOfLoading:        line.PortOfLoading,
+		HBLNo:                line.HBLNo,
+		HBLDate:              isoDate(line.HBLDate),
+		MBLNo:                line.MBLNo,
+		MBLDate:              isoDate(line.MBLDate),
+		Weight:               line.Weight,
+		Packages:             line.Packages,
+		Commodity:            line.Description,
+		Status:               models.JobStatusDraft,
+	}
+	if line.Local() {
+		draft.LocalIGM = line.IGMNo
+		draft.LocalIGMDate = line.IGMDate.Format("2006-01-02")
+	} else {
+		draft.GatewayIGM = line.IGMNo
+		draft.GatewayIGMDate = line.IGMDate.Format("2006-01-02")
+	}
+	for _, c := range line.Containers {
+		draft.Containers = append(draft.Containers, models.ContainerRequest{
+			ContainerNo: c.Number,
+			Size:        containerSize(c),
+		})
+	}
+
+	ids, err := s.pipelineRepo.LastPartyIdentifiers(ctx, "consignee", consignee)
+	if err != nil {
+		return nil, err
+	}
+	draft.ConsigneeGSTIN = ids.GSTIN
+	draft.ConsigneeIEC = ids.IEC
+	return draft, nil
+}
+
+func newIGMLineResult(line *igm.Line) models.IGMLineResult {
+	igmType := "gateway"
+	if line.Local() {
+		igmType = "local"
+	}
+	return models.IGMLineResult{
+		Line:       line.Ref(),
+		IGMNo:      line.IGMNo,
+		IGMDate:    line.IGMDate.Format("2006-01-02"),
+		IGMType:    igmType,
+		MBLNo:      line.MBLNo,
+		HBLNo:      line.HBLNo,
+		Containers: line.ContainerNumbers(),
+		Importer:   line.Importer,
+	}
+}
+
+// matchManifestLine returns the indexes of the jobs a manifest line may
+// belong to and what matched. An HBL match beats an MBL match, which
+// beats a shared container. An MBL match needs the line or the job to
+// have no HBL, as one master bill covers many house bills; a container
+// match is ignored when the bills of lading disagree.
+func matchManifestLine(line *igm.Line, jobs []models.ManifestJob) ([]int, string) {
+	var byHBL, byMBL, byContainer []int
+	for i := range jobs {
+		job := &jobs[i]
+		switch {
+		case line.HBLNo != "" && job.HBLNo == line.HBLNo:
+			byHBL = append(byHBL, i)
+		case line.MBLNo != "" && job.MBLNo == line.MBLNo && (line.HBLNo == "" || job.HBLNo == ""):
+			byMBL = append(byMBL, i)
+		case sharesContainer(job, line) && !conflictingBL(job, line):
+			byContainer = append(byContainer, i)
+		}
+	}
+	switch {
+	case len(byHBL) > 0:
+		return byHBL, "hbl"
+	case len(byMBL) > 0:
+		return byMBL, "mbl"
+	case len(byContainer) > 0:
+		return byContainer, "container"
+	}
+	return nil, ""
+}
+
+func sharesContainer(job *models.ManifestJob, line *igm.Line) bool {
+	for _, c := range line.Containers {
+		if slices.Contains(job.Containers, c.Number) {
+			return true
+		}
+	}
+	return false
+}
+
+func conflictingBL(job *models.ManifestJob, line *igm.Line) bool {
+	return job.HBLNo != "" && line.HBLNo != "" && job.HBLNo != line.HBLNo ||
+		job.MBLNo != "" && line.MBLNo != "" && job.MBLNo != line.MBLNo
+}
+
+// applyManifestLine copies a line's IGM number and date, packages and
+// weight onto job and returns what changed. The manifest is the local IGM
+// for cargo cleared where it was filed and the gateway IGM otherwise.
+// Packages and weight the manifest leaves blank are kept.
+func applyManifestLine(job *models.ManifestJob, line *igm.Line) []models.IGMFieldChange {
+	var changes []models.IGMFieldChange
+	set := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, models.IGMFieldChange{Field: field, Old: from, New: to})
+		}
+	}
+
+	date := line.IGMDate
+	if line.Local() {
+		set("local_igm", job.LocalIGM, line.IGMNo)
+		set("local_igm_date", isoDate(job.LocalIGMDate), isoDate(&date))
+		job.LocalIGM, job.LocalIGMDate = line.IGMNo, &date
+	} else {
+		set("gateway_igm", job.GatewayIGM, line.IGMNo)
+		set("gateway_igm_date", isoDate(job.GatewayIGMDate), isoDate(&date))
+		job.GatewayIGM, job.GatewayIGMDate = line.IGMNo, &date
+	}
+	if line.Packages > 0 {
+		set("packages", strconv.Itoa(job.Packages), strconv.Itoa(line.Packages))
+		job.Packages = line.Packages
+	}
+	if line.Weight > 0 {
+		// stage1_data keeps weight to two decimals
+		weight := math.Round(line.Weight*100) / 100
+		set("weight", formatWeight(job.Weight), formatWeight(weight))
+		job.Weight = weight
+	}
+	return changes
+}
+
+func manifestUpdateMessage(line *igm.Line, changes []models.IGMFieldChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		old := c.Old
+		if old == "" {
+			old = "(blank)"
+		}
+		parts[i] = fmt.Sprintf("%s %s -> %s", c.Field, old, c.New)
+	}
+	return fmt.Sprintf("IGM %s line %s imported: %s", line.IGMNo, line.Ref(), strings.Join(parts, ", "))
+}
+
+// containerSize reads the stage 1 container size from a manifest
+// container: LCL status, else the length digit of the ISO type code
+func containerSize(c igm.Container) string {
+	switch {
+	case c.Status == "LCL":
+		return "LCL"
+	case strings.HasPrefix(c.ISOCode, "2"):
+		return "20"
+	case strings.HasPrefix(c.ISOCode, "4"), strings.HasPrefix(c.ISOCode, "L"):
+		return "40"
+	}
+	return ""
+}
+
+func isoDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func formatWeight(kg float64) string {
+	return strconv.FormatFloat(kg, 'f', 2, 64)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/igm"
+	"maydiv-crm/internal/models"
+)
+
+func TestMatchManifestLine(t *testing.T) {
+	jobs := []models.ManifestJob{
+		{JobNo: "JOB001", MBLNo: "MAEU1", HBLNo: "SHA1", Containers: []string{"CSQU3054383"}},
+		{JobNo: "JOB002", MBLNo: "MAEU1", HBLNo: "SHA2", Containers: []string{"MSKU1234565"}},
+		{JobNo: "JOB003", MBLNo: "MAEU2", Containers: []string{"TGHU1234562"}},
+		{JobNo: "JOB004", Containers: []string{"TGHU1234562", "CSQU3054383"}},
+	}
+	containers := func(numbers ...string) []igm.Container {
+		var cs []igm.Container
+		for _, n := range numbers {
+			cs = append(cs, igm.Container{Number: n})
+		}
+		return cs
+	}
+	tests := []struct {
+		name string
+		line igm.Line
+		want []int
+		by   string
+	}{
+		{name: "house bill", line: igm.Line{MBLNo: "MAEU1", HBLNo: "SHA2"}, want: []int{1}, by: "hbl"},
+		{name: "house bill beats a container", line: igm.Line{HBLNo: "SHA1", Containers: containers("TGHU1234562")}, want: []int{0}, by: "hbl"},
+		{name: "master bill of a job without house bill", line: igm.Line{MBLNo: "MAEU2", HBLNo: "SHA9"}, want: []int{2}, by: "mbl"},
+		{name: "master bill of a line without house bill", line: igm.Line{MBLNo: "MAEU1"}, want: []int{0, 1}, by: "mbl"},
+		{name: "master bill, another house bill", line: igm.Line{MBLNo: "MAEU1", HBLNo: "SHA3"}},
+		{name: "container", line: igm.Line{MBLNo: "MAEU9", Containers: containers("TGHU1234562")}, want: []int{3}, by: "container"},
+		{name: "container of a job without bills", line: igm.Line{Containers: containers("CSQU3054383")}, want: []int{0, 3}, by: "container"},
+		{name: "container under another house bill", line: igm.Line{HBLNo: "SHA3", Containers: containers("MSKU1234565")}},
+		{name: "nothing shared", line: igm.Line{MBLNo: "MAEU9", HBLNo: "SHA9", Containers: containers("ABCU1234560")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, by := matchManifestLine(&tt.line, jobs)
+			if !slices.Equal(got, tt.want) || by != tt.by {
+				t.Errorf("matchManifestLine = %v by %q, want %v by %q", got, by, tt.want, tt.by)
+			}
+		})
+	}
+}
+
+func TestApplyManifestLine(t *testing.T) {
+	igmDate := time.Date(2026, 4, 10, 0, 0, 0, 0, time.UTC)
+	earlier := time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		job     models.ManifestJob
+		line    igm.Line
+		changes []models.IGMFieldChange
+	}{
+		{
+			name: "local IGM",
+			job:  models.ManifestJob{Packages: 100, Weight: 18000},
+			line: igm.Line{IGMNo: "2345678", IGMDate: igmDate, CargoMovement: igm.MovementLocal, Packages: 120, Weight: 18500.004},
+			changes: []models.IGMFieldChange{
+				{Field: "local_igm", New: "2345678"},
+				{Field: "local_igm_date", New: "2026-04-10"},
+				{Field: "packages", Old: "100", New: "120"},
+				{Field: "weight", Old: "18000.00", New: "18500.00"},
+			},
+		},
+		{
+			name: "gateway IGM already recorded",
+			job:  models.ManifestJob{GatewayIGM: "2345678", GatewayIGMDate: &earlier, Packages: 40, Weight: 950},
+			line: igm.Line{IGMNo: "2345678", IGMDate: igmDate, CargoMovement: igm.MovementTransshipICD, Packages: 40, Weight: 950},
+			changes: []models.IGMFieldChange{
+				{Field: "gateway_igm_date", Old: "2026-04-02", New: "2026-04-10"},
+			},
+		},
+		{
+			name: "packages and weight left blank",
+			job:  models.ManifestJob{LocalIGM: "2345678", LocalIGMDate: &igmDate, Packages: 40, Weight: 950},
+			line: igm.Line{IGMNo: "2345678", IGMDate: igmDate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := tt.job
+			changes := applyManifestLine(&job, &tt.line)
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("applyManifestLine = %+v, want %+v", changes, tt.changes)
+			}
+			if tt.line.Packages > 0 && job.Packages != tt.line.Packages {
+				t.Errorf("job packages %d, want %d", job.Packages, tt.line.Packages)
+			}
+		})
+	}
+}
+
+func TestContainerSize(t *testing.T) {
+	tests := []struct {
+		c    igm.Container
+		want string
+	}{
+		{igm.Container{Status: "FCL", ISOCode: "22G1"}, "20"},
+		{igm.Container{Status: "FCL", ISOCode: "45G1"}, "40"},
+		{igm.Container{Status: "FCL", ISOCode: "L5G1"}, "40"},
+		{igm.Container{Status: "LCL", ISOCode: "45G1"}, "LCL"},
+		{igm.Container{Status: "FCL"}, ""},
+	}
+	for _, tt := range tests {
+		if got := containerSize(tt.c); got != tt.want {
+			t.Errorf("containerSize(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
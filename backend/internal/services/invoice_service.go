@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/einvoice"
+	"maydiv-crm/internal/invoice"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// InvoiceDescription marks the stage 4 job files that hold generated
+// tax invoices
+const InvoiceDescription = "Tax invoice"
+
+// invoiceTemplateSetting names the invoice template in the settings table
+const invoiceTemplateSetting = "invoice_template"
+
+// InvoiceService renders tax invoice PDFs for stage 4 bills
+type InvoiceService struct {
+	pipelineRepo    *repository.PipelineRepository
+	einvoiceRepo    *repository.EInvoiceRepository
+	settingsRepo    *repository.SettingsRepository
+	einvoiceService *EInvoiceService
+	company         config.CompanyConfig
+	uploadDir       string
+}
+
+// NewInvoiceService creates a new invoice service
+func NewInvoiceService(pipelineRepo *repository.PipelineRepository, einvoiceRepo *repository.EInvoiceRepository, settingsRepo *repository.SettingsRepository, einvoiceService *EInvoiceService, company config.CompanyConfig, uploadDir string) *InvoiceService {
+	return &InvoiceService{
+		pipelineRepo:    pipelineRepo,
+		einvoiceRepo:    einvoiceRepo,
+		settingsRepo:    settingsRepo,
+		einvoiceService: einvoiceService,
+		company:         company,
+		uploadDir:       uploadDir,
+	}
+}
+
+// Template returns the invoice template, or the default until an admin
+// saves one
+func (s *InvoiceService) Template(ctx context.Context) (*models.InvoiceTemplate, error) {
+	t := models.DefaultInvoiceTemplate()
+	updatedBy, updatedAt, err := s.settingsRepo.Get(ctx, invoiceTemplateSetting, &t)
+	if errors.Is(err, repository.ErrNotFound) {
+		return &t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.UpdatedBy, t.UpdatedAt = &updatedBy, &updatedAt
+	return &t, nil
+}
+
+// SaveTemplate checks and saves the invoice template
+func (s *InvoiceService) SaveTemplate(ctx context.Context, t *models.InvoiceTemplate, userID int) error {
+	if errs := invoice.CheckTemplate(t); len(errs) > 0 {
+		return errs
+	}
+	t.UpdatedBy, t.UpdatedAt = nil, nil
+	return s.settingsRepo.Put(ctx, invoiceTemplateSetting, t, userID)
+}
+
+// Preview renders made-up invoice data with a template, so an admin can
+// see it before saving
+func (s *InvoiceService) Preview(t *models.InvoiceTemplate) ([]byte, error) {
+	if errs := invoice.CheckTemplate(t); len(errs) > 0 {
+		return nil, errs
+	}
+	data := invoice.Sample()
+	if s.company.GSTIN != "" {
+		data.Seller = s.seller()
+	}
+	return invoice.Render(data, t)
+}
+
+// Build returns the invoice data of a job, or validation.Errors listing
+// what the bill is missing
+func (s *InvoiceService) Build(ctx context.Context, jobID int) (*invoice.Data, error) {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs validation.Errors
+	if job.Stage1 == nil || job.Stage1.ConsigneeGSTIN == nil || *job.Stage1.ConsigneeGSTIN == "" {
+		errs.Add("stage1.consignee_gstin", "is required")
+	}
+	stage4 := job.Stage4
+	if stage4 == nil {
+		stage4 = &models.Stage4Data{}
+	}
+	if stage4.BillNo == nil || *stage4.BillNo == "" {
+		errs.Add("stage4.bill_no", "is required")
+	}
+	if stage4.BillDate == nil {
+		errs.Add("stage4.bill_date", "is required")
+	}
+	if len(stage4.Items) == 0 {
+		errs.Add("stage4.items", "is required")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	data := &invoice.Data{
+		Seller:      s.seller(),
+		InvoiceNo:   *stage4.BillNo,
+		InvoiceDate: *stage4.BillDate,
+		JobNo:       job.JobNo,
+		Items:       stage4.Items,
+		Taxable:     deref(stage4.AmountTaxable),
+		CGST:        deref(stage4.CGSTAmount),
+		SGST:        deref(stage4.SGSTAmount),
+		IGST:        deref(stage4.IGSTAmount),
+		RoundOff:    deref(stage4.RoundOff),
+		Total:       deref(stage4.TotalAmount),
+	}
+	if stage4.PlaceOfSupply != nil {
+		data.PlaceOfSupply = *stage4.PlaceOfSupply
+	}
+	if job.Stage1.HBLNo != nil {
+		data.HBLNo = *job.Stage1.HBLNo
+	}
+	if job.Stage1.MBLNo != nil {
+		data.MBLNo = *job.Stage1.MBLNo
+	}
+	if job.Stage2 != nil {
+		if job.Stage2.BillOfEntryNo != nil {
+			data.BENo = *job.Stage2.BillOfEntryNo
+		}
+		data.BEDate = job.Stage2.BillOfEntryDate
+	}
+	data.Containers = jobContainers(job)
+
+	// The buyer's address is the one on the job's e-invoice, falling back
+	// to the consignee's last e-invoice
+	e, err := s.einvoiceRepo.Get(ctx, jobID)
+	switch {
+	case err == nil:
+		var inv einvoice.Invoice
+		if err := json.Unmarshal(e.Payload, &inv); err != nil {
+			return nil, fmt.Errorf("reading stored e-invoice for job %d: %w", jobID, err)
+		}
+		data.Buyer = buyerFromInvoice(&inv)
+		if e.Status == models.EInvoiceRegistered {
+			data.IRN = deref(e.IRN)
+			data.AckNo = deref(e.AckNo)
+			data.AckDate = e.AckDate
+		}
+	case errors.Is(err, repository.ErrNotFound):
+		data.Buyer = s.einvoiceService.buyer(ctx, job, nil)
+	default:
+		return nil, err
+	}
+	return data, nil
+}
+
+// Generate renders a job's tax invoice, stores it with the job's stage 4
+// files and links it as the job's bill copy. The bill counts as issued from
+// then on.
+func (s *InvoiceService) Generate(ctx context.Context, jobID, userID int) (*models.JobFile, error) {
+	data, err := s.Build(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := s.Template(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pdf, err := invoice.Render(data, tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.uploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating upload directory %s: %w", s.uploadDir, err)
+	}
+	fileName := fmt.Sprintf("%d_stage4_invoice_%d.pdf", jobID, time.Now().Unix())
+	filePath := filepath.Join(s.uploadDir, fileName)
+	if err := os.WriteFile(filePath, pdf, 0644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", filePath, err)
+	}
+
+	name := "Invoice_" + strings.NewReplacer("/", "-", `"`, "").Replace(data.InvoiceNo) + ".pdf"
+	file, err := s.pipelineRepo.UploadFile(ctx, jobID, "stage4", userID, fileName, name, filePath,
+		int64(len(pdf)), "application/pdf", InvoiceDescription)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.pipelineRepo.SetBillCopy(ctx, jobID, "/api/pipeline/files/download?id="+strconv.Itoa(file.ID)); err != nil {
+		return nil, err
+	}
+	if err := s.pipelineRepo.MarkBillIssued(ctx, jobID); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Latest returns the tax invoice last generated for a job
+func (s *InvoiceService) Latest(ctx context.Context, jobID int) (*models.JobFile, error) {
+	files, err := s.pipelineRepo.GetFilesByJobAndStage(ctx, jobID, "stage4")
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if files[i].Description != nil && *files[i].Description == InvoiceDescription {
+			return &files[i], nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (s *InvoiceService) seller() models.BillingParty {
+	return models.BillingParty{
+		GSTIN:     s.company.GSTIN,
+		LegalName: s.company.Name,
+		Address1:  s.company.Address1,
+		Address2:  s.company.Address2,
+		Location:  s.company.Location,
+		Pincode:   s.company.Pincode,
+		Phone:     s.company.Phone,
+		Email:     s.company.Email,
+	}
+}
+
+// jobContainers lists the numbers of a job's containers
+func jobContainers(job *models.PipelineJobResponse) []string {
+	var containers []string
+	for _, c := range job.Containers {
+		if c.ContainerNo != nil && *c.ContainerNo != "" && !slices.Contains(containers, *c.ContainerNo) {
+			containers = append(containers, *c.ContainerNo)
+		}
+	}
+	return containers
+}
+
+func buyerFromInvoice(inv *einvoice.Invoice) models.BillingParty {
+	b := inv.BuyerDtls
+	party := models.BillingParty{
+		GSTIN:     b.Gstin,
+		LegalName: b.LglNm,
+		TradeName: b.TrdNm,
+		Address1:  b.Addr1,
+		Address2:  b.Addr2,
+		Location:  b.Loc,
+		Phone:     b.Ph,
+		Email:     b.Em,
+	}
+	if b.Pin > 0 {
+		party.Pincode = strconv.Itoa(b.Pin)
+	}
+	return party
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
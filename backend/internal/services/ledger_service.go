@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// Profitability report groupings
+const (
+	ByJob      = "job"
+	ByCustomer = "customer"
+	ByMonth    = "month"
+)
+
+// LedgerService books the costs and revenue of jobs and reports what they
+// earned. Customer deposits are debited as the ledger changes.
+type LedgerService struct {
+	ledgerRepo     *repository.LedgerRepository
+	pipelineRepo   *repository.PipelineRepository
+	depositService *DepositService
+}
+
+// NewLedgerService creates a new ledger service
+func NewLedgerService(ledgerRepo *repository.LedgerRepository, pipelineRepo *repository.PipelineRepository, depositService *DepositService) *LedgerService {
+	return &LedgerService{
+		ledgerRepo:     ledgerRepo,
+		pipelineRepo:   pipelineRepo,
+		depositService: depositService,
+	}
+}
+
+// Sync brings the job's ledger, and the deposit debits that follow it, in
+// line with the amounts in its stage data
+func (s *LedgerService) Sync(ctx context.Context, jobID int) error {
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if err := s.ledgerRepo.SyncJobEntries(ctx, jobID, DerivedLedgerEntries(job)); err != nil {
+		return fmt.Errorf("saving ledger of job %d: %w", jobID, err)
+	}
+	return s.depositService.Sync(ctx, jobID)
+}
+
+// Backfill syncs the ledger of every job and returns how many it synced
+func (s *LedgerService) Backfill(ctx context.Context) (int, error) {
+	ids, err := s.ledgerRepo.JobIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := s.Sync(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// ledgerColumn is a stage column kept in the ledger
+type ledgerColumn struct {
+	source       string
+	kind         string
+	category     string
+	description  string
+	reimbursable bool
+}
+
+// DerivedLedgerEntries are the ledger entries a job's stage data implies.
+// Duty, freight and destination charges take their payer from the stage 2
+// duty_paid_by and debit_paid_by. Our own clearance expenses are not
+// reimbursable; the other costs are recovered from the customer. The stage
+// 4 bill's taxable amount, net of approved credit and debit notes, is
+// revenue; its GST is not.
+func DerivedLedgerEntries(job *models.PipelineJobResponse) []models.LedgerEntry {
+	var entries []models.LedgerEntry
+	add := func(c ledgerColumn, amount float64, paidBy string, date *time.Time) {
+		if amount <= 0 {
+			return
+		}
+		source := c.source
+		entries = append(entries, models.LedgerEntry{
+			JobID:        job.ID,
+			Kind:         c.kind,
+			Category:     c.category,
+			Description:  c.description,
+			Amount:       gst.Round(amount),
+			PaidBy:       paidBy,
+			Reimbursable: c.reimbursable,
+			EntryDate:    date,
+			Source:       &source,
+		})
+	}
+
+	if s2 := job.Stage2; s2 != nil {
+		dutyPaidBy, debitPaidBy := ParsePaidBy(deref(s2.DutyPaidBy)), ParsePaidBy(deref(s2.DebitPaidBy))
+		add(ledgerColumn{"stage2.duty_amount", models.LedgerCost, "customs_duty", "Customs duty", true},
+			s2.DutyAmount, dutyPaidBy, s2.BillOfEntryDate)
+		add(ledgerColumn{"stage2.ocean_freight", models.LedgerCost, "ocean_freight", "Ocean freight", true},
+			s2.OceanFreight, debitPaidBy, s2.BillOfEntryDate)
+		add(ledgerColumn{"stage2.destination_charges", models.LedgerCost, "destination_charges", "Destination charges", true},
+			s2.DestinationCharges, debitPaidBy, s2.BillOfEntryDate)
+	}
+	if s3 := job.Stage3; s3 != nil {
+		add(ledgerColumn{"stage3.clearance_exps", models.LedgerCost, "clearance", "Clearance expenses", false},
+			deref(s3.ClearanceExps), "", s3.OutOfCharge)
+		add(ledgerColumn{"stage3.stamp_duty", models.LedgerCost, "stamp_duty", "Stamp duty", true},
+			deref(s3.StampDuty), "", s3.OutOfCharge)
+		add(ledgerColumn{"stage3.offloading_charges", models.LedgerCost, "offloading", "Offloading charges", true},
+			deref(s3.OffloadingCharges), "", s3.OutOfCharge)
+		add(ledgerColumn{"stage3.transport_detention", models.LedgerCost, "transport_detention", "Transport detention", true},
+			deref(s3.TransportDetention), "", s3.OutOfCharge)
+	}
+	if s4 := job.Stage4; s4 != nil {
+		revenue := deref(s4.AmountTaxable)
+		for _, n := range s4.Notes {
+			switch {
+			case n.Status != models.NoteApproved:
+			case n.Kind == models.DebitNote:
+				revenue += n.AmountTaxable
+			default:
+				revenue -= n.AmountTaxable
+			}
+		}
+		add(ledgerColumn{"stage4.amount_taxable", models.LedgerRevenue, "agency_fees", strings.TrimSpace("Bill " + deref(s4.BillNo)), false},
+			revenue, models.PaidByCustomer, s4.BillDate)
+	}
+	return entries
+}
+
+// ParsePaidBy reads the free text of the stage 2 paid-by columns as us,
+// customer or forwarder. It returns "" for an empty value.
+func ParsePaidBy(text string) string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return ""
+	}
+	for _, word := range []string{"customer", "client", "consignee", "importer", "party"} {
+		if strings.Contains(text, word) {
+			return models.PaidByCustomer
+		}
+	}
+	for _, word := range []string{"forwarder", "fwd", "shipping line", "carrier"} {
+		if strings.Contains(text, word) {
+			return models.PaidByForwarder
+		}
+	}
+	return models.PaidByUs
+}
+
+// Ledger returns a job's ledger entries and what the job earned
+func (s *LedgerService) Ledger(ctx context.Context, jobID int) (*models.JobLedger, error) {
+	if _, err := s.pipelineRepo.GetJobByID(ctx, jobID); err != nil {
+		return nil, err
+	}
+	entries, err := s.ledgerRepo.JobEntries(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	ledger := &models.JobLedger{JobID: jobID, Entries: entries}
+	for _, e := range entries {
+		switch {
+		case e.Kind == models.LedgerRevenue:
+			ledger.Revenue += e.Amount
+		case e.PaidBy != models.PaidByUs:
+			ledger.PassThrough += e.Amount
+		case e.Reimbursable:
+			ledger.Recoverable += e.Amount
+		default:
+			ledger.Costs += e.Amount
+		}
+	}
+	finishProfitability(&ledger.Profitability)
+	return ledger, nil
+}
+
+// AddEntry books an entry on a job by hand
+func (s *LedgerService) AddEntry(ctx context.Context, jobID int, req *models.LedgerEntryRequest, userID int) (*models.LedgerEntry, error) {
+	if _, err := s.pipelineRepo.GetJobByID(ctx, jobID); err != nil {
+		return nil, err
+	}
+	e := &models.LedgerEntry{JobID: jobID, CreatedBy: &userID}
+	applyLedgerRequest(e, req)
+	id, err := s.ledgerRepo.CreateEntry(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.depositService.Sync(ctx, jobID); err != nil {
+		return nil, err
+	}
+	return s.ledgerRepo.GetEntry(ctx, jobID, id)
+}
+
+// UpdateEntry replaces an entry entered by hand. Of an entry kept from a
+// stage column only the payer and whether it is reimbursable change; the
+// rest follows the stage data.
+func (s *LedgerService) UpdateEntry(ctx context.Context, jobID, id int, req *models.LedgerEntryRequest) (*models.LedgerEntry, error) {
+	e, err := s.ledgerRepo.GetEntry(ctx, jobID, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Source != nil {
+		e.PaidBy, e.Reimbursable = req.PaidBy, req.Reimbursable
+	} else {
+		applyLedgerRequest(e, req)
+	}
+	if err := s.ledgerRepo.UpdateEntry(ctx, e); err != nil {
+		return nil, err
+	}
+	if err := s.depositService.Sync(ctx, jobID); err != nil {
+		return nil, err
+	}
+	return s.ledgerRepo.GetEntry(ctx, jobID, id)
+}
+
+// DeleteEntry removes an entry entered by hand. Entries kept from stage
+// columns go when the column is cleared.
+func (s *LedgerService) DeleteEntry(ctx context.Context, jobID, id int) error {
+	e, err := s.ledgerRepo.GetEntry(ctx, jobID, id)
+	if err != nil {
+		return err
+	}
+	if e.Source != nil {
+		var errs validation.Errors
+		errs.Add("source", fmt.Sprintf("the entry follows %s; clear it in the stage data instead", *e.Source))
+		return errs
+	}
+	return s.ledgerRepo.DeleteEntry(ctx, jobID, id)
+}
+
+// Profitability reports what jobs billed between from and to earned,
+// grouped by job, customer or month
+func (s *LedgerService) Profitability(ctx context.Context, by string, from, to *time.Time) (*models.ProfitabilityReport, error) {
+	if by == "" {
+		by = ByJob
+	}
+	if by != ByJob && by != ByCustomer && by != ByMonth {
+		var errs validation.Errors
+		errs.Add("by", "must be one of job customer month")
+		return nil, errs
+	}
+	jobs, err := s.ledgerRepo.Profitability(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ProfitabilityReport{By: by, Rows: []models.ProfitabilityRow{}}
+	if from != nil {
+		f := from.Format(validation.DateLayout)
+		report.From = &f
+	}
+	if to != nil {
+		t := to.Format(validation.DateLayout)
+		report.To = &t
+	}
+
+	index := make(map[string]int)
+	for _, j := range jobs {
+		key, label := strconv.Itoa(j.JobID), j.JobNo
+		switch by {
+		case ByCustomer:
+			// Keyed as receivables and deposits match customers, so that
+			// "Acme Pvt Ltd" and "ACME PRIVATE LIMITED" are one row
+			key, label = repository.CustomerKey(j.Customer), j.Customer
+			if label == "" {
+				label = "(no consignee)"
+			}
+		case ByMonth:
+			key, label = j.Month, j.Month
+		}
+		i, seen := index[key]
+		if !seen {
+			i = len(report.Rows)
+			index[key] = i
+			report.Rows = append(report.Rows, models.ProfitabilityRow{Key: key, Label: label})
+		}
+		addProfitability(&report.Rows[i], j.Profitability)
+		addProfitability(&report.Total, j.Profitability)
+	}
+	for i := range report.Rows {
+		finishProfitability(&report.Rows[i].Profitability)
+	}
+	finishProfitability(&report.Total.Profitability)
+	report.Total.Key, report.Total.Label = "total", "Total"
+
+	if by == ByCustomer {
+		sort.SliceStable(report.Rows, func(i, j int) bool {
+			return report.Rows[i].Profit > report.Rows[j].Profit
+		})
+	}
+	return report, nil
+}
+
+func applyLedgerRequest(e *models.LedgerEntry, req *models.LedgerEntryRequest) {
+	e.Kind = req.Kind
+	e.Category = req.Category
+	e.Description = req.Description
+	e.Amount = gst.Round(req.Amount)
+	e.PaidBy = req.PaidBy
+	e.Reimbursable = req.Reimbursable
+	e.EntryDate = nil
+	if d, err := time.Parse(validation.DateLayout, req.EntryDate); err == nil {
+		e.EntryDate = &d
+	}
+}
+
+func addProfitability(row *models.ProfitabilityRow, p models.Profitability) {
+	row.Jobs++
+	row.Revenue += p.Revenue
+	row.Costs += p.Costs
+	row.Recoverable += p.Recoverable
+	row.PassThrough += p.PassThrough
+}
+
+// finishProfitability rounds the totals and works out the profit and the
+// margin, as a percentage of revenue
+func finishProfitability(p *models.Profitability) {
+	p.Revenue, p.Costs = gst.Round(p.Revenue), gst.Round(p.Costs)
+	p.Recoverable, p.PassThrough = gst.Round(p.Recoverable), gst.Round(p.PassThrough)
+	p.Profit = gst.Round(p.Revenue - p.Costs)
+	p.Margin = nil
+	if p.Revenue > 0 {
+		margin := gst.Round(p.Profit / p.Revenue * 100)
+		p.Margin = &margin
+	}
+}
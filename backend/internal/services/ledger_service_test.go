@@ -0,0 +1,150 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestDerivedLedgerEntries(t *testing.T) {
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	ptr := func(v float64) *float64 { return &v }
+	str := func(s string) *string { return &s }
+
+	// entry is the part of a derived entry compared
+	type entry struct {
+		source       string
+		kind         string
+		amount       float64
+		paidBy       string
+		reimbursable bool
+	}
+	tests := []struct {
+		name string
+		job  models.PipelineJobResponse
+		want []entry
+	}{
+		{name: "no stage data"},
+		{
+			name: "stage 2 payers",
+			job: models.PipelineJobResponse{Stage2: &models.Stage2Data{
+				DutyAmount: 12500.555, DutyPaidBy: str("Paid by client"),
+				OceanFreight: 800, DestinationCharges: 0, DebitPaidBy: str("FWD"),
+				BillOfEntryDate: &day,
+			}},
+			want: []entry{
+				{"stage2.duty_amount", models.LedgerCost, 12500.56, models.PaidByCustomer, true},
+				{"stage2.ocean_freight", models.LedgerCost, 800, models.PaidByForwarder, true},
+			},
+		},
+		{
+			name: "stage 3 expenses",
+			job: models.PipelineJobResponse{Stage3: &models.Stage3Data{
+				ClearanceExps: ptr(1500), StampDuty: ptr(100), OffloadingCharges: ptr(0), TransportDetention: nil,
+			}},
+			want: []entry{
+				{"stage3.clearance_exps", models.LedgerCost, 1500, "", false},
+				{"stage3.stamp_duty", models.LedgerCost, 100, "", true},
+			},
+		},
+		{
+			name: "revenue net of approved notes",
+			job: models.PipelineJobResponse{Stage4: &models.Stage4Data{
+				BillNo: str("B-101"), AmountTaxable: ptr(10000),
+				Notes: []models.BillNote{
+					{Kind: models.CreditNote, Status: models.NoteApproved, AmountTaxable: 1500},
+					{Kind: models.DebitNote, Status: models.NoteApproved, AmountTaxable: 250},
+					{Kind: models.CreditNote, Status: "draft", AmountTaxable: 9000},
+				},
+			}},
+			want: []entry{{"stage4.amount_taxable", models.LedgerRevenue, 8750, models.PaidByCustomer, false}},
+		},
+		{
+			name: "fully credited bill",
+			job: models.PipelineJobResponse{Stage4: &models.Stage4Data{
+				AmountTaxable: ptr(1000),
+				Notes:         []models.BillNote{{Kind: models.CreditNote, Status: models.NoteApproved, AmountTaxable: 1000}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.job.ID = 7
+			var got []entry
+			for _, e := range DerivedLedgerEntries(&tt.job) {
+				if e.JobID != 7 || e.Source == nil {
+					t.Fatalf("entry %+v is not sourced from job 7", e)
+				}
+				got = append(got, entry{*e.Source, e.Kind, e.Amount, e.PaidBy, e.Reimbursable})
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("DerivedLedgerEntries = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivedLedgerEntryDetails(t *testing.T) {
+	billDate := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	billNo, taxable := "B-101", 1000.0
+	entries := DerivedLedgerEntries(&models.PipelineJobResponse{
+		Stage4: &models.Stage4Data{BillNo: &billNo, BillDate: &billDate, AmountTaxable: &taxable},
+	})
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Category != "agency_fees" || e.Description != "Bill B-101" || e.EntryDate == nil || !e.EntryDate.Equal(billDate) {
+		t.Errorf("revenue entry = %s %q dated %v, want agency_fees \"Bill B-101\" dated %v", e.Category, e.Description, e.EntryDate, billDate)
+	}
+}
+
+func TestParsePaidBy(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"Customer", models.PaidByCustomer},
+		{"paid by CLIENT", models.PaidByCustomer},
+		{"Importer", models.PaidByCustomer},
+		{"Forwarder", models.PaidByForwarder},
+		{"shipping line", models.PaidByForwarder},
+		{"Self", models.PaidByUs},
+		{"Maydiv", models.PaidByUs},
+	}
+	for _, tt := range tests {
+		if got := ParsePaidBy(tt.text); got != tt.want {
+			t.Errorf("ParsePaidBy(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFinishProfitability(t *testing.T) {
+	tests := []struct {
+		name           string
+		p              models.Profitability
+		profit, margin float64
+		noMargin       bool
+	}{
+		{name: "profit", p: models.Profitability{Revenue: 10000, Costs: 7500.004}, profit: 2500, margin: 25},
+		{name: "loss", p: models.Profitability{Revenue: 3000, Costs: 4000}, profit: -1000, margin: -33.33},
+		{name: "no revenue", p: models.Profitability{Costs: 500}, profit: -500, noMargin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finishProfitability(&tt.p)
+			if tt.p.Profit != tt.profit {
+				t.Errorf("profit = %v, want %v", tt.p.Profit, tt.profit)
+			}
+			switch {
+			case tt.noMargin && tt.p.Margin != nil:
+				t.Errorf("margin = %v, want none", *tt.p.Margin)
+			case !tt.noMargin && (tt.p.Margin == nil || *tt.p.Margin != tt.margin):
+				t.Errorf("margin = %v, want %v", tt.p.Margin, tt.margin)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"maydiv-crm/internal/boe"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// MasterService manages the master data that ports, countries, shipping
+// lines, forwarders and custodians on jobs are linked to
+type MasterService struct {
+	masterRepo *repository.MasterRepository
+}
+
+// NewMasterService creates a new master data service
+func NewMasterService(masterRepo *repository.MasterRepository) *MasterService {
+	return &MasterService{masterRepo: masterRepo}
+}
+
+// List lists the masters of a kind, or with a query the best matches for
+// autocomplete
+func (s *MasterService) List(ctx context.Context, kind, query string, limit int) ([]models.Master, error) {
+	return s.masterRepo.List(ctx, kind, query, limit)
+}
+
+// Get retrieves a master of a kind
+func (s *MasterService) Get(ctx context.Context, kind string, id int) (*models.Master, error) {
+	return s.masterRepo.Get(ctx, kind, id)
+}
+
+// Save creates a master of a kind, or replaces the one with the given ID
+// when it is not 0. Its code, name and aliases must not match another
+// master of the kind.
+func (s *MasterService) Save(ctx context.Context, kind string, id int, req *models.MasterRequest) (*models.Master, error) {
+	m, err := MasterFromRequest(kind, req)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{repository.MasterKey(m.Name): "name"}
+	if m.Code != nil {
+		fields[repository.MasterKey(*m.Code)] = "code"
+	}
+	for i, alias := range m.Aliases {
+		fields[repository.MasterKey(alias)] = fmt.Sprintf("aliases[%d]", i)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	owners, err := s.masterRepo.KeyOwners(ctx, kind, keys)
+	if err != nil {
+		return nil, err
+	}
+	var errs validation.Errors
+	for _, key := range keys {
+		if owner, ok := owners[key]; ok && owner != id {
+			errs.Add(fields[key], "already names another "+kindLabel(kind))
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	if id == 0 {
+		id, err = s.masterRepo.Create(ctx, m)
+	} else {
+		m.ID = id
+		err = s.masterRepo.Update(ctx, m)
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		errs.Add("name", "already names another "+kindLabel(kind))
+		return nil, errs
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.masterRepo.Get(ctx, kind, id)
+}
+
+// Delete removes a master of a kind. The jobs linked to it keep their text.
+func (s *MasterService) Delete(ctx context.Context, kind string, id int) error {
+	return s.masterRepo.Delete(ctx, kind, id)
+}
+
+// Normalize links the free-text job fields not linked to master data yet
+// to the masters their values match, and reports every value it saw: the
+// master it was linked to or, for a value matching none, the closest
+// master to add it to as an alias
+func (s *MasterService) Normalize(ctx context.Context) (*models.MasterNormalization, error) {
+	report := &models.MasterNormalization{Rows: []models.MasterReviewRow{}}
+	masters := make(map[string][]models.Master)
+	for _, field := range repository.MasterFields {
+		if _, ok := masters[field.Kind]; !ok {
+			list, err := s.masterRepo.List(ctx, field.Kind, "", 0)
+			if err != nil {
+				return nil, err
+			}
+			masters[field.Kind] = list
+		}
+		values, err := s.masterRepo.UnlinkedValues(ctx, field)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range values {
+			id, err := s.masterRepo.Resolve(ctx, field.Kind, v.Value)
+			if err != nil {
+				return nil, err
+			}
+			if id == 0 {
+				v.Suggestion = suggestMaster(masters[field.Kind], v.Value)
+				report.UnmatchedJobs += v.Jobs
+				report.Rows = append(report.Rows, v)
+				continue
+			}
+			n, err := s.masterRepo.LinkValue(ctx, field, v.Value, id)
+			if err != nil {
+				return nil, err
+			}
+			v.MasterID = &id
+			for _, m := range masters[field.Kind] {
+				if m.ID == id {
+					v.Master = m.Name
+				}
+			}
+			report.LinkedValues++
+			report.LinkedJobs += n
+			report.Rows = append(report.Rows, v)
+		}
+	}
+	return report, nil
+}
+
+// MasterFromRequest validates a master request and builds the master of a
+// kind it describes
+func MasterFromRequest(kind string, req *models.MasterRequest) (*models.Master, error) {
+	m := &models.Master{
+		Kind:    kind,
+		Name:    strings.TrimSpace(req.Name),
+		Aliases: []string{},
+		Active:  req.Active == nil || *req.Active,
+	}
+	code := strings.ToUpper(strings.Join(strings.Fields(req.Code), ""))
+	country := strings.ToUpper(strings.TrimSpace(req.Country))
+
+	var errs validation.Errors
+	if repository.MasterKey(m.Name) == "" {
+		errs.Add("name", "must contain letters or digits")
+	}
+	switch kind {
+	case models.MasterPort:
+		if !boe.IsLocode(code) {
+			errs.Add("code", "must be a UN/LOCODE such as INNSA")
+		} else if country == "" {
+			country = code[:2]
+		}
+	case models.MasterCountry:
+		if len(code) != 2 || !isLetters(code) {
+			errs.Add("code", "must be an ISO 3166 country code such as IN")
+		}
+	}
+	if code != "" {
+		m.Code = &code
+	}
+	if country != "" {
+		if kind != models.MasterPort {
+			errs.Add("country", "is only kept for ports")
+		} else if !isLetters(country) {
+			errs.Add("country", "must be an ISO 3166 country code such as IN")
+		}
+		m.Country = &country
+	}
+
+	seen := map[string]bool{repository.MasterKey(m.Name): true, repository.MasterKey(code): true}
+	for i, alias := range req.Aliases {
+		alias = strings.TrimSpace(alias)
+		key := repository.MasterKey(alias)
+		switch {
+		case key == "":
+			errs.Add(fmt.Sprintf("aliases[%d]", i), "must contain letters or digits")
+		case len(alias) > 100:
+			errs.Add(fmt.Sprintf("aliases[%d]", i), "must be at most 100 characters")
+		case !seen[key]:
+			seen[key] = true
+			m.Aliases = append(m.Aliases, alias)
+		}
+	}
+	return m, errs.Err()
+}
+
+// suggestMaster names the master whose code, name or an alias is closest to
+// value, allowing a few typos, or returns "" when none is close
+func suggestMaster(masters []models.Master, value string) string {
+	key := repository.MasterKey(value)
+	best, bestDistance := "", len(key)/4+1
+	for _, m := range masters {
+		if !m.Active {
+			continue
+		}
+		candidates := append([]string{m.Name}, m.Aliases...)
+		if m.Code != nil {
+			candidates = append(candidates, *m.Code)
+		}
+		for _, c := range candidates {
+			ck := repository.MasterKey(c)
+			if len(ck) >= 4 && len(key) >= 4 && (strings.Contains(ck, key) || strings.Contains(key, ck)) {
+				return m.Name
+			}
+			if d := editDistance(key, ck); d < bestDistance {
+				best, bestDistance = m.Name, d
+			}
+		}
+	}
+	return best
+}
+
+// editDistance counts the characters to insert, delete or change to turn
+// a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func kindLabel(kind string) string {
+	return strings.ReplaceAll(kind, "_", " ")
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "INNSA", 5},
+		{"INNSA", "", 5},
+		{"INNSA", "INNSA", 0},
+		{"INNSA", "INNSB", 1},
+		{"NHAVASHEVA", "NHAVSHEVA", 1},
+		{"NHAVSHEVA", "NHAVASHEVA", 1},
+		{"MAERSK", "MEARSK", 2},
+		{"KITTEN", "SITTING", 3},
+		{"CMA CGM", "MSC", 5},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestMaster(t *testing.T) {
+	code := "INNSA1"
+	masters := []models.Master{
+		{Name: "Nhava Sheva", Code: &code, Aliases: []string{"JNPT", "Jawaharlal Nehru Port"}, Active: true},
+		{Name: "Mundra", Active: true},
+		{Name: "Kandla", Active: false},
+	}
+	tests := []struct {
+		value, want string
+	}{
+		{"Nava Sheva", "Nhava Sheva"},
+		{"JNPT Mumbai", "Nhava Sheva"},
+		{"innsa", "Nhava Sheva"},
+		{"Mundr", "Mundra"},
+		{"Mumbai", ""},
+		{"Kandla", ""},
+		{"XY", ""},
+	}
+	for _, tt := range tests {
+		if got := suggestMaster(masters, tt.value); got != tt.want {
+			t.Errorf("suggestMaster(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
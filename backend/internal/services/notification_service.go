@@ -1,46 +1,75 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 )
 
 type NotificationService struct {
 	EmailService *EmailService
 	db           *sql.DB
+	adminEmail   string
+	pending      sync.WaitGroup
 }
 
-func NewNotificationService(db *sql.DB) *NotificationService {
+func NewNotificationService(db *sql.DB, emailService *EmailService, adminEmail string) *NotificationService {
 	return &NotificationService{
-		EmailService: NewEmailService(),
+		EmailService: emailService,
 		db:           db,
+		adminEmail:   adminEmail,
+	}
+}
+
+// Go sends a notification in the background. Shutdown waits for it through
+// Wait, so a deploy does not drop emails that are still being sent. ctx
+// should outlive the request (see context.WithoutCancel) and is only used to
+// carry the request ID into logs.
+func (ns *NotificationService) Go(ctx context.Context, description string, send func() error) {
+	ns.pending.Add(1)
+	go func() {
+		defer ns.pending.Done()
+		if err := send(); err != nil {
+			slog.ErrorContext(ctx, "Failed to send notification", "notification", description, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until all background notifications have finished or ctx is done
+func (ns *NotificationService) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ns.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pending notifications not sent: %w", ctx.Err())
 	}
 }
 
 // NotifyStageCompletion sends email to admin when a stage is completed
-func (ns *NotificationService) NotifyStageCompletion(jobID int, stage string, completedByUserID int) error {
+func (ns *NotificationService) NotifyStageCompletion(ctx context.Context, jobID int, stage string, completedByUserID int) error {
 	// Get job details
-	job, err := ns.getJobDetails(jobID)
+	job, err := ns.getJobDetails(ctx, jobID)
 	if err != nil {
-		log.Printf("Failed to get job details for notification: %v", err)
-		return err
+		return fmt.Errorf("getting job details: %w", err)
 	}
 
 	// Get user details who completed the stage
-	completedByUser, err := ns.getUserDetails(completedByUserID)
+	completedByUser, err := ns.getUserDetails(ctx, completedByUserID)
 	if err != nil {
-		log.Printf("Failed to get user details for notification: %v", err)
-		return err
+		return fmt.Errorf("getting user details: %w", err)
 	}
 
 	// Get notification email (job-specific or admin email as fallback)
-	notificationEmail, err := ns.getNotificationEmail(jobID)
-	if err != nil {
-		log.Printf("Failed to get notification email: %v", err)
-		return err
-	}
+	notificationEmail := ns.getNotificationEmail(job)
 
 	// Determine next stage
 	nextStage := ns.getNextStage(stage)
@@ -59,56 +88,46 @@ func (ns *NotificationService) NotifyStageCompletion(jobID int, stage string, co
 	}
 
 	// Send email notification
-	if err := ns.EmailService.SendStageCompletionEmail(emailData); err != nil {
-		log.Printf("Failed to send stage completion email: %v", err)
+	if err := ns.EmailService.SendStageCompletionEmail(ctx, emailData); err != nil {
 		return err
 	}
 
-	// Log the notification
-	log.Printf("Stage completion notification sent for job %s, stage %s, completed by %s to %s", 
-		job.JobNo, stageName, completedByUser.Username, notificationEmail)
+	slog.InfoContext(ctx, "Stage completion notification sent",
+		"job_id", jobID, "job_no", job.JobNo, "stage", stage, "completed_by", completedByUserID)
 
 	return nil
 }
 
 // NotifyJobCreation sends email to admin when a new job is created
-func (ns *NotificationService) NotifyJobCreation(jobID int, createdByUserID int) error {
+func (ns *NotificationService) NotifyJobCreation(ctx context.Context, jobID int, createdByUserID int) error {
 	// Get job details
-	job, err := ns.getJobDetails(jobID)
+	job, err := ns.getJobDetails(ctx, jobID)
 	if err != nil {
-		log.Printf("Failed to get job details for creation notification: %v", err)
-		return err
+		return fmt.Errorf("getting job details: %w", err)
 	}
 
 	// Get user details who created the job
-	createdByUser, err := ns.getUserDetails(createdByUserID)
+	createdByUser, err := ns.getUserDetails(ctx, createdByUserID)
 	if err != nil {
-		log.Printf("Failed to get user details for creation notification: %v", err)
-		return err
+		return fmt.Errorf("getting user details: %w", err)
 	}
 
 	// Get notification email (job-specific or admin email as fallback)
-	notificationEmail, err := ns.getNotificationEmail(jobID)
-	if err != nil {
-		log.Printf("Failed to get notification email: %v", err)
-		return err
-	}
+	notificationEmail := ns.getNotificationEmail(job)
 
 	// Send email notification
-	if err := ns.EmailService.SendJobCreationEmail(job.JobNo, createdByUser.Username, notificationEmail); err != nil {
-		log.Printf("Failed to send job creation email: %v", err)
+	if err := ns.EmailService.SendJobCreationEmail(ctx, job.JobNo, createdByUser.Username, notificationEmail); err != nil {
 		return err
 	}
 
-	// Log the notification
-	log.Printf("Job creation notification sent for job %s, created by %s to %s", 
-		job.JobNo, createdByUser.Username, notificationEmail)
+	slog.InfoContext(ctx, "Job creation notification sent",
+		"job_id", jobID, "job_no", job.JobNo, "created_by", createdByUserID)
 
 	return nil
 }
 
 // Helper functions
-func (ns *NotificationService) getJobDetails(jobID int) (*JobDetails, error) {
+func (ns *NotificationService) getJobDetails(ctx context.Context, jobID int) (*JobDetails, error) {
 	query := `
 		SELECT pj.id, pj.job_no, pj.current_stage, pj.status, pj.created_at,
 		       s1.consignee, s1.shipper, s1.commodity, pj.notification_email
@@ -118,7 +137,7 @@ func (ns *NotificationService) getJobDetails(jobID int) (*JobDetails, error) {
 	`
 	
 	var job JobDetails
-	err := ns.db.QueryRow(query, jobID).Scan(
+	err := ns.db.QueryRowContext(ctx, query, jobID).Scan(
 		&job.ID, &job.JobNo, &job.CurrentStage, &job.Status, &job.CreatedAt,
 		&job.Consignee, &job.Shipper, &job.Commodity, &job.NotificationEmail,
 	)
@@ -130,11 +149,11 @@ func (ns *NotificationService) getJobDetails(jobID int) (*JobDetails, error) {
 	return &job, nil
 }
 
-func (ns *NotificationService) getUserDetails(userID int) (*UserDetails, error) {
+func (ns *NotificationService) getUserDetails(ctx context.Context, userID int) (*UserDetails, error) {
 	query := `SELECT id, username, designation, role FROM users WHERE id = ?`
 	
 	var user UserDetails
-	err := ns.db.QueryRow(query, userID).Scan(
+	err := ns.db.QueryRowContext(ctx, query, userID).Scan(
 		&user.ID, &user.Username, &user.Designation, &user.Role,
 	)
 	
@@ -145,27 +164,13 @@ func (ns *NotificationService) getUserDetails(userID int) (*UserDetails, error)
 	return &user, nil
 }
 
-func (ns *NotificationService) getNotificationEmail(jobID int) (string, error) {
-	// First, try to get the job-specific notification email from the job details
-	job, err := ns.getJobDetails(jobID)
-	if err != nil {
-		log.Printf("Failed to get job details for notification email: %v", err)
-		return "", err
-	}
-
-	if job.NotificationEmail.Valid {
-		return job.NotificationEmail.String, nil
+func (ns *NotificationService) getNotificationEmail(job *JobDetails) string {
+	if job.NotificationEmail.Valid && job.NotificationEmail.String != "" {
+		return job.NotificationEmail.String
 	}
 
-	// If no job-specific email, return a default admin email
-	// In a real system, you might want to store admin email in database or config
-	adminEmail := "admin@maydiv.com" // Default admin email
-	
-	// You can also query the database for admin users and get their email
-	// query := `SELECT email FROM users WHERE role = 'admin' LIMIT 1`
-	// err := ns.db.QueryRow(query).Scan(&adminEmail)
-	
-	return adminEmail, nil
+	// If no job-specific email, fall back to the configured admin email
+	return ns.adminEmail
 }
 
 func (ns *NotificationService) getNextStage(currentStage string) string {
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// invitationTTL is how long an invitation to an organization can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// errInvitationExpired is returned by the check accepting an invitation
+// past its expiry
+var errInvitationExpired = errors.New("invitation expired")
+
+// OrganizationService manages the customer users of an organization: their
+// rights over its jobs and the invitations org admins send colleagues
+type OrganizationService struct {
+	orgRepo             *repository.OrganizationRepository
+	userRepo            *repository.UserRepository
+	notificationService *NotificationService
+	appURL              string
+}
+
+// NewOrganizationService creates a new organization service. appURL is the
+// frontend address invitation links point to.
+func NewOrganizationService(orgRepo *repository.OrganizationRepository, userRepo *repository.UserRepository, notificationService *NotificationService, appURL string) *OrganizationService {
+	return &OrganizationService{
+		orgRepo:             orgRepo,
+		userRepo:            userRepo,
+		notificationService: notificationService,
+		appURL:              strings.TrimRight(appURL, "/"),
+	}
+}
+
+// Get returns an organization with its members and pending invitations
+func (s *OrganizationService) Get(ctx context.Context, partyID int) (*models.Organization, error) {
+	return s.orgRepo.Get(ctx, partyID)
+}
+
+// SetMember sets the rights of a member of an organization. With add, as
+// staff may, it also adds a customer user who is not a member yet, moving
+// them from any other organization. An organization keeps at least one org
+// admin.
+func (s *OrganizationService) SetMember(ctx context.Context, partyID, userID int, req *models.OrgMemberRequest, add bool) error {
+	var errs validation.Errors
+	stages := checkStages(req.Stages, &errs)
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	member := user.PartyID != nil && *user.PartyID == partyID
+	if !member && !add {
+		return repository.ErrNotFound
+	}
+	if user.Role != "customer" {
+		errs.Add("user_id", "must be a customer user")
+		return errs
+	}
+	if _, err := s.orgRepo.Get(ctx, partyID); err != nil {
+		return err
+	}
+	return lastAdmin(s.orgRepo.SetMember(ctx, partyID, userID, req.OrgAdmin, stages), "org_admin")
+}
+
+// RemoveMember removes a user from an organization, unless they are its
+// last org admin
+func (s *OrganizationService) RemoveMember(ctx context.Context, partyID, userID int) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PartyID == nil || *user.PartyID != partyID {
+		return repository.ErrNotFound
+	}
+	return lastAdmin(s.orgRepo.RemoveMember(ctx, partyID, userID), "user_id")
+}
+
+// lastAdmin turns repository.ErrLastAdmin into a validation error on field
+func lastAdmin(err error, field string) error {
+	if errors.Is(err, repository.ErrLastAdmin) {
+		var errs validation.Errors
+		errs.Add(field, "is the organization's last org admin")
+		return errs
+	}
+	return err
+}
+
+// Invite invites a colleague to join an organization. The invitation is
+// emailed in the background with the link accepting it, which is also
+// returned as the token is only kept hashed.
+func (s *OrganizationService) Invite(ctx context.Context, partyID int, invitedBy *models.User, req *models.OrgInvitationRequest) (*models.OrgInvitation, error) {
+	var errs validation.Errors
+	stages := checkStages(req.Stages, &errs)
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	org, err := s.orgRepo.Get(ctx, partyID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Truncate(time.Second)
+	inv := &models.OrgInvitation{
+		PartyID:   partyID,
+		Email:     strings.TrimSpace(req.Email),
+		OrgAdmin:  req.OrgAdmin,
+		Stages:    stages,
+		InvitedBy: &invitedBy.Username,
+		ExpiresAt: now.Add(invitationTTL),
+		CreatedAt: now,
+		AcceptURL: s.appURL + "/accept-invitation?token=" + url.QueryEscape(token),
+	}
+	if inv.ID, err = s.orgRepo.CreateInvitation(ctx, inv, hashToken(token), invitedBy.ID); err != nil {
+		return nil, err
+	}
+
+	bg := context.WithoutCancel(ctx)
+	s.notificationService.Go(bg, "organization invitation", func() error {
+		return s.notificationService.EmailService.SendInvitation(bg, inv.Email, org.Name, invitedBy.Username, inv.AcceptURL, inv.ExpiresAt)
+	})
+	return inv, nil
+}
+
+// RevokeInvitation deletes an organization's pending invitation
+func (s *OrganizationService) RevokeInvitation(ctx context.Context, partyID, id int) error {
+	return s.orgRepo.DeleteInvitation(ctx, partyID, id)
+}
+
+// AcceptInvitation creates the customer user who accepts an invitation, as
+// a member of the organization with the rights the invitation gives
+func (s *OrganizationService) AcceptInvitation(ctx context.Context, req *models.InvitationAcceptRequest) (*models.User, error) {
+	user := &models.UserCreate{
+		Username:     strings.TrimSpace(req.Username),
+		PasswordHash: req.Password, // stored as the users table stores every password
+		Designation:  strings.TrimSpace(req.Designation),
+		Role:         "customer",
+	}
+	_, userID, err := s.orgRepo.AcceptInvitation(ctx, hashToken(req.Token), user, func(inv *models.OrgInvitation) error {
+		if !time.Now().Before(inv.ExpiresAt) {
+			return errInvitationExpired
+		}
+		return nil
+	})
+	var errs validation.Errors
+	switch {
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, errInvitationExpired):
+		errs.Add("token", "is invalid or has expired")
+		return nil, errs
+	case errors.Is(err, repository.ErrDuplicate):
+		errs.Add("username", "is already taken")
+		return nil, errs
+	case err != nil:
+		return nil, err
+	}
+	return s.userRepo.GetByID(userID)
+}
+
+// checkStages validates the stages a member is restricted to and returns
+// them in job order without repeats
+func checkStages(stages []string, errs *validation.Errors) []string {
+	seen := make(map[string]bool)
+	for i, stage := range stages {
+		known := false
+		for _, s := range models.JobStages {
+			known = known || s == stage
+		}
+		if !known {
+			errs.Add(fmt.Sprintf("stages[%d]", i), "must be one of "+strings.Join(models.JobStages, " "))
+		}
+		seen[stage] = true
+	}
+	checked := []string{}
+	for _, s := range models.JobStages {
+		if seen[s] {
+			checked = append(checked, s)
+		}
+	}
+	return checked
+}
+
+// newInvitationToken returns a random token for an invitation link
+func newInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating invitation token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash an invitation token is stored as
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+func TestCheckStages(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []string
+		want   []string
+		fields []string
+	}{
+		{name: "every stage", stages: nil, want: []string{}},
+		{name: "job order", stages: []string{"stage4", "stage1"}, want: []string{"stage1", "stage4"}},
+		{name: "repeats", stages: []string{"stage3", "stage3"}, want: []string{"stage3"}},
+		{
+			name: "unknown stages", stages: []string{"stage2", "billing", "stage5"},
+			want: []string{"stage2"}, fields: []string{"stages[1]", "stages[2]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs validation.Errors
+			got := checkStages(tt.stages, &errs)
+			if !slices.Equal(got, tt.want) || got == nil {
+				t.Errorf("checkStages = %#v, want %#v", got, tt.want)
+			}
+			var fields []string
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("checkStages rejected %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestInvitationToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := newInvitationToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 64 {
+			t.Fatalf("token %q has %d characters, want 64", token, len(token))
+		}
+		if seen[token] {
+			t.Fatalf("token %q generated twice", token)
+		}
+		seen[token] = true
+
+		hash := hashToken(token)
+		if hash == token || len(hash) != 64 || hashToken(token) != hash {
+			t.Fatalf("hashToken(%q) = %q, want a stable 64 character hash unlike the token", token, hash)
+		}
+	}
+	if hashToken("a") == hashToken("b") {
+		t.Error("different tokens hash alike")
+	}
+}
+
+// acceptDB is a database/sql driver standing in for MySQL while an
+// invitation is accepted: it finds one pending invitation expiring at the
+// time it was opened with, reads back any user as a customer, and records
+// the statements executed
+type acceptDB struct {
+	expiresAt time.Time
+	mu        sync.Mutex
+	execs     []string
+}
+
+var (
+	acceptDriverOnce sync.Once
+	acceptDBs        sync.Map
+)
+
+type acceptDriver struct{}
+
+func (acceptDriver) Open(name string) (driver.Conn, error) {
+	db, _ := acceptDBs.Load(name)
+	return acceptConn{db.(*acceptDB)}, nil
+}
+
+type acceptConn struct{ db *acceptDB }
+
+func (c acceptConn) Prepare(query string) (driver.Stmt, error) { return acceptStmt{c.db, query}, nil }
+func (c acceptConn) Close() error                              { return nil }
+func (c acceptConn) Begin() (driver.Tx, error)                 { return acceptTx{}, nil }
+
+type acceptTx struct{}
+
+func (acceptTx) Commit() error   { return nil }
+func (acceptTx) Rollback() error { return nil }
+
+type acceptStmt struct {
+	db    *acceptDB
+	query string
+}
+
+func (s acceptStmt) Close() error  { return nil }
+func (s acceptStmt) NumInput() int { return -1 }
+func (s acceptStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	s.db.execs = append(s.db.execs, strings.Join(strings.Fields(s.query), " "))
+	s.db.mu.Unlock()
+	return acceptResult{}, nil
+}
+func (s acceptStmt) Query([]driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "FROM org_invitations") {
+		created := s.db.expiresAt.Add(-invitationTTL)
+		return &acceptRows{
+			columns: []string{"id", "party_id", "email", "org_admin", "stages", "username", "expires_at", "created_at"},
+			values:  []driver.Value{int64(5), int64(20), "ravi@acme.in", false, nil, "acme_admin", s.db.expiresAt, created},
+		}, nil
+	}
+	return &acceptRows{
+		columns: []string{"id", "username", "password_hash", "designation", "is_admin", "role", "party_id", "org_admin", "stages"},
+		values:  []driver.Value{int64(1), "ravi", "", "Accounts", false, "customer", int64(20), false, nil},
+	}, nil
+}
+
+// acceptResult gives every insert ID 1
+type acceptResult struct{}
+
+func (acceptResult) LastInsertId() (int64, error) { return 1, nil }
+func (acceptResult) RowsAffected() (int64, error) { return 1, nil }
+
+type acceptRows struct {
+	columns []string
+	values  []driver.Value
+	done    bool
+}
+
+func (r *acceptRows) Columns() []string { return r.columns }
+func (r *acceptRows) Close() error      { return nil }
+func (r *acceptRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+func TestAcceptInvitationExpiry(t *testing.T) {
+	acceptDriverOnce.Do(func() { sql.Register("services-test-accept", acceptDriver{}) })
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		expired   bool
+	}{
+		{name: "within a week", expiresIn: invitationTTL - time.Hour},
+		{name: "about to expire", expiresIn: time.Minute},
+		{name: "expired", expiresIn: -time.Second, expired: true},
+		{name: "expired long ago", expiresIn: -invitationTTL, expired: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &acceptDB{expiresAt: time.Now().Add(tt.expiresIn)}
+			acceptDBs.Store(t.Name(), fake)
+			db, err := sql.Open("services-test-accept", t.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			s := NewOrganizationService(repository.NewOrganizationRepository(db), repository.NewUserRepository(db), nil, "")
+
+			user, err := s.AcceptInvitation(context.Background(), &models.InvitationAcceptRequest{
+				Token: "token", Username: "ravi", Password: "secret", Designation: "Accounts",
+			})
+			if !tt.expired {
+				if err != nil || user == nil || user.Username != "ravi" {
+					t.Fatalf("AcceptInvitation = %v, %v, want the new user", user, err)
+				}
+				if len(fake.execs) != 3 {
+					t.Errorf("accepting ran %d statements, want the user, membership and invitation saved: %q", len(fake.execs), fake.execs)
+				}
+				return
+			}
+			var errs validation.Errors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "token" {
+				t.Fatalf("AcceptInvitation error = %v, want token is invalid or has expired", err)
+			}
+			if len(fake.execs) != 0 {
+				t.Errorf("an expired invitation saved %q", fake.execs)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/tradeid"
+	"maydiv-crm/internal/validation"
+)
+
+// PartyService manages the party directory of consignees and shippers and
+// links jobs to it
+type PartyService struct {
+	partyRepo         *repository.PartyRepository
+	receivableService *ReceivableService
+}
+
+// NewPartyService creates a new party service
+func NewPartyService(partyRepo *repository.PartyRepository, receivableService *ReceivableService) *PartyService {
+	return &PartyService{
+		partyRepo:         partyRepo,
+		receivableService: receivableService,
+	}
+}
+
+// List lists the parties, or with a query the best matches for autocomplete
+func (s *PartyService) List(ctx context.Context, query string, limit int) ([]models.Party, error) {
+	return s.partyRepo.List(ctx, query, limit)
+}
+
+// Detail returns a party with its jobs, the bills it has yet to settle as
+// of asOf and the documents on its jobs
+func (s *PartyService) Detail(ctx context.Context, id int, asOf time.Time) (*models.PartyDetail, error) {
+	party, err := s.partyRepo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	detail := &models.PartyDetail{Party: *party}
+	if detail.Jobs, err = s.partyRepo.Jobs(ctx, id); err != nil {
+		return nil, err
+	}
+	if detail.Bills, err = s.receivableService.PartyBills(ctx, id, asOf); err != nil {
+		return nil, err
+	}
+	for _, b := range detail.Bills {
+		detail.Outstanding += b.Outstanding
+	}
+	detail.Outstanding = gst.Round(detail.Outstanding)
+	if detail.Documents, err = s.partyRepo.Documents(ctx, id); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// Save creates a party, or replaces the one with the given ID when it is
+// not 0. Its name and aliases must not match another party.
+func (s *PartyService) Save(ctx context.Context, id int, req *models.PartyRequest) (*models.Party, error) {
+	p, err := PartyFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{repository.PartyKey(p.Name): "name"}
+	for i, alias := range p.Aliases {
+		fields[repository.PartyKey(alias)] = fmt.Sprintf("aliases[%d]", i)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	owners, err := s.partyRepo.KeyOwners(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	var errs validation.Errors
+	for _, key := range keys {
+		if owner, ok := owners[key]; ok && owner != id {
+			errs.Add(fields[key], "already names another party")
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	if id == 0 {
+		id, err = s.partyRepo.Create(ctx, p)
+	} else {
+		p.ID = id
+		err = s.partyRepo.Update(ctx, p)
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		errs.Add("name", "already names another party")
+		return nil, errs
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.partyRepo.Get(ctx, id)
+}
+
+// Delete removes a party. The jobs linked to it keep their names.
+func (s *PartyService) Delete(ctx context.Context, id int) error {
+	return s.partyRepo.Delete(ctx, id)
+}
+
+// FillJobParties links a new job's consignee and shipper to the parties
+// given by ID, or else named, filling in the name, GSTIN and IEC the
+// request leaves empty from the party
+func (s *PartyService) FillJobParties(ctx context.Context, req *models.Stage1CreateRequest) error {
+	parties := []struct {
+		field      string
+		id         *int
+		name       *string
+		gstin, iec *string
+	}{
+		{"consignee", &req.ConsigneePartyID, &req.Consignee, &req.ConsigneeGSTIN, &req.ConsigneeIEC},
+		{"shipper", &req.ShipperPartyID, &req.Shipper, &req.ShipperGSTIN, &req.ShipperIEC},
+	}
+
+	var errs validation.Errors
+	for _, p := range parties {
+		if *p.id == 0 {
+			id, err := s.partyRepo.Resolve(ctx, *p.name)
+			if err != nil {
+				return err
+			}
+			if id == 0 {
+				continue
+			}
+			*p.id = id
+		}
+		party, err := s.partyRepo.Get(ctx, *p.id)
+		if errors.Is(err, repository.ErrNotFound) {
+			errs.Add(p.field+"_party_id", "is not a party")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(*p.name) == "" {
+			*p.name = party.Name
+		}
+		if *p.gstin == "" && party.GSTIN != nil {
+			*p.gstin = *party.GSTIN
+		}
+		if *p.iec == "" && party.IEC != nil {
+			*p.iec = *party.IEC
+		}
+	}
+	return errs.Err()
+}
+
+// Migrate links the consignees and shippers of jobs not linked to a party
+// yet. A name is linked to the party it matches, else to the party with
+// the GSTIN or IEC recorded with it, else to a party whose name differs
+// from it by a typo or two, the name being added to the party's aliases.
+// A name matching no party becomes a new party. Names are taken most used
+// first, so a party is named as most of its jobs name it. Every name is
+// reported with how it was matched.
+func (s *PartyService) Migrate(ctx context.Context) (*models.PartyMigration, error) {
+	report := &models.PartyMigration{Rows: []models.PartyReviewRow{}}
+	parties, err := s.partyRepo.List(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*models.Party)
+	byGSTIN := make(map[string]*models.Party)
+	byIEC := make(map[string]*models.Party)
+	index := func(p *models.Party) {
+		byKey[repository.PartyKey(p.Name)] = p
+		for _, alias := range p.Aliases {
+			byKey[repository.PartyKey(alias)] = p
+		}
+		if p.GSTIN != nil {
+			byGSTIN[*p.GSTIN] = p
+		}
+		if p.IEC != nil {
+			byIEC[*p.IEC] = p
+		}
+	}
+	for i := range parties {
+		index(&parties[i])
+	}
+
+	var names []models.PartyReviewRow
+	for _, field := range repository.PartyFields {
+		found, err := s.partyRepo.UnlinkedNames(ctx, field)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, found...)
+	}
+	sort.SliceStable(names, func(i, j int) bool { return names[i].Jobs > names[j].Jobs })
+
+	for _, row := range names {
+		key := repository.PartyKey(row.Value)
+		if key == "" {
+			report.Rows = append(report.Rows, row)
+			continue
+		}
+
+		party, match := byKey[key], models.PartyMatchExact
+		if party == nil && row.GSTIN != "" {
+			party, match = byGSTIN[row.GSTIN], models.PartyMatchGSTIN
+		}
+		if party == nil && row.IEC != "" {
+			party, match = byIEC[row.IEC], models.PartyMatchIEC
+		}
+		if party == nil {
+			party, match = similarParty(byKey, key), models.PartyMatchSimilar
+		}
+
+		switch {
+		case party == nil:
+			party = &models.Party{Name: row.Value, Aliases: []string{}, Contacts: []models.PartyContact{}}
+			if row.GSTIN != "" {
+				party.GSTIN = &row.GSTIN
+			}
+			if row.IEC != "" {
+				party.IEC = &row.IEC
+			}
+			if party.ID, err = s.partyRepo.Create(ctx, party); err != nil {
+				return nil, err
+			}
+			match = models.PartyMatchCreated
+			report.Created++
+		case match != models.PartyMatchExact:
+			if err := s.partyRepo.AddAlias(ctx, party.ID, row.Value); err != nil {
+				return nil, err
+			}
+			party.Aliases = append(party.Aliases, row.Value)
+		}
+		index(party)
+
+		n, err := s.partyRepo.LinkName(ctx, row.Field, row.Value, party.ID)
+		if err != nil {
+			return nil, err
+		}
+		row.PartyID, row.Party, row.Match = party.ID, party.Name, match
+		report.LinkedJobs += n
+		report.Rows = append(report.Rows, row)
+	}
+	return report, nil
+}
+
+// PartyFromRequest validates a party request and builds the party it
+// describes
+func PartyFromRequest(req *models.PartyRequest) (*models.Party, error) {
+	p := &models.Party{
+		Name:           strings.TrimSpace(req.Name),
+		Address:        optionalString(req.Address),
+		GSTIN:          optionalString(tradeid.Normalize(req.GSTIN)),
+		IEC:            optionalString(tradeid.Normalize(req.IEC)),
+		BillingEmail:   optionalString(req.BillingEmail),
+		BillingAddress: optionalString(req.BillingAddress),
+		CreditDays:     req.CreditDays,
+		Notes:          optionalString(req.Notes),
+		Aliases:        []string{},
+		Contacts:       []models.PartyContact{},
+	}
+
+	var errs validation.Errors
+	key := repository.PartyKey(p.Name)
+	if key == "" {
+		errs.Add("name", "must have a word besides legal forms such as Pvt Ltd")
+	}
+	seen := map[string]bool{key: true}
+	for i, alias := range req.Aliases {
+		alias = strings.TrimSpace(alias)
+		aliasKey := repository.PartyKey(alias)
+		switch {
+		case aliasKey == "":
+			errs.Add(fmt.Sprintf("aliases[%d]", i), "must have a word besides legal forms such as Pvt Ltd")
+		case len(alias) > 255:
+			errs.Add(fmt.Sprintf("aliases[%d]", i), "must be at most 255 characters")
+		case !seen[aliasKey]:
+			seen[aliasKey] = true
+			p.Aliases = append(p.Aliases, alias)
+		}
+	}
+	for _, c := range req.Contacts {
+		p.Contacts = append(p.Contacts, models.PartyContact{
+			Name:  strings.TrimSpace(c.Name),
+			Email: optionalString(c.Email),
+			Phone: optionalString(c.Phone),
+			Role:  optionalString(c.Role),
+		})
+	}
+	return p, errs.Err()
+}
+
+// similarParty returns the party whose name or an alias differs from key by
+// at most one typo in eight characters, the closest one, or nil. Short
+// names must match exactly.
+func similarParty(byKey map[string]*models.Party, key string) *models.Party {
+	var best *models.Party
+	bestDistance := len(key)/8 + 1
+	for k, p := range byKey {
+		d := editDistance(key, k)
+		if d < bestDistance || (d == bestDistance && best != nil && p.ID < best.ID) {
+			best, bestDistance = p, d
+		}
+	}
+	return best
+}
+
+// optionalString trims s and returns nil when nothing is left
+func optionalString(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
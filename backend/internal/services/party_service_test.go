@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"maydiv-crm/internal/models"
+)
+
+func TestSimilarParty(t *testing.T) {
+	byKey := map[string]*models.Party{
+		"abc import":           {ID: 1, Name: "ABC Import Co."},
+		"abc imports":          {ID: 2, Name: "ABC Imports"},
+		"acme":                 {ID: 3, Name: "Acme Pvt Ltd"},
+		"xyz traders":          {ID: 4, Name: "XYZ Traders"},
+		"xyz tradres":          {ID: 5, Name: "XYZ Traders (alias)"},
+		"ningbo bearing works": {ID: 6, Name: "Ningbo Bearing Works"},
+	}
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "the same name", key: "abc import", want: 1},
+		{name: "one typo in a long name", key: "abc imoprt", want: 0},
+		{name: "one letter added", key: "abc importss", want: 2},
+		{name: "ties go to the lower ID, a letter added", key: "abc importe", want: 1},
+		{name: "ties go to the lower ID", key: "xyz tradrs", want: 4},
+		{name: "short names match exactly", key: "acme", want: 3},
+		{name: "a typo in a short name", key: "acne"},
+		{name: "two typos in sixteen characters", key: "ningbo bearing wroks", want: 6},
+		{name: "three typos", key: "ningbo baering wroks"},
+		{name: "another party", key: "global freight"},
+	}
+	for _, tt := range tests {
+		got := similarParty(byKey, tt.key)
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("%s: similarParty(%q) = %d, want none", tt.name, tt.key, got.ID)
+		case tt.want != 0 && (got == nil || got.ID != tt.want):
+			t.Errorf("%s: similarParty(%q) = %v, want party %d", tt.name, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/gst"
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/repository"
+	"maydiv-crm/internal/validation"
+)
+
+// paymentTolerance is how far a payment may go over the outstanding
+// balance, or an advance's balance: half a paisa of rounding
+const paymentTolerance = 0.005
+
+// ReceivableService records what customers pay against their bills and
+// reports what they still owe
+type ReceivableService struct {
+	receivableRepo *repository.ReceivableRepository
+	pipelineRepo   *repository.PipelineRepository
+	billing        config.BillingConfig
+}
+
+// NewReceivableService creates a new receivable service
+func NewReceivableService(receivableRepo *repository.ReceivableRepository, pipelineRepo *repository.PipelineRepository, billing config.BillingConfig) *ReceivableService {
+	return &ReceivableService{
+		receivableRepo: receivableRepo,
+		pipelineRepo:   pipelineRepo,
+		billing:        billing,
+	}
+}
+
+// Bill returns a job's bill with its payments and balance as of a date
+func (s *ReceivableService) Bill(ctx context.Context, jobID int, asOf time.Time) (*models.Receivable, error) {
+	bills, err := s.receivableRepo.Bills(ctx, repository.BillFilter{JobID: jobID})
+	if err != nil {
+		return nil, err
+	}
+	if len(bills) == 0 {
+		return nil, repository.ErrNotFound
+	}
+	bill := &bills[0]
+	s.age(bill, asOf)
+	bill.Payments, err = s.receivableRepo.Payments(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+// AddPayment records a payment against a job's bill and returns the bill.
+// Together with any TDS it may not come to more than is outstanding, and a
+// payment from an advance may not draw more than the advance has left.
+func (s *ReceivableService) AddPayment(ctx context.Context, jobID int, req *models.PaymentRequest, userID int) (*models.Receivable, error) {
+	var errs validation.Errors
+	if req.Amount+req.TDSAmount <= 0 {
+		errs.Add("amount", "the amount and TDS cannot both be zero")
+	}
+	if req.Mode == models.PaymentAdvance && req.AdvanceID == 0 {
+		errs.Add("advance_id", "is required to adjust a payment from an advance")
+	}
+	if req.Mode != models.PaymentAdvance && req.AdvanceID != 0 {
+		errs.Add("advance_id", "is only used with mode advance")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	job, err := s.pipelineRepo.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	consignee := ""
+	if job.Stage1 != nil && job.Stage1.Consignee != nil {
+		consignee = *job.Stage1.Consignee
+	}
+
+	date, _ := time.Parse(validation.DateLayout, req.PaymentDate)
+	p := &models.Payment{
+		JobID:       jobID,
+		PaymentDate: date,
+		Amount:      gst.Round(req.Amount),
+		TDSAmount:   gst.Round(req.TDSAmount),
+		Mode:        req.Mode,
+		CreatedBy:   &userID,
+	}
+	if req.AdvanceID != 0 {
+		p.AdvanceID = &req.AdvanceID
+	}
+	if req.ReferenceNo != "" {
+		p.ReferenceNo = &req.ReferenceNo
+	}
+	if req.Remarks != "" {
+		p.Remarks = &req.Remarks
+	}
+
+	err = s.receivableRepo.AddPayment(ctx, p, func(outstanding float64, advance *models.Advance) error {
+		var errs validation.Errors
+		if p.Amount+p.TDSAmount > outstanding+paymentTolerance {
+			errs.Add("amount", fmt.Sprintf("with TDS comes to %.2f but only %.2f is outstanding", p.Amount+p.TDSAmount, outstanding))
+		}
+		if advance != nil {
+			if !sameCustomer(advance.Customer, consignee) {
+				errs.Add("advance_id", fmt.Sprintf("is an advance from %s, not from the consignee %s", advance.Customer, consignee))
+			} else if p.Amount > advance.Balance+paymentTolerance {
+				errs.Add("advance_id", fmt.Sprintf("has only %.2f left", advance.Balance))
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) && p.AdvanceID != nil {
+		if _, lookup := s.receivableRepo.GetAdvance(ctx, *p.AdvanceID); errors.Is(lookup, repository.ErrNotFound) {
+			errs.Add("advance_id", "does not exist")
+			return nil, errs
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Bill(ctx, jobID, time.Now())
+}
+
+// DeletePayment removes a payment recorded in error
+func (s *ReceivableService) DeletePayment(ctx context.Context, jobID, paymentID, userID int) error {
+	return s.receivableRepo.DeletePayment(ctx, jobID, paymentID, userID)
+}
+
+// Outstanding lists the open bills, all or one customer's, and the
+// balance of each customer who owes money or has an advance left
+func (s *ReceivableService) Outstanding(ctx context.Context, customer string, asOf time.Time) ([]models.Receivable, []models.CustomerBalance, error) {
+	bills, err := s.receivableRepo.Bills(ctx, repository.BillFilter{Customer: customer})
+	if err != nil {
+		return nil, nil, err
+	}
+	advances, err := s.receivableRepo.Advances(ctx, customer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	open := []models.Receivable{}
+	balances := []models.CustomerBalance{}
+	index := make(map[string]int)
+	balance := func(name string) *models.CustomerBalance {
+		key := customerKey(name)
+		i, seen := index[key]
+		if !seen {
+			i = len(balances)
+			index[key] = i
+			balances = append(balances, models.CustomerBalance{Customer: name})
+		}
+		return &balances[i]
+	}
+
+	for i := range bills {
+		b := &bills[i]
+		s.age(b, asOf)
+		cb := balance(b.Customer)
+		cb.Bills++
+		cb.BillAmount += b.BillAmount
+		cb.Settled += b.BillAmount - b.Outstanding
+		cb.Outstanding += b.Outstanding
+		if b.DaysOverdue > 0 {
+			cb.Overdue += b.Outstanding
+		}
+		if b.Status != models.BillSettled {
+			open = append(open, *b)
+		}
+	}
+	for _, a := range advances {
+		balance(a.Customer).AdvanceBalance += a.Balance
+	}
+
+	owing := balances[:0]
+	for _, cb := range balances {
+		cb.BillAmount, cb.Settled, cb.Outstanding = gst.Round(cb.BillAmount), gst.Round(cb.Settled), gst.Round(cb.Outstanding)
+		cb.Overdue, cb.AdvanceBalance = gst.Round(cb.Overdue), gst.Round(cb.AdvanceBalance)
+		if cb.Outstanding > 0 || cb.AdvanceBalance > 0 {
+			owing = append(owing, cb)
+		}
+	}
+	sort.SliceStable(owing, func(i, j int) bool { return owing[i].Outstanding > owing[j].Outstanding })
+	return open, owing, nil
+}
+
+// PartyBills lists the bills a party has yet to settle as the consignee,
+// oldest first, as of a date
+func (s *ReceivableService) PartyBills(ctx context.Context, partyID int, asOf time.Time) ([]models.Receivable, error) {
+	bills, err := s.receivableRepo.Bills(ctx, repository.BillFilter{PartyID: partyID, OpenOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	for i := range bills {
+		s.age(&bills[i], asOf)
+	}
+	return bills, nil
+}
+
+// Aging splits each customer's outstanding balance into buckets by the age
+// of their bills on asOf: 0-30, 31-60, 61-90 and over 90 days
+func (s *ReceivableService) Aging(ctx context.Context, asOf time.Time) (*models.AgingReport, error) {
+	bills, err := s.receivableRepo.Bills(ctx, repository.BillFilter{OpenOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.AgingReport{AsOf: asOf.Format(validation.DateLayout), Rows: []models.AgingRow{}}
+	index := make(map[string]int)
+	for i := range bills {
+		b := &bills[i]
+		s.age(b, asOf)
+		key := customerKey(b.Customer)
+		j, seen := index[key]
+		if !seen {
+			j = len(report.Rows)
+			index[key] = j
+			report.Rows = append(report.Rows, models.AgingRow{Customer: b.Customer})
+		}
+		addAging(&report.Rows[j], b.AgeDays, b.Outstanding)
+		addAging(&report.Total, b.AgeDays, b.Outstanding)
+	}
+	for i := range report.Rows {
+		roundAging(&report.Rows[i])
+	}
+	roundAging(&report.Total)
+	report.Total.Customer = "Total"
+	sort.SliceStable(report.Rows, func(i, j int) bool { return report.Rows[i].Total > report.Rows[j].Total })
+	return report, nil
+}
+
+// Reminders lists the bills overdue on asOf that customers should be
+// reminded of, longest overdue first
+func (s *ReceivableService) Reminders(ctx context.Context, asOf time.Time) ([]models.Receivable, error) {
+	bills, err := s.receivableRepo.Bills(ctx, repository.BillFilter{OpenOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	overdue := []models.Receivable{}
+	for i := range bills {
+		s.age(&bills[i], asOf)
+		if bills[i].DaysOverdue > 0 {
+			overdue = append(overdue, bills[i])
+		}
+	}
+	sort.SliceStable(overdue, func(i, j int) bool { return overdue[i].DaysOverdue > overdue[j].DaysOverdue })
+	return overdue, nil
+}
+
+// Advances lists customer advances, all or one customer's
+func (s *ReceivableService) Advances(ctx context.Context, customer string) ([]models.Advance, error) {
+	return s.receivableRepo.Advances(ctx, customer)
+}
+
+// AddAdvance records an advance received from a customer
+func (s *ReceivableService) AddAdvance(ctx context.Context, req *models.AdvanceRequest, userID int) (*models.Advance, error) {
+	date, _ := time.Parse(validation.DateLayout, req.ReceivedDate)
+	a := &models.Advance{
+		Customer:     strings.TrimSpace(req.Customer),
+		Amount:       gst.Round(req.Amount),
+		ReceivedDate: date,
+		CreatedBy:    &userID,
+	}
+	if req.ReferenceNo != "" {
+		a.ReferenceNo = &req.ReferenceNo
+	}
+	if req.Remarks != "" {
+		a.Remarks = &req.Remarks
+	}
+	id, err := s.receivableRepo.CreateAdvance(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	return s.receivableRepo.GetAdvance(ctx, id)
+}
+
+// age works out a bill's balance, status, due date and age on asOf. A
+// bill falls due after its party's credit days, else billing.credit_days.
+func (s *ReceivableService) age(b *models.Receivable, asOf time.Time) {
+	b.Outstanding = gst.Round(b.BillAmount - b.Received - b.AdvanceAdjusted - b.TDS)
+	switch {
+	case b.Outstanding <= 0:
+		b.Outstanding = 0
+		b.Status = models.BillSettled
+	case b.Outstanding < b.BillAmount:
+		b.Status = models.BillPartlyPaid
+	default:
+		b.Status = models.BillUnpaid
+	}
+
+	creditDays := s.billing.CreditDays
+	if b.CreditDays != nil {
+		creditDays = *b.CreditDays
+	}
+	b.DueDate = b.BillDate.AddDate(0, 0, creditDays)
+	b.AgeDays = max(daysBetween(b.BillDate, asOf), 0)
+	b.DaysOverdue = 0
+	if b.Status != models.BillSettled {
+		b.DaysOverdue = max(daysBetween(b.DueDate, asOf), 0)
+	}
+	if b.Email != nil && !strings.Contains(*b.Email, "@") {
+		b.Email = nil
+	}
+}
+
+// daysBetween counts the calendar days from one date to another
+func daysBetween(from, to time.Time) int {
+	f := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	t := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Round(t.Sub(f).Hours() / 24))
+}
+
+func addAging(row *models.AgingRow, ageDays int, amount float64) {
+	switch {
+	case ageDays <= 30:
+		row.Days0To30 += amount
+	case ageDays <= 60:
+		row.Days31To60 += amount
+	case ageDays <= 90:
+		row.Days61To90 += amount
+	default:
+		row.Over90 += amount
+	}
+	row.Total += amount
+}
+
+func roundAging(row *models.AgingRow) {
+	row.Days0To30, row.Days31To60 = gst.Round(row.Days0To30), gst.Round(row.Days31To60)
+	row.Days61To90, row.Over90, row.Total = gst.Round(row.Days61To90), gst.Round(row.Over90), gst.Round(row.Total)
+}
+
+// customerKey compares customer names ignoring case, punctuation and legal
+// forms, as deposit accounts are matched
+func customerKey(name string) string {
+	return repository.CustomerKey(name)
+}
+
+func sameCustomer(a, b string) bool {
+	return customerKey(a) == customerKey(b)
+}
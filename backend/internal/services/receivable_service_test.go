@@ -0,0 +1,137 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"maydiv-crm/internal/config"
+	"maydiv-crm/internal/models"
+)
+
+func TestReceivableAge(t *testing.T) {
+	billDate := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	fifteen := 15
+	s := NewReceivableService(nil, nil, config.BillingConfig{CreditDays: 30})
+
+	tests := []struct {
+		name        string
+		bill        models.Receivable
+		asOf        time.Time
+		outstanding float64
+		status      string
+		due         time.Time
+		age         int
+		overdue     int
+	}{
+		{
+			name: "unpaid within credit", bill: models.Receivable{BillAmount: 1180},
+			asOf: time.Date(2026, 2, 20, 18, 30, 0, 0, time.UTC), outstanding: 1180, status: models.BillUnpaid,
+			due: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), age: 20,
+		},
+		{
+			name: "partly paid and overdue", bill: models.Receivable{BillAmount: 1180, Received: 500, TDS: 20.01},
+			asOf: time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), outstanding: 659.99, status: models.BillPartlyPaid,
+			due: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), age: 40, overdue: 10,
+		},
+		{
+			name: "settled with an advance", bill: models.Receivable{BillAmount: 1180, Received: 1000, AdvanceAdjusted: 180},
+			asOf: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), status: models.BillSettled,
+			due: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), age: 121,
+		},
+		{
+			name: "overpaid", bill: models.Receivable{BillAmount: 1180, Received: 1200},
+			asOf: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), status: models.BillSettled,
+			due: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), age: 1,
+		},
+		{
+			name: "party's credit days", bill: models.Receivable{BillAmount: 1180, CreditDays: &fifteen},
+			asOf: time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC), outstanding: 1180, status: models.BillUnpaid,
+			due: time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), age: 20, overdue: 5,
+		},
+		{
+			name: "aged before its date", bill: models.Receivable{BillAmount: 1180},
+			asOf: time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC), outstanding: 1180, status: models.BillUnpaid,
+			due: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.bill
+			b.BillDate = billDate
+			s.age(&b, tt.asOf)
+			if b.Outstanding != tt.outstanding || b.Status != tt.status {
+				t.Errorf("outstanding %v %s, want %v %s", b.Outstanding, b.Status, tt.outstanding, tt.status)
+			}
+			if !b.DueDate.Equal(tt.due) || b.AgeDays != tt.age || b.DaysOverdue != tt.overdue {
+				t.Errorf("due %s, %d days old, %d overdue, want due %s, %d days old, %d overdue",
+					b.DueDate.Format(time.DateOnly), b.AgeDays, b.DaysOverdue, tt.due.Format(time.DateOnly), tt.age, tt.overdue)
+			}
+		})
+	}
+}
+
+func TestReceivableAgeDropsBadEmail(t *testing.T) {
+	s := NewReceivableService(nil, nil, config.BillingConfig{})
+	for email, keep := range map[string]bool{"accounts@acme.in": true, "n/a": false} {
+		b := models.Receivable{BillAmount: 100, Email: &email}
+		s.age(&b, time.Now())
+		if (b.Email != nil) != keep {
+			t.Errorf("email %q kept %v, want %v", email, b.Email != nil, keep)
+		}
+	}
+}
+
+func TestDaysBetween(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		from, to time.Time
+		want     int
+	}{
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), 30},
+		{time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC), time.Date(2026, 1, 2, 0, 1, 0, 0, time.UTC), 1},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, ist), time.Date(2026, 3, 1, 23, 0, 0, 0, ist), 0},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), -28},
+	}
+	for _, tt := range tests {
+		if got := daysBetween(tt.from, tt.to); got != tt.want {
+			t.Errorf("daysBetween(%v, %v) = %d, want %d", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAddAging(t *testing.T) {
+	var row models.AgingRow
+	for _, b := range []struct {
+		age    int
+		amount float64
+	}{
+		{0, 100.001}, {30, 100}, {31, 200}, {60, 200}, {61, 300}, {90, 300}, {91, 400}, {400, 400},
+	} {
+		addAging(&row, b.age, b.amount)
+	}
+	roundAging(&row)
+	want := models.AgingRow{Days0To30: 200, Days31To60: 400, Days61To90: 600, Over90: 800, Total: 2000}
+	if row != want {
+		t.Errorf("aging = %+v, want %+v", row, want)
+	}
+}
+
+func TestSameCustomer(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Acme Pvt. Ltd.", "ACME PRIVATE LIMITED", true},
+		{"M/s Acme Traders", "acme traders", true},
+		{" Acme ", "Acme Co", true},
+		{"Acme Traders", "Acme Exports", false},
+		// Names of legal forms alone still compare by themselves
+		{"Pvt Ltd", "pvt ltd", true},
+		{"Pvt Ltd", "Limited", false},
+	}
+	for _, tt := range tests {
+		if got := sameCustomer(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameCustomer(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
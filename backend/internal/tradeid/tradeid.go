@@ -0,0 +1,182 @@
+// Package tradeid validates Indian trade identifiers: GSTIN, PAN, IEC, HSN
+// and SAC codes. Validators take normalized input; use Normalize first.
+package tradeid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// base36 is the GSTIN checksum alphabet; a character's value is its index
+const base36 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// panEntityTypes are the holder type codes allowed as the fourth PAN character
+const panEntityTypes = "ABCEFGHJLPT"
+
+// States maps GSTIN state codes to state and union territory names
+var States = map[string]string{
+	"01": "Jammu and Kashmir", "02": "Himachal Pradesh", "03": "Punjab",
+	"04": "Chandigarh", "05": "Uttarakhand", "06": "Haryana", "07": "Delhi",
+	"08": "Rajasthan", "09": "Uttar Pradesh", "10": "Bihar", "11": "Sikkim",
+	"12": "Arunachal Pradesh", "13": "Nagaland", "14": "Manipur", "15": "Mizoram",
+	"16": "Tripura", "17": "Meghalaya", "18": "Assam", "19": "West Bengal",
+	"20": "Jharkhand", "21": "Odisha", "22": "Chhattisgarh", "23": "Madhya Pradesh",
+	"24": "Gujarat", "26": "Dadra and Nagar Haveli and Daman and Diu",
+	"27": "Maharashtra", "29": "Karnataka", "30": "Goa", "31": "Lakshadweep",
+	"32": "Kerala", "33": "Tamil Nadu", "34": "Puducherry",
+	"35": "Andaman and Nicobar Islands", "36": "Telangana", "37": "Andhra Pradesh",
+	"38": "Ladakh", "97": "Other Territory", "99": "Centre Jurisdiction",
+}
+
+// Normalize uppercases s and removes spaces, dashes and dots
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		switch r {
+		case ' ', '-', '.', '\t':
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ValidatePAN checks a 10 character Permanent Account Number: five letters,
+// the fourth naming the holder type, four digits and a letter
+func ValidatePAN(pan string) error {
+	if len(pan) != 10 {
+		return fmt.Errorf("must be 10 characters, got %d", len(pan))
+	}
+	if !allLetters(pan[:5]) || !allDigits(pan[5:9]) || !allLetters(pan[9:]) {
+		return errors.New("must be five letters, four digits and a letter")
+	}
+	if !strings.ContainsRune(panEntityTypes, rune(pan[3])) {
+		return fmt.Errorf("holder type %c is not valid", pan[3])
+	}
+	return nil
+}
+
+// ValidateGSTIN checks a 15 character GST identification number: state
+// code, the holder's PAN, entity number, the letter Z and a checksum
+func ValidateGSTIN(gstin string) error {
+	if len(gstin) != 15 {
+		return fmt.Errorf("must be 15 characters, got %d", len(gstin))
+	}
+	for i := 0; i < len(gstin); i++ {
+		if strings.IndexByte(base36, gstin[i]) < 0 {
+			return errors.New("may only contain letters and digits")
+		}
+	}
+	if _, ok := States[gstin[:2]]; !ok {
+		return fmt.Errorf("state code %s is not valid", gstin[:2])
+	}
+	if err := ValidatePAN(gstin[2:12]); err != nil {
+		return fmt.Errorf("embedded PAN %w", err)
+	}
+	if gstin[12] == '0' {
+		return errors.New("entity number must be 1-9 or A-Z")
+	}
+	if gstin[13] != 'Z' {
+		return errors.New("14th character must be Z")
+	}
+	if want := GSTINChecksum(gstin[:14]); gstin[14] != want {
+		return fmt.Errorf("checksum %c does not match, expected %c", gstin[14], want)
+	}
+	return nil
+}
+
+// GSTINChecksum computes the check character for the first 14 characters
+// of a GSTIN: a Luhn mod 36 over alternating weights 1 and 2
+func GSTINChecksum(prefix string) byte {
+	sum := 0
+	for i := 0; i < len(prefix); i++ {
+		product := strings.IndexByte(base36, prefix[i]) * (i%2 + 1)
+		sum += product/36 + product%36
+	}
+	return base36[(36-sum%36)%36]
+}
+
+// GSTINState returns the state name encoded in a valid GSTIN
+func GSTINState(gstin string) string {
+	if len(gstin) < 2 {
+		return ""
+	}
+	return States[gstin[:2]]
+}
+
+// GSTINPAN returns the PAN embedded in a GSTIN
+func GSTINPAN(gstin string) string {
+	if len(gstin) < 12 {
+		return ""
+	}
+	return gstin[2:12]
+}
+
+// ValidateIEC checks an Importer-Exporter Code. IECs issued since 2018 are
+// the holder's PAN; older ones are 10 digits.
+func ValidateIEC(iec string) error {
+	if len(iec) != 10 {
+		return fmt.Errorf("must be 10 characters, got %d", len(iec))
+	}
+	if allDigits(iec) {
+		return nil
+	}
+	if err := ValidatePAN(iec); err != nil {
+		return errors.New("must be 10 digits or a valid PAN")
+	}
+	return nil
+}
+
+// ValidateHSN checks an HSN code of 4 (heading), 6 (subheading) or 8
+// (Indian tariff item) digits whose chapter exists
+func ValidateHSN(hsn string) error {
+	switch len(hsn) {
+	case 4, 6, 8:
+	default:
+		return fmt.Errorf("must be 4, 6 or 8 digits, got %d characters", len(hsn))
+	}
+	if !allDigits(hsn) {
+		return errors.New("must contain only digits")
+	}
+	// Chapter 77 is reserved and 99 is services (SAC), not goods
+	if chapter := hsn[:2]; chapter == "00" || chapter == "77" || chapter == "99" {
+		return fmt.Errorf("chapter %s is not a goods chapter", chapter)
+	}
+	return nil
+}
+
+// ValidateSAC checks a services accounting code: 6 digits in chapter 99,
+// or a 4 digit heading
+func ValidateSAC(sac string) error {
+	switch len(sac) {
+	case 4, 6:
+	default:
+		return fmt.Errorf("must be 4 or 6 digits, got %d characters", len(sac))
+	}
+	if !allDigits(sac) {
+		return errors.New("must contain only digits")
+	}
+	if sac[:2] != "99" {
+		return errors.New("must start with 99")
+	}
+	return nil
+}
+
+func allLetters(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package tradeid
+
+import "testing"
+
+func TestValidateGSTIN(t *testing.T) {
+	tests := []struct {
+		gstin string
+		err   string
+	}{
+		{"27AAPFU0939F1ZV", ""},
+		{"29AAGCB7383J1Z4", ""},
+		{"07AAACR5055K1Z9", ""},
+		{"27AAPFU0939F1ZW", "checksum W does not match, expected V"},
+		{"27AAPFU0939F1Z", "must be 15 characters, got 14"},
+		{"27AAPFU0939F1Z-", "may only contain letters and digits"},
+		{"00AAPFU0939F1ZV", "state code 00 is not valid"},
+		// Daman and Diu's 25 was merged into 26
+		{"25AAPFU0939F1ZV", "state code 25 is not valid"},
+		{"39AAPFU0939F1ZV", "state code 39 is not valid"},
+		{"27AAPDU0939F1ZV", "embedded PAN holder type D is not valid"},
+		{"27AAPFU0939F0ZV", "entity number must be 1-9 or A-Z"},
+		{"27AAPFU0939F1YV", "14th character must be Z"},
+	}
+	for _, tt := range tests {
+		if got := errString(ValidateGSTIN(tt.gstin)); got != tt.err {
+			t.Errorf("ValidateGSTIN(%q) = %q, want %q", tt.gstin, got, tt.err)
+		}
+	}
+}
+
+func TestGSTINParts(t *testing.T) {
+	if got := GSTINState("27AAPFU0939F1ZV"); got != "Maharashtra" {
+		t.Errorf("GSTINState = %q, want Maharashtra", got)
+	}
+	if got := GSTINPAN("27AAPFU0939F1ZV"); got != "AAPFU0939F" {
+		t.Errorf("GSTINPAN = %q, want AAPFU0939F", got)
+	}
+	if GSTINState("2") != "" || GSTINPAN("27AAP") != "" {
+		t.Error("GSTINState and GSTINPAN of a short GSTIN should be empty")
+	}
+}
+
+func TestValidatePAN(t *testing.T) {
+	tests := []struct {
+		pan string
+		err string
+	}{
+		{"AAPFU0939F", ""},
+		{"ABCPE1234F", ""},
+		{"AAATA1234B", ""},
+		{"AAPFU0939", "must be 10 characters, got 9"},
+		{"AAPF10939F", "must be five letters, four digits and a letter"},
+		{"AAPFU0939Z9", "must be 10 characters, got 11"},
+		{"AAPFU09391", "must be five letters, four digits and a letter"},
+		{"AAPDU0939F", "holder type D is not valid"},
+		{"AAPKU0939F", "holder type K is not valid"},
+	}
+	for _, tt := range tests {
+		if got := errString(ValidatePAN(tt.pan)); got != tt.err {
+			t.Errorf("ValidatePAN(%q) = %q, want %q", tt.pan, got, tt.err)
+		}
+	}
+}
+
+func TestValidateIEC(t *testing.T) {
+	tests := []struct {
+		iec string
+		err string
+	}{
+		{"0305012345", ""},
+		{"AAPFU0939F", ""},
+		{"030501234", "must be 10 characters, got 9"},
+		{"AAPDU0939F", "must be 10 digits or a valid PAN"},
+		{"03050X2345", "must be 10 digits or a valid PAN"},
+	}
+	for _, tt := range tests {
+		if got := errString(ValidateIEC(tt.iec)); got != tt.err {
+			t.Errorf("ValidateIEC(%q) = %q, want %q", tt.iec, got, tt.err)
+		}
+	}
+}
+
+func TestValidateHSN(t *testing.T) {
+	tests := []struct {
+		hsn string
+		err string
+	}{
+		{"8471", ""},
+		{"847130", ""},
+		{"84713010", ""},
+		{"84713", "must be 4, 6 or 8 digits, got 5 characters"},
+		{"8471301", "must be 4, 6 or 8 digits, got 7 characters"},
+		{"84A1", "must contain only digits"},
+		{"0012", "chapter 00 is not a goods chapter"},
+		{"7710", "chapter 77 is not a goods chapter"},
+		{"996713", "chapter 99 is not a goods chapter"},
+	}
+	for _, tt := range tests {
+		if got := errString(ValidateHSN(tt.hsn)); got != tt.err {
+			t.Errorf("ValidateHSN(%q) = %q, want %q", tt.hsn, got, tt.err)
+		}
+	}
+}
+
+func TestValidateSAC(t *testing.T) {
+	tests := []struct {
+		sac string
+		err string
+	}{
+		{"996713", ""},
+		{"9967", ""},
+		{"99671", "must be 4 or 6 digits, got 5 characters"},
+		{"99671300", "must be 4 or 6 digits, got 8 characters"},
+		{"99A713", "must contain only digits"},
+		{"847130", "must start with 99"},
+	}
+	for _, tt := range tests {
+		if got := errString(ValidateSAC(tt.sac)); got != tt.err {
+			t.Errorf("ValidateSAC(%q) = %q, want %q", tt.sac, got, tt.err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize(" 27aapfu-0939.f1zv\t"); got != "27AAPFU0939F1ZV" {
+		t.Errorf("Normalize = %q, want 27AAPFU0939F1ZV", got)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,64 @@
+package validation_test
+
+import (
+	"fmt"
+	"testing"
+
+	"maydiv-crm/internal/models"
+	"maydiv-crm/internal/validation"
+)
+
+// requestModels are the request bodies handlers validate. A model with
+// validate tags belongs here, so that a mistyped tag fails this test
+// rather than panicking a handler.
+var requestModels = []interface{}{
+	models.AdvanceRequest{},
+	models.BillItemRequest{},
+	models.BillNoteRequest{},
+	models.BillNoteReview{},
+	models.BillingParty{},
+	models.ContainerEventRequest{},
+	models.ContainerRequest{},
+	models.DemurrageSlabRequest{},
+	models.DemurrageTariffRequest{},
+	models.DepositAccountRequest{},
+	models.DepositMovementRequest{},
+	models.DutyCalculationRequest{},
+	models.DutyItemRequest{},
+	models.ExchangeRateRequest{},
+	models.FileUploadRequest{},
+	models.FilingRuleRequest{},
+	models.IGMDraftsRequest{},
+	models.InvitationAcceptRequest{},
+	models.InvoiceTemplate{},
+	models.JobFilingUpdateRequest{},
+	models.LedgerEntryRequest{},
+	models.MasterRequest{},
+	models.OrgInvitationRequest{},
+	models.OrgMemberRequest{},
+	models.PartyContactRequest{},
+	models.PartyRequest{},
+	models.PaymentRequest{},
+	models.Stage1CreateRequest{},
+	models.Stage2UpdateRequest{},
+	models.Stage3ContainerRequest{},
+	models.Stage3UpdateRequest{},
+	models.Stage4UpdateRequest{},
+	models.TaskCreate{},
+	models.TaskUpdateCreate{},
+	models.UserCreate{},
+	models.UserLogin{},
+}
+
+func TestRequestModelTags(t *testing.T) {
+	for _, m := range requestModels {
+		name := fmt.Sprintf("%T", m)
+		t.Run(name, func(t *testing.T) {
+			if err := validation.CheckTags(m); err != nil {
+				t.Fatal(err)
+			}
+			// An empty body must be answered with field errors, not a panic
+			_ = validation.Struct(m)
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Package validation checks request structs against their `validate` tags.
+//
+// Supported rules, comma separated:
+//
+//	required        value must not be the zero value
+//	omitempty       skip the remaining rules when the value is empty
+//	oneof=a b 'c d' value must be one of the listed words; quote words with spaces
+//	email           a single plain email address
+//	date            YYYY-MM-DD
+//	datetime        YYYY-MM-DDTHH:MM:SS or YYYY-MM-DD
+//	container       ISO 6346 container number, with suggested corrections
+//	gstin, pan, iec Indian tax and trade identifiers
+//	hsn             HSN code of 4, 6 or 8 digits
+//	sac             services accounting code of 4 or 6 digits
+//	min=n, max=n    bounds for numbers, length bounds for strings and slices
+//
+// Nested structs and slices of structs are always checked; their errors are
+// reported as "containers[1].size". A tag Struct cannot apply panics when
+// it is reached; CheckTags finds such tags up front, for tests.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"maydiv-crm/internal/iso6346"
+	"maydiv-crm/internal/tradeid"
+)
+
+// Date layouts accepted by the date and datetime rules
+const (
+	DateLayout     = "2006-01-02"
+	DateTimeLayout = "2006-01-02T15:04:05"
+)
+
+// FieldError describes a problem with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is every field error found in a request
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + " " + fe.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// Add records a field error. It lets callers append checks that tags
+// cannot express, e.g. ones that need the database.
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Err returns e as an error, or nil when there are no field errors
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Struct validates v, which must be a struct or a pointer to one, and
+// returns Errors listing every failed rule, or nil
+func Struct(v interface{}) error {
+	var errs Errors
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: Struct called with %T", v))
+	}
+	validateStruct(rv, "", &errs)
+	return errs.Err()
+}
+
+// CheckTags reports the first validate tag on v's type, or on the structs
+// it holds, that Struct cannot apply: an unknown rule, a oneof without
+// options or a min or max that is not a number or not on a sized value
+func CheckTags(v interface{}) error {
+	rt := reflect.TypeOf(v)
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: CheckTags called with %T", v)
+	}
+	return checkTypeTags(rt, map[reflect.Type]bool{})
+}
+
+func checkTypeTags(rt reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[rt] {
+		return nil
+	}
+	seen[rt] = true
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		ft := sf.Type
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			if err := checkFieldTag(ft, tag); err != nil {
+				return fmt.Errorf("validation: %s.%s: %w", rt.Name(), sf.Name, err)
+			}
+		}
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			if err := checkTypeTags(ft, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkFieldTag(ft reflect.Type, tag string) error {
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "omitempty", "required", "email", "date", "datetime", "container", "gstin", "pan", "iec", "hsn", "sac":
+		case "oneof":
+			if len(splitOptions(param)) == 0 {
+				return fmt.Errorf("oneof without options")
+			}
+		case "min", "max":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return fmt.Errorf("bad %s parameter %q", name, param)
+			}
+			switch ft.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64, reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			default:
+				return fmt.Errorf("%s on %s", name, ft.Kind())
+			}
+		default:
+			return fmt.Errorf("unknown rule %q", name)
+		}
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs *Errors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+
+		name := fieldName(sf)
+		if sf.Anonymous && fv.Kind() == reflect.Struct {
+			validateStruct(fv, prefix, errs)
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			validateField(fv, path, tag, errs)
+		}
+		validateNested(fv, path, errs)
+	}
+}
+
+// validateNested descends into struct, pointer-to-struct and slice fields
+func validateNested(fv reflect.Value, path string, errs *Errors) {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if !fv.IsNil() && fv.Elem().Kind() == reflect.Struct {
+			validateStruct(fv.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		if fv.Type() != reflect.TypeOf(time.Time{}) {
+			validateStruct(fv, path, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			elem := reflect.Indirect(fv.Index(i))
+			if elem.Kind() == reflect.Struct {
+				validateStruct(elem, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+func validateField(fv reflect.Value, path, tag string, errs *Errors) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			if hasRule(tag, "required") {
+				errs.Add(path, "is required")
+			}
+			return
+		}
+		fv = fv.Elem()
+	}
+
+	empty := fv.IsZero()
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "omitempty":
+			if empty {
+				return
+			}
+		case "required":
+			if empty {
+				errs.Add(path, "is required")
+				return
+			}
+		default:
+			if msg := check(fv, name, param); msg != "" {
+				errs.Add(path, msg)
+				return
+			}
+		}
+	}
+}
+
+// tradeIDValidators maps rule names to the tradeid check they run
+var tradeIDValidators = map[string]func(string) error{
+	"gstin": tradeid.ValidateGSTIN,
+	"pan":   tradeid.ValidatePAN,
+	"iec":   tradeid.ValidateIEC,
+	"hsn":   tradeid.ValidateHSN,
+	"sac":   tradeid.ValidateSAC,
+}
+
+// check applies one rule and returns the failure message, or ""
+func check(fv reflect.Value, rule, param string) string {
+	switch rule {
+	case "oneof":
+		options := splitOptions(param)
+		value := fmt.Sprint(fv.Interface())
+		for _, o := range options {
+			if value == o {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", ")
+	case "email":
+		if !IsEmail(fv.String()) {
+			return "must be a valid email address"
+		}
+	case "date":
+		if _, err := time.Parse(DateLayout, fv.String()); err != nil {
+			return "must be a date in YYYY-MM-DD format"
+		}
+	case "datetime":
+		s := fv.String()
+		if _, err := time.Parse(DateTimeLayout, s); err != nil {
+			if _, err := time.Parse(DateLayout, s); err != nil {
+				return "must be a date in YYYY-MM-DD or YYYY-MM-DDTHH:MM:SS format"
+			}
+		}
+	case "container":
+		if err := iso6346.Validate(fv.String()); err != nil {
+			return err.Error()
+		}
+	case "gstin", "pan", "iec", "hsn", "sac":
+		if err := tradeIDValidators[rule](tradeid.Normalize(fv.String())); err != nil {
+			return err.Error()
+		}
+	case "min", "max":
+		return checkBound(fv, rule, param)
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", rule))
+	}
+	return ""
+}
+
+func checkBound(fv reflect.Value, rule, param string) string {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validation: bad %s parameter %q", rule, param))
+	}
+
+	var n float64
+	var unit string
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(fv.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = fv.Float()
+	case reflect.String:
+		n, unit = float64(len([]rune(fv.String()))), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, unit = float64(fv.Len()), " items"
+	default:
+		panic(fmt.Sprintf("validation: %s on %s", rule, fv.Kind()))
+	}
+
+	if rule == "min" && n < limit {
+		return fmt.Sprintf("must be at least %s%s", param, unit)
+	}
+	if rule == "max" && n > limit {
+		return fmt.Sprintf("must be at most %s%s", param, unit)
+	}
+	return ""
+}
+
+// IsEmail reports whether s is a single bare address such as a@b.com
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+}
+
+// splitOptions splits a oneof parameter on spaces, keeping 'quoted words' together
+func splitOptions(param string) []string {
+	var options []string
+	for param = strings.TrimSpace(param); param != ""; param = strings.TrimSpace(param) {
+		if param[0] == '\'' {
+			if end := strings.IndexByte(param[1:], '\''); end >= 0 {
+				options = append(options, param[1:end+1])
+				param = param[end+2:]
+				continue
+			}
+		}
+		word, rest, _ := strings.Cut(param, " ")
+		options = append(options, word)
+		param = rest
+	}
+	return options
+}
+
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName is the JSON name clients use for the field
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
@@ -0,0 +1,175 @@
+package validation
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type tradeIDs struct {
+	GSTIN string `json:"gstin" validate:"omitempty,gstin"`
+	PAN   string `json:"pan" validate:"omitempty,pan"`
+	IEC   string `json:"iec" validate:"required,iec"`
+	HSN   string `json:"hsn" validate:"omitempty,hsn"`
+	SAC   string `json:"sac" validate:"omitempty,sac"`
+}
+
+func TestTradeIDRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		ids    tradeIDs
+		fields []string
+	}{
+		{name: "valid", ids: tradeIDs{GSTIN: "27AAPFU0939F1ZV", PAN: "AAPFU0939F", IEC: "0305012345", HSN: "84713010", SAC: "996713"}},
+		{name: "normalized before checking", ids: tradeIDs{GSTIN: "27 aapfu 0939 f1zv", PAN: "aapfu-0939-f", IEC: "aapfu0939f", HSN: "8471.30", SAC: "99 67 13"}},
+		{name: "empty optional ids", ids: tradeIDs{IEC: "0305012345"}},
+		{name: "required iec", ids: tradeIDs{}, fields: []string{"iec"}},
+		{name: "bad checksum", ids: tradeIDs{GSTIN: "27AAPFU0939F1ZW", IEC: "0305012345"}, fields: []string{"gstin"}},
+		{
+			name:   "every id wrong",
+			ids:    tradeIDs{GSTIN: "25AAPFU0939F1ZV", PAN: "AAPDU0939F", IEC: "03050X2345", HSN: "996713", SAC: "847130"},
+			fields: []string{"gstin", "pan", "iec", "hsn", "sac"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			var errs Errors
+			if err := Struct(&tt.ids); errors.As(err, &errs) {
+				for _, fe := range errs {
+					fields = append(fields, fe.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Struct returned %T, want Errors", err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Struct failed fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestTradeIDMessage(t *testing.T) {
+	err := Struct(&tradeIDs{GSTIN: "27AAPFU0939F1ZW", IEC: "0305012345"})
+	want := "validation failed: gstin checksum W does not match, expected V"
+	if err == nil || err.Error() != want {
+		t.Errorf("Struct error = %v, want %q", err, want)
+	}
+}
+
+type item struct {
+	Size string `json:"size" validate:"required,oneof=20 40 LCL"`
+	Qty  int    `json:"qty" validate:"min=1,max=10"`
+}
+
+type order struct {
+	Name     string   `json:"name" validate:"required,max=5"`
+	Email    string   `json:"email" validate:"omitempty,email"`
+	Mode     string   `json:"mode" validate:"omitempty,oneof=air sea 'by road'"`
+	Date     string   `json:"date" validate:"omitempty,date"`
+	ETA      string   `json:"eta" validate:"omitempty,datetime"`
+	Rate     *float64 `json:"rate" validate:"omitempty,min=0.5,max=2"`
+	Count    *int     `json:"count" validate:"required,min=1"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Items    []item   `json:"items" validate:"min=1"`
+	Shipment *item    `json:"shipment"`
+	Pickup   item     `json:"pickup"`
+}
+
+func validOrder() order {
+	one := 1
+	return order{Name: "acme", Count: &one, Items: []item{{Size: "20", Qty: 1}}, Pickup: item{Size: "LCL", Qty: 10}}
+}
+
+func TestStructRules(t *testing.T) {
+	zero, half, three := 0, 0.5, 3.0
+	tests := []struct {
+		name   string
+		edit   func(o *order)
+		fields []string
+	}{
+		{name: "valid", edit: func(o *order) {}},
+		{name: "every optional rule passes", edit: func(o *order) {
+			o.Email, o.Mode, o.Date, o.ETA, o.Rate = "ops@acme.in", "by road", "2026-04-01", "2026-04-01T10:30:00", &half
+		}},
+		{name: "datetime takes a date", edit: func(o *order) { o.ETA = "2026-04-01" }},
+		{name: "required string", edit: func(o *order) { o.Name = "" }, fields: []string{"name"}},
+		{name: "required nil pointer", edit: func(o *order) { o.Count = nil }, fields: []string{"count"}},
+		{name: "required pointer to zero", edit: func(o *order) { o.Count = &zero }, fields: []string{"count"}},
+		{name: "string too long", edit: func(o *order) { o.Name = "acme co" }, fields: []string{"name"}},
+		{name: "max counts characters", edit: func(o *order) { o.Name = "ñandú" }},
+		{name: "bad email", edit: func(o *order) { o.Email = "Ops <ops@acme.in>" }, fields: []string{"email"}},
+		{name: "oneof half of a quoted option", edit: func(o *order) { o.Mode = "road" }, fields: []string{"mode"}},
+		{name: "oneof quote marks", edit: func(o *order) { o.Mode = "'by" }, fields: []string{"mode"}},
+		{name: "bad date", edit: func(o *order) { o.Date = "01/04/2026" }, fields: []string{"date"}},
+		{name: "bad datetime", edit: func(o *order) { o.ETA = "2026-04-01 10:30" }, fields: []string{"eta"}},
+		{name: "pointer over max", edit: func(o *order) { o.Rate = &three }, fields: []string{"rate"}},
+		{name: "too many tags", edit: func(o *order) { o.Tags = []string{"a", "b", "c"} }, fields: []string{"tags"}},
+		{name: "too few items", edit: func(o *order) { o.Items = nil }, fields: []string{"items"}},
+		{name: "slice of structs", edit: func(o *order) {
+			o.Items = append(o.Items, item{Size: "45", Qty: 11})
+		}, fields: []string{"items[1].size", "items[1].qty"}},
+		{name: "nested pointer", edit: func(o *order) { o.Shipment = &item{Size: "40"} }, fields: []string{"shipment.qty"}},
+		{name: "nested struct", edit: func(o *order) { o.Pickup.Size = "" }, fields: []string{"pickup.size"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := validOrder()
+			tt.edit(&o)
+			var fields []string
+			var errs Errors
+			if err := Struct(&o); errors.As(err, &errs) {
+				for _, fe := range errs {
+					fields = append(fields, fe.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Struct returned %T, want Errors", err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Struct failed fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	got := splitOptions(" sea  'by road' air 'open")
+	want := []string{"sea", "by road", "air", "'open"}
+	if !slices.Equal(got, want) {
+		t.Errorf("splitOptions = %q, want %q", got, want)
+	}
+}
+
+func TestCheckTags(t *testing.T) {
+	if err := CheckTags(&order{}); err != nil {
+		t.Errorf("CheckTags(order) = %v", err)
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{name: "unknown rule", v: struct {
+			A string `validate:"requried"`
+		}{}},
+		{name: "bad bound", v: struct {
+			A int `validate:"min=one"`
+		}{}},
+		{name: "bound on a bool", v: struct {
+			A bool `validate:"max=1"`
+		}{}},
+		{name: "oneof without options", v: struct {
+			A string `validate:"oneof="`
+		}{}},
+		{name: "in a nested slice", v: struct {
+			A []*struct {
+				B string `validate:"omitempty,emial"`
+			}
+		}{}},
+		{name: "not a struct", v: "order"},
+	}
+	for _, tt := range tests {
+		if err := CheckTags(tt.v); err == nil {
+			t.Errorf("%s: CheckTags found no problem", tt.name)
+		}
+	}
+}